@@ -21,9 +21,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
@@ -35,6 +39,9 @@ import (
 type Client struct {
 	base *url.URL
 	http *http.Client
+
+	// apiKey is sent to servers which require an API key
+	apiKey string
 }
 
 func checkError(resp *http.Response, body []byte) error {
@@ -60,12 +67,34 @@ func checkError(resp *http.Response, body []byte) error {
 //
 //	<scheme>://<host>:<port>
 //
+// or, for a unix socket:
+//
+//	unix://<path>
+//
 // If the variable is not specified, a default ollama host and port will be
-// used.
+// used. If OLLAMA_HOST lists several addresses, the first is used. The key
+// in OLLAMA_API_KEY, if any, is sent with every request.
 func ClientFromEnvironment() (*Client, error) {
+	base, client := envconfig.Host(), http.DefaultClient
+	if base.Scheme == "unix" {
+		// requests to any host are sent over the socket
+		socket := base.Path
+		client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+
+		base = &url.URL{Scheme: "http", Host: "localhost"}
+	}
+
 	return &Client{
-		base: envconfig.Host(),
-		http: http.DefaultClient,
+		base:   base,
+		http:   client,
+		apiKey: envconfig.APIKey(),
 	}, nil
 }
 
@@ -96,7 +125,11 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 		reqBody = bytes.NewReader(data)
 	}
 
+	path, query, _ := strings.Cut(path, "?")
 	requestURL := c.base.JoinPath(path)
+	if query != "" {
+		requestURL.RawQuery = query
+	}
 	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), reqBody)
 	if err != nil {
 		return err
@@ -105,6 +138,9 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	respObj, err := c.http.Do(request)
 	if err != nil {
@@ -151,6 +187,9 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/x-ndjson")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
@@ -173,6 +212,12 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 		}
 
 		if errorResponse.Error != "" {
+			// errors sent before the response started keep their status,
+			// and read like errors sent while streaming it
+			if response.StatusCode >= http.StatusBadRequest {
+				return StatusError{StatusCode: response.StatusCode, ErrorMessage: errorResponse.Error}
+			}
+
 			return errors.New(errorResponse.Error)
 		}
 
@@ -307,6 +352,45 @@ func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	return &lr, nil
 }
 
+// Stats returns the throughput, latency and error rate of the requests each
+// model served recently.
+func (c *Client) Stats(ctx context.Context) (*StatsResponse, error) {
+	var sr StatsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/stats", nil, &sr); err != nil {
+		return nil, err
+	}
+	return &sr, nil
+}
+
+// History returns the requests the server served, the latest first, from
+// its usage history.
+func (c *Client) History(ctx context.Context, req *HistoryRequest) (*HistoryResponse, error) {
+	values := url.Values{}
+	if req.Model != "" {
+		values.Set("model", req.Model)
+	}
+	if !req.Since.IsZero() {
+		values.Set("since", req.Since.Format(time.RFC3339Nano))
+	}
+	if !req.Until.IsZero() {
+		values.Set("until", req.Until.Format(time.RFC3339Nano))
+	}
+	if req.Limit > 0 {
+		values.Set("limit", strconv.Itoa(req.Limit))
+	}
+
+	path := "/api/history"
+	if len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+
+	var hr HistoryResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &hr); err != nil {
+		return nil, err
+	}
+	return &hr, nil
+}
+
 // Copy copies a model - creating a model with another name from an existing
 // model.
 func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
@@ -324,6 +408,16 @@ func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	return nil
 }
 
+// GC removes the blobs no model uses and stale partial downloads from the
+// server's models directories.
+func (c *Client) GC(ctx context.Context, req *GCRequest) (*GCResponse, error) {
+	var resp GCResponse
+	if err := c.do(ctx, http.MethodPost, "/api/gc", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Show obtains model information, including details, modelfile, license etc.
 func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
 	var resp ShowResponse
@@ -351,6 +445,86 @@ func (c *Client) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse,
 	return &resp, nil
 }
 
+// Tokenize splits text into the tokens of a model's tokenizer.
+func (c *Client) Tokenize(ctx context.Context, req *TokenizeRequest) (*TokenizeResponse, error) {
+	var resp TokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Classify returns the label probabilities of a sequence classification
+// model for each input.
+func (c *Client) Classify(ctx context.Context, req *ClassifyRequest) (*ClassifyResponse, error) {
+	var resp ClassifyResponse
+	if err := c.do(ctx, http.MethodPost, "/api/classify", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Rerank scores the relevance of each document to the query with a
+// cross-encoder reranker.
+func (c *Client) Rerank(ctx context.Context, req *RerankRequest) (*RerankResponse, error) {
+	var resp RerankResponse
+	if err := c.do(ctx, http.MethodPost, "/api/rerank", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Speech synthesizes speech for the input with a text-to-speech model and
+// writes the audio to w as it is generated.
+func (c *Client) Speech(ctx context.Context, req *SpeechRequest, w io.Writer) error {
+	return c.copy(ctx, http.MethodPost, "/api/speech", req, w)
+}
+
+// Extract writes the files of a local model, its GGUF weights, adapters,
+// projectors, template, parameters and a Modelfile which creates the model
+// from them, to w as a tar archive.
+func (c *Client) Extract(ctx context.Context, req *ExtractRequest, w io.Writer) error {
+	return c.copy(ctx, http.MethodPost, "/api/extract", req, w)
+}
+
+// copy makes a request whose response isn't JSON and copies its body to w
+func (c *Client) copy(ctx context.Context, method, path string, reqData any, w io.Writer) error {
+	bts, err := json.Marshal(reqData)
+	if err != nil {
+		return err
+	}
+
+	requestURL := c.base.JoinPath(path)
+	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), bytes.NewReader(bts))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return checkError(response, body)
+	}
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
 // Embeddings generates an embedding from a model.
 func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResponse, error) {
 	var resp EmbeddingResponse
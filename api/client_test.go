@@ -1,7 +1,13 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 )
 
 func TestClientFromEnvironment(t *testing.T) {
@@ -43,3 +49,35 @@ func TestClientFromEnvironment(t *testing.T) {
 		})
 	}
 }
+
+func TestClientHistory(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/history" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		query = r.URL.Query()
+		json.NewEncoder(w).Encode(HistoryResponse{Requests: []HistoryEntry{{Model: "llama3:latest", Status: "success"}}})
+	}))
+	defer srv.Close()
+
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	resp, err := NewClient(base, srv.Client()).History(context.Background(), &HistoryRequest{Model: "llama3", Since: since, Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Requests) != 1 || resp.Requests[0].Model != "llama3:latest" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	expect := url.Values{"model": {"llama3"}, "since": {"2024-07-01T12:00:00Z"}, "limit": {"5"}}
+	if query.Encode() != expect.Encode() {
+		t.Fatalf("expected query %s, got %s", expect.Encode(), query.Encode())
+	}
+}
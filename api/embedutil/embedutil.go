@@ -0,0 +1,226 @@
+// Package embedutil has helpers for working with the embeddings returned by
+// [api.Client.Embed]: comparing and normalizing them, finding the nearest of
+// a set of embeddings, and splitting text into chunks which fit a model.
+package embedutil
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Dot returns the dot product of a and b, which must have the same length
+func Dot(a, b []float32) float32 {
+	if len(a) != len(b) {
+		panic("embedutil: vectors have different lengths")
+	}
+
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+
+	return float32(dot)
+}
+
+// Norm returns the length of v
+func Norm(v []float32) float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+
+	return float32(math.Sqrt(sum))
+}
+
+// Cosine returns the cosine similarity of a and b, from -1 to 1, or 0 if
+// either is all zeros. a and b must have the same length.
+func Cosine(a, b []float32) float32 {
+	na, nb := Norm(a), Norm(b)
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return Dot(a, b) / (na * nb)
+}
+
+// Normalize returns v scaled to a length of 1, so the cosine similarity of
+// normalized vectors is their dot product. A vector of zeros is returned
+// unchanged.
+func Normalize(v []float32) []float32 {
+	n := Norm(v)
+	normalized := make([]float32, len(v))
+	for i, x := range v {
+		if n == 0 {
+			normalized[i] = x
+		} else {
+			normalized[i] = x / n
+		}
+	}
+
+	return normalized
+}
+
+// Match is one of the vectors found by [Nearest]
+type Match struct {
+	Index int     // index of the vector
+	Score float32 // cosine similarity to the query
+}
+
+// Nearest returns the k vectors most similar to query by cosine similarity,
+// the most similar first. It returns every vector if there are fewer than k.
+func Nearest(query []float32, vectors [][]float32, k int) []Match {
+	matches := make([]Match, len(vectors))
+	for i, v := range vectors {
+		matches[i] = Match{Index: i, Score: Cosine(query, v)}
+	}
+
+	slices.SortStableFunc(matches, func(a, b Match) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return matches[:min(max(k, 0), len(matches))]
+}
+
+// TokenCounter counts the tokens in text, as a model's tokenizer does
+type TokenCounter func(ctx context.Context, text string) (int, error)
+
+// ModelTokenCounter returns a TokenCounter which counts tokens with model's
+// tokenizer, so chunks for an embedding model can be sized by the model
+// itself. Counts don't include the tokens the model adds to every input, such
+// as a BOS token, so chunks should leave room for them.
+func ModelTokenCounter(client *api.Client, model string) TokenCounter {
+	return func(ctx context.Context, text string) (int, error) {
+		resp, err := client.Tokenize(ctx, &api.TokenizeRequest{Model: model, Content: text})
+		if err != nil {
+			return 0, err
+		}
+
+		return len(resp.Tokens), nil
+	}
+}
+
+// Chunk splits text into chunks of at most size tokens as counted by count,
+// each repeating up to overlap tokens from the end of the chunk before it so
+// context isn't lost at their boundaries. Text is split between words, and
+// words which alone are larger than size are chunks of their own.
+func Chunk(ctx context.Context, text string, size, overlap int, count TokenCounter) ([]string, error) {
+	if size <= 0 {
+		return nil, errors.New("embedutil: chunk size must be positive")
+	}
+
+	if overlap < 0 || overlap >= size {
+		return nil, errors.New("embedutil: chunk overlap must be at least 0 and less than the size")
+	}
+
+	words := splitWords(text)
+
+	// fits reports whether words[start:end] fit in n tokens
+	fits := func(start, end, n int) (bool, error) {
+		tokens, err := count(ctx, strings.TrimSpace(strings.Join(words[start:end], "")))
+		return tokens <= n, err
+	}
+
+	// longest returns the most words, between lo and hi, which fit in n
+	// tokens, where span gives the words of a count. Spans grow from lo
+	// until one doesn't fit before they're narrowed down, so no span much
+	// longer than n tokens is counted however long the text is.
+	longest := func(lo, hi, n int, span func(k int) (int, int)) (int, error) {
+		for step := 1; lo < hi; step *= 2 {
+			k := min(lo+step, hi)
+			s, e := span(k)
+			ok, err := fits(s, e, n)
+			if err != nil {
+				return 0, err
+			}
+
+			if !ok {
+				hi = k - 1
+				break
+			}
+
+			lo = k
+		}
+
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			s, e := span(mid)
+			ok, err := fits(s, e, n)
+			if err != nil {
+				return 0, err
+			}
+
+			if ok {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+
+		return lo, nil
+	}
+
+	var chunks []string
+	for start := 0; start < len(words); {
+		n, err := longest(1, len(words)-start, size, func(k int) (int, int) { return start, start + k })
+		if err != nil {
+			return nil, err
+		}
+
+		end := start + n
+		chunks = append(chunks, strings.TrimSpace(strings.Join(words[start:end], "")))
+		if end == len(words) {
+			break
+		}
+
+		// the next chunk starts with the most words from the end of this
+		// one which fit in overlap, but always makes progress
+		o := 0
+		if overlap > 0 && n > 1 {
+			o, err = longest(0, n-1, overlap, func(k int) (int, int) { return end - k, end })
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		start = end - o
+	}
+
+	return chunks, nil
+}
+
+// splitWords splits text into words, each with the whitespace after it, so
+// joining them gives back text without its leading whitespace
+func splitWords(text string) []string {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+
+	var words []string
+	start := 0
+	inSpace := false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if !space && inSpace {
+			words = append(words, text[start:i])
+			start = i
+		}
+		inSpace = space
+	}
+
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+
+	return words
+}
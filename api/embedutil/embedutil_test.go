@@ -0,0 +1,140 @@
+package embedutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestCosine(t *testing.T) {
+	assert.InDelta(t, 1, Cosine([]float32{1, 2, 3}, []float32{2, 4, 6}), 1e-6)
+	assert.InDelta(t, 0, Cosine([]float32{1, 0}, []float32{0, 1}), 1e-6)
+	assert.InDelta(t, -1, Cosine([]float32{1, 1}, []float32{-1, -1}), 1e-6)
+	assert.Zero(t, Cosine([]float32{0, 0}, []float32{1, 1}))
+	assert.Panics(t, func() { Cosine([]float32{1}, []float32{1, 2}) })
+}
+
+func TestNormalize(t *testing.T) {
+	v := []float32{3, 4}
+	assert.InDeltaSlice(t, []float32{0.6, 0.8}, Normalize(v), 1e-6)
+	assert.Equal(t, []float32{3, 4}, v)
+	assert.Equal(t, []float32{0, 0}, Normalize([]float32{0, 0}))
+}
+
+func TestNearest(t *testing.T) {
+	vectors := [][]float32{{0, 1}, {1, 0}, {1, 1}, {-1, 0}}
+
+	matches := Nearest([]float32{1, 0.1}, vectors, 2)
+	require.Len(t, matches, 2)
+	assert.Equal(t, 1, matches[0].Index)
+	assert.Equal(t, 2, matches[1].Index)
+	assert.Greater(t, matches[0].Score, matches[1].Score)
+
+	assert.Len(t, Nearest([]float32{1, 0}, vectors, 10), 4)
+	assert.Empty(t, Nearest([]float32{1, 0}, vectors, 0))
+}
+
+// words counts a token for every word
+func words(_ context.Context, text string) (int, error) {
+	return len(strings.Fields(text)), nil
+}
+
+func TestChunk(t *testing.T) {
+	cases := []struct {
+		name          string
+		text          string
+		size, overlap int
+		want          []string
+	}{
+		{"empty", "  ", 3, 0, nil},
+		{"fits", "one two", 3, 1, []string{"one two"}},
+		{"split", "one two three four five", 2, 0, []string{"one two", "three four", "five"}},
+		{"overlap", "one two three four five", 3, 1, []string{"one two three", "three four five"}},
+		{"whitespace", " one\ntwo\n\nthree  four", 3, 0, []string{"one\ntwo\n\nthree", "four"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := Chunk(context.Background(), tt.text, tt.size, tt.overlap, words)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, chunks)
+		})
+	}
+
+	t.Run("large words", func(t *testing.T) {
+		// a token for every 4 characters
+		count := func(_ context.Context, text string) (int, error) {
+			return (len(text) + 3) / 4, nil
+		}
+
+		chunks, err := Chunk(context.Background(), "a supercalifragilistic b", 2, 0, count)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "supercalifragilistic", "b"}, chunks)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := Chunk(context.Background(), "one", 0, 0, words)
+		require.Error(t, err)
+		_, err = Chunk(context.Background(), "one", 2, 2, words)
+		require.Error(t, err)
+	})
+
+	t.Run("long text", func(t *testing.T) {
+		// like a model, the counter fails on text much longer than a chunk
+		count := func(ctx context.Context, text string) (int, error) {
+			n, _ := words(ctx, text)
+			if n > 8 {
+				return 0, errors.New("input is too long")
+			}
+
+			return n, nil
+		}
+
+		text := strings.Repeat("word ", 100)
+		chunks, err := Chunk(context.Background(), text, 4, 1, count)
+		require.NoError(t, err)
+		assert.Len(t, chunks, 33)
+		for _, chunk := range chunks {
+			assert.Equal(t, "word word word word", chunk)
+		}
+	})
+
+	t.Run("count error", func(t *testing.T) {
+		_, err := Chunk(context.Background(), "one two three", 1, 0, func(context.Context, string) (int, error) {
+			return 0, errors.New("model not found")
+		})
+		require.ErrorContains(t, err, "model not found")
+	})
+}
+
+func TestModelTokenCounter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/tokenize", r.URL.Path)
+
+		var req api.TokenizeRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "all-minilm", req.Model)
+
+		tokens := make([]int, len(strings.Fields(req.Content)))
+		require.NoError(t, json.NewEncoder(w).Encode(api.TokenizeResponse{Model: req.Model, Tokens: tokens}))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	count := ModelTokenCounter(api.NewClient(u, srv.Client()), "all-minilm")
+	n, err := count(context.Background(), "one two three")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
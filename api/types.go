@@ -66,13 +66,24 @@ type GenerateRequest struct {
 	// Raw set to true means that no formatting will be applied to the prompt.
 	Raw bool `json:"raw,omitempty"`
 
-	// Format specifies the format to return a response in.
+	// Format specifies the format to return a response in, either "json" or
+	// the text of a JSON Schema the response must conform to.
 	Format string `json:"format"`
 
 	// KeepAlive controls how long the model will stay loaded in memory following
 	// this request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// QueueTimeout is the longest the request will wait in the queue for the
+	// model to be scheduled and for a free slot on it. If it expires the
+	// server responds with 503.
+	QueueTimeout *Duration `json:"queue_timeout,omitempty"`
+
+	// Adapter is the name of a model created from the same base model with
+	// an ADAPTER. Its adapter is applied to this request without loading
+	// another copy of the base model.
+	Adapter string `json:"adapter,omitempty"`
+
 	// Images is an optional list of base64-encoded images accompanying this
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
@@ -93,13 +104,22 @@ type ChatRequest struct {
 	// Stream enable streaming of returned response; true by default.
 	Stream *bool `json:"stream,omitempty"`
 
-	// Format is the format to return the response in (e.g. "json").
+	// Format is the format to return the response in, either "json" or the
+	// text of a JSON Schema the response must conform to.
 	Format string `json:"format"`
 
 	// KeepAlive controls how long the model will stay loaded into memory
 	// followin the request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// QueueTimeout is the longest the request will wait to be scheduled, as
+	// in [GenerateRequest].
+	QueueTimeout *Duration `json:"queue_timeout,omitempty"`
+
+	// Adapter is the name of a model whose adapter is applied to the
+	// request, as in [GenerateRequest].
+	Adapter string `json:"adapter,omitempty"`
+
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
@@ -191,6 +211,9 @@ type ChatResponse struct {
 
 	Done bool `json:"done"`
 
+	// RequestID is the ID of the request, set on the final response.
+	RequestID string `json:"request_id,omitempty"`
+
 	Metrics
 }
 
@@ -199,8 +222,14 @@ type Metrics struct {
 	LoadDuration       time.Duration `json:"load_duration,omitempty"`
 	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+	PromptCacheCount   int           `json:"prompt_cache_count,omitempty"`
+	DiscardedCount     int           `json:"discarded_count,omitempty"`
 	EvalCount          int           `json:"eval_count,omitempty"`
 	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+
+	// ImageTokens is the number of prompt tokens each image of the request
+	// took, in the order the images were sent.
+	ImageTokens []int `json:"image_tokens,omitempty"`
 }
 
 // Options specified in [GenerateRequest], if you add a new option here add it
@@ -227,6 +256,39 @@ type Options struct {
 	MirostatEta      float32  `json:"mirostat_eta,omitempty"`
 	PenalizeNewline  bool     `json:"penalize_newline,omitempty"`
 	Stop             []string `json:"stop,omitempty"`
+
+	// ContextOverflow is the policy used when the input or generated text
+	// exceeds the context window: "error", "truncate", "shift" (default) or
+	// "middle".
+	ContextOverflow string `json:"context_overflow,omitempty"`
+
+	// AdapterScale is the scaling factor of each LoRA adapter in the order
+	// the adapters are declared. Adapters without one are applied at 1.0.
+	AdapterScale []float32 `json:"adapter_scale,omitempty"`
+
+	// ImageResize is how images are fit to ImageMaxResolution: "fit"
+	// (default) keeps their aspect ratio, "crop" and "pad" first make them
+	// square and "none" passes them to the model unchanged.
+	ImageResize string `json:"image_resize,omitempty"`
+
+	// ImageMaxResolution is the longest side, in pixels, of images passed to
+	// the model. Larger images are scaled down. 0 is no limit.
+	ImageMaxResolution int `json:"image_max_resolution,omitempty"`
+
+	// Sticky keeps the model loaded when another model needs its memory,
+	// unless every model that could make room is sticky too. It's only
+	// honored in a Modelfile, not in the options of a request.
+	Sticky bool `json:"sticky,omitempty"`
+
+	// Warmup generates a token as soon as the model is loaded, before it
+	// serves requests, so the first request isn't slowed by compiling
+	// kernels and filling caches.
+	Warmup bool `json:"warmup,omitempty"`
+
+	// BestOf generates this many candidate responses by sampling and
+	// responds with the one whose tokens are the most likely on average.
+	// Responses are streamed whole once every candidate is generated.
+	BestOf int `json:"best_of,omitempty"`
 }
 
 // Runner options which must be set when the model is loaded into memory
@@ -242,6 +304,55 @@ type Runner struct {
 	UseMMap   *bool `json:"use_mmap,omitempty"`
 	UseMLock  bool  `json:"use_mlock,omitempty"`
 	NumThread int   `json:"num_thread,omitempty"`
+
+	// TensorSplit is a comma separated list of proportions, one per GPU,
+	// which overrides the automatic layout of layers across GPUs.
+	TensorSplit string `json:"tensor_split,omitempty"`
+
+	// GPUs is a comma separated list of GPU IDs or indexes which restricts
+	// the GPUs the model may be loaded on.
+	GPUs string `json:"gpus,omitempty"`
+
+	// KVCacheType is the data type of the K/V cache, one of f16, q8_0 or
+	// q4_0. Quantized types require flash attention.
+	KVCacheType string `json:"kv_cache_type,omitempty"`
+
+	// FlashAttention enables or disables flash attention for the model,
+	// overriding OLLAMA_FLASH_ATTENTION. It is ignored on unsupported GPUs.
+	FlashAttention *bool `json:"flash_attention,omitempty"`
+
+	// NumUBatch is the number of tokens of each batch of num_batch tokens
+	// which are processed at once. It's 512 if it's 0.
+	NumUBatch int `json:"num_ubatch,omitempty"`
+
+	// CUDAGraphs enables or disables CUDA graphs for the model, which are
+	// enabled by default on NVIDIA GPUs. Disabling them can work around
+	// driver issues at the cost of some generation speed.
+	CUDAGraphs *bool `json:"cuda_graphs,omitempty"`
+
+	// UsePinned enables or disables pinned memory for the parts of the model
+	// in system memory when it's loaded on NVIDIA or AMD GPUs without mmap.
+	// Pinned memory speeds up transfers to the GPUs but can't be swapped out.
+	UsePinned *bool `json:"use_pinned,omitempty"`
+
+	// RPCServers is a comma separated list of the host:port addresses of
+	// llama.cpp RPC servers on other machines which the model's layers are
+	// split across along with the local GPUs.
+	RPCServers string `json:"rpc_servers,omitempty"`
+
+	// Pooling is how the hidden states of the input tokens are combined
+	// into one vector, one of mean, cls or last. The model's default is
+	// used if it is empty.
+	Pooling string `json:"pooling,omitempty"`
+
+	// MaxTokensPerSecond caps how fast the model generates tokens, across
+	// all of its requests, so it leaves power and thermal headroom for
+	// other models. 0 is no limit.
+	MaxTokensPerSecond float32 `json:"max_tokens_per_second,omitempty"`
+
+	// NumParallel is how many requests the model serves at once, in place
+	// of OLLAMA_NUM_PARALLEL. 0 uses OLLAMA_NUM_PARALLEL.
+	NumParallel int `json:"num_parallel,omitempty"`
 }
 
 // EmbedRequest is the request passed to [Client.Embed].
@@ -256,6 +367,12 @@ type EmbedRequest struct {
 	// this request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// QueueTimeout is the longest the request will wait to be scheduled, as
+	// in [GenerateRequest].
+	QueueTimeout *Duration `json:"queue_timeout,omitempty"`
+
+	// Truncate truncates inputs longer than the model's context length to
+	// fit it if it's true or unset. If it's false, the request fails instead.
 	Truncate *bool `json:"truncate,omitempty"`
 
 	// Options lists model-specific options.
@@ -270,6 +387,164 @@ type EmbedResponse struct {
 	TotalDuration   time.Duration `json:"total_duration,omitempty"`
 	LoadDuration    time.Duration `json:"load_duration,omitempty"`
 	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+
+	// InputTokens is the number of tokens of each input which were
+	// embedded, after any truncation.
+	InputTokens []int `json:"input_tokens,omitempty"`
+
+	// Truncated is the indexes of the inputs which were truncated to fit
+	// the context length.
+	Truncated []int `json:"truncated,omitempty"`
+
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// TokenizeRequest is the request passed to [Client.Tokenize].
+type TokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Content is the text to tokenize.
+	Content string `json:"content"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// TokenizeResponse is the response from [Client.Tokenize].
+type TokenizeResponse struct {
+	Model string `json:"model"`
+
+	// Tokens are the IDs of the tokens of the content, without the tokens
+	// the model adds to every input such as BOS.
+	Tokens []int `json:"tokens"`
+}
+
+// ClassifyRequest is the request passed to [Client.Classify].
+type ClassifyRequest struct {
+	// Model is the model name. It must be a sequence classification model.
+	Model string `json:"model"`
+
+	// Input is the text or list of texts to classify.
+	Input any `json:"input"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// QueueTimeout is the longest the request will wait to be scheduled, as
+	// in [GenerateRequest].
+	QueueTimeout *Duration `json:"queue_timeout,omitempty"`
+
+	Truncate *bool `json:"truncate,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// ClassifyResponse is the response from [Client.Classify]. It has one result
+// per input.
+type ClassifyResponse struct {
+	Model   string           `json:"model"`
+	Results []ClassifyResult `json:"results"`
+
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+}
+
+// ClassifyResult holds the probability of each label for an input, sorted
+// from the most to the least likely label.
+type ClassifyResult struct {
+	Labels []ClassifyLabel `json:"labels"`
+}
+
+type ClassifyLabel struct {
+	Label string  `json:"label"`
+	Score float32 `json:"score"`
+}
+
+// RerankRequest is the request passed to [Client.Rerank].
+type RerankRequest struct {
+	// Model is the model name. It must be a cross-encoder reranker.
+	Model string `json:"model"`
+
+	// Query is the text documents are scored against.
+	Query string `json:"query"`
+
+	// Documents are the texts to rank.
+	Documents []string `json:"documents"`
+
+	// TopN limits the response to the most relevant documents. All documents
+	// are returned if it is zero.
+	TopN int `json:"top_n,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// QueueTimeout is the longest the request will wait to be scheduled, as
+	// in [GenerateRequest].
+	QueueTimeout *Duration `json:"queue_timeout,omitempty"`
+
+	Truncate *bool `json:"truncate,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// RerankResponse is the response from [Client.Rerank].
+type RerankResponse struct {
+	Model   string         `json:"model"`
+	Results []RerankResult `json:"results"`
+
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+}
+
+// RerankResult is the relevance of a document to the query. Results are
+// sorted from the most to the least relevant document.
+type RerankResult struct {
+	// Index is the position of the document in the request.
+	Index          int     `json:"index"`
+	Document       string  `json:"document"`
+	RelevanceScore float32 `json:"relevance_score"`
+}
+
+// ExtractRequest is the request passed to [Client.Extract].
+type ExtractRequest struct {
+	// Model is the name of the local model to extract.
+	Model string `json:"model"`
+}
+
+// SpeechRequest is the request passed to [Client.Speech].
+type SpeechRequest struct {
+	// Model is the model name. It must be a text-to-speech model.
+	Model string `json:"model"`
+
+	// Input is the text to speak.
+	Input string `json:"input"`
+
+	// ResponseFormat is the audio format of the response, "wav" (default) for
+	// a WAV stream or "pcm" for raw 16-bit little-endian mono samples. Both
+	// are sampled at 24 kHz.
+	ResponseFormat string `json:"response_format,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// QueueTimeout is the longest the request will wait to be scheduled, as
+	// in [GenerateRequest].
+	QueueTimeout *Duration `json:"queue_timeout,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
 }
 
 // EmbeddingRequest is the request passed to [Client.Embeddings].
@@ -284,6 +559,10 @@ type EmbeddingRequest struct {
 	// this request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// QueueTimeout is the longest the request will wait to be scheduled, as
+	// in [GenerateRequest].
+	QueueTimeout *Duration `json:"queue_timeout,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
@@ -301,6 +580,31 @@ type CreateRequest struct {
 	Stream    *bool  `json:"stream,omitempty"`
 	Quantize  string `json:"quantize,omitempty"`
 
+	// Imatrix is the digest of a blob used to weight the quantization: an
+	// importance matrix in llama.cpp's imatrix format, or calibration text to
+	// compute one from
+	Imatrix string `json:"imatrix,omitempty"`
+
+	// Check evaluates the created model on a short text and warns if its
+	// perplexity is too high for a working model
+	Check bool `json:"check,omitempty"`
+
+	// MergeAdapters folds the model's LoRA adapters into its weights before
+	// it's quantized, creating a model without adapters
+	MergeAdapters bool `json:"merge_adapters,omitempty"`
+
+	// OverrideKV sets keys of the model's GGUF metadata, such as
+	// llama.rope.freq_base, to new values
+	OverrideKV map[string]string `json:"override_kv,omitempty"`
+
+	// BuildArgs are the values of the Modelfile's ARG declarations
+	BuildArgs map[string]string `json:"build_args,omitempty"`
+
+	// Strict rejects parameters with values out of their range, as well as
+	// ones which don't exist or have the wrong type, with where they are in
+	// the Modelfile
+	Strict bool `json:"strict,omitempty"`
+
 	// Name is deprecated, see Model
 	Name string `json:"name"`
 
@@ -316,6 +620,29 @@ type DeleteRequest struct {
 	Name string `json:"name"`
 }
 
+// GCRequest is the request passed to [Client.GC].
+type GCRequest struct {
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// GCResponse is the response returned from [Client.GC].
+type GCResponse struct {
+	// Blobs is the number of blobs removed which no model uses.
+	Blobs int `json:"blobs"`
+
+	// Partials is the number of stale partial downloads and temporary
+	// files removed.
+	Partials int `json:"partials"`
+
+	// Linked is the number of copies of blobs in several models
+	// directories which were linked to share their storage.
+	Linked int `json:"linked"`
+
+	// Reclaimed is the number of bytes freed.
+	Reclaimed int64 `json:"reclaimed"`
+}
+
 // ShowRequest is the request passed to [Client.Show].
 type ShowRequest struct {
 	Model  string `json:"model"`
@@ -325,6 +652,10 @@ type ShowRequest struct {
 	Template string `json:"template"`
 	Verbose  bool   `json:"verbose"`
 
+	// Resolve references the model's blobs by digest in the Modelfile, so
+	// it creates the same model on any server which has them
+	Resolve bool `json:"resolve,omitempty"`
+
 	Options map[string]interface{} `json:"options"`
 
 	// Name is deprecated, see Model
@@ -340,9 +671,16 @@ type ShowResponse struct {
 	System        string         `json:"system,omitempty"`
 	Details       ModelDetails   `json:"details,omitempty"`
 	Messages      []Message      `json:"messages,omitempty"`
+	Tools         Tools          `json:"tools,omitempty"`
 	ModelInfo     map[string]any `json:"model_info,omitempty"`
 	ProjectorInfo map[string]any `json:"projector_info,omitempty"`
 	ModifiedAt    time.Time      `json:"modified_at,omitempty"`
+	Metadata      *ModelMetadata `json:"metadata,omitempty"`
+
+	// Attention is the attention implementation used by the loaded instance
+	// of the model, such as "flash" or "standard". It is empty when the model
+	// is not loaded.
+	Attention string `json:"attention,omitempty"`
 }
 
 // CopyRequest is the request passed to [Client.Copy].
@@ -359,6 +697,10 @@ type PullRequest struct {
 	Password string `json:"password"`
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// Concurrency is the number of connections the model is downloaded
+	// with. The server's OLLAMA_DOWNLOAD_CONCURRENCY is used if it's 0.
+	Concurrency int `json:"concurrency,omitempty"`
+
 	// Name is deprecated, see Model
 	Name string `json:"name"`
 }
@@ -370,6 +712,9 @@ type ProgressResponse struct {
 	Digest    string `json:"digest,omitempty"`
 	Total     int64  `json:"total,omitempty"`
 	Completed int64  `json:"completed,omitempty"`
+
+	// Tensor is the tensor being converted while a model is created
+	Tensor string `json:"tensor,omitempty"`
 }
 
 // PushRequest is the request passed to [Client.Push].
@@ -380,6 +725,10 @@ type PushRequest struct {
 	Password string `json:"password"`
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// Concurrency is the number of connections the model is uploaded
+	// with. The server's OLLAMA_UPLOAD_CONCURRENCY is used if it's 0.
+	Concurrency int `json:"concurrency,omitempty"`
+
 	// Name is deprecated, see Model
 	Name string `json:"name"`
 }
@@ -387,6 +736,10 @@ type PushRequest struct {
 // ListResponse is the response from [Client.List].
 type ListResponse struct {
 	Models []ListModelResponse `json:"models"`
+
+	// DiskUsage is the number of bytes the models take up on disk, counting
+	// the layers they share once.
+	DiskUsage int64 `json:"disk_usage,omitempty"`
 }
 
 // ProcessResponse is the response from [Client.Process].
@@ -394,6 +747,74 @@ type ProcessResponse struct {
 	Models []ProcessModelResponse `json:"models"`
 }
 
+// StatsResponse is the response from [Client.Stats].
+type StatsResponse struct {
+	// Window is how far back the rolling stats of each model go
+	Window time.Duration `json:"window"`
+
+	Models []ModelStats `json:"models"`
+}
+
+// ModelStats are the stats of the requests a model served in [StatsResponse].
+// All but TotalRequests and TotalErrors are of the requests in the window.
+type ModelStats struct {
+	Model string `json:"model"`
+
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+
+	// PromptTokensPerSecond and TokensPerSecond are how fast prompts were
+	// evaluated and responses generated
+	PromptTokensPerSecond float64 `json:"prompt_tokens_per_second"`
+	TokensPerSecond       float64 `json:"tokens_per_second"`
+
+	// LatencyP50 and LatencyP95 are percentiles of how long successful
+	// requests took, including loading the model
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP95 time.Duration `json:"latency_p95"`
+
+	// TotalRequests and TotalErrors are counted since the server started
+	TotalRequests int64 `json:"total_requests"`
+	TotalErrors   int64 `json:"total_errors"`
+}
+
+// HistoryRequest is the request passed to [Client.History].
+type HistoryRequest struct {
+	// Model limits the history to the requests of the model, if set
+	Model string `json:"model,omitempty"`
+
+	// Since and Until limit the history to the requests between them, if set
+	Since time.Time `json:"since,omitempty"`
+	Until time.Time `json:"until,omitempty"`
+
+	// Limit is the most requests returned, or 100 if it's 0
+	Limit int `json:"limit,omitempty"`
+}
+
+// HistoryResponse is the response from [Client.History].
+type HistoryResponse struct {
+	// Requests are the requests served, the latest first
+	Requests []HistoryEntry `json:"requests"`
+}
+
+// HistoryEntry summarizes a request served by a model in [HistoryResponse].
+type HistoryEntry struct {
+	Time      time.Time `json:"time"`
+	Model     string    `json:"model"`
+	Endpoint  string    `json:"endpoint"`
+	RequestID string    `json:"request_id,omitempty"`
+
+	// Status is "success", or "error" if the request failed
+	Status string `json:"status"`
+
+	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
+	EvalCount          int           `json:"eval_count,omitempty"`
+	TotalDuration      time.Duration `json:"total_duration"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+}
+
 // ListModelResponse is a single model description in [ListResponse].
 type ListModelResponse struct {
 	Name       string       `json:"name"`
@@ -402,6 +823,12 @@ type ListModelResponse struct {
 	Size       int64        `json:"size"`
 	Digest     string       `json:"digest"`
 	Details    ModelDetails `json:"details,omitempty"`
+
+	// Location is the models directory of the model when the server has
+	// more than one
+	Location string `json:"location,omitempty"`
+
+	Metadata *ModelMetadata `json:"metadata,omitempty"`
 }
 
 // ProcessModelResponse is a single model description in [ProcessResponse].
@@ -413,6 +840,75 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+
+	// KVCacheType and SizeKVCache describe the K/V cache of the loaded model
+	KVCacheType string `json:"kv_cache_type,omitempty"`
+	SizeKVCache int64  `json:"size_kv_cache,omitempty"`
+
+	// NumBatch, NumUBatch and CUDAGraphs are the batch sizes and whether
+	// CUDA graphs are used by the loaded model
+	NumBatch   int  `json:"num_batch,omitempty"`
+	NumUBatch  int  `json:"num_ubatch,omitempty"`
+	CUDAGraphs bool `json:"cuda_graphs,omitempty"`
+
+	// UseMMap, UseMLock and UsePinned report whether the parts of the
+	// loaded model in system memory are memory mapped from the model file,
+	// locked against swapping and pinned for transfers to GPUs
+	UseMMap   bool `json:"use_mmap,omitempty"`
+	UseMLock  bool `json:"use_mlock,omitempty"`
+	UsePinned bool `json:"use_pinned,omitempty"`
+
+	// Crashes is the number of times runners of the model crashed since the
+	// server started
+	Crashes int `json:"crashes,omitempty"`
+
+	// GPUs are the GPUs the model is loaded on with their current load
+	GPUs []ProcessGPUResponse `json:"gpus,omitempty"`
+
+	// Slots are what each of the model's parallel slots is doing, and
+	// Waiting is the number of requests waiting for one of them. Slots are
+	// omitted while the model is loading or if its runner doesn't respond
+	// in time.
+	Slots   []ProcessSlotResponse `json:"slots,omitempty"`
+	Waiting int                   `json:"waiting,omitempty"`
+}
+
+// ProcessSlotResponse is a parallel slot of a model in [ProcessModelResponse].
+type ProcessSlotResponse struct {
+	ID int `json:"id"`
+
+	// State is "idle", "prompt" while the slot evaluates the prompt of its
+	// request, or "generating"
+	State string `json:"state"`
+
+	// RequestID, the token counts and Age are of the request occupying the
+	// slot. ContextTokens are the tokens in the slot's context so far, from
+	// the prompt and generated.
+	RequestID       string        `json:"request_id,omitempty"`
+	PromptTokens    int           `json:"prompt_tokens,omitempty"`
+	ContextTokens   int           `json:"context_tokens,omitempty"`
+	GeneratedTokens int           `json:"generated_tokens,omitempty"`
+	Age             time.Duration `json:"age,omitempty"`
+}
+
+// ProcessGPUResponse is a GPU a model is loaded on in [ProcessModelResponse].
+// Utilization, Temperature and Power are omitted when the GPU or its driver
+// doesn't report them.
+type ProcessGPUResponse struct {
+	ID          string `json:"id"`
+	Library     string `json:"library"`
+	Name        string `json:"name,omitempty"`
+	FreeMemory  int64  `json:"free_memory"`
+	TotalMemory int64  `json:"total_memory"`
+
+	// Utilization is the percent of the time the GPU was busy
+	Utilization *int `json:"utilization,omitempty"`
+
+	// Temperature is in degrees Celsius
+	Temperature *int `json:"temperature,omitempty"`
+
+	// Power is the power draw in watts
+	Power *float64 `json:"power,omitempty"`
 }
 
 type RetrieveModelResponse struct {
@@ -447,6 +943,9 @@ type GenerateResponse struct {
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// RequestID is the ID of the request, set on the final response.
+	RequestID string `json:"request_id,omitempty"`
+
 	Metrics
 }
 
@@ -460,6 +959,14 @@ type ModelDetails struct {
 	QuantizationLevel string   `json:"quantization_level"`
 }
 
+// ModelMetadata describes a model for catalogs of models. It's set with
+// METADATA in the model's Modelfile.
+type ModelMetadata struct {
+	Description string   `json:"description,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
 func (m *Metrics) Summary() {
 	if m.TotalDuration > 0 {
 		fmt.Fprintf(os.Stderr, "total duration:       %v\n", m.TotalDuration)
@@ -478,6 +985,14 @@ func (m *Metrics) Summary() {
 		fmt.Fprintf(os.Stderr, "prompt eval rate:     %.2f tokens/s\n", float64(m.PromptEvalCount)/m.PromptEvalDuration.Seconds())
 	}
 
+	if m.PromptCacheCount > 0 {
+		fmt.Fprintf(os.Stderr, "prompt cache count:   %d token(s)\n", m.PromptCacheCount)
+	}
+
+	if m.DiscardedCount > 0 {
+		fmt.Fprintf(os.Stderr, "discarded count:      %d token(s)\n", m.DiscardedCount)
+	}
+
 	if m.EvalCount > 0 {
 		fmt.Fprintf(os.Stderr, "eval count:           %d token(s)\n", m.EvalCount)
 	}
@@ -550,16 +1065,30 @@ func (opts *Options) FromMap(m map[string]interface{}) error {
 				if !ok {
 					return fmt.Errorf("option %q must be of type array", key)
 				}
-				// convert []interface{} to []string
-				slice := make([]string, len(val))
-				for i, item := range val {
-					str, ok := item.(string)
-					if !ok {
-						return fmt.Errorf("option %q must be of an array of strings", key)
+				switch field.Type().Elem().Kind() {
+				case reflect.Float32:
+					// convert []interface{} to []float32
+					slice := make([]float32, len(val))
+					for i, item := range val {
+						f, ok := item.(float64)
+						if !ok {
+							return fmt.Errorf("option %q must be of an array of numbers", key)
+						}
+						slice[i] = float32(f)
 					}
-					slice[i] = str
+					field.Set(reflect.ValueOf(slice))
+				default:
+					// convert []interface{} to []string
+					slice := make([]string, len(val))
+					for i, item := range val {
+						str, ok := item.(string)
+						if !ok {
+							return fmt.Errorf("option %q must be of an array of strings", key)
+						}
+						slice[i] = str
+					}
+					field.Set(reflect.ValueOf(slice))
 				}
-				field.Set(reflect.ValueOf(slice))
 			case reflect.Pointer:
 				var b bool
 				if field.Type() == reflect.TypeOf(&b) {
@@ -707,8 +1236,22 @@ func FormatParams(params map[string][]string) (map[string]interface{}, error) {
 				case reflect.String:
 					out[key] = vals[0]
 				case reflect.Slice:
-					// TODO: only string slices are supported right now
-					out[key] = vals
+					if field.Type().Elem().Kind() != reflect.Float32 {
+						out[key] = vals
+						break
+					}
+
+					floatVals := make([]float32, len(vals))
+					for i, val := range vals {
+						floatVal, err := strconv.ParseFloat(val, 32)
+						if err != nil {
+							return nil, fmt.Errorf("invalid float value %s", vals)
+						}
+
+						floatVals[i] = float32(floatVal)
+					}
+
+					out[key] = floatVals
 				case reflect.Pointer:
 					var b bool
 					if field.Type() == reflect.TypeOf(&b) {
@@ -209,6 +209,21 @@ func TestUseMmapFormatParams(t *testing.T) {
 	}
 }
 
+func TestAdapterScaleParams(t *testing.T) {
+	params, err := FormatParams(map[string][]string{"adapter_scale": {"1", "0.5"}})
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 0.5}, params["adapter_scale"])
+
+	_, err = FormatParams(map[string][]string{"adapter_scale": {"foo"}})
+	require.Error(t, err)
+
+	var opts Options
+	require.NoError(t, opts.FromMap(map[string]any{"adapter_scale": []any{0.25, 2.0}}))
+	assert.Equal(t, []float32{0.25, 2}, opts.AdapterScale)
+
+	require.Error(t, opts.FromMap(map[string]any{"adapter_scale": []any{"foo"}}))
+}
+
 func TestMessage_UnmarshalJSON(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// errNoClipboardImage is returned by clipboardImage when the clipboard
+// doesn't hold an image
+var errNoClipboardImage = errors.New("the clipboard doesn't hold an image")
+
+// clipboardImage returns the PNG or JPEG image held by the system clipboard
+func clipboardImage(ctx context.Context) ([]byte, error) {
+	data, err := readClipboardImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, errNoClipboardImage
+	}
+
+	contentType := http.DetectContentType(data)
+	if !slices.Contains([]string{"image/jpeg", "image/png"}, contentType) {
+		return nil, fmt.Errorf("invalid image type: %s", contentType)
+	}
+
+	return data, nil
+}
+
+// clipboardMarker is the text standing in for the nth image pasted from the
+// clipboard in a message
+func clipboardMarker(n int) string {
+	return fmt.Sprintf("[image %d]", n)
+}
+
+// removeClipboardMarkers removes the markers of n images pasted from the
+// clipboard from content
+func removeClipboardMarkers(content string, n int) string {
+	for i := range n {
+		content = strings.ReplaceAll(content, clipboardMarker(i+1), "")
+	}
+
+	return strings.TrimSpace(content)
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"os/exec"
+	"strings"
+)
+
+func readClipboardImage(ctx context.Context) ([]byte, error) {
+	// AppleScript prints the image as «data PNGf89504E47...»
+	out, err := exec.CommandContext(ctx, "osascript", "-e", "the clipboard as «class PNGf»").Output()
+	if err != nil {
+		return nil, errNoClipboardImage
+	}
+
+	s := strings.TrimSpace(string(out))
+	s = strings.TrimPrefix(s, "«data PNGf")
+	s = strings.TrimSuffix(s, "»")
+	return hex.DecodeString(s)
+}
@@ -0,0 +1,28 @@
+//go:build !windows && !darwin
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func readClipboardImage(ctx context.Context) ([]byte, error) {
+	name, args := "xclip", []string{"-selection", "clipboard", "-target", "image/png", "-out"}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		name, args = "wl-paste", []string{"--no-newline", "--type", "image/png"}
+	}
+
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, errors.New("reading images from the clipboard requires " + name)
+	}
+
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return nil, errNoClipboardImage
+	}
+
+	return out, nil
+}
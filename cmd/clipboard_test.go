@@ -0,0 +1,13 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveClipboardMarkers(t *testing.T) {
+	assert.Equal(t, "what's in this image?", removeClipboardMarkers(clipboardMarker(1)+" what's in this image?", 1))
+	assert.Equal(t, "compare  and", removeClipboardMarkers("compare [image 1] and [image 2]", 2))
+	assert.Equal(t, "[image 3] isn't pasted", removeClipboardMarkers("[image 3] isn't pasted", 2))
+}
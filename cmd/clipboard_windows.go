@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"os/exec"
+	"strings"
+)
+
+const clipboardScript = `Add-Type -AssemblyName System.Windows.Forms, System.Drawing
+$image = [System.Windows.Forms.Clipboard]::GetImage()
+if ($image -eq $null) { exit 1 }
+$stream = New-Object System.IO.MemoryStream
+$image.Save($stream, [System.Drawing.Imaging.ImageFormat]::Png)
+[Convert]::ToBase64String($stream.ToArray())`
+
+func readClipboardImage(ctx context.Context) ([]byte, error) {
+	// the clipboard can only be read from a single-threaded apartment
+	out, err := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-STA", "-Command", clipboardScript).Output()
+	if err != nil {
+		return nil, errNoClipboardImage
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
@@ -1,19 +1,20 @@
 package cmd
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -21,9 +22,11 @@ import (
 	"regexp"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/containerd/console"
@@ -37,9 +40,12 @@ import (
 	"github.com/ollama/ollama/auth"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/i18n"
+	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/parser"
 	"github.com/ollama/ollama/progress"
 	"github.com/ollama/ollama/server"
+	"github.com/ollama/ollama/theme"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
 	"github.com/ollama/ollama/version"
@@ -57,7 +63,7 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	p := progress.NewProgress(os.Stderr)
+	p := newProgress(cmd)
 	defer p.Stop()
 
 	f, err := os.Open(filename)
@@ -71,6 +77,63 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	buildArgs, err := cmd.Flags().GetStringArray("build-arg")
+	if err != nil {
+		return err
+	}
+
+	// args are expanded here, not by the server, so paths can contain them
+	values := make(map[string]string)
+	for _, arg := range buildArgs {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			// like docker build, a bare name takes its value from the environment
+			if value, ok = os.LookupEnv(name); !ok {
+				return fmt.Errorf("build arg %s has no value", name)
+			}
+		}
+
+		values[name] = value
+	}
+
+	if err := modelfile.Expand(values); err != nil {
+		return err
+	}
+
+	// the Modelfile is checked here too so problems are reported where they
+	// are in it, not in the Modelfile sent to the server
+	strict, _ := cmd.Flags().GetBool("strict")
+	if strict {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := parser.CheckParameters(f, values); err != nil {
+			return err
+		}
+	}
+
+	// the quantization is checked before any model data is transferred
+	quantize, _ := cmd.Flags().GetString("quantize")
+	imatrixPath, _ := cmd.Flags().GetString("imatrix")
+	if quantize != "" {
+		ft, err := llm.ParseQuantizationType(quantize)
+		if err != nil {
+			return err
+		}
+
+		if ft.RequiresImatrix() && imatrixPath == "" {
+			return fmt.Errorf("quantizing to %s requires --imatrix", ft)
+		}
+	} else if imatrixPath != "" {
+		return errors.New("--imatrix requires --quantize")
+	}
+
+	// the server may not be able to read files next to the Modelfile
+	if err := modelfile.ReadFiles(filepath.Dir(filename)); err != nil {
+		return err
+	}
+
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return err
@@ -84,7 +147,16 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 	for i := range modelfile.Commands {
 		switch modelfile.Commands[i].Name {
 		case "model", "adapter":
-			path := modelfile.Commands[i].Args
+			path, scale := modelfile.Commands[i].Args, ""
+			if modelfile.Commands[i].Name == "adapter" {
+				path, scale = parser.SplitAdapter(path)
+			}
+
+			if strings.HasPrefix(path, "@") {
+				// a blob which is already on the server
+				continue
+			}
+
 			if path == "~" {
 				path = home
 			} else if strings.HasPrefix(path, "~/") {
@@ -102,30 +174,106 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 				return err
 			}
 
-			if fi.IsDir() {
-				// this is likely a safetensors or pytorch directory
-				// TODO make this work w/ adapters
-				tempfile, err := tempZipFiles(path)
+			var digest string
+			if files, err := llm.SplitFiles(path); err != nil {
+				return err
+			} else if len(files) > 0 {
+				// the files of a split GGUF are merged into one before they're uploaded
+				tempfile, err := tempMergeGGUF(files)
 				if err != nil {
 					return err
 				}
 				defer os.RemoveAll(tempfile)
 
 				path = tempfile
+			} else if fi.IsDir() {
+				// this is likely a safetensors or pytorch model, or a PEFT adapter
+				files, err := zipFiles(path)
+				if err != nil {
+					return err
+				}
+
+				if digest, err = createZipBlob(cmd, client, files, spinner); err != nil {
+					return err
+				}
 			}
 
-			digest, err := createBlob(cmd, client, path, spinner)
-			if err != nil {
-				return err
+			if digest == "" {
+				if digest, err = createBlob(cmd, client, path, spinner); err != nil {
+					return err
+				}
 			}
 
-			modelfile.Commands[i].Args = "@" + digest
+			modelfile.Commands[i].Args = strings.TrimSpace("@" + digest + " " + scale)
+		case "message":
+			for _, image := range parser.MessageImages(modelfile.Commands[i].Args) {
+				if strings.HasPrefix(image, "@") {
+					continue
+				}
+
+				path := image
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(filepath.Dir(filename), path)
+				}
+
+				if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+					// like prompts to ollama run, paths which aren't files are kept
+					continue
+				} else if err != nil {
+					return err
+				}
+
+				digest, err := createBlob(cmd, client, path, spinner)
+				if err != nil {
+					return err
+				}
+
+				modelfile.Commands[i].Args = strings.Replace(modelfile.Commands[i].Args, image, "@"+digest, 1)
+			}
+		}
+	}
+
+	var imatrix string
+	if imatrixPath != "" {
+		digest, err := createBlob(cmd, client, imatrixPath, spinner)
+		if err != nil {
+			return err
 		}
+
+		imatrix = digest
+	}
+
+	fn := createProgress(p, spinner, status)
+
+	check, _ := cmd.Flags().GetBool("check")
+	mergeAdapters, _ := cmd.Flags().GetBool("merge-adapter")
+	request := api.CreateRequest{Name: args[0], Modelfile: modelfile.String(), Quantize: quantize, Imatrix: imatrix, Check: check, MergeAdapters: mergeAdapters, Strict: strict}
+	if err := client.Create(cmd.Context(), &request, fn); err != nil {
+		return err
 	}
 
+	return nil
+}
+
+// createProgress shows the progress of creating a model after spinner, which
+// shows status
+func createProgress(p *progress.Progress, spinner *progress.Spinner, status string) func(api.ProgressResponse) error {
 	bars := make(map[string]*progress.Bar)
-	fn := func(resp api.ProgressResponse) error {
-		if resp.Digest != "" {
+	return func(resp api.ProgressResponse) error {
+		if resp.Digest == "" && resp.Total > 0 {
+			// progress of a step such as computing an importance matrix
+			spinner.Stop()
+
+			bar, ok := bars[resp.Status]
+			if !ok {
+				bar = progress.NewBar(resp.Status+"...", resp.Total, resp.Completed)
+				bars[resp.Status] = bar
+				p.Add(resp.Status, bar)
+			}
+
+			bar.Set(resp.Completed)
+			status = resp.Status
+		} else if resp.Digest != "" {
 			spinner.Stop()
 
 			bar, ok := bars[resp.Digest]
@@ -146,24 +294,26 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 
 		return nil
 	}
-
-	quantize, _ := cmd.Flags().GetString("quantize")
-
-	request := api.CreateRequest{Name: args[0], Modelfile: modelfile.String(), Quantize: quantize}
-	if err := client.Create(cmd.Context(), &request, fn); err != nil {
-		return err
-	}
-
-	return nil
 }
 
-func tempZipFiles(path string) (string, error) {
-	tempfile, err := os.CreateTemp("", "ollama-tf")
+func tempMergeGGUF(files []string) (string, error) {
+	tempfile, err := os.CreateTemp("", "ollama-gguf")
 	if err != nil {
 		return "", err
 	}
 	defer tempfile.Close()
 
+	if err := llm.MergeGGUF(tempfile, files); err != nil {
+		os.Remove(tempfile.Name())
+		return "", err
+	}
+
+	return tempfile.Name(), nil
+}
+
+// zipFiles returns the files of the model or adapter in the directory path
+// which are needed to convert it
+func zipFiles(path string) ([]string, error) {
 	detectContentType := func(path string) (string, error) {
 		f, err := os.Open(path)
 		if err != nil {
@@ -200,7 +350,10 @@ func tempZipFiles(path string) (string, error) {
 	}
 
 	var files []string
-	if st, _ := glob(filepath.Join(path, "model*.safetensors"), "application/octet-stream"); len(st) > 0 {
+	if st, _ := glob(filepath.Join(path, "adapter_model.safetensors"), "application/octet-stream"); len(st) > 0 {
+		// a PEFT adapter, adapter_config.json is picked up with the other json files
+		files = append(files, st...)
+	} else if st, _ := glob(filepath.Join(path, "model*.safetensors"), "application/octet-stream"); len(st) > 0 {
 		// safetensors files might be unresolved git lfs references; skip if they are
 		// covers model-x-of-y.safetensors, model.fp32-x-of-y.safetensors, model.safetensors
 		files = append(files, st...)
@@ -213,13 +366,13 @@ func tempZipFiles(path string) (string, error) {
 		// covers consolidated.x.pth, consolidated.pth
 		files = append(files, pt...)
 	} else {
-		return "", errors.New("no safetensors or torch files found")
+		return nil, errors.New("no safetensors or torch files found")
 	}
 
 	// add configuration files, json files are detected as text/plain
 	js, err := glob(filepath.Join(path, "*.json"), "text/plain")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	files = append(files, js...)
 
@@ -227,66 +380,177 @@ func tempZipFiles(path string) (string, error) {
 		// add tokenizer.model if it exists, tokenizer.json is automatically picked up by the previous glob
 		// tokenizer.model might be a unresolved git lfs reference; error if it is
 		files = append(files, tks...)
+	} else if tks, _ := glob(filepath.Join(path, "tokenizer.model"), "text/plain"); len(tks) > 0 && !slices.Contains(js, filepath.Join(path, "tokenizer.json")) {
+		// a text tokenizer.model is a tiktoken file, which is only needed without tokenizer.json
+		files = append(files, tks...)
 	} else if tks, _ := glob(filepath.Join(path, "**/tokenizer.model"), "text/plain"); len(tks) > 0 {
 		// some times tokenizer.model is in a subdirectory (e.g. meta-llama/Meta-Llama-3-8B)
 		files = append(files, tks...)
 	}
 
-	zipfile := zip.NewWriter(tempfile)
-	defer zipfile.Close()
+	return files, nil
+}
 
+// writeZip writes a zip archive of files to w. The files are stored
+// uncompressed so the server can read them in place, and the archive is the
+// same each time it's written as long as the files don't change.
+func writeZip(w io.Writer, files []string) error {
+	zipfile := zip.NewWriter(w)
 	for _, file := range files {
 		f, err := os.Open(file)
 		if err != nil {
-			return "", err
+			return err
 		}
 		defer f.Close()
 
 		fi, err := f.Stat()
 		if err != nil {
-			return "", err
+			return err
 		}
 
 		zfi, err := zip.FileInfoHeader(fi)
 		if err != nil {
-			return "", err
+			return err
 		}
+		zfi.Method = zip.Store
 
 		zf, err := zipfile.CreateHeader(zfi)
 		if err != nil {
-			return "", err
+			return err
 		}
 
 		if _, err := io.Copy(zf, f); err != nil {
-			return "", err
+			return err
 		}
 	}
 
-	return tempfile.Name(), nil
+	return zipfile.Close()
+}
+
+func LintHandler(cmd *cobra.Command, args []string) error {
+	filename, _ := cmd.Flags().GetString("file")
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	diagnostics, err := parser.Lint(f, filepath.Dir(filename), ggufChatTemplate)
+	if err != nil {
+		return err
+	}
+
+	if format, _ := cmd.Flags().GetString("format"); format == "json" {
+		if diagnostics == nil {
+			diagnostics = []parser.Diagnostic{}
+		}
+
+		if err := json.NewEncoder(os.Stdout).Encode(diagnostics); err != nil {
+			return err
+		}
+	} else {
+		name, _ := cmd.Flags().GetString("file")
+		for _, d := range diagnostics {
+			if d.Line == 0 {
+				fmt.Printf("%s: %s\n", name, d)
+			} else {
+				fmt.Printf("%s:%s\n", name, d)
+			}
+		}
+	}
+
+	var errs int
+	for _, d := range diagnostics {
+		if d.Severity == parser.SeverityError {
+			errs++
+		}
+	}
+
+	if errs == 1 {
+		return errors.New("found 1 error")
+	} else if errs > 1 {
+		return fmt.Errorf("found %d errors", errs)
+	}
+
+	return nil
+}
+
+// ggufChatTemplate returns the chat template of the GGUF model at path, or
+// nothing if it isn't a GGUF
+func ggufChatTemplate(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	ggml, _, err := llm.DecodeGGML(f, 0)
+	if errors.Is(err, llm.ErrUnsupportedFormat) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return ggml.KV().ChatTemplate(), nil
 }
 
 func createBlob(cmd *cobra.Command, client *api.Client, path string, spinner *progress.Spinner) (string, error) {
-	bin, err := os.Open(path)
+	fi, err := os.Stat(path)
 	if err != nil {
 		return "", err
 	}
-	defer bin.Close()
 
-	// Get file info to retrieve the size
-	fileInfo, err := bin.Stat()
+	return uploadBlob(cmd, client, func() (io.ReadCloser, error) { return os.Open(path) }, fi.Size(), spinner)
+}
+
+// createZipBlob uploads a zip archive of files, which is written as it's
+// hashed and uploaded rather than to a temporary file
+func createZipBlob(cmd *cobra.Command, client *api.Client, files []string, spinner *progress.Spinner) (string, error) {
+	var size int64
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+
+		size += fi.Size()
+	}
+
+	return uploadBlob(cmd, client, func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeZip(pw, files))
+		}()
+
+		return pr, nil
+	}, size, spinner)
+}
+
+// uploadBlob uploads the blob open returns, reading it twice: once to hash it
+// and once to upload it. size is the blob's size, for progress.
+func uploadBlob(cmd *cobra.Command, client *api.Client, open func() (io.ReadCloser, error), size int64, spinner *progress.Spinner) (string, error) {
+	bin, err := open()
 	if err != nil {
 		return "", err
 	}
-	fileSize := fileInfo.Size()
 
 	hash := sha256.New()
-	if _, err := io.Copy(hash, bin); err != nil {
+	_, err = io.Copy(hash, bin)
+	bin.Close()
+	if err != nil {
 		return "", err
 	}
 
-	if _, err := bin.Seek(0, io.SeekStart); err != nil {
+	bin, err = open()
+	if err != nil {
 		return "", err
 	}
+	defer bin.Close()
 
 	var pw progressWriter
 	status := "transferring model data 0%"
@@ -301,7 +565,7 @@ func createBlob(cmd *cobra.Command, client *api.Client, path string, spinner *pr
 		for {
 			select {
 			case <-ticker.C:
-				spinner.SetMessage(fmt.Sprintf("transferring model data %d%%", int(100*pw.n.Load()/fileSize)))
+				spinner.SetMessage(fmt.Sprintf("transferring model data %d%%", min(100, int(100*pw.n.Load()/max(size, 1)))))
 			case <-done:
 				spinner.SetMessage("transferring model data 100%")
 				return
@@ -476,7 +740,12 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	p := progress.NewProgress(os.Stderr)
+	concurrency, err := cmd.Flags().GetInt("concurrency")
+	if err != nil {
+		return err
+	}
+
+	p := newProgress(cmd)
 	defer p.Stop()
 
 	bars := make(map[string]*progress.Bar)
@@ -510,7 +779,7 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PushRequest{Name: args[0], Insecure: insecure}
+	request := api.PushRequest{Name: args[0], Insecure: insecure, Concurrency: concurrency}
 	if err := client.Push(cmd.Context(), &request, fn); err != nil {
 		if spinner != nil {
 			spinner.Stop()
@@ -544,25 +813,70 @@ func ListHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if format, _ := cmd.Flags().GetString("format"); format != "" {
+		return listFormat(models.Models, args, format)
+	}
+
+	// the location is only set when there are several models directories
+	header := []string{"NAME", "ID", "SIZE", "MODIFIED"}
+	if slices.ContainsFunc(models.Models, func(m api.ListModelResponse) bool { return m.Location != "" }) {
+		header = append(header, "LOCATION")
+	}
+
 	var data [][]string
+	var total int64
 
 	for _, m := range models.Models {
+		total += m.Size
 		if len(args) == 0 || strings.HasPrefix(m.Name, args[0]) {
-			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), format.HumanTime(m.ModifiedAt, "Never")})
+			row := []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), format.HumanTime(m.ModifiedAt, "Never")}
+			if len(header) > 4 {
+				row = append(row, m.Location)
+			}
+
+			data = append(data, row)
 		}
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"NAME", "ID", "SIZE", "MODIFIED"})
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetHeaderLine(false)
-	table.SetBorder(false)
-	table.SetNoWhiteSpace(true)
-	table.SetTablePadding("\t")
+	table := newTable(header)
 	table.AppendBulk(data)
 	table.Render()
 
+	// models which share layers take up less space than their sizes add up to
+	if len(args) == 0 && models.DiskUsage > 0 && models.DiskUsage < total {
+		fmt.Printf("\n%s on disk, %s shared between models\n", format.HumanBytes(models.DiskUsage), format.HumanBytes(total-models.DiskUsage))
+	}
+
+	return nil
+}
+
+// listFormat writes the models as JSON, or each model with a template such
+// as "{{ .Name }}\t{{ .Metadata.Description }}"
+func listFormat(models []api.ListModelResponse, args []string, format string) error {
+	models = slices.DeleteFunc(models, func(m api.ListModelResponse) bool {
+		return len(args) > 0 && !strings.HasPrefix(m.Name, args[0])
+	})
+
+	if format == "json" {
+		return json.NewEncoder(os.Stdout).Encode(models)
+	}
+
+	tmpl, err := template.New("format").Option("missingkey=zero").Parse(format + "\n")
+	if err != nil {
+		return fmt.Errorf("invalid format: %w", err)
+	}
+
+	for _, m := range models {
+		if m.Metadata == nil {
+			// so templates can use fields of the metadata of every model
+			m.Metadata = &api.ModelMetadata{}
+		}
+
+		if err := tmpl.Execute(os.Stdout, m); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -577,7 +891,12 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	var data [][]string
+	showSlots, err := cmd.Flags().GetBool("slots")
+	if err != nil {
+		return err
+	}
+
+	var data, slotData [][]string
 
 	for _, m := range models.Models {
 		if len(args) == 0 || strings.HasPrefix(m.Name, args[0]) {
@@ -594,24 +913,184 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 				cpuPercent := math.Round(float64(sizeCPU) / float64(m.Size) * 100)
 				procStr = fmt.Sprintf("%d%%/%d%% CPU/GPU", int(cpuPercent), int(100-cpuPercent))
 			}
-			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), procStr, format.HumanTime(m.ExpiresAt, "Never")})
+			var kvStr string
+			if m.KVCacheType != "" {
+				kvStr = fmt.Sprintf("%s %s", format.HumanBytes(m.SizeKVCache), m.KVCacheType)
+			}
+			var batchStr string
+			if m.NumBatch > 0 {
+				batchStr = fmt.Sprintf("%d/%d", m.NumBatch, m.NumUBatch)
+				if m.CUDAGraphs {
+					batchStr += " graphs"
+				}
+			}
+			var memory []string
+			if m.UseMMap {
+				memory = append(memory, "mmap")
+			}
+			if m.UseMLock {
+				memory = append(memory, "mlock")
+			}
+			if m.UsePinned {
+				memory = append(memory, "pinned")
+			}
+			var crashStr string
+			if m.Crashes > 0 {
+				crashStr = strconv.Itoa(m.Crashes)
+			}
+			var slotStr string
+			if len(m.Slots) > 0 {
+				var busy int
+				for _, slot := range m.Slots {
+					if slot.State != "idle" {
+						busy++
+					}
+
+					if showSlots {
+						var age string
+						if slot.State != "idle" {
+							age = slot.Age.Round(time.Second).String()
+						}
+						slotData = append(slotData, []string{m.Name, strconv.Itoa(slot.ID), slot.State, slot.RequestID, strconv.Itoa(slot.PromptTokens), strconv.Itoa(slot.ContextTokens), strconv.Itoa(slot.GeneratedTokens), age})
+					}
+				}
+				slotStr = fmt.Sprintf("%d/%d busy", busy, len(m.Slots))
+			}
+			if m.Waiting > 0 {
+				slotStr = strings.TrimPrefix(fmt.Sprintf("%s, %d waiting", slotStr, m.Waiting), ", ")
+			}
+			utilStr, tempStr, powerStr, vramStr := gpuStats(m.GPUs)
+			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), procStr, kvStr, batchStr, strings.Join(memory, ", "), utilStr, tempStr, powerStr, vramStr, slotStr, crashStr, format.HumanTime(m.ExpiresAt, "Never")})
 		}
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"NAME", "ID", "SIZE", "PROCESSOR", "UNTIL"})
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetHeaderLine(false)
-	table.SetBorder(false)
-	table.SetNoWhiteSpace(true)
-	table.SetTablePadding("\t")
+	table := newTable([]string{"NAME", "ID", "SIZE", "PROCESSOR", "KV CACHE", "BATCH", "MEMORY", "GPU UTIL", "TEMP", "POWER", "VRAM FREE", "SLOTS", "CRASHES", "UNTIL"})
 	table.AppendBulk(data)
 	table.Render()
 
+	if len(slotData) > 0 {
+		fmt.Println()
+
+		table := newTable([]string{"NAME", "SLOT", "STATE", "REQUEST ID", "PROMPT TOKENS", "CONTEXT TOKENS", "GENERATED", "AGE"})
+		table.AppendBulk(slotData)
+		table.Render()
+	}
+
 	return nil
 }
 
+func StatsHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Stats(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, m := range resp.Models {
+		if len(args) == 0 || strings.HasPrefix(m.Model, args[0]) {
+			data = append(data, []string{
+				m.Model,
+				strconv.Itoa(m.Requests),
+				fmt.Sprintf("%d (%.1f%%)", m.Errors, m.ErrorRate*100),
+				fmt.Sprintf("%.2f", m.PromptTokensPerSecond),
+				fmt.Sprintf("%.2f", m.TokensPerSecond),
+				m.LatencyP50.Round(time.Millisecond).String(),
+				m.LatencyP95.Round(time.Millisecond).String(),
+				fmt.Sprintf("%d (%d errors)", m.TotalRequests, m.TotalErrors),
+			})
+		}
+	}
+
+	fmt.Printf("Requests in the last %s:\n", resp.Window)
+
+	table := newTable([]string{"NAME", "REQUESTS", "ERRORS", "PROMPT TOKENS/S", "TOKENS/S", "P50", "P95", "SINCE START"})
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
+func HistoryHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	since, err := cmd.Flags().GetDuration("since")
+	if err != nil {
+		return err
+	}
+
+	limit, err := cmd.Flags().GetInt("limit")
+	if err != nil {
+		return err
+	}
+
+	req := api.HistoryRequest{Limit: limit}
+	if len(args) > 0 {
+		req.Model = args[0]
+	}
+	if since > 0 {
+		req.Since = time.Now().Add(-since)
+	}
+
+	resp, err := client.History(cmd.Context(), &req)
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, e := range resp.Requests {
+		data = append(data, []string{
+			e.Time.Local().Format(time.DateTime),
+			e.Model,
+			e.Endpoint,
+			e.Status,
+			strconv.Itoa(e.PromptEvalCount),
+			strconv.Itoa(e.EvalCount),
+			e.TotalDuration.Round(time.Millisecond).String(),
+			e.RequestID,
+		})
+	}
+
+	table := newTable([]string{"TIME", "NAME", "ENDPOINT", "STATUS", "PROMPT TOKENS", "TOKENS", "DURATION", "REQUEST ID"})
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
+// gpuStats formats the utilization, temperature, power draw and free memory
+// of the GPUs a model is loaded on, separated by commas when there are
+// several. Measurements a GPU doesn't report are shown as -.
+func gpuStats(gpus []api.ProcessGPUResponse) (util, temp, power, vram string) {
+	var utils, temps, powers, vrams []string
+	for _, g := range gpus {
+		u, t, p := "-", "-", "-"
+		if g.Utilization != nil {
+			u = fmt.Sprintf("%d%%", *g.Utilization)
+		}
+		if g.Temperature != nil {
+			t = fmt.Sprintf("%d°C", *g.Temperature)
+		}
+		if g.Power != nil {
+			p = fmt.Sprintf("%.0f W", *g.Power)
+		}
+
+		utils = append(utils, u)
+		temps = append(temps, t)
+		powers = append(powers, p)
+		vrams = append(vrams, format.HumanBytes(g.FreeMemory)+"/"+format.HumanBytes(g.TotalMemory))
+	}
+
+	return strings.Join(utils, ", "), strings.Join(temps, ", "), strings.Join(powers, ", "), strings.Join(vrams, ", ")
+}
+
 func DeleteHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -628,6 +1107,31 @@ func DeleteHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func PruneHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GC(cmd.Context(), &api.GCRequest{DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+
+	verb := "reclaimed"
+	if dryRun {
+		verb = "would reclaim"
+	}
+
+	fmt.Printf("%s %s: %d unused blobs, %d partial downloads, %d linked copies\n", verb, format.HumanBytes(resp.Reclaimed), resp.Blobs, resp.Partials, resp.Linked)
+	return nil
+}
+
 func ShowHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -678,7 +1182,12 @@ func ShowHandler(cmd *cobra.Command, args []string) error {
 		return errors.New("only one of '--license', '--modelfile', '--parameters', '--system', or '--template' can be specified")
 	}
 
-	req := api.ShowRequest{Name: args[0]}
+	resolve, _ := cmd.Flags().GetBool("resolve")
+	if resolve && !modelfile {
+		return errors.New("'--resolve' can only be used with '--modelfile'")
+	}
+
+	req := api.ShowRequest{Name: args[0], Resolve: resolve}
 	resp, err := client.Show(cmd.Context(), &req)
 	if err != nil {
 		return err
@@ -743,6 +1252,17 @@ func showInfo(resp *api.ShowResponse) {
 		)
 	}
 
+	if md := resp.Metadata; md != nil {
+		var metadata [][]string
+		for _, kv := range [][]string{{"description", md.Description}, {"author", md.Author}, {"tags", strings.Join(md.Tags, ", ")}} {
+			if kv[1] != "" {
+				metadata = append(metadata, kv)
+			}
+		}
+
+		mainTableData = append(mainTableData, []string{"Metadata"}, []string{renderSubTable(metadata, false)})
+	}
+
 	if resp.Parameters != "" {
 		mainTableData = append(mainTableData, []string{"Parameters"}, []string{formatParams(resp.Parameters)})
 	}
@@ -767,6 +1287,26 @@ func showInfo(resp *api.ShowResponse) {
 	table.Render()
 }
 
+// newTable returns a table on stdout with header, aligned like the output of
+// list and ps
+func newTable(header []string) *tablewriter.Table {
+	styled := make([]string, len(header))
+	for i, h := range header {
+		styled[i] = theme.Current().Header.Render(h)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(styled)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("\t")
+	return table
+}
+
 func renderSubTable(data [][]string, file bool) string {
 	var buf bytes.Buffer
 	table := tablewriter.NewWriter(&buf)
@@ -833,18 +1373,268 @@ func CopyHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func ExtractHandler(cmd *cobra.Command, args []string) error {
+	dest, _ := cmd.Flags().GetString("dest")
+	if dest == "" {
+		// the directory defaults to the model's name, e.g. llama3.2-latest
+		n := model.ParseName(args[0])
+		if !n.IsValid() {
+			return fmt.Errorf("invalid model name %q", args[0])
+		}
+
+		dest = n.Model + "-" + n.Tag
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+
+	p := newProgress(cmd)
+	defer p.Stop()
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	go func() {
+		pw.CloseWithError(client.Extract(cmd.Context(), &api.ExtractRequest{Model: args[0]}, pw))
+	}()
+
+	tr := tar.NewReader(pr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(hdr.Name) {
+			return fmt.Errorf("invalid file %q", hdr.Name)
+		}
+
+		bar := progress.NewBar(fmt.Sprintf("writing %s...", hdr.Name), hdr.Size, 0)
+		p.Add(hdr.Name, bar)
+
+		if err := extractFile(filepath.Join(dest, hdr.Name), tr, bar); err != nil {
+			return err
+		}
+	}
+
+	p.StopAndClear()
+	fmt.Printf("extracted '%s' to %s\n", args[0], dest)
+	return nil
+}
+
+// extractFile writes the file at path from r, which mustn't already exist
+func extractFile(path string, r io.Reader, bar *progress.Bar) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, io.TeeReader(r, &barWriter{bar: bar})); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
+
+// barWriter sets bar to the number of bytes written to it
+type barWriter struct {
+	bar *progress.Bar
+	n   int64
+}
+
+func (w *barWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.bar.Set(w.n)
+	return len(p), nil
+}
+
+func QuantizeHandler(cmd *cobra.Command, args []string) error {
+	quantize, _ := cmd.Flags().GetString("quantize")
+	if quantize == "" {
+		// the type defaults to the tag of the destination, e.g. llama3:q4_K_M
+		tag := model.ParseName(args[1]).Tag
+		if _, err := llm.ParseQuantizationType(tag); err != nil {
+			return fmt.Errorf("%s isn't a quantization type, set one with --quantize", tag)
+		}
+
+		quantize = tag
+	}
+
+	ft, err := llm.ParseQuantizationType(quantize)
+	if err != nil {
+		return err
+	}
+
+	imatrixPath, _ := cmd.Flags().GetString("imatrix")
+	if ft.RequiresImatrix() && imatrixPath == "" {
+		return fmt.Errorf("quantizing to %s requires --imatrix", ft)
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	// only local models are quantized, unlike FROM in a Modelfile they aren't pulled
+	if _, err := client.Show(cmd.Context(), &api.ShowRequest{Name: args[0]}); err != nil {
+		return err
+	}
+
+	p := newProgress(cmd)
+	defer p.Stop()
+
+	status := "reading model metadata"
+	spinner := progress.NewSpinner(status)
+	p.Add(status, spinner)
+
+	var imatrix string
+	if imatrixPath != "" {
+		digest, err := createBlob(cmd, client, imatrixPath, spinner)
+		if err != nil {
+			return err
+		}
+
+		imatrix = digest
+	}
+
+	check, _ := cmd.Flags().GetBool("check")
+
+	// the template, parameters and other layers of the model are kept
+	request := api.CreateRequest{
+		Model:     args[1],
+		Modelfile: fmt.Sprintf("FROM %s", args[0]),
+		Quantize:  ft.String(),
+		Imatrix:   imatrix,
+		Check:     check,
+	}
+
+	return client.Create(cmd.Context(), &request, createProgress(p, spinner, status))
+}
+
+// ggufValue formats a value of a model's GGUF metadata as returned by show
+func ggufValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		bts, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+
+		return string(bts)
+	}
+}
+
+func GGUFGetHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Show(cmd.Context(), &api.ShowRequest{Name: args[0]})
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 1 {
+		for _, key := range args[1:] {
+			v, ok := resp.ModelInfo[key]
+			if !ok {
+				return fmt.Errorf("model has no metadata %s", key)
+			}
+
+			fmt.Println(ggufValue(v))
+		}
+
+		return nil
+	}
+
+	keys := make([]string, 0, len(resp.ModelInfo))
+	for key := range resp.ModelInfo {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Printf("%s = %s\n", key, ggufValue(resp.ModelInfo[key]))
+	}
+
+	return nil
+}
+
+func GGUFSetHandler(cmd *cobra.Command, args []string) error {
+	overrides := make(map[string]string)
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid metadata %q, expected KEY=VALUE", arg)
+		}
+
+		overrides[key] = value
+	}
+
+	dest, _ := cmd.Flags().GetString("dest")
+	if dest == "" {
+		dest = args[0]
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	// like quantize, only local models are changed
+	if _, err := client.Show(cmd.Context(), &api.ShowRequest{Name: args[0]}); err != nil {
+		return err
+	}
+
+	p := newProgress(cmd)
+	defer p.Stop()
+
+	status := "reading model metadata"
+	spinner := progress.NewSpinner(status)
+	p.Add(status, spinner)
+
+	// the template, parameters and other layers of the model are kept
+	request := api.CreateRequest{
+		Model:      dest,
+		Modelfile:  fmt.Sprintf("FROM %s", args[0]),
+		OverrideKV: overrides,
+	}
+
+	return client.Create(cmd.Context(), &request, createProgress(p, spinner, status))
+}
+
 func PullHandler(cmd *cobra.Command, args []string) error {
 	insecure, err := cmd.Flags().GetBool("insecure")
 	if err != nil {
 		return err
 	}
 
+	concurrency, err := cmd.Flags().GetInt("concurrency")
+	if err != nil {
+		return err
+	}
+
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
 		return err
 	}
 
-	p := progress.NewProgress(os.Stderr)
+	p := newProgress(cmd)
 	defer p.Stop()
 
 	bars := make(map[string]*progress.Bar)
@@ -879,7 +1669,7 @@ func PullHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PullRequest{Name: args[0], Insecure: insecure}
+	request := api.PullRequest{Name: args[0], Insecure: insecure, Concurrency: concurrency}
 	if err := client.Pull(cmd.Context(), &request, fn); err != nil {
 		return err
 	}
@@ -962,7 +1752,7 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 		return nil, err
 	}
 
-	p := progress.NewProgress(os.Stderr)
+	p := newProgress(cmd)
 	defer p.StopAndClear()
 
 	spinner := progress.NewSpinner("")
@@ -1039,7 +1829,7 @@ func generate(cmd *cobra.Command, opts runOptions) error {
 		return err
 	}
 
-	p := progress.NewProgress(os.Stderr)
+	p := newProgress(cmd)
 	defer p.StopAndClear()
 
 	spinner := progress.NewSpinner("")
@@ -1130,12 +1920,12 @@ func RunServer(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	ln, err := net.Listen("tcp", envconfig.Host().Host)
+	lns, err := server.Listen()
 	if err != nil {
 		return err
 	}
 
-	err = server.Serve(ln)
+	err = server.Serve(lns...)
 	if errors.Is(err, http.ErrServerClosed) {
 		return nil
 	}
@@ -1189,6 +1979,16 @@ func initializeKeypair() error {
 	return nil
 }
 
+// newProgress returns the progress of cmd on stderr. Unless stderr is a
+// terminal and cmd isn't quiet, only its result is shown.
+func newProgress(cmd *cobra.Command) *progress.Progress {
+	if quiet, _ := cmd.Flags().GetBool("quiet"); quiet || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return progress.NewQuietProgress(os.Stderr)
+	}
+
+	return progress.NewProgress(os.Stderr)
+}
+
 func checkServerHeartbeat(cmd *cobra.Command, _ []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -1199,7 +1999,7 @@ func checkServerHeartbeat(cmd *cobra.Command, _ []string) error {
 			return err
 		}
 		if err := startApp(cmd.Context(), client); err != nil {
-			return errors.New("could not connect to ollama app, is it running?")
+			return errServerUnreachable
 		}
 	}
 	return nil
@@ -1213,7 +2013,7 @@ func versionHandler(cmd *cobra.Command, _ []string) {
 
 	serverVersion, err := client.Version(cmd.Context())
 	if err != nil {
-		fmt.Println("Warning: could not connect to a running Ollama instance")
+		fmt.Println(theme.Current().Warning.Render("Warning:"), "could not connect to a running Ollama instance")
 	}
 
 	if serverVersion != "" {
@@ -1221,8 +2021,32 @@ func versionHandler(cmd *cobra.Command, _ []string) {
 	}
 
 	if serverVersion != version.Version {
-		fmt.Printf("Warning: client version is %s\n", version.Version)
+		fmt.Printf("%s client version is %s\n", theme.Current().Warning.Render("Warning:"), version.Version)
+	}
+}
+
+// usageText is the text of cobra's usage template shown in the user's
+// language. Global Flags comes before Flags, which it contains.
+var usageText = []string{
+	"Usage:",
+	"Aliases:",
+	"Examples:",
+	"Available Commands:",
+	"Global Flags:",
+	"Flags:",
+	"Additional help topics:",
+	`Use "{{.CommandPath}} [command] --help" for more information about a command.`,
+}
+
+// localizeUsage translates the usage of cmd, and the commands which inherit
+// it, to the user's language
+func localizeUsage(cmd *cobra.Command) {
+	usage := cmd.UsageTemplate()
+	for _, text := range usageText {
+		usage = strings.ReplaceAll(usage, text, i18n.T(text))
 	}
+
+	cmd.SetUsageTemplate(usage)
 }
 
 func appendEnvDocs(cmd *cobra.Command, envs []envconfig.EnvVar) {
@@ -1230,9 +2054,7 @@ func appendEnvDocs(cmd *cobra.Command, envs []envconfig.EnvVar) {
 		return
 	}
 
-	envUsage := `
-Environment Variables:
-`
+	envUsage := "\n" + i18n.T("Environment Variables:") + "\n"
 	for _, e := range envs {
 		envUsage += fmt.Sprintf("      %-24s   %s\n", e.Name, e.Description)
 	}
@@ -1250,11 +2072,16 @@ func NewCLI() *cobra.Command {
 
 	rootCmd := &cobra.Command{
 		Use:           "ollama",
-		Short:         "Large language model runner",
+		Short:         i18n.T("Large language model runner"),
 		SilenceUsage:  true,
 		SilenceErrors: true,
-		CompletionOptions: cobra.CompletionOptions{
-			DisableDefaultCmd: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			color, err := cmd.Flags().GetString("color")
+			if err != nil {
+				return err
+			}
+
+			return theme.Setup(color, envconfig.Theme())
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			if version, _ := cmd.Flags().GetBool("version"); version {
@@ -1267,107 +2094,254 @@ func NewCLI() *cobra.Command {
 	}
 
 	rootCmd.Flags().BoolP("version", "v", false, "Show version information")
+	localizeUsage(rootCmd)
+	rootCmd.PersistentFlags().Bool("quiet", false, "Don't show spinners or progress bars, only the result")
+	rootCmd.PersistentFlags().String("color", theme.ColorAuto, "When to color output: auto, always or never")
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("color", completeValues(
+		theme.ColorAuto+"\tColor terminals unless NO_COLOR is set",
+		theme.ColorAlways+"\tAlways color output",
+		theme.ColorNever+"\tNever color output",
+	)))
 
 	createCmd := &cobra.Command{
-		Use:     "create MODEL",
-		Short:   "Create a model from a Modelfile",
-		Args:    cobra.ExactArgs(1),
-		PreRunE: checkServerHeartbeat,
-		RunE:    CreateHandler,
+		Use:               "create MODEL",
+		Short:             i18n.T("Create a model from a Modelfile"),
+		Args:              cobra.ExactArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              CreateHandler,
+		ValidArgsFunction: cobra.NoFileCompletions,
 	}
 
 	createCmd.Flags().StringP("file", "f", "Modelfile", "Name of the Modelfile")
-	createCmd.Flags().StringP("quantize", "q", "", "Quantize model to this level (e.g. q4_0)")
+	createCmd.Flags().StringP("quantize", "q", "", "Quantize model to this level (e.g. q4_K_M, q8_0, iq4_xs)")
+	createCmd.Flags().String("imatrix", "", "Weight quantization with an importance matrix, or calibration text to compute one from")
+	createCmd.Flags().Bool("check", false, "Check the model's perplexity on a short text to catch broken conversions")
+	createCmd.Flags().Bool("merge-adapter", false, "Merge ADAPTER weights into the model before quantizing it")
+	createCmd.Flags().StringArray("build-arg", nil, "Set the value of a Modelfile ARG (e.g. QUANT=q8_0)")
+	createCmd.Flags().Bool("strict", false, "Reject parameters which are out of range as well as unknown or of the wrong type")
+	cobra.CheckErr(createCmd.RegisterFlagCompletionFunc("quantize", completeQuantizationTypes))
+	cobra.CheckErr(createCmd.RegisterFlagCompletionFunc("build-arg", completeBuildArgs))
+
+	lintCmd := &cobra.Command{
+		Use:               "lint",
+		Short:             i18n.T("Check a Modelfile for problems"),
+		Args:              cobra.NoArgs,
+		RunE:              LintHandler,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	lintCmd.Flags().StringP("file", "f", "Modelfile", "Name of the Modelfile")
+	lintCmd.Flags().String("format", "", "Output format (json)")
+	cobra.CheckErr(lintCmd.RegisterFlagCompletionFunc("format", completeValues("json\tPrint problems as JSON")))
 
 	showCmd := &cobra.Command{
-		Use:     "show MODEL",
-		Short:   "Show information for a model",
-		Args:    cobra.ExactArgs(1),
-		PreRunE: checkServerHeartbeat,
-		RunE:    ShowHandler,
+		Use:               "show MODEL",
+		Short:             i18n.T("Show information for a model"),
+		Args:              cobra.ExactArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              ShowHandler,
+		ValidArgsFunction: completeModels(1),
 	}
 
 	showCmd.Flags().Bool("license", false, "Show license of a model")
 	showCmd.Flags().Bool("modelfile", false, "Show Modelfile of a model")
+	showCmd.Flags().Bool("resolve", false, "Reference the blobs of the model by digest in its Modelfile")
 	showCmd.Flags().Bool("parameters", false, "Show parameters of a model")
 	showCmd.Flags().Bool("template", false, "Show template of a model")
 	showCmd.Flags().Bool("system", false, "Show system message of a model")
 
 	runCmd := &cobra.Command{
-		Use:     "run MODEL [PROMPT]",
-		Short:   "Run a model",
-		Args:    cobra.MinimumNArgs(1),
-		PreRunE: checkServerHeartbeat,
-		RunE:    RunHandler,
+		Use:               "run MODEL [PROMPT]",
+		Short:             i18n.T("Run a model"),
+		Args:              cobra.MinimumNArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              RunHandler,
+		ValidArgsFunction: completeModels(1),
 	}
 
 	runCmd.Flags().String("keepalive", "", "Duration to keep a model loaded (e.g. 5m)")
 	runCmd.Flags().Bool("verbose", false, "Show timings for response")
 	runCmd.Flags().Bool("insecure", false, "Use an insecure registry")
 	runCmd.Flags().Bool("nowordwrap", false, "Don't wrap words to the next line automatically")
-	runCmd.Flags().String("format", "", "Response format (e.g. json)")
+	runCmd.Flags().String("format", "", "Response format (e.g. json or a JSON Schema)")
+	cobra.CheckErr(runCmd.RegisterFlagCompletionFunc("format", completeValues("json\tRespond with JSON")))
+	cobra.CheckErr(runCmd.RegisterFlagCompletionFunc("keepalive", completeValues(
+		"5m\tKeep the model loaded for 5 minutes",
+		"1h\tKeep the model loaded for an hour",
+		"0\tUnload the model after the response",
+		"-1\tKeep the model loaded until the server stops",
+	)))
 	serveCmd := &cobra.Command{
-		Use:     "serve",
-		Aliases: []string{"start"},
-		Short:   "Start ollama",
-		Args:    cobra.ExactArgs(0),
-		RunE:    RunServer,
+		Use:               "serve",
+		Aliases:           []string{"start"},
+		Short:             i18n.T("Start ollama"),
+		Args:              cobra.ExactArgs(0),
+		RunE:              RunServer,
+		ValidArgsFunction: cobra.NoFileCompletions,
 	}
 
 	pullCmd := &cobra.Command{
-		Use:     "pull MODEL",
-		Short:   "Pull a model from a registry",
-		Args:    cobra.ExactArgs(1),
-		PreRunE: checkServerHeartbeat,
-		RunE:    PullHandler,
+		Use:               "pull MODEL",
+		Short:             i18n.T("Pull a model from a registry"),
+		Args:              cobra.ExactArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              PullHandler,
+		ValidArgsFunction: cobra.NoFileCompletions,
 	}
 
 	pullCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pullCmd.Flags().Int("concurrency", 0, "Number of connections to download with (default OLLAMA_DOWNLOAD_CONCURRENCY or 16)")
+	cobra.CheckErr(pullCmd.RegisterFlagCompletionFunc("concurrency", cobra.NoFileCompletions))
 
 	pushCmd := &cobra.Command{
-		Use:     "push MODEL",
-		Short:   "Push a model to a registry",
-		Args:    cobra.ExactArgs(1),
-		PreRunE: checkServerHeartbeat,
-		RunE:    PushHandler,
+		Use:               "push MODEL",
+		Short:             i18n.T("Push a model to a registry"),
+		Args:              cobra.ExactArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              PushHandler,
+		ValidArgsFunction: completeModels(1),
 	}
 
 	pushCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pushCmd.Flags().Int("concurrency", 0, "Number of connections to upload with (default OLLAMA_UPLOAD_CONCURRENCY or 64)")
+	cobra.CheckErr(pushCmd.RegisterFlagCompletionFunc("concurrency", cobra.NoFileCompletions))
 
 	listCmd := &cobra.Command{
-		Use:     "list",
-		Aliases: []string{"ls"},
-		Short:   "List models",
-		PreRunE: checkServerHeartbeat,
-		RunE:    ListHandler,
+		Use:               "list",
+		Aliases:           []string{"ls"},
+		Short:             i18n.T("List models"),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              ListHandler,
+		ValidArgsFunction: cobra.NoFileCompletions,
 	}
 
+	listCmd.Flags().String("format", "", "Format the output as json, or with a Go template (e.g. '{{ .Name }} {{ .Metadata.Tags }}')")
+	cobra.CheckErr(listCmd.RegisterFlagCompletionFunc("format", completeValues("json\tList models as JSON")))
+
 	psCmd := &cobra.Command{
-		Use:     "ps",
-		Short:   "List running models",
-		PreRunE: checkServerHeartbeat,
-		RunE:    ListRunningHandler,
-	}
+		Use:               "ps",
+		Short:             i18n.T("List running models"),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              ListRunningHandler,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	psCmd.Flags().Bool("slots", false, "Show what each parallel slot of the models is doing")
+
+	statsCmd := &cobra.Command{
+		Use:               "stats [MODEL]",
+		Short:             i18n.T("Show the throughput, latency and errors of models"),
+		Args:              cobra.MaximumNArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              StatsHandler,
+		ValidArgsFunction: completeModels(1),
+	}
+
+	historyCmd := &cobra.Command{
+		Use:               "history [MODEL]",
+		Short:             i18n.T("Show the requests models served"),
+		Args:              cobra.MaximumNArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              HistoryHandler,
+		ValidArgsFunction: completeModels(1),
+	}
+
+	historyCmd.Flags().Duration("since", 0, "Only show requests served within this duration (e.g. 24h)")
+	historyCmd.Flags().Int("limit", 0, "Maximum number of requests to show (default 100)")
+	cobra.CheckErr(historyCmd.RegisterFlagCompletionFunc("since", completeValues(
+		"1h\tRequests served in the last hour",
+		"24h\tRequests served in the last day",
+		"168h\tRequests served in the last week",
+	)))
+	cobra.CheckErr(historyCmd.RegisterFlagCompletionFunc("limit", cobra.NoFileCompletions))
 
 	copyCmd := &cobra.Command{
-		Use:     "cp SOURCE DESTINATION",
-		Short:   "Copy a model",
-		Args:    cobra.ExactArgs(2),
-		PreRunE: checkServerHeartbeat,
-		RunE:    CopyHandler,
+		Use:               "cp SOURCE DESTINATION",
+		Short:             i18n.T("Copy a model"),
+		Args:              cobra.ExactArgs(2),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              CopyHandler,
+		ValidArgsFunction: completeModels(1),
+	}
+
+	quantizeCmd := &cobra.Command{
+		Use:               "quantize SOURCE DESTINATION",
+		Short:             i18n.T("Quantize a local model to a smaller type"),
+		Args:              cobra.ExactArgs(2),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              QuantizeHandler,
+		ValidArgsFunction: completeModels(1),
+	}
+
+	quantizeCmd.Flags().StringP("quantize", "q", "", "Quantize model to this type, by default the tag of the destination (e.g. q4_K_M)")
+	cobra.CheckErr(quantizeCmd.RegisterFlagCompletionFunc("quantize", completeQuantizationTypes))
+	quantizeCmd.Flags().String("imatrix", "", "Weight quantization with an importance matrix, or calibration text to compute one from")
+	quantizeCmd.Flags().Bool("check", false, "Check the model's perplexity on a short text to catch broken quantizations")
+
+	extractCmd := &cobra.Command{
+		Use:               "extract MODEL",
+		Short:             i18n.T("Write a local model's GGUF files, template and parameters to a directory"),
+		Args:              cobra.ExactArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              ExtractHandler,
+		ValidArgsFunction: completeModels(1),
 	}
 
+	extractCmd.Flags().String("dest", "", "Directory to write the files to, by default the model's name")
+	cobra.CheckErr(extractCmd.RegisterFlagCompletionFunc("dest", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}))
+
+	ggufCmd := &cobra.Command{
+		Use:   "gguf",
+		Short: i18n.T("Read and change the GGUF metadata of a local model"),
+	}
+
+	ggufGetCmd := &cobra.Command{
+		Use:               "get MODEL [KEY...]",
+		Short:             i18n.T("Print a model's GGUF metadata, or the values of keys"),
+		Args:              cobra.MinimumNArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              GGUFGetHandler,
+		ValidArgsFunction: completeModels(1),
+	}
+
+	ggufSetCmd := &cobra.Command{
+		Use:               "set MODEL KEY=VALUE [KEY=VALUE...]",
+		Short:             i18n.T("Set keys of a model's GGUF metadata, e.g. llama.rope.freq_base=500000"),
+		Args:              cobra.MinimumNArgs(2),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              GGUFSetHandler,
+		ValidArgsFunction: completeModels(1),
+	}
+
+	ggufSetCmd.Flags().String("dest", "", "Create the changed model with this name instead of replacing the model")
+	cobra.CheckErr(ggufSetCmd.RegisterFlagCompletionFunc("dest", cobra.NoFileCompletions))
+	ggufCmd.AddCommand(ggufGetCmd, ggufSetCmd)
+
 	deleteCmd := &cobra.Command{
-		Use:     "rm MODEL [MODEL...]",
-		Short:   "Remove a model",
-		Args:    cobra.MinimumNArgs(1),
-		PreRunE: checkServerHeartbeat,
-		RunE:    DeleteHandler,
+		Use:               "rm MODEL [MODEL...]",
+		Short:             i18n.T("Remove a model"),
+		Args:              cobra.MinimumNArgs(1),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              DeleteHandler,
+		ValidArgsFunction: completeModels(0),
 	}
 
+	pruneCmd := &cobra.Command{
+		Use:               "prune",
+		Short:             i18n.T("Remove unused blobs and stale partial downloads"),
+		Args:              cobra.ExactArgs(0),
+		PreRunE:           checkServerHeartbeat,
+		RunE:              PruneHandler,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	pruneCmd.Flags().Bool("dry-run", false, "Report what would be removed without removing it")
+
 	envVars := envconfig.AsMap()
 
-	envs := []envconfig.EnvVar{envVars["OLLAMA_HOST"]}
+	envs := []envconfig.EnvVar{envVars["OLLAMA_HOST"], envVars["OLLAMA_JSON_ERRORS"], envVars["OLLAMA_LANG"], envVars["OLLAMA_THEME"]}
 
 	for _, cmd := range []*cobra.Command{
 		createCmd,
@@ -1377,28 +2351,62 @@ func NewCLI() *cobra.Command {
 		pushCmd,
 		listCmd,
 		psCmd,
+		statsCmd,
+		historyCmd,
 		copyCmd,
+		quantizeCmd,
+		extractCmd,
+		ggufGetCmd,
+		ggufSetCmd,
 		deleteCmd,
+		pruneCmd,
 		serveCmd,
 	} {
 		switch cmd {
 		case runCmd:
-			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_HOST"], envVars["OLLAMA_NOHISTORY"]})
+			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_HOST"], envVars["OLLAMA_JSON_ERRORS"], envVars["OLLAMA_LANG"], envVars["OLLAMA_NOHISTORY"], envVars["OLLAMA_THEME"]})
 		case serveCmd:
 			appendEnvDocs(cmd, []envconfig.EnvVar{
+				envVars["OLLAMA_CONTEXT_LENGTH"],
 				envVars["OLLAMA_DEBUG"],
+				envVars["OLLAMA_DEBUG_ADDR"],
+				envVars["OLLAMA_DOWNLOAD_CONCURRENCY"],
+				envVars["OLLAMA_GC_INTERVAL"],
+				envVars["OLLAMA_GPU_MIN_MEMORY"],
+				envVars["OLLAMA_GPU_POLICY"],
+				envVars["OLLAMA_HEALTH_CHECK_FAILURES"],
+				envVars["OLLAMA_HEALTH_CHECK_INTERVAL"],
+				envVars["OLLAMA_HEALTH_CHECK_TIMEOUT"],
+				envVars["OLLAMA_HISTORY_DIR"],
+				envVars["OLLAMA_HISTORY_RETENTION"],
 				envVars["OLLAMA_HOST"],
 				envVars["OLLAMA_KEEP_ALIVE"],
+				envVars["OLLAMA_LOAD_TIMEOUT"],
 				envVars["OLLAMA_MAX_LOADED_MODELS"],
 				envVars["OLLAMA_MAX_QUEUE"],
+				envVars["OLLAMA_MAX_REQUEST_SIZE"],
+				envVars["OLLAMA_MAX_IMAGES"],
+				envVars["OLLAMA_MAX_IMAGE_SIZE"],
+				envVars["OLLAMA_MAX_PROMPT_TOKENS"],
+				envVars["OLLAMA_MAX_TOKEN_RATE"],
 				envVars["OLLAMA_MODELS"],
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
+				envVars["OLLAMA_RUNNER_LOG_DIR"],
+				envVars["OLLAMA_RUNNER_LOG_LEVEL"],
 				envVars["OLLAMA_SCHED_SPREAD"],
+				envVars["OLLAMA_SLOW_FIRST_TOKEN_THRESHOLD"],
+				envVars["OLLAMA_SLOW_REQUEST_THRESHOLD"],
 				envVars["OLLAMA_TMPDIR"],
+				envVars["OLLAMA_UPLOAD_CONCURRENCY"],
+				envVars["OLLAMA_VISIBLE_GPUS"],
+				envVars["OLLAMA_WARMUP_MODELS"],
+				envVars["OLLAMA_ZSTD_TRANSFERS"],
 				envVars["OLLAMA_FLASH_ATTENTION"],
+				envVars["OLLAMA_KV_CACHE_TYPE"],
 				envVars["OLLAMA_LLM_LIBRARY"],
+				envVars["OLLAMA_CPU_VARIANT"],
 			})
 		default:
 			appendEnvDocs(cmd, envs)
@@ -1408,14 +2416,21 @@ func NewCLI() *cobra.Command {
 	rootCmd.AddCommand(
 		serveCmd,
 		createCmd,
+		lintCmd,
 		showCmd,
 		runCmd,
 		pullCmd,
 		pushCmd,
 		listCmd,
 		psCmd,
+		statsCmd,
+		historyCmd,
 		copyCmd,
+		quantizeCmd,
+		extractCmd,
+		ggufCmd,
 		deleteCmd,
+		pruneCmd,
 	)
 
 	return rootCmd
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/parser"
+)
+
+// completionTimeout is how long completing the names of models may wait for
+// the server, so a shell doesn't hang when it isn't running
+const completionTimeout = 2 * time.Second
+
+// completionFunc completes the arguments or the value of a flag of cmd
+type completionFunc = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completeModels completes the names of local models, described by their
+// sizes, for the first n arguments of a command, or every argument if n is 0
+func completeModels(n int) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if n > 0 && len(args) >= n {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		client, err := api.ClientFromEnvironment()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+		defer cancel()
+
+		models, err := client.List(ctx)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		var completions []string
+		for _, m := range models.Models {
+			if strings.HasPrefix(m.Name, toComplete) && !slices.Contains(args, m.Name) {
+				completions = append(completions, fmt.Sprintf("%s\t%s", m.Name, format.HumanBytes(m.Size)))
+			}
+		}
+
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeQuantizationTypes completes the types models can be quantized to,
+// described by their bits per weight
+func completeQuantizationTypes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var completions []string
+	for _, name := range llm.QuantizationTypes() {
+		t, err := llm.ParseQuantizationType(name)
+		if err != nil {
+			continue
+		}
+
+		completions = append(completions, fmt.Sprintf("%s\t~%g bits per weight", strings.ToLower(name), t.BitsPerWeight()))
+	}
+
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeBuildArgs completes the ARGs declared by the Modelfile of the
+// --file flag, described by their default values
+func completeBuildArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	filename, _ := cmd.Flags().GetString("file")
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer f.Close()
+
+	modelfile, err := parser.ParseFile(f)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var completions []string
+	for _, c := range modelfile.Commands {
+		if c.Name != "arg" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(c.Args, "=")
+		description := "required"
+		if ok {
+			description = "default " + strings.TrimSpace(value)
+		}
+
+		completions = append(completions, fmt.Sprintf("%s=\t%s", strings.TrimSpace(name), description))
+	}
+
+	return completions, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// completeValues completes the values of a flag, each of which may be
+// followed by a tab and its description
+func completeValues(values ...string) completionFunc {
+	return cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp)
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompleteBuildArgs(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "Modelfile")
+	modelfile := "ARG BASE=llama3.2\nARG QUANT\nFROM ${BASE}\n"
+	if err := os.WriteFile(filename, []byte(modelfile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("file", filename, "")
+
+	completions, directive := completeBuildArgs(cmd, nil, "")
+	assert.Equal(t, []string{"BASE=\tdefault llama3.2", "QUANT=\trequired"}, completions)
+	assert.Equal(t, cobra.ShellCompDirectiveNoFileComp|cobra.ShellCompDirectiveNoSpace, directive)
+
+	cmd.Flags().Set("file", filepath.Join(t.TempDir(), "missing"))
+	completions, _ = completeBuildArgs(cmd, nil, "")
+	assert.Empty(t, completions)
+}
+
+func TestCompleteQuantizationTypes(t *testing.T) {
+	completions, _ := completeQuantizationTypes(&cobra.Command{}, nil, "")
+	assert.Contains(t, completions, "q8_0\t~8.5 bits per weight")
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/i18n"
+	"github.com/ollama/ollama/theme"
+)
+
+// Exit codes of the CLI, so scripts can tell its failures apart
+const (
+	ExitError             = 1 // any failure without a code of its own
+	ExitModelNotFound     = 3
+	ExitServerUnreachable = 4
+	ExitOutOfMemory       = 5
+	ExitUnauthorized      = 6
+	ExitCancelled         = 130 // as if interrupted by ctrl+c
+)
+
+var errServerUnreachable = errors.New("could not connect to ollama app, is it running?")
+
+// errorClass is the class of an error printed by the CLI, with its exit code
+type errorClass struct {
+	Name string
+	Code int
+}
+
+var (
+	classError             = errorClass{"error", ExitError}
+	classModelNotFound     = errorClass{"model_not_found", ExitModelNotFound}
+	classServerUnreachable = errorClass{"server_unreachable", ExitServerUnreachable}
+	classOutOfMemory       = errorClass{"out_of_memory", ExitOutOfMemory}
+	classUnauthorized      = errorClass{"unauthorized", ExitUnauthorized}
+	classCancelled         = errorClass{"cancelled", ExitCancelled}
+)
+
+// classify returns the class of err
+func classify(err error) errorClass {
+	var se api.StatusError
+	if errors.As(err, &se) {
+		switch se.StatusCode {
+		case http.StatusNotFound:
+			return classModelNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return classUnauthorized
+		case 499:
+			return classCancelled
+		}
+	}
+
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return classCancelled
+	case errors.Is(err, errServerUnreachable), errors.As(err, &opErr):
+		return classServerUnreachable
+	}
+
+	// errors sent while a response is streamed don't have a status, so
+	// they're told apart by their messages
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "out of GPU memory"), strings.Contains(msg, "requires more system memory"):
+		return classOutOfMemory
+	case strings.HasPrefix(msg, "pull model manifest: file does not exist"):
+		return classModelNotFound
+	case strings.HasPrefix(msg, "unauthorized"):
+		return classUnauthorized
+	}
+
+	return classError
+}
+
+// ExitCode returns the code the CLI exits with after failing with err
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	return classify(err).Code
+}
+
+// WriteError writes err to w, as a JSON object with the class of the error
+// and the code the CLI exits with if OLLAMA_JSON_ERRORS is set
+func WriteError(w io.Writer, err error) {
+	if !envconfig.JSONErrors() {
+		fmt.Fprintln(w, theme.Current().Error.Render(i18n.T("Error:")), i18n.T(err.Error()))
+		return
+	}
+
+	class := classify(err)
+	json.NewEncoder(w).Encode(struct {
+		Error    string `json:"error"`
+		Class    string `json:"class"`
+		ExitCode int    `json:"exit_code"`
+	}{err.Error(), class.Name, class.Code}) //nolint:errcheck
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{errors.New("something went wrong"), ExitError},
+		{api.StatusError{StatusCode: 404, ErrorMessage: `model "llama3" not found, try pulling it first`}, ExitModelNotFound},
+		{errors.New("pull model manifest: file does not exist"), ExitModelNotFound},
+		{errServerUnreachable, ExitServerUnreachable},
+		{&url.Error{Op: "Post", URL: "http://127.0.0.1:11434/api/chat", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, ExitServerUnreachable},
+		{fmt.Errorf("chat: %w", context.Canceled), ExitCancelled},
+		{api.StatusError{StatusCode: 499, ErrorMessage: "request canceled"}, ExitCancelled},
+		{errors.New("llama runner: out of GPU memory"), ExitOutOfMemory},
+		{api.StatusError{StatusCode: 500, ErrorMessage: "model requires more system memory (12 GiB) than is available (8 GiB)"}, ExitOutOfMemory},
+		{api.StatusError{StatusCode: 401, ErrorMessage: "unauthorized"}, ExitUnauthorized},
+		{errors.New("unauthorized: access denied"), ExitUnauthorized},
+	}
+
+	for _, tt := range cases {
+		assert.Equal(t, tt.want, ExitCode(tt.err), "%v", tt.err)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	err := api.StatusError{StatusCode: 404, ErrorMessage: "model 'llama3' not found"}
+
+	t.Setenv("OLLAMA_JSON_ERRORS", "")
+	var b bytes.Buffer
+	WriteError(&b, err)
+	assert.Equal(t, "Error: model 'llama3' not found\n", b.String())
+
+	t.Setenv("OLLAMA_JSON_ERRORS", "1")
+	b.Reset()
+	WriteError(&b, err)
+	assert.JSONEq(t, `{"error":"model 'llama3' not found","class":"model_not_found","exit_code":3}`, b.String())
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"slices"
@@ -17,6 +18,8 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/i18n"
 	"github.com/ollama/ollama/parser"
 	"github.com/ollama/ollama/progress"
 	"github.com/ollama/ollama/readline"
@@ -32,7 +35,7 @@ const (
 )
 
 func loadModel(cmd *cobra.Command, opts *runOptions) error {
-	p := progress.NewProgress(os.Stderr)
+	p := newProgress(cmd)
 	defer p.StopAndClear()
 
 	spinner := progress.NewSpinner("")
@@ -53,89 +56,96 @@ func loadModel(cmd *cobra.Command, opts *runOptions) error {
 
 func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 	usage := func() {
-		fmt.Fprintln(os.Stderr, "Available Commands:")
-		fmt.Fprintln(os.Stderr, "  /set            Set session variables")
-		fmt.Fprintln(os.Stderr, "  /show           Show model information")
-		fmt.Fprintln(os.Stderr, "  /load <model>   Load a session or model")
-		fmt.Fprintln(os.Stderr, "  /save <model>   Save your current session")
-		fmt.Fprintln(os.Stderr, "  /clear          Clear session context")
-		fmt.Fprintln(os.Stderr, "  /bye            Exit")
-		fmt.Fprintln(os.Stderr, "  /?, /help       Help for a command")
-		fmt.Fprintln(os.Stderr, "  /? shortcuts    Help for keyboard shortcuts")
+		fmt.Fprintln(os.Stderr, i18n.T("Available Commands:"))
+		fmt.Fprintln(os.Stderr, "  /set            "+i18n.T("Set session variables"))
+		fmt.Fprintln(os.Stderr, "  /show           "+i18n.T("Show model information"))
+		fmt.Fprintln(os.Stderr, "  /load <model>   "+i18n.T("Load a session or model"))
+		fmt.Fprintln(os.Stderr, "  /save <model>   "+i18n.T("Save your current session"))
+		fmt.Fprintln(os.Stderr, "  /clear          "+i18n.T("Clear session context"))
+		if opts.MultiModal {
+			fmt.Fprintln(os.Stderr, "  /paste          "+i18n.T("Attach an image from the clipboard to the next message"))
+		}
+		fmt.Fprintln(os.Stderr, "  !<command>      "+i18n.T("Run a shell command and add its output to the next message"))
+		fmt.Fprintln(os.Stderr, "  /bye            "+i18n.T("Exit"))
+		fmt.Fprintln(os.Stderr, "  /?, /help       "+i18n.T("Help for a command"))
+		fmt.Fprintln(os.Stderr, "  /? shortcuts    "+i18n.T("Help for keyboard shortcuts"))
 		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "Use \"\"\" to begin a multi-line message.")
+		fmt.Fprintln(os.Stderr, i18n.T("Use \"\"\" to begin a multi-line message."))
 
 		if opts.MultiModal {
-			fmt.Fprintf(os.Stderr, "Use %s to include .jpg or .png images.\n", filepath.FromSlash("/path/to/file"))
+			fmt.Fprintln(os.Stderr, i18n.Sprintf("Use %s to include .jpg or .png images.", filepath.FromSlash("/path/to/file")))
+			fmt.Fprintln(os.Stderr, i18n.T("Paste an image, or use /paste, to include it from the clipboard."))
 		}
 
 		fmt.Fprintln(os.Stderr, "")
 	}
 
 	usageSet := func() {
-		fmt.Fprintln(os.Stderr, "Available Commands:")
-		fmt.Fprintln(os.Stderr, "  /set parameter ...     Set a parameter")
-		fmt.Fprintln(os.Stderr, "  /set system <string>   Set system message")
-		fmt.Fprintln(os.Stderr, "  /set history           Enable history")
-		fmt.Fprintln(os.Stderr, "  /set nohistory         Disable history")
-		fmt.Fprintln(os.Stderr, "  /set wordwrap          Enable wordwrap")
-		fmt.Fprintln(os.Stderr, "  /set nowordwrap        Disable wordwrap")
-		fmt.Fprintln(os.Stderr, "  /set format json       Enable JSON mode")
-		fmt.Fprintln(os.Stderr, "  /set noformat          Disable formatting")
-		fmt.Fprintln(os.Stderr, "  /set verbose           Show LLM stats")
-		fmt.Fprintln(os.Stderr, "  /set quiet             Disable LLM stats")
+		fmt.Fprintln(os.Stderr, i18n.T("Available Commands:"))
+		fmt.Fprintln(os.Stderr, "  /set parameter ...     "+i18n.T("Set a parameter"))
+		fmt.Fprintln(os.Stderr, "  /set system <string>   "+i18n.T("Set system message"))
+		fmt.Fprintln(os.Stderr, "  /set history           "+i18n.T("Enable history"))
+		fmt.Fprintln(os.Stderr, "  /set nohistory         "+i18n.T("Disable history"))
+		fmt.Fprintln(os.Stderr, "  /set wordwrap          "+i18n.T("Enable wordwrap"))
+		fmt.Fprintln(os.Stderr, "  /set nowordwrap        "+i18n.T("Disable wordwrap"))
+		fmt.Fprintln(os.Stderr, "  /set shelloutput       "+i18n.T("Add the output of !commands to the next message"))
+		fmt.Fprintln(os.Stderr, "  /set noshelloutput     "+i18n.T("Only show the output of !commands"))
+		fmt.Fprintln(os.Stderr, "  /set format json       "+i18n.T("Enable JSON mode"))
+		fmt.Fprintln(os.Stderr, "  /set noformat          "+i18n.T("Disable formatting"))
+		fmt.Fprintln(os.Stderr, "  /set verbose           "+i18n.T("Show LLM stats"))
+		fmt.Fprintln(os.Stderr, "  /set quiet             "+i18n.T("Disable LLM stats"))
 		fmt.Fprintln(os.Stderr, "")
 	}
 
 	usageShortcuts := func() {
-		fmt.Fprintln(os.Stderr, "Available keyboard shortcuts:")
-		fmt.Fprintln(os.Stderr, "  Ctrl + a            Move to the beginning of the line (Home)")
-		fmt.Fprintln(os.Stderr, "  Ctrl + e            Move to the end of the line (End)")
-		fmt.Fprintln(os.Stderr, "   Alt + b            Move back (left) one word")
-		fmt.Fprintln(os.Stderr, "   Alt + f            Move forward (right) one word")
-		fmt.Fprintln(os.Stderr, "  Ctrl + k            Delete the sentence after the cursor")
-		fmt.Fprintln(os.Stderr, "  Ctrl + u            Delete the sentence before the cursor")
-		fmt.Fprintln(os.Stderr, "  Ctrl + w            Delete the word before the cursor")
+		fmt.Fprintln(os.Stderr, i18n.T("Available keyboard shortcuts:"))
+		fmt.Fprintln(os.Stderr, "  Ctrl + a            "+i18n.T("Move to the beginning of the line (Home)"))
+		fmt.Fprintln(os.Stderr, "  Ctrl + e            "+i18n.T("Move to the end of the line (End)"))
+		fmt.Fprintln(os.Stderr, "   Alt + b            "+i18n.T("Move back (left) one word"))
+		fmt.Fprintln(os.Stderr, "   Alt + f            "+i18n.T("Move forward (right) one word"))
+		fmt.Fprintln(os.Stderr, "  Ctrl + k            "+i18n.T("Delete the sentence after the cursor"))
+		fmt.Fprintln(os.Stderr, "  Ctrl + u            "+i18n.T("Delete the sentence before the cursor"))
+		fmt.Fprintln(os.Stderr, "  Ctrl + w            "+i18n.T("Delete the word before the cursor"))
 		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "  Ctrl + l            Clear the screen")
-		fmt.Fprintln(os.Stderr, "  Ctrl + c            Stop the model from responding")
-		fmt.Fprintln(os.Stderr, "  Ctrl + d            Exit ollama (/bye)")
+		fmt.Fprintln(os.Stderr, "  Ctrl + l            "+i18n.T("Clear the screen"))
+		fmt.Fprintln(os.Stderr, "  Ctrl + c            "+i18n.T("Stop the model from responding"))
+		fmt.Fprintln(os.Stderr, "  Ctrl + d            "+i18n.T("Exit ollama (/bye)"))
 		fmt.Fprintln(os.Stderr, "")
 	}
 
 	usageShow := func() {
-		fmt.Fprintln(os.Stderr, "Available Commands:")
-		fmt.Fprintln(os.Stderr, "  /show info         Show details for this model")
-		fmt.Fprintln(os.Stderr, "  /show license      Show model license")
-		fmt.Fprintln(os.Stderr, "  /show modelfile    Show Modelfile for this model")
-		fmt.Fprintln(os.Stderr, "  /show parameters   Show parameters for this model")
-		fmt.Fprintln(os.Stderr, "  /show system       Show system message")
-		fmt.Fprintln(os.Stderr, "  /show template     Show prompt template")
+		fmt.Fprintln(os.Stderr, i18n.T("Available Commands:"))
+		fmt.Fprintln(os.Stderr, "  /show info         "+i18n.T("Show details for this model"))
+		fmt.Fprintln(os.Stderr, "  /show license      "+i18n.T("Show model license"))
+		fmt.Fprintln(os.Stderr, "  /show modelfile    "+i18n.T("Show Modelfile for this model"))
+		fmt.Fprintln(os.Stderr, "  /show parameters   "+i18n.T("Show parameters for this model"))
+		fmt.Fprintln(os.Stderr, "  /show system       "+i18n.T("Show system message"))
+		fmt.Fprintln(os.Stderr, "  /show template     "+i18n.T("Show prompt template"))
 		fmt.Fprintln(os.Stderr, "")
 	}
 
 	// only list out the most common parameters
 	usageParameters := func() {
-		fmt.Fprintln(os.Stderr, "Available Parameters:")
-		fmt.Fprintln(os.Stderr, "  /set parameter seed <int>             Random number seed")
-		fmt.Fprintln(os.Stderr, "  /set parameter num_predict <int>      Max number of tokens to predict")
-		fmt.Fprintln(os.Stderr, "  /set parameter top_k <int>            Pick from top k num of tokens")
-		fmt.Fprintln(os.Stderr, "  /set parameter top_p <float>          Pick token based on sum of probabilities")
-		fmt.Fprintln(os.Stderr, "  /set parameter min_p <float>          Pick token based on top token probability * min_p")
-		fmt.Fprintln(os.Stderr, "  /set parameter num_ctx <int>          Set the context size")
-		fmt.Fprintln(os.Stderr, "  /set parameter temperature <float>    Set creativity level")
-		fmt.Fprintln(os.Stderr, "  /set parameter repeat_penalty <float> How strongly to penalize repetitions")
-		fmt.Fprintln(os.Stderr, "  /set parameter repeat_last_n <int>    Set how far back to look for repetitions")
-		fmt.Fprintln(os.Stderr, "  /set parameter num_gpu <int>          The number of layers to send to the GPU")
-		fmt.Fprintln(os.Stderr, "  /set parameter stop <string> <string> ...   Set the stop parameters")
+		fmt.Fprintln(os.Stderr, i18n.T("Available Parameters:"))
+		fmt.Fprintln(os.Stderr, "  /set parameter seed <int>             "+i18n.T("Random number seed"))
+		fmt.Fprintln(os.Stderr, "  /set parameter num_predict <int>      "+i18n.T("Max number of tokens to predict"))
+		fmt.Fprintln(os.Stderr, "  /set parameter top_k <int>            "+i18n.T("Pick from top k num of tokens"))
+		fmt.Fprintln(os.Stderr, "  /set parameter top_p <float>          "+i18n.T("Pick token based on sum of probabilities"))
+		fmt.Fprintln(os.Stderr, "  /set parameter min_p <float>          "+i18n.T("Pick token based on top token probability * min_p"))
+		fmt.Fprintln(os.Stderr, "  /set parameter num_ctx <int>          "+i18n.T("Set the context size"))
+		fmt.Fprintln(os.Stderr, "  /set parameter temperature <float>    "+i18n.T("Set creativity level"))
+		fmt.Fprintln(os.Stderr, "  /set parameter repeat_penalty <float> "+i18n.T("How strongly to penalize repetitions"))
+		fmt.Fprintln(os.Stderr, "  /set parameter repeat_last_n <int>    "+i18n.T("Set how far back to look for repetitions"))
+		fmt.Fprintln(os.Stderr, "  /set parameter num_gpu <int>          "+i18n.T("The number of layers to send to the GPU"))
+		fmt.Fprintln(os.Stderr, "  /set parameter stop <string> <string> ...   "+i18n.T("Set the stop parameters"))
 		fmt.Fprintln(os.Stderr, "")
 	}
 
 	scanner, err := readline.New(readline.Prompt{
 		Prompt:         ">>> ",
 		AltPrompt:      "... ",
-		Placeholder:    "Send a message (/? for help)",
-		AltPlaceholder: `Use """ to end multi-line input`,
+		Placeholder:    i18n.T("Send a message (/? for help)"),
+		AltPlaceholder: i18n.T(`Use """ to end multi-line input`),
 	})
 	if err != nil {
 		return err
@@ -145,6 +155,27 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 		scanner.HistoryDisable()
 	}
 
+	// the output of !commands is added to the next message, unless
+	// shellOutput is disabled
+	var shell strings.Builder
+	shellOutput := true
+
+	// images pasted from the clipboard are attached to the next message
+	var pasted []api.ImageData
+	if opts.MultiModal {
+		// terminals paste nothing when the clipboard holds an image, so the
+		// image is read from the clipboard itself
+		scanner.EmptyPaste = func() string {
+			data, err := clipboardImage(cmd.Context())
+			if err != nil {
+				return ""
+			}
+
+			pasted = append(pasted, data)
+			return clipboardMarker(len(pasted)) + " "
+		}
+	}
+
 	fmt.Print(readline.StartBracketedPaste)
 	defer fmt.Printf(readline.EndBracketedPaste)
 
@@ -249,8 +280,25 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 			}
 			fmt.Printf("Created new model '%s'\n", args[1])
 			continue
+		case strings.HasPrefix(line, "/paste"):
+			if !opts.MultiModal {
+				fmt.Println(i18n.T("This model doesn't support images."))
+				continue
+			}
+
+			data, err := clipboardImage(cmd.Context())
+			if err != nil {
+				fmt.Println(i18n.Sprintf("Couldn't paste image: %v", err))
+				continue
+			}
+
+			pasted = append(pasted, data)
+			fmt.Println(i18n.Sprintf("Added image %s from the clipboard to the next message.", clipboardMarker(len(pasted))))
+			continue
 		case strings.HasPrefix(line, "/clear"):
 			opts.Messages = []api.Message{}
+			pasted = nil
+			shell.Reset()
 			if opts.System != "" {
 				newMessage := api.Message{Role: "system", Content: opts.System}
 				opts.Messages = append(opts.Messages, newMessage)
@@ -271,6 +319,13 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 				case "nowordwrap":
 					opts.WordWrap = false
 					fmt.Println("Set 'nowordwrap' mode.")
+				case "shelloutput":
+					shellOutput = true
+					fmt.Println("Set 'shelloutput' mode.")
+				case "noshelloutput":
+					shellOutput = false
+					shell.Reset()
+					fmt.Println("Set 'noshelloutput' mode.")
 				case "verbose":
 					if err := cmd.Flags().Set("verbose", "true"); err != nil {
 						return err
@@ -345,7 +400,7 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 					sb.Reset()
 					continue
 				default:
-					fmt.Printf("Unknown command '/set %s'. Type /? for help\n", args[1])
+					fmt.Println(i18n.Sprintf("Unknown command '/set %s'. Type /? for help", args[1]))
 				}
 			} else {
 				usageSet()
@@ -410,7 +465,7 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 						fmt.Println("No prompt template was specified for this model.")
 					}
 				default:
-					fmt.Printf("Unknown command '/show %s'. Type /? for help\n", args[1])
+					fmt.Println(i18n.Sprintf("Unknown command '/show %s'. Type /? for help", args[1]))
 				}
 			} else {
 				usageShow()
@@ -431,6 +486,30 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 			}
 		case strings.HasPrefix(line, "/exit"), strings.HasPrefix(line, "/bye"):
 			return nil
+		// a ! line in the middle of a message, or pasted, is part of it
+		case sb.Len() == 0 && !scanner.Pasted() && line == "!":
+			fmt.Println(i18n.T("Use !<command> to run a shell command."))
+			continue
+		case sb.Len() == 0 && !scanner.Pasted() && isShellCommand(line):
+			command := strings.TrimSpace(strings.TrimPrefix(line, "!"))
+
+			output, truncated, err := runShellCommand(cmd.Context(), command)
+			var exitErr *exec.ExitError
+			switch {
+			case errors.As(err, &exitErr):
+				fmt.Fprintln(os.Stderr, i18n.Sprintf("Command exited with status %d.", exitErr.ExitCode()))
+			case err != nil:
+				fmt.Fprintln(os.Stderr, i18n.Sprintf("Couldn't run command: %v", err))
+				continue
+			}
+
+			if shellOutput {
+				shell.WriteString(formatShellOutput(command, output))
+				if truncated {
+					fmt.Fprintln(os.Stderr, i18n.Sprintf("Only the first %s of the output will be added to the next message.", format.HumanBytes(maxShellOutput)))
+				}
+			}
+			continue
 		case strings.HasPrefix(line, "/"):
 			args := strings.Fields(line)
 			isFile := false
@@ -445,7 +524,7 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 			}
 
 			if !isFile {
-				fmt.Printf("Unknown command '%s'. Type /? for help\n", args[0])
+				fmt.Println(i18n.Sprintf("Unknown command '%s'. Type /? for help", args[0]))
 				continue
 			}
 
@@ -463,6 +542,12 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 					return err
 				}
 
+				if len(pasted) > 0 {
+					msg = removeClipboardMarkers(msg, len(pasted))
+					images = append(images, pasted...)
+					pasted = nil
+				}
+
 				// clear all previous images for better responses
 				if len(images) > 0 {
 					for i := range opts.Messages {
@@ -474,6 +559,11 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 				newMessage.Images = images
 			}
 
+			if shell.Len() > 0 {
+				newMessage.Content = shell.String() + newMessage.Content
+				shell.Reset()
+			}
+
 			opts.Messages = append(opts.Messages, newMessage)
 
 			assistant, err := chat(cmd, opts)
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxShellOutput is how much of the output of a shell command run with
+// !command is added to the next message, so a large output doesn't
+// overflow the model's context
+const maxShellOutput = 32 << 10
+
+// cappedBuffer keeps the first max bytes written to it and discards the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if remaining := b.max - b.Len(); len(p) > remaining {
+		p = p[:max(remaining, 0)]
+		b.truncated = true
+	}
+
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+// runShellCommand runs command with the user's shell, showing its output
+// as it runs, and returns up to maxShellOutput bytes of its stdout. The
+// command's stdin is empty, as readline keeps reading the terminal and would
+// take keys typed for it.
+func runShellCommand(ctx context.Context, command string) (output string, truncated bool, err error) {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.CommandContext(ctx, cmp.Or(os.Getenv("COMSPEC"), "cmd.exe"), "/C", command)
+	} else {
+		c = exec.CommandContext(ctx, cmp.Or(os.Getenv("SHELL"), "/bin/sh"), "-c", command)
+	}
+
+	out := cappedBuffer{max: maxShellOutput}
+	c.Stdout = io.MultiWriter(os.Stdout, &out)
+	c.Stderr = os.Stderr
+
+	err = c.Run()
+	return out.String(), out.truncated, err
+}
+
+// isShellCommand reports whether line runs a shell command, such as
+// "!ls -l". The command must follow the ! directly, so text such as
+// "!!! urgent" or "! really" is still sent to the model.
+func isShellCommand(line string) bool {
+	command, ok := strings.CutPrefix(line, "!")
+	if !ok || command == "" {
+		return false
+	}
+
+	r, _ := utf8.DecodeRuneInString(command)
+	return r != '!' && !unicode.IsSpace(r)
+}
+
+// formatShellOutput formats the output of command to be added to a message
+func formatShellOutput(command, output string) string {
+	return fmt.Sprintf("```\n$ %s\n%s\n```\n\n", command, strings.TrimRight(output, "\n"))
+}
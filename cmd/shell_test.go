@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	b := cappedBuffer{max: 8}
+
+	n, err := fmt.Fprint(&b, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.False(t, b.truncated)
+
+	n, err = fmt.Fprint(&b, " world")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.True(t, b.truncated)
+	assert.Equal(t, "hello wo", b.String())
+
+	n, err = fmt.Fprint(&b, "!")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "hello wo", b.String())
+}
+
+func TestFormatShellOutput(t *testing.T) {
+	assert.Equal(t, "```\n$ echo hi\nhi\n```\n\n", formatShellOutput("echo hi", "hi\n"))
+}
+
+func TestIsShellCommand(t *testing.T) {
+	for line, expect := range map[string]bool{
+		"!ls -l":      true,
+		"!git status": true,
+		"!":           false,
+		"!!! urgent":  false,
+		"! really":    false,
+		"hi!":         false,
+		"":            false,
+	} {
+		assert.Equal(t, expect, isShellCommand(line), line)
+	}
+}
@@ -37,7 +37,10 @@ func (Parameters) KV(t *Tokenizer) llm.KV {
 
 	for _, sv := range t.SpecialVocabulary {
 		kv[fmt.Sprintf("tokenizer.ggml.%s_token_id", sv.Key())] = uint32(sv.ID)
-		kv[fmt.Sprintf("tokenizer.ggml.add_%s_token", sv.Key())] = sv.AddToken
+		// end of turn tokens are only ever generated, never added
+		if sv.Type != "eot" {
+			kv[fmt.Sprintf("tokenizer.ggml.add_%s_token", sv.Key())] = sv.AddToken
+		}
 	}
 
 	return kv
@@ -49,8 +52,8 @@ func (Parameters) specialTokenTypes() []string {
 	}
 }
 
-func (Parameters) writeFile(ws io.WriteSeeker, kv llm.KV, ts []llm.Tensor) error {
-	return llm.WriteGGUF(ws, kv, ts)
+func (Parameters) writeFile(w io.Writer, kv llm.KV, ts []llm.Tensor) error {
+	return llm.WriteGGUF(w, kv, ts)
 }
 
 type Converter interface {
@@ -63,26 +66,77 @@ type Converter interface {
 	tensorName(string) string
 	// specialTokenTypes returns any special token types the model uses
 	specialTokenTypes() []string
-	writeFile(io.WriteSeeker, llm.KV, []llm.Tensor) error
+	writeFile(io.Writer, llm.KV, []llm.Tensor) error
+}
+
+// headConverter is implemented by converters of models with a sequence
+// classification head. llama.cpp can't load the head so it's written to its
+// own file.
+type headConverter interface {
+	// headKV maps parameters to classifier key-values
+	headKV(*Tokenizer) llm.KV
+	// headTensors maps input tensors to classifier tensors
+	headTensors([]Tensor) []llm.Tensor
 }
 
-// Convert writes an Ollama compatible model to the provided io.WriteSeeker based on configurations
-// and files it finds in the input path.
+// ErrNoHead is returned by ConvertHead for models without a classification head
+var ErrNoHead = errors.New("model has no classification head")
+
+// Convert writes an Ollama compatible model to the provided io.Writer based on configurations
+// and files it finds in the input path. The tensors are converted one at a time as they're
+// written so w can be a stream. Weights are stored as F16, or as BF16 if bf16 is
+// set, which keeps the range of BF16 checkpoints. FP8 weights are dequantized as
+// ggml has no FP8 type.
 // Supported input model formats include safetensors.
 // Supported input tokenizers files include tokenizer.json (preferred) and tokenizer.model.
-func Convert(fsys fs.FS, ws io.WriteSeeker) error {
-	bts, err := fs.ReadFile(fsys, "config.json")
+func Convert(fsys fs.FS, w io.Writer, bf16 bool) error {
+	conv, t, ts, err := parse(fsys)
 	if err != nil {
 		return err
 	}
 
+	kv := conv.KV(t)
+	if bf16 {
+		for _, t := range ts {
+			t.SetBF16(true)
+		}
+
+		kv["general.file_type"] = uint32(32)
+	}
+
+	return conv.writeFile(w, kv, conv.Tensors(ts))
+}
+
+// ConvertHead writes the sequence classification head of the model in the
+// provided path, such as the score of a cross-encoder reranker, to the
+// provided io.Writer. It returns ErrNoHead if the model doesn't have one.
+func ConvertHead(fsys fs.FS, w io.Writer) error {
+	conv, t, ts, err := parse(fsys)
+	if err != nil {
+		return err
+	}
+
+	head, ok := conv.(headConverter)
+	if !ok {
+		return ErrNoHead
+	}
+
+	return conv.writeFile(w, head.headKV(t), head.headTensors(ts))
+}
+
+func parse(fsys fs.FS) (Converter, *Tokenizer, []Tensor, error) {
+	bts, err := fs.ReadFile(fsys, "config.json")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	var p Parameters
 	if err := json.Unmarshal(bts, &p); err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	if len(p.Architectures) < 1 {
-		return errors.New("unknown architecture")
+		return nil, nil, nil, errors.New("unknown architecture")
 	}
 
 	var conv Converter
@@ -93,19 +147,29 @@ func Convert(fsys fs.FS, ws io.WriteSeeker) error {
 		conv = &mixtral{}
 	case "GemmaForCausalLM":
 		conv = &gemma{}
+	case "Gemma2ForCausalLM":
+		conv = &gemma2{}
 	case "Phi3ForCausalLM":
 		conv = &phi3{}
+	case "Qwen2ForCausalLM":
+		conv = &qwen2{}
+	case "Qwen2MoeForCausalLM":
+		conv = &qwen2moe{}
+	case "DeepseekV2ForCausalLM":
+		conv = &deepseek2{}
+	case "BertForSequenceClassification", "XLMRobertaForSequenceClassification":
+		conv = &bert{}
 	default:
-		return errors.New("unsupported architecture")
+		return nil, nil, nil, errors.New("unsupported architecture")
 	}
 
 	if err := json.Unmarshal(bts, conv); err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	t, err := parseTokenizer(fsys, conv.specialTokenTypes())
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	if vocabSize := int(p.VocabSize); vocabSize > len(t.Vocabulary.Tokens) {
@@ -121,8 +185,8 @@ func Convert(fsys fs.FS, ws io.WriteSeeker) error {
 
 	ts, err := parseTensors(fsys)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
-	return conv.writeFile(ws, conv.KV(t), conv.Tensors(ts))
+	return conv, t, ts, nil
 }
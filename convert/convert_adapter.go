@@ -0,0 +1,161 @@
+package convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// AdapterParameters are the parameters of a Hugging Face PEFT adapter, from
+// its adapter_config.json
+type AdapterParameters struct {
+	PeftType string  `json:"peft_type"`
+	Rank     uint32  `json:"r"`
+	Alpha    float32 `json:"lora_alpha"`
+	UseDora  bool    `json:"use_dora"`
+}
+
+// ErrNotAdapter is returned by ConvertAdapter for files which aren't a PEFT
+// adapter
+var ErrNotAdapter = errors.New("not a PEFT adapter")
+
+// ConvertAdapter writes the PEFT LoRA adapter in fsys, adapter_model.safetensors
+// and adapter_config.json, to w as a GGUF adapter for base. The tensors are
+// named and laid out like base's, and their shapes are checked against it.
+func ConvertAdapter(fsys fs.FS, w io.Writer, base *llm.GGML) error {
+	bts, err := fs.ReadFile(fsys, "adapter_config.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotAdapter
+	} else if err != nil {
+		return err
+	}
+
+	var p AdapterParameters
+	if err := json.Unmarshal(bts, &p); err != nil {
+		return err
+	}
+
+	if p.PeftType != "LORA" {
+		return fmt.Errorf("unsupported adapter type %s", p.PeftType)
+	} else if p.UseDora {
+		return errors.New("DoRA adapters are not supported")
+	} else if p.Rank == 0 {
+		return errors.New("adapter has no rank")
+	}
+
+	kv := base.KV()
+	arch := kv.Architecture()
+
+	var conv Converter
+	switch arch {
+	case "llama":
+		heads := uint32(kv.HeadCount())
+		headsKV := heads
+		if _, ok := kv["llama.attention.head_count_kv"]; ok {
+			headsKV = uint32(kv.HeadCountKV())
+		}
+
+		conv = &llama{NumAttentionHeads: heads, NumKeyValueHeads: headsKV}
+	case "gemma":
+		conv = &gemma{}
+	case "gemma2":
+		conv = &gemma2{}
+	case "qwen2":
+		conv = &qwen2{}
+	case "phi3":
+		conv = &phi3{}
+	default:
+		return fmt.Errorf("adapters for %s models are not supported", arch)
+	}
+
+	ts, err := parseAdapterTensors(fsys)
+	if err != nil {
+		return err
+	}
+
+	shapes := make(map[string][]uint64)
+	for _, t := range base.Tensors().Items {
+		shapes[t.Name] = t.Shape
+	}
+
+	var out []llm.Tensor
+	for _, t := range ts {
+		module, ab, err := loraModule(t.Name())
+		if err != nil {
+			return err
+		}
+
+		name := conv.tensorName(module)
+		shape, ok := shapes[name]
+		if !ok {
+			return fmt.Errorf("adapter tensor %s is for %s, which the model doesn't have", t.Name(), name)
+		}
+
+		// lora_a is [rank, in] and lora_b is [out, rank] where the model's
+		// tensor is [out, in], which ggml orders as [in, out]
+		expect := []uint64{uint64(p.Rank), shape[0]}
+		if ab == "b" {
+			expect = []uint64{shape[1], uint64(p.Rank)}
+		}
+
+		if !slices.Equal(t.Shape(), expect) {
+			return fmt.Errorf("adapter tensor %s has shape %v, expected %v for rank %d", t.Name(), t.Shape(), expect, p.Rank)
+		}
+
+		// the outputs of llama's attention queries and keys are permuted by the
+		// model converter so lora_b has to be too
+		if l, ok := conv.(*llama); ok && ab == "b" &&
+			(strings.HasSuffix(module, "q_proj.weight") || strings.HasSuffix(module, "k_proj.weight")) {
+			t.SetRepacker(func(_ string, data []float32, shape []uint64) ([]float32, error) {
+				return l.repack(module, data, shape)
+			})
+		}
+
+		out = append(out, llm.Tensor{
+			Name:     name + ".lora_" + ab,
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return llm.WriteGGUF(w, llm.KV{
+		"general.architecture": arch,
+		"general.type":         "adapter",
+		"general.file_type":    uint32(1),
+		"adapter.type":         "lora",
+		"adapter.lora.alpha":   p.Alpha,
+	}, out)
+}
+
+func parseAdapterTensors(fsys fs.FS) ([]Tensor, error) {
+	if _, err := fs.Stat(fsys, "adapter_model.safetensors"); err == nil {
+		return parseSafetensors(fsys, "adapter_model.safetensors")
+	}
+
+	if _, err := fs.Stat(fsys, "adapter_model.bin"); err == nil {
+		return parseTorch(fsys, "adapter_model.bin")
+	}
+
+	return nil, errors.New("adapter has no adapter_model.safetensors or adapter_model.bin")
+}
+
+// loraModule returns the name of the weight a PEFT LoRA tensor, e.g.
+// base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight, applies to
+// and whether it's lora_a or lora_b
+func loraModule(name string) (string, string, error) {
+	module := strings.TrimPrefix(name, "base_model.model.")
+	if s, ok := strings.CutSuffix(module, ".lora_A.weight"); ok {
+		return s + ".weight", "a", nil
+	} else if s, ok := strings.CutSuffix(module, ".lora_B.weight"); ok {
+		return s + ".weight", "b", nil
+	}
+
+	return "", "", fmt.Errorf("unsupported adapter tensor %s", name)
+}
@@ -0,0 +1,202 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/x448/float16"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// adapterBase returns a llama model with two attention heads and 8x8 query and
+// value weights
+func adapterBase(t *testing.T, arch string) *llm.GGML {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var ts []llm.Tensor
+	for _, name := range []string{"blk.0.attn_q.weight", "blk.0.attn_v.weight"} {
+		ts = append(ts, llm.Tensor{Name: name, Kind: 0, Shape: []uint64{8, 8}, WriterTo: bytes.NewReader(make([]byte, 8*8*4))})
+	}
+
+	if err := llm.WriteGGUF(f, llm.KV{
+		"general.architecture":            arch,
+		arch + ".attention.head_count":    uint32(2),
+		arch + ".attention.head_count_kv": uint32(2),
+	}, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	ggml, _, err := llm.DecodeGGML(f, math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ggml
+}
+
+func writeAdapter(t *testing.T, config map[string]any, ts []testTensor) string {
+	t.Helper()
+
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "adapter_config.json"), config)
+	writeSafetensors(t, p, ts)
+	if err := os.Rename(filepath.Join(p, "model.safetensors"), filepath.Join(p, "adapter_model.safetensors")); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// rows returns n rows of rank values, each the row's index
+func rows(n, rank int) []float32 {
+	var fs []float32
+	for i := range n {
+		for range rank {
+			fs = append(fs, float32(i))
+		}
+	}
+
+	return fs
+}
+
+func TestConvertAdapter(t *testing.T) {
+	p := writeAdapter(t, map[string]any{"peft_type": "LORA", "r": 2, "lora_alpha": 16}, []testTensor{
+		{"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight", []uint64{2, 8}, make([]float32, 16)},
+		{"base_model.model.model.layers.0.self_attn.q_proj.lora_B.weight", []uint64{8, 2}, rows(8, 2)},
+		{"base_model.model.model.layers.0.self_attn.v_proj.lora_A.weight", []uint64{2, 8}, make([]float32, 16)},
+		{"base_model.model.model.layers.0.self_attn.v_proj.lora_B.weight", []uint64{8, 2}, rows(8, 2)},
+	})
+
+	f, err := os.CreateTemp(t.TempDir(), "lora")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ConvertAdapter(os.DirFS(p), f, adapterBase(t, "llama")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	ggml, _, err := llm.DecodeGGML(f, math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv := ggml.KV()
+	if kv.Architecture() != "llama" || kv.Kind() != "adapter" || kv["adapter.type"] != "lora" || kv["adapter.lora.alpha"] != float32(16) {
+		t.Errorf("unexpected metadata %v", kv)
+	}
+
+	tensors := ggml.Tensors()
+	shapes := make(map[string][]uint64)
+	for _, tensor := range tensors.Items {
+		shapes[tensor.Name] = tensor.Shape
+	}
+
+	// shapes are in ggml's order
+	expect := map[string][]uint64{
+		"blk.0.attn_q.weight.lora_a": {8, 2},
+		"blk.0.attn_q.weight.lora_b": {2, 8},
+		"blk.0.attn_v.weight.lora_a": {8, 2},
+		"blk.0.attn_v.weight.lora_b": {2, 8},
+	}
+
+	if len(shapes) != len(expect) {
+		t.Fatalf("expected tensors %v, got %v", expect, shapes)
+	}
+
+	for name, shape := range expect {
+		if !slices.Equal(shapes[name], shape) {
+			t.Errorf("%s: expected shape %v, got %v", name, shape, shapes[name])
+		}
+	}
+
+	// the rows of the query's lora_b are permuted like the model's query, the
+	// value's are kept
+	for _, tensor := range tensors.Items {
+		if !strings.HasSuffix(tensor.Name, ".lora_b") {
+			continue
+		}
+
+		u16s := make([]uint16, 16)
+		sr := io.NewSectionReader(f, int64(tensors.Offset+tensor.Offset), int64(tensor.Size()))
+		if err := binary.Read(sr, binary.LittleEndian, u16s); err != nil {
+			t.Fatal(err)
+		}
+
+		var order []float32
+		for i := 0; i < len(u16s); i += 2 {
+			order = append(order, float16.Frombits(u16s[i]).Float32())
+		}
+
+		expect := []float32{0, 1, 2, 3, 4, 5, 6, 7}
+		if tensor.Name == "blk.0.attn_q.weight.lora_b" {
+			expect = []float32{0, 2, 1, 3, 4, 6, 5, 7}
+		}
+
+		if !slices.Equal(order, expect) {
+			t.Errorf("%s: expected rows %v, got %v", tensor.Name, expect, order)
+		}
+	}
+}
+
+func TestConvertAdapterErrors(t *testing.T) {
+	lora := map[string]any{"peft_type": "LORA", "r": 2, "lora_alpha": 16}
+	qA := testTensor{"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight", []uint64{2, 8}, make([]float32, 16)}
+
+	cases := []struct {
+		name   string
+		arch   string
+		config map[string]any
+		ts     []testTensor
+		expect string
+	}{
+		{"prefix tuning", "llama", map[string]any{"peft_type": "PREFIX_TUNING"}, []testTensor{qA}, "unsupported adapter type PREFIX_TUNING"},
+		{"dora", "llama", map[string]any{"peft_type": "LORA", "r": 2, "use_dora": true}, []testTensor{qA}, "DoRA adapters are not supported"},
+		{"architecture", "mamba", lora, []testTensor{qA}, "adapters for mamba models are not supported"},
+		{"rank", "llama", map[string]any{"peft_type": "LORA", "r": 4}, []testTensor{qA}, "expected [4 8] for rank 4"},
+		{"shape", "llama", lora, []testTensor{{qA.name, []uint64{2, 4}, make([]float32, 8)}}, "expected [2 8] for rank 2"},
+		{"module", "llama", lora, []testTensor{{"base_model.model.model.layers.0.self_attn.k_proj.lora_A.weight", []uint64{2, 8}, make([]float32, 16)}}, "which the model doesn't have"},
+		{"embedding", "llama", lora, []testTensor{{"base_model.model.model.embed_tokens.lora_embedding_A", []uint64{2, 8}, make([]float32, 16)}}, "unsupported adapter tensor"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := writeAdapter(t, tt.config, tt.ts)
+
+			var b bytes.Buffer
+			err := ConvertAdapter(os.DirFS(p), &b, adapterBase(t, tt.arch))
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Errorf("expected error containing %q, got %v", tt.expect, err)
+			}
+		})
+	}
+
+	var b bytes.Buffer
+	if err := ConvertAdapter(os.DirFS(t.TempDir()), &b, adapterBase(t, "llama")); !errors.Is(err, ErrNotAdapter) {
+		t.Errorf("expected ErrNotAdapter, got %v", err)
+	}
+}
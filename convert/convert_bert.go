@@ -0,0 +1,208 @@
+package convert
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// bert converts BERT and XLM-RoBERTa sequence classification models, such as
+// cross-encoder rerankers. The encoder is written as a "bert" model and the
+// classification head is written separately by ConvertHead.
+type bert struct {
+	Parameters
+	ModelType             string            `json:"model_type"`
+	MaxPositionEmbeddings uint32            `json:"max_position_embeddings"`
+	HiddenSize            uint32            `json:"hidden_size"`
+	HiddenLayers          uint32            `json:"num_hidden_layers"`
+	IntermediateSize      uint32            `json:"intermediate_size"`
+	NumAttentionHeads     uint32            `json:"num_attention_heads"`
+	TypeVocabSize         uint32            `json:"type_vocab_size"`
+	LayerNormEPS          float32           `json:"layer_norm_eps"`
+	PadTokenID            uint32            `json:"pad_token_id"`
+	ID2Label              map[string]string `json:"id2label"`
+	ProblemType           string            `json:"problem_type"`
+}
+
+var (
+	_ Converter     = (*bert)(nil)
+	_ headConverter = (*bert)(nil)
+)
+
+// positionOffset is the number of position embeddings RoBERTa models reserve
+// before the first position
+func (p *bert) positionOffset() uint32 {
+	if strings.Contains(p.ModelType, "roberta") {
+		return p.PadTokenID + 1
+	}
+
+	return 0
+}
+
+func (p *bert) KV(t *Tokenizer) llm.KV {
+	kv := p.Parameters.KV(t)
+	kv["general.architecture"] = "bert"
+	kv["general.name"] = "bert"
+	kv["bert.context_length"] = p.MaxPositionEmbeddings - p.positionOffset()
+	kv["bert.embedding_length"] = p.HiddenSize
+	kv["bert.feed_forward_length"] = p.IntermediateSize
+	kv["bert.block_count"] = p.HiddenLayers
+	kv["bert.attention.head_count"] = p.NumAttentionHeads
+	kv["bert.attention.layer_norm_epsilon"] = p.LayerNormEPS
+	kv["bert.attention.causal"] = false
+	// sequence classification heads read the hidden state of the first token
+	kv["bert.pooling_type"] = uint32(2)
+	kv["tokenizer.ggml.token_type_count"] = p.TypeVocabSize
+
+	switch t.Vocabulary.Model {
+	case "bert":
+		// llama.cpp expects a phantom space instead of the ## continuation prefix
+		tokens := make([]string, len(t.Vocabulary.Tokens))
+		for i, token := range t.Vocabulary.Tokens {
+			switch {
+			case strings.HasPrefix(token, "[") && strings.HasSuffix(token, "]"):
+				tokens[i] = token
+			case strings.HasPrefix(token, "##"):
+				tokens[i] = token[2:]
+			default:
+				tokens[i] = "▁" + token
+			}
+		}
+
+		kv["tokenizer.ggml.tokens"] = tokens
+	case "t5":
+		kv["tokenizer.ggml.add_bos_token"] = true
+		kv["tokenizer.ggml.add_eos_token"] = true
+	}
+
+	return kv
+}
+
+func (p *bert) Tensors(ts []Tensor) []llm.Tensor {
+	var out []llm.Tensor
+	for _, t := range ts {
+		name := p.tensorName(t.Name())
+		if strings.HasPrefix(name, "cls.") || strings.HasSuffix(name, "position_ids") {
+			continue
+		}
+
+		shape := t.Shape()
+		if offset := p.positionOffset(); name == "position_embd.weight" && offset > 0 {
+			shape = []uint64{shape[0] - uint64(offset), shape[1]}
+			t.SetRepacker(func(_ string, data []float32, shape []uint64) ([]float32, error) {
+				return data[uint64(offset)*shape[1]:], nil
+			})
+		}
+
+		out = append(out, llm.Tensor{
+			Name:     name,
+			Kind:     t.Kind(),
+			Shape:    shape,
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *bert) tensorName(n string) string {
+	n = strings.TrimPrefix(n, "bert.")
+	n = strings.TrimPrefix(n, "roberta.")
+	return strings.NewReplacer(
+		"embeddings.word_embeddings", "token_embd",
+		"embeddings.position_embeddings", "position_embd",
+		"embeddings.token_type_embeddings", "token_types",
+		"embeddings.LayerNorm", "token_embd_norm",
+		"encoder.layer", "blk",
+		"attention.self.query", "attn_q",
+		"attention.self.key", "attn_k",
+		"attention.self.value", "attn_v",
+		"attention.output.dense", "attn_output",
+		"attention.output.LayerNorm", "attn_output_norm",
+		"intermediate.dense", "ffn_up",
+		"output.dense", "ffn_down",
+		"output.LayerNorm", "layer_output_norm",
+		"pooler.dense", "cls.dense",
+		"classifier.dense", "cls.dense",
+		"classifier.out_proj", "cls.output",
+		"classifier", "cls.output",
+		".gamma", ".weight",
+		".beta", ".bias",
+	).Replace(n)
+}
+
+func (p *bert) headKV(t *Tokenizer) llm.KV {
+	type label struct {
+		id   int
+		name string
+	}
+
+	var labels []label
+	for k, v := range p.ID2Label {
+		id, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+
+		labels = append(labels, label{id, v})
+	}
+
+	slices.SortFunc(labels, func(a, b label) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	names := make([]string, len(labels))
+	for i, l := range labels {
+		names[i] = l.name
+	}
+
+	problemType := p.ProblemType
+	if problemType == "" && len(names) == 1 {
+		// a single output is a score, such as the relevance of a reranker
+		problemType = "regression"
+	}
+
+	var separator string
+	for _, sv := range t.SpecialVocabulary {
+		if sv.Type == "sep" {
+			separator = sv.Content
+		}
+	}
+
+	if strings.Contains(p.ModelType, "roberta") {
+		// RoBERTa separates pairs with two separator tokens
+		separator += separator
+	}
+
+	kv := llm.KV{
+		"general.architecture":    "classifier",
+		"classifier.labels":       names,
+		"classifier.pooling":      llm.PoolingCLS,
+		"classifier.problem_type": cmp.Or(problemType, "single_label_classification"),
+	}
+
+	if separator != "" {
+		kv["classifier.separator"] = separator
+	}
+
+	return kv
+}
+
+func (p *bert) headTensors(ts []Tensor) []llm.Tensor {
+	var out []llm.Tensor
+	for _, t := range ts {
+		if name := p.tensorName(t.Name()); strings.HasPrefix(name, "cls.") {
+			out = append(out, llm.Tensor{
+				Name:     name,
+				Kind:     t.Kind(),
+				Shape:    t.Shape(),
+				WriterTo: t,
+			})
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,311 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/llm"
+)
+
+type testTensor struct {
+	name  string
+	shape []uint64
+	data  []float32
+}
+
+func writeSafetensors(t *testing.T, p string, ts []testTensor) {
+	t.Helper()
+
+	header := make(map[string]safetensorMetadata)
+	var data bytes.Buffer
+	for _, tt := range ts {
+		offset := int64(data.Len())
+		if err := binary.Write(&data, binary.LittleEndian, tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		header[tt.name] = safetensorMetadata{Type: "F32", Shape: tt.shape, Offsets: []int64{offset, int64(data.Len())}}
+	}
+
+	bts, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, int64(len(bts))); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Write(bts)
+	b.Write(data.Bytes())
+
+	if err := os.WriteFile(filepath.Join(p, "model.safetensors"), b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeJSON(t *testing.T, p string, v any) {
+	t.Helper()
+
+	bts, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(p, bts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func convertHead(t *testing.T, p string) *llm.Classifier {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ConvertHead(os.DirFS(p), f); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := llm.LoadClassifier(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestConvertXLMRobertaReranker(t *testing.T) {
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "config.json"), map[string]any{
+		"architectures":           []string{"XLMRobertaForSequenceClassification"},
+		"model_type":              "xlm-roberta",
+		"vocab_size":              5,
+		"hidden_size":             2,
+		"num_hidden_layers":       1,
+		"num_attention_heads":     1,
+		"intermediate_size":       4,
+		"max_position_embeddings": 4,
+		"type_vocab_size":         1,
+		"layer_norm_eps":          1e-5,
+		"pad_token_id":            1,
+		"id2label":                map[string]string{"0": "LABEL_0"},
+	})
+
+	writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+		"added_tokens": []map[string]any{
+			{"id": 0, "content": "<s>", "special": true},
+			{"id": 1, "content": "<pad>", "special": true},
+			{"id": 2, "content": "</s>", "special": true},
+			{"id": 3, "content": "<unk>", "special": true},
+		},
+		"model": map[string]any{
+			"type":  "Unigram",
+			"vocab": [][]any{{"<s>", 0}, {"<pad>", 0}, {"</s>", 0}, {"<unk>", 0}, {"▁a", -1.5}},
+		},
+	})
+
+	writeJSON(t, filepath.Join(p, "tokenizer_config.json"), map[string]any{
+		"bos_token": "<s>",
+		"eos_token": "</s>",
+		"sep_token": "</s>",
+		"cls_token": "<s>",
+	})
+
+	writeSafetensors(t, p, []testTensor{
+		{"roberta.embeddings.word_embeddings.weight", []uint64{5, 2}, make([]float32, 10)},
+		{"roberta.embeddings.position_embeddings.weight", []uint64{4, 2}, []float32{0, 0, 0, 0, 1, 2, 3, 4}},
+		{"roberta.embeddings.LayerNorm.weight", []uint64{2}, []float32{1, 1}},
+		{"roberta.encoder.layer.0.attention.self.query.weight", []uint64{2, 2}, make([]float32, 4)},
+		{"roberta.encoder.layer.0.attention.output.dense.weight", []uint64{2, 2}, make([]float32, 4)},
+		{"roberta.encoder.layer.0.attention.output.LayerNorm.weight", []uint64{2}, []float32{1, 1}},
+		{"roberta.encoder.layer.0.intermediate.dense.weight", []uint64{4, 2}, make([]float32, 8)},
+		{"roberta.encoder.layer.0.output.dense.weight", []uint64{2, 4}, make([]float32, 8)},
+		{"roberta.encoder.layer.0.output.LayerNorm.weight", []uint64{2}, []float32{1, 1}},
+		{"classifier.dense.weight", []uint64{2, 2}, []float32{1, 0, 0, 1}},
+		{"classifier.dense.bias", []uint64{2}, []float32{0, 0}},
+		{"classifier.out_proj.weight", []uint64{1, 2}, []float32{1, -1}},
+		{"classifier.out_proj.bias", []uint64{1}, []float32{0.5}},
+	})
+
+	f, kv, tensors := convertFull(t, os.DirFS(p))
+	defer f.Close()
+
+	if kv.Architecture() != "bert" {
+		t.Errorf("expected architecture bert, got %s", kv.Architecture())
+	}
+
+	if kv.ContextLength() != 2 {
+		t.Errorf("expected context length 2, got %d", kv.ContextLength())
+	}
+
+	if kv["tokenizer.ggml.model"] != "t5" {
+		t.Errorf("expected tokenizer t5, got %v", kv["tokenizer.ggml.model"])
+	}
+
+	var names []string
+	for _, tensor := range tensors.Items {
+		names = append(names, tensor.Name)
+		if tensor.Name == "position_embd.weight" && !slices.Equal(tensor.Shape, []uint64{2, 2}) {
+			t.Errorf("expected position embeddings shape [2 2], got %v", tensor.Shape)
+		}
+	}
+
+	slices.Sort(names)
+	expect := []string{
+		"blk.0.attn_output.weight",
+		"blk.0.attn_output_norm.weight",
+		"blk.0.attn_q.weight",
+		"blk.0.ffn_down.weight",
+		"blk.0.ffn_up.weight",
+		"blk.0.layer_output_norm.weight",
+		"position_embd.weight",
+		"token_embd.weight",
+		"token_embd_norm.weight",
+	}
+
+	if !slices.Equal(names, expect) {
+		t.Errorf("unexpected tensors %v", names)
+	}
+
+	c := convertHead(t, p)
+	if !slices.Equal(c.Labels, []string{"LABEL_0"}) {
+		t.Errorf("unexpected labels %v", c.Labels)
+	}
+
+	if !c.Regression {
+		t.Error("expected a regression head")
+	}
+
+	if c.Separator != "</s></s>" {
+		t.Errorf("expected separator </s></s>, got %q", c.Separator)
+	}
+
+	// tanh(1) - tanh(0) + 0.5
+	scores, err := c.Classify([]float32{1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scores) != 1 || scores[0] < 1.26 || scores[0] > 1.27 {
+		t.Errorf("unexpected scores %v", scores)
+	}
+}
+
+func TestConvertBertClassifier(t *testing.T) {
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "config.json"), map[string]any{
+		"architectures":           []string{"BertForSequenceClassification"},
+		"model_type":              "bert",
+		"hidden_size":             2,
+		"num_hidden_layers":       1,
+		"num_attention_heads":     1,
+		"intermediate_size":       4,
+		"max_position_embeddings": 8,
+		"type_vocab_size":         2,
+		"layer_norm_eps":          1e-12,
+		"id2label":                map[string]string{"0": "negative", "1": "positive"},
+	})
+
+	writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+		"added_tokens": []map[string]any{
+			{"id": 0, "content": "[PAD]", "special": true},
+			{"id": 1, "content": "[CLS]", "special": true},
+			{"id": 2, "content": "[SEP]", "special": true},
+		},
+		"model": map[string]any{
+			"type":  "WordPiece",
+			"vocab": map[string]int{"[PAD]": 0, "[CLS]": 1, "[SEP]": 2, "movie": 3, "##s": 4},
+		},
+	})
+
+	writeJSON(t, filepath.Join(p, "tokenizer_config.json"), map[string]any{
+		"cls_token": "[CLS]",
+		"sep_token": "[SEP]",
+		"pad_token": "[PAD]",
+	})
+
+	writeSafetensors(t, p, []testTensor{
+		{"bert.embeddings.word_embeddings.weight", []uint64{5, 2}, make([]float32, 10)},
+		{"bert.embeddings.position_embeddings.weight", []uint64{8, 2}, make([]float32, 16)},
+		{"bert.embeddings.token_type_embeddings.weight", []uint64{2, 2}, make([]float32, 4)},
+		{"bert.pooler.dense.weight", []uint64{2, 2}, []float32{1, 0, 0, 1}},
+		{"bert.pooler.dense.bias", []uint64{2}, []float32{0, 0}},
+		{"classifier.weight", []uint64{2, 2}, []float32{1, 0, 0, 1}},
+		{"classifier.bias", []uint64{2}, []float32{0, 0}},
+	})
+
+	f, kv, tensors := convertFull(t, os.DirFS(p))
+	defer f.Close()
+
+	if kv.ContextLength() != 8 {
+		t.Errorf("expected context length 8, got %d", kv.ContextLength())
+	}
+
+	bts, err := json.Marshal(kv["tokenizer.ggml.tokens"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens []string
+	if err := json.Unmarshal(bts, &tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(tokens, []string{"[PAD]", "[CLS]", "[SEP]", "▁movie", "s"}) {
+		t.Errorf("unexpected tokens %v", tokens)
+	}
+
+	for _, tensor := range tensors.Items {
+		if tensor.Name == "cls.dense.weight" || tensor.Name == "cls.output.weight" {
+			t.Errorf("unexpected head tensor %s in model", tensor.Name)
+		}
+	}
+
+	c := convertHead(t, p)
+	if !slices.Equal(c.Labels, []string{"negative", "positive"}) {
+		t.Errorf("unexpected labels %v", c.Labels)
+	}
+
+	if c.Regression || c.MultiLabel {
+		t.Error("expected a single label head")
+	}
+
+	if c.Separator != "[SEP]" {
+		t.Errorf("expected separator [SEP], got %q", c.Separator)
+	}
+}
+
+func TestConvertHeadWithoutHead(t *testing.T) {
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "config.json"), map[string]any{
+		"architectures": []string{"LlamaForCausalLM"},
+	})
+
+	writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+		"model": map[string]any{"type": "BPE", "vocab": map[string]int{"a": 0}},
+	})
+
+	writeSafetensors(t, p, []testTensor{
+		{"model.embed_tokens.weight", []uint64{1, 2}, make([]float32, 2)},
+	})
+
+	f, err := os.CreateTemp(t.TempDir(), "head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ConvertHead(os.DirFS(p), f); err != ErrNoHead {
+		t.Errorf("expected ErrNoHead, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/llm"
+)
+
+type deepseek2 struct {
+	Parameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	RMSNormEPS            float32 `json:"rms_norm_eps"`
+	RopeTheta             float32 `json:"rope_theta"`
+	QLoraRank             uint32  `json:"q_lora_rank"`
+	KVLoraRank            uint32  `json:"kv_lora_rank"`
+	QKNopeHeadDim         uint32  `json:"qk_nope_head_dim"`
+	QKRopeHeadDim         uint32  `json:"qk_rope_head_dim"`
+	VHeadDim              uint32  `json:"v_head_dim"`
+	FirstKDenseReplace    uint32  `json:"first_k_dense_replace"`
+	MoeIntermediateSize   uint32  `json:"moe_intermediate_size"`
+	NRoutedExperts        uint32  `json:"n_routed_experts"`
+	NSharedExperts        uint32  `json:"n_shared_experts"`
+	NumExpertsPerToken    uint32  `json:"num_experts_per_tok"`
+	RoutedScalingFactor   float32 `json:"routed_scaling_factor"`
+	RopeScaling           struct {
+		Type                          string  `json:"type"`
+		Factor                        float32 `json:"factor"`
+		OriginalMaxPositionEmbeddings uint32  `json:"original_max_position_embeddings"`
+		MScaleAllDim                  float32 `json:"mscale_all_dim"`
+	} `json:"rope_scaling"`
+}
+
+var _ Converter = (*deepseek2)(nil)
+
+func (p *deepseek2) KV(t *Tokenizer) llm.KV {
+	kv := p.Parameters.KV(t)
+	kv["general.architecture"] = "deepseek2"
+	kv["general.name"] = "deepseek2"
+	kv["deepseek2.vocab_size"] = p.VocabSize
+	kv["deepseek2.context_length"] = p.MaxPositionEmbeddings
+	kv["deepseek2.embedding_length"] = p.HiddenSize
+	kv["deepseek2.block_count"] = p.HiddenLayers
+	kv["deepseek2.feed_forward_length"] = p.IntermediateSize
+	kv["deepseek2.attention.head_count"] = p.NumAttentionHeads
+	kv["deepseek2.attention.head_count_kv"] = p.NumKeyValueHeads
+	kv["deepseek2.attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
+	kv["deepseek2.rope.freq_base"] = p.RopeTheta
+	kv["deepseek2.rope.dimension_count"] = p.QKRopeHeadDim
+	kv["deepseek2.leading_dense_block_count"] = p.FirstKDenseReplace
+	kv["deepseek2.attention.kv_lora_rank"] = p.KVLoraRank
+	kv["deepseek2.attention.key_length"] = p.QKNopeHeadDim + p.QKRopeHeadDim
+	kv["deepseek2.attention.value_length"] = p.VHeadDim
+	kv["deepseek2.expert_feed_forward_length"] = p.MoeIntermediateSize
+	kv["deepseek2.expert_count"] = p.NRoutedExperts
+	kv["deepseek2.expert_shared_count"] = p.NSharedExperts
+	kv["deepseek2.expert_used_count"] = p.NumExpertsPerToken
+	kv["deepseek2.expert_weights_scale"] = p.RoutedScalingFactor
+
+	// the lite models project queries without a low rank adaptation
+	if p.QLoraRank > 0 {
+		kv["deepseek2.attention.q_lora_rank"] = p.QLoraRank
+	}
+
+	if p.RopeScaling.Type == "yarn" {
+		kv["deepseek2.rope.scaling.type"] = p.RopeScaling.Type
+		kv["deepseek2.rope.scaling.factor"] = p.RopeScaling.Factor
+		kv["deepseek2.rope.scaling.original_context_length"] = p.RopeScaling.OriginalMaxPositionEmbeddings
+		kv["deepseek2.rope.scaling.yarn_log_multiplier"] = 0.1 * p.RopeScaling.MScaleAllDim
+	}
+
+	return kv
+}
+
+func (p *deepseek2) Tensors(ts []Tensor) []llm.Tensor {
+	ts, out := mergeExperts(ts, p.tensorName)
+	for _, t := range ts {
+		out = append(out, llm.Tensor{
+			Name:     p.tensorName(t.Name()),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *deepseek2) tensorName(n string) string {
+	return strings.NewReplacer(
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.q_a_proj", "attn_q_a",
+		"self_attn.q_a_layernorm", "attn_q_a_norm",
+		"self_attn.q_b_proj", "attn_q_b",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.kv_a_proj_with_mqa", "attn_kv_a_mqa",
+		"self_attn.kv_a_layernorm", "attn_kv_a_norm",
+		"self_attn.kv_b_proj", "attn_kv_b",
+		"self_attn.o_proj", "attn_output",
+		"mlp.experts.gate_proj", "ffn_gate_exps",
+		"mlp.experts.down_proj", "ffn_down_exps",
+		"mlp.experts.up_proj", "ffn_up_exps",
+		"mlp.shared_experts.gate_proj", "ffn_gate_shexp",
+		"mlp.shared_experts.down_proj", "ffn_down_shexp",
+		"mlp.shared_experts.up_proj", "ffn_up_shexp",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.down_proj", "ffn_down",
+		"mlp.up_proj", "ffn_up",
+		"mlp.gate.", "ffn_gate_inp.",
+		"post_attention_layernorm", "ffn_norm",
+	).Replace(n)
+}
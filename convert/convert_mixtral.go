@@ -3,7 +3,9 @@ package convert
 import (
 	"fmt"
 	"io"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/ollama/ollama/llm"
@@ -72,6 +74,52 @@ func (p *mixtral) Tensors(ts []Tensor) []llm.Tensor {
 	return append(out, p.llama.Tensors(ts)...)
 }
 
+// expertPattern matches the expert in the names of the tensors of mixtures of
+// experts, e.g. ".experts.1." in "model.layers.0.mlp.experts.1.up_proj.weight"
+var expertPattern = regexp.MustCompile(`\.experts\.(\d+)\.`)
+
+// mergeExperts removes the tensors of experts from ts and merges those of the
+// same layer and type into one tensor, ordered by expert, which is named by
+// namer from the name without the expert, e.g.
+// "model.layers.0.mlp.experts.up_proj.weight". It returns the other tensors
+// and the merged ones.
+func mergeExperts(ts []Tensor, namer func(string) string) ([]Tensor, []llm.Tensor) {
+	merged := make(map[string]experts)
+	ts = slices.DeleteFunc(ts, func(t Tensor) bool {
+		m := expertPattern.FindStringSubmatchIndex(t.Name())
+		if m == nil {
+			return false
+		}
+
+		i, err := strconv.Atoi(t.Name()[m[2]:m[3]])
+		if err != nil {
+			return false
+		}
+
+		name := namer(t.Name()[:m[0]] + ".experts." + t.Name()[m[1]:])
+		e := merged[name]
+		if len(e) <= i {
+			e = append(e, make(experts, i+1-len(e))...)
+		}
+
+		e[i] = t
+		merged[name] = e
+		return true
+	})
+
+	out := make([]llm.Tensor, 0, len(merged))
+	for name, e := range merged {
+		out = append(out, llm.Tensor{
+			Name:     name,
+			Kind:     e[0].Kind(),
+			Shape:    append([]uint64{uint64(len(e))}, e[0].Shape()...),
+			WriterTo: e,
+		})
+	}
+
+	return ts, out
+}
+
 type experts []Tensor
 
 func (e experts) WriteTo(w io.Writer) (int64, error) {
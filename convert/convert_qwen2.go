@@ -0,0 +1,85 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/llm"
+)
+
+type qwen2 struct {
+	Parameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	RopeTheta             float32 `json:"rope_theta"`
+	RopeScaling           struct {
+		Type                          string  `json:"type"`
+		Factor                        float32 `json:"factor"`
+		OriginalMaxPositionEmbeddings uint32  `json:"original_max_position_embeddings"`
+	} `json:"rope_scaling"`
+	RMSNormEPS float32 `json:"rms_norm_eps"`
+}
+
+var _ Converter = (*qwen2)(nil)
+
+func (p *qwen2) KV(t *Tokenizer) llm.KV {
+	return p.kv(t, "qwen2")
+}
+
+// kv maps the parameters shared by qwen2 and qwen2moe to key-values of arch
+func (p *qwen2) kv(t *Tokenizer, arch string) llm.KV {
+	kv := p.Parameters.KV(t)
+	kv["general.architecture"] = arch
+	kv["general.name"] = arch
+	kv[arch+".context_length"] = p.MaxPositionEmbeddings
+	kv[arch+".embedding_length"] = p.HiddenSize
+	kv[arch+".block_count"] = p.HiddenLayers
+	kv[arch+".feed_forward_length"] = p.IntermediateSize
+	kv[arch+".attention.head_count"] = p.NumAttentionHeads
+	kv[arch+".attention.head_count_kv"] = p.NumKeyValueHeads
+	kv[arch+".attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
+	kv[arch+".rope.freq_base"] = p.RopeTheta
+
+	if p.RopeScaling.Type == "yarn" {
+		kv[arch+".rope.scaling.type"] = p.RopeScaling.Type
+		kv[arch+".rope.scaling.factor"] = p.RopeScaling.Factor
+		kv[arch+".rope.scaling.original_context_length"] = p.RopeScaling.OriginalMaxPositionEmbeddings
+	}
+
+	return kv
+}
+
+func (p *qwen2) Tensors(ts []Tensor) []llm.Tensor {
+	var out []llm.Tensor
+	for _, t := range ts {
+		out = append(out, llm.Tensor{
+			Name:     p.tensorName(t.Name()),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *qwen2) tensorName(n string) string {
+	return strings.NewReplacer(
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.o_proj", "attn_output",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.down_proj", "ffn_down",
+		"mlp.up_proj", "ffn_up",
+		"post_attention_layernorm", "ffn_norm",
+	).Replace(n)
+}
@@ -0,0 +1,53 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/llm"
+)
+
+type qwen2moe struct {
+	qwen2
+	NumExperts                   uint32 `json:"num_experts"`
+	NumExpertsPerToken           uint32 `json:"num_experts_per_tok"`
+	MoeIntermediateSize          uint32 `json:"moe_intermediate_size"`
+	SharedExpertIntermediateSize uint32 `json:"shared_expert_intermediate_size"`
+}
+
+var _ Converter = (*qwen2moe)(nil)
+
+func (p *qwen2moe) KV(t *Tokenizer) llm.KV {
+	kv := p.qwen2.kv(t, "qwen2moe")
+	kv["qwen2moe.expert_count"] = p.NumExperts
+	kv["qwen2moe.expert_used_count"] = p.NumExpertsPerToken
+	kv["qwen2moe.expert_feed_forward_length"] = p.MoeIntermediateSize
+	kv["qwen2moe.expert_shared_feed_forward_length"] = p.SharedExpertIntermediateSize
+	return kv
+}
+
+func (p *qwen2moe) Tensors(ts []Tensor) []llm.Tensor {
+	ts, out := mergeExperts(ts, p.tensorName)
+	for _, t := range ts {
+		out = append(out, llm.Tensor{
+			Name:     p.tensorName(t.Name()),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *qwen2moe) tensorName(n string) string {
+	return p.qwen2.tensorName(strings.NewReplacer(
+		"mlp.experts.gate_proj", "ffn_gate_exps",
+		"mlp.experts.down_proj", "ffn_down_exps",
+		"mlp.experts.up_proj", "ffn_up_exps",
+		"mlp.shared_expert_gate", "ffn_gate_inp_shexp",
+		"mlp.shared_expert.gate_proj", "ffn_gate_shexp",
+		"mlp.shared_expert.down_proj", "ffn_down_shexp",
+		"mlp.shared_expert.up_proj", "ffn_up_shexp",
+		"mlp.gate.", "ffn_gate_inp.",
+	).Replace(n))
+}
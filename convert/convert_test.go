@@ -12,6 +12,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"reflect"
 	"slices"
 	"testing"
 
@@ -29,7 +30,7 @@ func convertFull(t *testing.T, fsys fs.FS) (*os.File, llm.KV, llm.Tensors) {
 	}
 	defer f.Close()
 
-	if err := Convert(fsys, f); err != nil {
+	if err := Convert(fsys, f, false); err != nil {
 		t.Fatal(err)
 	}
 
@@ -128,3 +129,180 @@ func TestConvertFull(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertArchitectures(t *testing.T) {
+	tensor := func(name string, shape ...uint64) testTensor {
+		n := uint64(1)
+		for _, dim := range shape {
+			n *= dim
+		}
+
+		return testTensor{name, shape, make([]float32, n)}
+	}
+
+	cases := []struct {
+		name    string
+		config  map[string]any
+		tensors []testTensor
+		kv      map[string]any
+		expect  map[string][]uint64
+	}{
+		{
+			"qwen2",
+			map[string]any{
+				"architectures":       []string{"Qwen2ForCausalLM"},
+				"hidden_size":         4,
+				"num_hidden_layers":   1,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 1,
+				"rope_theta":          1000000,
+			},
+			[]testTensor{
+				tensor("model.embed_tokens.weight", 3, 4),
+				tensor("model.layers.0.self_attn.q_proj.weight", 4, 4),
+				tensor("model.layers.0.self_attn.q_proj.bias", 4),
+				tensor("model.layers.0.mlp.gate_proj.weight", 8, 4),
+				tensor("lm_head.weight", 3, 4),
+			},
+			map[string]any{"qwen2.attention.head_count_kv": uint32(1), "qwen2.rope.freq_base": float32(1000000)},
+			map[string][]uint64{
+				"token_embd.weight":     {3, 4},
+				"blk.0.attn_q.weight":   {4, 4},
+				"blk.0.attn_q.bias":     {4},
+				"blk.0.ffn_gate.weight": {8, 4},
+				"output.weight":         {3, 4},
+			},
+		},
+		{
+			"qwen2moe",
+			map[string]any{
+				"architectures":                   []string{"Qwen2MoeForCausalLM"},
+				"hidden_size":                     4,
+				"num_hidden_layers":               1,
+				"num_experts":                     11,
+				"num_experts_per_tok":             2,
+				"moe_intermediate_size":           2,
+				"shared_expert_intermediate_size": 8,
+			},
+			func() []testTensor {
+				ts := []testTensor{
+					tensor("model.layers.0.mlp.gate.weight", 11, 4),
+					tensor("model.layers.0.mlp.shared_expert_gate.weight", 1, 4),
+					tensor("model.layers.0.mlp.shared_expert.up_proj.weight", 8, 4),
+				}
+
+				for i := range 11 {
+					ts = append(ts, tensor(fmt.Sprintf("model.layers.0.mlp.experts.%d.up_proj.weight", i), 2, 4))
+				}
+
+				return ts
+			}(),
+			map[string]any{"qwen2moe.expert_count": uint32(11), "qwen2moe.expert_shared_feed_forward_length": uint32(8)},
+			map[string][]uint64{
+				"blk.0.ffn_gate_inp.weight":       {11, 4},
+				"blk.0.ffn_gate_inp_shexp.weight": {1, 4},
+				"blk.0.ffn_up_shexp.weight":       {8, 4},
+				"blk.0.ffn_up_exps.weight":        {11, 2, 4},
+			},
+		},
+		{
+			"gemma2",
+			map[string]any{
+				"architectures":           []string{"Gemma2ForCausalLM"},
+				"hidden_size":             4,
+				"num_hidden_layers":       1,
+				"head_dim":                2,
+				"sliding_window":          4096,
+				"attn_logit_softcapping":  50,
+				"final_logit_softcapping": 30,
+			},
+			[]testTensor{
+				tensor("model.layers.0.input_layernorm.weight", 4),
+				tensor("model.layers.0.post_attention_layernorm.weight", 4),
+				tensor("model.layers.0.pre_feedforward_layernorm.weight", 4),
+				tensor("model.layers.0.post_feedforward_layernorm.weight", 4),
+			},
+			map[string]any{"gemma2.attention.key_length": uint32(2), "gemma2.attention.sliding_window": uint32(4096), "gemma2.final_logit_softcapping": float32(30)},
+			map[string][]uint64{
+				"blk.0.attn_norm.weight":           {4},
+				"blk.0.post_attention_norm.weight": {4},
+				"blk.0.ffn_norm.weight":            {4},
+				"blk.0.post_ffw_norm.weight":       {4},
+			},
+		},
+		{
+			"deepseek2",
+			map[string]any{
+				"architectures":         []string{"DeepseekV2ForCausalLM"},
+				"hidden_size":           4,
+				"num_hidden_layers":     2,
+				"kv_lora_rank":          2,
+				"qk_nope_head_dim":      2,
+				"qk_rope_head_dim":      1,
+				"v_head_dim":            2,
+				"first_k_dense_replace": 1,
+				"n_routed_experts":      2,
+				"n_shared_experts":      1,
+				"rope_scaling":          map[string]any{"type": "yarn", "factor": 40, "mscale_all_dim": 0.707},
+			},
+			[]testTensor{
+				tensor("model.layers.0.self_attn.q_proj.weight", 6, 4),
+				tensor("model.layers.0.self_attn.kv_a_proj_with_mqa.weight", 3, 4),
+				tensor("model.layers.0.self_attn.kv_a_layernorm.weight", 2),
+				tensor("model.layers.0.self_attn.kv_b_proj.weight", 8, 2),
+				tensor("model.layers.0.mlp.gate_proj.weight", 8, 4),
+				tensor("model.layers.1.mlp.gate.weight", 2, 4),
+				tensor("model.layers.1.mlp.experts.0.gate_proj.weight", 2, 4),
+				tensor("model.layers.1.mlp.experts.1.gate_proj.weight", 2, 4),
+				tensor("model.layers.1.mlp.shared_experts.gate_proj.weight", 2, 4),
+			},
+			map[string]any{"deepseek2.attention.key_length": uint32(3), "deepseek2.leading_dense_block_count": uint32(1), "deepseek2.rope.scaling.type": "yarn"},
+			map[string][]uint64{
+				"blk.0.attn_q.weight":         {6, 4},
+				"blk.0.attn_kv_a_mqa.weight":  {3, 4},
+				"blk.0.attn_kv_a_norm.weight": {2},
+				"blk.0.attn_kv_b.weight":      {8, 2},
+				"blk.0.ffn_gate.weight":       {8, 4},
+				"blk.1.ffn_gate_inp.weight":   {2, 4},
+				"blk.1.ffn_gate_exps.weight":  {2, 2, 4},
+				"blk.1.ffn_gate_shexp.weight": {2, 4},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := t.TempDir()
+			writeJSON(t, filepath.Join(p, "config.json"), tt.config)
+			writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+				"model": map[string]any{"type": "BPE", "vocab": map[string]int{"a": 0, "b": 1, "c": 2}},
+			})
+			writeSafetensors(t, p, tt.tensors)
+
+			f, kv, tensors := convertFull(t, os.DirFS(p))
+			defer f.Close()
+
+			if kv.Architecture() != tt.name {
+				t.Errorf("expected architecture %s, got %s", tt.name, kv.Architecture())
+			}
+
+			for k, v := range tt.kv {
+				if kv[k] != v {
+					t.Errorf("expected %s %v, got %v", k, v, kv[k])
+				}
+			}
+
+			// shapes are decoded in ggml's order, the reverse of the model's
+			actual := make(map[string][]uint64)
+			for _, tensor := range tensors.Items {
+				shape := slices.Clone(tensor.Shape)
+				slices.Reverse(shape)
+				actual[tensor.Name] = shape
+			}
+
+			if !reflect.DeepEqual(actual, tt.expect) {
+				t.Errorf("expected tensors %v, got %v", tt.expect, actual)
+			}
+		})
+	}
+}
@@ -10,19 +10,47 @@ import (
 )
 
 type ZipReader struct {
-	r *zip.Reader
-	p string
+	r  *zip.Reader
+	ra io.ReaderAt
+	p  string
 
-	// limit is the maximum size of a file that can be read directly
-	// from the zip archive. Files larger than this size will be extracted
+	// files are the archive's uncompressed files, which are read in place
+	files map[string]*zip.File
+
+	// limit is the maximum size of a compressed file that can be read
+	// directly from the zip archive. Files larger than this size will be extracted
 	limit int64
 }
 
-func NewZipReader(r *zip.Reader, p string, limit int64) fs.FS {
-	return &ZipReader{r, p, limit}
+// NewZipReader returns a fs.FS of the zip archive in ra. Uncompressed files,
+// like those in archives made by ollama create, are read in place so large
+// models aren't extracted to p.
+func NewZipReader(ra io.ReaderAt, size int64, p string, limit int64) (fs.FS, error) {
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range r.File {
+		if f.Method == zip.Store && f.Mode().IsRegular() {
+			files[f.Name] = f
+		}
+	}
+
+	return &ZipReader{r: r, ra: ra, p: p, files: files, limit: limit}, nil
 }
 
 func (z *ZipReader) Open(name string) (fs.File, error) {
+	if f, ok := z.files[name]; ok {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+
+		return &zipFile{io.NewSectionReader(z.ra, offset, int64(f.UncompressedSize64)), f.FileInfo()}, nil
+	}
+
 	r, err := z.r.Open(name)
 	if err != nil {
 		return nil, err
@@ -56,3 +84,18 @@ func (z *ZipReader) Open(name string) (fs.File, error) {
 
 	return os.Open(n)
 }
+
+// zipFile is an uncompressed file in a zip archive, which can be read at
+// random like a file on disk
+type zipFile struct {
+	*io.SectionReader
+	fi fs.FileInfo
+}
+
+func (f *zipFile) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *zipFile) Close() error {
+	return nil
+}
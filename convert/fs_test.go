@@ -0,0 +1,110 @@
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ollama/ollama/llm"
+)
+
+func TestZipReader(t *testing.T) {
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "config.json"), map[string]any{
+		"architectures":       []string{"Qwen2ForCausalLM"},
+		"hidden_size":         4,
+		"num_hidden_layers":   1,
+		"num_attention_heads": 2,
+		"num_key_value_heads": 1,
+	})
+	writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+		"model": map[string]any{"type": "BPE", "vocab": map[string]int{"a": 0, "b": 1, "c": 2}},
+	})
+	writeSafetensors(t, p, []testTensor{
+		{"model.embed_tokens.weight", []uint64{3, 4}, make([]float32, 12)},
+		{"model.layers.0.self_attn.q_proj.weight", []uint64{4, 4}, make([]float32, 16)},
+	})
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, name := range []string{"config.json", "tokenizer.json", "model.safetensors"} {
+		bts, err := os.ReadFile(filepath.Join(p, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write(bts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// compressed files larger than the limit are extracted
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "extra.bin", Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	extract := t.TempDir()
+	fsys, err := NewZipReader(bytes.NewReader(b.Bytes()), int64(b.Len()), extract, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.Open("model.safetensors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, ok := f.(io.ReadSeeker); !ok {
+		t.Errorf("expected stored file to be seekable, got %T", f)
+	}
+
+	// the model is converted into a stream without extracting it
+	var out bytes.Buffer
+	if err := Convert(fsys, &out, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, err := os.ReadDir(extract); err != nil {
+		t.Fatal(err)
+	} else if len(entries) > 0 {
+		t.Errorf("expected nothing to be extracted, got %v", entries)
+	}
+
+	ggml, _, err := llm.DecodeGGML(bytes.NewReader(out.Bytes()), math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(ggml.Tensors().Items); n != 2 {
+		t.Errorf("expected 2 tensors, got %d", n)
+	}
+
+	extra, err := fsys.Open("extra.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extra.Close()
+
+	if _, err := os.Stat(filepath.Join(extract, "extra.bin")); err != nil {
+		t.Errorf("expected compressed file to be extracted: %v", err)
+	}
+}
@@ -12,6 +12,7 @@ type Tensor interface {
 	Shape() []uint64
 	Kind() uint32
 	SetRepacker(repacker)
+	SetBF16(bool)
 	WriteTo(io.Writer) (int64, error)
 }
 
@@ -19,6 +20,9 @@ type tensorBase struct {
 	name  string
 	shape []uint64
 	repacker
+
+	// bf16 stores the weight as BF16 rather than F16
+	bf16 bool
 }
 
 func (t tensorBase) Name() string {
@@ -34,8 +38,14 @@ const (
 	tensorKindF16
 )
 
+// tensorKindBF16 is numbered after ggml's quantized types
+const tensorKindBF16 uint32 = 30
+
 func (t tensorBase) Kind() uint32 {
-	if strings.HasSuffix(t.name, ".block_sparse_moe.gate.weight") {
+	// the routers of mixtures of experts are kept as F32
+	if strings.HasSuffix(t.name, ".block_sparse_moe.gate.weight") ||
+		strings.HasSuffix(t.name, ".mlp.gate.weight") ||
+		strings.HasSuffix(t.name, ".mlp.shared_expert_gate.weight") {
 		return 0
 	}
 
@@ -45,10 +55,18 @@ func (t tensorBase) Kind() uint32 {
 	case 1:
 		return tensorKindF32
 	default:
+		if t.bf16 {
+			return tensorKindBF16
+		}
+
 		return tensorKindF16
 	}
 }
 
+func (t *tensorBase) SetBF16(bf16 bool) {
+	t.bf16 = bf16
+}
+
 func (t *tensorBase) SetRepacker(fn repacker) {
 	t.repacker = fn
 }
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"slices"
+	"strings"
 
 	"github.com/d4l3k/go-bfloat16"
 	"github.com/x448/float16"
@@ -64,7 +66,49 @@ func parseSafetensors(fsys fs.FS, ps ...string) ([]Tensor, error) {
 		}
 	}
 
-	return ts, nil
+	return fp8Scales(ts), nil
+}
+
+// fp8Scales moves the scales of FP8 weights in ts, such as
+// model.layers.0.mlp.up_proj.weight_scale or DeepSeek's weight_scale_inv, to
+// the weights they dequantize. The scales of the weights' inputs, used to run
+// them in FP8, are removed.
+func fp8Scales(ts []Tensor) []Tensor {
+	weights := make(map[string]int)
+	for i, t := range ts {
+		if st, ok := t.(safetensor); ok && strings.HasPrefix(st.dtype, "F8_") {
+			weights[t.Name()] = i
+		}
+	}
+
+	if len(weights) == 0 {
+		return ts
+	}
+
+	// scales are found before any are removed, which moves the weights
+	scales := make(map[string]bool)
+	for _, t := range ts {
+		for _, suffix := range []string{".weight_scale_inv", ".weight_scale"} {
+			if prefix, ok := strings.CutSuffix(t.Name(), suffix); ok {
+				if i, ok := weights[prefix+".weight"]; ok {
+					st := ts[i].(safetensor)
+					scale := t.(safetensor)
+					st.scale = &scale
+					ts[i] = st
+					scales[t.Name()] = true
+				}
+			}
+		}
+	}
+
+	return slices.DeleteFunc(ts, func(t Tensor) bool {
+		if prefix, ok := strings.CutSuffix(t.Name(), ".input_scale"); ok {
+			_, ok := weights[prefix+".weight"]
+			return ok
+		}
+
+		return scales[t.Name()]
+	})
 }
 
 // safetensorsPad returns the padded size of the safetensors file given a length n and offset s
@@ -79,72 +123,224 @@ type safetensor struct {
 	offset int64
 	size   int64
 	*tensorBase
+
+	// scale dequantizes an FP8 weight
+	scale *safetensor
 }
 
-func (st safetensor) WriteTo(w io.Writer) (int64, error) {
+// safetensorChunkSize is the size of the chunks tensors are converted in
+const safetensorChunkSize = 32 << 20
+
+// open opens the file of st at the start of its data
+func (st safetensor) open() (fs.File, error) {
 	f, err := st.fs.Open(st.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if seeker, ok := f.(io.Seeker); ok {
+		_, err = seeker.Seek(st.offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, f, st.offset)
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// floats reads all of st's data
+func (st safetensor) floats() ([]float32, error) {
+	f, err := st.open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bts := make([]byte, st.size)
+	if _, err := io.ReadFull(f, bts); err != nil {
+		return nil, err
+	}
+
+	return safetensorFloats(st.dtype, bts)
+}
+
+func (st safetensor) WriteTo(w io.Writer) (int64, error) {
+	f, err := st.open()
 	if err != nil {
 		return 0, err
 	}
 	defer f.Close()
 
-	if seeker, ok := f.(io.Seeker); ok {
-		if _, err := seeker.Seek(st.offset, io.SeekStart); err != nil {
-			return 0, err
+	// tensors are converted in chunks so memory use doesn't grow with their
+	// size, except those with a repacker or scales which need the whole tensor
+	chunk := st.size
+	if st.repacker == nil && st.scale == nil {
+		chunk = min(chunk, safetensorChunkSize)
+	}
+
+	var n int64
+	bts := make([]byte, chunk)
+	for remaining := st.size; remaining > 0; remaining -= int64(len(bts)) {
+		bts = bts[:min(int64(len(bts)), remaining)]
+		if _, err := io.ReadFull(f, bts); err != nil {
+			return n, err
 		}
-	} else {
-		if _, err := io.CopyN(io.Discard, f, st.offset); err != nil {
-			return 0, err
+
+		f32s, err := safetensorFloats(st.dtype, bts)
+		if err != nil {
+			return n, err
 		}
+
+		if st.scale != nil {
+			if err := st.dequantize(f32s); err != nil {
+				return n, err
+			}
+		}
+
+		if st.repacker != nil {
+			f32s, err = st.repacker(st.Name(), f32s, st.Shape())
+			if err != nil {
+				return n, err
+			}
+		}
+
+		nn, err := writeFloats(w, st.Kind(), f32s)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// dequantize multiplies the FP8 weight data by its scales: one for the whole
+// weight, one for each row, or one for each block of rows and columns like
+// DeepSeek's. Blocks are assumed to be the smallest power of two which
+// covers the weight with as many blocks as there are scales, e.g. 128x128.
+func (st safetensor) dequantize(data []float32) error {
+	scales, err := st.scale.floats()
+	if err != nil {
+		return err
 	}
 
-	var f32s []float32
-	switch st.dtype {
+	shape, scaleShape := st.Shape(), st.scale.Shape()
+	switch {
+	case len(scales) == 1:
+		for i := range data {
+			data[i] *= scales[0]
+		}
+	case len(shape) == 2 && len(scales) == int(shape[0]) && (len(scaleShape) == 1 || scaleShape[1] == 1):
+		cols := int(shape[1])
+		for i := range data {
+			data[i] *= scales[i/cols]
+		}
+	case len(shape) == 2 && len(scaleShape) == 2 && len(scales) == int(scaleShape[0]*scaleShape[1]):
+		rows, cols := int(shape[0]), int(shape[1])
+		br := blockSize(rows, int(scaleShape[0]))
+		bc := blockSize(cols, int(scaleShape[1]))
+		for r := range rows {
+			for c := range cols {
+				data[r*cols+c] *= scales[(r/br)*int(scaleShape[1])+c/bc]
+			}
+		}
+	default:
+		return fmt.Errorf("%s has %d scales which don't match its shape %v", st.Name(), len(scales), shape)
+	}
+
+	return nil
+}
+
+// blockSize returns the smallest power of two which splits n into blocks
+func blockSize(n, blocks int) int {
+	size := 1
+	for (n+size-1)/size > blocks {
+		size *= 2
+	}
+
+	return size
+}
+
+// fp8E4M3 decodes FP8 E4M3, which has no infinities and one NaN
+var fp8E4M3 = func() (f [256]float32) {
+	for i := range f {
+		sign := float32(1)
+		if i&0x80 != 0 {
+			sign = -1
+		}
+
+		exp, mantissa := (i>>3)&0xf, float64(i&0x7)
+		switch {
+		case exp == 0xf && mantissa == 7:
+			f[i] = float32(math.NaN())
+		case exp == 0:
+			f[i] = sign * float32(math.Ldexp(mantissa/8, -6))
+		default:
+			f[i] = sign * float32(math.Ldexp(1+mantissa/8, exp-7))
+		}
+	}
+
+	return
+}()
+
+// safetensorFloats decodes bts of type dtype
+func safetensorFloats(dtype string, bts []byte) ([]float32, error) {
+	switch dtype {
 	case "F32":
-		f32s = make([]float32, st.size/4)
-		if err = binary.Read(f, binary.LittleEndian, f32s); err != nil {
-			return 0, err
+		f32s := make([]float32, len(bts)/4)
+		if err := binary.Read(bytes.NewReader(bts), binary.LittleEndian, f32s); err != nil {
+			return nil, err
 		}
+
+		return f32s, nil
 	case "F16":
-		u16s := make([]uint16, st.size/2)
-		if err = binary.Read(f, binary.LittleEndian, u16s); err != nil {
-			return 0, err
+		f32s := make([]float32, len(bts)/2)
+		for i := range f32s {
+			f32s[i] = float16.Frombits(binary.LittleEndian.Uint16(bts[2*i:])).Float32()
 		}
 
-		f32s = make([]float32, len(u16s))
-		for i := range u16s {
-			f32s[i] = float16.Frombits(u16s[i]).Float32()
+		return f32s, nil
+	case "BF16":
+		return bfloat16.DecodeFloat32(bts), nil
+	case "F8_E4M3":
+		f32s := make([]float32, len(bts))
+		for i, b := range bts {
+			f32s[i] = fp8E4M3[b]
 		}
 
-	case "BF16":
-		u8s := make([]uint8, st.size)
-		if err = binary.Read(f, binary.LittleEndian, u8s); err != nil {
-			return 0, err
+		return f32s, nil
+	case "F8_E5M2":
+		// E5M2 is the high byte of an F16
+		f32s := make([]float32, len(bts))
+		for i, b := range bts {
+			f32s[i] = float16.Frombits(uint16(b) << 8).Float32()
 		}
 
-		f32s = bfloat16.DecodeFloat32(u8s)
+		return f32s, nil
 	default:
-		return 0, fmt.Errorf("unknown data type: %s", st.dtype)
-	}
-
-	if st.repacker != nil {
-		f32s, err = st.repacker(st.Name(), f32s, st.Shape())
-		if err != nil {
-			return 0, err
-		}
+		return nil, fmt.Errorf("unknown data type: %s", dtype)
 	}
+}
 
-	switch st.Kind() {
+// writeFloats writes f32s to w as kind
+func writeFloats(w io.Writer, kind uint32, f32s []float32) (int64, error) {
+	switch kind {
 	case tensorKindF32:
-		return 0, binary.Write(w, binary.LittleEndian, f32s)
+		return int64(4 * len(f32s)), binary.Write(w, binary.LittleEndian, f32s)
 	case tensorKindF16:
 		f16s := make([]uint16, len(f32s))
 		for i := range f32s {
 			f16s[i] = float16.Fromfloat32(f32s[i]).Bits()
 		}
 
-		return 0, binary.Write(w, binary.LittleEndian, f16s)
+		return int64(2 * len(f16s)), binary.Write(w, binary.LittleEndian, f16s)
+	case tensorKindBF16:
+		return int64(2 * len(f32s)), binary.Write(w, binary.LittleEndian, bfloat16.EncodeFloat32(f32s))
 	default:
-		return 0, fmt.Errorf("unknown storage type: %d", st.Kind())
+		return 0, fmt.Errorf("unknown storage type: %d", kind)
 	}
 }
@@ -0,0 +1,142 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/d4l3k/go-bfloat16"
+	"github.com/x448/float16"
+)
+
+func TestFP8E4M3(t *testing.T) {
+	cases := map[byte]float32{
+		0x00: 0,
+		0x01: 1.0 / 512,
+		0x38: 1,
+		0x40: 2,
+		0xb8: -1,
+		0x7e: 448,
+	}
+
+	for b, expect := range cases {
+		if got := fp8E4M3[b]; got != expect {
+			t.Errorf("%#x: expected %v, got %v", b, expect, got)
+		}
+	}
+
+	if !math.IsNaN(float64(fp8E4M3[0x7f])) {
+		t.Errorf("expected 0x7f to be NaN, got %v", fp8E4M3[0x7f])
+	}
+}
+
+func TestSafetensorFP8(t *testing.T) {
+	type raw struct {
+		name  string
+		dtype string
+		shape []uint64
+		data  []byte
+	}
+
+	f32s := func(vs ...float32) []byte {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, vs); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	// a 2x4 weight of ones with a 1x2 block of scales, so each row is
+	// scaled by 2 then 3
+	header := make(map[string]safetensorMetadata)
+	var data bytes.Buffer
+	for _, r := range []raw{
+		{"model.layers.0.mlp.up_proj.weight", "F8_E4M3", []uint64{2, 4}, bytes.Repeat([]byte{0x38}, 8)},
+		{"model.layers.0.mlp.up_proj.weight_scale_inv", "F32", []uint64{1, 2}, f32s(2, 3)},
+		{"model.layers.0.mlp.up_proj.input_scale", "F32", []uint64{1}, f32s(0.5)},
+		{"model.norm.weight", "BF16", []uint64{4}, bfloat16.EncodeFloat32([]float32{1, 2, 3, 4})},
+	} {
+		offset := int64(data.Len())
+		data.Write(r.data)
+		header[r.name] = safetensorMetadata{Type: r.dtype, Shape: r.shape, Offsets: []int64{offset, int64(data.Len())}}
+	}
+
+	bts, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, int64(len(bts))); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Write(bts)
+	b.Write(data.Bytes())
+
+	p := t.TempDir()
+	if err := os.WriteFile(filepath.Join(p, "model.safetensors"), b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := parseSafetensors(os.DirFS(p), "model.safetensors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, t := range ts {
+		names = append(names, t.Name())
+	}
+
+	// the scales aren't tensors of the model
+	if expect := []string{"model.layers.0.mlp.up_proj.weight", "model.norm.weight"}; !slices.Equal(names, expect) {
+		t.Fatalf("expected tensors %v, got %v", expect, names)
+	}
+
+	var out bytes.Buffer
+	if _, err := ts[0].WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	f16s := make([]uint16, 8)
+	if err := binary.Read(&out, binary.LittleEndian, f16s); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []float32
+	for _, f := range f16s {
+		got = append(got, float16.Frombits(f).Float32())
+	}
+
+	if expect := []float32{2, 2, 3, 3, 2, 2, 3, 3}; !slices.Equal(got, expect) {
+		t.Errorf("expected dequantized weight %v, got %v", expect, got)
+	}
+
+	t.Run("bf16", func(t *testing.T) {
+		ts[0].SetBF16(true)
+		if kind := ts[0].Kind(); kind != tensorKindBF16 {
+			t.Fatalf("expected kind %d, got %d", tensorKindBF16, kind)
+		}
+
+		var out bytes.Buffer
+		if _, err := ts[0].WriteTo(&out); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := bfloat16.DecodeFloat32(out.Bytes()); !slices.Equal(got, []float32{2, 2, 3, 3, 2, 2, 3, 3}) {
+			t.Errorf("expected BF16 weight, got %v", got)
+		}
+
+		// norms are kept as F32
+		ts[1].SetBF16(true)
+		if kind := ts[1].Kind(); kind != tensorKindF32 {
+			t.Errorf("expected kind %d, got %d", tensorKindF32, kind)
+		}
+	})
+}
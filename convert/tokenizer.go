@@ -11,6 +11,9 @@ import (
 	"log/slog"
 	"os"
 	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
 )
 
 const (
@@ -40,9 +43,15 @@ func parseTokenizer(fsys fs.FS, specialTokenTypes []string) (*Tokenizer, error)
 
 	t := &Tokenizer{
 		Vocabulary: v,
+		Merges:     v.merges,
 		Pre:        "default",
 	}
 
+	if v.merges != nil {
+		// tiktoken files don't have their pattern, Llama 3's is assumed
+		t.Pre = "llama-bpe"
+	}
+
 	addedTokens := make(map[string]token)
 	if f, err := fsys.Open("tokenizer.json"); errors.Is(err, os.ErrNotExist) {
 	} else if err != nil {
@@ -61,16 +70,11 @@ func parseTokenizer(fsys fs.FS, specialTokenTypes []string) (*Tokenizer, error)
 
 		t.Merges = tt.Model.Merges
 
+		// create a checksum of all Split pretokenizers which should be sufficient
+		// to identify the pretokenizer
 		sha256sum := sha256.New()
-		for _, pt := range tt.PreTokenizer.PreTokenizers {
-			switch pt.Type {
-			case "Split":
-				if pt.Pattern.Regex != "" {
-					// create a checksum of all Split pretokenizers which should be sufficient
-					// to identify the pretokenizer
-					sha256sum.Write([]byte(pt.Pattern.Regex))
-				}
-			}
+		for _, regex := range tt.PreTokenizer.splits() {
+			sha256sum.Write([]byte(regex))
 		}
 
 		switch digest := hex.EncodeToString(sha256sum.Sum(nil)); digest {
@@ -80,6 +84,8 @@ func parseTokenizer(fsys fs.FS, specialTokenTypes []string) (*Tokenizer, error)
 			t.Pre = "deepseek-llm"
 		case "21cde974d587f0d54dc8d56b183cc1e6239600172035c68fbd6d4b9f8da0576e":
 			t.Pre = "deepseek-coder"
+		case "1ff7f41064896984db5d1bb6ff64fa4bc29007d08c1b439e505b7392777a319e":
+			t.Pre = "qwen2"
 		case "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855":
 			// noop, empty pretokenizer
 		default:
@@ -104,6 +110,21 @@ func parseTokenizer(fsys fs.FS, specialTokenTypes []string) (*Tokenizer, error)
 			}
 		}
 
+		// added_tokens_decoder has the special tokens of tokenizers without a
+		// tokenizer.json, such as tiktoken's
+		if bts, ok := p["added_tokens_decoder"]; ok {
+			decoder, err := parseAddedTokensDecoder(bts)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, at := range decoder {
+				if _, ok := addedTokens[at.Content]; !ok {
+					addedTokens[at.Content] = at
+				}
+			}
+		}
+
 		for _, st := range specialTokenTypes {
 			sv := SpecialVocabulary{Type: st}
 			if bts, ok := p[fmt.Sprintf("add_%s_token", st)]; ok {
@@ -129,33 +150,105 @@ func parseTokenizer(fsys fs.FS, specialTokenTypes []string) (*Tokenizer, error)
 				sv.Content = content
 			}
 
-			if id, ok := addedTokens[sv.Content]; ok {
-				sv.ID = id.ID
+			if id, ok := t.tokenID(addedTokens, sv.Content); ok {
+				sv.ID = id
 				t.SpecialVocabulary = append(t.SpecialVocabulary, &sv)
 			}
 		}
 	}
 
+	// chat models end their turns with a special token which often isn't their
+	// eos token
+	for _, content := range []string{"<|eot_id|>", "<|im_end|>", "<|end|>", "<end_of_turn>", "<|END_OF_TURN_TOKEN|>"} {
+		if id, ok := t.tokenID(addedTokens, content); ok {
+			t.SpecialVocabulary = append(t.SpecialVocabulary, &SpecialVocabulary{Type: "eot", ID: id, Content: content})
+			break
+		}
+	}
+
 	return t, nil
 }
 
+// tokenID returns the ID of the token with content, looking in the added
+// tokens before the vocabulary
+func (t *Tokenizer) tokenID(addedTokens map[string]token, content string) (int, bool) {
+	if content == "" {
+		return 0, false
+	}
+
+	if at, ok := addedTokens[content]; ok {
+		return at.ID, true
+	}
+
+	if i := slices.Index(t.Vocabulary.Tokens, content); i >= 0 {
+		return i, true
+	}
+
+	return 0, false
+}
+
 type tokenizer struct {
 	Version     string  `json:"version"`
 	AddedTokens []token `json:"added_tokens"`
 	Model       struct {
-		Type   string         `json:"type"`
-		Vocab  map[string]int `json:"vocab"`
-		Merges []string       `json:"merges"`
+		Type         string          `json:"type"`
+		Vocab        json.RawMessage `json:"vocab"`
+		Merges       merges          `json:"merges"`
+		ByteFallback bool            `json:"byte_fallback"`
+		UnkToken     string          `json:"unk_token"`
 	} `json:"model"`
 
-	PreTokenizer struct {
-		PreTokenizers []struct {
-			Type    string `json:"type"`
-			Pattern struct {
-				Regex string `json:"Regex"`
-			} `json:"pattern"`
-		} `json:"pretokenizers"`
-	} `json:"pre_tokenizer"`
+	PreTokenizer preTokenizer `json:"pre_tokenizer"`
+}
+
+// merges are "a b" strings or, since tokenizers 0.20, ["a", "b"] pairs
+type merges []string
+
+func (m *merges) UnmarshalJSON(bts []byte) error {
+	var ss []string
+	if err := json.Unmarshal(bts, &ss); err == nil {
+		*m = ss
+		return nil
+	}
+
+	var pairs [][]string
+	if err := json.Unmarshal(bts, &pairs); err != nil {
+		return err
+	}
+
+	*m = make(merges, len(pairs))
+	for i, pair := range pairs {
+		if len(pair) != 2 {
+			return fmt.Errorf("invalid merge %v", pair)
+		}
+
+		(*m)[i] = pair[0] + " " + pair[1]
+	}
+
+	return nil
+}
+
+type preTokenizer struct {
+	Type    string `json:"type"`
+	Pattern struct {
+		Regex string `json:"Regex"`
+	} `json:"pattern"`
+	PreTokenizers []preTokenizer `json:"pretokenizers"`
+}
+
+// splits returns the regular expressions of the Split pretokenizers, which may
+// be the pretokenizer itself or in a Sequence
+func (pt preTokenizer) splits() []string {
+	if pt.Type == "Split" && pt.Pattern.Regex != "" {
+		return []string{pt.Pattern.Regex}
+	}
+
+	var regexes []string
+	for _, pt := range pt.PreTokenizers {
+		regexes = append(regexes, pt.splits()...)
+	}
+
+	return regexes
 }
 
 type token struct {
@@ -163,6 +256,8 @@ type token struct {
 	Content     string `json:"content"`
 	Special     bool   `json:"special"`
 	UserDefined bool
+
+	score float32
 }
 
 type Vocabulary struct {
@@ -170,6 +265,9 @@ type Vocabulary struct {
 	Tokens []string
 	Scores []float32
 	Types  []int32
+
+	// merges are those implied by the vocabulary, e.g. by tiktoken's ranks
+	merges []string
 }
 
 func parseVocabularyFromTokenizer(fsys fs.FS) (*Vocabulary, error) {
@@ -184,33 +282,79 @@ func parseVocabularyFromTokenizer(fsys fs.FS) (*Vocabulary, error) {
 		return nil, err
 	}
 
-	var tokens []token
-	for k, v := range t.Model.Vocab {
-		tokens = append(tokens, token{
-			ID:      v,
-			Content: k,
-		})
+	v := Vocabulary{Model: "gpt2"}
+
+	tokens := make(map[int]token)
+	switch t.Model.Type {
+	case "Unigram":
+		// unigram vocabularies are a list of pieces and their scores
+		var pieces [][2]any
+		if err := json.Unmarshal(t.Model.Vocab, &pieces); err != nil {
+			return nil, err
+		}
+
+		v.Model = "t5"
+		for i, piece := range pieces {
+			content, _ := piece[0].(string)
+			score, _ := piece[1].(float64)
+			tokens[i] = token{ID: i, Content: content, score: float32(score)}
+		}
+	default:
+		switch {
+		case t.Model.Type == "WordPiece":
+			v.Model = "bert"
+		case t.Model.Type == "BPE" && t.Model.ByteFallback:
+			// sentencepiece BPE, like llama's, which falls back to <0xXX> byte
+			// tokens instead of using byte level tokens
+			v.Model = "llama"
+		}
+
+		var vocab map[string]int
+		if err := json.Unmarshal(t.Model.Vocab, &vocab); err != nil {
+			return nil, err
+		}
+
+		for k, id := range vocab {
+			score := float32(id)
+			if v.Model == "llama" {
+				// sentencepiece merges the highest scoring pieces first
+				score = -score
+			}
+
+			tokens[id] = token{ID: id, Content: k, score: score}
+		}
 	}
 
+	// added tokens may also be in the vocabulary, e.g. [CLS] in WordPiece vocabularies
 	for _, t := range t.AddedTokens {
 		t.UserDefined = true
-		tokens = append(tokens, t)
+		t.score = float32(t.ID)
+		if existing, ok := tokens[t.ID]; ok {
+			t.score = existing.score
+		}
+
+		tokens[t.ID] = t
 	}
 
-	slices.SortFunc(tokens, func(i, j token) int {
+	sorted := maps.Values(tokens)
+	slices.SortFunc(sorted, func(i, j token) int {
 		return cmp.Compare(i.ID, j.ID)
 	})
 
-	v := Vocabulary{Model: "gpt2"}
-	for _, t := range tokens {
+	unk := t.Model.UnkToken
+	for _, t := range sorted {
 		v.Tokens = append(v.Tokens, t.Content)
-		v.Scores = append(v.Scores, float32(t.ID))
+		v.Scores = append(v.Scores, t.score)
 
 		switch {
 		case t.Special:
 			v.Types = append(v.Types, tokenTypeControl)
 		case t.UserDefined:
 			v.Types = append(v.Types, tokenTypeUserDefined)
+		case v.Model == "llama" && t.Content == unk:
+			v.Types = append(v.Types, tokenTypeUnknown)
+		case v.Model == "llama" && isByteToken(t.Content):
+			v.Types = append(v.Types, tokenTypeByte)
 		default:
 			v.Types = append(v.Types, tokenTypeNormal)
 		}
@@ -219,6 +363,16 @@ func parseVocabularyFromTokenizer(fsys fs.FS) (*Vocabulary, error) {
 	return &v, nil
 }
 
+// isByteToken reports whether s is a sentencepiece byte token, e.g. <0x0A>
+func isByteToken(s string) bool {
+	if len(s) != 6 || !strings.HasPrefix(s, "<0x") || !strings.HasSuffix(s, ">") {
+		return false
+	}
+
+	_, err := hex.DecodeString(s[3:5])
+	return err == nil
+}
+
 func parseVocabulary(fsys fs.FS) (*Vocabulary, error) {
 	patterns := []struct {
 		Pattern string
@@ -226,6 +380,9 @@ func parseVocabulary(fsys fs.FS) (*Vocabulary, error) {
 	}{
 		{"tokenizer.model", parseSentencePiece},
 		{"tokenizer.json", parseVocabularyFromTokenizer},
+		// tiktoken files are also named tokenizer.model but tokenizer.json is
+		// preferred as it has the added tokens and pretokenizer
+		{"tokenizer.model", parseTiktoken},
 	}
 
 	for _, pattern := range patterns {
@@ -235,10 +392,15 @@ func parseVocabulary(fsys fs.FS) (*Vocabulary, error) {
 			return nil, err
 		}
 
-		return pattern.Func(fsys)
+		v, err := pattern.Func(fsys)
+		if errors.Is(err, errTiktoken) {
+			continue
+		}
+
+		return v, err
 	}
 
-	return nil, errors.New("unknown tensor format")
+	return nil, errors.New("unknown tokenizer format")
 }
 
 type SpecialVocabulary struct {
@@ -250,7 +412,7 @@ type SpecialVocabulary struct {
 
 func (sv SpecialVocabulary) Key() string {
 	switch t := sv.Type; t {
-	case "bos", "eos", "cls", "mask":
+	case "bos", "eos", "cls", "mask", "eot":
 		return t
 	case "unk":
 		return "unknown"
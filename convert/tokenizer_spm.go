@@ -20,6 +20,10 @@ func parseSentencePiece(fsys fs.FS) (*Vocabulary, error) {
 		return nil, err
 	}
 
+	if isTiktoken(bts) {
+		return nil, errTiktoken
+	}
+
 	var spm sentencepiece.ModelProto
 	if err := proto.Unmarshal(bts, &spm); err != nil {
 		return nil, err
@@ -0,0 +1,175 @@
+package convert
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTokenizerMergePairs(t *testing.T) {
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+		"model": map[string]any{
+			"type":   "BPE",
+			"vocab":  map[string]int{"a": 0, "b": 1, "ab": 2},
+			"merges": [][]string{{"a", "b"}},
+		},
+		"pre_tokenizer": map[string]any{
+			"type": "Split",
+			// Llama 3's pattern
+			"pattern": map[string]any{"Regex": `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+		},
+	})
+
+	tok, err := parseTokenizer(os.DirFS(p), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(tok.Merges, []string{"a b"}) {
+		t.Errorf("unexpected merges %v", tok.Merges)
+	}
+
+	if tok.Pre != "llama-bpe" {
+		t.Errorf("expected pretokenizer llama-bpe, got %s", tok.Pre)
+	}
+}
+
+func TestParseTokenizerSpecialTokens(t *testing.T) {
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+		"model": map[string]any{
+			"type":  "BPE",
+			"vocab": map[string]int{"<s>": 0, "a": 1, "<|im_end|>": 2, "<|endoftext|>": 3},
+		},
+		"added_tokens": []map[string]any{
+			{"id": 2, "content": "<|im_end|>", "special": true},
+			{"id": 3, "content": "<|endoftext|>", "special": true},
+		},
+	})
+	writeJSON(t, filepath.Join(p, "tokenizer_config.json"), map[string]any{
+		// <s> isn't an added token so it's found in the vocabulary
+		"bos_token":     "<s>",
+		"add_bos_token": true,
+		"eos_token":     map[string]any{"content": "<|endoftext|>"},
+	})
+
+	tok, err := parseTokenizer(os.DirFS(p), []string{"bos", "eos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := make(map[string]SpecialVocabulary)
+	for _, sv := range tok.SpecialVocabulary {
+		actual[sv.Type] = *sv
+	}
+
+	expect := map[string]SpecialVocabulary{
+		"bos": {Type: "bos", ID: 0, Content: "<s>", AddToken: true},
+		"eos": {Type: "eos", ID: 3, Content: "<|endoftext|>"},
+		"eot": {Type: "eot", ID: 2, Content: "<|im_end|>"},
+	}
+
+	if len(actual) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, actual)
+	}
+
+	for k, v := range expect {
+		if actual[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, actual[k])
+		}
+	}
+
+	kv := Parameters{}.KV(tok)
+	if kv["tokenizer.ggml.eot_token_id"] != uint32(2) {
+		t.Errorf("expected eot token 2, got %v", kv["tokenizer.ggml.eot_token_id"])
+	}
+
+	if _, ok := kv["tokenizer.ggml.add_eot_token"]; ok {
+		t.Error("unexpected add_eot_token")
+	}
+}
+
+func TestParseTokenizerByteFallback(t *testing.T) {
+	p := t.TempDir()
+	writeJSON(t, filepath.Join(p, "tokenizer.json"), map[string]any{
+		"model": map[string]any{
+			"type":          "BPE",
+			"byte_fallback": true,
+			"unk_token":     "<unk>",
+			"vocab":         map[string]int{"<unk>": 0, "<s>": 1, "<0x0A>": 2, "▁a": 3},
+		},
+		"added_tokens": []map[string]any{
+			{"id": 1, "content": "<s>", "special": true},
+		},
+	})
+
+	tok, err := parseTokenizer(os.DirFS(p), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tok.Vocabulary.Model != "llama" {
+		t.Errorf("expected llama vocabulary, got %s", tok.Vocabulary.Model)
+	}
+
+	if expect := []int32{tokenTypeUnknown, tokenTypeControl, tokenTypeByte, tokenTypeNormal}; !slices.Equal(tok.Vocabulary.Types, expect) {
+		t.Errorf("expected types %v, got %v", expect, tok.Vocabulary.Types)
+	}
+
+	if tok.Vocabulary.Scores[3] >= tok.Vocabulary.Scores[2] {
+		t.Errorf("expected scores to decrease with id, got %v", tok.Vocabulary.Scores)
+	}
+}
+
+func TestParseTokenizerTiktoken(t *testing.T) {
+	p := t.TempDir()
+
+	var sb strings.Builder
+	for rank, token := range []string{"a", "b", " ", "\n", "ab", " ab", "aba"} {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+
+	if err := os.WriteFile(filepath.Join(p, "tokenizer.model"), []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeJSON(t, filepath.Join(p, "tokenizer_config.json"), map[string]any{
+		"bos_token": "<|begin_of_text|>",
+		"added_tokens_decoder": map[string]any{
+			"7": map[string]any{"content": "<|begin_of_text|>", "special": true},
+			"9": map[string]any{"content": "<|eot_id|>", "special": true},
+		},
+	})
+
+	tok, err := parseTokenizer(os.DirFS(p), []string{"bos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tok.Vocabulary.Model != "gpt2" || tok.Pre != "llama-bpe" {
+		t.Errorf("unexpected model %s and pretokenizer %s", tok.Vocabulary.Model, tok.Pre)
+	}
+
+	// bytes are mapped to GPT-2's byte level characters
+	if expect := []string{"a", "b", "Ġ", "Ċ", "ab", "Ġab", "aba", "<|begin_of_text|>", "[PAD8]", "<|eot_id|>"}; !slices.Equal(tok.Vocabulary.Tokens, expect) {
+		t.Errorf("expected tokens %v, got %v", expect, tok.Vocabulary.Tokens)
+	}
+
+	if expect := []string{"a b", "Ġ ab", "ab a"}; !slices.Equal(tok.Merges, expect) {
+		t.Errorf("expected merges %v, got %v", expect, tok.Merges)
+	}
+
+	var ids []int
+	for _, sv := range tok.SpecialVocabulary {
+		ids = append(ids, sv.ID)
+	}
+
+	if !slices.Equal(ids, []int{7, 9}) {
+		t.Errorf("expected bos and eot tokens 7 and 9, got %v", ids)
+	}
+}
@@ -0,0 +1,228 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errTiktoken is returned by parseSentencePiece for tiktoken files
+var errTiktoken = errors.New("tokenizer.model is a tiktoken file")
+
+// isTiktoken reports whether bts looks like a tiktoken BPE file, i.e. lines of
+// a base64 encoded token and its rank
+func isTiktoken(bts []byte) bool {
+	line, _, _ := bytes.Cut(bts, []byte("\n"))
+	token, rank, ok := strings.Cut(string(line), " ")
+	if !ok {
+		return false
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(token); err != nil {
+		return false
+	}
+
+	_, err := strconv.Atoi(rank)
+	return err == nil
+}
+
+// parseTiktoken parses a tiktoken BPE file, such as Llama 3's original
+// tokenizer.model, into a byte level BPE vocabulary. tiktoken merges the
+// lowest ranked pairs first so the merges are recovered from the ranks.
+func parseTiktoken(fsys fs.FS) (*Vocabulary, error) {
+	bts, err := fs.ReadFile(fsys, "tokenizer.model")
+	if err != nil {
+		return nil, err
+	}
+
+	if !isTiktoken(bts) {
+		return nil, errors.New("tokenizer.model isn't a tiktoken file")
+	}
+
+	var tokens []string
+	ranks := make(map[string]int)
+
+	s := bufio.NewScanner(bytes.NewReader(bts))
+	for s.Scan() {
+		if s.Text() == "" {
+			continue
+		}
+
+		token, rank, ok := strings.Cut(s.Text(), " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid tiktoken line %q", s.Text())
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, err
+		} else if n != len(tokens) {
+			return nil, fmt.Errorf("invalid tiktoken rank %d, expected %d", n, len(tokens))
+		}
+
+		ranks[string(decoded)] = n
+		tokens = append(tokens, string(decoded))
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	v := Vocabulary{Model: "gpt2"}
+	for rank, token := range tokens {
+		v.Tokens = append(v.Tokens, byteLevel(token))
+		v.Scores = append(v.Scores, float32(rank))
+		v.Types = append(v.Types, tokenTypeNormal)
+
+		if len(token) < 2 {
+			continue
+		}
+
+		parts := tiktokenMerge(ranks, token, rank)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("tiktoken token %d can't be merged from lower ranked tokens", rank)
+		}
+
+		v.merges = append(v.merges, byteLevel(parts[0])+" "+byteLevel(parts[1]))
+	}
+
+	// the special tokens follow the ranked tokens
+	added, err := tiktokenAddedTokens(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, at := range added {
+		if at.ID < len(v.Tokens) {
+			return nil, fmt.Errorf("invalid token id: %d", at.ID)
+		}
+
+		for i := len(v.Tokens); i < at.ID; i++ {
+			v.Tokens = append(v.Tokens, fmt.Sprintf("[PAD%d]", i))
+			v.Scores = append(v.Scores, -1)
+			v.Types = append(v.Types, tokenTypeUserDefined)
+		}
+
+		v.Tokens = append(v.Tokens, at.Content)
+		v.Scores = append(v.Scores, float32(at.ID))
+		if at.Special {
+			v.Types = append(v.Types, tokenTypeControl)
+		} else {
+			v.Types = append(v.Types, tokenTypeUserDefined)
+		}
+	}
+
+	return &v, nil
+}
+
+// tiktokenMerge merges the bytes of token like tiktoken does, with tokens
+// ranked lower than rank, leaving the two parts token is merged from
+func tiktokenMerge(ranks map[string]int, token string, rank int) []string {
+	parts := make([]string, 0, len(token))
+	for i := range len(token) {
+		parts = append(parts, token[i:i+1])
+	}
+
+	for {
+		best, bestRank := -1, rank
+		for i := range len(parts) - 1 {
+			if r, ok := ranks[parts[i]+parts[i+1]]; ok && r < bestRank {
+				best, bestRank = i, r
+			}
+		}
+
+		if best < 0 {
+			return parts
+		}
+
+		parts = slices.Replace(parts, best, best+2, parts[best]+parts[best+1])
+	}
+}
+
+// byteLevel encodes s in the printable characters GPT-2's byte level BPE
+// maps bytes to
+func byteLevel(s string) string {
+	var sb strings.Builder
+	for i := range len(s) {
+		sb.WriteRune(byteLevelRunes[s[i]])
+	}
+
+	return sb.String()
+}
+
+var byteLevelRunes = func() (runes [256]rune) {
+	n := 0
+	for b := range 256 {
+		if (b >= '!' && b <= '~') || (b >= 0xa1 && b <= 0xac) || (b >= 0xae && b <= 0xff) {
+			runes[b] = rune(b)
+		} else {
+			runes[b] = rune(256 + n)
+			n++
+		}
+	}
+
+	return runes
+}()
+
+// tiktokenAddedTokens returns the added tokens in tokenizer_config.json's
+// added_tokens_decoder, ordered by ID
+func tiktokenAddedTokens(fsys fs.FS) ([]token, error) {
+	bts, err := fs.ReadFile(fsys, "tokenizer_config.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var p struct {
+		AddedTokensDecoder json.RawMessage `json:"added_tokens_decoder"`
+	}
+
+	if err := json.Unmarshal(bts, &p); err != nil {
+		return nil, err
+	} else if p.AddedTokensDecoder == nil {
+		return nil, nil
+	}
+
+	return parseAddedTokensDecoder(p.AddedTokensDecoder)
+}
+
+// parseAddedTokensDecoder parses tokenizer_config.json's added_tokens_decoder,
+// added tokens keyed by their ID, ordered by ID
+func parseAddedTokensDecoder(bts []byte) ([]token, error) {
+	var decoder map[string]token
+	if err := json.Unmarshal(bts, &decoder); err != nil {
+		return nil, err
+	}
+
+	tokens := make([]token, 0, len(decoder))
+	for k, t := range decoder {
+		id, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token id: %s", k)
+		}
+
+		t.ID = id
+		tokens = append(tokens, t)
+	}
+
+	slices.SortFunc(tokens, func(i, j token) int {
+		return cmp.Compare(i.ID, j.ID)
+	})
+
+	return tokens, nil
+}
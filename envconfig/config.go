@@ -15,11 +15,41 @@ import (
 )
 
 // Host returns the scheme and host. Host can be configured via the OLLAMA_HOST environment variable.
-// Default is scheme "http" and host "127.0.0.1:11434"
+// Default is scheme "http" and host "127.0.0.1:11434". When OLLAMA_HOST lists several addresses,
+// Host is the first.
 func Host() *url.URL {
+	u := Hosts()[0]
+	u.RawQuery = ""
+	return u
+}
+
+// Hosts returns the addresses the server listens on. Hosts can be configured via the OLLAMA_HOST
+// environment variable as a comma separated list, e.g. "127.0.0.1:11434,[::1]:11434", including
+// unix sockets such as "unix:///run/ollama.sock". The settings of each listener are its query
+// parameters.
+func Hosts() []*url.URL {
+	var hosts []*url.URL
+	for _, s := range strings.Split(Var("OLLAMA_HOST"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			hosts = append(hosts, host(s))
+		}
+	}
+
+	if len(hosts) == 0 {
+		hosts = append(hosts, host(""))
+	}
+
+	return hosts
+}
+
+func host(s string) *url.URL {
 	defaultPort := "11434"
 
-	s := strings.TrimSpace(Var("OLLAMA_HOST"))
+	s, query, _ := strings.Cut(s, "?")
+	if path, ok := strings.CutPrefix(s, "unix://"); ok {
+		return &url.URL{Scheme: "unix", Path: path, RawQuery: query}
+	}
+
 	scheme, hostport, ok := strings.Cut(s, "://")
 	switch {
 	case !ok:
@@ -45,15 +75,13 @@ func Host() *url.URL {
 
 	if n, err := strconv.ParseInt(port, 10, 32); err != nil || n > 65535 || n < 0 {
 		slog.Warn("invalid port, using default", "port", port, "default", defaultPort)
-		return &url.URL{
-			Scheme: scheme,
-			Host:   net.JoinHostPort(host, defaultPort),
-		}
+		port = defaultPort
 	}
 
 	return &url.URL{
-		Scheme: scheme,
-		Host:   net.JoinHostPort(host, port),
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(host, port),
+		RawQuery: query,
 	}
 }
 
@@ -81,10 +109,38 @@ func Origins() (origins []string) {
 	return origins
 }
 
-// Models returns the path to the models directory. Models directory can be configured via the OLLAMA_MODELS environment variable.
+// Models returns the path to the first models directory, where new models are written. Models directory can be configured via the OLLAMA_MODELS environment variable.
 // Default is $HOME/.ollama/models
 func Models() string {
-	if s := Var("OLLAMA_MODELS"); s != "" {
+	path, _, _ := strings.Cut(ModelsDirs()[0], "?")
+	return path
+}
+
+// ModelsDirs returns the models directories. OLLAMA_MODELS can be a comma separated list of paths, each optionally
+// followed by placement policies as a query string, e.g. /mnt/nvme?max=200GB&models=llama3*,/mnt/hdd
+func ModelsDirs() (dirs []string) {
+	for _, dir := range strings.Split(Var("OLLAMA_MODELS"), ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	if len(dirs) > 0 {
+		return dirs
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+
+	return []string{filepath.Join(home, ".ollama", "models")}
+}
+
+// HistoryDir returns the directory the usage history of the server is kept in. HistoryDir can be configured via the
+// OLLAMA_HISTORY_DIR environment variable. Default is $HOME/.ollama/usage.
+func HistoryDir() string {
+	if s := Var("OLLAMA_HISTORY_DIR"); s != "" {
 		return s
 	}
 
@@ -93,7 +149,44 @@ func Models() string {
 		panic(err)
 	}
 
-	return filepath.Join(home, ".ollama", "models")
+	return filepath.Join(home, ".ollama", "usage")
+}
+
+// Webhooks returns the URLs which are sent server events. Webhooks can be configured via the OLLAMA_WEBHOOKS environment
+// variable as a comma separated list of URLs.
+func Webhooks() (urls []string) {
+	for _, u := range strings.Split(Var("OLLAMA_WEBHOOKS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	return urls
+}
+
+// StickyModels returns the names of the models which are kept loaded when another model needs their memory. StickyModels
+// can be configured via the OLLAMA_STICKY_MODELS environment variable as a comma separated list of model names.
+func StickyModels() (names []string) {
+	for _, name := range strings.Split(Var("OLLAMA_STICKY_MODELS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// WarmupModels returns the names of the models which generate a token as soon as they're loaded, so the first request
+// isn't slowed by compiling kernels and filling caches. WarmupModels can be configured via the OLLAMA_WARMUP_MODELS
+// environment variable as a comma separated list of model names.
+func WarmupModels() (names []string) {
+	for _, name := range strings.Split(Var("OLLAMA_WARMUP_MODELS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
 }
 
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
@@ -116,6 +209,139 @@ func KeepAlive() (keepAlive time.Duration) {
 	return keepAlive
 }
 
+// ResponseCacheTTL returns the duration responses are cached for. ResponseCacheTTL can be configured via the
+// OLLAMA_RESPONSE_CACHE_TTL environment variable. The response cache is disabled by default.
+func ResponseCacheTTL() (ttl time.Duration) {
+	if s := Var("OLLAMA_RESPONSE_CACHE_TTL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			ttl = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ttl = time.Duration(n) * time.Second
+		}
+	}
+
+	return max(ttl, 0)
+}
+
+// GCInterval returns how often unreferenced blobs and stale partial downloads are removed. GCInterval can be
+// configured via the OLLAMA_GC_INTERVAL environment variable. Default is 24 hours, and 0 disables garbage collection.
+func GCInterval() time.Duration {
+	if s := Var("OLLAMA_GC_INTERVAL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			return max(d, 0)
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return max(time.Duration(n)*time.Second, 0)
+		}
+	}
+
+	return 24 * time.Hour
+}
+
+// GPUPlacement returns a map of model names to the GPUs they may be loaded on. GPUPlacement can be configured via the
+// OLLAMA_GPU_PLACEMENT environment variable as a semicolon separated list of model=gpus entries, e.g. "all-minilm=1;llama3=0".
+// GPUs are a comma separated list of GPU IDs or indexes.
+func GPUPlacement() map[string]string {
+	placement := make(map[string]string)
+	for _, entry := range strings.Split(Var("OLLAMA_GPU_PLACEMENT"), ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, gpus, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(gpus) == "" {
+			slog.Warn("invalid gpu placement, ignoring", "entry", entry)
+			continue
+		}
+
+		placement[strings.TrimSpace(name)] = strings.TrimSpace(gpus)
+	}
+
+	return placement
+}
+
+// MaxTokenRates returns the most tokens per second models may generate, by
+// model name. MaxTokenRates can be configured via the OLLAMA_MAX_TOKEN_RATE
+// environment variable as a semicolon separated list of model=rate entries,
+// e.g. "summarizer=10;llama3=40".
+func MaxTokenRates() map[string]float32 {
+	rates := make(map[string]float32)
+	for _, entry := range strings.Split(Var("OLLAMA_MAX_TOKEN_RATE"), ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, rate, ok := strings.Cut(entry, "=")
+		f, err := strconv.ParseFloat(strings.TrimSpace(rate), 32)
+		if !ok || strings.TrimSpace(name) == "" || err != nil || f <= 0 {
+			slog.Warn("invalid max token rate, ignoring", "entry", entry)
+			continue
+		}
+
+		rates[strings.TrimSpace(name)] = float32(f)
+	}
+
+	return rates
+}
+
+// RunnerLogLevels returns how verbosely the runners of models log, one of error, warn, info or debug, by model name.
+// The level of models without their own is under the empty name. RunnerLogLevels can be configured via the
+// OLLAMA_RUNNER_LOG_LEVEL environment variable as a semicolon separated list of a level for every model and model=level
+// entries, e.g. "warn;llama3=debug". Runners log at debug if OLLAMA_DEBUG is set and info otherwise by default.
+func RunnerLogLevels() map[string]string {
+	levels := map[string]string{"": "info"}
+	if Debug() {
+		levels[""] = "debug"
+	}
+
+	for _, entry := range strings.Split(Var("OLLAMA_RUNNER_LOG_LEVEL"), ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, level, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, level = "", name
+		}
+
+		name, level = strings.TrimSpace(name), strings.ToLower(strings.TrimSpace(level))
+		switch level {
+		case "error", "warn", "info", "debug":
+		default:
+			slog.Warn("invalid runner log level, ignoring", "entry", entry)
+			continue
+		}
+
+		if ok && name == "" {
+			slog.Warn("invalid runner log level, ignoring", "entry", entry)
+			continue
+		}
+
+		levels[name] = level
+	}
+
+	return levels
+}
+
+// GPU placement policies returned by GPUPolicy
+const (
+	GPUPolicyFastest      = "fastest"
+	GPUPolicyProportional = "proportional"
+)
+
+// GPUPolicy returns how models are placed on GPUs of different sizes and speeds. GPUPolicy can be configured via the
+// OLLAMA_GPU_POLICY environment variable as fastest, to load models on the fewest and fastest GPUs they fit on, or
+// proportional, to split models across GPUs in proportion to their free memory. By default models are loaded on the
+// single GPU with the most free memory they fit on, or else split evenly across all GPUs.
+func GPUPolicy() string {
+	switch s := strings.ToLower(strings.TrimSpace(Var("OLLAMA_GPU_POLICY"))); s {
+	case "", GPUPolicyFastest, GPUPolicyProportional:
+		return s
+	default:
+		slog.Warn("invalid gpu policy, ignoring", "OLLAMA_GPU_POLICY", s)
+		return ""
+	}
+}
+
 func Bool(k string) func() bool {
 	return func() bool {
 		if s := Var(k); s != "" {
@@ -138,12 +364,18 @@ var (
 	FlashAttention = Bool("OLLAMA_FLASH_ATTENTION")
 	// NoHistory disables readline history.
 	NoHistory = Bool("OLLAMA_NOHISTORY")
+	// JSONErrors prints the errors of the CLI as JSON objects, with their class and exit code.
+	JSONErrors = Bool("OLLAMA_JSON_ERRORS")
 	// NoPrune disables pruning of model blobs on startup.
 	NoPrune = Bool("OLLAMA_NOPRUNE")
 	// SchedSpread allows scheduling models across all GPUs.
 	SchedSpread = Bool("OLLAMA_SCHED_SPREAD")
 	// IntelGPU enables experimental Intel GPU detection.
 	IntelGPU = Bool("OLLAMA_INTEL_GPU")
+	// RegistryCache serves models pulled from the registry to other servers, pulling them when they're requested.
+	RegistryCache = Bool("OLLAMA_REGISTRY_CACHE")
+	// ZstdTransfers asks registries to send layers compressed with zstd when they can.
+	ZstdTransfers = Bool("OLLAMA_ZSTD_TRANSFERS")
 )
 
 func String(s string) func() string {
@@ -153,14 +385,46 @@ func String(s string) func() string {
 }
 
 var (
-	LLMLibrary = String("OLLAMA_LLM_LIBRARY")
-	TmpDir     = String("OLLAMA_TMPDIR")
+	LLMLibrary  = String("OLLAMA_LLM_LIBRARY")
+	TmpDir      = String("OLLAMA_TMPDIR")
+	KVCacheType = String("OLLAMA_KV_CACHE_TYPE")
+	// CPUVariant overrides the vector extensions detected on the CPU, one
+	// of none, avx, avx2 or avx512, which select the CPU runner.
+	CPUVariant = String("OLLAMA_CPU_VARIANT")
+	// RunnerLogDir is the directory the runners of models log to, one file per model, instead of the server's log.
+	RunnerLogDir = String("OLLAMA_RUNNER_LOG_DIR")
+	// DebugAddr is the address pprof, expvar and goroutine stacks are served on, if any.
+	DebugAddr = String("OLLAMA_DEBUG_ADDR")
+	// Store is the URL of an object store, such as s3://bucket/prefix, which
+	// holds the models shared by several servers.
+	Store = String("OLLAMA_STORE")
+	// RegistryMirror is the URL of a server with OLLAMA_REGISTRY_CACHE set
+	// which models are pulled through instead of ollama.com.
+	RegistryMirror = String("OLLAMA_REGISTRY_MIRROR")
+	// TrustPolicy is the path of a file listing the signers whose cosign
+	// signatures models pulled from a registry or namespace must have.
+	TrustPolicy = String("OLLAMA_TRUST_POLICY")
+	// Registries is the path of a file of the proxy and TLS settings of
+	// registry hosts.
+	Registries = String("OLLAMA_REGISTRIES")
+	// WebhookSecret is the key webhook events are signed with.
+	WebhookSecret = String("OLLAMA_WEBHOOK_SECRET")
+	// APIKey is the key the client sends to servers which require one.
+	APIKey = String("OLLAMA_API_KEY")
+	// Theme is the color theme of the CLI, default or high-contrast.
+	Theme = String("OLLAMA_THEME")
+	// Lang is the language of the CLI's messages, overriding the locale's.
+	Lang = String("OLLAMA_LANG")
+	// VisibleGPUs is a comma separated list of the IDs or indexes of the GPUs
+	// Ollama may use. All detected GPUs are used if it's not set.
+	VisibleGPUs = String("OLLAMA_VISIBLE_GPUS")
 
 	CudaVisibleDevices    = String("CUDA_VISIBLE_DEVICES")
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
 	RocrVisibleDevices    = String("ROCR_VISIBLE_DEVICES")
 	GpuDeviceOrdinal      = String("GPU_DEVICE_ORDINAL")
 	HsaOverrideGfxVersion = String("HSA_OVERRIDE_GFX_VERSION")
+	OneapiDeviceSelector  = String("ONEAPI_DEVICE_SELECTOR")
 )
 
 func RunnersDir() (p string) {
@@ -231,8 +495,131 @@ var (
 	MaxRunners = Uint("OLLAMA_MAX_LOADED_MODELS", 0)
 	// MaxQueue sets the maximum number of queued requests. MaxQueue can be configured via the OLLAMA_MAX_QUEUE environment variable.
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
+	// ResponseCacheSize sets the maximum number of cached responses. ResponseCacheSize can be configured via the OLLAMA_RESPONSE_CACHE_SIZE environment variable.
+	ResponseCacheSize = Uint("OLLAMA_RESPONSE_CACHE_SIZE", 1024)
+	// DownloadConcurrency sets the number of connections a pull downloads with. DownloadConcurrency can be configured via the OLLAMA_DOWNLOAD_CONCURRENCY environment variable.
+	DownloadConcurrency = Uint("OLLAMA_DOWNLOAD_CONCURRENCY", 0)
+	// UploadConcurrency sets the number of connections a push uploads with. UploadConcurrency can be configured via the OLLAMA_UPLOAD_CONCURRENCY environment variable.
+	UploadConcurrency = Uint("OLLAMA_UPLOAD_CONCURRENCY", 0)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
+	// HealthCheckFailures sets the number of health checks in a row a runner may fail before it's replaced. HealthCheckFailures can be configured via the OLLAMA_HEALTH_CHECK_FAILURES environment variable.
+	HealthCheckFailures = Uint("OLLAMA_HEALTH_CHECK_FAILURES", 3)
+	// MaxImages sets the maximum number of images in a request, or 0 for no limit. MaxImages can be configured via the OLLAMA_MAX_IMAGES environment variable.
+	MaxImages = Uint("OLLAMA_MAX_IMAGES", 0)
+	// MaxPromptTokens sets the maximum number of tokens in the prompt of a request, or 0 for no limit. MaxPromptTokens can be configured via the OLLAMA_MAX_PROMPT_TOKENS environment variable.
+	MaxPromptTokens = Uint("OLLAMA_MAX_PROMPT_TOKENS", 0)
+	// ContextLength sets the context length models are loaded with, overriding the num_ctx of their Modelfiles, or 0 to keep it. ContextLength can be configured via the OLLAMA_CONTEXT_LENGTH environment variable.
+	ContextLength = Uint("OLLAMA_CONTEXT_LENGTH", 0)
+)
+
+// Duration returns a function which parses key as a duration such as "5m" or
+// a number of seconds. Negative values are 0, which disables what they
+// configure.
+func Duration(key string, defaultValue time.Duration) func() time.Duration {
+	return func() time.Duration {
+		if s := Var(key); s != "" {
+			if d, err := time.ParseDuration(s); err == nil {
+				return max(d, 0)
+			} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return max(time.Duration(n)*time.Second, 0)
+			}
+
+			slog.Warn("invalid environment variable, using default", "key", key, "value", s, "default", defaultValue)
+		}
+
+		return defaultValue
+	}
+}
+
+var (
+	// LoadTimeout is how long loading a model may go without progress before it fails.
+	LoadTimeout = Duration("OLLAMA_LOAD_TIMEOUT", 5*time.Minute)
+	// HealthCheckInterval is how often loaded runners are checked to be responsive.
+	HealthCheckInterval = Duration("OLLAMA_HEALTH_CHECK_INTERVAL", 30*time.Second)
+	// HealthCheckTimeout is how long a runner has to respond to a health check.
+	HealthCheckTimeout = Duration("OLLAMA_HEALTH_CHECK_TIMEOUT", 30*time.Second)
+	// HistoryRetention is how long requests are kept in the usage history, which is disabled if it's 0.
+	HistoryRetention = Duration("OLLAMA_HISTORY_RETENTION", 30*24*time.Hour)
+	// SlowRequestThreshold is how long requests may take before they're logged as slow.
+	SlowRequestThreshold = Duration("OLLAMA_SLOW_REQUEST_THRESHOLD", 0)
+	// SlowFirstTokenThreshold is how long requests may take to the first token before they're logged as slow.
+	SlowFirstTokenThreshold = Duration("OLLAMA_SLOW_FIRST_TOKEN_THRESHOLD", 0)
+)
+
+// Memory returns a function which parses key as an amount of memory out of
+// total bytes, either a number of bytes with an optional KB, MB, GB, KiB,
+// MiB or GiB suffix, or a percentage of total like "75%". Invalid or unset
+// values are 0.
+func Memory(key string) func(total uint64) uint64 {
+	return func(total uint64) uint64 {
+		s := strings.TrimSpace(Var(key))
+		if s == "" {
+			return 0
+		}
+
+		if p, ok := strings.CutSuffix(s, "%"); ok {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil || f < 0 || f > 100 {
+				slog.Warn("invalid environment variable, ignoring", "key", key, "value", s)
+				return 0
+			}
+
+			return uint64(float64(total) * f / 100)
+		}
+
+		unit := uint64(1)
+		for _, u := range []struct {
+			suffix string
+			size   uint64
+		}{
+			{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+			{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		} {
+			if n, ok := strings.CutSuffix(s, u.suffix); ok {
+				s, unit = strings.TrimSpace(n), u.size
+				break
+			}
+		}
+
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f < 0 {
+			slog.Warn("invalid environment variable, ignoring", "key", key, "value", Var(key))
+			return 0
+		}
+
+		return uint64(f * float64(unit))
+	}
+}
+
+var (
+	// MetalMemoryLimit is the memory models may use on Apple Silicon,
+	// replacing Metal's recommended working set size.
+	MetalMemoryLimit = Memory("OLLAMA_METAL_MEMORY_LIMIT")
+	// MetalMemoryReserve is the memory always left to the system and other
+	// apps on Apple Silicon.
+	MetalMemoryReserve = Memory("OLLAMA_METAL_MEMORY_RESERVE")
+	// GPUMinMemory is the total memory GPUs need for models to be loaded on
+	// them. Percentages are of the memory of the largest GPU.
+	GPUMinMemory = Memory("OLLAMA_GPU_MIN_MEMORY")
+)
+
+// Bytes returns a function which parses key as a number of bytes with an
+// optional KB, MB, GB, KiB, MiB or GiB suffix. Invalid or unset values are 0.
+func Bytes(key string) func() uint64 {
+	memory := Memory(key)
+	return func() uint64 {
+		return memory(0)
+	}
+}
+
+var (
+	// MaxRequestSize is the largest request body the server accepts, other
+	// than blobs, or 0 for no limit.
+	MaxRequestSize = Bytes("OLLAMA_MAX_REQUEST_SIZE")
+	// MaxImageSize is the largest image the server accepts in a request, or
+	// 0 for no limit.
+	MaxImageSize = Bytes("OLLAMA_MAX_IMAGE_SIZE")
 )
 
 type EnvVar struct {
@@ -243,21 +630,65 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
-		"OLLAMA_RUNNERS_DIR":       {"OLLAMA_RUNNERS_DIR", RunnersDir(), "Location for runners"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_TMPDIR":            {"OLLAMA_TMPDIR", TmpDir(), "Location for temporary files"},
+		"OLLAMA_CPU_VARIANT":                {"OLLAMA_CPU_VARIANT", CPUVariant(), "Override the detected CPU vector extensions (none, avx, avx2, avx512)"},
+		"OLLAMA_CONTEXT_LENGTH":             {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length of models unless requests set num_ctx (default 0, the model's own)"},
+		"OLLAMA_DEBUG":                      {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_DEBUG_ADDR":                 {"OLLAMA_DEBUG_ADDR", DebugAddr(), "Address to serve pprof, expvar and goroutine stacks on (e.g. 127.0.0.1:6060)"},
+		"OLLAMA_DOWNLOAD_CONCURRENCY":       {"OLLAMA_DOWNLOAD_CONCURRENCY", DownloadConcurrency(), "Number of connections models are pulled with (default 16)"},
+		"OLLAMA_FLASH_ATTENTION":            {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_GC_INTERVAL":                {"OLLAMA_GC_INTERVAL", GCInterval(), "How often unused blobs are removed (default \"24h\", 0 disables)"},
+		"OLLAMA_GPU_MIN_MEMORY":             {"OLLAMA_GPU_MIN_MEMORY", Var("OLLAMA_GPU_MIN_MEMORY"), "Memory GPUs need to be used (e.g. 10GB or 50%)"},
+		"OLLAMA_GPU_PLACEMENT":              {"OLLAMA_GPU_PLACEMENT", GPUPlacement(), "Pin models to GPUs (e.g. all-minilm=1;llama3=0)"},
+		"OLLAMA_GPU_POLICY":                 {"OLLAMA_GPU_POLICY", GPUPolicy(), "How models are placed on GPUs (fastest, proportional)"},
+		"OLLAMA_HEALTH_CHECK_FAILURES":      {"OLLAMA_HEALTH_CHECK_FAILURES", HealthCheckFailures(), "Health checks in a row a runner may fail before it's replaced (default 3)"},
+		"OLLAMA_HEALTH_CHECK_INTERVAL":      {"OLLAMA_HEALTH_CHECK_INTERVAL", HealthCheckInterval(), "How often loaded models are checked to be responsive (default \"30s\", 0 disables)"},
+		"OLLAMA_HEALTH_CHECK_TIMEOUT":       {"OLLAMA_HEALTH_CHECK_TIMEOUT", HealthCheckTimeout(), "How long models have to respond to health checks (default \"30s\")"},
+		"OLLAMA_HISTORY_DIR":                {"OLLAMA_HISTORY_DIR", HistoryDir(), "The path to the usage history"},
+		"OLLAMA_HISTORY_RETENTION":          {"OLLAMA_HISTORY_RETENTION", HistoryRetention(), "How long requests are kept in the usage history (default \"720h\", 0 disables)"},
+		"OLLAMA_HOST":                       {"OLLAMA_HOST", Hosts(), "Comma separated IP addresses for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":                 {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_KV_CACHE_TYPE":              {"OLLAMA_KV_CACHE_TYPE", KVCacheType(), "Data type of the K/V cache (f16, q8_0, q4_0)"},
+		"OLLAMA_LLM_LIBRARY":                {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_LOAD_TIMEOUT":               {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long loading a model may stall before it fails (default \"5m\", 0 disables)"},
+		"OLLAMA_MAX_LOADED_MODELS":          {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_QUEUE":                  {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"OLLAMA_MAX_TOKEN_RATE":             {"OLLAMA_MAX_TOKEN_RATE", MaxTokenRates(), "Most tokens per second models may generate (e.g. summarizer=10;llama3=40)"},
+		"OLLAMA_MAX_REQUEST_SIZE":           {"OLLAMA_MAX_REQUEST_SIZE", MaxRequestSize(), "Maximum size of request bodies other than blobs (e.g. 10MB, default 0, unlimited)"},
+		"OLLAMA_MAX_IMAGES":                 {"OLLAMA_MAX_IMAGES", MaxImages(), "Maximum number of images in a request (default 0, unlimited)"},
+		"OLLAMA_MAX_IMAGE_SIZE":             {"OLLAMA_MAX_IMAGE_SIZE", MaxImageSize(), "Maximum size of images in requests (e.g. 5MB, default 0, unlimited)"},
+		"OLLAMA_MAX_PROMPT_TOKENS":          {"OLLAMA_MAX_PROMPT_TOKENS", MaxPromptTokens(), "Maximum number of tokens in the prompt of a request (default 0, unlimited)"},
+		"OLLAMA_MODELS":                     {"OLLAMA_MODELS", strings.Join(ModelsDirs(), ","), "The paths to the models directories"},
+		"OLLAMA_NOHISTORY":                  {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_JSON_ERRORS":                {"OLLAMA_JSON_ERRORS", JSONErrors(), "Print errors as JSON objects with their class and exit code"},
+		"OLLAMA_THEME":                      {"OLLAMA_THEME", Theme(), "Color theme of the CLI, default or high-contrast"},
+		"OLLAMA_LANG":                       {"OLLAMA_LANG", Lang(), "Language of the CLI's messages, such as es (default from the locale)"},
+		"OLLAMA_NOPRUNE":                    {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":               {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_ORIGINS":                    {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
+		"OLLAMA_REGISTRIES":                 {"OLLAMA_REGISTRIES", Registries(), "Path of the proxy and TLS settings of registries"},
+		"OLLAMA_REGISTRY_CACHE":             {"OLLAMA_REGISTRY_CACHE", RegistryCache(), "Serve pulled models to other servers as a registry cache"},
+		"OLLAMA_REGISTRY_MIRROR":            {"OLLAMA_REGISTRY_MIRROR", RegistryMirror(), "Pull models from ollama.com through a registry cache (e.g. http://cache:11434)"},
+		"OLLAMA_TRUST_POLICY":               {"OLLAMA_TRUST_POLICY", TrustPolicy(), "Path of a policy of the signatures pulled models must have"},
+		"OLLAMA_WARMUP_MODELS":              {"OLLAMA_WARMUP_MODELS", WarmupModels(), "Comma separated list of models warmed up with a hidden generation when loaded"},
+		"OLLAMA_WEBHOOKS":                   {"OLLAMA_WEBHOOKS", Webhooks(), "Comma separated list of URLs sent server events"},
+		"OLLAMA_SLOW_FIRST_TOKEN_THRESHOLD": {"OLLAMA_SLOW_FIRST_TOKEN_THRESHOLD", SlowFirstTokenThreshold(), "Log requests slower than this to their first token (default 0, disabled)"},
+		"OLLAMA_SLOW_REQUEST_THRESHOLD":     {"OLLAMA_SLOW_REQUEST_THRESHOLD", SlowRequestThreshold(), "Log requests slower than this (default 0, disabled)"},
+		"OLLAMA_STICKY_MODELS":              {"OLLAMA_STICKY_MODELS", StickyModels(), "Comma separated list of models kept loaded when another model needs memory"},
+		"OLLAMA_RESPONSE_CACHE_SIZE":        {"OLLAMA_RESPONSE_CACHE_SIZE", ResponseCacheSize(), "Maximum number of cached responses (default 1024)"},
+		"OLLAMA_RESPONSE_CACHE_TTL":         {"OLLAMA_RESPONSE_CACHE_TTL", ResponseCacheTTL(), "Duration identical requests are answered from the response cache (default 0, disabled)"},
+		"OLLAMA_RUNNER_LOG_DIR":             {"OLLAMA_RUNNER_LOG_DIR", RunnerLogDir(), "Directory runners log to, one rotated file per model, instead of the server log"},
+		"OLLAMA_RUNNER_LOG_LEVEL":           {"OLLAMA_RUNNER_LOG_LEVEL", RunnerLogLevels(), "Runner log levels, error, warn, info or debug, for every model or by model (e.g. warn;llama3=debug)"},
+		"OLLAMA_RUNNERS_DIR":                {"OLLAMA_RUNNERS_DIR", RunnersDir(), "Location for runners"},
+		"OLLAMA_SCHED_SPREAD":               {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_STORE":                      {"OLLAMA_STORE", Store(), "Object store shared by servers for models (s3://, gs://, az:// or file:// URL)"},
+		"OLLAMA_TMPDIR":                     {"OLLAMA_TMPDIR", TmpDir(), "Location for temporary files"},
+		"OLLAMA_UPLOAD_CONCURRENCY":         {"OLLAMA_UPLOAD_CONCURRENCY", UploadConcurrency(), "Number of connections models are pushed with (default 64)"},
+		"OLLAMA_VISIBLE_GPUS":               {"OLLAMA_VISIBLE_GPUS", VisibleGPUs(), "Comma separated list of GPU IDs or indexes Ollama may use"},
+		"OLLAMA_ZSTD_TRANSFERS":             {"OLLAMA_ZSTD_TRANSFERS", ZstdTransfers(), "Pull layers compressed with zstd from registries that support it"},
+	}
+	if runtime.GOOS == "darwin" {
+		ret["OLLAMA_METAL_MEMORY_LIMIT"] = EnvVar{"OLLAMA_METAL_MEMORY_LIMIT", Var("OLLAMA_METAL_MEMORY_LIMIT"), "Memory models may use on Apple Silicon (e.g. 96GB or 90%)"}
+		ret["OLLAMA_METAL_MEMORY_RESERVE"] = EnvVar{"OLLAMA_METAL_MEMORY_RESERVE", Var("OLLAMA_METAL_MEMORY_RESERVE"), "Memory left to the system on Apple Silicon (e.g. 4GB or 25%)"}
 	}
 	if runtime.GOOS != "darwin" {
 		ret["CUDA_VISIBLE_DEVICES"] = EnvVar{"CUDA_VISIBLE_DEVICES", CudaVisibleDevices(), "Set which NVIDIA devices are visible"}
@@ -266,6 +697,7 @@ func AsMap() map[string]EnvVar {
 		ret["GPU_DEVICE_ORDINAL"] = EnvVar{"GPU_DEVICE_ORDINAL", GpuDeviceOrdinal(), "Set which AMD devices are visible"}
 		ret["HSA_OVERRIDE_GFX_VERSION"] = EnvVar{"HSA_OVERRIDE_GFX_VERSION", HsaOverrideGfxVersion(), "Override the gfx used for all detected AMD GPUs"}
 		ret["OLLAMA_INTEL_GPU"] = EnvVar{"OLLAMA_INTEL_GPU", IntelGPU(), "Enable experimental Intel GPU detection"}
+		ret["ONEAPI_DEVICE_SELECTOR"] = EnvVar{"ONEAPI_DEVICE_SELECTOR", OneapiDeviceSelector(), "Set which Intel devices are visible"}
 	}
 	return ret
 }
@@ -46,6 +46,41 @@ func TestHost(t *testing.T) {
 	}
 }
 
+func TestHosts(t *testing.T) {
+	cases := map[string]struct {
+		value  string
+		expect []string
+	}{
+		"empty":          {"", []string{"http://127.0.0.1:11434"}},
+		"one":            {"1.2.3.4", []string{"http://1.2.3.4:11434"}},
+		"ipv4 and ipv6":  {"127.0.0.1:11434,[::1]:11434", []string{"http://127.0.0.1:11434", "http://[::1]:11434"}},
+		"extra space":    {" 127.0.0.1 , ::1 ", []string{"http://127.0.0.1:11434", "http://[::1]:11434"}},
+		"trailing comma": {"1.2.3.4,", []string{"http://1.2.3.4:11434"}},
+		"settings":       {"https://0.0.0.0:4321?tls_cert=a.pem&tls_key=b.pem", []string{"https://0.0.0.0:4321?tls_cert=a.pem&tls_key=b.pem"}},
+		"unix socket":    {"unix:///run/ollama.sock?mode=0660,127.0.0.1", []string{"unix:///run/ollama.sock?mode=0660", "http://127.0.0.1:11434"}},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("OLLAMA_HOST", tt.value)
+
+			var actual []string
+			for _, u := range Hosts() {
+				actual = append(actual, u.String())
+			}
+
+			if diff := cmp.Diff(actual, tt.expect); diff != "" {
+				t.Errorf("%s: mismatch (-actual +expect):\n%s", name, diff)
+			}
+		})
+	}
+
+	t.Setenv("OLLAMA_HOST", "https://0.0.0.0:4321?api_keys=keys,127.0.0.1")
+	if host := Host(); host.String() != "https://0.0.0.0:4321" {
+		t.Errorf("expected the first host without its settings, actual %s", host)
+	}
+}
+
 func TestOrigins(t *testing.T) {
 	cases := []struct {
 		value  string
@@ -181,6 +216,34 @@ func TestUint(t *testing.T) {
 	}
 }
 
+func TestMemory(t *testing.T) {
+	const total = 64 << 30
+	cases := map[string]uint64{
+		"":           0,
+		"1073741824": 1 << 30,
+		"48GiB":      48 << 30,
+		"1.5 GiB":    3 << 29,
+		"96GB":       96_000_000_000,
+		"512MB":      512_000_000,
+		"75%":        48 << 30,
+		"100%":       total,
+		// invalid values
+		"-1GB": 0,
+		"150%": 0,
+		"1TB":  0,
+		"???":  0,
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_MEMORY", k)
+			if actual := Memory("OLLAMA_MEMORY")(total); actual != v {
+				t.Errorf("%s: expected %d, got %d", k, v, actual)
+			}
+		})
+	}
+}
+
 func TestKeepAlive(t *testing.T) {
 	cases := map[string]time.Duration{
 		"":       5 * time.Minute,
@@ -214,6 +277,158 @@ func TestKeepAlive(t *testing.T) {
 	}
 }
 
+func TestDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    5 * time.Minute,
+		"1h":  time.Hour,
+		"90s": 90 * time.Second,
+		"60":  time.Minute,
+		"0":   0,
+		"-1":  0,
+		"???": 5 * time.Minute,
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_LOAD_TIMEOUT", tt)
+			if actual := LoadTimeout(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", tt, expect, actual)
+			}
+		})
+	}
+}
+
+func TestGCInterval(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    24 * time.Hour,
+		"1h":  time.Hour,
+		"30m": 30 * time.Minute,
+		"60":  time.Minute,
+		"0":   0,
+		"-1h": 0,
+		"???": 24 * time.Hour,
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_GC_INTERVAL", tt)
+			if actual := GCInterval(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", tt, expect, actual)
+			}
+		})
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	cases := map[string][]string{
+		"":                                     nil,
+		"http://a.local/hook":                  {"http://a.local/hook"},
+		"http://a.local/hook, https://b.local": {"http://a.local/hook", "https://b.local"},
+		",http://a.local/hook,,":               {"http://a.local/hook"},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_WEBHOOKS", k)
+			if diff := cmp.Diff(Webhooks(), v); diff != "" {
+				t.Errorf("%s: mismatch (-want +got):\n%s", k, diff)
+			}
+		})
+	}
+}
+
+func TestGPUPlacement(t *testing.T) {
+	cases := map[string]map[string]string{
+		"":                         {},
+		"llama3=0":                 {"llama3": "0"},
+		"all-minilm=1; llama3=0,1": {"all-minilm": "1", "llama3": "0,1"},
+		"llama3:70b=GPU-452cac9f;": {"llama3:70b": "GPU-452cac9f"},
+		// invalid entries are skipped
+		"llama3":          {},
+		"=0;all-minilm=1": {"all-minilm": "1"},
+		"llama3=":         {},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_GPU_PLACEMENT", k)
+			if diff := cmp.Diff(GPUPlacement(), v); diff != "" {
+				t.Errorf("%s: mismatch (-want +got):\n%s", k, diff)
+			}
+		})
+	}
+}
+
+func TestMaxTokenRates(t *testing.T) {
+	cases := map[string]map[string]float32{
+		"":                           {},
+		"summarizer=10":              {"summarizer": 10},
+		" summarizer=10; llama3=2.5": {"summarizer": 10, "llama3": 2.5},
+		// invalid entries are skipped
+		"10":                    {},
+		"=10;llama3=20":         {"llama3": 20},
+		"llama3=fast;phi3=-1":   {},
+		"llama3=0;summarizer=5": {"summarizer": 5},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_MAX_TOKEN_RATE", k)
+			if diff := cmp.Diff(MaxTokenRates(), v); diff != "" {
+				t.Errorf("%s: mismatch (-want +got):\n%s", k, diff)
+			}
+		})
+	}
+}
+
+func TestRunnerLogLevels(t *testing.T) {
+	cases := map[string]map[string]string{
+		"":                   {"": "info"},
+		"warn":               {"": "warn"},
+		"warn;llama3=debug":  {"": "warn", "llama3": "debug"},
+		" llama3:70b=Error ": {"": "info", "llama3:70b": "error"},
+		// invalid entries are skipped
+		"verbose":            {"": "info"},
+		"=debug;llama3=warn": {"": "info", "llama3": "warn"},
+		"llama3=":            {"": "info"},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_RUNNER_LOG_LEVEL", k)
+			if diff := cmp.Diff(RunnerLogLevels(), v); diff != "" {
+				t.Errorf("%s: mismatch (-want +got):\n%s", k, diff)
+			}
+		})
+	}
+
+	t.Run("debug", func(t *testing.T) {
+		t.Setenv("OLLAMA_DEBUG", "1")
+		t.Setenv("OLLAMA_RUNNER_LOG_LEVEL", "llama3=warn")
+		if diff := cmp.Diff(RunnerLogLevels(), map[string]string{"": "debug", "llama3": "warn"}); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestGPUPolicy(t *testing.T) {
+	cases := map[string]string{
+		"":              "",
+		"fastest":       GPUPolicyFastest,
+		" Proportional": GPUPolicyProportional,
+		"weakest":       "",
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_GPU_POLICY", k)
+			if policy := GPUPolicy(); policy != v {
+				t.Errorf("%s: expected %q, got %q", k, v, policy)
+			}
+		})
+	}
+}
+
 func TestVar(t *testing.T) {
 	cases := map[string]string{
 		"value":       "value",
@@ -233,3 +448,28 @@ func TestVar(t *testing.T) {
 		})
 	}
 }
+
+func TestModelsDirs(t *testing.T) {
+	cases := map[string]struct {
+		dirs  []string
+		first string
+	}{
+		"/models":                       {[]string{"/models"}, "/models"},
+		"/nvme?max=200GB, /hdd":         {[]string{"/nvme?max=200GB", "/hdd"}, "/nvme"},
+		"/nvme?models=llama3*,,/hdd":    {[]string{"/nvme?models=llama3*", "/hdd"}, "/nvme"},
+		"C:\\models?max=1TB,D:\\models": {[]string{"C:\\models?max=1TB", "D:\\models"}, "C:\\models"},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_MODELS", k)
+			if diff := cmp.Diff(ModelsDirs(), v.dirs); diff != "" {
+				t.Errorf("%s: mismatch (-want +got):\n%s", k, diff)
+			}
+
+			if path := Models(); path != v.first {
+				t.Errorf("%s: expected %s, got %s", k, v.first, path)
+			}
+		})
+	}
+}
@@ -443,6 +443,53 @@ func (gpus RocmGPUInfoList) RefreshFreeMemory() error {
 	return nil
 }
 
+// Stats returns the load of the GPU from the amdgpu driver's sysfs nodes,
+// which rocm-smi reads too
+func (gpu RocmGPUInfo) Stats() GpuStats {
+	if gpu.usedFilepath == "" {
+		return GpuStats{}
+	}
+
+	return amdgpuStats(filepath.Dir(gpu.usedFilepath))
+}
+
+func amdgpuStats(devDir string) GpuStats {
+	var stats GpuStats
+	if busy, err := readSysfsInt(filepath.Join(devDir, "gpu_busy_percent")); err == nil {
+		utilization := int(busy)
+		stats.Utilization = &utilization
+	}
+
+	hwmons, _ := filepath.Glob(filepath.Join(devDir, "hwmon", "hwmon*"))
+	for _, hwmon := range hwmons {
+		// millidegrees Celsius
+		if t, err := readSysfsInt(filepath.Join(hwmon, "temp1_input")); err == nil {
+			temperature := int(t / 1000)
+			stats.Temperature = &temperature
+		}
+
+		// microwatts, averaged by older GPUs and instantaneous on newer ones
+		for _, name := range []string{"power1_average", "power1_input"} {
+			if p, err := readSysfsInt(filepath.Join(hwmon, name)); err == nil {
+				power := float64(p) / 1e6
+				stats.Power = &power
+				break
+			}
+		}
+	}
+
+	return stats
+}
+
+func readSysfsInt(path string) (int64, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(buf)), 10, 64)
+}
+
 func getFreeMemory(usedFile string) (uint64, error) {
 	buf, err := os.ReadFile(usedFile)
 	if err != nil {
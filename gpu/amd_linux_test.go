@@ -0,0 +1,37 @@
+package gpu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAMDGPUStats(t *testing.T) {
+	dir := t.TempDir()
+	hwmon := filepath.Join(dir, "hwmon", "hwmon3")
+	require.NoError(t, os.MkdirAll(hwmon, 0o755))
+
+	for name, value := range map[string]string{
+		filepath.Join(dir, "gpu_busy_percent"): "42\n",
+		filepath.Join(hwmon, "temp1_input"):    "61000\n",
+		filepath.Join(hwmon, "power1_input"):   "215000000\n",
+	} {
+		require.NoError(t, os.WriteFile(name, []byte(value), 0o644))
+	}
+
+	stats := amdgpuStats(dir)
+	require.NotNil(t, stats.Utilization)
+	assert.Equal(t, 42, *stats.Utilization)
+	require.NotNil(t, stats.Temperature)
+	assert.Equal(t, 61, *stats.Temperature)
+	require.NotNil(t, stats.Power)
+	assert.InDelta(t, 215.0, *stats.Power, 0.001)
+
+	stats = amdgpuStats(t.TempDir())
+	assert.Nil(t, stats.Utilization)
+	assert.Nil(t, stats.Temperature)
+	assert.Nil(t, stats.Power)
+}
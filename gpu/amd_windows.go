@@ -190,3 +190,8 @@ func (gpus RocmGPUInfoList) RefreshFreeMemory() error {
 	}
 	return nil
 }
+
+// Stats returns no measurements as they aren't read from the driver on Windows
+func (gpu RocmGPUInfo) Stats() GpuStats {
+	return GpuStats{}
+}
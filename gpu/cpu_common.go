@@ -1,21 +1,57 @@
 package gpu
 
 import (
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	"golang.org/x/sys/cpu"
+
+	"github.com/ollama/ollama/envconfig"
 )
 
+var logCPUCapability sync.Once
+
+// GetCPUCapability returns the vector extensions of the CPU runner to use,
+// which are those detected on the CPU unless OLLAMA_CPU_VARIANT is set
 func GetCPUCapability() CPUCapability {
-	if cpu.X86.HasAVX2 {
-		return CPUCapabilityAVX2
+	detected := detectCPUCapability()
+
+	capability := detected
+	if s := envconfig.CPUVariant(); s != "" {
+		if c, err := ParseCPUCapability(s); err != nil {
+			slog.Warn("ignoring OLLAMA_CPU_VARIANT", "error", err)
+		} else {
+			capability = c
+		}
 	}
-	if cpu.X86.HasAVX {
+
+	logCPUCapability.Do(func() {
+		slog.Info("cpu vector extensions", "detected", detected, "using", capability)
+		if capability > detected {
+			slog.Warn("OLLAMA_CPU_VARIANT selects extensions the CPU may not have, runners may crash", "variant", capability)
+		}
+	})
+
+	return capability
+}
+
+func detectCPUCapability() CPUCapability {
+	// the AVX-512 runners target Skylake-X and newer, which have these subsets
+	avx512 := cpu.X86.HasAVX512F && cpu.X86.HasAVX512CD && cpu.X86.HasAVX512BW && cpu.X86.HasAVX512DQ && cpu.X86.HasAVX512VL
+
+	switch {
+	case avx512:
+		return CPUCapabilityAVX512
+	case cpu.X86.HasAVX2:
+		return CPUCapabilityAVX2
+	case cpu.X86.HasAVX:
 		return CPUCapabilityAVX
 	}
+
 	// else LCD
 	return CPUCapabilityNone
 }
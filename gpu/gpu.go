@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"unsafe"
@@ -54,6 +55,10 @@ var (
 	nvmlLibPath   string
 	rocmGPUs      []RocmGPUInfo
 	oneapiGPUs    []OneapiGPUInfo
+
+	// nvmlStats is kept loaded to read the load of NVIDIA GPUs
+	nvmlStats     *C.nvml_handle_t
+	nvmlStatsOnce sync.Once
 )
 
 // With our current CUDA compile flags, older than 5.0 will not work properly
@@ -330,13 +335,19 @@ func GetGPUInfo() GpuInfoList {
 						}
 						// TODO - split bootstrapping from updating free memory
 						C.oneapi_check_vram(*oHandles.oneapi, C.int(d), i, &memInfo)
-						// TODO - convert this to MinimumMemory based on testing...
-						var totalFreeMem float64 = float64(memInfo.free) * 0.95 // work-around: leave some reserve vram for mkl lib used in ggml-sycl backend.
-						memInfo.free = C.uint64_t(totalFreeMem)
-						gpuInfo.TotalMemory = uint64(memInfo.total)
-						gpuInfo.FreeMemory = uint64(memInfo.free)
+						if memInfo.err != nil {
+							slog.Info("error looking up oneAPI GPU memory", "error", C.GoString(memInfo.err))
+							C.free(unsafe.Pointer(memInfo.err))
+							continue
+						}
+						// TODO - convert the reserve to MinimumMemory based on testing...
+						gpuInfo.TotalMemory, gpuInfo.FreeMemory = oneapiMemory(uint64(memInfo.total), uint64(memInfo.free), cpus[0].memInfo)
 						gpuInfo.ID = C.GoString(&memInfo.gpu_id[0])
 						gpuInfo.Name = C.GoString(&memInfo.gpu_name[0])
+						if !oneapiVisible(envconfig.OneapiDeviceSelector(), gpuInfo.ID) {
+							slog.Info("filtering out device per user request", "id", gpuInfo.ID, "selector", envconfig.OneapiDeviceSelector())
+							continue
+						}
 						gpuInfo.DependencyPath = depPath
 						oneapiGPUs = append(oneapiGPUs, gpuInfo)
 					}
@@ -436,10 +447,12 @@ func GetGPUInfo() GpuInfoList {
 				continue
 			}
 			C.oneapi_check_vram(*oHandles.oneapi, C.int(gpu.driverIndex), C.int(gpu.gpuIndex), &memInfo)
-			// TODO - convert this to MinimumMemory based on testing...
-			var totalFreeMem float64 = float64(memInfo.free) * 0.95 // work-around: leave some reserve vram for mkl lib used in ggml-sycl backend.
-			memInfo.free = C.uint64_t(totalFreeMem)
-			oneapiGPUs[i].FreeMemory = uint64(memInfo.free)
+			if memInfo.err != nil {
+				slog.Warn("error looking up oneAPI GPU memory", "error", C.GoString(memInfo.err))
+				C.free(unsafe.Pointer(memInfo.err))
+				continue
+			}
+			_, oneapiGPUs[i].FreeMemory = oneapiMemory(uint64(memInfo.total), uint64(memInfo.free), cpus[0].memInfo)
 		}
 
 		err = RocmGPUInfoList(rocmGPUs).RefreshFreeMemory()
@@ -458,6 +471,9 @@ func GetGPUInfo() GpuInfoList {
 	for _, gpu := range oneapiGPUs {
 		resp = append(resp, gpu.GpuInfo)
 	}
+
+	// log the GPUs which aren't visible once, when they're discovered
+	resp = visibleGPUs(resp, envconfig.VisibleGPUs(), !needRefresh)
 	if len(resp) == 0 {
 		resp = append(resp, cpus[0].GpuInfo)
 	}
@@ -614,6 +630,63 @@ func LoadOneapiMgmt(oneapiLibPaths []string) (int, *C.oneapi_handle_t, string) {
 	return 0, nil, ""
 }
 
+// GetGPUStats returns the load of each of gpus, as returned by GetGPUInfo,
+// from NVML for NVIDIA GPUs and the amdgpu driver for Radeon GPUs on Linux
+func GetGPUStats(gpus GpuInfoList) []GpuStats {
+	gpuMutex.Lock()
+	defer gpuMutex.Unlock()
+
+	stats := make([]GpuStats, len(gpus))
+	for i, g := range gpus {
+		switch g.Library {
+		case "cuda":
+			if j := slices.IndexFunc(cudaGPUs, func(c CudaGPUInfo) bool { return c.ID == g.ID }); j >= 0 {
+				stats[i] = nvmlGPUStats(cudaGPUs[j])
+			}
+		case "rocm":
+			if j := slices.IndexFunc(rocmGPUs, func(r RocmGPUInfo) bool { return r.ID == g.ID }); j >= 0 {
+				stats[i] = rocmGPUs[j].Stats()
+			}
+		}
+	}
+
+	return stats
+}
+
+// Note: gpuMutex must already be held
+func nvmlGPUStats(gpu CudaGPUInfo) GpuStats {
+	nvmlStatsOnce.Do(func() {
+		if libPaths := FindGPULibs(NvmlStatsName, NvmlStatsGlobs); len(libPaths) > 0 {
+			nvmlStats, _ = LoadNVMLMgmt(libPaths)
+		}
+	})
+
+	var stats GpuStats
+	if nvmlStats == nil {
+		return stats
+	}
+
+	uuid := C.CString(gpu.ID)
+	defer C.free(unsafe.Pointer(uuid))
+
+	var s C.nvml_stats_t
+	C.nvml_get_stats(*nvmlStats, uuid, C.int(gpu.index), &s)
+	if s.utilization >= 0 {
+		utilization := int(s.utilization)
+		stats.Utilization = &utilization
+	}
+	if s.temperature >= 0 {
+		temperature := int(s.temperature)
+		stats.Temperature = &temperature
+	}
+	if s.power >= 0 {
+		power := float64(s.power) / 1000
+		stats.Power = &power
+	}
+
+	return stats
+}
+
 func getVerboseState() C.uint16_t {
 	if envconfig.Debug() {
 		return C.uint16_t(1)
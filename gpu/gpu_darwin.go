@@ -4,14 +4,17 @@ package gpu
 
 /*
 #cgo CFLAGS: -x objective-c
-#cgo LDFLAGS: -framework Foundation -framework CoreGraphics -framework Metal
+#cgo LDFLAGS: -framework Foundation -framework CoreGraphics -framework Metal -framework IOKit
 #include "gpu_info_darwin.h"
 */
 import "C"
 
 import (
+	"log/slog"
 	"runtime"
+	"sync"
 
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 )
 
@@ -34,7 +37,7 @@ func GetGPUInfo() GpuInfoList {
 		Library: "metal",
 		ID:      "0",
 	}
-	info.TotalMemory = uint64(C.getRecommendedMaxVRAM())
+	info.TotalMemory = metalMemoryLimit(uint64(C.getRecommendedMaxVRAM()), mem.TotalMemory)
 
 	// TODO is there a way to gather actual allocated video memory? (currentAllocatedSize doesn't work)
 	info.FreeMemory = info.TotalMemory
@@ -43,6 +46,47 @@ func GetGPUInfo() GpuInfoList {
 	return []GpuInfo{info}
 }
 
+var warnMetalLimit sync.Once
+
+// metalMemoryLimit is the unified memory models may use: Metal's recommended
+// working set size unless OLLAMA_METAL_MEMORY_LIMIT replaces it, and no more
+// than leaves OLLAMA_METAL_MEMORY_RESERVE of physical memory to the system.
+func metalMemoryLimit(recommended, physical uint64) uint64 {
+	limit := recommended
+	if l := envconfig.MetalMemoryLimit(physical); l > 0 {
+		limit = min(l, physical)
+		if limit > recommended {
+			warnMetalLimit.Do(func() {
+				slog.Warn("metal memory limit is above the recommended working set, raise iogpu.wired_limit_mb to match if models fail to load",
+					"limit", format.HumanBytes2(limit), "recommended", format.HumanBytes2(recommended))
+			})
+		}
+	}
+
+	if reserve := envconfig.MetalMemoryReserve(physical); reserve > 0 {
+		limit = min(limit, physical-min(reserve, physical))
+	}
+
+	return limit
+}
+
+// GetGPUStats returns the load of each of gpus, as returned by GetGPUInfo.
+// Only the utilization of Apple Silicon GPUs is reported.
+func GetGPUStats(gpus GpuInfoList) []GpuStats {
+	stats := make([]GpuStats, len(gpus))
+	for i, g := range gpus {
+		if g.Library != "metal" {
+			continue
+		}
+
+		if u := int(C.getGPUUtilization()); u >= 0 {
+			stats[i].Utilization = &u
+		}
+	}
+
+	return stats
+}
+
 func GetCPUInfo() GpuInfoList {
 	mem, _ := GetCPUMem()
 	return []GpuInfo{
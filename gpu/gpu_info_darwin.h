@@ -3,3 +3,4 @@
 uint64_t getRecommendedMaxVRAM();
 uint64_t getPhysicalMemory();
 uint64_t getFreeMemory();
+int getGPUUtilization();
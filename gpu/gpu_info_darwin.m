@@ -1,4 +1,5 @@
 #import <Foundation/Foundation.h>
+#import <IOKit/IOKitLib.h>
 #import <mach/mach.h>
 #include "gpu_info_darwin.h"
 
@@ -33,3 +34,33 @@ uint64_t getFreeMemory() {
 
   return free_memory;
 }
+
+// getGPUUtilization returns the percent of the time the GPU was busy from
+// the performance statistics of its IOAccelerator, or -1 if it isn't reported
+int getGPUUtilization() {
+  io_iterator_t iterator;
+  if (IOServiceGetMatchingServices(MACH_PORT_NULL, IOServiceMatching("IOAccelerator"), &iterator) != KERN_SUCCESS) {
+    return -1;
+  }
+
+  int utilization = -1;
+  io_registry_entry_t entry;
+  while ((entry = IOIteratorNext(iterator))) {
+    CFMutableDictionaryRef properties = NULL;
+    if (IORegistryEntryCreateCFProperties(entry, &properties, kCFAllocatorDefault, 0) == KERN_SUCCESS) {
+      CFDictionaryRef stats = CFDictionaryGetValue(properties, CFSTR("PerformanceStatistics"));
+      if (stats && CFGetTypeID(stats) == CFDictionaryGetTypeID()) {
+        CFNumberRef value = CFDictionaryGetValue(stats, CFSTR("Device Utilization %"));
+        int v;
+        if (value && CFNumberGetValue(value, kCFNumberIntType, &v) && v > utilization) {
+          utilization = v;
+        }
+      }
+      CFRelease(properties);
+    }
+    IOObjectRelease(entry);
+  }
+
+  IOObjectRelease(iterator);
+  return utilization;
+}
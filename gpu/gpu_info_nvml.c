@@ -19,6 +19,10 @@ void nvml_init(char *nvml_lib_path, nvml_init_resp_t *resp) {
       {"nvmlShutdown", (void *)&resp->ch.nvmlShutdown},
       {"nvmlDeviceGetHandleByIndex", (void *)&resp->ch.nvmlDeviceGetHandleByIndex},
       {"nvmlDeviceGetMemoryInfo", (void *)&resp->ch.nvmlDeviceGetMemoryInfo},
+      {"nvmlDeviceGetHandleByUUID", (void *)&resp->ch.nvmlDeviceGetHandleByUUID},
+      {"nvmlDeviceGetUtilizationRates", (void *)&resp->ch.nvmlDeviceGetUtilizationRates},
+      {"nvmlDeviceGetTemperature", (void *)&resp->ch.nvmlDeviceGetTemperature},
+      {"nvmlDeviceGetPowerUsage", (void *)&resp->ch.nvmlDeviceGetPowerUsage},
       {NULL, NULL},
   };
 
@@ -89,6 +93,37 @@ void nvml_get_free(nvml_handle_t h, int device_id, uint64_t *free, uint64_t *tot
     *used = memInfo.used;
 }
 
+// nvml_get_stats looks the device up by its UUID, as NVML may number devices
+// differently than CUDA, falling back to device_id
+void nvml_get_stats(nvml_handle_t h, const char *uuid, int device_id, nvml_stats_t *stats) {
+    nvmlDevice_t device;
+    nvmlUtilization_t utilization = {0};
+    unsigned int value;
+    nvmlReturn_t ret;
+
+    stats->utilization = -1;
+    stats->temperature = -1;
+    stats->power = -1;
+
+    ret = (*h.nvmlDeviceGetHandleByUUID)(uuid, &device);
+    if (ret != NVML_SUCCESS) {
+        ret = (*h.nvmlDeviceGetHandleByIndex)(device_id, &device);
+    }
+    if (ret != NVML_SUCCESS) {
+        LOG(h.verbose, "unable to get device handle %d: %d", device_id, ret);
+        return;
+    }
+
+    if ((*h.nvmlDeviceGetUtilizationRates)(device, &utilization) == NVML_SUCCESS) {
+        stats->utilization = utilization.gpu;
+    }
+    if ((*h.nvmlDeviceGetTemperature)(device, NVML_TEMPERATURE_GPU, &value) == NVML_SUCCESS) {
+        stats->temperature = value;
+    }
+    if ((*h.nvmlDeviceGetPowerUsage)(device, &value) == NVML_SUCCESS) {
+        stats->power = value;
+    }
+}
 
 void nvml_release(nvml_handle_t h) {
   LOG(h.verbose, "releasing nvml library\n");
@@ -20,6 +20,15 @@ typedef enum nvmlBrandType_enum
     NVML_BRAND_UNKNOWN          = 0,
 } nvmlBrandType_t;
 
+typedef struct nvmlUtilization_st {
+  unsigned int gpu;
+  unsigned int memory;
+} nvmlUtilization_t;
+
+typedef enum nvmlTemperatureSensors_enum {
+  NVML_TEMPERATURE_GPU = 0,
+} nvmlTemperatureSensors_t;
+
 typedef struct nvml_handle {
   void *handle;
   uint16_t verbose;
@@ -27,6 +36,10 @@ typedef struct nvml_handle {
   nvmlReturn_t (*nvmlShutdown)(void);
   nvmlReturn_t (*nvmlDeviceGetHandleByIndex)(unsigned int, nvmlDevice_t *);
   nvmlReturn_t (*nvmlDeviceGetMemoryInfo)(nvmlDevice_t, nvmlMemory_t *);
+  nvmlReturn_t (*nvmlDeviceGetHandleByUUID)(const char *, nvmlDevice_t *);
+  nvmlReturn_t (*nvmlDeviceGetUtilizationRates)(nvmlDevice_t, nvmlUtilization_t *);
+  nvmlReturn_t (*nvmlDeviceGetTemperature)(nvmlDevice_t, nvmlTemperatureSensors_t, unsigned int *);
+  nvmlReturn_t (*nvmlDeviceGetPowerUsage)(nvmlDevice_t, unsigned int *);
 } nvml_handle_t;
 
 typedef struct nvml_init_resp {
@@ -34,6 +47,13 @@ typedef struct nvml_init_resp {
   nvml_handle_t ch;
 } nvml_init_resp_t;
 
+// Each is -1 if the device doesn't report it
+typedef struct nvml_stats {
+  int utilization;  // percent
+  int temperature;  // degrees Celsius
+  int power;        // milliwatts
+} nvml_stats_t;
+
 typedef struct nvml_compute_capability {
   char *err;
   int major;
@@ -42,6 +62,7 @@ typedef struct nvml_compute_capability {
 
 void nvml_init(char *nvml_lib_path, nvml_init_resp_t *resp);
 void nvml_get_free(nvml_handle_t ch,  int device_id, uint64_t *free, uint64_t *total, uint64_t *used);
+void nvml_get_stats(nvml_handle_t ch, const char *uuid, int device_id, nvml_stats_t *stats);
 void nvml_release(nvml_handle_t ch);
 
 #endif  // __GPU_INFO_NVML_H__
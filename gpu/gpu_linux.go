@@ -26,6 +26,17 @@ var CudartGlobs = []string{
 
 var NvmlGlobs = []string{}
 
+// NvmlStatsGlobs finds the NVIDIA management library, which is only used to
+// read the load of GPUs on linux
+var NvmlStatsGlobs = []string{
+	"/usr/lib/*-linux-gnu/nvidia/current/libnvidia-ml.so*",
+	"/usr/lib/*-linux-gnu/libnvidia-ml.so*",
+	"/usr/lib/wsl/lib/libnvidia-ml.so*",
+	"/usr/lib/wsl/drivers/*/libnvidia-ml.so*",
+	"/usr/lib*/libnvidia-ml.so*",
+	"/usr/local/lib*/libnvidia-ml.so*",
+}
+
 var NvcudaGlobs = []string{
 	"/usr/local/cuda*/targets/*/lib/libcuda.so*",
 	"/usr/lib/*-linux-gnu/nvidia/current/libcuda.so*",
@@ -47,6 +58,7 @@ var (
 	CudartMgmtName = "libcudart.so*"
 	NvcudaMgmtName = "libcuda.so*"
 	NvmlMgmtName   = "" // not currently wired on linux
+	NvmlStatsName  = "libnvidia-ml.so*"
 	OneapiMgmtName = "libze_intel_gpu.so"
 )
 
@@ -4,6 +4,7 @@ package gpu
 
 import (
 	"log/slog"
+	"slices"
 	"strings"
 )
 
@@ -19,3 +20,28 @@ func oneapiGetVisibleDevicesEnv(gpuInfo []GpuInfo) (string, string) {
 	}
 	return "ONEAPI_DEVICE_SELECTOR", "level_zero:" + strings.Join(ids, ",")
 }
+
+// oneapiVisible reports whether the Intel GPU id is selected by selector, an
+// ONEAPI_DEVICE_SELECTOR of Level-Zero devices like "level_zero:0,1". Any
+// other selector, including none, selects every device.
+func oneapiVisible(selector, id string) bool {
+	devices, ok := strings.CutPrefix(selector, "level_zero:")
+	if !ok || devices == "*" {
+		return true
+	}
+
+	return slices.Contains(strings.Split(devices, ","), id)
+}
+
+// oneapiMemory returns the total and free memory of an Intel GPU which
+// Level-Zero reports total and free bytes of memory modules for. Integrated
+// Xe GPUs have no memory modules of their own and share system memory, so
+// they get the system's memory instead. 5% of free memory is left for the
+// MKL library used by ggml's SYCL backend.
+func oneapiMemory(total, free uint64, system memInfo) (uint64, uint64) {
+	if total == 0 {
+		total, free = system.TotalMemory, system.FreeMemory
+	}
+
+	return total, free - free/20
+}
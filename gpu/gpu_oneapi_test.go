@@ -0,0 +1,42 @@
+//go:build linux || windows
+
+package gpu
+
+import (
+	"testing"
+)
+
+func TestOneapiMemory(t *testing.T) {
+	system := memInfo{TotalMemory: 32 << 30, FreeMemory: 20 << 30}
+
+	total, free := oneapiMemory(16<<30, 10<<30, system)
+	if total != 16<<30 || free != 10<<30-10<<30/20 {
+		t.Errorf("discrete: unexpected total %d, free %d", total, free)
+	}
+
+	// integrated GPUs have no memory modules of their own
+	total, free = oneapiMemory(0, 0, system)
+	if total != 32<<30 || free != 20<<30-20<<30/20 {
+		t.Errorf("integrated: unexpected total %d, free %d", total, free)
+	}
+}
+
+func TestOneapiVisible(t *testing.T) {
+	cases := []struct {
+		selector string
+		id       string
+		expect   bool
+	}{
+		{"", "0", true},
+		{"level_zero:*", "1", true},
+		{"level_zero:0,2", "2", true},
+		{"level_zero:0,2", "1", false},
+		{"opencl:0", "1", true},
+	}
+
+	for _, tt := range cases {
+		if actual := oneapiVisible(tt.selector, tt.id); actual != tt.expect {
+			t.Errorf("%q %s: expected %t, actual %t", tt.selector, tt.id, tt.expect, actual)
+		}
+	}
+}
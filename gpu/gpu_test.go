@@ -2,6 +2,8 @@ package gpu
 
 import (
 	"runtime"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,7 +13,7 @@ import (
 func TestBasicGetGPUInfo(t *testing.T) {
 	info := GetGPUInfo()
 	assert.NotEmpty(t, len(info))
-	assert.Contains(t, "cuda rocm cpu metal", info[0].Library)
+	assert.Contains(t, "cuda rocm oneapi cpu metal", info[0].Library)
 	if info[0].Library != "cpu" {
 		assert.Greater(t, info[0].TotalMemory, uint64(0))
 		assert.Greater(t, info[0].FreeMemory, uint64(0))
@@ -32,4 +34,100 @@ func TestCPUMemInfo(t *testing.T) {
 	}
 }
 
+func TestSelect(t *testing.T) {
+	gpus := GpuInfoList{
+		{Library: "cuda", ID: "GPU-a"},
+		{Library: "cuda", ID: "GPU-b"},
+		{Library: "rocm", ID: "0"},
+		{Library: "rocm", ID: "1"},
+	}
+
+	cases := map[string][]string{
+		"":            nil,
+		"0":           {"GPU-a", "0"},
+		"1":           {"GPU-b", "1"},
+		"GPU-b":       {"GPU-b"},
+		"GPU-a, 1":    {"GPU-a", "GPU-b", "1"},
+		"2,GPU-other": nil,
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			var ids []string
+			for _, g := range gpus.Select(k) {
+				ids = append(ids, g.ID)
+			}
+			assert.Equal(t, v, ids)
+		})
+	}
+}
+
+func TestVisibleGPUs(t *testing.T) {
+	gpus := GpuInfoList{
+		{Library: "cuda", ID: "GPU-a"},
+		{Library: "cuda", ID: "GPU-b"},
+		{Library: "rocm", ID: "0"},
+	}
+
+	assert.Equal(t, gpus, visibleGPUs(gpus, "", true))
+	assert.Equal(t, gpus, visibleGPUs(gpus, " ", true))
+	assert.Equal(t, GpuInfoList{gpus[1]}, visibleGPUs(gpus, "GPU-b", true))
+	assert.Equal(t, GpuInfoList{gpus[0], gpus[2]}, visibleGPUs(gpus, "0", true))
+	assert.Empty(t, visibleGPUs(gpus, "GPU-other", true))
+}
+
+func TestByPerformance(t *testing.T) {
+	for _, tt := range []struct {
+		computes []string
+		expect   []string
+	}{
+		{[]string{"6.1", "8.6", "8.9", "7.5", "8.6+"}, []string{"8.9", "8.6+", "8.6", "7.5", "6.1"}},
+		{[]string{"gfx906", "gfx1100", "gfx90a", "gfx1030"}, []string{"gfx1100", "gfx1030", "gfx90a", "gfx906"}},
+	} {
+		var gpus GpuInfoList
+		for _, c := range tt.computes {
+			// a + marks the larger of GPUs with the same compute capability
+			g := GpuInfo{ID: c, Compute: strings.TrimSuffix(c, "+")}
+			if strings.HasSuffix(c, "+") {
+				g.TotalMemory = 1
+			}
+			gpus = append(gpus, g)
+		}
+
+		sort.Sort(sort.Reverse(ByPerformance(gpus)))
+		var ids []string
+		for _, g := range gpus {
+			ids = append(ids, g.ID)
+		}
+		assert.Equal(t, tt.expect, ids)
+	}
+}
+
+func TestParseCPUCapability(t *testing.T) {
+	for _, c := range []CPUCapability{CPUCapabilityAVX, CPUCapabilityAVX2, CPUCapabilityAVX512} {
+		parsed, err := ParseCPUCapability(c.String())
+		require.NoError(t, err)
+		assert.Equal(t, c, parsed)
+	}
+
+	c, err := ParseCPUCapability(" AVX512 ")
+	require.NoError(t, err)
+	assert.Equal(t, CPUCapabilityAVX512, c)
+
+	c, err = ParseCPUCapability("none")
+	require.NoError(t, err)
+	assert.Equal(t, CPUCapabilityNone, c)
+
+	_, err = ParseCPUCapability("sse4")
+	assert.Error(t, err)
+}
+
+func TestGetCPUCapabilityOverride(t *testing.T) {
+	t.Setenv("OLLAMA_CPU_VARIANT", "avx")
+	assert.Equal(t, CPUCapabilityAVX, GetCPUCapability())
+
+	t.Setenv("OLLAMA_CPU_VARIANT", "bogus")
+	assert.Equal(t, detectCPUCapability(), GetCPUCapability())
+}
+
 // TODO - add some logic to figure out card type through other means and actually verify we got back what we expected
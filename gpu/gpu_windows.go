@@ -32,6 +32,8 @@ var NvmlGlobs = []string{
 	"c:\\Windows\\System32\\nvml.dll",
 }
 
+var NvmlStatsGlobs = NvmlGlobs
+
 var NvcudaGlobs = []string{
 	"c:\\windows\\system*\\nvcuda.dll",
 }
@@ -44,6 +46,7 @@ var (
 	CudartMgmtName = "cudart64_*.dll"
 	NvcudaMgmtName = "nvcuda.dll"
 	NvmlMgmtName   = "nvml.dll"
+	NvmlStatsName  = "nvml.dll"
 	OneapiMgmtName = "ze_intel_gpu64.dll"
 )
 
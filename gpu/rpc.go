@@ -0,0 +1,135 @@
+package gpu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rpcGetDeviceMemory is the GET_DEVICE_MEMORY command of llama.cpp's RPC
+// protocol
+const rpcGetDeviceMemory = 10
+
+// rpcTimeout bounds querying an RPC server. The server handles one client at
+// a time, so one serving another runner doesn't answer.
+const rpcTimeout = 5 * time.Second
+
+// rpcCacheTTL is how long the memory of an RPC server, or the error querying
+// it, is reused so that scheduling a model doesn't wait on the servers for
+// each request.
+const rpcCacheTTL = 10 * time.Second
+
+type rpcMemory struct {
+	free, total uint64
+	err         error
+	queried     time.Time
+}
+
+var (
+	rpcCacheMu sync.Mutex
+	rpcCache   = make(map[string]rpcMemory)
+)
+
+// RPCGetGPUInfo returns the memory of the llama.cpp RPC servers at the comma
+// separated host:port addresses in servers, which runners offload layers to
+// like local GPUs.
+// The servers are queried concurrently and their answers cached for
+// rpcCacheTTL.
+func RPCGetGPUInfo(servers string) (GpuInfoList, error) {
+	var addrs []string
+	for _, addr := range strings.Split(servers, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	mems := make([]rpcMemory, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mems[i] = cachedDeviceMemory(addr)
+		}()
+	}
+	wg.Wait()
+
+	var resp GpuInfoList
+	for i, addr := range addrs {
+		if mems[i].err != nil {
+			return nil, fmt.Errorf("rpc server %s: %w", addr, mems[i].err)
+		}
+
+		info := GpuInfo{
+			Library: "rpc",
+			ID:      addr,
+			Name:    addr,
+		}
+		info.TotalMemory = mems[i].total
+		info.FreeMemory = mems[i].free
+		resp = append(resp, info)
+	}
+
+	return resp, nil
+}
+
+// cachedDeviceMemory returns the memory of the RPC server at addr, querying
+// it if it wasn't within rpcCacheTTL
+func cachedDeviceMemory(addr string) rpcMemory {
+	rpcCacheMu.Lock()
+	mem, ok := rpcCache[addr]
+	rpcCacheMu.Unlock()
+	if ok && time.Since(mem.queried) < rpcCacheTTL {
+		return mem
+	}
+
+	mem.free, mem.total, mem.err = rpcDeviceMemory(addr)
+	mem.queried = time.Now()
+
+	rpcCacheMu.Lock()
+	rpcCache[addr] = mem
+	rpcCacheMu.Unlock()
+	return mem
+}
+
+func rpcDeviceMemory(addr string) (free, total uint64, err error) {
+	conn, err := net.DialTimeout("tcp", addr, rpcTimeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(rpcTimeout)); err != nil {
+		return 0, 0, err
+	}
+
+	// a command is its id and the size of its input, which is empty here
+	var req [9]byte
+	req[0] = rpcGetDeviceMemory
+	if _, err := conn.Write(req[:]); err != nil {
+		return 0, 0, err
+	}
+
+	var size uint64
+	if err := binary.Read(conn, binary.LittleEndian, &size); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, 0, errors.New("connection closed, is it a llama.cpp rpc-server?")
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	if size != 16 {
+		return 0, 0, fmt.Errorf("unexpected response of %d bytes, is it a llama.cpp rpc-server?", size)
+	}
+
+	var mem [2]uint64
+	if err := binary.Read(conn, binary.LittleEndian, &mem); err != nil {
+		return 0, 0, err
+	}
+
+	return mem[0], mem[1], nil
+}
@@ -0,0 +1,85 @@
+package gpu
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rpcServer answers GET_DEVICE_MEMORY like llama.cpp's rpc-server, counting
+// the connections in conns if it isn't nil
+func rpcServer(t *testing.T, free, total uint64, conns *atomic.Int32) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			if conns != nil {
+				conns.Add(1)
+			}
+
+			var req [9]byte
+			if _, err := io.ReadFull(conn, req[:]); err == nil && req[0] == rpcGetDeviceMemory {
+				binary.Write(conn, binary.LittleEndian, []uint64{16, free, total}) //nolint:errcheck
+			}
+
+			conn.Close()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestRPCGetGPUInfo(t *testing.T) {
+	a := rpcServer(t, 20<<30, 24<<30, nil)
+	b := rpcServer(t, 6<<30, 8<<30, nil)
+
+	gpus, err := RPCGetGPUInfo(a + ", " + b)
+	require.NoError(t, err)
+	require.Len(t, gpus, 2)
+
+	assert.Equal(t, "rpc", gpus[0].Library)
+	assert.Equal(t, a, gpus[0].ID)
+	assert.Equal(t, uint64(20<<30), gpus[0].FreeMemory)
+	assert.Equal(t, uint64(24<<30), gpus[0].TotalMemory)
+	assert.Equal(t, b, gpus[1].ID)
+	assert.Equal(t, uint64(6<<30), gpus[1].FreeMemory)
+
+	t.Run("unreachable", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close()
+
+		_, err = RPCGetGPUInfo(a + "," + addr)
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "rpc server "+addr), err.Error())
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		var conns atomic.Int32
+		c := rpcServer(t, 1<<30, 2<<30, &conns)
+
+		for range 3 {
+			gpus, err := RPCGetGPUInfo(c)
+			require.NoError(t, err)
+			require.Len(t, gpus, 1)
+			assert.Equal(t, uint64(1<<30), gpus[0].FreeMemory)
+		}
+		assert.Equal(t, int32(1), conns.Load())
+	})
+}
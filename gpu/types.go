@@ -3,6 +3,9 @@ package gpu
 import (
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/ollama/ollama/format"
 )
@@ -51,6 +54,14 @@ type CPUInfo struct {
 	GpuInfo
 }
 
+// GpuStats is the load of a GPU. Each measurement is nil when the GPU or its
+// driver doesn't report it.
+type GpuStats struct {
+	Utilization *int     // percent of the time the GPU was busy
+	Temperature *int     // degrees Celsius
+	Power       *float64 // watts
+}
+
 type CudaGPUInfo struct {
 	GpuInfo
 	OSOverhead uint64 // Memory overhead between the driver library and management library
@@ -99,6 +110,53 @@ func (l GpuInfoList) ByLibrary() []GpuInfoList {
 	return resp
 }
 
+// Select returns the GPUs matching any of the comma separated selectors in s.
+// A selector matches either the ID of a GPU or its index amongst the detected
+// GPUs of the same library, e.g. "1" or "GPU-452cac9f".
+func (l GpuInfoList) Select(s string) GpuInfoList {
+	var selectors []string
+	for _, selector := range strings.Split(s, ",") {
+		if selector = strings.TrimSpace(selector); selector != "" {
+			selectors = append(selectors, selector)
+		}
+	}
+
+	var resp GpuInfoList
+	indexes := make(map[string]int)
+	for _, info := range l {
+		index := indexes[info.Library]
+		indexes[info.Library]++
+		if slices.Contains(selectors, info.ID) || slices.Contains(selectors, strconv.Itoa(index)) {
+			resp = append(resp, info)
+		}
+	}
+	return resp
+}
+
+// visibleGPUs returns the GPUs in l selected by the comma separated selectors
+// of OLLAMA_VISIBLE_GPUS, like [GpuInfoList.Select], or all of them if there
+// are none. GPUs which aren't visible are logged when logFiltered is set.
+func visibleGPUs(l GpuInfoList, selectors string, logFiltered bool) GpuInfoList {
+	if strings.TrimSpace(selectors) == "" {
+		return l
+	}
+
+	visible := l.Select(selectors)
+	if logFiltered {
+		for _, info := range l {
+			if !slices.ContainsFunc(visible, func(v GpuInfo) bool { return v.Library == info.Library && v.ID == info.ID }) {
+				slog.Info("filtering out device per user request", "id", info.ID, "library", info.Library, "OLLAMA_VISIBLE_GPUS", selectors)
+			}
+		}
+
+		if len(l) > 0 && len(visible) == 0 {
+			slog.Warn("OLLAMA_VISIBLE_GPUS matches none of the detected GPUs, falling back to CPU", "OLLAMA_VISIBLE_GPUS", selectors)
+		}
+	}
+
+	return visible
+}
+
 // Report the GPU information into the log an Info level
 func (l GpuInfoList) LogDetails() {
 	for _, g := range l {
@@ -121,6 +179,40 @@ func (a ByFreeMemory) Len() int           { return len(a) }
 func (a ByFreeMemory) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByFreeMemory) Less(i, j int) bool { return a[i].FreeMemory < a[j].FreeMemory }
 
+// Sort by compute capability, or gfx version for AMD GPUs, then total memory,
+// as a rough measure of speed
+type ByPerformance []GpuInfo
+
+func (a ByPerformance) Len() int      { return len(a) }
+func (a ByPerformance) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByPerformance) Less(i, j int) bool {
+	mi, ni := a[i].computeVersion()
+	mj, nj := a[j].computeVersion()
+	switch {
+	case mi != mj:
+		return mi < mj
+	case ni != nj:
+		return ni < nj
+	default:
+		return a[i].TotalMemory < a[j].TotalMemory
+	}
+}
+
+// computeVersion parses Compute, e.g. "8.6" for NVIDIA GPUs or "gfx90a" for
+// AMD GPUs, whose last two digits are hexadecimal
+func (g GpuInfo) computeVersion() (major, minor int) {
+	if s, ok := strings.CutPrefix(g.Compute, "gfx"); ok && len(s) > 2 {
+		major, _ = strconv.Atoi(s[:len(s)-2])
+		n, _ := strconv.ParseInt(s[len(s)-2:], 16, 0)
+		return major, int(n)
+	}
+
+	a, b, _ := strings.Cut(g.Compute, ".")
+	major, _ = strconv.Atoi(a)
+	minor, _ = strconv.Atoi(b)
+	return major, minor
+}
+
 type CPUCapability uint32
 
 // Override at build time when building base GPU runners
@@ -130,7 +222,7 @@ const (
 	CPUCapabilityNone CPUCapability = iota
 	CPUCapabilityAVX
 	CPUCapabilityAVX2
-	// TODO AVX512
+	CPUCapabilityAVX512
 )
 
 func (c CPUCapability) String() string {
@@ -139,7 +231,26 @@ func (c CPUCapability) String() string {
 		return "avx"
 	case CPUCapabilityAVX2:
 		return "avx2"
+	case CPUCapabilityAVX512:
+		return "avx512"
 	default:
 		return "no vector extensions"
 	}
 }
+
+// ParseCPUCapability parses the name of a CPU runner variant, such as avx2,
+// or none for the runner without vector extensions
+func ParseCPUCapability(s string) (CPUCapability, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "none" {
+		return CPUCapabilityNone, nil
+	}
+
+	for c := CPUCapabilityAVX; c <= CPUCapabilityAVX512; c++ {
+		if s == c.String() {
+			return c, nil
+		}
+	}
+
+	return CPUCapabilityNone, fmt.Errorf("unknown cpu variant %q", s)
+}
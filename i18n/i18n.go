@@ -0,0 +1,91 @@
+// Package i18n translates the messages of the CLI. Messages are looked up
+// by their English text in the catalog of the user's language, and are
+// shown in English if they haven't been translated to it.
+//
+// Catalogs are the JSON files in locales, named after their language, such
+// as es.json, each mapping English messages to their translations.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+var catalogs = sync.OnceValue(func() map[string]map[string]string {
+	entries, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]map[string]string)
+	for _, e := range entries {
+		b, err := locales.ReadFile(path.Join("locales", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		var catalog map[string]string
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			panic(fmt.Errorf("%s: %w", e.Name(), err))
+		}
+
+		catalogs[strings.TrimSuffix(e.Name(), ".json")] = catalog
+	}
+
+	return catalogs
+})
+
+// Locale returns the language messages are shown in, such as "es" or
+// "pt-BR": the first of OLLAMA_LANG, LC_ALL, LC_MESSAGES and LANG which is
+// set, if there's a catalog for its language, or "en" otherwise.
+func Locale() string {
+	for _, v := range []string{envconfig.Lang(), os.Getenv("LC_ALL"), os.Getenv("LC_MESSAGES"), os.Getenv("LANG")} {
+		if v == "" {
+			continue
+		}
+
+		// locales such as pt_BR.UTF-8@euro are pt-BR
+		v, _, _ = strings.Cut(v, ".")
+		v, _, _ = strings.Cut(v, "@")
+		lang, region, _ := strings.Cut(strings.ReplaceAll(v, "_", "-"), "-")
+		lang = strings.ToLower(lang)
+
+		if region != "" {
+			if tag := lang + "-" + strings.ToUpper(region); catalogs()[tag] != nil {
+				return tag
+			}
+		}
+
+		if catalogs()[lang] != nil {
+			return lang
+		}
+
+		return "en"
+	}
+
+	return "en"
+}
+
+// T returns msg translated to the user's language
+func T(msg string) string {
+	if translated, ok := catalogs()[Locale()][msg]; ok && translated != "" {
+		return translated
+	}
+
+	return msg
+}
+
+// Sprintf formats args with format translated to the user's language
+func Sprintf(format string, args ...any) string {
+	return fmt.Sprintf(T(format), args...)
+}
@@ -0,0 +1,50 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocale(t *testing.T) {
+	cases := []struct {
+		ollamaLang, lcAll, lang string
+		want                    string
+	}{
+		{"", "", "", "en"},
+		{"", "", "C.UTF-8", "en"},
+		{"", "", "es_ES.UTF-8", "es"},
+		{"", "es_MX", "en_US.UTF-8", "es"},
+		{"", "", "de_DE.UTF-8@euro", "en"},
+		{"en", "", "es_ES.UTF-8", "en"},
+		{"ES", "", "", "es"},
+	}
+
+	for _, tt := range cases {
+		t.Setenv("OLLAMA_LANG", tt.ollamaLang)
+		t.Setenv("LC_ALL", tt.lcAll)
+		t.Setenv("LC_MESSAGES", "")
+		t.Setenv("LANG", tt.lang)
+		assert.Equal(t, tt.want, Locale(), "OLLAMA_LANG=%q LC_ALL=%q LANG=%q", tt.ollamaLang, tt.lcAll, tt.lang)
+	}
+}
+
+func TestT(t *testing.T) {
+	t.Setenv("OLLAMA_LANG", "es")
+	assert.Equal(t, "Comandos disponibles:", T("Available Commands:"))
+	assert.Equal(t, "Comando desconocido '/foo'. Escriba /? para ver la ayuda", Sprintf("Unknown command '%s'. Type /? for help", "/foo"))
+	assert.Equal(t, "not translated", T("not translated"))
+
+	t.Setenv("OLLAMA_LANG", "en")
+	assert.Equal(t, "Available Commands:", T("Available Commands:"))
+}
+
+func TestCatalogs(t *testing.T) {
+	for lang, catalog := range catalogs() {
+		for msg, translated := range catalog {
+			// translations must format the same arguments
+			assert.Equal(t, strings.Count(msg, "%"), strings.Count(translated, "%"), "%s: %q", lang, msg)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// adapterGate makes sure requests running on a runner at the same time use
+// the same set of LoRA adapters. Adapters are applied to the whole llama.cpp
+// context, so a request for a different set waits for the running requests
+// to finish before the runner switches adapters. Once a request for another
+// set is waiting, new requests for the current set wait too so it isn't
+// starved.
+type adapterGate struct {
+	mu       sync.Mutex
+	current  string
+	active   int
+	waiting  map[string]int // waiting requests by adapters
+	nwaiting int
+	idle     chan struct{} // closed when active drops to zero
+}
+
+func newAdapterGate() *adapterGate {
+	return &adapterGate{waiting: make(map[string]int), idle: make(chan struct{})}
+}
+
+// Acquire blocks until requests for the adapters identified by key may run or
+// ctx is done
+func (g *adapterGate) Acquire(ctx context.Context, key string) error {
+	g.mu.Lock()
+	for g.active > 0 && (g.current != key || g.nwaiting > g.waiting[key]) {
+		idle := g.idle
+		g.waiting[key]++
+		g.nwaiting++
+		g.mu.Unlock()
+
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-idle:
+		}
+
+		g.mu.Lock()
+		g.waiting[key]--
+		g.nwaiting--
+		if g.waiting[key] == 0 {
+			delete(g.waiting, key)
+		}
+
+		if err != nil {
+			g.mu.Unlock()
+			return err
+		}
+	}
+
+	g.current = key
+	g.active++
+	g.mu.Unlock()
+	return nil
+}
+
+func (g *adapterGate) Release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.active--
+	if g.active == 0 {
+		close(g.idle)
+		g.idle = make(chan struct{})
+	}
+}
+
+// adapterScale returns the scaling factor of the i-th adapter
+func adapterScale(scales []float32, i int) float32 {
+	if i < len(scales) {
+		return scales[i]
+	}
+
+	return 1
+}
+
+// loraAdapters returns the adapters at paths with their scales as sent to
+// the runner and a key identifying them for the adapter gate
+func loraAdapters(paths []string, scales []float32) ([]map[string]any, string) {
+	lora := make([]map[string]any, len(paths))
+	keys := make([]string, len(paths))
+	for i, path := range paths {
+		scale := adapterScale(scales, i)
+		lora[i] = map[string]any{"path": path, "scale": scale}
+		keys[i] = fmt.Sprintf("%s:%g", path, scale)
+	}
+
+	return lora, strings.Join(keys, ";")
+}
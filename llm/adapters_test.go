@@ -0,0 +1,72 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdapterGate(t *testing.T) {
+	g := newAdapterGate()
+	ctx := context.Background()
+
+	// requests for the same adapters run together
+	if err := g.Acquire(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Acquire(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a request for other adapters waits for them to finish
+	acquired := make(chan struct{})
+	go func() {
+		if err := g.Acquire(ctx, "b"); err != nil {
+			t.Error(err)
+		}
+		close(acquired)
+	}()
+
+	// and new requests for the current adapters wait behind it
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	time.Sleep(10 * time.Millisecond)
+	if err := g.Acquire(timeout, "a"); err == nil {
+		t.Fatal("expected request for current adapters to wait")
+	}
+
+	g.Release()
+	select {
+	case <-acquired:
+		t.Fatal("acquired while other adapters are running")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	g.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for adapters to switch")
+	}
+
+	g.Release()
+}
+
+func TestLoraAdapters(t *testing.T) {
+	lora, key := loraAdapters([]string{"a", "b"}, []float32{0.5})
+	if len(lora) != 2 || lora[0]["scale"] != float32(0.5) || lora[1]["scale"] != float32(1) {
+		t.Errorf("unexpected adapters %v", lora)
+	}
+
+	if key != "a:0.5;b:1" {
+		t.Errorf("unexpected key %q", key)
+	}
+
+	if _, other := loraAdapters([]string{"a", "b"}, []float32{0.5, 0.5}); other == key {
+		t.Error("expected different keys for different scales")
+	}
+
+	if lora, key := loraAdapters(nil, nil); len(lora) != 0 || key != "" {
+		t.Errorf("expected no adapters, got %v %q", lora, key)
+	}
+}
@@ -0,0 +1,232 @@
+package llm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/d4l3k/go-bfloat16"
+	"github.com/x448/float16"
+)
+
+// Pooling types the runner uses to combine the hidden states of the input
+// tokens into one vector
+const (
+	PoolingMean = "mean"
+	PoolingCLS  = "cls"
+	PoolingLast = "last"
+)
+
+// Classifier is a sequence classification head, such as a toxicity or
+// sentiment head, applied to the pooled hidden state of a model. llama.cpp
+// fails to load models with unknown tensors, so the head is stored in its own
+// GGUF file with the "classifier" architecture. It has these keys:
+//
+//   - classifier.labels: the label of each output
+//   - classifier.pooling: "cls" (default), "mean" or "last"
+//   - classifier.problem_type: "single_label_classification" (default) scores
+//     labels with a softmax, "multi_label_classification" with a sigmoid each
+//     and "regression" returns the raw outputs, such as the relevance score
+//     of a cross-encoder reranker
+//   - classifier.separator: the text joining the two inputs of a pair, such
+//     as "[SEP]" for BERT rerankers
+//
+// and tensors cls.output.weight and, optionally, cls.output.bias. Heads with
+// a hidden layer, such as BERT's pooler, also have cls.dense.weight and
+// cls.dense.bias which are applied with a tanh activation first.
+type Classifier struct {
+	Labels     []string
+	Pooling    string
+	Separator  string
+	MultiLabel bool
+	Regression bool
+
+	dense, denseBias   []float32
+	output, outputBias []float32
+}
+
+// LoadClassifier reads the classifier head at path
+func LoadClassifier(path string) (*Classifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ggml, _, err := DecodeGGML(f, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := ggml.KV()
+	if kv.Architecture() != "classifier" {
+		return nil, fmt.Errorf("unexpected architecture %q for classifier", kv.Architecture())
+	}
+
+	c := Classifier{
+		Pooling:    PoolingCLS,
+		MultiLabel: kv["classifier.problem_type"] == "multi_label_classification",
+		Regression: kv["classifier.problem_type"] == "regression",
+	}
+
+	if s, ok := kv["classifier.separator"].(string); ok {
+		c.Separator = s
+	}
+
+	if s, ok := kv["classifier.pooling"].(string); ok {
+		c.Pooling = s
+	}
+
+	if a, ok := kv["classifier.labels"].(*array); ok {
+		for _, v := range a.values {
+			if s, ok := v.(string); ok {
+				c.Labels = append(c.Labels, s)
+			}
+		}
+	}
+
+	tensors := ggml.Tensors()
+	for _, t := range tensors.Items {
+		data, err := readTensorF32(f, int64(tensors.Offset+t.Offset), t)
+		if err != nil {
+			return nil, err
+		}
+
+		switch t.Name {
+		case "cls.dense.weight":
+			c.dense = data
+		case "cls.dense.bias":
+			c.denseBias = data
+		case "cls.output.weight":
+			c.output = data
+		case "cls.output.bias":
+			c.outputBias = data
+		}
+	}
+
+	switch {
+	case c.output == nil:
+		return nil, errors.New("classifier has no cls.output.weight tensor")
+	case len(c.Labels) == 0 || len(c.output)%len(c.Labels) != 0:
+		return nil, fmt.Errorf("classifier has %d labels which don't match its output", len(c.Labels))
+	case c.outputBias != nil && len(c.outputBias) != len(c.Labels):
+		return nil, errors.New("classifier output bias doesn't match its labels")
+	case c.dense != nil && c.denseBias != nil && len(c.dense)%len(c.denseBias) != 0:
+		return nil, errors.New("classifier dense bias doesn't match its weight")
+	}
+
+	return &c, nil
+}
+
+func readTensorF32(r io.ReaderAt, offset int64, t *Tensor) ([]float32, error) {
+	n := t.parameters()
+	switch t.Kind {
+	case 0: // F32
+		data := make([]float32, n)
+		if err := binary.Read(io.NewSectionReader(r, offset, int64(n)*4), binary.LittleEndian, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case 1: // F16
+		u16s := make([]uint16, n)
+		if err := binary.Read(io.NewSectionReader(r, offset, int64(n)*2), binary.LittleEndian, u16s); err != nil {
+			return nil, err
+		}
+
+		data := make([]float32, n)
+		for i := range u16s {
+			data[i] = float16.Frombits(u16s[i]).Float32()
+		}
+		return data, nil
+	case 30: // BF16
+		bts := make([]byte, n*2)
+		if _, err := r.ReadAt(bts, offset); err != nil {
+			return nil, err
+		}
+
+		return bfloat16.DecodeFloat32(bts), nil
+	default:
+		return nil, fmt.Errorf("unsupported tensor type %d for %s", t.Kind, t.Name)
+	}
+}
+
+// linear returns w x + b for a weight w stored with one row of len(x)
+// elements per output
+func linear(w, b, x []float32) ([]float32, error) {
+	if len(x) == 0 || len(w)%len(x) != 0 {
+		return nil, fmt.Errorf("input has %d elements, but the classifier expects a multiple of %d", len(x), len(w))
+	}
+
+	out := make([]float32, len(w)/len(x))
+	for i := range out {
+		var sum float32
+		for j, v := range w[i*len(x) : (i+1)*len(x)] {
+			sum += v * x[j]
+		}
+
+		if b != nil {
+			sum += b[i]
+		}
+		out[i] = sum
+	}
+
+	return out, nil
+}
+
+// Classify returns the score of each label for the pooled hidden state of
+// an input
+func (c *Classifier) Classify(hidden []float32) ([]float32, error) {
+	x := hidden
+	if c.dense != nil {
+		var err error
+		if x, err = linear(c.dense, c.denseBias, x); err != nil {
+			return nil, err
+		}
+
+		for i := range x {
+			x[i] = float32(math.Tanh(float64(x[i])))
+		}
+	}
+
+	logits, err := linear(c.output, c.outputBias, x)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(logits) != len(c.Labels) {
+		return nil, fmt.Errorf("classifier produced %d scores for %d labels", len(logits), len(c.Labels))
+	}
+
+	if c.Regression {
+		return logits, nil
+	}
+
+	if c.MultiLabel {
+		for i, v := range logits {
+			logits[i] = float32(1 / (1 + math.Exp(-float64(v))))
+		}
+		return logits, nil
+	}
+
+	// softmax
+	maxLogit := logits[0]
+	for _, v := range logits {
+		maxLogit = max(maxLogit, v)
+	}
+
+	var sum float64
+	for i, v := range logits {
+		e := math.Exp(float64(v - maxLogit))
+		logits[i] = float32(e)
+		sum += e
+	}
+
+	for i := range logits {
+		logits[i] = float32(float64(logits[i]) / sum)
+	}
+
+	return logits, nil
+}
@@ -0,0 +1,129 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func f32Tensor(t *testing.T, name string, shape []uint64, values ...float32) Tensor {
+	t.Helper()
+
+	var b bytes.Buffer
+	require.NoError(t, binary.Write(&b, binary.LittleEndian, values))
+	return Tensor{Name: name, Kind: 0, Shape: shape, WriterTo: &b}
+}
+
+func writeClassifier(t *testing.T, kv KV, tensors []Tensor) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "classifier.gguf")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, WriteGGUF(f, kv, tensors))
+	return path
+}
+
+func TestClassifier(t *testing.T) {
+	path := writeClassifier(t, KV{
+		"general.architecture": "classifier",
+		"classifier.labels":    []string{"negative", "positive"},
+	}, []Tensor{
+		f32Tensor(t, "cls.output.weight", []uint64{2, 2}, 1, 0, 0, 1),
+		f32Tensor(t, "cls.output.bias", []uint64{2}, 0, 0),
+	})
+
+	c, err := LoadClassifier(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"negative", "positive"}, c.Labels)
+	assert.Equal(t, PoolingCLS, c.Pooling)
+	assert.False(t, c.MultiLabel)
+
+	scores, err := c.Classify([]float32{0, 0})
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{0.5, 0.5}, scores, 1e-6)
+
+	scores, err = c.Classify([]float32{0, 2})
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{0.1192, 0.8808}, scores, 1e-4)
+
+	_, err = c.Classify([]float32{1, 2, 3})
+	require.Error(t, err)
+}
+
+func TestClassifierMultiLabel(t *testing.T) {
+	path := writeClassifier(t, KV{
+		"general.architecture":    "classifier",
+		"classifier.labels":       []string{"toxic", "insult"},
+		"classifier.pooling":      PoolingMean,
+		"classifier.problem_type": "multi_label_classification",
+	}, []Tensor{
+		f32Tensor(t, "cls.dense.weight", []uint64{1, 1}, 1),
+		f32Tensor(t, "cls.dense.bias", []uint64{1}, 0),
+		f32Tensor(t, "cls.output.weight", []uint64{1, 2}, 1, -1),
+	})
+
+	c, err := LoadClassifier(path)
+	require.NoError(t, err)
+	assert.Equal(t, PoolingMean, c.Pooling)
+	assert.True(t, c.MultiLabel)
+
+	scores, err := c.Classify([]float32{0})
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{0.5, 0.5}, scores, 1e-6)
+}
+
+func TestLoadClassifierErrors(t *testing.T) {
+	cases := map[string]struct {
+		kv      KV
+		tensors []Tensor
+	}{
+		"architecture": {
+			KV{"general.architecture": "llama"},
+			[]Tensor{f32Tensor(t, "cls.output.weight", []uint64{1}, 1)},
+		},
+		"missing output": {
+			KV{"general.architecture": "classifier", "classifier.labels": []string{"a"}},
+			[]Tensor{f32Tensor(t, "cls.dense.weight", []uint64{1}, 1)},
+		},
+		"labels": {
+			KV{"general.architecture": "classifier", "classifier.labels": []string{"a", "b", "c"}},
+			[]Tensor{f32Tensor(t, "cls.output.weight", []uint64{2, 2}, 1, 0, 0, 1)},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadClassifier(writeClassifier(t, tt.kv, tt.tensors))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestClassifierRegression(t *testing.T) {
+	path := writeClassifier(t, KV{
+		"general.architecture":    "classifier",
+		"classifier.labels":       []string{"relevance"},
+		"classifier.problem_type": "regression",
+		"classifier.separator":    "[SEP]",
+	}, []Tensor{
+		f32Tensor(t, "cls.output.weight", []uint64{2, 1}, 2, -1),
+		f32Tensor(t, "cls.output.bias", []uint64{1}, 0.5),
+	})
+
+	c, err := LoadClassifier(path)
+	require.NoError(t, err)
+	assert.True(t, c.Regression)
+	assert.Equal(t, "[SEP]", c.Separator)
+
+	scores, err := c.Classify([]float32{1, 1})
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{1.5}, scores, 1e-6)
+}
@@ -45,6 +45,7 @@
 #endif
 
 #include <algorithm>
+#include <cmath>
 #include <cstddef>
 #include <thread>
 #include <chrono>
@@ -64,10 +65,13 @@ struct server_params {
     bool slots_endpoint = true;
     bool metrics_endpoint = false;
     int n_threads_http = -1;
+    std::string vocoder;
+    float max_tokens_per_second = 0; // across every slot, 0 is no limit
 };
 
 bool server_verbose = false;
 bool server_log_json = false;
+int server_log_level = SERVER_LOG_LEVEL_INFO;
 
 enum stop_type {
     STOP_FULL,
@@ -94,6 +98,8 @@ struct slot_params {
     int32_t  n_keep    =  0; // number of tokens to keep from initial prompt
     int32_t  n_predict = -1; // new tokens to predict
 
+    bool context_shift = true; // shift the context when it is full instead of stopping
+
     std::vector<std::string> antiprompt;
 
     json input_prefix;
@@ -116,6 +122,10 @@ struct server_slot {
     int id;
     int task_id = -1;
 
+    // the API request of the task and when the slot was given it
+    std::string request_id;
+    int64_t t_assigned = 0;
+
     struct slot_params params;
 
     slot_state state = IDLE;
@@ -134,6 +144,10 @@ struct server_slot {
 
     int32_t n_prompt_tokens           = 0;
     int32_t n_prompt_tokens_processed = 0;
+    int32_t n_prompt_tokens_cached    = 0;
+    int32_t n_discarded               = 0; // tokens dropped from the prompt or shifted out of the context
+
+    double sum_logprob = 0; // of the sampled tokens, to score candidates of best_of
 
     json prompt;
     std::string generated_text;
@@ -163,6 +177,10 @@ struct server_slot {
     // multimodal
     std::vector<slot_image> images;
 
+    // lora adapters requested by the task and those its kv cache was computed with
+    std::vector<llama_lora_adapter_container> lora;
+    std::string lora_key;
+
     // stats
     size_t n_sent_text = 0; // number of sent text character
     size_t n_sent_token_probs = 0;
@@ -178,6 +196,9 @@ struct server_slot {
 
     void reset() {
         n_prompt_tokens        = 0;
+        n_prompt_tokens_cached = 0;
+        n_discarded            = 0;
+        sum_logprob            = 0;
         generated_text         = "";
         truncated              = false;
         stopped_eos            = false;
@@ -247,6 +268,7 @@ struct server_slot {
         return json
         {
             {"prompt_n",               n_prompt_tokens_processed},
+            {"prompt_cached_n",        n_prompt_tokens_cached},
             {"prompt_ms",              t_prompt_processing},
             {"prompt_per_token_ms",    t_prompt_processing / n_prompt_tokens_processed},
             {"prompt_per_second",      1e3 / t_prompt_processing * n_prompt_tokens_processed},
@@ -342,6 +364,18 @@ struct llama_server_context
 
     llama_batch batch;
 
+    // lora adapters given on the command line, all loaded adapters and the
+    // key of the adapters currently applied to ctx
+    std::vector<llama_lora_adapter_container> lora_default;
+    std::vector<llama_lora_adapter_container> lora_loaded;
+    std::string lora_applied;
+
+    // vocoder of text-to-speech models, it decodes audio codes generated by
+    // the model into spectrogram frames
+    llama_model   *vocoder     = nullptr;
+    llama_context *vocoder_ctx = nullptr;
+    std::mutex     vocoder_mutex;
+
     bool multimodal         = false;
     bool clean_kv_cache     = true;
     bool all_slots_are_idle = false;
@@ -349,6 +383,11 @@ struct llama_server_context
 
     int32_t n_ctx;  // total context for all clients / slots
 
+    // generation is paced so tokens aren't sampled faster than
+    // max_tokens_per_second, with t_next_token the time the next token is due
+    float   max_tokens_per_second = 0;
+    int64_t t_next_token          = 0;
+
     // system prompt
     bool system_need_update = false;
 
@@ -368,6 +407,16 @@ struct llama_server_context
 
     ~llama_server_context()
     {
+        if (vocoder_ctx)
+        {
+            llama_free(vocoder_ctx);
+            vocoder_ctx = nullptr;
+        }
+        if (vocoder)
+        {
+            llama_free_model(vocoder);
+            vocoder = nullptr;
+        }
         if (clp_ctx)
         {
             LOG_DEBUG("freeing clip model", {});
@@ -412,6 +461,10 @@ struct llama_server_context
             return false;
         }
 
+        lora_default = init_result.lora_adapters;
+        lora_loaded  = init_result.lora_adapters;
+        lora_applied = lora_key(lora_default);
+
         if (multimodal) {
             const int n_embd_clip = clip_n_mmproj_embd(clp_ctx);
             const int n_embd_llm  = llama_n_embd(model);
@@ -430,6 +483,73 @@ struct llama_server_context
         return true;
     }
 
+    bool load_vocoder(const std::string &path)
+    {
+        // vocoders are small so they stay on the CPU and don't change the
+        // memory estimate of the model
+        llama_model_params mparams = llama_model_default_params();
+        mparams.n_gpu_layers = 0;
+
+        vocoder = llama_load_model_from_file(path.c_str(), mparams);
+        if (vocoder == nullptr)
+        {
+            LOG_ERROR("unable to load vocoder", {{"model", path}});
+            return false;
+        }
+
+        llama_context_params cparams = llama_context_default_params();
+        cparams.n_ctx        = llama_n_ctx_train(vocoder);
+        cparams.n_batch      = cparams.n_ctx;
+        cparams.n_ubatch     = cparams.n_ctx;
+        cparams.n_threads    = params.n_threads;
+        cparams.embeddings   = true;
+        cparams.pooling_type = LLAMA_POOLING_TYPE_NONE;
+
+        vocoder_ctx = llama_new_context_with_model(vocoder, cparams);
+        if (vocoder_ctx == nullptr)
+        {
+            LOG_ERROR("unable to create vocoder context", {{"model", path}});
+            llama_free_model(vocoder);
+            vocoder = nullptr;
+            return false;
+        }
+
+        return true;
+    }
+
+    // vocode returns one frame of n_embd values for each audio code
+    bool vocode(const std::vector<llama_token> &codes, std::vector<float> &frames, int &n_embd)
+    {
+        std::lock_guard<std::mutex> lock(vocoder_mutex);
+
+        const int n_codes = codes.size();
+        if (n_codes == 0 || n_codes > (int) llama_n_ctx(vocoder_ctx))
+        {
+            LOG_ERROR("invalid number of audio codes", {{"n_codes", n_codes}, {"n_ctx", llama_n_ctx(vocoder_ctx)}});
+            return false;
+        }
+
+        llama_batch batch = llama_batch_init(n_codes, 0, 1);
+        for (int i = 0; i < n_codes; i++)
+        {
+            llama_batch_add(batch, codes[i], i, {0}, true);
+        }
+
+        if (llama_decode(vocoder_ctx, batch) != 0)
+        {
+            LOG_ERROR("failed to decode audio codes", {{"n_codes", n_codes}});
+            llama_batch_free(batch);
+            return false;
+        }
+
+        n_embd = llama_n_embd(vocoder);
+        const float *embd = llama_get_embeddings(vocoder_ctx);
+        frames.assign(embd, embd + (size_t) n_codes * n_embd);
+
+        llama_batch_free(batch);
+        return true;
+    }
+
     void initialize() {
         // create slots
         all_slots_are_idle = true;
@@ -549,10 +669,68 @@ struct llama_server_context
         return last_used;
     }
 
+    static std::string lora_key(const std::vector<llama_lora_adapter_container> &adapters)
+    {
+        std::string key;
+        for (const auto &la : adapters)
+        {
+            key += la.path + ":" + std::to_string(la.scale) + ";";
+        }
+        return key;
+    }
+
+    // load_lora returns the adapter at path, loading it the first time it is used
+    llama_lora_adapter *load_lora(const std::string &path)
+    {
+        for (const auto &la : lora_loaded)
+        {
+            if (la.path == path)
+            {
+                return la.adapter;
+            }
+        }
+
+        llama_lora_adapter *adapter = llama_lora_adapter_init(model, path.c_str());
+        if (adapter == nullptr)
+        {
+            return nullptr;
+        }
+
+        llama_lora_adapter_container la;
+        la.path    = path;
+        la.scale   = 1.0f;
+        la.adapter = adapter;
+        lora_loaded.push_back(la);
+        return adapter;
+    }
+
     bool launch_slot_with_data(server_slot* &slot, json data) {
         slot_params default_params;
         llama_sampling_params default_sparams;
 
+        slot->request_id = json_value(data, "request_id", std::string());
+        slot->t_assigned = ggml_time_us();
+
+        // requests without adapters use the ones given on the command line
+        slot->lora = lora_default;
+        if (data.count("lora") != 0 && data["lora"].is_array())
+        {
+            slot->lora.clear();
+            for (const auto &entry : data["lora"])
+            {
+                llama_lora_adapter_container la;
+                la.path    = json_value(entry, "path", std::string());
+                la.scale   = json_value(entry, "scale", 1.0f);
+                la.adapter = load_lora(la.path);
+                if (la.adapter == nullptr)
+                {
+                    LOG_ERROR("failed to load lora adapter", {{"path", la.path}});
+                    return false;
+                }
+                slot->lora.push_back(la);
+            }
+        }
+
         slot->params.stream             = json_value(data, "stream",            false);
         slot->params.cache_prompt       = json_value(data, "cache_prompt",      false);
         slot->params.n_predict          = json_value(data, "n_predict",         default_params.n_predict);
@@ -573,6 +751,7 @@ struct llama_server_context
         slot->sparams.mirostat_eta      = json_value(data, "mirostat_eta",      default_sparams.mirostat_eta);
         slot->sparams.penalize_nl       = json_value(data, "penalize_nl",       default_sparams.penalize_nl);
         slot->params.n_keep             = json_value(data, "n_keep",            slot->params.n_keep);
+        slot->params.context_shift      = json_value(data, "context_shift",     default_params.context_shift);
         slot->sparams.seed              = json_value(data, "seed",              default_params.seed);
         slot->sparams.grammar           = json_value(data, "grammar",           default_sparams.grammar);
         slot->sparams.n_probs           = json_value(data, "n_probs",           default_sparams.n_probs);
@@ -1160,9 +1339,21 @@ struct llama_server_context
             {"stopped_limit",       slot.stopped_limit},
             {"stopping_word",       slot.stopping_word},
             {"tokens_cached",       slot.n_past},
+            {"tokens_discarded",    slot.n_discarded},
+            {"logprob",             slot.sum_logprob},
             {"timings",             slot.get_formated_timings()}
         };
 
+        if (!slot.images.empty())
+        {
+            std::vector<int32_t> image_tokens;
+            for (const slot_image &img : slot.images)
+            {
+                image_tokens.push_back(img.image_tokens);
+            }
+            res.result_json["image_tokens"] = image_tokens;
+        }
+
         if (slot.sparams.n_probs > 0)
         {
             std::vector<completion_token_output> probs = {};
@@ -1466,10 +1657,25 @@ struct llama_server_context
             } break;
             case TASK_TYPE_METRICS: {
                 json slots_data        = json::array();
+                json slot_summaries    = json::array();
                 int n_idle_slots       = 0;
                 int n_processing_slots = 0;
 
                 for (server_slot &slot: slots) {
+                    // a compact summary without the prompt, for ollama ps
+                    json summary = {{"id", slot.id}, {"state", "idle"}};
+                    if (slot.state != IDLE || slot.command == LOAD_PROMPT) {
+                        // the counts are of the previous task until the prompt is loaded
+                        const bool loaded = slot.command != LOAD_PROMPT;
+                        summary["state"]           = loaded && slot.n_decoded > 0 ? "generating" : "prompt";
+                        summary["request_id"]      = slot.request_id;
+                        summary["n_prompt_tokens"] = loaded ? slot.n_prompt_tokens : 0;
+                        summary["n_past"]          = loaded ? slot.n_past : 0;
+                        summary["n_decoded"]       = loaded ? slot.n_decoded : 0;
+                        summary["t_elapsed_ms"]    = (ggml_time_us() - slot.t_assigned) / 1000;
+                    }
+                    slot_summaries.push_back(summary);
+
                     json slot_data = get_formated_generation(slot);
                     slot_data["id"] = slot.id;
                     slot_data["task_id"] = slot.task_id;
@@ -1524,6 +1730,7 @@ struct llama_server_context
                         { "kv_cache_used_cells",             llama_get_kv_cache_used_cells(ctx)},
 
                         { "slots",                           slots_data },
+                        { "slot_summaries",                  slot_summaries },
                 };
                 metrics.reset_bucket();
                 queue_results.send(res);
@@ -1581,6 +1788,18 @@ struct llama_server_context
             {
                 if (slot.is_processing() && system_tokens.size() + slot.cache_tokens.size() >= (size_t) slot.n_ctx)
                 {
+                    if (!slot.params.context_shift && slot.state == PROCESSING && slot.command != RELEASE)
+                    {
+                        // stop generating rather than discard tokens from the context
+                        slot.stopped_limit = true;
+                        slot.has_next_token = false;
+                        slot.release();
+                        slot.print_timings();
+                        send_final_response(slot);
+                        metrics.on_prediction(slot);
+                        continue;
+                    }
+
                     // Shift context
                     const int n_keep    = slot.params.n_keep + add_bos_token;
                     const int n_left    = (int) system_tokens.size() + slot.n_past - n_keep;
@@ -1608,6 +1827,7 @@ struct llama_server_context
                     slot.cache_tokens.resize(slot.cache_tokens.size() - n_discard);
 
                     slot.n_past -= n_discard;
+                    slot.n_discarded += n_discard;
 
                     slot.truncated = true;
                 }
@@ -1715,12 +1935,29 @@ struct llama_server_context
                             {"n_erase",      n_erase},
                         });
                         slot.truncated = true;
+                        slot.n_discarded += n_erase;
                         prompt_tokens = new_tokens;
 
                         slot.n_prompt_tokens = prompt_tokens.size();
                         GGML_ASSERT(slot.n_prompt_tokens < slot.n_ctx);
                     }
 
+                    // the caller makes sure all tasks running at the same time use
+                    // the same adapters, so they can be applied to the whole context
+                    const std::string key = lora_key(slot.lora);
+                    if (lora_applied != key)
+                    {
+                        llama_lora_adapters_apply(ctx, slot.lora);
+                        lora_applied = key;
+                    }
+
+                    // the cached prompt is only valid for the adapters it was computed with
+                    if (slot.lora_key != key)
+                    {
+                        slot.cache_tokens.clear();
+                        slot.lora_key = key;
+                    }
+
                     if (!slot.params.cache_prompt)
                     {
                         llama_sampling_reset(slot.ctx_sampling);
@@ -1747,6 +1984,43 @@ struct llama_server_context
                             slot.n_past -= 1;
                         }
 
+                        // an idle slot may hold a longer prefix of the prompt (e.g. a shared
+                        // system prompt) in its KV cache, copy it rather than re-evaluating it
+                        if (slot.ga_n == 1)
+                        {
+                            server_slot *src = nullptr;
+                            int32_t n_src = slot.n_past;
+                            for (server_slot & other : slots)
+                            {
+                                if (other.id == slot.id || other.state != IDLE || other.ga_n != 1 || !other.images.empty() || other.lora_key != slot.lora_key)
+                                {
+                                    continue;
+                                }
+
+                                const int32_t n = std::min((int32_t) common_part(other.cache_tokens, prompt_tokens), other.n_past);
+                                if (n > n_src)
+                                {
+                                    src = &other;
+                                    n_src = n;
+                                }
+                            }
+
+                            if (src != nullptr)
+                            {
+                                LOG_DEBUG("reusing prompt prefix from slot", {
+                                    { "slot_id",     slot.id },
+                                    { "task_id",     slot.task_id },
+                                    { "src_slot_id", src->id },
+                                    { "n_past",      n_src }
+                                });
+
+                                const int p0 = (int) system_tokens.size();
+                                llama_kv_cache_seq_rm(ctx, slot.id, p0, -1);
+                                llama_kv_cache_seq_cp(ctx, src->id, slot.id, p0, p0 + n_src);
+                                slot.n_past = n_src;
+                            }
+                        }
+
                         slot.n_prompt_tokens_processed = slot.n_prompt_tokens;
 
                         if (slot.ga_n != 1)
@@ -1794,6 +2068,8 @@ struct llama_server_context
                         }
                     }
 
+                    slot.n_prompt_tokens_cached = slot.n_past;
+
                     int p0 = (int) system_tokens.size() + slot.n_past;
                     LOG_DEBUG("kv cache rm [p0, end)", {
                         { "slot_id", slot.id },
@@ -1928,6 +2204,7 @@ struct llama_server_context
                 continue;
             }
 
+            int32_t n_sampled = 0;
             for (auto & slot : slots)
             {
                 if (slot.i_batch < (int) i || slot.i_batch >= (int) (i + n_tokens))
@@ -1950,6 +2227,7 @@ struct llama_server_context
                 llama_sampling_accept(slot.ctx_sampling, ctx, id, true);
 
                 slot.n_decoded += 1;
+                n_sampled += 1;
                 if (slot.n_decoded == 1)
                 {
                     slot.t_start_genereration = ggml_time_us();
@@ -1972,6 +2250,20 @@ struct llama_server_context
                     result.probs.push_back({cur_p.data[i].id, cur_p.data[i].p});
                 }
 
+                // the candidates hold the probabilities the token was
+                // sampled from, unless it was picked greedily
+                if (slot.sparams.temp > 0)
+                {
+                    for (size_t i = 0; i < cur_p.size; ++i)
+                    {
+                        if (cur_p.data[i].id == id)
+                        {
+                            slot.sum_logprob += std::log(std::max(cur_p.data[i].p, 1e-10f));
+                            break;
+                        }
+                    }
+                }
+
                 if (!process_token(result, slot))
                 {
                     slot.release();
@@ -1982,12 +2274,32 @@ struct llama_server_context
 
                 slot.i_batch = -1;
             }
+
+            throttle(n_sampled);
         }
 
         LOG_VERBOSE("slots updated", {});
         return true;
     }
 
+    // throttle waits until n_tokens more tokens can be sampled without
+    // exceeding max_tokens_per_second. Up to a second of tokens may be
+    // sampled at once after the model has been idle.
+    void throttle(int32_t n_tokens)
+    {
+        if (max_tokens_per_second <= 0 || n_tokens == 0)
+        {
+            return;
+        }
+
+        const int64_t t_now = ggml_time_us();
+        t_next_token = std::max(t_next_token, t_now - 1000000) + (int64_t) (1e6 * n_tokens / max_tokens_per_second);
+        if (t_next_token > t_now)
+        {
+            std::this_thread::sleep_for(std::chrono::microseconds(t_next_token - t_now));
+        }
+    }
+
     json model_meta() {
         return json{
                 {"vocab_type", llama_vocab_type(model)},
@@ -2008,6 +2320,7 @@ static void server_print_usage(const char *argv0, const gpt_params &params,
     printf("options:\n");
     printf("  -h, --help                show this help message and exit\n");
     printf("  -v, --verbose             verbose output (default: %s)\n", server_verbose ? "enabled" : "disabled");
+    printf("  --log-level LEVEL         least severe messages logged, one of error, warn, info or debug (default: info)\n");
     printf("  -t N, --threads N         number of threads to use during computation (default: %d)\n", params.n_threads);
     printf("  -tb N, --threads-batch N  number of threads to use during batch and prompt processing (default: same as --threads)\n");
     printf("  --threads-http N          number of threads in the http server pool to process requests (default: max(hardware concurrency - 1, --parallel N + 2))\n");
@@ -2020,9 +2333,10 @@ static void server_print_usage(const char *argv0, const gpt_params &params,
     printf("  --yarn-attn-factor N      YaRN: scale sqrt(t) or attention magnitude (default: 1.0)\n");
     printf("  --yarn-beta-slow N        YaRN: high correction dim or alpha (default: %.1f)\n", params.yarn_beta_slow);
     printf("  --yarn-beta-fast N        YaRN: low correction dim or beta (default: %.1f)\n", params.yarn_beta_fast);
-    printf("  --pooling {none,mean,cls}\n");
+    printf("  --pooling {none,mean,cls,last}\n");
     printf("                        pooling type for embeddings, use model default if unspecified\n");
     printf("  -b N, --batch-size N      batch size for prompt processing (default: %d)\n", params.n_batch);
+    printf("  -ub N, --ubatch-size N    physical batch size for prompt processing (default: %d)\n", params.n_ubatch);
     printf("  --memory-f32              use f32 instead of f16 for memory key+value (default: disabled)\n");
     printf("                            not recommended: doubles context memory required and no measurable increase in quality\n");
     if (llama_supports_mlock())
@@ -2050,11 +2364,13 @@ static void server_print_usage(const char *argv0, const gpt_params &params,
         printf("  -mg i, --main-gpu i       the GPU to use for the model (with split-mode = none),\n");
         printf("                            or for intermediate results and KV (with split-mode = row)\n");
     }
+    printf("  --rpc SERVERS             comma separated list of RPC servers to offload layers to\n");
     printf("  -m FNAME, --model FNAME\n");
     printf("                            model path (default: %s)\n", params.model.c_str());
     printf("  -a ALIAS, --alias ALIAS\n");
     printf("                            set an alias for the model, will be added as `model` field in completion response\n");
     printf("  --lora FNAME              apply LoRA adapter (implies --no-mmap)\n");
+    printf("  --lora-scaled FNAME S     apply LoRA adapter with user defined scaling S (implies --no-mmap)\n");
     printf("  --lora-base FNAME         optional model to use as a base for the layers modified by the LoRA adapter\n");
     printf("  --host                    ip address to listen (default  (default: %s)\n", sparams.hostname.c_str());
     printf("  --port PORT               port to listen (default  (default: %d)\n", sparams.port);
@@ -2073,6 +2389,7 @@ static void server_print_usage(const char *argv0, const gpt_params &params,
     printf("  -ctv TYPE, --cache-type-v TYPE\n");
     printf("                            KV cache data type for V (default: f16)\n");
     printf("  --mmproj MMPROJ_FILE      path to a multimodal projector file for LLaVA.\n");
+    printf("  --vocoder VOCODER_FILE    path to a vocoder decoding the audio codes of a text-to-speech model.\n");
     printf("  --log-format              log output format: json or text (default: json)\n");
     printf("  --log-disable             disables logging to a file.\n");
     printf("  --slots-endpoint-disable  disables slots monitoring endpoint.\n");
@@ -2272,6 +2589,7 @@ static void server_params_parse(int argc, char **argv, server_params &sparams, g
             /**/ if (value == "none") { params.pooling_type = LLAMA_POOLING_TYPE_NONE; }
             else if (value == "mean") { params.pooling_type = LLAMA_POOLING_TYPE_MEAN; }
             else if (value == "cls")  { params.pooling_type = LLAMA_POOLING_TYPE_CLS; }
+            else if (value == "last") { params.pooling_type = LLAMA_POOLING_TYPE_LAST; }
             else { invalid_param = true; break; }
         }
         else if (arg == "--threads" || arg == "-t")
@@ -2329,6 +2647,15 @@ static void server_params_parse(int argc, char **argv, server_params &sparams, g
             }
             params.n_batch = std::stoi(argv[i]);
         }
+        else if (arg == "-ub" || arg == "--ubatch-size")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            params.n_ubatch = std::stoi(argv[i]);
+        }
         else if (arg == "--gpu-layers" || arg == "-ngl" || arg == "--n-gpu-layers")
         {
             if (++i >= argc)
@@ -2378,7 +2705,7 @@ static void server_params_parse(int argc, char **argv, server_params &sparams, g
                 invalid_param = true;
                 break;
             }
-#if defined(GGML_USE_CUDA) || defined(GGML_USE_SYCL)
+#if defined(GGML_USE_CUDA) || defined(GGML_USE_SYCL) || defined(GGML_USE_RPC)
             std::string arg_next = argv[i];
 
             // split string by , and /
@@ -2401,6 +2728,19 @@ static void server_params_parse(int argc, char **argv, server_params &sparams, g
 #else
             LOG_WARNING("llama.cpp was compiled without CUDA. It is not possible to set a tensor split.\n", {});
 #endif // GGML_USE_CUDA
+        }
+        else if (arg == "--rpc")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+#ifdef GGML_USE_RPC
+            params.rpc_servers = argv[i];
+#else
+            LOG_WARNING("llama.cpp was compiled without RPC support. The --rpc option will be ignored.\n", {});
+#endif // GGML_USE_RPC
         }
         else if (arg == "--main-gpu" || arg == "-mg")
         {
@@ -2450,6 +2790,38 @@ static void server_params_parse(int argc, char **argv, server_params &sparams, g
         else if (arg == "-v" || arg == "--verbose")
         {
             server_verbose = true;
+            server_log_level = SERVER_LOG_LEVEL_DEBUG;
+        }
+        else if (arg == "--log-level")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            std::string level = argv[i];
+            if (level == "error")
+            {
+                server_log_level = SERVER_LOG_LEVEL_ERROR;
+            }
+            else if (level == "warn")
+            {
+                server_log_level = SERVER_LOG_LEVEL_WARN;
+            }
+            else if (level == "info")
+            {
+                server_log_level = SERVER_LOG_LEVEL_INFO;
+            }
+            else if (level == "debug")
+            {
+                server_log_level = SERVER_LOG_LEVEL_DEBUG;
+                server_verbose = true;
+            }
+            else
+            {
+                invalid_param = true;
+                break;
+            }
         }
         else if (arg == "--mlock")
         {
@@ -2517,6 +2889,24 @@ static void server_params_parse(int argc, char **argv, server_params &sparams, g
             }
             params.mmproj = argv[i];
         }
+        else if (arg == "--vocoder")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            sparams.vocoder = argv[i];
+        }
+        else if (arg == "--max-tokens-per-second")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            sparams.max_tokens_per_second = std::stof(argv[i]);
+        }
         else if (arg == "--log-format")
         {
             if (++i >= argc)
@@ -2841,7 +3231,7 @@ int main(int argc, char **argv) {
     });
 
     if (sparams.slots_endpoint) {
-        svr.Get("/slots", [&](const httplib::Request&, httplib::Response& res) {
+        svr.Get("/slots", [&](const httplib::Request& req, httplib::Response& res) {
             // request slots data using task queue
             task_server task;
             task.id = llama.queue_tasks.get_new_id();
@@ -2855,7 +3245,11 @@ int main(int argc, char **argv) {
             task_result result = llama.queue_results.recv(task.id);
             llama.queue_results.remove_waiting_task_id(task.id);
 
-            res.set_content(result.result_json["slots"].dump(), "application/json");
+            if (req.has_param("summary")) {
+                res.set_content(result.result_json["slot_summaries"].dump(), "application/json");
+            } else {
+                res.set_content(result.result_json["slots"].dump(), "application/json");
+            }
             res.status = 200; // HTTP OK
         });
     }
@@ -3028,12 +3422,13 @@ int main(int argc, char **argv) {
     params.progress_callback = update_load_progress;
     params.progress_callback_user_data = (void*)&llama;
 
-    if (!llama.load_model(params))
+    if (!llama.load_model(params) || (!sparams.vocoder.empty() && !llama.load_vocoder(sparams.vocoder)))
     {
         state.store(SERVER_STATE_ERROR);
         return 1;
     } else {
         llama.initialize();
+        llama.max_tokens_per_second = sparams.max_tokens_per_second;
         state.store(SERVER_STATE_READY);
         LOG_INFO("model loaded", {});
     }
@@ -3081,6 +3476,10 @@ int main(int argc, char **argv) {
                 }
                 json data = json::parse(req.body);
                 const int task_id = llama.queue_tasks.get_new_id();
+                if (data.contains("request_id")) {
+                    // ties the task's log lines to the API request
+                    LOG_INFO("completion request", {{"request_id", data["request_id"]}, {"task_id", task_id}});
+                }
                 llama.queue_results.add_waiting_task_id(task_id);
                 llama.request_completion(task_id, data, false, -1);
                 if (!json_value(data, "stream", false)) {
@@ -3205,6 +3604,34 @@ int main(int argc, char **argv) {
                 return res.set_content(result.result_json.dump(), "application/json; charset=utf-8");
             });
 
+    svr.Post("/vocode", [&llama](const httplib::Request &req, httplib::Response &res)
+            {
+                res.set_header("Access-Control-Allow-Origin", req.get_header_value("Origin"));
+                if (llama.vocoder_ctx == nullptr)
+                {
+                    res.status = 400;
+                    return res.set_content(json{{"error", "model has no vocoder"}}.dump(), "application/json; charset=utf-8");
+                }
+
+                const json body = json::parse(req.body);
+                std::vector<llama_token> codes;
+                if (body.count("codes") != 0)
+                {
+                    codes = body["codes"].get<std::vector<llama_token>>();
+                }
+
+                std::vector<float> frames;
+                int n_embd = 0;
+                if (!llama.vocode(codes, frames, n_embd))
+                {
+                    res.status = 500;
+                    return res.set_content(json{{"error", "failed to decode audio codes"}}.dump(), "application/json; charset=utf-8");
+                }
+
+                const json data = json{{"frames", frames}, {"n_embd", n_embd}};
+                return res.set_content(data.dump(), "application/json; charset=utf-8");
+            });
+
     // GG: if I put the main loop inside a thread, it crashes on the first request when build in Debug!?
     //     "Bus error: 10" - this is on macOS, it does not crash on Linux
     //std::thread t2([&]()
@@ -35,8 +35,16 @@
 
 using json = nlohmann::json;
 
+enum server_log_levels {
+    SERVER_LOG_LEVEL_ERROR,
+    SERVER_LOG_LEVEL_WARN,
+    SERVER_LOG_LEVEL_INFO,
+    SERVER_LOG_LEVEL_DEBUG,
+};
+
 extern bool server_verbose;
 extern bool server_log_json;
+extern int server_log_level;
 
 #ifndef SERVER_VERBOSE
 #define SERVER_VERBOSE 1
@@ -128,6 +136,19 @@ static inline void server_log(const char *level, const char *function, int line,
         return;
     }
 
+    // messages less severe than --log-level are dropped
+    int severity = SERVER_LOG_LEVEL_DEBUG;
+    if (strcmp(level, "ERROR") == 0) {
+        severity = SERVER_LOG_LEVEL_ERROR;
+    } else if (strcmp(level, "WARN") == 0) {
+        severity = SERVER_LOG_LEVEL_WARN;
+    } else if (strcmp(level, "INFO") == 0) {
+        severity = SERVER_LOG_LEVEL_INFO;
+    }
+    if (severity > server_log_level) {
+        return;
+    }
+
     if (server_log_json) {
         log.merge_patch(
                 {
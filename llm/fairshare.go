@@ -0,0 +1,152 @@
+package llm
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx which identifies the client making the
+// request. Requests waiting for a slot on the same runner are granted slots
+// round-robin across clients rather than in arrival order.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the client set by [WithClient] or an empty string
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+type queueDeadlineKey struct{}
+
+type queueDeadline struct {
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// WithQueueDeadline returns a copy of ctx whose request waits for a slot on
+// its runner until deadline at most, when its queue timeout of timeout
+// expires
+func WithQueueDeadline(ctx context.Context, timeout time.Duration, deadline time.Time) context.Context {
+	return context.WithValue(ctx, queueDeadlineKey{}, queueDeadline{timeout, deadline})
+}
+
+// fairSemaphore bounds the number of concurrent requests to a runner. Unlike
+// a FIFO semaphore, waiters are queued per client and slots are handed out
+// round-robin so a single client with a deep backlog can't starve others.
+type fairSemaphore struct {
+	mu     sync.Mutex
+	size   int
+	cur    int
+	queues map[string]*list.List
+	order  []string // clients with waiters, in the order they will be served
+}
+
+type fairWaiter struct {
+	client string
+	ready  chan struct{}
+}
+
+func newFairSemaphore(n int) *fairSemaphore {
+	return &fairSemaphore{
+		size:   n,
+		queues: make(map[string]*list.List),
+	}
+}
+
+// Acquire blocks until a slot is available for client or ctx is done
+func (s *fairSemaphore) Acquire(ctx context.Context, client string) error {
+	s.mu.Lock()
+	if s.cur < s.size && len(s.order) == 0 {
+		s.cur++
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &fairWaiter{client: client, ready: make(chan struct{})}
+	q, ok := s.queues[client]
+	if !ok {
+		q = list.New()
+		s.queues[client] = q
+		s.order = append(s.order, client)
+	}
+	elem := q.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// acquired after cancellation, give the slot back
+			s.cur--
+			s.notify()
+		default:
+			q.Remove(elem)
+			if q.Len() == 0 {
+				s.removeClient(client)
+			}
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Release returns a slot and wakes the next waiter, if any
+func (s *fairSemaphore) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur--
+	s.notify()
+}
+
+// Waiting returns the number of requests waiting for a slot
+func (s *fairSemaphore) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, q := range s.queues {
+		n += q.Len()
+	}
+
+	return n
+}
+
+// notify hands free slots to waiters; s.mu must be held
+func (s *fairSemaphore) notify() {
+	for s.cur < s.size && len(s.order) > 0 {
+		client := s.order[0]
+		s.order = s.order[1:]
+
+		q := s.queues[client]
+		w := q.Remove(q.Front()).(*fairWaiter)
+		if q.Len() > 0 {
+			// move to the back of the line
+			s.order = append(s.order, client)
+		} else {
+			delete(s.queues, client)
+		}
+
+		s.cur++
+		close(w.ready)
+	}
+}
+
+// removeClient drops client from the round-robin order; s.mu must be held
+func (s *fairSemaphore) removeClient(client string) {
+	delete(s.queues, client)
+	for i, c := range s.order {
+		if c == client {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
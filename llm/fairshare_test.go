@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFairSemaphoreRoundRobin(t *testing.T) {
+	s := newFairSemaphore(1)
+	require.NoError(t, s.Acquire(context.Background(), "a"))
+
+	order := make(chan string, 4)
+	enqueue := func(client string) {
+		go func() {
+			if err := s.Acquire(context.Background(), client); err == nil {
+				order <- client
+			}
+		}()
+
+		// wait for the waiter to be queued so arrival order is deterministic
+		require.Eventually(t, func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			q, ok := s.queues[client]
+			return ok && q.Len() > 0
+		}, time.Second, time.Millisecond)
+	}
+
+	enqueue("a")
+	enqueue("a")
+	enqueue("a")
+	enqueue("b")
+
+	var got []string
+	for range 4 {
+		s.Release()
+		got = append(got, <-order)
+	}
+
+	require.Equal(t, []string{"a", "b", "a", "a"}, got)
+}
+
+func TestFairSemaphoreCancel(t *testing.T) {
+	s := newFairSemaphore(1)
+	require.NoError(t, s.Acquire(context.Background(), "a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Acquire(ctx, "b"), context.DeadlineExceeded)
+
+	s.mu.Lock()
+	require.Empty(t, s.queues)
+	require.Empty(t, s.order)
+	s.mu.Unlock()
+
+	s.Release()
+	require.NoError(t, s.Acquire(context.Background(), "c"))
+}
+
+func TestFairSemaphoreWaiting(t *testing.T) {
+	s := newFairSemaphore(1)
+	require.NoError(t, s.Acquire(context.Background(), "a"))
+	require.Zero(t, s.Waiting())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, client := range []string{"a", "b", "b"} {
+		go s.Acquire(ctx, client) //nolint:errcheck
+	}
+
+	require.Eventually(t, func() bool { return s.Waiting() == 3 }, time.Second, time.Millisecond)
+
+	cancel()
+	require.Eventually(t, func() bool { return s.Waiting() == 0 }, time.Second, time.Millisecond)
+}
@@ -1,6 +1,9 @@
 package llm
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type fileType uint32
 
@@ -32,9 +35,10 @@ const (
 	fileTypeIQ1_S
 	fileTypeIQ4_NL
 	fileTypeIQ3_S
+	fileTypeIQ3_M
 	fileTypeIQ2_S
-	fileTypeIQ4_XS
 	fileTypeIQ2_M
+	fileTypeIQ4_XS
 	fileTypeIQ1_M
 	fileTypeBF16
 
@@ -93,6 +97,8 @@ func ParseFileType(s string) (fileType, error) {
 		return fileTypeIQ4_NL, nil
 	case "IQ3_S":
 		return fileTypeIQ3_S, nil
+	case "IQ3_M":
+		return fileTypeIQ3_M, nil
 	case "IQ2_S":
 		return fileTypeIQ2_S, nil
 	case "IQ4_XS":
@@ -160,6 +166,8 @@ func (t fileType) String() string {
 		return "IQ4_NL"
 	case fileTypeIQ3_S:
 		return "IQ3_S"
+	case fileTypeIQ3_M:
+		return "IQ3_M"
 	case fileTypeIQ2_S:
 		return "IQ2_S"
 	case fileTypeIQ4_XS:
@@ -178,3 +186,92 @@ func (t fileType) String() string {
 func (t fileType) Value() uint32 {
 	return uint32(t)
 }
+
+// quantizationTypes are the file types models can be quantized to, with the
+// approximate bits per weight of a quantized llama model
+var quantizationTypes = []struct {
+	fileType      fileType
+	bitsPerWeight float64
+}{
+	{fileTypeF32, 32},
+	{fileTypeF16, 16},
+	{fileTypeBF16, 16},
+	{fileTypeQ8_0, 8.5},
+	{fileTypeQ6_K, 6.59},
+	{fileTypeQ5_1, 6},
+	{fileTypeQ5_K_M, 5.69},
+	{fileTypeQ5_K_S, 5.54},
+	{fileTypeQ5_0, 5.5},
+	{fileTypeQ4_1, 5},
+	{fileTypeQ4_K_M, 4.89},
+	{fileTypeQ4_K_S, 4.58},
+	{fileTypeQ4_0, 4.5},
+	{fileTypeIQ4_NL, 4.5},
+	{fileTypeQ3_K_L, 4.27},
+	{fileTypeIQ4_XS, 4.25},
+	{fileTypeQ3_K_M, 3.91},
+	{fileTypeIQ3_M, 3.66},
+	{fileTypeQ3_K_S, 3.5},
+	{fileTypeIQ3_S, 3.44},
+	{fileTypeQ2_K, 3.35},
+	{fileTypeIQ3_XS, 3.3},
+	{fileTypeIQ3_XXS, 3.06},
+	{fileTypeQ2_K_S, 2.97},
+	{fileTypeIQ2_M, 2.7},
+	{fileTypeIQ2_S, 2.5},
+	{fileTypeIQ2_XS, 2.31},
+	{fileTypeIQ2_XXS, 2.06},
+	{fileTypeIQ1_M, 1.75},
+	{fileTypeIQ1_S, 1.56},
+}
+
+// QuantizationTypes returns the names of the types models can be quantized to,
+// from the largest to the smallest
+func QuantizationTypes() []string {
+	names := make([]string, len(quantizationTypes))
+	for i, q := range quantizationTypes {
+		names[i] = q.fileType.String()
+	}
+
+	return names
+}
+
+// ParseQuantizationType parses s, in any case, as a type models can be
+// quantized to
+func ParseQuantizationType(s string) (fileType, error) {
+	t, err := ParseFileType(strings.ToUpper(s))
+	if err == nil && t.BitsPerWeight() > 0 {
+		return t, nil
+	}
+
+	return fileTypeUnknown, fmt.Errorf("unsupported quantization type %s, supported types are %s", s, strings.Join(QuantizationTypes(), ", "))
+}
+
+// BitsPerWeight is the approximate bits per weight of a model quantized to t,
+// or 0 if models can't be quantized to t
+func (t fileType) BitsPerWeight() float64 {
+	for _, q := range quantizationTypes {
+		if q.fileType == t {
+			return q.bitsPerWeight
+		}
+	}
+
+	return 0
+}
+
+// EstimateSize is the approximate size in bytes of a model with parameters
+// parameters quantized to t
+func (t fileType) EstimateSize(parameters uint64) uint64 {
+	return uint64(float64(parameters) * t.BitsPerWeight() / 8)
+}
+
+// RequiresImatrix reports whether quantizing to t requires an importance
+// matrix, without which the quality of the model is unusable
+func (t fileType) RequiresImatrix() bool {
+	switch t {
+	case fileTypeIQ1_S, fileTypeIQ1_M, fileTypeIQ2_XXS, fileTypeIQ2_XS, fileTypeIQ2_S, fileTypeQ2_K_S:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,94 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQuantizationType(t *testing.T) {
+	cases := map[string]fileType{
+		"q4_0":   fileTypeQ4_0,
+		"Q4_K_M": fileTypeQ4_K_M,
+		"q4_K_M": fileTypeQ4_K_M,
+		"q6_k":   fileTypeQ6_K,
+		"iq4_xs": fileTypeIQ4_XS,
+		"IQ2_M":  fileTypeIQ2_M,
+		"iq3_m":  fileTypeIQ3_M,
+		"bf16":   fileTypeBF16,
+	}
+
+	for s, expect := range cases {
+		ft, err := ParseQuantizationType(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+
+		if ft != expect {
+			t.Errorf("%s: expected %s, actual %s", s, expect, ft)
+		}
+	}
+
+	for _, s := range []string{"", "q4_k_x", "Q4_1_F16", "unknown"} {
+		if _, err := ParseQuantizationType(s); err == nil || !strings.Contains(err.Error(), "Q4_K_M") {
+			t.Errorf("%q: expected an error listing supported types, actual %v", s, err)
+		}
+	}
+}
+
+func TestFileTypeValues(t *testing.T) {
+	// these are llama.cpp's llama_ftype values, which are also general.file_type
+	cases := map[fileType]uint32{
+		fileTypeQ6_K:   18,
+		fileTypeIQ3_S:  26,
+		fileTypeIQ3_M:  27,
+		fileTypeIQ2_S:  28,
+		fileTypeIQ2_M:  29,
+		fileTypeIQ4_XS: 30,
+		fileTypeIQ1_M:  31,
+		fileTypeBF16:   32,
+	}
+
+	for ft, expect := range cases {
+		if ft.Value() != expect {
+			t.Errorf("%s: expected %d, actual %d", ft, expect, ft.Value())
+		}
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	const parameters = 8_000_000_000
+
+	if size := fileTypeF16.EstimateSize(parameters); size != 16_000_000_000 {
+		t.Errorf("F16: expected 16000000000, actual %d", size)
+	}
+
+	// every type is smaller than the one listed before it
+	var last uint64
+	for i, name := range QuantizationTypes() {
+		ft, err := ParseQuantizationType(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := ft.EstimateSize(parameters)
+		if size == 0 || (i > 0 && size > last) {
+			t.Errorf("%s: unexpected size %d after %d", name, size, last)
+		}
+
+		last = size
+	}
+
+	if size := fileTypeQ4_1_F16.EstimateSize(parameters); size != 0 {
+		t.Errorf("Q4_1_F16: expected 0, actual %d", size)
+	}
+}
+
+func TestKVFileType(t *testing.T) {
+	if ft := (KV{"general.file_type": uint32(0)}).FileType(); ft != fileTypeF32 {
+		t.Errorf("expected F32, actual %s", ft)
+	}
+
+	if ft := (KV{}).FileType(); ft != fileTypeUnknown {
+		t.Errorf("expected unknown, actual %s", ft)
+	}
+}
@@ -31,7 +31,8 @@ function init_vars {
     $script:cmakeDefs = @(
         "-DBUILD_SHARED_LIBS=on",
         "-DGGML_NATIVE=off",
-        "-DGGML_OPENMP=off"
+        "-DGGML_OPENMP=off",
+        "-DGGML_RPC=on"
         )
     $script:commonCpuDefs = @("-DCMAKE_POSITION_INDEPENDENT_CODE=on")
     $script:ARCH = $Env:PROCESSOR_ARCHITECTURE.ToLower()
@@ -176,6 +177,7 @@ function cleanup {
 # -DGGML_AVX -- 2011 Intel Sandy Bridge & AMD Bulldozer
 # -DGGML_AVX2 -- 2013 Intel Haswell & 2015 AMD Excavator / 2017 AMD Zen
 # -DGGML_FMA (FMA3) -- 2013 Intel Haswell & 2012 AMD Piledriver
+# -DGGML_AVX512 -- 2017 Intel Skylake-X and Xeon & 2022 AMD Zen 4
 
 
 function build_static() {
@@ -257,6 +259,21 @@ function build_cpu_avx2() {
     }
 }
 
+function build_cpu_avx512() {
+    if ((-not "${env:OLLAMA_SKIP_CPU_GENERATE}" ) -and ((-not "${env:OLLAMA_CPU_TARGET}") -or ("${env:OLLAMA_CPU_TARGET}" -eq "cpu_avx512"))) {
+        init_vars
+        $script:cmakeDefs = $script:commonCpuDefs + @("-A", "x64", "-DGGML_AVX=on", "-DGGML_AVX2=on", "-DGGML_AVX512=on", "-DGGML_AVX512_VBMI=off", "-DGGML_AVX512_VNNI=off", "-DGGML_FMA=on", "-DGGML_F16C=on") + $script:cmakeDefs
+        $script:buildDir="../build/windows/${script:ARCH}/cpu_avx512"
+        $script:distDir="$script:DIST_BASE\cpu_avx512"
+        write-host "Building AVX512 CPU"
+        build
+        sign
+        install
+    } else {
+        write-host "Skipping CPU AVX512 generation step as requested"
+    }
+}
+
 function build_cuda() {
     if ((-not "${env:OLLAMA_SKIP_CUDA_GENERATE}") -and ("${script:CUDA_LIB_DIR}")) {
         # Then build cuda as a dynamically loaded library
@@ -408,6 +425,7 @@ if ($($args.count) -eq 0) {
         build_cpu("x64")
         build_cpu_avx
         build_cpu_avx2
+        build_cpu_avx512
         build_cuda
         build_oneapi
         build_rocm
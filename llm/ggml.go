@@ -43,13 +43,24 @@ func (kv KV) Architecture() string {
 	return "unknown"
 }
 
+// Kind is the general.type of the file, e.g. "model" or "adapter"
+func (kv KV) Kind() string {
+	if s, ok := kv["general.type"].(string); ok {
+		return s
+	}
+
+	return "unknown"
+}
+
 func (kv KV) ParameterCount() uint64 {
 	return kv.u64("general.parameter_count")
 }
 
+// FileType is the type most of the model's weights are stored as. F32 is 0,
+// so the key being missing rather than its value tells it's unknown.
 func (kv KV) FileType() fileType {
-	if u64 := kv.u64("general.file_type"); u64 > 0 {
-		return fileType(uint32(u64))
+	if _, ok := kv["general.file_type"]; ok {
+		return fileType(uint32(kv.u64("general.file_type")))
 	}
 
 	return fileTypeUnknown
@@ -112,6 +123,23 @@ func (kv KV) ChatTemplate() string {
 	return s
 }
 
+// Token returns the text of the token with the ID of key, such as
+// tokenizer.ggml.eos_token_id, if the model's tokens were decoded
+func (kv KV) Token(key string) (string, bool) {
+	id, ok := kv[key].(uint32)
+	if !ok {
+		return "", false
+	}
+
+	tokens, ok := kv["tokenizer.ggml.tokens"].(*array)
+	if !ok || int(id) >= len(tokens.values) {
+		return "", false
+	}
+
+	s, ok := tokens.values[id].(string)
+	return s, ok
+}
+
 type Tensors struct {
 	Items  []*Tensor
 	Offset uint64
@@ -236,6 +264,8 @@ func (t Tensor) typeSize() uint64 {
 		return 8
 	case 29: // IQ1_M
 		return blockSize/8 + blockSize/16 + blockSize/32
+	case 30: // BF16
+		return 2
 	default:
 		return 0
 	}
@@ -1 +1,52 @@
 package llm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/go-bfloat16"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bf16Tensor(t *testing.T, name string, shape []uint64, values ...float32) Tensor {
+	t.Helper()
+
+	return Tensor{Name: name, Kind: 30, Shape: shape, WriterTo: bytes.NewBuffer(bfloat16.EncodeFloat32(values))}
+}
+
+func TestWriteGGUFBF16(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, WriteGGUF(f, KV{"general.architecture": "llama"}, []Tensor{
+		bf16Tensor(t, "blk.0.attn_q.weight", []uint64{2, 3}, 1, 2, 3, 4, 5, 6),
+		bf16Tensor(t, "output.weight", []uint64{2}, 7, 8),
+	}))
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	ggml, _, err := DecodeGGML(f, -1)
+	require.NoError(t, err)
+
+	tensors := ggml.Tensors()
+	expect := map[string][]float32{
+		"blk.0.attn_q.weight": {1, 2, 3, 4, 5, 6},
+		"output.weight":       {7, 8},
+	}
+
+	require.Len(t, tensors.Items, len(expect))
+	for _, tensor := range tensors.Items {
+		assert.Equal(t, uint64(len(expect[tensor.Name])*2), tensor.Size(), tensor.Name)
+
+		bts := make([]byte, tensor.Size())
+		_, err := f.ReadAt(bts, int64(tensors.Offset+tensor.Offset))
+		require.NoError(t, err)
+		assert.Equal(t, expect[tensor.Name], bfloat16.DecodeFloat32(bts), tensor.Name)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"cmp"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -357,6 +358,8 @@ func writeGGUFString(w io.Writer, s string) error {
 }
 
 type array struct {
+	// t is the gguf type of the values
+	t      uint32
 	size   int
 	values []any
 }
@@ -376,7 +379,7 @@ func readGGUFV1Array(llm *gguf, r io.Reader) (*array, error) {
 		return nil, err
 	}
 
-	a := &array{size: int(n)}
+	a := &array{t: t, size: int(n)}
 	if llm.canCollectArray(int(n)) {
 		a.values = make([]any, 0, int(n))
 	}
@@ -438,7 +441,7 @@ func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
 		return nil, err
 	}
 
-	a := &array{size: int(n)}
+	a := &array{t: t, size: int(n)}
 	if llm.canCollectArray(int(n)) {
 		a.values = make([]any, int(n))
 	}
@@ -506,7 +509,19 @@ func writeGGUFArray[S ~[]E, E any](w io.Writer, t uint32, s S) error {
 	return binary.Write(w, binary.LittleEndian, s)
 }
 
-func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
+// TensorWriter is an io.Writer which WriteGGUF tells the name of each tensor
+// before its data is written, along with the size of the whole file, so the
+// progress of a conversion can be reported
+type TensorWriter interface {
+	io.Writer
+	StartTensor(name string, total int64)
+}
+
+// WriteGGUF writes a GGUF file of kv and ts to w, which can be a stream as the
+// tensors' offsets are counted rather than seeked. Tensors are aligned to
+// general.alignment, or 32 if kv doesn't have it.
+func WriteGGUF(w io.Writer, kv KV, ts []Tensor) error {
+	ws := &countWriter{w: w}
 	if err := binary.Write(ws, binary.LittleEndian, []byte("GGUF")); err != nil {
 		return err
 	}
@@ -542,17 +557,36 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 		}
 	})
 
-	var s uint64
+	// the tensors are aligned as the decoder expects, to general.alignment
+	// if kv has it
+	var alignment int64 = 32
+	if a, ok := kv["general.alignment"].(uint32); ok {
+		if a == 0 {
+			return errors.New("general.alignment must be greater than 0")
+		}
+
+		alignment = int64(a)
+	}
+
+	// end is the size of the tensor data, which isn't padded after the last
+	var s, end uint64
 	for _, t := range ts {
 		t.Offset = s
 		if err := ggufWriteTensorInfo(ws, t); err != nil {
 			return err
 		}
 		s += t.Size()
+		end = s
+		s += uint64(ggufPadding(int64(s), alignment))
 	}
 
-	var alignment int64 = 32
+	tw, _ := w.(TensorWriter)
+	total := ws.n + ggufPadding(ws.n, alignment) + int64(end)
 	for _, t := range ts {
+		if tw != nil {
+			tw.StartTensor(t.Name, total)
+		}
+
 		if err := ggufWriteTensor(ws, t, alignment); err != nil {
 			return err
 		}
@@ -561,7 +595,7 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 	return nil
 }
 
-func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
+func ggufWriteKV(ws io.Writer, k string, v any) error {
 	slog.Debug(k, "type", fmt.Sprintf("%T", v))
 	if err := binary.Write(ws, binary.LittleEndian, uint64(len(k))); err != nil {
 		return err
@@ -573,10 +607,26 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 
 	var err error
 	switch v := v.(type) {
+	case uint8:
+		err = writeGGUF(ws, ggufTypeUint8, v)
+	case int8:
+		err = writeGGUF(ws, ggufTypeInt8, v)
+	case uint16:
+		err = writeGGUF(ws, ggufTypeUint16, v)
+	case int16:
+		err = writeGGUF(ws, ggufTypeInt16, v)
 	case uint32:
 		err = writeGGUF(ws, ggufTypeUint32, v)
+	case int32:
+		err = writeGGUF(ws, ggufTypeInt32, v)
+	case uint64:
+		err = writeGGUF(ws, ggufTypeUint64, v)
+	case int64:
+		err = writeGGUF(ws, ggufTypeInt64, v)
 	case float32:
 		err = writeGGUF(ws, ggufTypeFloat32, v)
+	case float64:
+		err = writeGGUF(ws, ggufTypeFloat64, v)
 	case bool:
 		err = writeGGUF(ws, ggufTypeBool, v)
 	case string:
@@ -609,6 +659,8 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 				return err
 			}
 		}
+	case *array:
+		err = writeGGUFDecodedArray(ws, v)
 	default:
 		return fmt.Errorf("improper type for '%s'", k)
 	}
@@ -616,7 +668,43 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 	return err
 }
 
-func ggufWriteTensorInfo(ws io.WriteSeeker, t Tensor) error {
+// writeGGUFDecodedArray writes an array decoded from a gguf file. Its values
+// must have been collected.
+func writeGGUFDecodedArray(w io.Writer, a *array) error {
+	if len(a.values) != a.size {
+		return errors.New("array values weren't decoded")
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, ggufTypeArray); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, a.t); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint64(a.size)); err != nil {
+		return err
+	}
+
+	for _, e := range a.values {
+		if s, ok := e.(string); ok {
+			if err := binary.Write(w, binary.LittleEndian, uint64(len(s))); err != nil {
+				return err
+			}
+
+			if _, err := io.WriteString(w, s); err != nil {
+				return err
+			}
+		} else if err := binary.Write(w, binary.LittleEndian, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ggufWriteTensorInfo(ws io.Writer, t Tensor) error {
 	slog.Debug(t.Name, "kind", t.Kind, "shape", t.Shape, "offset", t.Offset)
 	if err := binary.Write(ws, binary.LittleEndian, uint64(len(t.Name))); err != nil {
 		return err
@@ -643,20 +731,27 @@ func ggufWriteTensorInfo(ws io.WriteSeeker, t Tensor) error {
 	return binary.Write(ws, binary.LittleEndian, t.Offset)
 }
 
-func ggufWriteTensor(ws io.WriteSeeker, t Tensor, alignment int64) error {
-	offset, err := ws.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return err
-	}
-
-	if err := binary.Write(ws, binary.LittleEndian, bytes.Repeat([]byte{0}, int(ggufPadding(offset, alignment)))); err != nil {
+func ggufWriteTensor(ws *countWriter, t Tensor, alignment int64) error {
+	if err := binary.Write(ws, binary.LittleEndian, bytes.Repeat([]byte{0}, int(ggufPadding(ws.n, alignment)))); err != nil {
 		return err
 	}
 
-	_, err = t.WriteTo(ws)
+	_, err := t.WriteTo(ws)
 	return err
 }
 
+// countWriter counts the bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
 func ggufPadding(offset, align int64) int64 {
 	return (align - offset%align) % align
 }
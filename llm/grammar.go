@@ -0,0 +1,703 @@
+package llm
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidFormat = errors.New(`format must be empty, "json" or a JSON Schema`)
+
+// schemaGrammars caches grammars compiled from JSON Schemas by schema hash,
+// evicting the least recently used grammar when full
+var schemaGrammars = struct {
+	mu sync.Mutex
+	l  *list.List
+	m  map[[sha256.Size]byte]*list.Element
+}{l: list.New(), m: make(map[[sha256.Size]byte]*list.Element)}
+
+const maxSchemaGrammars = 256
+
+type schemaGrammar struct {
+	key     [sha256.Size]byte
+	grammar string
+}
+
+// FormatGrammar returns the grammar constraining a response to format, which
+// is empty, "json" or the text of a JSON Schema. Grammars compiled from
+// schemas are cached.
+func FormatGrammar(format string) (string, error) {
+	switch {
+	case format == "":
+		return "", nil
+	case format == "json":
+		return jsonGrammar, nil
+	case !strings.HasPrefix(strings.TrimSpace(format), "{"):
+		return "", ErrInvalidFormat
+	}
+
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(format)); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidFormat, err)
+	}
+
+	key := sha256.Sum256(b.Bytes())
+
+	schemaGrammars.mu.Lock()
+	e, ok := schemaGrammars.m[key]
+	if ok {
+		schemaGrammars.l.MoveToFront(e)
+	}
+	schemaGrammars.mu.Unlock()
+	if ok {
+		return e.Value.(*schemaGrammar).grammar, nil
+	}
+
+	grammar, err := schemaToGrammar(b.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidFormat, err)
+	}
+
+	schemaGrammars.mu.Lock()
+	defer schemaGrammars.mu.Unlock()
+	if _, ok := schemaGrammars.m[key]; !ok {
+		if schemaGrammars.l.Len() >= maxSchemaGrammars {
+			oldest := schemaGrammars.l.Back()
+			schemaGrammars.l.Remove(oldest)
+			delete(schemaGrammars.m, oldest.Value.(*schemaGrammar).key)
+		}
+		schemaGrammars.m[key] = schemaGrammars.l.PushFront(&schemaGrammar{key: key, grammar: grammar})
+	}
+
+	return grammar, nil
+}
+
+// schemaObject is a JSON object which remembers the order of its keys so
+// properties are generated in the order the schema declares them
+type schemaObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *schemaObject) get(key string) (any, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+func (o *schemaObject) string(key string) string {
+	s, _ := o.values[key].(string)
+	return s
+}
+
+func (o *schemaObject) int(key string, fallback int) int {
+	if f, ok := o.values[key].(float64); ok {
+		return int(f)
+	}
+
+	return fallback
+}
+
+// decodeSchema decodes JSON like json.Unmarshal into an any, except objects
+// are decoded to *schemaObject
+func decodeSchema(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		o := &schemaObject{values: make(map[string]any)}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			key := t.(string)
+			v, err := decodeSchema(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := o.values[key]; !ok {
+				o.keys = append(o.keys, key)
+			}
+			o.values[key] = v
+		}
+
+		// consume '}'
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return o, nil
+	case json.Delim('['):
+		a := make([]any, 0)
+		for dec.More() {
+			v, err := decodeSchema(dec)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+
+		// consume ']'
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	default:
+		return t, nil
+	}
+}
+
+// schemaPrimitives are the rules of JSON values and the rules they depend on
+var schemaPrimitives = map[string]struct {
+	rule string
+	deps []string
+}{
+	"boolean":       {`("true" | "false") space`, nil},
+	"null":          {`"null" space`, nil},
+	"integral-part": {`[0] | [1-9] [0-9]*`, nil},
+	"decimal-part":  {`[0-9]+`, nil},
+	"number":        {`("-"? integral-part) ("." decimal-part)? ([eE] [-+]? integral-part)? space`, []string{"integral-part", "decimal-part"}},
+	"integer":       {`("-"? integral-part) space`, []string{"integral-part"}},
+	"char":          {`[^"\\\x7F\x00-\x1F] | [\\] (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])`, nil},
+	"string":        {`"\"" char* "\"" space`, []string{"char"}},
+	"value":         {`object | array | string | number | boolean | null`, []string{"object", "array", "string", "number", "boolean", "null"}},
+	"object":        {`"{" space ( string ":" space value ("," space string ":" space value)* )? "}" space`, []string{"string", "value"}},
+	"array":         {`"[" space ( value ("," space value)* )? "]" space`, []string{"value"}},
+}
+
+var invalidRuleChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// schemaConverter converts a JSON Schema to a GBNF grammar. Each object
+// property, array item and alternative becomes a rule named after its path
+// in the schema.
+type schemaConverter struct {
+	root  any
+	rules map[string]string
+	order []string
+	refs  map[string]string
+}
+
+func schemaToGrammar(schema []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(schema))
+	dec.UseNumber()
+
+	root, err := decodeSchema(dec)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return "", errors.New("unexpected data after schema")
+	}
+
+	root = normalizeNumbers(root)
+
+	c := &schemaConverter{root: root, rules: make(map[string]string), refs: make(map[string]string)}
+	c.rules["space"] = `" "?`
+
+	rule, err := c.visit(root, "root")
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "root ::= %s\n", rule)
+	for _, name := range c.order {
+		fmt.Fprintf(&b, "%s ::= %s\n", name, c.rules[name])
+	}
+	fmt.Fprintf(&b, "space ::= %s\n", c.rules["space"])
+	return b.String(), nil
+}
+
+// normalizeNumbers converts json.Number values to float64 so schema values
+// compare like those from json.Unmarshal
+func normalizeNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return f
+	case *schemaObject:
+		for k, e := range v.values {
+			v.values[k] = normalizeNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = normalizeNumbers(e)
+		}
+	}
+
+	return v
+}
+
+// addRule adds a rule named after name and returns the name it was given.
+// Identical rules share a name.
+func (c *schemaConverter) addRule(name, rule string) string {
+	name = strings.Trim(invalidRuleChars.ReplaceAllString(name, "-"), "-")
+	if name == "" {
+		name = "rule"
+	}
+
+	unique := name
+	for i := 0; ; i++ {
+		existing, ok := c.rules[unique]
+		if !ok {
+			break
+		} else if existing == rule {
+			return unique
+		}
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+
+	c.rules[unique] = rule
+	c.order = append(c.order, unique)
+	return unique
+}
+
+// reserve adds an empty rule with a name no other rule shares
+func (c *schemaConverter) reserve(name string) string {
+	name = strings.Trim(invalidRuleChars.ReplaceAllString(name, "-"), "-")
+	unique := name
+	for i := 0; ; i++ {
+		if _, ok := c.rules[unique]; !ok {
+			break
+		}
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+
+	c.rules[unique] = ""
+	c.order = append(c.order, unique)
+	return unique
+}
+
+// primitive adds the rule of a JSON value and its dependencies
+func (c *schemaConverter) primitive(name string) string {
+	if _, ok := c.rules[name]; ok {
+		return name
+	}
+
+	p := schemaPrimitives[name]
+	c.rules[name] = p.rule
+	c.order = append(c.order, name)
+	for _, dep := range p.deps {
+		c.primitive(dep)
+	}
+
+	return name
+}
+
+// literal returns a grammar string matching the JSON encoding of v
+func literal(v any) (string, error) {
+	encode := func(v any) (string, error) {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	}
+
+	s, err := encode(v)
+	if err != nil {
+		return "", err
+	}
+
+	return encode(s)
+}
+
+// repeat returns a rule matching item min to max times separated by sep. A
+// negative max means no upper bound.
+func repeat(item, sep string, min, max int) string {
+	if max == 0 {
+		return ""
+	}
+
+	next := item
+	if sep != "" {
+		next = fmt.Sprintf("%s %s", sep, item)
+	}
+
+	parts := []string{item}
+	for i := 1; i < min; i++ {
+		parts = append(parts, next)
+	}
+
+	if max < 0 {
+		parts = append(parts, fmt.Sprintf("(%s)*", next))
+	} else if optional := max - len(parts); optional > 0 {
+		var nested string
+		for range optional {
+			nested = fmt.Sprintf("(%s)?", strings.TrimSpace(next+" "+nested))
+		}
+		parts = append(parts, nested)
+	}
+
+	rule := strings.Join(parts, " ")
+	if min == 0 {
+		rule = fmt.Sprintf("(%s)?", rule)
+	}
+
+	return rule
+}
+
+func (c *schemaConverter) visit(schema any, name string) (string, error) {
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			return "", errors.New("false schemas match nothing")
+		}
+		return c.primitive("value"), nil
+	case *schemaObject:
+		return c.visitObject(schema, name)
+	default:
+		return "", fmt.Errorf("invalid schema at %s", name)
+	}
+}
+
+func (c *schemaConverter) visitObject(s *schemaObject, name string) (string, error) {
+	for _, key := range []string{"pattern", "patternProperties"} {
+		if s.has(key) {
+			return "", fmt.Errorf("unsupported keyword %s at %s", key, name)
+		}
+	}
+
+	if ref := s.string("$ref"); ref != "" {
+		return c.resolve(ref)
+	}
+
+	if v, ok := s.get("const"); ok {
+		return c.enum([]any{v})
+	}
+
+	if v, ok := s.get("enum"); ok {
+		values, ok := v.([]any)
+		if !ok || len(values) == 0 {
+			return "", fmt.Errorf("enum at %s must be a non-empty array", name)
+		}
+		return c.enum(values)
+	}
+
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if v, ok := s.get(key); ok {
+			alternatives, ok := v.([]any)
+			if !ok || len(alternatives) == 0 {
+				return "", fmt.Errorf("%s at %s must be a non-empty array", key, name)
+			}
+			return c.alternatives(alternatives, name)
+		}
+	}
+
+	if v, ok := s.get("allOf"); ok {
+		merged, err := c.merge(v, name)
+		if err != nil {
+			return "", err
+		}
+		return c.visitObject(merged, name)
+	}
+
+	switch t := s.values["type"].(type) {
+	case []any:
+		alternatives := make([]any, len(t))
+		for i, t := range t {
+			alternative := &schemaObject{keys: s.keys, values: make(map[string]any, len(s.values))}
+			for k, v := range s.values {
+				alternative.values[k] = v
+			}
+			alternative.values["type"] = t
+			alternatives[i] = alternative
+		}
+		return c.alternatives(alternatives, name)
+	case string:
+		switch t {
+		case "object":
+			return c.object(s, name)
+		case "array":
+			return c.array(s, name)
+		case "string":
+			return c.string(s, name)
+		case "number", "integer", "boolean", "null":
+			return c.primitive(t), nil
+		default:
+			return "", fmt.Errorf("unsupported type %q at %s", t, name)
+		}
+	case nil:
+		switch {
+		case slices.ContainsFunc([]string{"properties", "additionalProperties", "required"}, s.has):
+			return c.object(s, name)
+		case slices.ContainsFunc([]string{"items", "prefixItems"}, s.has):
+			return c.array(s, name)
+		}
+		return c.primitive("value"), nil
+	default:
+		return "", fmt.Errorf("invalid type at %s", name)
+	}
+}
+
+func (o *schemaObject) has(key string) bool {
+	_, ok := o.values[key]
+	return ok
+}
+
+// resolve returns the rule of a local reference such as #/$defs/node. The
+// rule is named before its schema is visited so schemas can be recursive.
+func (c *schemaConverter) resolve(ref string) (string, error) {
+	if name, ok := c.refs[ref]; ok {
+		return name, nil
+	}
+
+	target, err := c.lookup(ref)
+	if err != nil {
+		return "", err
+	}
+
+	name := c.reserve("ref-" + ref[strings.LastIndex(ref, "/")+1:])
+	c.refs[ref] = name
+
+	rule, err := c.visit(target, name)
+	if err != nil {
+		return "", err
+	}
+
+	c.rules[name] = rule
+	return name, nil
+}
+
+func (c *schemaConverter) enum(values []any) (string, error) {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		l, err := literal(v)
+		if err != nil {
+			return "", err
+		}
+		literals[i] = l
+	}
+
+	return fmt.Sprintf("(%s) space", strings.Join(literals, " | ")), nil
+}
+
+func (c *schemaConverter) alternatives(alternatives []any, name string) (string, error) {
+	rules := make([]string, len(alternatives))
+	for i, alternative := range alternatives {
+		rule, err := c.visit(alternative, fmt.Sprintf("%s-%d", name, i))
+		if err != nil {
+			return "", err
+		}
+		rules[i] = c.addRule(fmt.Sprintf("%s-%d", name, i), rule)
+	}
+
+	return strings.Join(rules, " | "), nil
+}
+
+// merge combines the object schemas of allOf into one schema
+func (c *schemaConverter) merge(v any, name string) (*schemaObject, error) {
+	parts, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("allOf at %s must be an array", name)
+	}
+
+	properties := &schemaObject{values: make(map[string]any)}
+	var required []any
+	for _, part := range parts {
+		o, ok := part.(*schemaObject)
+		if !ok {
+			return nil, fmt.Errorf("invalid schema in allOf at %s", name)
+		}
+
+		// follow references to the schemas being combined
+		for seen := 0; o.string("$ref") != ""; seen++ {
+			if seen > 32 {
+				return nil, fmt.Errorf("reference cycle in allOf at %s", name)
+			}
+
+			target, err := c.lookup(o.string("$ref"))
+			if err != nil {
+				return nil, err
+			}
+
+			if o, ok = target.(*schemaObject); !ok {
+				return nil, fmt.Errorf("invalid schema in allOf at %s", name)
+			}
+		}
+
+		if t := o.string("type"); t != "" && t != "object" {
+			return nil, fmt.Errorf("allOf at %s only supports object schemas", name)
+		}
+
+		if p, ok := o.values["properties"].(*schemaObject); ok {
+			for _, k := range p.keys {
+				if _, ok := properties.values[k]; !ok {
+					properties.keys = append(properties.keys, k)
+				}
+				properties.values[k] = p.values[k]
+			}
+		}
+
+		if r, ok := o.values["required"].([]any); ok {
+			required = append(required, r...)
+		}
+	}
+
+	return &schemaObject{
+		keys:   []string{"type", "properties", "required"},
+		values: map[string]any{"type": "object", "properties": properties, "required": required},
+	}, nil
+}
+
+// lookup returns the schema a local reference such as #/$defs/node points to
+func (c *schemaConverter) lookup(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference %q: only local references are supported", ref)
+	}
+
+	target := c.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref[1:], "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		o, ok := target.(*schemaObject)
+		if !ok {
+			return nil, fmt.Errorf("unresolved reference %q", ref)
+		}
+
+		if target, ok = o.get(strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")); !ok {
+			return nil, fmt.Errorf("unresolved reference %q", ref)
+		}
+	}
+
+	return target, nil
+}
+
+func (c *schemaConverter) object(s *schemaObject, name string) (string, error) {
+	properties, _ := s.values["properties"].(*schemaObject)
+	if properties == nil || len(properties.keys) == 0 {
+		additional, ok := s.values["additionalProperties"].(*schemaObject)
+		if !ok {
+			return c.primitive("object"), nil
+		}
+
+		// an object with arbitrary keys and values matching a schema
+		value, err := c.visit(additional, name+"-value")
+		if err != nil {
+			return "", err
+		}
+
+		kv := c.addRule(name+"-kv", fmt.Sprintf(`%s ":" space %s`, c.primitive("string"), c.addRule(name+"-value", value)))
+		return fmt.Sprintf(`"{" space %s "}" space`, repeat(kv, `"," space`, 0, -1)), nil
+	}
+
+	required := make(map[string]bool)
+	if r, ok := s.values["required"].([]any); ok {
+		for _, k := range r {
+			if k, ok := k.(string); ok {
+				required[k] = true
+			}
+		}
+	}
+
+	var requiredKVs, optionalKVs []string
+	for _, k := range properties.keys {
+		value, err := c.visit(properties.values[k], name+"-"+k)
+		if err != nil {
+			return "", err
+		}
+
+		key, err := literal(k)
+		if err != nil {
+			return "", err
+		}
+
+		kv := c.addRule(name+"-"+k+"-kv", fmt.Sprintf(`%s space ":" space %s`, key, c.addRule(name+"-"+k, value)))
+		if required[k] {
+			requiredKVs = append(requiredKVs, kv)
+		} else {
+			optionalKVs = append(optionalKVs, kv)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`"{" space `)
+	b.WriteString(strings.Join(requiredKVs, ` "," space `))
+
+	if len(optionalKVs) > 0 {
+		// rest matches any ordered subset of the optional properties,
+		// starting with the i-th
+		var rest func(i int, first bool) string
+		rest = func(i int, first bool) string {
+			kv := optionalKVs[i]
+			if !first {
+				kv = fmt.Sprintf(`( "," space %s )?`, kv)
+			}
+
+			if i+1 < len(optionalKVs) {
+				kv += " " + c.addRule(fmt.Sprintf("%s-rest%d", name, i+1), rest(i+1, false))
+			}
+			return kv
+		}
+
+		alternatives := make([]string, len(optionalKVs))
+		for i := range optionalKVs {
+			alternatives[i] = rest(i, true)
+		}
+
+		if len(requiredKVs) > 0 {
+			fmt.Fprintf(&b, ` ( "," space ( %s ) )?`, strings.Join(alternatives, " | "))
+		} else {
+			fmt.Fprintf(&b, `( %s )?`, strings.Join(alternatives, " | "))
+		}
+	}
+
+	b.WriteString(` "}" space`)
+	return b.String(), nil
+}
+
+func (c *schemaConverter) array(s *schemaObject, name string) (string, error) {
+	if prefix, ok := s.values["prefixItems"].([]any); ok {
+		items := make([]string, len(prefix))
+		for i, item := range prefix {
+			rule, err := c.visit(item, fmt.Sprintf("%s-%d", name, i))
+			if err != nil {
+				return "", err
+			}
+			items[i] = c.addRule(fmt.Sprintf("%s-%d", name, i), rule)
+		}
+
+		return fmt.Sprintf(`"[" space %s "]" space`, strings.Join(items, ` "," space `)), nil
+	}
+
+	items, ok := s.get("items")
+	if !ok {
+		return c.primitive("array"), nil
+	}
+
+	rule, err := c.visit(items, name+"-item")
+	if err != nil {
+		return "", err
+	}
+
+	item := c.addRule(name+"-item", rule)
+	return fmt.Sprintf(`"[" space %s "]" space`, repeat(item, `"," space`, s.int("minItems", 0), s.int("maxItems", -1))), nil
+}
+
+func (c *schemaConverter) string(s *schemaObject, name string) (string, error) {
+	if !s.has("minLength") && !s.has("maxLength") {
+		return c.primitive("string"), nil
+	}
+
+	return fmt.Sprintf(`"\"" %s "\"" space`, repeat(c.primitive("char"), "", s.int("minLength", 0), s.int("maxLength", -1))), nil
+}
@@ -0,0 +1,179 @@
+package llm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatGrammar(t *testing.T) {
+	grammar, err := FormatGrammar("")
+	require.NoError(t, err)
+	assert.Empty(t, grammar)
+
+	grammar, err = FormatGrammar("json")
+	require.NoError(t, err)
+	assert.Equal(t, jsonGrammar, grammar)
+
+	for _, format := range []string{
+		"xml",
+		"[]",
+		`{"type":`,
+		`{"$ref":"#/$defs/missing"}`,
+		`{"type":"string","pattern":"^a+$"}`,
+		`{"pattern":"^a+$"}`,
+		`{"properties":{"id":{"anyOf":[{"type":"integer"},{"pattern":"^[0-9]+$"}]}}}`,
+		`{"type":"object","patternProperties":{"^x-":{"type":"string"}}}`,
+	} {
+		_, err := FormatGrammar(format)
+		require.ErrorIs(t, err, ErrInvalidFormat, format)
+	}
+}
+
+func TestFormatGrammarCache(t *testing.T) {
+	const schema = `{"type": "object", "properties": {"cached": {"type": "boolean"}}}`
+
+	grammar, err := FormatGrammar(schema)
+	require.NoError(t, err)
+
+	// schemas differing only in whitespace share an entry
+	again, err := FormatGrammar(`{"type":"object","properties":{"cached":{"type":"boolean"}}}`)
+	require.NoError(t, err)
+	assert.Equal(t, grammar, again)
+
+	var b bytes.Buffer
+	require.NoError(t, json.Compact(&b, []byte(schema)))
+
+	schemaGrammars.mu.Lock()
+	e, ok := schemaGrammars.m[sha256.Sum256(b.Bytes())]
+	schemaGrammars.mu.Unlock()
+	require.True(t, ok)
+	assert.Equal(t, grammar, e.Value.(*schemaGrammar).grammar)
+}
+
+func TestFormatGrammarCacheEviction(t *testing.T) {
+	schema := func(i int) string {
+		return fmt.Sprintf(`{"type":"object","properties":{"p%d":{"type":"integer"}}}`, i)
+	}
+
+	for i := range maxSchemaGrammars {
+		_, err := FormatGrammar(schema(i))
+		require.NoError(t, err)
+	}
+
+	// using the oldest schema again keeps it when the next one is added
+	_, err := FormatGrammar(schema(0))
+	require.NoError(t, err)
+	_, err = FormatGrammar(schema(maxSchemaGrammars))
+	require.NoError(t, err)
+
+	cached := func(i int) bool {
+		schemaGrammars.mu.Lock()
+		defer schemaGrammars.mu.Unlock()
+		_, ok := schemaGrammars.m[sha256.Sum256([]byte(schema(i)))]
+		return ok
+	}
+
+	assert.True(t, cached(0))
+	assert.False(t, cached(1))
+	assert.True(t, cached(maxSchemaGrammars))
+
+	schemaGrammars.mu.Lock()
+	defer schemaGrammars.mu.Unlock()
+	assert.Equal(t, maxSchemaGrammars, schemaGrammars.l.Len())
+	assert.Len(t, schemaGrammars.m, maxSchemaGrammars)
+}
+
+func TestSchemaToGrammar(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		expect string
+	}{
+		{
+			name:   "object",
+			schema: `{"type":"object","properties":{"name":{"type":"string"},"age":{"type":"integer"},"kind":{"enum":["a","b"]}},"required":["name","age"]}`,
+			expect: `root ::= "{" space root-name-kv "," space root-age-kv ( "," space ( root-kind-kv ) )? "}" space
+string ::= "\"" char* "\"" space
+char ::= [^"\\\x7F\x00-\x1F] | [\\] (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])
+root-name ::= string
+root-name-kv ::= "\"name\"" space ":" space root-name
+integer ::= ("-"? integral-part) space
+integral-part ::= [0] | [1-9] [0-9]*
+root-age ::= integer
+root-age-kv ::= "\"age\"" space ":" space root-age
+root-kind ::= ("\"a\"" | "\"b\"") space
+root-kind-kv ::= "\"kind\"" space ":" space root-kind
+space ::= " "?
+`,
+		},
+		{
+			name:   "optional properties",
+			schema: `{"properties":{"a":{"type":"boolean"},"b":{"type":"null"}}}`,
+			expect: `root ::= "{" space ( root-a-kv root-rest1 | root-b-kv )? "}" space
+boolean ::= ("true" | "false") space
+root-a ::= boolean
+root-a-kv ::= "\"a\"" space ":" space root-a
+null ::= "null" space
+root-b ::= null
+root-b-kv ::= "\"b\"" space ":" space root-b
+root-rest1 ::= ( "," space root-b-kv )?
+space ::= " "?
+`,
+		},
+		{
+			name:   "array bounds",
+			schema: `{"type":"array","items":{"type":"boolean"},"minItems":1,"maxItems":3}`,
+			expect: `root ::= "[" space root-item ("," space root-item ("," space root-item)?)? "]" space
+boolean ::= ("true" | "false") space
+root-item ::= boolean
+space ::= " "?
+`,
+		},
+		{
+			name:   "recursive reference",
+			schema: `{"$defs":{"node":{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#/$defs/node"}}},"required":["children"]}},"$ref":"#/$defs/node"}`,
+			expect: `root ::= ref-node
+ref-node ::= "{" space ref-node-children-kv "}" space
+ref-node-children-item ::= ref-node
+ref-node-children ::= "[" space (ref-node-children-item ("," space ref-node-children-item)*)? "]" space
+ref-node-children-kv ::= "\"children\"" space ":" space ref-node-children
+space ::= " "?
+`,
+		},
+		{
+			name:   "nullable",
+			schema: `{"type":["integer","null"]}`,
+			expect: `root ::= root-0 | root-1
+integer ::= ("-"? integral-part) space
+integral-part ::= [0] | [1-9] [0-9]*
+root-0 ::= integer
+null ::= "null" space
+root-1 ::= null
+space ::= " "?
+`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			grammar, err := schemaToGrammar([]byte(tt.schema))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, grammar)
+		})
+	}
+}
+
+func TestSchemaToGrammarAllOf(t *testing.T) {
+	grammar, err := schemaToGrammar([]byte(`{
+		"$defs": {"named": {"properties": {"name": {"type": "string"}}, "required": ["name"]}},
+		"allOf": [{"$ref": "#/$defs/named"}, {"properties": {"id": {"type": "integer"}}, "required": ["id"]}]
+	}`))
+	require.NoError(t, err)
+	assert.Contains(t, grammar, `root ::= "{" space root-name-kv "," space root-id-kv "}" space`)
+}
@@ -0,0 +1,167 @@
+package llm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+)
+
+// Strategies for fitting images to [api.Options.ImageMaxResolution], see
+// [api.Options.ImageResize]
+const (
+	// ImageResizeFit scales images down, keeping their aspect ratio, so
+	// neither side exceeds the max resolution
+	ImageResizeFit = "fit"
+	// ImageResizeCrop crops images to a centered square before fitting them
+	ImageResizeCrop = "crop"
+	// ImageResizePad pads images to a square before fitting them
+	ImageResizePad = "pad"
+	// ImageResizeNone passes images to the model unchanged
+	ImageResizeNone = "none"
+)
+
+// DefaultImageTokens is the number of tokens an image is assumed to take when
+// the projector doesn't describe its vision encoder
+const DefaultImageTokens = 768
+
+// imagePadColor is the mean color of CLIP's training images. Padding with it
+// affects the image embedding less than black or white.
+var imagePadColor = color.RGBA{122, 116, 104, 255}
+
+// PreprocessImage resizes an image with the given strategy so neither side
+// exceeds maxResolution, or 0 for no limit. Images which don't need to change
+// are returned as is, others are re-encoded as PNG.
+func PreprocessImage(data []byte, resize string, maxResolution int) ([]byte, error) {
+	if resize == ImageResizeNone || (resize != ImageResizeCrop && resize != ImageResizePad && maxResolution <= 0) {
+		return data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+
+	changed := false
+	switch resize {
+	case ImageResizeCrop:
+		if width != height {
+			side := min(width, height)
+			x, y := bounds.Min.X+(width-side)/2, bounds.Min.Y+(height-side)/2
+			cropped := image.NewRGBA(image.Rect(0, 0, side, side))
+			draw.Draw(cropped, cropped.Bounds(), img, image.Pt(x, y), draw.Src)
+			img, width, height, changed = cropped, side, side, true
+		}
+	case ImageResizePad:
+		if width != height {
+			side := max(width, height)
+			padded := image.NewRGBA(image.Rect(0, 0, side, side))
+			draw.Draw(padded, padded.Bounds(), image.NewUniform(imagePadColor), image.Point{}, draw.Src)
+			offset := image.Pt((side-width)/2, (side-height)/2)
+			draw.Draw(padded, image.Rectangle{offset, offset.Add(image.Pt(width, height))}, img, bounds.Min, draw.Src)
+			img, width, height, changed = padded, side, side, true
+		}
+	}
+
+	if maxResolution > 0 && max(width, height) > maxResolution {
+		scale := float64(maxResolution) / float64(max(width, height))
+		width = max(1, int(float64(width)*scale+0.5))
+		height = max(1, int(float64(height)*scale+0.5))
+		img, changed = downscale(img, width, height), true
+	}
+
+	if !changed {
+		return data, nil
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// downscale resizes img to width x height by averaging the source pixels
+// covered by each destination pixel
+func downscale(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0, y1 := y*src.Rect.Dy()/height, max((y+1)*src.Rect.Dy()/height, y*src.Rect.Dy()/height+1)
+		for x := range width {
+			x0, x1 := x*src.Rect.Dx()/width, max((x+1)*src.Rect.Dx()/width, x*src.Rect.Dx()/width+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// ProjectorImageTokens estimates the number of tokens an image takes from the
+// vision encoder of the projector at path. Encoders which split images into
+// tiles, such as LLaVA 1.6, are assumed to use the most tiles they support.
+func ProjectorImageTokens(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	ggml, _, err := DecodeGGML(f, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	kv := ggml.KV()
+	imageSize, _ := kv["clip.vision.image_size"].(uint32)
+	patchSize, _ := kv["clip.vision.patch_size"].(uint32)
+	if imageSize == 0 || patchSize == 0 {
+		return DefaultImageTokens, nil
+	}
+
+	patches := int(imageSize/patchSize) * int(imageSize/patchSize)
+	switch kv["clip.projector_type"] {
+	case "ldp", "ldpv2":
+		// MobileVLM pools patches 2x2
+		patches /= 4
+	}
+
+	if _, ok := kv["clip.vision.image_grid_pinpoints"]; ok {
+		// a downscaled overview of the image followed by up to 4 tiles
+		patches *= 5
+	}
+
+	return patches, nil
+}
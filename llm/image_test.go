@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, png.Encode(&b, img))
+	return b.Bytes()
+}
+
+func imageSize(t *testing.T, data []byte) (int, int) {
+	t.Helper()
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	return img.Bounds().Dx(), img.Bounds().Dy()
+}
+
+func TestPreprocessImage(t *testing.T) {
+	data := testImage(t, 400, 200)
+
+	cases := []struct {
+		resize        string
+		maxResolution int
+		width, height int
+	}{
+		{"", 0, 400, 200},
+		{"", 100, 100, 50},
+		{ImageResizeFit, 1000, 400, 200},
+		{ImageResizeCrop, 0, 200, 200},
+		{ImageResizeCrop, 100, 100, 100},
+		{ImageResizePad, 0, 400, 400},
+		{ImageResizePad, 100, 100, 100},
+		{ImageResizeNone, 100, 400, 200},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.resize, func(t *testing.T) {
+			out, err := PreprocessImage(data, tt.resize, tt.maxResolution)
+			require.NoError(t, err)
+
+			width, height := imageSize(t, out)
+			assert.Equal(t, tt.width, width)
+			assert.Equal(t, tt.height, height)
+		})
+	}
+}
+
+func TestPreprocessImageUnchanged(t *testing.T) {
+	data := testImage(t, 64, 64)
+
+	out, err := PreprocessImage(data, ImageResizeCrop, 64)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+}
+
+func TestPreprocessImagePad(t *testing.T) {
+	out, err := PreprocessImage(testImage(t, 4, 2), ImageResizePad, 0)
+	require.NoError(t, err)
+
+	img, _, err := image.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, imagePadColor, color.RGBAModel.Convert(img.At(0, 0)))
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, color.RGBAModel.Convert(img.At(0, 1)))
+}
+
+func TestPreprocessImageInvalid(t *testing.T) {
+	_, err := PreprocessImage([]byte("not an image"), ImageResizeFit, 100)
+	assert.Error(t, err)
+
+	// images which don't need to change aren't decoded
+	out, err := PreprocessImage([]byte("not an image"), ImageResizeFit, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("not an image"), out)
+}
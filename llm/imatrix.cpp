@@ -0,0 +1,242 @@
+#include "imatrix.h"
+
+#include <cstdint>
+#include <cstdio>
+#include <cstring>
+#include <fstream>
+#include <mutex>
+#include <string>
+#include <unordered_map>
+#include <vector>
+
+#include "ggml.h"
+#include "ggml-backend.h"
+
+struct imatrix_stats {
+    std::vector<float>   values;
+    std::vector<int32_t> counts;
+    int32_t              ncall = 0;
+};
+
+struct ollama_imatrix {
+    std::unordered_map<std::string, imatrix_stats> stats;
+    std::vector<float>                             src1_data;
+    std::vector<char>                              ids;
+    std::mutex                                     mutex;
+};
+
+// filter_tensor_name strips the backend prefix and copy suffix the scheduler
+// adds to tensor names, e.g. CUDA0#blk.0.attn_q.weight#0
+static std::string filter_tensor_name(const char *name) {
+    const char *p = strchr(name, '#');
+    if (p == nullptr) {
+        return name;
+    }
+
+    p++;
+    const char *q = strchr(p, '#');
+    if (q == nullptr) {
+        return p;
+    }
+
+    return std::string(p, q - p);
+}
+
+struct ollama_imatrix *ollama_imatrix_init(void) {
+    return new ollama_imatrix;
+}
+
+void ollama_imatrix_free(struct ollama_imatrix *imatrix) {
+    delete imatrix;
+}
+
+bool ollama_imatrix_collect(struct ggml_tensor *t, bool ask, void *user_data) {
+    auto *imatrix = (ollama_imatrix *) user_data;
+
+    const struct ggml_tensor *src0 = t->src[0];
+    const struct ggml_tensor *src1 = t->src[1];
+    const std::string name = filter_tensor_name(src0->name);
+
+    // the scheduler asks which tensors the callback wants the data of before
+    // calling it again with them
+    if (ask) {
+        if (t->op == GGML_OP_MUL_MAT_ID) {
+            return true;
+        }
+
+        // small batches, e.g. of the output layer, aren't representative
+        if (t->op != GGML_OP_MUL_MAT || src1->ne[1] < 16 || src1->type != GGML_TYPE_F32) {
+            return false;
+        }
+
+        return name.rfind("blk.", 0) == 0;
+    }
+
+    std::lock_guard<std::mutex> lock(imatrix->mutex);
+
+    // activations on a GPU are copied to the host
+    const bool is_host = ggml_backend_buffer_is_host(src1->buffer);
+    if (!is_host) {
+        imatrix->src1_data.resize(ggml_nelements(src1));
+        ggml_backend_tensor_get(src1, imatrix->src1_data.data(), 0, ggml_nbytes(src1));
+    }
+
+    const char *data = is_host ? (const char *) src1->data : (const char *) imatrix->src1_data.data();
+
+    auto &e = imatrix->stats[name];
+    e.ncall++;
+
+    if (t->op == GGML_OP_MUL_MAT_ID) {
+        // ids holds the experts selected for each token, [n_expert_used, n_tokens],
+        // and src1 the activations, [n_embd, n_expert_used, n_tokens]
+        const struct ggml_tensor *ids = t->src[2];
+        const int64_t n_as  = src0->ne[2];
+        const int64_t n_ids = ids->ne[0];
+
+        imatrix->ids.resize(ggml_nbytes(ids));
+        ggml_backend_tensor_get(ids, imatrix->ids.data(), 0, ggml_nbytes(ids));
+
+        if (e.values.empty()) {
+            e.values.resize(src1->ne[0] * n_as, 0);
+            e.counts.resize(src1->ne[0] * n_as, 0);
+        } else if (e.values.size() != (size_t) (src1->ne[0] * n_as)) {
+            fprintf(stderr, "imatrix: inconsistent size for %s\n", name.c_str());
+            return false;
+        }
+
+        for (int64_t row = 0; row < src1->ne[2]; row++) {
+            for (int64_t idx = 0; idx < n_ids; idx++) {
+                const int32_t ex = *(const int32_t *) (imatrix->ids.data() + row * ids->nb[1] + idx * ids->nb[0]);
+                if (ex < 0 || ex >= n_as) {
+                    continue;
+                }
+
+                const float *x = (const float *) (data + (idx % src1->ne[1]) * src1->nb[1] + row * src1->nb[2]);
+                const int64_t start = ex * src1->ne[0];
+                for (int64_t j = 0; j < src1->ne[0]; j++) {
+                    e.values[start + j] += x[j] * x[j];
+                    e.counts[start + j]++;
+                }
+            }
+        }
+    } else {
+        if (e.values.empty()) {
+            e.values.resize(src1->ne[0], 0);
+            e.counts.resize(src1->ne[0], 0);
+        } else if (e.values.size() != (size_t) src1->ne[0]) {
+            fprintf(stderr, "imatrix: inconsistent size for %s\n", name.c_str());
+            return false;
+        }
+
+        for (int64_t row = 0; row < ggml_nrows(src1); row++) {
+            const float *x = (const float *) (data + row * src1->nb[1]);
+            for (int64_t j = 0; j < src1->ne[0]; j++) {
+                e.values[j] += x[j] * x[j];
+                e.counts[j]++;
+            }
+        }
+    }
+
+    return true;
+}
+
+int ollama_imatrix_save(struct ollama_imatrix *imatrix, const char *path, int n_chunks, const char *dataset) {
+    std::ofstream out(path, std::ios::binary);
+    if (!out) {
+        return 1;
+    }
+
+    std::lock_guard<std::mutex> lock(imatrix->mutex);
+
+    const int32_t n_entries = imatrix->stats.size();
+    out.write((const char *) &n_entries, sizeof(n_entries));
+
+    for (const auto &kv : imatrix->stats) {
+        const int32_t len = kv.first.size();
+        out.write((const char *) &len, sizeof(len));
+        out.write(kv.first.c_str(), len);
+
+        const imatrix_stats &e = kv.second;
+        out.write((const char *) &e.ncall, sizeof(e.ncall));
+
+        const int32_t nval = e.values.size();
+        out.write((const char *) &nval, sizeof(nval));
+
+        // values are written scaled by the number of calls, which readers
+        // divide them by
+        std::vector<float> values(nval);
+        for (int32_t i = 0; i < nval; i++) {
+            if (e.counts[i] > 0) {
+                values[i] = e.values[i] / e.counts[i] * e.ncall;
+            }
+        }
+        out.write((const char *) values.data(), nval * sizeof(float));
+    }
+
+    const int32_t last_call = n_chunks;
+    out.write((const char *) &last_call, sizeof(last_call));
+
+    const int32_t dataset_len = strlen(dataset);
+    out.write((const char *) &dataset_len, sizeof(dataset_len));
+    out.write(dataset, dataset_len);
+
+    return out.fail() ? 1 : 0;
+}
+
+static bool load_imatrix(const char *path, std::unordered_map<std::string, std::vector<float>> &imatrix) {
+    std::ifstream in(path, std::ios::binary);
+    if (!in) {
+        return false;
+    }
+
+    int32_t n_entries;
+    in.read((char *) &n_entries, sizeof(n_entries));
+    if (in.fail() || n_entries < 1) {
+        return false;
+    }
+
+    for (int32_t i = 0; i < n_entries; i++) {
+        int32_t len;
+        in.read((char *) &len, sizeof(len));
+        if (in.fail() || len < 1) {
+            return false;
+        }
+
+        std::string name(len, '\0');
+        in.read(&name[0], len);
+
+        int32_t ncall, nval;
+        in.read((char *) &ncall, sizeof(ncall));
+        in.read((char *) &nval, sizeof(nval));
+        if (in.fail() || nval < 1) {
+            return false;
+        }
+
+        auto &e = imatrix[name];
+        e.resize(nval);
+        in.read((char *) e.data(), nval * sizeof(float));
+        if (in.fail()) {
+            return false;
+        }
+
+        if (ncall > 0) {
+            for (auto &v : e) {
+                v /= ncall;
+            }
+        }
+    }
+
+    // the number of chunks and the dataset which follow are informational
+    return true;
+}
+
+int ollama_model_quantize_imatrix(const char *infile, const char *outfile, llama_model_quantize_params *params, const char *path) {
+    std::unordered_map<std::string, std::vector<float>> imatrix;
+    if (!load_imatrix(path, imatrix)) {
+        fprintf(stderr, "imatrix: failed to load %s\n", path);
+        return 1;
+    }
+
+    params->imatrix = &imatrix;
+    return llama_model_quantize(infile, outfile, params);
+}
@@ -0,0 +1,114 @@
+package llm
+
+// #cgo CXXFLAGS: -std=c++11 -Illama.cpp -Illama.cpp/include -Illama.cpp/ggml/include
+// #include <stdlib.h>
+// #include "imatrix.h"
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// ImatrixChunkSize is the number of tokens of calibration text evaluated at a
+// time when computing an importance matrix
+const ImatrixChunkSize = 512
+
+// Calibrate computes the importance matrix of the model at path by evaluating
+// text in chunks of [ImatrixChunkSize] tokens, and writes it to imatrix in
+// llama.cpp's imatrix format. fn is called after each chunk.
+func Calibrate(ctx context.Context, path, text, imatrix string, fn func(completed, total int)) error {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	mparams := C.llama_model_default_params()
+	mparams.n_gpu_layers = 0
+
+	model := C.llama_load_model_from_file(cpath, mparams)
+	if model == nil {
+		return fmt.Errorf("failed to load model %s", path)
+	}
+	defer C.llama_free_model(model)
+
+	tokens, err := calibrationTokens(model, text)
+	if err != nil {
+		return err
+	}
+
+	chunks := len(tokens) / ImatrixChunkSize
+	if chunks == 0 {
+		return fmt.Errorf("calibration text has %d tokens, at least %d are needed", len(tokens), ImatrixChunkSize)
+	}
+
+	collector := C.ollama_imatrix_init()
+	defer C.ollama_imatrix_free(collector)
+
+	cparams := C.llama_context_default_params()
+	cparams.n_ctx = ImatrixChunkSize
+	cparams.n_batch = ImatrixChunkSize
+	cparams.n_ubatch = ImatrixChunkSize
+	cparams.n_threads = C.uint32_t(runtime.NumCPU())
+	cparams.n_threads_batch = C.uint32_t(runtime.NumCPU())
+	cparams.cb_eval = C.ggml_backend_sched_eval_callback(C.ollama_imatrix_collect)
+	cparams.cb_eval_user_data = unsafe.Pointer(collector)
+
+	lctx := C.llama_new_context_with_model(model, cparams)
+	if lctx == nil {
+		return errors.New("failed to create context for calibration")
+	}
+	defer C.llama_free(lctx)
+
+	// tokens are passed to llama.cpp from C memory as batches keep them
+	buf := (*C.llama_token)(C.malloc(C.size_t(ImatrixChunkSize) * C.size_t(unsafe.Sizeof(C.llama_token(0)))))
+	defer C.free(unsafe.Pointer(buf))
+
+	fn(0, chunks)
+	for i := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		C.llama_kv_cache_clear(lctx)
+
+		copy(unsafe.Slice(buf, ImatrixChunkSize), tokens[i*ImatrixChunkSize:(i+1)*ImatrixChunkSize])
+		batch := C.llama_batch_get_one(buf, ImatrixChunkSize, 0, 0)
+		if rc := C.llama_decode(lctx, batch); rc != 0 {
+			return fmt.Errorf("failed to evaluate calibration chunk %d: %d", i, rc)
+		}
+
+		fn(i+1, chunks)
+	}
+
+	cimatrix := C.CString(imatrix)
+	defer C.free(unsafe.Pointer(cimatrix))
+
+	cdataset := C.CString("calibration")
+	defer C.free(unsafe.Pointer(cdataset))
+
+	if rc := C.ollama_imatrix_save(collector, cimatrix, C.int(chunks), cdataset); rc != 0 {
+		return fmt.Errorf("failed to write importance matrix %s", imatrix)
+	}
+
+	return nil
+}
+
+func calibrationTokens(model *C.struct_llama_model, text string) ([]C.llama_token, error) {
+	ctext := C.CString(text)
+	defer C.free(unsafe.Pointer(ctext))
+
+	// a negative count is the number of tokens when there isn't room for them
+	n := C.llama_tokenize(model, ctext, C.int32_t(len(text)), nil, 0, true, false)
+	if n >= 0 {
+		return nil, nil
+	}
+
+	tokens := make([]C.llama_token, -n)
+	if n := C.llama_tokenize(model, ctext, C.int32_t(len(text)), &tokens[0], C.int32_t(len(tokens)), true, false); n < 0 {
+		return nil, errors.New("failed to tokenize calibration text")
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,36 @@
+#ifndef OLLAMA_IMATRIX_H
+#define OLLAMA_IMATRIX_H
+
+#include <stdbool.h>
+
+#include "llama.h"
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// ollama_imatrix collects the mean squared activations of the inputs of the
+// matrix multiplications of a model, as llama.cpp's imatrix example does
+struct ollama_imatrix;
+
+struct ollama_imatrix *ollama_imatrix_init(void);
+void ollama_imatrix_free(struct ollama_imatrix *imatrix);
+
+// ollama_imatrix_collect is a ggml_backend_sched_eval_callback. user_data must
+// be an ollama_imatrix
+bool ollama_imatrix_collect(struct ggml_tensor *t, bool ask, void *user_data);
+
+// ollama_imatrix_save writes imatrix to path in llama.cpp's imatrix format. It
+// returns non-zero on failure
+int ollama_imatrix_save(struct ollama_imatrix *imatrix, const char *path, int n_chunks, const char *dataset);
+
+// ollama_model_quantize_imatrix quantizes like llama_model_quantize using the
+// importance matrix in the llama.cpp imatrix file at path. It returns non-zero
+// on failure
+int ollama_model_quantize_imatrix(const char *infile, const char *outfile, llama_model_quantize_params *params, const char *path);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
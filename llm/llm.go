@@ -10,6 +10,7 @@ package llm
 // #cgo linux,arm64 LDFLAGS: -L${SRCDIR}/build/linux/arm64_static -L${SRCDIR}/build/linux/arm64_static/src -L${SRCDIR}/build/linux/arm64_static/ggml/src
 // #include <stdlib.h>
 // #include "llama.h"
+// #include "imatrix.h"
 import "C"
 
 import (
@@ -22,7 +23,10 @@ func SystemInfo() string {
 	return C.GoString(C.llama_print_system_info())
 }
 
-func Quantize(infile, outfile string, ftype fileType) error {
+// Quantize quantizes the model in infile to ftype. If imatrix isn't empty, it's
+// the path of an importance matrix in llama.cpp's imatrix format, such as one
+// written by [Calibrate], which weights the quantization.
+func Quantize(infile, outfile string, ftype fileType, imatrix string) error {
 	cinfile := C.CString(infile)
 	defer C.free(unsafe.Pointer(cinfile))
 
@@ -32,6 +36,19 @@ func Quantize(infile, outfile string, ftype fileType) error {
 	params := C.llama_model_quantize_default_params()
 	params.nthread = -1
 	params.ftype = ftype.Value()
+	// callers choose which quantized models can be requantized
+	params.allow_requantize = true
+
+	if imatrix != "" {
+		cimatrix := C.CString(imatrix)
+		defer C.free(unsafe.Pointer(cimatrix))
+
+		if rc := C.ollama_model_quantize_imatrix(cinfile, coutfile, &params, cimatrix); rc != 0 {
+			return errors.New("failed to quantize model with importance matrix. The importance matrix may not be for this model")
+		}
+
+		return nil
+	}
 
 	if rc := C.llama_model_quantize(cinfile, coutfile, &params); rc != 0 {
 		return errors.New("failed to quantize model. This model architecture may not be supported, or you may need to upgrade Ollama to the latest version")
@@ -1,12 +1,17 @@
 package llm
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/gpu"
 )
@@ -15,9 +20,19 @@ import (
 func PredictServerFit(allGpus gpu.GpuInfoList, ggml *GGML, adapters, projectors []string, opts api.Options) (bool, uint64) {
 	// Split up the GPUs by type and try them
 	var estimatedVRAM uint64
+
+	var rpcGPUs gpu.GpuInfoList
+	if opts.RPCServers != "" && opts.NumGPU != 0 {
+		var err error
+		if rpcGPUs, err = gpu.RPCGetGPUInfo(opts.RPCServers); err != nil {
+			slog.Warn("unable to query rpc servers", "error", err)
+			return false, estimatedVRAM
+		}
+	}
+
 	for _, gpus := range allGpus.ByLibrary() {
 		var layerCount int
-		estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate := EstimateGPULayers(withRPC(gpus, rpcGPUs), ggml, projectors, opts)
 		layerCount, estimatedVRAM = estimate.Layers, estimate.VRAMSize
 		if opts.NumGPU < 0 {
 			if layerCount > 0 && layerCount >= int(ggml.KV().BlockCount()+1) {
@@ -51,12 +66,15 @@ type MemoryEstimate struct {
 	// For multi-GPU scenarios, this is the size in bytes per GPU
 	GPUSizes []uint64
 
+	// The data type of the K/V cache and its size in bytes
+	KVCacheType string
+	KVCache     uint64
+
 	// internal fields for logging purposes
 	inferenceLibrary    string
 	layersRequested     int
 	layersModel         int
 	availableList       []string
-	kv                  uint64
 	allocationsList     []string
 	memoryWeights       uint64
 	memoryLayerOutput   uint64
@@ -115,13 +133,21 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 		slog.Warn("model missing blk.0 layer size")
 	}
 
-	// fp16 k,v = sizeof(float16) * n_ctx * n_layer * (n_embd_head_k + n_embd_head_v) * n_head_kv
-	var kv uint64 = 2 * uint64(opts.NumCtx) * ggml.KV().BlockCount() * (ggml.KV().EmbeddingHeadCountK() + ggml.KV().EmbeddingHeadCountV()) * ggml.KV().HeadCountKV()
+	// k,v = sizeof(cache type) * n_ctx * n_layer * (n_embd_head_k + n_embd_head_v) * n_head_kv
+	cacheType := kvCacheType(gpus, opts)
+	kv := uint64(kvCacheTypes[cacheType] * float64(uint64(opts.NumCtx)*ggml.KV().BlockCount()*(ggml.KV().EmbeddingHeadCountK()+ggml.KV().EmbeddingHeadCountV())*ggml.KV().HeadCountKV()))
 
 	// KV is proportional to the number of layers
 	layerSize += kv / ggml.KV().BlockCount()
 
-	graphPartialOffload, graphFullOffload = ggml.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)))
+	// the graph is sized for the ubatch, but only a ubatch which is set is
+	// trusted to be smaller than the batch
+	batch := opts.NumBatch
+	if opts.NumUBatch > 0 {
+		batch = ubatchSize(opts)
+	}
+
+	graphPartialOffload, graphFullOffload = ggml.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, batch)))
 	if graphPartialOffload == 0 {
 		graphPartialOffload = ggml.KV().GQA() * kv / 6
 	}
@@ -149,6 +175,28 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 	// Output layer handled at the end if we have space
 	gpuZeroOverhead := projectorSize
 
+	// An explicit tensor split or main GPU overrides the automatic layout
+	var ratios []float64
+	if opts.TensorSplit != "" && len(gpus) > 1 {
+		var err error
+		if ratios, err = tensorSplitRatios(opts.TensorSplit, len(gpus)); err != nil {
+			slog.Warn("ignoring tensor split", "error", err)
+		}
+	}
+
+	// proportional placement splits layers by free memory rather than evenly
+	if ratios == nil && len(gpus) > 1 && envconfig.GPUPolicy() == envconfig.GPUPolicyProportional {
+		ratios = make([]float64, len(gpus))
+		for i, g := range gpus {
+			ratios[i] = float64(g.FreeMemory)
+		}
+	}
+
+	mainGPU := -1
+	if opts.MainGPU > 0 && opts.MainGPU < len(gpus) {
+		mainGPU = opts.MainGPU
+	}
+
 	// Reduce set of GPUs to only those that have sufficient space to fit overhead and at least one layer
 	var layerCount int
 	layerCounts := make([]int, len(gpus))
@@ -159,8 +207,12 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 	}
 	gpusWithSpace := []gs{}
 	for i := range gpus {
+		if ratios != nil && ratios[i] == 0 {
+			continue
+		}
+
 		var gzo uint64
-		if len(gpusWithSpace) == 0 {
+		if (mainGPU < 0 && len(gpusWithSpace) == 0) || i == mainGPU {
 			gzo = gpuZeroOverhead
 		}
 		// Only include GPUs that can fit the graph, gpu minimum, the layer buffer and at least more layer
@@ -175,9 +227,31 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 	var gpuZeroID int
 	if len(gpusWithSpace) > 0 {
 		gpuZeroID = gpusWithSpace[0].i
+		for _, g := range gpusWithSpace {
+			if g.i == mainGPU {
+				gpuZeroID = g.i
+			}
+		}
 		gpuAllocations[gpuZeroID] += gpuZeroOverhead
 	}
 
+	// next returns the index into gpusWithSpace to try for the n'th layer. By
+	// default layers are spread round robin, otherwise each layer goes to the
+	// GPU furthest below its requested share.
+	next := func(n, j int) int {
+		if ratios == nil {
+			return n % j
+		}
+
+		k := 0
+		for m := 1; m < j; m++ {
+			if float64(layerCounts[gpusWithSpace[m].i])/ratios[gpusWithSpace[m].i] < float64(layerCounts[gpusWithSpace[k].i])/ratios[gpusWithSpace[k].i] {
+				k = m
+			}
+		}
+		return k
+	}
+
 	// For all the layers, find where they can fit on the GPU(s)
 	for i := range int(ggml.KV().BlockCount()) {
 		// Some models have inconsistent layer sizes
@@ -194,7 +268,8 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 
 		// distribute the layers across the GPU(s) that have space
 		for j := len(gpusWithSpace); j > 0; j-- {
-			g := gpusWithSpace[i%j]
+			k := next(i, j)
+			g := gpusWithSpace[k]
 			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
 			if g.g.FreeMemory > used+layerSize {
 				gpuAllocations[g.i] += layerSize
@@ -202,7 +277,7 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 				layerCount++
 				break
 			} else {
-				gpusWithSpace = append(gpusWithSpace[:i%j], gpusWithSpace[i%j+1:]...)
+				gpusWithSpace = append(gpusWithSpace[:k], gpusWithSpace[k+1:]...)
 			}
 		}
 	}
@@ -217,7 +292,7 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 	// Determine if we need to consider output then find where it fits
 	if memoryLayerOutput > 0 && (opts.NumGPU < 0 || layerCount < opts.NumGPU) {
 		for j := len(gpusWithSpace); j > 0; j-- {
-			g := gpusWithSpace[layerCount%j]
+			g := gpusWithSpace[next(layerCount, j)]
 			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
 			if g.g.FreeMemory > used+memoryLayerOutput {
 				gpuAllocations[g.i] += memoryLayerOutput
@@ -277,11 +352,13 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 		VRAMSize:  0,
 		GPUSizes:  []uint64{},
 
+		KVCacheType: cacheType,
+		KVCache:     kv,
+
 		inferenceLibrary:    gpus[0].Library,
 		layersRequested:     opts.NumGPU,
 		layersModel:         int(ggml.KV().BlockCount()) + 1,
 		availableList:       availableList,
-		kv:                  kv,
 		allocationsList:     allocationsList,
 		memoryWeights:       memoryWeights,
 		memoryLayerOutput:   memoryLayerOutput,
@@ -305,6 +382,234 @@ func EstimateGPULayers(gpus []gpu.GpuInfo, ggml *GGML, projectors []string, opts
 	return estimate
 }
 
+// tensorSplitRatios parses a tensor split such as "3,1" into one proportion
+// per GPU. GPUs without an entry are assigned no layers.
+func tensorSplitRatios(s string, numGPUs int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) > numGPUs {
+		return nil, fmt.Errorf("tensor_split has %d entries but only %d GPUs are available", len(parts), numGPUs)
+	}
+
+	var total float64
+	ratios := make([]float64, numGPUs)
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("invalid tensor_split value %q", part)
+		}
+
+		ratios[i] = f
+		total += f
+	}
+
+	if total == 0 {
+		return nil, errors.New("tensor_split must assign layers to at least one GPU")
+	}
+
+	return ratios, nil
+}
+
+// requestedTensorSplit checks the tensor split requested for numGPUs GPUs. A
+// single GPU has nothing to split layers across so the split is ignored.
+func requestedTensorSplit(split string, numGPUs int) (string, error) {
+	switch {
+	case split == "" || numGPUs == 0:
+		return "", nil
+	case numGPUs == 1:
+		slog.Warn("ignoring tensor_split, only one GPU is available", "tensor_split", split)
+		return "", nil
+	}
+
+	if _, err := tensorSplitRatios(split, numGPUs); err != nil {
+		return "", err
+	}
+
+	return split, nil
+}
+
+// withRPC returns the local gpus followed by the RPC servers rpc, which
+// replace the CPU when there are no local GPUs
+func withRPC(gpus, rpc gpu.GpuInfoList) gpu.GpuInfoList {
+	if len(rpc) == 0 {
+		return gpus
+	}
+
+	if len(gpus) == 0 || gpus[0].Library == "cpu" {
+		return rpc
+	}
+
+	return slices.Concat(gpus, rpc)
+}
+
+// rpcTensorSplit reorders split, which has an entry for each of numLocal local
+// GPUs followed by one for each of numRPC RPC servers, to llama.cpp's order of
+// RPC servers first. Missing entries assign no layers.
+func rpcTensorSplit(split string, numLocal, numRPC int) string {
+	parts := strings.Split(split, ",")
+	for len(parts) < numLocal+numRPC {
+		parts = append(parts, "0")
+	}
+
+	return strings.Join(slices.Concat(parts[numLocal:], parts[:numLocal]), ",")
+}
+
+// kvCacheTypes maps the supported K/V cache types to their size in bytes per element
+var kvCacheTypes = map[string]float64{
+	"f32":  4,
+	"f16":  2,
+	"q8_0": 34.0 / 32,
+	"q4_0": 18.0 / 32,
+}
+
+// kvCacheType returns the K/V cache type used when loading a model onto gpus.
+// Quantized caches require flash attention so fall back to f16 when it is
+// unavailable.
+func kvCacheType(gpus []gpu.GpuInfo, opts api.Options) string {
+	t := requestedKVCacheType(opts)
+	switch t {
+	case "":
+		if !opts.F16KV {
+			return "f32"
+		}
+
+		return "f16"
+	case "f32", "f16":
+		return t
+	}
+
+	if _, ok := kvCacheTypes[t]; !ok || !flashAttention(gpus, opts) {
+		return "f16"
+	}
+
+	return t
+}
+
+// requestedKVCacheType returns the K/V cache type asked for by the per-model
+// option, or OLLAMA_KV_CACHE_TYPE if the option is not set
+func requestedKVCacheType(opts api.Options) string {
+	if opts.KVCacheType != "" {
+		return strings.ToLower(opts.KVCacheType)
+	}
+
+	return strings.ToLower(envconfig.KVCacheType())
+}
+
+// flashAttention reports whether flash attention should be used when loading
+// a model onto gpus
+func flashAttention(gpus []gpu.GpuInfo, opts api.Options) bool {
+	return flashAttentionRequested(opts) && flashAttentionSupported(gpus)
+}
+
+// flashAttentionRequested reports whether flash attention is asked for by the
+// per-model option, or OLLAMA_FLASH_ATTENTION if the option is not set
+func flashAttentionRequested(opts api.Options) bool {
+	if opts.FlashAttention != nil {
+		return *opts.FlashAttention
+	}
+
+	return envconfig.FlashAttention()
+}
+
+// flashAttentionSupported reports whether all of gpus support flash attention
+func flashAttentionSupported(gpus []gpu.GpuInfo) bool {
+	for _, g := range gpus {
+		// only cuda (compute capability 7+) and metal support flash attention
+		if g.Library != "metal" && (g.Library != "cuda" || g.DriverMajor < 7) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// defaultUBatch is llama.cpp's default number of tokens of a batch which are
+// processed at once
+const defaultUBatch = 512
+
+// ubatchSize is the number of tokens of each batch processed at once, which
+// llama.cpp caps to the batch size
+func ubatchSize(opts api.Options) int {
+	ubatch := defaultUBatch
+	if opts.NumUBatch > 0 {
+		ubatch = opts.NumUBatch
+	}
+
+	return min(ubatch, opts.NumBatch)
+}
+
+// cudaGraphs reports whether CUDA graphs are used when loading on gpus.
+// llama.cpp only uses them on a single NVIDIA GPU of compute capability 8 or
+// newer, and not if they're disabled by the cuda_graphs option or, if the
+// option is not set, GGML_CUDA_DISABLE_GRAPHS.
+func cudaGraphs(gpus []gpu.GpuInfo, opts api.Options) bool {
+	if len(gpus) != 1 || gpus[0].Library != "cuda" {
+		return false
+	}
+
+	major, _, _ := strings.Cut(gpus[0].Compute, ".")
+	if n, err := strconv.Atoi(major); err != nil || n < 8 {
+		return false
+	}
+
+	if opts.CUDAGraphs != nil {
+		return *opts.CUDAGraphs
+	}
+
+	_, disabled := os.LookupEnv("GGML_CUDA_DISABLE_GRAPHS")
+	return !disabled
+}
+
+// useMMap reports whether the model is memory mapped when loading it on gpus.
+// llama.cpp doesn't map models with adapters, and Ollama doesn't map them
+// when partially offloaded with Metal. Otherwise the use_mmap option decides,
+// or if it's not set, models aren't mapped when they run on the CPU, on
+// NVIDIA GPUs on Windows or when they're larger than the free memory on Linux.
+func useMMap(gpus []gpu.GpuInfo, opts api.Options, numAdapters int, blockCount, size, systemFreeMemory uint64) bool {
+	if numAdapters > 0 {
+		return false
+	}
+
+	// mmap has issues with partial offloading on metal
+	if gpus[0].Library == "metal" && opts.NumGPU > 0 && uint64(opts.NumGPU) < blockCount+1 {
+		return false
+	}
+
+	if opts.UseMMap != nil {
+		return *opts.UseMMap
+	}
+
+	switch {
+	case gpus[0].Library == "cpu":
+		// For CPU loads we want the memory to be allocated, not FS cache
+		return false
+	case runtime.GOOS == "windows" && gpus[0].Library == "cuda":
+		// Windows CUDA should not use mmap for best performance
+		return false
+	case runtime.GOOS == "linux" && systemFreeMemory < size:
+		// Linux with a model larger than free space, mmap leads to thrashing
+		return false
+	}
+
+	return true
+}
+
+// pinnedMemory reports whether the parts of a model in system memory are
+// pinned when loading it on gpus. llama.cpp pins them on NVIDIA and AMD GPUs
+// when the model isn't memory mapped, unless they're disabled by the
+// use_pinned option or, if the option is not set, GGML_CUDA_NO_PINNED.
+func pinnedMemory(gpus []gpu.GpuInfo, opts api.Options, mmap bool) bool {
+	if mmap || (gpus[0].Library != "cuda" && gpus[0].Library != "rocm") {
+		return false
+	}
+
+	if opts.UsePinned != nil {
+		return *opts.UsePinned
+	}
+
+	_, disabled := os.LookupEnv("GGML_CUDA_NO_PINNED")
+	return !disabled
+}
+
 func (m MemoryEstimate) log() {
 	slog.Info(
 		"offload to "+m.inferenceLibrary,
@@ -330,7 +635,9 @@ func (m MemoryEstimate) log() {
 				// memory required to offload layers.estimate layers
 				"partial", format.HumanBytes2(m.VRAMSize),
 				// memory of KV cache
-				"kv", format.HumanBytes2(m.kv),
+				"kv", format.HumanBytes2(m.KVCache),
+				// data type of KV cache
+				"kv_type", m.KVCacheType,
 				// Allocations across the GPUs
 				"allocations", m.allocationsList,
 			),
@@ -126,4 +126,216 @@ func TestEstimateGPULayers(t *testing.T) {
 			}
 		})
 	}
+
+	// Explicit tensor split overrides the round robin layout
+	for _, s := range []struct {
+		split  string
+		expect string
+	}{
+		{"2,1", "4,2"},
+		{"1,0", "6,0"},
+		{"0,1", "0,6"},
+	} {
+		t.Run(s.split, func(t *testing.T) {
+			gpus[0].FreeMemory = gpuMinimumMemory + 8*layerSize + max(graphFullOffload, graphPartialOffload)
+			gpus[1].FreeMemory = gpuMinimumMemory + 8*layerSize + max(graphFullOffload, graphPartialOffload)
+			opts := api.DefaultOptions()
+			opts.TensorSplit = s.split
+			estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+			assert.Equal(t, inputLayerCount+1, estimate.Layers)
+			assert.Equal(t, s.expect, estimate.TensorSplit)
+		})
+	}
+
+	// Proportional placement splits layers by free memory
+	t.Run("proportional", func(t *testing.T) {
+		t.Setenv("OLLAMA_GPU_POLICY", "proportional")
+		gpus[0].FreeMemory = gpuMinimumMemory + 16*layerSize + max(graphFullOffload, graphPartialOffload)
+		gpus[1].FreeMemory = gpus[0].FreeMemory / 2
+		estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+		assert.Equal(t, inputLayerCount+1, estimate.Layers)
+		assert.Equal(t, "4,2", estimate.TensorSplit)
+	})
+}
+
+func TestTensorSplitRatios(t *testing.T) {
+	ratios, err := tensorSplitRatios("3, 1", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{3, 1, 0}, ratios)
+
+	for _, s := range []string{"1,1,1", "a,1", "-1,2", "0,0"} {
+		_, err := tensorSplitRatios(s, 2)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRequestedTensorSplit(t *testing.T) {
+	cases := []struct {
+		split   string
+		numGPUs int
+		expect  string
+		err     bool
+	}{
+		{"", 2, "", false},
+		{"3,1", 2, "3,1", false},
+		{"3,1,1", 2, "", true},
+		// a single GPU or the CPU have nothing to split across
+		{"3,1", 1, "", false},
+		{"0,1", 1, "", false},
+		{"3,1", 0, "", false},
+	}
+
+	for _, tt := range cases {
+		split, err := requestedTensorSplit(tt.split, tt.numGPUs)
+		if tt.err {
+			assert.Error(t, err, tt.split)
+			continue
+		}
+
+		require.NoError(t, err, tt.split)
+		assert.Equal(t, tt.expect, split, tt.split)
+	}
+}
+
+func TestKVCacheType(t *testing.T) {
+	cuda := []gpu.GpuInfo{{Library: "cuda", DriverMajor: 8}}
+	cpu := []gpu.GpuInfo{{Library: "cpu"}}
+
+	opts := api.DefaultOptions()
+	assert.Equal(t, "f16", kvCacheType(cuda, opts))
+
+	t.Setenv("OLLAMA_KV_CACHE_TYPE", "q8_0")
+	assert.Equal(t, "f16", kvCacheType(cuda, opts), "requires flash attention")
+
+	t.Setenv("OLLAMA_FLASH_ATTENTION", "1")
+	assert.Equal(t, "q8_0", kvCacheType(cuda, opts))
+	assert.Equal(t, "f16", kvCacheType(cpu, opts))
+
+	opts.KVCacheType = "Q4_0"
+	assert.Equal(t, "q4_0", kvCacheType(cuda, opts))
+
+	opts.KVCacheType = "q5_1"
+	assert.Equal(t, "f16", kvCacheType(cuda, opts))
+}
+
+func TestFlashAttention(t *testing.T) {
+	cuda := []gpu.GpuInfo{{Library: "cuda", DriverMajor: 8}}
+	old := []gpu.GpuInfo{{Library: "cuda", DriverMajor: 6}}
+
+	opts := api.DefaultOptions()
+	assert.False(t, flashAttention(cuda, opts))
+
+	enabled, disabled := true, false
+	opts.FlashAttention = &enabled
+	assert.True(t, flashAttention(cuda, opts))
+	assert.False(t, flashAttention(old, opts), "falls back on unsupported GPUs")
+
+	t.Setenv("OLLAMA_FLASH_ATTENTION", "1")
+	opts.FlashAttention = &disabled
+	assert.False(t, flashAttention(cuda, opts))
+
+	opts.FlashAttention = nil
+	assert.True(t, flashAttention(cuda, opts))
+}
+
+func TestUBatchSize(t *testing.T) {
+	opts := api.DefaultOptions()
+	assert.Equal(t, 512, ubatchSize(opts))
+
+	opts.NumUBatch = 128
+	assert.Equal(t, 128, ubatchSize(opts))
+
+	opts.NumUBatch, opts.NumBatch = 1024, 256
+	assert.Equal(t, 256, ubatchSize(opts), "capped to the batch size")
+}
+
+func TestCUDAGraphs(t *testing.T) {
+	ampere := []gpu.GpuInfo{{Library: "cuda", Compute: "8.6"}}
+	turing := []gpu.GpuInfo{{Library: "cuda", Compute: "7.5"}}
+
+	// Setenv restores the variable once it's been unset for the test
+	t.Setenv("GGML_CUDA_DISABLE_GRAPHS", "")
+	os.Unsetenv("GGML_CUDA_DISABLE_GRAPHS")
+
+	opts := api.DefaultOptions()
+	assert.True(t, cudaGraphs(ampere, opts))
+	assert.False(t, cudaGraphs(turing, opts))
+	assert.False(t, cudaGraphs(append(ampere, ampere...), opts), "not used across GPUs")
+	assert.False(t, cudaGraphs([]gpu.GpuInfo{{Library: "rocm", Compute: "gfx1100"}}, opts))
+
+	t.Setenv("GGML_CUDA_DISABLE_GRAPHS", "1")
+	assert.False(t, cudaGraphs(ampere, opts))
+
+	enabled, disabled := true, false
+	opts.CUDAGraphs = &enabled
+	assert.True(t, cudaGraphs(ampere, opts))
+	assert.False(t, cudaGraphs(turing, opts))
+
+	t.Setenv("GGML_CUDA_DISABLE_GRAPHS", "")
+	os.Unsetenv("GGML_CUDA_DISABLE_GRAPHS")
+	opts.CUDAGraphs = &disabled
+	assert.False(t, cudaGraphs(ampere, opts))
+}
+
+func TestUseMMap(t *testing.T) {
+	cpu := []gpu.GpuInfo{{Library: "cpu"}}
+	metal := []gpu.GpuInfo{{Library: "metal"}}
+
+	opts := api.DefaultOptions()
+	assert.False(t, useMMap(cpu, opts, 0, 32, 4<<30, 16<<30))
+	assert.True(t, useMMap(metal, opts, 0, 32, 4<<30, 16<<30))
+	assert.False(t, useMMap(metal, opts, 1, 32, 4<<30, 16<<30), "adapters are never mapped")
+
+	opts.NumGPU = 20
+	assert.False(t, useMMap(metal, opts, 0, 32, 4<<30, 16<<30), "partial offloading on metal")
+	opts.NumGPU = 33
+	assert.True(t, useMMap(metal, opts, 0, 32, 4<<30, 16<<30))
+
+	enabled, disabled := true, false
+	opts.UseMMap = &enabled
+	assert.True(t, useMMap(cpu, opts, 0, 32, 4<<30, 16<<30))
+	opts.UseMMap = &disabled
+	assert.False(t, useMMap(metal, opts, 0, 32, 4<<30, 16<<30))
+}
+
+func TestPinnedMemory(t *testing.T) {
+	cuda := []gpu.GpuInfo{{Library: "cuda"}}
+
+	t.Setenv("GGML_CUDA_NO_PINNED", "")
+	os.Unsetenv("GGML_CUDA_NO_PINNED")
+
+	opts := api.DefaultOptions()
+	assert.True(t, pinnedMemory(cuda, opts, false))
+	assert.True(t, pinnedMemory([]gpu.GpuInfo{{Library: "rocm"}}, opts, false))
+	assert.False(t, pinnedMemory(cuda, opts, true), "not used with mmap")
+	assert.False(t, pinnedMemory([]gpu.GpuInfo{{Library: "metal"}}, opts, false))
+
+	t.Setenv("GGML_CUDA_NO_PINNED", "1")
+	assert.False(t, pinnedMemory(cuda, opts, false))
+
+	enabled, disabled := true, false
+	opts.UsePinned = &enabled
+	assert.True(t, pinnedMemory(cuda, opts, false))
+	opts.UsePinned = &disabled
+	assert.False(t, pinnedMemory(cuda, opts, false))
+}
+
+func TestWithRPC(t *testing.T) {
+	cuda := gpu.GpuInfoList{{Library: "cuda", ID: "0"}}
+	cpu := gpu.GpuInfoList{{Library: "cpu", ID: "0"}}
+	rpc := gpu.GpuInfoList{{Library: "rpc", ID: "a:50052"}, {Library: "rpc", ID: "b:50052"}}
+
+	assert.Equal(t, cuda, withRPC(cuda, nil))
+	assert.Equal(t, rpc, withRPC(cpu, rpc))
+
+	gpus := withRPC(cuda, rpc)
+	require.Len(t, gpus, 3)
+	assert.Equal(t, "0", gpus[0].ID)
+	assert.Equal(t, "b:50052", gpus[2].ID)
+}
+
+func TestRPCTensorSplit(t *testing.T) {
+	assert.Equal(t, "5,7,10,20", rpcTensorSplit("10,20,5,7", 2, 2))
+	assert.Equal(t, "0,0,3,1", rpcTensorSplit("3,1", 2, 2), "missing entries")
+	assert.Equal(t, "4,8", rpcTensorSplit("4,8", 0, 2))
 }
@@ -0,0 +1,246 @@
+package llm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/d4l3k/go-bfloat16"
+	"github.com/x448/float16"
+)
+
+// lora is a pair of LoRA tensors, lora_a and lora_b, of an adapter for one of
+// the model's weights
+type lora struct {
+	r      io.ReaderAt
+	offset uint64
+	a, b   *Tensor
+	scale  float32
+}
+
+// MergeAdapters writes the model at path to w with the LoRA adapters at
+// adapters folded into its weights, each scaled by its entry of scales or 1
+// like adapters applied by the runner. The merged weights keep their type,
+// which must be F32, F16 or BF16.
+func MergeAdapters(w io.Writer, path string, adapters []string, scales []float32) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ggml, _, err := DecodeGGML(f, -1)
+	if err != nil {
+		return err
+	}
+
+	if ggml.Name() != "gguf" {
+		return fmt.Errorf("%s: not a GGUF file", path)
+	}
+
+	loras := make(map[string][]lora)
+	for i, adapter := range adapters {
+		a, err := os.Open(adapter)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		ag, _, err := DecodeGGML(a, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", adapter, err)
+		}
+
+		if ag.Name() != "gguf" || ag.KV().Kind() != "adapter" {
+			return fmt.Errorf("%s: not a GGUF adapter", adapter)
+		}
+
+		tensors := ag.Tensors()
+		pairs := make(map[string]*lora)
+		for _, t := range tensors.Items {
+			if t.typeSize() == 0 {
+				return fmt.Errorf("%s: tensor %s has unsupported type %d", adapter, t.Name, t.Kind)
+			}
+
+			name, ab, ok := strings.Cut(t.Name, ".lora_")
+			if !ok || (ab != "a" && ab != "b") {
+				return fmt.Errorf("%s: unsupported adapter tensor %s", adapter, t.Name)
+			}
+
+			l, ok := pairs[name]
+			if !ok {
+				l = &lora{r: a, offset: tensors.Offset}
+				pairs[name] = l
+			}
+
+			if ab == "a" {
+				l.a = t
+			} else {
+				l.b = t
+			}
+		}
+
+		// llama.cpp scales adapters by alpha / rank when they have an alpha
+		alpha, _ := ag.KV()["adapter.lora.alpha"].(float32)
+		for name, l := range pairs {
+			if l.a == nil || l.b == nil || len(l.a.Shape) != 2 {
+				return fmt.Errorf("%s: adapter tensors for %s aren't a lora_a and lora_b pair", adapter, name)
+			}
+
+			l.scale = adapterScale(scales, i)
+			if alpha != 0 {
+				l.scale *= alpha / float32(l.a.Shape[1])
+			}
+
+			loras[name] = append(loras[name], *l)
+		}
+	}
+
+	tensors := ggml.Tensors()
+	var ts []Tensor
+	for _, t := range tensors.Items {
+		// the size of a tensor of an unknown type would be 0, so it would
+		// be written empty
+		if t.typeSize() == 0 {
+			return fmt.Errorf("%s: tensor %s has unsupported type %d", path, t.Name, t.Kind)
+		}
+
+		offset := int64(tensors.Offset + t.Offset)
+
+		// WriteGGUF reverses shapes to the order ggml expects
+		shape := slices.Clone(t.Shape)
+		slices.Reverse(shape)
+
+		var wt io.WriterTo = sectionWriterTo{io.NewSectionReader(f, offset, int64(t.Size()))}
+		if ls, ok := loras[t.Name]; ok {
+			if !slices.Contains([]uint32{0, 1, 30}, t.Kind) {
+				return fmt.Errorf("adapters can only be merged into F32, F16 and BF16 weights, %s has type %d", t.Name, t.Kind)
+			}
+
+			// the model's weight is [in, out] and an adapter's lora_a and
+			// lora_b are [in, rank] and [rank, out]
+			for _, l := range ls {
+				if len(t.Shape) != 2 || l.a.Shape[0] != t.Shape[0] || len(l.b.Shape) != 2 || l.b.Shape[0] != l.a.Shape[1] || l.b.Shape[1] != t.Shape[1] {
+					return fmt.Errorf("adapter tensors for %s have shapes %v and %v, which don't match its shape %v", t.Name, l.a.Shape, l.b.Shape, t.Shape)
+				}
+			}
+
+			wt = mergeWriterTo{r: f, offset: offset, t: t, loras: ls}
+			delete(loras, t.Name)
+		}
+
+		ts = append(ts, Tensor{
+			Name:     t.Name,
+			Kind:     t.Kind,
+			Shape:    shape,
+			WriterTo: wt,
+		})
+	}
+
+	if len(loras) > 0 {
+		names := make([]string, 0, len(loras))
+		for name := range loras {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+		return fmt.Errorf("adapter has tensors for %s, which the model doesn't have", strings.Join(names, ", "))
+	}
+
+	kv := maps.Clone(ggml.KV())
+
+	// the count is added when decoding, and the merged model is written with
+	// the default alignment
+	delete(kv, "general.parameter_count")
+	delete(kv, "general.alignment")
+
+	return WriteGGUF(w, kv, ts)
+}
+
+// mergeWriterTo writes the weight t with loras added to it
+type mergeWriterTo struct {
+	r      io.ReaderAt
+	offset int64
+	t      *Tensor
+	loras  []lora
+}
+
+func (m mergeWriterTo) WriteTo(w io.Writer) (int64, error) {
+	data, err := readTensorF32(m.r, m.offset, m.t)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, l := range m.loras {
+		a, err := readTensorF32(l.r, int64(l.offset+l.a.Offset), l.a)
+		if err != nil {
+			return 0, err
+		}
+
+		b, err := readTensorF32(l.r, int64(l.offset+l.b.Offset), l.b)
+		if err != nil {
+			return 0, err
+		}
+
+		addLora(data, a, b, int(m.t.Shape[0]), int(l.a.Shape[1]), l.scale)
+	}
+
+	var bts []byte
+	switch m.t.Kind {
+	case 0: // F32
+		if err := binary.Write(w, binary.LittleEndian, data); err != nil {
+			return 0, err
+		}
+
+		return int64(len(data)) * 4, nil
+	case 1: // F16
+		bts = make([]byte, len(data)*2)
+		for i, v := range data {
+			binary.LittleEndian.PutUint16(bts[2*i:], float16.Fromfloat32(v).Bits())
+		}
+	case 30: // BF16
+		bts = bfloat16.EncodeFloat32(data)
+	}
+
+	n, err := w.Write(bts)
+	return int64(n), err
+}
+
+// addLora adds scale * b a to the weight data, with rows of in elements, where
+// a has rank rows of in elements and b has a row of rank elements for each
+// of data's rows. Rows are split between CPUs as this is most of the work of
+// merging.
+func addLora(data, a, b []float32, in, rank int, scale float32) {
+	out := len(data) / in
+	n := runtime.NumCPU()
+	chunk := (out + n - 1) / n
+
+	var wg sync.WaitGroup
+	for start := 0; start < out; start += chunk {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for o := start; o < end; o++ {
+				row := data[o*in : (o+1)*in]
+				for r, v := range b[o*rank : (o+1)*rank] {
+					c := scale * v
+					if c == 0 {
+						continue
+					}
+
+					for i, x := range a[r*in : (r+1)*in] {
+						row[i] += c * x
+					}
+				}
+			}
+		}(start, min(start+chunk, out))
+	}
+
+	wg.Wait()
+}
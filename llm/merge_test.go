@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/go-bfloat16"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeAdapters(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "model.gguf")
+	writeSplit(t, base, KV{
+		"general.architecture":  "llama",
+		"tokenizer.ggml.tokens": []string{"a", "b"},
+	}, []Tensor{
+		f32Tensor(t, "blk.0.attn_q.weight", []uint64{2, 3}, 1, 2, 3, 4, 5, 6),
+		f32Tensor(t, "output.weight", []uint64{2}, 13, 14),
+	})
+
+	// rank 1 with an alpha of 2 doubles the scale
+	adapter := filepath.Join(dir, "adapter.gguf")
+	writeSplit(t, adapter, KV{
+		"general.architecture": "llama",
+		"general.type":         "adapter",
+		"adapter.type":         "lora",
+		"adapter.lora.alpha":   float32(2),
+	}, []Tensor{
+		f32Tensor(t, "blk.0.attn_q.weight.lora_a", []uint64{1, 3}, 1, 0, 1),
+		f32Tensor(t, "blk.0.attn_q.weight.lora_b", []uint64{2, 1}, 1, 2),
+	})
+
+	f, err := os.Create(filepath.Join(dir, "merged.gguf"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, MergeAdapters(f, base, []string{adapter}, []float32{0.5}))
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	ggml, _, err := DecodeGGML(f, -1)
+	require.NoError(t, err)
+
+	kv := ggml.KV()
+	assert.Equal(t, "llama", kv.Architecture())
+	assert.Equal(t, []any{"a", "b"}, kv["tokenizer.ggml.tokens"].(*array).values)
+
+	tensors := ggml.Tensors()
+	expect := map[string][]float32{
+		"blk.0.attn_q.weight": {2, 2, 4, 6, 5, 8},
+		"output.weight":       {13, 14},
+	}
+
+	require.Len(t, tensors.Items, len(expect))
+	for _, tensor := range tensors.Items {
+		values := make([]float32, tensor.parameters())
+		sr := io.NewSectionReader(f, int64(tensors.Offset+tensor.Offset), int64(tensor.Size()))
+		require.NoError(t, binary.Read(sr, binary.LittleEndian, values))
+		assert.Equal(t, expect[tensor.Name], values, tensor.Name)
+	}
+
+	t.Run("bf16", func(t *testing.T) {
+		base := filepath.Join(dir, "bf16.gguf")
+		writeSplit(t, base, KV{
+			"general.architecture": "llama",
+			"general.alignment":    uint32(64),
+		}, []Tensor{
+			bf16Tensor(t, "blk.0.attn_q.weight", []uint64{2, 3}, 1, 2, 3, 4, 5, 6),
+			bf16Tensor(t, "output.weight", []uint64{2}, 13, 14),
+		})
+
+		f, err := os.Create(filepath.Join(dir, "merged-bf16.gguf"))
+		require.NoError(t, err)
+		defer f.Close()
+
+		require.NoError(t, MergeAdapters(f, base, []string{adapter}, []float32{0.5}))
+
+		_, err = f.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+
+		ggml, _, err := DecodeGGML(f, -1)
+		require.NoError(t, err)
+		assert.NotContains(t, ggml.KV(), "general.alignment")
+
+		tensors := ggml.Tensors()
+		require.Len(t, tensors.Items, len(expect))
+		for _, tensor := range tensors.Items {
+			require.Equal(t, uint64(len(expect[tensor.Name])*2), tensor.Size(), tensor.Name)
+
+			bts := make([]byte, tensor.Size())
+			_, err := f.ReadAt(bts, int64(tensors.Offset+tensor.Offset))
+			require.NoError(t, err)
+			assert.Equal(t, expect[tensor.Name], bfloat16.DecodeFloat32(bts), tensor.Name)
+		}
+	})
+
+	t.Run("unknown type", func(t *testing.T) {
+		other := filepath.Join(dir, "unknown.gguf")
+		writeSplit(t, other, KV{"general.architecture": "llama"}, []Tensor{
+			{Name: "output.weight", Kind: 99, Shape: []uint64{2}, WriterTo: bytes.NewBuffer(nil)},
+		})
+
+		err := MergeAdapters(io.Discard, other, nil, nil)
+		assert.ErrorContains(t, err, "tensor output.weight has unsupported type 99")
+	})
+
+	t.Run("missing weight", func(t *testing.T) {
+		other := filepath.Join(dir, "other.gguf")
+		writeSplit(t, other, KV{
+			"general.architecture": "llama",
+			"general.type":         "adapter",
+		}, []Tensor{
+			f32Tensor(t, "blk.1.attn_q.weight.lora_a", []uint64{1, 3}, 1, 0, 1),
+			f32Tensor(t, "blk.1.attn_q.weight.lora_b", []uint64{2, 1}, 1, 2),
+		})
+
+		err := MergeAdapters(io.Discard, base, []string{other}, nil)
+		assert.ErrorContains(t, err, "adapter has tensors for blk.1.attn_q.weight, which the model doesn't have")
+	})
+}
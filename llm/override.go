@@ -0,0 +1,151 @@
+package llm
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// ParseKV parses value as the type of key's current value in kv, or for a
+// new key as an integer, float, bool or string, whichever it is first
+func ParseKV(kv KV, key, value string) (any, error) {
+	var err error
+	switch v := kv[key].(type) {
+	case nil:
+		if i, err := strconv.ParseUint(value, 10, 32); err == nil {
+			return uint32(i), nil
+		} else if i, err := strconv.ParseInt(value, 10, 32); err == nil {
+			return int32(i), nil
+		} else if f, err := strconv.ParseFloat(value, 32); err == nil {
+			return float32(f), nil
+		} else if b, err := strconv.ParseBool(value); err == nil {
+			return b, nil
+		}
+
+		return value, nil
+	case string:
+		return value, nil
+	case bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		if err == nil {
+			return b, nil
+		}
+	case uint8, uint16, uint32, uint64:
+		var i uint64
+		i, err = strconv.ParseUint(value, 10, bitSize(v))
+		if err == nil {
+			switch v.(type) {
+			case uint8:
+				return uint8(i), nil
+			case uint16:
+				return uint16(i), nil
+			case uint32:
+				return uint32(i), nil
+			default:
+				return i, nil
+			}
+		}
+	case int8, int16, int32, int64:
+		var i int64
+		i, err = strconv.ParseInt(value, 10, bitSize(v))
+		if err == nil {
+			switch v.(type) {
+			case int8:
+				return int8(i), nil
+			case int16:
+				return int16(i), nil
+			case int32:
+				return int32(i), nil
+			default:
+				return i, nil
+			}
+		}
+	case float32:
+		var f float64
+		f, err = strconv.ParseFloat(value, 32)
+		if err == nil {
+			return float32(f), nil
+		}
+	case float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, 64)
+		if err == nil {
+			return f, nil
+		}
+	default:
+		return nil, fmt.Errorf("%s is an array, which can't be set", key)
+	}
+
+	return nil, fmt.Errorf("invalid value %q for %s, which is a %T", value, key, kv[key])
+}
+
+func bitSize(v any) int {
+	switch v.(type) {
+	case uint8, int8:
+		return 8
+	case uint16, int16:
+		return 16
+	case uint32, int32:
+		return 32
+	default:
+		return 64
+	}
+}
+
+// OverrideKV writes the GGUF at path to w with the metadata in overrides,
+// parsed by [ParseKV], set. Its tensors are copied unchanged.
+func OverrideKV(w io.Writer, path string, overrides map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ggml, _, err := DecodeGGML(f, -1)
+	if err != nil {
+		return err
+	}
+
+	if ggml.Name() != "gguf" {
+		return fmt.Errorf("%s: not a GGUF file", path)
+	}
+
+	kv := maps.Clone(ggml.KV())
+
+	// the count is added when decoding
+	delete(kv, "general.parameter_count")
+
+	for key, value := range overrides {
+		if key == "general.parameter_count" || key == "general.alignment" {
+			return fmt.Errorf("%s can't be set", key)
+		}
+
+		v, err := ParseKV(kv, key, value)
+		if err != nil {
+			return err
+		}
+
+		kv[key] = v
+	}
+
+	tensors := ggml.Tensors()
+	ts := make([]Tensor, len(tensors.Items))
+	for i, t := range tensors.Items {
+		// WriteGGUF reverses shapes to the order ggml expects
+		shape := slices.Clone(t.Shape)
+		slices.Reverse(shape)
+
+		ts[i] = Tensor{
+			Name:     t.Name,
+			Kind:     t.Kind,
+			Shape:    shape,
+			WriterTo: sectionWriterTo{io.NewSectionReader(f, int64(tensors.Offset+t.Offset), int64(t.Size()))},
+		}
+	}
+
+	return WriteGGUF(w, kv, ts)
+}
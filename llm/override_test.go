@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/go-bfloat16"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKV(t *testing.T) {
+	kv := KV{
+		"llama.context_length": uint32(8192),
+		"llama.rope.freq_base": float32(10000),
+		"tokenizer.ggml.pre":   "llama-bpe",
+		"general.flag":         true,
+		"tokenizer.ggml.tokens": &array{
+			values: []any{"a", "b"},
+		},
+	}
+
+	cases := []struct {
+		key, value string
+		expect     any
+		err        string
+	}{
+		{"llama.context_length", "131072", uint32(131072), ""},
+		{"llama.context_length", "-1", nil, `invalid value "-1" for llama.context_length, which is a uint32`},
+		{"llama.rope.freq_base", "500000", float32(500000), ""},
+		{"tokenizer.ggml.pre", "qwen2", "qwen2", ""},
+		{"general.flag", "false", false, ""},
+		{"tokenizer.ggml.tokens", "c", nil, "tokenizer.ggml.tokens is an array, which can't be set"},
+		{"llama.rope.scaling.factor", "8", uint32(8), ""},
+		{"llama.rope.scaling.factor", "8.0", float32(8), ""},
+		{"llama.rope.scaling.type", "linear", "linear", ""},
+	}
+
+	for _, tt := range cases {
+		v, err := ParseKV(kv, tt.key, tt.value)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.key)
+			continue
+		}
+
+		require.NoError(t, err, tt.key)
+		assert.Equal(t, tt.expect, v, tt.key)
+	}
+}
+
+func TestOverrideKV(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "model.gguf")
+	writeSplit(t, path, KV{
+		"general.architecture":  "llama",
+		"llama.rope.freq_base":  float32(10000),
+		"tokenizer.ggml.tokens": []string{"a", "b"},
+	}, []Tensor{
+		f32Tensor(t, "output.weight", []uint64{2}, 13, 14),
+	})
+
+	f, err := os.Create(filepath.Join(dir, "override.gguf"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, OverrideKV(f, path, map[string]string{
+		"llama.rope.freq_base": "500000",
+		"llama.context_length": "131072",
+	}))
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	ggml, _, err := DecodeGGML(f, -1)
+	require.NoError(t, err)
+
+	kv := ggml.KV()
+	assert.Equal(t, float32(500000), kv["llama.rope.freq_base"])
+	assert.Equal(t, uint64(131072), kv.ContextLength())
+	assert.Equal(t, []any{"a", "b"}, kv["tokenizer.ggml.tokens"].(*array).values)
+	assert.Equal(t, uint64(2), kv.ParameterCount())
+
+	err = OverrideKV(io.Discard, path, map[string]string{"general.alignment": "64"})
+	assert.EqualError(t, err, "general.alignment can't be set")
+
+	t.Run("alignment", func(t *testing.T) {
+		path := filepath.Join(dir, "aligned.gguf")
+		writeSplit(t, path, KV{
+			"general.architecture": "llama",
+			"general.alignment":    uint32(64),
+		}, []Tensor{
+			bf16Tensor(t, "blk.0.attn_q.weight", []uint64{3}, 1, 2, 3),
+			bf16Tensor(t, "output.weight", []uint64{2}, 13, 14),
+		})
+
+		f, err := os.Create(filepath.Join(dir, "aligned-override.gguf"))
+		require.NoError(t, err)
+		defer f.Close()
+
+		require.NoError(t, OverrideKV(f, path, map[string]string{"llama.context_length": "8192"}))
+
+		_, err = f.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+
+		ggml, _, err := DecodeGGML(f, -1)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(64), ggml.KV()["general.alignment"])
+
+		tensors := ggml.Tensors()
+		expect := map[string][]float32{
+			"blk.0.attn_q.weight": {1, 2, 3},
+			"output.weight":       {13, 14},
+		}
+
+		require.Len(t, tensors.Items, len(expect))
+		for _, tensor := range tensors.Items {
+			assert.Zero(t, (tensors.Offset+tensor.Offset)%64, tensor.Name)
+
+			bts := make([]byte, tensor.Size())
+			_, err := f.ReadAt(bts, int64(tensors.Offset+tensor.Offset))
+			require.NoError(t, err)
+			assert.Equal(t, expect[tensor.Name], bfloat16.DecodeFloat32(bts), tensor.Name)
+		}
+	})
+}
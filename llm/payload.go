@@ -117,26 +117,8 @@ func serversForGpu(info gpu.GpuInfo) []string {
 
 	if !(runtime.GOOS == "darwin" && runtime.GOARCH == "arm64") {
 		// Load up the best CPU variant if not primary requested
-		if info.Library != "cpu" {
-			variant := gpu.GetCPUCapability()
-			// If no variant, then we fall back to default
-			// If we have a variant, try that if we find an exact match
-			// Attempting to run the wrong CPU instructions will panic the
-			// process
-			if variant != gpu.CPUCapabilityNone {
-				for cmp := range availableServers {
-					if cmp == "cpu_"+variant.String() {
-						servers = append(servers, cmp)
-						break
-					}
-				}
-			} else {
-				servers = append(servers, "cpu")
-			}
-		}
-
-		if len(servers) == 0 {
-			servers = []string{"cpu"}
+		if info.Library != "cpu" || len(servers) == 0 {
+			servers = append(servers, cpuServer(availableServers, gpu.GetCPUCapability()))
 		}
 	}
 
@@ -148,15 +130,20 @@ func serverForCpu() string {
 	if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
 		return "metal"
 	}
-	variant := gpu.GetCPUCapability()
-	availableServers := getAvailableServers()
-	if variant != gpu.CPUCapabilityNone {
-		for cmp := range availableServers {
-			if cmp == "cpu_"+variant.String() {
-				return cmp
-			}
+	return cpuServer(getAvailableServers(), gpu.GetCPUCapability())
+}
+
+// cpuServer returns the CPU server for the most of the vector extensions in
+// variant that one was built for. Attempting to run the wrong CPU
+// instructions will panic the process, so it never picks a server for
+// extensions beyond variant.
+func cpuServer(availableServers map[string]string, variant gpu.CPUCapability) string {
+	for v := variant; v > gpu.CPUCapabilityNone; v-- {
+		if _, ok := availableServers["cpu_"+v.String()]; ok {
+			return "cpu_" + v.String()
 		}
 	}
+
 	return "cpu"
 }
 
@@ -0,0 +1,20 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ollama/ollama/gpu"
+)
+
+func TestCPUServer(t *testing.T) {
+	available := map[string]string{"cpu": "", "cpu_avx": "", "cpu_avx2": "", "cpu_avx512": ""}
+
+	assert.Equal(t, "cpu_avx512", cpuServer(available, gpu.CPUCapabilityAVX512))
+	assert.Equal(t, "cpu_avx2", cpuServer(available, gpu.CPUCapabilityAVX2))
+	assert.Equal(t, "cpu", cpuServer(available, gpu.CPUCapabilityNone))
+
+	delete(available, "cpu_avx2")
+	assert.Equal(t, "cpu_avx", cpuServer(available, gpu.CPUCapabilityAVX2))
+}
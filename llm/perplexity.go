@@ -0,0 +1,92 @@
+package llm
+
+// #cgo CFLAGS: -Illama.cpp -Illama.cpp/include -Illama.cpp/ggml/include
+// #include <stdlib.h>
+// #include "llama.h"
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"unsafe"
+)
+
+// Perplexity evaluates the model at path on up to [ImatrixChunkSize] tokens of
+// text and returns its perplexity, the exponential of the mean negative log
+// likelihood of each token given the ones before it.
+func Perplexity(ctx context.Context, path, text string) (float64, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	mparams := C.llama_model_default_params()
+	mparams.n_gpu_layers = 0
+
+	model := C.llama_load_model_from_file(cpath, mparams)
+	if model == nil {
+		return 0, fmt.Errorf("failed to load model %s", path)
+	}
+	defer C.llama_free_model(model)
+
+	tokens, err := calibrationTokens(model, text)
+	if err != nil {
+		return 0, err
+	}
+
+	tokens = tokens[:min(len(tokens), ImatrixChunkSize)]
+	if len(tokens) < 2 {
+		return 0, errors.New("text is too short to compute perplexity")
+	}
+
+	cparams := C.llama_context_default_params()
+	cparams.n_ctx = ImatrixChunkSize
+	cparams.n_batch = ImatrixChunkSize
+	cparams.n_ubatch = ImatrixChunkSize
+	cparams.n_threads = C.uint32_t(runtime.NumCPU())
+	cparams.n_threads_batch = C.uint32_t(runtime.NumCPU())
+	cparams.logits_all = true
+
+	lctx := C.llama_new_context_with_model(model, cparams)
+	if lctx == nil {
+		return 0, errors.New("failed to create context for perplexity")
+	}
+	defer C.llama_free(lctx)
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	buf := (*C.llama_token)(C.malloc(C.size_t(len(tokens)) * C.size_t(unsafe.Sizeof(C.llama_token(0)))))
+	defer C.free(unsafe.Pointer(buf))
+
+	copy(unsafe.Slice(buf, len(tokens)), tokens)
+	batch := C.llama_batch_get_one(buf, C.int32_t(len(tokens)), 0, 0)
+	if rc := C.llama_decode(lctx, batch); rc != 0 {
+		return 0, fmt.Errorf("failed to evaluate text: %d", rc)
+	}
+
+	vocab := int(C.llama_n_vocab(model))
+
+	var nll float64
+	for i := range len(tokens) - 1 {
+		logits := unsafe.Slice((*float32)(unsafe.Pointer(C.llama_get_logits_ith(lctx, C.int32_t(i)))), vocab)
+
+		// log softmax of the next token, shifted by the largest logit so the
+		// exponentials don't overflow
+		top := float64(logits[0])
+		for _, logit := range logits {
+			top = max(top, float64(logit))
+		}
+
+		var sum float64
+		for _, logit := range logits {
+			sum += math.Exp(float64(logit) - top)
+		}
+
+		nll += math.Log(sum) + top - float64(logits[tokens[i+1]])
+	}
+
+	return math.Exp(nll / float64(len(tokens)-1)), nil
+}
@@ -0,0 +1,19 @@
+package llm
+
+import "context"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx for the API request with the given ID.
+// Completions with the context send it to the runner, which logs it with the
+// runner's own ID of the task.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID set by [WithRequestID] or an
+// empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
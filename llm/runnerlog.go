@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Runner log levels, from the least to the most verbose
+const (
+	LogLevelError = "error"
+	LogLevelWarn  = "warn"
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// maxRunnerLogSize is how large a runner's log file grows before it's
+// rotated, keeping maxRunnerLogBackups older files
+const (
+	maxRunnerLogSize    = 10 << 20
+	maxRunnerLogBackups = 3
+)
+
+// RunnerLog is how verbosely a runner logs and where to
+type RunnerLog struct {
+	// Level is one of the LogLevel constants, or info if it's empty
+	Level string
+
+	// File is the path of the file the runner logs to instead of the
+	// server's log, if any
+	File string
+}
+
+// logFile is a file which is rotated once it grows past maxSize
+type logFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+
+	f    *os.File
+	size int64
+}
+
+func openLogFile(path string, maxSize int64, backups int) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &logFile{path: path, maxSize: maxSize, backups: backups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+func (l *logFile) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate moves the log to path.1, path.1 to path.2 and so on, dropping the
+// oldest, and starts a new log
+func (l *logFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	for i := l.backups; i > 0; i-- {
+		older := fmt.Sprintf("%s.%d", l.path, i)
+		newer := l.path
+		if i > 1 {
+			newer = fmt.Sprintf("%s.%d", l.path, i-1)
+		}
+
+		if err := os.Rename(newer, older); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if l.backups == 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return l.open()
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
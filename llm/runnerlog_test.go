@@ -0,0 +1,50 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogFileRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "llama3-latest.log")
+	l, err := openLogFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := l.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	read := func(path string) string {
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// reopening appends to the latest log
+	l, err = openLogFile(path, 100, 2)
+	require.NoError(t, err)
+	_, err = l.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	assert.Equal(t, "fourth\nfifth\n", read(path))
+
+	// writes larger than the limit aren't split
+	l, err = openLogFile(path, 10, 0)
+	require.NoError(t, err)
+	_, err = l.Write([]byte(strings.Repeat("x", 20)))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	assert.Equal(t, strings.Repeat("x", 20), read(path))
+}
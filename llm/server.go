@@ -10,6 +10,7 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
@@ -17,12 +18,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/sync/semaphore"
-
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
@@ -31,17 +31,67 @@ import (
 
 type LlamaServer interface {
 	Ping(ctx context.Context) error
-	WaitUntilRunning(ctx context.Context) error
+	Slots(ctx context.Context) ([]SlotStatus, error)
+	Waiting() int
+	WaitUntilRunning(ctx context.Context, timeout time.Duration) error
 	Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error
 	Embedding(ctx context.Context, input string) ([]float32, error)
+	Vocode(ctx context.Context, codes []int) ([]float32, error)
 	Tokenize(ctx context.Context, content string) ([]int, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
 	Close() error
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
 	EstimatedVRAMByGPU(gpuID string) uint64
+	EstimatedKVCache() uint64
+	KVCacheType() string
+	Attention() string
+	BatchSize() (batch, ubatch int)
+	CUDAGraphs() bool
+	HostMemory() (mmap, mlock, pinned bool)
+	GPULayers() int
+}
+
+// ErrOutOfMemory is wrapped by the errors of runners which failed to allocate
+// GPU memory
+var ErrOutOfMemory = errors.New("out of GPU memory")
+
+// CrashError is returned by runners whose process exited while serving
+// requests
+type CrashError struct {
+	ExitStatus string   // how the process exited, e.g. "signal: segmentation fault"
+	Message    string   // the last error it logged, if any
+	Log        []string // the last lines it logged
+}
+
+func (e *CrashError) Error() string {
+	msg := "llama runner process has terminated: " + e.ExitStatus
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
 }
 
+// QueueTimeoutError is returned when a request waits to be scheduled, or for
+// a slot on its runner, longer than its queue timeout
+type QueueTimeoutError struct {
+	Timeout  time.Duration
+	Position int // number of requests ahead of this one, including itself
+}
+
+func (e *QueueTimeoutError) Error() string {
+	return fmt.Sprintf("server busy, timed out after %s waiting in queue at position %d", e.Timeout, e.Position)
+}
+
+// crashLogLines is the number of lines of a crashed runner's output reported
+const crashLogLines = 20
+
+// Attention implementations reported by [LlamaServer.Attention]
+const (
+	AttentionStandard = "standard"
+	AttentionFlash    = "flash"
+)
+
 // llmServer is an instance of the llama.cpp server
 type llmServer struct {
 	port        int
@@ -50,6 +100,10 @@ type llmServer struct {
 	status      *StatusWriter
 	options     api.Options
 	numParallel int
+	attention   string
+	cudaGraphs  bool
+	mmap        bool
+	pinned      bool
 
 	estimate    MemoryEstimate
 	totalLayers uint64
@@ -58,7 +112,9 @@ type llmServer struct {
 	loadDuration time.Duration   // Record how long it took the model to load
 	loadProgress float32
 
-	sem *semaphore.Weighted
+	sem          *fairSemaphore
+	adapters     *adapterGate
+	adapterPaths []string
 }
 
 // LoadModel will load a model from disk. The model must be in the GGML format.
@@ -83,7 +139,7 @@ func LoadModel(model string, maxArraySize int) (*GGML, error) {
 
 // NewLlamaServer will run a server for the given GPUs
 // The gpu list must be a single family.
-func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, projectors []string, opts api.Options, numParallel int) (LlamaServer, error) {
+func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, projectors []string, vocoder string, opts api.Options, numParallel int, runnerLog RunnerLog) (LlamaServer, error) {
 	var err error
 	var cpuRunner string
 	var estimate MemoryEstimate
@@ -105,11 +161,21 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 	if opts.NumGPU == 0 {
 		gpus = gpu.GetCPUInfo()
 	}
+
+	// Layers are offloaded to RPC servers on other machines like local GPUs
+	var rpcGPUs gpu.GpuInfoList
+	if opts.RPCServers != "" && opts.NumGPU != 0 {
+		rpcGPUs, err = gpu.RPCGetGPUInfo(opts.RPCServers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if len(gpus) == 1 && gpus[0].Library == "cpu" {
 		cpuRunner = serverForCpu()
-		estimate = EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate = EstimateGPULayers(withRPC(gpus, rpcGPUs), ggml, projectors, opts)
 	} else {
-		estimate = EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate = EstimateGPULayers(withRPC(gpus, rpcGPUs), ggml, projectors, opts)
 
 		switch {
 		case gpus[0].Library == "metal" && estimate.VRAMSize > systemTotalMemory:
@@ -120,11 +186,29 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 			// Don't bother loading into the GPU if no layers can fit
 			cpuRunner = serverForCpu()
 			gpus = gpu.GetCPUInfo()
+			estimate = EstimateGPULayers(withRPC(gpus, rpcGPUs), ggml, projectors, opts)
 		case opts.NumGPU < 0 && estimate.Layers > 0 && gpus[0].Library != "cpu":
 			opts.NumGPU = estimate.Layers
 		}
 	}
 
+	if len(rpcGPUs) > 0 {
+		if opts.NumGPU < 0 {
+			opts.NumGPU = estimate.Layers
+		}
+
+		if opts.NumGPU == 0 {
+			rpcGPUs = nil
+		}
+	}
+
+	// local GPUs, if any, are ahead of the RPC servers in the estimate and
+	// tensor_split, but llama.cpp puts RPC servers first
+	numLocal := len(gpus)
+	if gpus[0].Library == "cpu" {
+		numLocal = 0
+	}
+
 	// On linux and windows, over-allocating CPU memory will almost always result in an error
 	// Darwin has fully dynamic swap so has no direct concept of free swap space
 	if runtime.GOOS != "darwin" {
@@ -136,15 +220,23 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		}
 	}
 
+	split, err := requestedTensorSplit(opts.TensorSplit, numLocal+len(rpcGPUs))
+	if err != nil {
+		return nil, err
+	}
+	opts.TensorSplit = split
+
+	if numLocal > 0 {
+		if opts.MainGPU >= len(gpus) {
+			return nil, fmt.Errorf("main_gpu %d is out of range, only %d GPUs are available", opts.MainGPU, len(gpus))
+		}
+	}
+
 	estimate.log()
 
 	// Loop through potential servers
 	finalErr := errors.New("no suitable llama servers found")
 
-	if len(adapters) > 1 {
-		return nil, errors.New("ollama supports only one lora adapter, but multiple were provided")
-	}
-
 	availableServers := getAvailableServers()
 	if len(availableServers) == 0 {
 		if runtime.GOOS != "windows" {
@@ -191,23 +283,39 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		"--embedding",
 	}
 
+	if opts.NumUBatch > 0 {
+		params = append(params, "--ubatch-size", strconv.Itoa(opts.NumUBatch))
+	}
+
 	params = append(params, "--log-disable")
 
+	if opts.Pooling != "" {
+		params = append(params, "--pooling", opts.Pooling)
+	}
+
 	if opts.NumGPU >= 0 {
 		params = append(params, "--n-gpu-layers", strconv.Itoa(opts.NumGPU))
 	}
 
-	if envconfig.Debug() {
-		params = append(params, "--verbose")
+	if runnerLog.Level != "" {
+		params = append(params, "--log-level", runnerLog.Level)
 	}
 
-	if opts.MainGPU > 0 {
-		params = append(params, "--main-gpu", strconv.Itoa(opts.MainGPU))
+	if numLocal > 0 && opts.MainGPU+len(rpcGPUs) > 0 {
+		params = append(params, "--main-gpu", strconv.Itoa(opts.MainGPU+len(rpcGPUs)))
+	}
+
+	if len(rpcGPUs) > 0 {
+		addrs := make([]string, len(rpcGPUs))
+		for i, g := range rpcGPUs {
+			addrs[i] = g.ID
+		}
+		params = append(params, "--rpc", strings.Join(addrs, ","))
 	}
 
-	if len(adapters) > 0 {
-		// TODO: applying multiple adapters is not supported by the llama.cpp server yet
-		params = append(params, "--lora", adapters[0])
+	for i, adapter := range adapters {
+		scale := adapterScale(opts.AdapterScale, i)
+		params = append(params, "--lora-scaled", adapter, strconv.FormatFloat(float64(scale), 'f', -1, 32))
 	}
 
 	if len(projectors) > 0 {
@@ -215,42 +323,36 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		params = append(params, "--mmproj", projectors[0])
 	}
 
+	if vocoder != "" {
+		params = append(params, "--vocoder", vocoder)
+	}
+
 	if opts.NumThread > 0 {
 		params = append(params, "--threads", strconv.Itoa(opts.NumThread))
 	}
 
-	if !opts.F16KV {
+	switch estimate.KVCacheType {
+	case "f32":
 		params = append(params, "--memory-f32")
+	case "f16":
+	default:
+		params = append(params, "--cache-type-k", estimate.KVCacheType, "--cache-type-v", estimate.KVCacheType)
 	}
 
-	flashAttnEnabled := envconfig.FlashAttention()
-
-	for _, g := range gpus {
-		// only cuda (compute capability 7+) and metal support flash attention
-		if g.Library != "metal" && (g.Library != "cuda" || g.DriverMajor < 7) {
-			flashAttnEnabled = false
-		}
-
-		// mmap has issues with partial offloading on metal
-		if g.Library == "metal" &&
-			uint64(opts.NumGPU) > 0 &&
-			uint64(opts.NumGPU) < ggml.KV().BlockCount()+1 {
-			opts.UseMMap = new(bool)
-			*opts.UseMMap = false
-		}
+	attention := AttentionStandard
+	if flashAttention(gpus, opts) {
+		attention = AttentionFlash
+		params = append(params, "--flash-attn")
+	} else if flashAttentionRequested(opts) {
+		slog.Warn("flash attention is not supported, falling back to standard attention", "library", gpus[0].Library)
 	}
 
-	if flashAttnEnabled {
-		params = append(params, "--flash-attn")
+	if t := requestedKVCacheType(opts); t != "" && t != estimate.KVCacheType {
+		slog.Warn("kv cache type is not supported, falling back", "requested", t, "type", estimate.KVCacheType)
 	}
 
-	// Windows CUDA should not use mmap for best performance
-	// Linux  with a model larger than free space, mmap leads to thrashing
-	// For CPU loads we want the memory to be allocated, not FS cache
-	if (runtime.GOOS == "windows" && gpus[0].Library == "cuda" && opts.UseMMap == nil) ||
-		(runtime.GOOS == "linux" && systemFreeMemory < estimate.TotalSize && opts.UseMMap == nil) ||
-		(gpus[0].Library == "cpu" && opts.UseMMap == nil) ||
-		(opts.UseMMap != nil && !*opts.UseMMap) {
+	mmap := useMMap(gpus, opts, len(adapters), ggml.KV().BlockCount(), estimate.TotalSize, systemFreeMemory)
+	if !mmap {
 		params = append(params, "--no-mmap")
 	}
 
@@ -270,8 +372,32 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 
 	params = append(params, "--parallel", strconv.Itoa(numParallel))
 
-	if estimate.TensorSplit != "" {
-		params = append(params, "--tensor-split", estimate.TensorSplit)
+	if opts.MaxTokensPerSecond > 0 {
+		params = append(params, "--max-tokens-per-second", strconv.FormatFloat(float64(opts.MaxTokensPerSecond), 'f', -1, 32))
+	}
+
+	tensorSplit := estimate.TensorSplit
+	if opts.TensorSplit != "" && numLocal+len(rpcGPUs) > 1 {
+		tensorSplit = opts.TensorSplit
+	}
+
+	if tensorSplit != "" {
+		if len(rpcGPUs) > 0 {
+			tensorSplit = rpcTensorSplit(tensorSplit, numLocal, len(rpcGPUs))
+		}
+		params = append(params, "--tensor-split", tensorSplit)
+	}
+
+	// the runner logs to the server's log unless it has its own file
+	var file *logFile
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if runnerLog.File != "" {
+		if file, err = openLogFile(runnerLog.File, maxRunnerLogSize, maxRunnerLogBackups); err != nil {
+			slog.Warn("failed to open runner log, logging to the server log", "path", runnerLog.File, "error", err)
+		} else {
+			slog.Info("runner logging to file", "model", model, "path", runnerLog.File)
+			stdout, stderr = file, file
+		}
 	}
 
 	for i := range len(servers) {
@@ -335,25 +461,34 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 			err = Init()
 			if err != nil {
 				slog.Warn("failed to reinitialize payloads", "error", err)
+				if file != nil {
+					file.Close()
+				}
 				return nil, err
 			}
 		}
 
 		s := &llmServer{
-			port:        port,
-			cmd:         exec.Command(server, finalParams...),
-			status:      NewStatusWriter(os.Stderr),
-			options:     opts,
-			estimate:    estimate,
-			numParallel: numParallel,
-			sem:         semaphore.NewWeighted(int64(numParallel)),
-			totalLayers: ggml.KV().BlockCount() + 1,
-			gpus:        gpus,
-			done:        make(chan error, 1),
+			port:         port,
+			cmd:          exec.Command(server, finalParams...),
+			status:       NewStatusWriter(stderr),
+			options:      opts,
+			estimate:     estimate,
+			numParallel:  numParallel,
+			attention:    attention,
+			cudaGraphs:   cudaGraphs(gpus, opts),
+			mmap:         mmap,
+			pinned:       pinnedMemory(gpus, opts, mmap),
+			sem:          newFairSemaphore(numParallel),
+			adapters:     newAdapterGate(),
+			adapterPaths: adapters,
+			totalLayers:  ggml.KV().BlockCount() + 1,
+			gpus:         gpus,
+			done:         make(chan error, 1),
 		}
 
 		s.cmd.Env = os.Environ()
-		s.cmd.Stdout = os.Stdout
+		s.cmd.Stdout = stdout
 		s.cmd.Stderr = s.status
 		s.cmd.SysProcAttr = LlamaServerSysProcAttr
 
@@ -389,6 +524,24 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		if devicesNeeded {
 			s.cmd.Env = append(s.cmd.Env, visibleDevicesEnv+"="+visibleDevicesEnvVal)
 		}
+		if opts.CUDAGraphs != nil {
+			// llama.cpp disables graphs if the variable is set to anything
+			s.cmd.Env = slices.DeleteFunc(s.cmd.Env, func(ev string) bool {
+				return strings.HasPrefix(ev, "GGML_CUDA_DISABLE_GRAPHS=")
+			})
+			if !*opts.CUDAGraphs {
+				s.cmd.Env = append(s.cmd.Env, "GGML_CUDA_DISABLE_GRAPHS=1")
+			}
+		}
+		if opts.UsePinned != nil {
+			// likewise for pinned memory
+			s.cmd.Env = slices.DeleteFunc(s.cmd.Env, func(ev string) bool {
+				return strings.HasPrefix(ev, "GGML_CUDA_NO_PINNED=")
+			})
+			if !*opts.UsePinned {
+				s.cmd.Env = append(s.cmd.Env, "GGML_CUDA_NO_PINNED=1")
+			}
+		}
 
 		slog.Info("starting llama server", "cmd", s.cmd.String())
 		if envconfig.Debug() {
@@ -428,6 +581,10 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		// reap subprocess when it exits
 		go func() {
 			err := s.cmd.Wait()
+			if file != nil {
+				file.Close()
+			}
+
 			// Favor a more detailed message over the process exit status
 			if err != nil && s.status != nil && s.status.LastErrMsg != "" {
 				slog.Debug("llama runner terminated", "error", err)
@@ -443,6 +600,10 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 		return s, nil
 	}
 
+	if file != nil {
+		file.Close()
+	}
+
 	slog.Error("unable to load any llama server", "error", finalErr)
 	return nil, finalErr
 }
@@ -503,15 +664,11 @@ type ServerStatusResp struct {
 func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
 	// Fail fast if its exited
 	if s.cmd.ProcessState != nil {
-		msg := ""
-		if s.status != nil && s.status.LastErrMsg != "" {
-			msg = s.status.LastErrMsg
-		}
 		if s.cmd.ProcessState.ExitCode() == -1 {
 			// Most likely a signal killed it, log some more details to try to help troubleshoot
 			slog.Warn("llama runner process no longer running", "sys", s.cmd.ProcessState.Sys(), "string", s.cmd.ProcessState.String())
 		}
-		return ServerStatusError, fmt.Errorf("llama runner process no longer running: %d %s", s.cmd.ProcessState.ExitCode(), msg)
+		return ServerStatusError, s.crashError()
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/health", s.port), nil)
@@ -584,11 +741,11 @@ func (s *llmServer) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
+// WaitUntilRunning waits for the runner to load the model. Loading fails if it
+// makes no progress for timeout, or never when timeout is 0.
+func (s *llmServer) WaitUntilRunning(ctx context.Context, timeout time.Duration) error {
 	start := time.Now()
-	stallDuration := 5 * time.Minute            // If no progress happens
-	finalLoadDuration := 5 * time.Minute        // After we hit 100%, give the runner more time to come online
-	stallTimer := time.Now().Add(stallDuration) // give up if we stall
+	stallTimer := time.Now().Add(timeout) // give up if we stall
 
 	slog.Info("waiting for llama runner to start responding")
 	var lastStatus ServerStatus = -1
@@ -600,23 +757,23 @@ func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 			slog.Warn("client connection closed before server finished loading, aborting load")
 			return fmt.Errorf("timed out waiting for llama runner to start: %w", ctx.Err())
 		case err := <-s.done:
-			return fmt.Errorf("llama runner process has terminated: %w", err)
+			return s.outOfMemory(fmt.Errorf("llama runner process has terminated: %w", err))
 		default:
 		}
-		if time.Now().After(stallTimer) {
+		if timeout > 0 && time.Now().After(stallTimer) {
 			// timeout
 			msg := ""
 			if s.status != nil && s.status.LastErrMsg != "" {
 				msg = s.status.LastErrMsg
 			}
-			return fmt.Errorf("timed out waiting for llama runner to start - progress %0.2f - %s", s.loadProgress, msg)
+			return s.outOfMemory(fmt.Errorf("timed out waiting for llama runner to start after %s without progress - progress %0.2f - %s", timeout, s.loadProgress, msg))
 		}
 		if s.cmd.ProcessState != nil {
 			msg := ""
 			if s.status != nil && s.status.LastErrMsg != "" {
 				msg = s.status.LastErrMsg
 			}
-			return fmt.Errorf("llama runner process no longer running: %d %s", s.cmd.ProcessState.ExitCode(), msg)
+			return s.outOfMemory(fmt.Errorf("llama runner process no longer running: %d %s", s.cmd.ProcessState.ExitCode(), msg))
 		}
 		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
 		defer cancel()
@@ -630,16 +787,23 @@ func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 		case ServerStatusReady:
 			s.loadDuration = time.Since(start)
 			slog.Info(fmt.Sprintf("llama runner started in %0.2f seconds", s.loadDuration.Seconds()))
+			if s.options.UseMLock && s.status.MLockFailed {
+				slog.Warn("model could not be locked in memory, raise the locked memory limit (ulimit -l) of the server to lock it")
+			}
+			if s.pinned && s.status.PinnedFailed {
+				slog.Warn("pinned memory could not be allocated, falling back to pageable memory")
+			}
 			return nil
 		default:
 			lastStatus = status
 			// Reset the timer as long as we're making forward progress on the load
 			if priorProgress != s.loadProgress {
 				slog.Debug(fmt.Sprintf("model load progress %0.2f", s.loadProgress))
-				stallTimer = time.Now().Add(stallDuration)
+				stallTimer = time.Now().Add(timeout)
 			} else if !fullyLoaded && int(s.loadProgress*100.0) >= 100 {
+				// give the runner time to come online
 				slog.Debug("model load completed, waiting for server to become available", "status", status.ToString())
-				stallTimer = time.Now().Add(finalLoadDuration)
+				stallTimer = time.Now().Add(timeout)
 				fullyLoaded = true
 			}
 			time.Sleep(time.Millisecond * 250)
@@ -648,6 +812,16 @@ func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 	}
 }
 
+// outOfMemory wraps err, an error of the runner, with ErrOutOfMemory if the
+// runner failed to allocate GPU memory
+func (s *llmServer) outOfMemory(err error) error {
+	if s.status != nil && s.status.OutOfMemory {
+		return fmt.Errorf("%w: %w", ErrOutOfMemory, err)
+	}
+
+	return err
+}
+
 const jsonGrammar = `
 root   ::= object
 value  ::= object | array | string | number | ("true" | "false" | "null") ws
@@ -684,25 +858,50 @@ type ImageData struct {
 }
 
 type completion struct {
-	Content      string `json:"content"`
-	Model        string `json:"model"`
-	Prompt       string `json:"prompt"`
-	Stop         bool   `json:"stop"`
-	StoppedLimit bool   `json:"stopped_limit"`
+	Content         string  `json:"content"`
+	Model           string  `json:"model"`
+	Prompt          string  `json:"prompt"`
+	Stop            bool    `json:"stop"`
+	StoppedLimit    bool    `json:"stopped_limit"`
+	TokensDiscarded int     `json:"tokens_discarded"`
+	ImageTokens     []int   `json:"image_tokens"`
+	LogProb         float64 `json:"logprob"`
 
 	Timings struct {
-		PredictedN  int     `json:"predicted_n"`
-		PredictedMS float64 `json:"predicted_ms"`
-		PromptN     int     `json:"prompt_n"`
-		PromptMS    float64 `json:"prompt_ms"`
+		PredictedN    int     `json:"predicted_n"`
+		PredictedMS   float64 `json:"predicted_ms"`
+		PromptN       int     `json:"prompt_n"`
+		PromptCachedN int     `json:"prompt_cached_n"`
+		PromptMS      float64 `json:"prompt_ms"`
 	}
 }
 
+// Policies for input or generated text which exceeds the context window, see
+// [api.Options.ContextOverflow]
+const (
+	// ContextOverflowError rejects input which doesn't fit and stops
+	// generating when the context is full
+	ContextOverflowError = "error"
+	// ContextOverflowTruncate drops the oldest input to make it fit and stops
+	// generating when the context is full
+	ContextOverflowTruncate = "truncate"
+	// ContextOverflowShift drops the oldest input to make it fit and shifts
+	// the oldest tokens out of the context when it is full
+	ContextOverflowShift = "shift"
+	// ContextOverflowMiddle keeps the start and the end of the input and
+	// drops tokens from the middle of the context when it is full
+	ContextOverflowMiddle = "middle"
+)
+
 type CompletionRequest struct {
 	Prompt  string
 	Format  string
 	Images  []ImageData
 	Options *api.Options
+
+	// Adapters are paths to LoRA adapters to apply instead of the ones the
+	// model was loaded with. Options.AdapterScale holds their scales.
+	Adapters []string
 }
 
 type CompletionResponse struct {
@@ -711,27 +910,149 @@ type CompletionResponse struct {
 	Done               bool
 	PromptEvalCount    int
 	PromptEvalDuration time.Duration
+	PromptCacheCount   int
+	DiscardedCount     int
 	EvalCount          int
 	EvalDuration       time.Duration
+
+	// ImageTokens is the number of prompt tokens each image took
+	ImageTokens []int
+
+	// LogProb is the sum of the log probabilities of the generated tokens
+	// in the distributions they were sampled from, which is 0 if they were
+	// picked greedily
+	LogProb float64
+}
+
+// acquire waits for a slot for the request of ctx, or returns a
+// *QueueTimeoutError if its queue deadline passes first
+func (s *llmServer) acquire(ctx context.Context) error {
+	q, ok := ctx.Value(queueDeadlineKey{}).(queueDeadline)
+	if !ok {
+		return s.sem.Acquire(ctx, ClientFromContext(ctx))
+	}
+
+	waitCtx, cancel := context.WithDeadline(ctx, q.deadline)
+	defer cancel()
+
+	if err := s.sem.Acquire(waitCtx, ClientFromContext(ctx)); err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return &QueueTimeoutError{Timeout: q.timeout, Position: s.sem.Waiting() + 1}
+		}
+
+		return err
+	}
+
+	return nil
 }
 
 func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
-	if err := s.sem.Acquire(ctx, 1); err != nil {
-		slog.Error("Failed to acquire semaphore", "error", err)
+	if err := s.acquire(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to acquire semaphore", "error", err)
 		return err
 	}
-	defer s.sem.Release(1)
+	defer s.sem.Release()
+
+	// requests without adapters of their own apply the model's adapters so
+	// their scales can change per request
+	adapters := req.Adapters
+	if len(adapters) == 0 {
+		adapters = s.adapterPaths
+	}
 
+	lora, key := loraAdapters(adapters, req.Options.AdapterScale)
+	if err := s.adapters.Acquire(ctx, key); err != nil {
+		return err
+	}
+	defer s.adapters.Release()
+
+	// best_of is pointless without sampling, as every candidate would be the
+	// same
+	if req.Options.BestOf > 1 && req.Options.Temperature > 0 {
+		return s.bestOf(ctx, req, lora, fn)
+	}
+
+	return s.complete(ctx, req, lora, fn)
+}
+
+// bestOf generates req.Options.BestOf candidate completions of req one after
+// another and responds with the one whose tokens were the most likely on
+// average. The chosen completion is sent whole once every candidate has been
+// generated, and its eval count and duration are of every candidate.
+func (s *llmServer) bestOf(ctx context.Context, req CompletionRequest, lora []map[string]any, fn func(CompletionResponse)) error {
+	var best, first CompletionResponse
+	var content string
+	var evalCount int
+	var evalDuration time.Duration
+	bestScore := math.Inf(-1)
+	for i := range req.Options.BestOf {
+		candidate := req
+		if req.Options.Seed >= 0 {
+			// candidates with the same seed would be the same
+			opts := *req.Options
+			opts.Seed += i
+			candidate.Options = &opts
+		}
+
+		var sb strings.Builder
+		var done CompletionResponse
+		if err := s.complete(ctx, candidate, lora, func(r CompletionResponse) {
+			sb.WriteString(r.Content)
+			if r.Done {
+				done = r
+			}
+		}); err != nil {
+			return err
+		}
+
+		if i == 0 {
+			// the prompt of later candidates is cached
+			first = done
+		}
+
+		evalCount += done.EvalCount
+		evalDuration += done.EvalDuration
+
+		score := done.LogProb / float64(max(done.EvalCount, 1))
+		slog.DebugContext(ctx, "best_of candidate", "candidate", i, "tokens", done.EvalCount, "score", score)
+		if score > bestScore {
+			best, content, bestScore = done, sb.String(), score
+		}
+	}
+
+	if content != "" {
+		fn(CompletionResponse{Content: content})
+	}
+
+	best.PromptEvalCount = first.PromptEvalCount
+	best.PromptEvalDuration = first.PromptEvalDuration
+	best.PromptCacheCount = first.PromptCacheCount
+	best.EvalCount = evalCount
+	best.EvalDuration = evalDuration
+	fn(best)
+	return nil
+}
+
+// complete generates the completion of req with lora applied, once the
+// caller has acquired the runner and its adapters
+func (s *llmServer) complete(ctx context.Context, req CompletionRequest, lora []map[string]any, fn func(CompletionResponse)) error {
 	// put an upper limit on num_predict to avoid the model running on forever
 	if req.Options.NumPredict < 0 || req.Options.NumPredict > 10*s.options.NumCtx {
 		req.Options.NumPredict = 10 * s.options.NumCtx
 	}
 
+	nKeep := req.Options.NumKeep
+	if req.Options.ContextOverflow == ContextOverflowMiddle {
+		// keep the first half of the context when dropping tokens
+		nKeep = max(nKeep, req.Options.NumCtx/2)
+	}
+
 	request := map[string]any{
 		"prompt":            req.Prompt,
 		"stream":            true,
 		"n_predict":         req.Options.NumPredict,
-		"n_keep":            req.Options.NumKeep,
+		"n_keep":            nKeep,
+		"context_shift":     req.Options.ContextOverflow != ContextOverflowError && req.Options.ContextOverflow != ContextOverflowTruncate,
 		"main_gpu":          req.Options.MainGPU,
 		"temperature":       req.Options.Temperature,
 		"top_k":             req.Options.TopK,
@@ -753,6 +1074,10 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		"cache_prompt":      true,
 	}
 
+	if id := RequestIDFromContext(ctx); id != "" {
+		request["request_id"] = id
+	}
+
 	// Make sure the server is ready
 	status, err := s.getServerStatusRetry(ctx)
 	if err != nil {
@@ -761,10 +1086,17 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		return fmt.Errorf("unexpected server status: %s", status.ToString())
 	}
 
-	if req.Format == "json" {
-		request["grammar"] = jsonGrammar
+	if len(lora) > 0 {
+		request["lora"] = lora
+	}
+
+	grammar, err := FormatGrammar(req.Format)
+	if err != nil {
+		return err
+	} else if grammar != "" {
+		request["grammar"] = grammar
 		if !strings.Contains(strings.ToLower(req.Prompt), "json") {
-			slog.Warn("Prompt does not specify that the LLM should response in JSON, but JSON format is expected. For best results specify that JSON is expected in the system prompt.")
+			slog.WarnContext(ctx, "Prompt does not specify that the LLM should response in JSON, but JSON format is expected. For best results specify that JSON is expected in the system prompt.")
 		}
 	}
 
@@ -838,7 +1170,7 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 
 			// 30 picked as an arbitrary max token repeat limit, modify as needed
 			if tokenRepeat > 30 {
-				slog.Debug("prediction aborted, token repeat limit reached")
+				slog.DebugContext(ctx, "prediction aborted, token repeat limit reached")
 				return ctx.Err()
 			}
 
@@ -859,8 +1191,12 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 					DoneReason:         doneReason,
 					PromptEvalCount:    c.Timings.PromptN,
 					PromptEvalDuration: parseDurationMs(c.Timings.PromptMS),
+					PromptCacheCount:   c.Timings.PromptCachedN,
+					DiscardedCount:     c.TokensDiscarded,
 					EvalCount:          c.Timings.PredictedN,
 					EvalDuration:       parseDurationMs(c.Timings.PredictedMS),
+					ImageTokens:        c.ImageTokens,
+					LogProb:            c.LogProb,
 				})
 				return nil
 			}
@@ -870,11 +1206,9 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 	if err := scanner.Err(); err != nil {
 		if strings.Contains(err.Error(), "unexpected EOF") {
 			s.Close()
-			msg := ""
-			if s.status != nil && s.status.LastErrMsg != "" {
-				msg = s.status.LastErrMsg
-			}
-			return fmt.Errorf("an unknown error was encountered while running the model %s", msg)
+			crash := s.crashError()
+			slog.ErrorContext(ctx, "llama runner crashed while generating", "status", crash.ExitStatus, "error", crash.Message, "log", strings.Join(crash.Log, "\n"))
+			return s.outOfMemory(crash)
 		}
 
 		return fmt.Errorf("error reading llm response: %v", err)
@@ -892,11 +1226,18 @@ type EmbeddingResponse struct {
 }
 
 func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, error) {
-	if err := s.sem.Acquire(ctx, 1); err != nil {
+	if err := s.acquire(ctx); err != nil {
 		slog.Error("Failed to acquire semaphore", "error", err)
 		return nil, err
 	}
-	defer s.sem.Release(1)
+	defer s.sem.Release()
+
+	// embeddings use the adapters the model was loaded with
+	_, key := loraAdapters(s.adapterPaths, s.options.AdapterScale)
+	if err := s.adapters.Acquire(ctx, key); err != nil {
+		return nil, err
+	}
+	defer s.adapters.Release()
 
 	// Make sure the server is ready
 	status, err := s.getServerStatusRetry(ctx)
@@ -941,6 +1282,66 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 	return e.Embedding, nil
 }
 
+type VocodeRequest struct {
+	Codes []int `json:"codes"`
+}
+
+type VocodeResponse struct {
+	Frames []float32 `json:"frames"`
+	NEmbd  int       `json:"n_embd"`
+}
+
+// Vocode decodes the audio codes generated by a text-to-speech model into
+// audio samples at [SpeechSampleRate]
+func (s *llmServer) Vocode(ctx context.Context, codes []int) ([]float32, error) {
+	if err := s.acquire(ctx); err != nil {
+		slog.Error("Failed to acquire semaphore", "error", err)
+		return nil, err
+	}
+	defer s.sem.Release()
+
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return nil, err
+	} else if status != ServerStatusReady {
+		return nil, fmt.Errorf("unexpected server status: %s", status.ToString())
+	}
+
+	data, err := json.Marshal(VocodeRequest{Codes: codes})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling vocode data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/vocode", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creating vocode request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("do vocode request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vocode response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		log.Printf("llm vocode error: %s", body)
+		return nil, fmt.Errorf("%s", body)
+	}
+
+	var v VocodeResponse
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, fmt.Errorf("unmarshal vocode response: %w", err)
+	}
+
+	return spectrogramToAudio(v.Frames, v.NEmbd), nil
+}
+
 type TokenizeRequest struct {
 	Content string `json:"content"`
 }
@@ -1045,6 +1446,21 @@ func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error
 	return decoded.Content, nil
 }
 
+// crashError describes how the runner process exited, once it has
+func (s *llmServer) crashError() *CrashError {
+	crash := &CrashError{ExitStatus: "unknown exit status"}
+	if s.cmd.ProcessState != nil {
+		crash.ExitStatus = s.cmd.ProcessState.String()
+	}
+
+	if s.status != nil {
+		crash.Message = s.status.LastErrMsg
+		crash.Log = s.status.Tail(crashLogLines)
+	}
+
+	return crash
+}
+
 func (s *llmServer) Close() error {
 	if s.cmd != nil {
 		slog.Debug("stopping llama server")
@@ -1071,9 +1487,43 @@ func (s *llmServer) EstimatedTotal() uint64 {
 	return s.estimate.TotalSize
 }
 
+func (s *llmServer) EstimatedKVCache() uint64 {
+	return s.estimate.KVCache
+}
+
+func (s *llmServer) KVCacheType() string {
+	return s.estimate.KVCacheType
+}
+
+func (s *llmServer) Attention() string {
+	return s.attention
+}
+
+func (s *llmServer) BatchSize() (batch, ubatch int) {
+	return s.options.NumBatch, ubatchSize(s.options)
+}
+
+func (s *llmServer) CUDAGraphs() bool {
+	return s.cudaGraphs
+}
+
+// HostMemory reports whether the parts of the model in system memory are
+// memory mapped, locked and pinned. Locking and pinning can fail, which the
+// runner logs while loading.
+func (s *llmServer) HostMemory() (mmap, mlock, pinned bool) {
+	return s.mmap, s.options.UseMLock && !s.status.MLockFailed, s.pinned && !s.status.PinnedFailed
+}
+
+// GPULayers is the number of layers offloaded to GPUs, or -1 if the runner
+// decides
+func (s *llmServer) GPULayers() int {
+	return s.options.NumGPU
+}
+
 func (s *llmServer) EstimatedVRAMByGPU(gpuID string) uint64 {
 	for i, gpu := range s.gpus {
-		if gpu.ID == gpuID {
+		// the estimate of a CPU runner is of the RPC servers it offloads to
+		if gpu.ID == gpuID && gpu.Library != "cpu" && i < len(s.estimate.GPUSizes) {
 			return s.estimate.GPUSizes[i]
 		}
 	}
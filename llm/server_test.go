@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestBestOf(t *testing.T) {
+	// the runner's candidates are more likely for some seeds than others
+	logprobs := map[int]float64{0: -4, 1: -1, 2: -3}
+
+	var seeds []int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("/completion", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Seed int `json:"seed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		seeds = append(seeds, req.Seed)
+		fmt.Fprintf(w, "data: {\"content\":\"candidate %d\"}\n\n", req.Seed)
+		fmt.Fprintf(w, "data: {\"stop\":true,\"logprob\":%g,\"timings\":{\"predicted_n\":2,\"prompt_n\":5}}\n\n", logprobs[req.Seed])
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	_, p, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(p)
+	require.NoError(t, err)
+
+	s := &llmServer{
+		port:     port,
+		cmd:      &exec.Cmd{},
+		options:  api.DefaultOptions(),
+		sem:      newFairSemaphore(1),
+		adapters: newAdapterGate(),
+	}
+
+	complete := func(opts api.Options) (string, CompletionResponse) {
+		var content string
+		var done CompletionResponse
+		err := s.Completion(context.Background(), CompletionRequest{Prompt: "hi", Options: &opts}, func(r CompletionResponse) {
+			content += r.Content
+			if r.Done {
+				done = r
+			}
+		})
+		require.NoError(t, err)
+		return content, done
+	}
+
+	opts := api.DefaultOptions()
+	opts.Seed = 0
+	opts.BestOf = 3
+	content, done := complete(opts)
+	assert.Equal(t, []int{0, 1, 2}, seeds)
+	assert.Equal(t, "candidate 1", content)
+	assert.Equal(t, 6, done.EvalCount)
+	assert.Equal(t, 5, done.PromptEvalCount)
+	assert.InDelta(t, -1, done.LogProb, 1e-9)
+
+	// without sampling every candidate would be the same
+	seeds = nil
+	opts.Temperature = 0
+	content, _ = complete(opts)
+	assert.Equal(t, []int{0}, seeds)
+	assert.Equal(t, "candidate 0", content)
+}
+
+func TestQueueDeadline(t *testing.T) {
+	// the first completion holds the only slot until it's released
+	release := make(chan struct{})
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("/completion", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, "data: {\"stop\":true,\"timings\":{\"predicted_n\":1,\"prompt_n\":1}}\n\n")
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	_, p, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(p)
+	require.NoError(t, err)
+
+	s := &llmServer{
+		port:     port,
+		cmd:      &exec.Cmd{},
+		options:  api.DefaultOptions(),
+		sem:      newFairSemaphore(1),
+		adapters: newAdapterGate(),
+	}
+
+	opts := api.DefaultOptions()
+	done := make(chan error)
+	go func() {
+		done <- s.Completion(context.Background(), CompletionRequest{Prompt: "hi", Options: &opts}, func(CompletionResponse) {})
+	}()
+	<-started
+
+	timeout := 50 * time.Millisecond
+	ctx := WithQueueDeadline(context.Background(), timeout, time.Now().Add(timeout))
+	err = s.Completion(ctx, CompletionRequest{Prompt: "hi", Options: &opts}, func(CompletionResponse) {})
+
+	var queueErr *QueueTimeoutError
+	require.ErrorAs(t, err, &queueErr)
+	assert.Equal(t, timeout, queueErr.Timeout)
+	assert.Equal(t, 1, queueErr.Position)
+	assert.Zero(t, s.sem.Waiting())
+
+	_, err = s.Embedding(ctx, "hi")
+	require.ErrorAs(t, err, &queueErr)
+
+	close(release)
+	require.NoError(t, <-done)
+
+	// a free slot is acquired even after the deadline
+	require.NoError(t, s.acquire(ctx))
+	s.sem.Release()
+}
@@ -0,0 +1,82 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Slot states reported by [LlamaServer.Slots]
+const (
+	SlotIdle       = "idle"
+	SlotPrompt     = "prompt"     // evaluating the prompt of its request
+	SlotGenerating = "generating" // generating the response to its request
+)
+
+// SlotStatus is what one of the parallel slots of a runner is doing
+type SlotStatus struct {
+	ID    int
+	State string
+
+	// RequestID, the token counts and Age are of the request occupying the
+	// slot, if it isn't idle
+	RequestID       string
+	PromptTokens    int // tokens in the prompt
+	ContextTokens   int // tokens in the slot's context so far
+	GeneratedTokens int
+	Age             time.Duration // since the slot was given the request
+}
+
+// Slots returns what each of the runner's parallel slots is doing
+func (s *llmServer) Slots(ctx context.Context) ([]SlotStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/slots?summary", s.port), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("slots request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("slots request: %s", resp.Status)
+	}
+
+	var slots []struct {
+		ID            int    `json:"id"`
+		State         string `json:"state"`
+		RequestID     string `json:"request_id"`
+		NPromptTokens int    `json:"n_prompt_tokens"`
+		NPast         int    `json:"n_past"`
+		NDecoded      int    `json:"n_decoded"`
+		ElapsedMS     int64  `json:"t_elapsed_ms"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&slots); err != nil {
+		return nil, fmt.Errorf("slots response: %w", err)
+	}
+
+	statuses := make([]SlotStatus, len(slots))
+	for i, slot := range slots {
+		statuses[i] = SlotStatus{
+			ID:              slot.ID,
+			State:           slot.State,
+			RequestID:       slot.RequestID,
+			PromptTokens:    slot.NPromptTokens,
+			ContextTokens:   slot.NPast,
+			GeneratedTokens: slot.NDecoded,
+			Age:             time.Duration(slot.ElapsedMS) * time.Millisecond,
+		}
+	}
+
+	return statuses, nil
+}
+
+// Waiting returns the number of requests waiting for one of the runner's
+// slots
+func (s *llmServer) Waiting() int {
+	return s.sem.Waiting()
+}
@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"math"
+	"math/cmplx"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// SpeechSampleRate is the sample rate of audio decoded by WavTokenizer
+// vocoders
+const SpeechSampleRate = 24000
+
+// spectrogram parameters of WavTokenizer vocoders
+const (
+	speechFFT = 1280
+	speechHop = 320
+	speechWin = 1280
+)
+
+// speechWordSeparator separates the words of text prompts of OuteTTS models
+const speechWordSeparator = "<|text_sep|>"
+
+var (
+	speechPunctuation = strings.NewReplacer("-", " ", "_", " ", "/", " ", ",", " ", ".", " ", "\\", " ")
+	audioCodePattern  = regexp.MustCompile(`<\|(\d+)\|>`)
+)
+
+// SpeechText formats text for the prompt of a text-to-speech model. Words are
+// lowercased, stripped of punctuation and joined by the word separator.
+func SpeechText(text string) string {
+	text = speechPunctuation.Replace(strings.ToLower(text))
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return r
+		}
+		return -1
+	}, text)
+
+	return strings.Join(strings.Fields(text), speechWordSeparator)
+}
+
+// AudioCodes returns the audio codes, such as <|1234|>, in text generated
+// by a text-to-speech model. Other tokens, such as word timings, are
+// ignored.
+func AudioCodes(text string) []int {
+	var codes []int
+	for _, match := range audioCodePattern.FindAllStringSubmatch(text, -1) {
+		if code, err := strconv.Atoi(match[1]); err == nil {
+			codes = append(codes, code)
+		}
+	}
+
+	return codes
+}
+
+// spectrogramToAudio converts vocoder frames of log magnitudes followed by
+// phases into audio samples with an inverse short-time Fourier transform
+func spectrogramToAudio(frames []float32, nEmbd int) []float32 {
+	if nEmbd <= 0 || len(frames) < nEmbd {
+		return nil
+	}
+
+	nFrames := len(frames) / nEmbd
+	nBins := nEmbd / 2
+	nPad := (speechWin - speechHop) / 2
+	nOut := (nFrames-1)*speechHop + speechWin
+
+	window := make([]float64, speechFFT)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(speechFFT))
+	}
+
+	audio := make([]float64, nOut)
+	envelope := make([]float64, nOut)
+
+	fft := fourier.NewFFT(speechFFT)
+	coeff := make([]complex128, speechFFT/2+1)
+	sequence := make([]float64, speechFFT)
+	for f := range nFrames {
+		frame := frames[f*nEmbd : (f+1)*nEmbd]
+		clear(coeff)
+		for k := range min(nBins, len(coeff)) {
+			magnitude := min(math.Exp(float64(frame[k])), 1e2)
+			coeff[k] = cmplx.Rect(magnitude, float64(frame[k+nBins]))
+
+			// frames are inverted summing each bin once, but the inverse
+			// FFT also adds the conjugates of all but the first and last
+			if k > 0 && k < speechFFT/2 {
+				coeff[k] /= 2
+			}
+		}
+
+		// inverse real FFT, windowed and overlap-added into the output
+		fft.Sequence(sequence, coeff)
+		for n, v := range sequence {
+			i := f*speechHop + n
+			audio[i] += v / float64(nBins) * window[n]
+			envelope[i] += window[n] * window[n]
+		}
+	}
+
+	samples := make([]float32, 0, nOut-2*nPad)
+	for i := nPad; i < nOut-nPad; i++ {
+		if envelope[i] > 1e-8 {
+			audio[i] /= envelope[i]
+		}
+		samples = append(samples, float32(audio[i]))
+	}
+
+	return samples
+}
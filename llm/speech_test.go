@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpeechText(t *testing.T) {
+	assert.Equal(t, "hello<|text_sep|>world<|text_sep|>its<|text_sep|>fine", SpeechText("Hello, world! It's... fine."))
+	assert.Equal(t, "well<|text_sep|>known", SpeechText("well-known"))
+	assert.Empty(t, SpeechText(" ?! "))
+}
+
+func TestAudioCodes(t *testing.T) {
+	assert.Equal(t, []int{12, 3456, 7}, AudioCodes("hello<|t_0.24|><|code_start|><|12|><|3456|><|code_end|>\n<|7|>"))
+	assert.Empty(t, AudioCodes("<|t_0.24|>"))
+}
+
+func TestSpectrogramToAudio(t *testing.T) {
+	nEmbd := 2 * (speechFFT/2 + 1)
+	nFrames := 8
+
+	frames := make([]float32, nFrames*nEmbd)
+	for f := range nFrames {
+		for k := range nEmbd / 2 {
+			frames[f*nEmbd+k] = float32(math.Log(0.5))
+		}
+	}
+
+	samples := spectrogramToAudio(frames, nEmbd)
+	assert.Len(t, samples, (nFrames-1)*speechHop+speechWin-(speechWin-speechHop))
+
+	for _, s := range samples {
+		assert.False(t, math.IsNaN(float64(s)))
+	}
+
+	assert.Nil(t, spectrogramToAudio(nil, nEmbd))
+}
+
+func TestSpectrogramToAudioDFT(t *testing.T) {
+	nEmbd := 2 * (speechFFT/2 + 1)
+	nBins := nEmbd / 2
+	nFrames := 3
+
+	frames := make([]float32, nFrames*nEmbd)
+	for f := range nFrames {
+		for k := range nBins {
+			frames[f*nEmbd+k] = float32(math.Sin(float64(f*k)) - 1)
+			frames[f*nEmbd+nBins+k] = float32(math.Cos(float64(f+k)) * math.Pi)
+		}
+	}
+
+	// the inverse of each frame, by its definition
+	dft := func(frame []float32) []float64 {
+		seq := make([]float64, speechFFT)
+		for n := range seq {
+			for k := range nBins {
+				magnitude := math.Exp(float64(frame[k]))
+				angle := 2*math.Pi*float64(k)*float64(n)/float64(speechFFT) + float64(frame[k+nBins])
+				seq[n] += magnitude * math.Cos(angle)
+			}
+		}
+		return seq
+	}
+
+	nOut := (nFrames-1)*speechHop + speechWin
+	audio := make([]float64, nOut)
+	envelope := make([]float64, nOut)
+	for f := range nFrames {
+		for n, v := range dft(frames[f*nEmbd : (f+1)*nEmbd]) {
+			w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(n)/float64(speechFFT))
+			audio[f*speechHop+n] += v / float64(nBins) * w
+			envelope[f*speechHop+n] += w * w
+		}
+	}
+
+	samples := spectrogramToAudio(frames, nEmbd)
+	nPad := (speechWin - speechHop) / 2
+	require.Len(t, samples, nOut-2*nPad)
+	for i, s := range samples {
+		expected := audio[i+nPad]
+		if envelope[i+nPad] > 1e-8 {
+			expected /= envelope[i+nPad]
+		}
+		require.InDelta(t, expected, s, 1e-4, "sample %d", i)
+	}
+}
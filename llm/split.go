@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// splitPattern matches the names llama.cpp's gguf-split gives the files of a
+// split GGUF, e.g. model-00001-of-00005.gguf
+var splitPattern = regexp.MustCompile(`-(\d{5})-of-(\d{5})\.gguf$`)
+
+// SplitFiles returns the files of the split GGUF path is one of, or which is
+// in the directory path, in order. It returns nil if path isn't a split GGUF.
+func SplitFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		matches, err := filepath.Glob(filepath.Join(path, "*-00001-of-*.gguf"))
+		if err != nil {
+			return nil, err
+		}
+
+		switch len(matches) {
+		case 0:
+			return nil, nil
+		case 1:
+			path = matches[0]
+		default:
+			return nil, fmt.Errorf("%s has more than one split GGUF", path)
+		}
+	}
+
+	m := splitPattern.FindStringSubmatch(path)
+	if m == nil {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(m[2])
+	if err != nil || n < 2 {
+		return nil, nil
+	}
+
+	prefix := strings.TrimSuffix(path, m[0])
+
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("%s-%05d-of-%s.gguf", prefix, i+1, m[2])
+		if _, err := os.Stat(files[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// MergeGGUF writes the files of a split GGUF to ws as a single GGUF. The
+// metadata is that of the first file, which is the only one llama.cpp keeps
+// it in, without the split.* keys.
+func MergeGGUF(ws io.WriteSeeker, files []string) error {
+	var kv KV
+	var ts []Tensor
+	for i, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		ggml, _, err := DecodeGGML(f, -1)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		if ggml.Name() != "gguf" {
+			return fmt.Errorf("%s: not a GGUF file", file)
+		}
+
+		if no, ok := ggml.KV()["split.no"].(uint16); ok && int(no) != i {
+			return fmt.Errorf("%s: expected split %d, got %d", file, i, no)
+		}
+
+		if i == 0 {
+			kv = maps.Clone(ggml.KV())
+		}
+
+		tensors := ggml.Tensors()
+		for _, t := range tensors.Items {
+			// the size of a tensor of an unknown type would be 0, so it
+			// would be written empty
+			if t.typeSize() == 0 {
+				return fmt.Errorf("%s: tensor %s has unsupported type %d", file, t.Name, t.Kind)
+			}
+
+			// WriteGGUF reverses shapes to the order ggml expects
+			shape := slices.Clone(t.Shape)
+			slices.Reverse(shape)
+
+			ts = append(ts, Tensor{
+				Name:     t.Name,
+				Kind:     t.Kind,
+				Shape:    shape,
+				WriterTo: sectionWriterTo{io.NewSectionReader(f, int64(tensors.Offset+t.Offset), int64(t.Size()))},
+			})
+		}
+	}
+
+	maps.DeleteFunc(kv, func(k string, _ any) bool {
+		return strings.HasPrefix(k, "split.")
+	})
+
+	// the count is added when decoding, and only for the first file, and
+	// the merged file is written with the default alignment
+	delete(kv, "general.parameter_count")
+	delete(kv, "general.alignment")
+
+	return WriteGGUF(ws, kv, ts)
+}
+
+type sectionWriterTo struct {
+	*io.SectionReader
+}
+
+func (s sectionWriterTo) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, s.SectionReader)
+}
@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/go-bfloat16"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSplit(t *testing.T, path string, kv KV, tensors []Tensor) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, WriteGGUF(f, kv, tensors))
+}
+
+func TestSplitFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"model-00001-of-00003.gguf", "model-00002-of-00003.gguf", "model-00003-of-00003.gguf", "other.gguf"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+
+	expect := []string{
+		filepath.Join(dir, "model-00001-of-00003.gguf"),
+		filepath.Join(dir, "model-00002-of-00003.gguf"),
+		filepath.Join(dir, "model-00003-of-00003.gguf"),
+	}
+
+	for _, path := range []string{dir, expect[0], expect[2]} {
+		files, err := SplitFiles(path)
+		require.NoError(t, err)
+		assert.Equal(t, expect, files, path)
+	}
+
+	files, err := SplitFiles(filepath.Join(dir, "other.gguf"))
+	require.NoError(t, err)
+	assert.Nil(t, files)
+
+	require.NoError(t, os.Remove(expect[1]))
+	_, err = SplitFiles(dir)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMergeGGUF(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		filepath.Join(dir, "model-00001-of-00002.gguf"),
+		filepath.Join(dir, "model-00002-of-00002.gguf"),
+	}
+
+	writeSplit(t, files[0], KV{
+		"general.architecture":  "llama",
+		"llama.block_count":     uint32(2),
+		"llama.rope.freq_base":  float64(10000),
+		"tokenizer.ggml.tokens": []string{"a", "b"},
+		"split.no":              uint16(0),
+		"split.count":           uint16(2),
+		"split.tensors.count":   int32(3),
+	}, []Tensor{
+		f32Tensor(t, "blk.0.attn_q.weight", []uint64{2, 3}, 1, 2, 3, 4, 5, 6),
+	})
+
+	writeSplit(t, files[1], KV{
+		"split.no":            uint16(1),
+		"split.count":         uint16(2),
+		"split.tensors.count": int32(3),
+	}, []Tensor{
+		f32Tensor(t, "blk.1.attn_q.weight", []uint64{2, 3}, 7, 8, 9, 10, 11, 12),
+		f32Tensor(t, "output.weight", []uint64{2}, 13, 14),
+	})
+
+	f, err := os.Create(filepath.Join(dir, "model.gguf"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, MergeGGUF(f, files))
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	ggml, _, err := DecodeGGML(f, -1)
+	require.NoError(t, err)
+
+	kv := ggml.KV()
+	assert.Equal(t, "llama", kv.Architecture())
+	assert.Equal(t, uint64(2), kv.BlockCount())
+	assert.Equal(t, float64(10000), kv["llama.rope.freq_base"])
+	assert.Equal(t, []any{"a", "b"}, kv["tokenizer.ggml.tokens"].(*array).values)
+	assert.Equal(t, uint64(14), kv.ParameterCount())
+	for _, k := range []string{"split.no", "split.count", "split.tensors.count"} {
+		assert.NotContains(t, kv, k)
+	}
+
+	tensors := ggml.Tensors()
+	expect := map[string][]float32{
+		"blk.0.attn_q.weight": {1, 2, 3, 4, 5, 6},
+		"blk.1.attn_q.weight": {7, 8, 9, 10, 11, 12},
+		"output.weight":       {13, 14},
+	}
+
+	require.Len(t, tensors.Items, len(expect))
+	for _, tensor := range tensors.Items {
+		values := make([]float32, tensor.parameters())
+		sr := io.NewSectionReader(f, int64(tensors.Offset+tensor.Offset), int64(tensor.Size()))
+		require.NoError(t, binary.Read(sr, binary.LittleEndian, values))
+		assert.Equal(t, expect[tensor.Name], values, tensor.Name)
+	}
+}
+
+func TestMergeGGUFBF16(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		filepath.Join(dir, "model-00001-of-00002.gguf"),
+		filepath.Join(dir, "model-00002-of-00002.gguf"),
+	}
+
+	writeSplit(t, files[0], KV{
+		"general.architecture": "llama",
+		"general.alignment":    uint32(64),
+		"split.no":             uint16(0),
+		"split.count":          uint16(2),
+	}, []Tensor{
+		bf16Tensor(t, "blk.0.attn_q.weight", []uint64{3}, 1, 2, 3),
+	})
+
+	writeSplit(t, files[1], KV{
+		"general.alignment": uint32(64),
+		"split.no":          uint16(1),
+		"split.count":       uint16(2),
+	}, []Tensor{
+		bf16Tensor(t, "blk.1.attn_q.weight", []uint64{3}, 4, 5, 6),
+		bf16Tensor(t, "output.weight", []uint64{2}, 7, 8),
+	})
+
+	f, err := os.Create(filepath.Join(dir, "model.gguf"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, MergeGGUF(f, files))
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	ggml, _, err := DecodeGGML(f, -1)
+	require.NoError(t, err)
+	assert.NotContains(t, ggml.KV(), "general.alignment")
+
+	tensors := ggml.Tensors()
+	expect := map[string][]float32{
+		"blk.0.attn_q.weight": {1, 2, 3},
+		"blk.1.attn_q.weight": {4, 5, 6},
+		"output.weight":       {7, 8},
+	}
+
+	require.Len(t, tensors.Items, len(expect))
+	for _, tensor := range tensors.Items {
+		require.Equal(t, uint64(len(expect[tensor.Name])*2), tensor.Size(), tensor.Name)
+
+		bts := make([]byte, tensor.Size())
+		_, err := f.ReadAt(bts, int64(tensors.Offset+tensor.Offset))
+		require.NoError(t, err)
+		assert.Equal(t, expect[tensor.Name], bfloat16.DecodeFloat32(bts), tensor.Name)
+	}
+}
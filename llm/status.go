@@ -2,16 +2,32 @@ package llm
 
 import (
 	"bytes"
-	"os"
+	"io"
+	"strings"
 )
 
+// statusTailSize is how much of the runner's latest output is kept to
+// diagnose it crashing
+const statusTailSize = 8 << 10
+
 // StatusWriter is a writer that captures error messages from the llama runner process
 type StatusWriter struct {
 	LastErrMsg string
-	out        *os.File
+
+	// OutOfMemory is set once the runner fails to allocate GPU memory, which
+	// later errors caused by it don't mention
+	OutOfMemory bool
+
+	// MLockFailed and PinnedFailed are set when the runner couldn't lock the
+	// model in memory or allocate pinned memory, which it continues without
+	MLockFailed  bool
+	PinnedFailed bool
+
+	out  io.Writer
+	tail []byte
 }
 
-func NewStatusWriter(out *os.File) *StatusWriter {
+func NewStatusWriter(out io.Writer) *StatusWriter {
 	return &StatusWriter{
 		out: out,
 	}
@@ -29,7 +45,32 @@ var errorPrefixes = []string{
 	"GGML_ASSERT",
 }
 
+// outOfMemoryMessages are logged by the GPU backends when an allocation fails
+var outOfMemoryMessages = []string{
+	"out of memory",
+	"OutOfMemory",            // Metal's kIOGPUCommandBufferCallbackErrorOutOfMemory
+	"ErrorOutOfDeviceMemory", // Vulkan
+	"cudaMalloc failed",
+	"unable to allocate backend buffer",
+}
+
 func (w *StatusWriter) Write(b []byte) (int, error) {
+	if bytes.Contains(b, []byte("failed to mlock")) {
+		w.MLockFailed = true
+	}
+
+	// failing to allocate pinned memory is out of memory too, but the runner
+	// falls back to pageable memory
+	if bytes.Contains(b, []byte("failed to allocate")) && bytes.Contains(b, []byte("pinned memory")) {
+		w.PinnedFailed = true
+	} else {
+		for _, msg := range outOfMemoryMessages {
+			if bytes.Contains(b, []byte(msg)) {
+				w.OutOfMemory = true
+			}
+		}
+	}
+
 	var errMsg string
 	for _, prefix := range errorPrefixes {
 		if _, after, ok := bytes.Cut(b, []byte(prefix)); ok {
@@ -40,5 +81,26 @@ func (w *StatusWriter) Write(b []byte) (int, error) {
 		w.LastErrMsg = errMsg
 	}
 
+	w.tail = append(w.tail, b...)
+	if len(w.tail) > statusTailSize {
+		w.tail = append(w.tail[:0:0], w.tail[len(w.tail)-statusTailSize:]...)
+	}
+
 	return w.out.Write(b)
 }
+
+// Tail returns up to the last n lines the runner logged
+func (w *StatusWriter) Tail(n int) []string {
+	s := strings.TrimRight(string(w.tail), "\n")
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.Split(s, "\n")
+	if len(w.tail) == statusTailSize {
+		// the first line was cut off
+		lines = lines[1:]
+	}
+
+	return lines[max(len(lines)-n, 0):]
+}
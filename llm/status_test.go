@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusWriterOutOfMemory(t *testing.T) {
+	out, err := os.Create(os.DevNull)
+	require.NoError(t, err)
+	defer out.Close()
+
+	w := NewStatusWriter(out)
+	_, err = w.Write([]byte("llm_load_tensors: offloading 32 repeating layers to GPU\n"))
+	require.NoError(t, err)
+	assert.False(t, w.OutOfMemory)
+
+	_, err = w.Write([]byte("ggml_backend_cuda_buffer_type_alloc_buffer: allocating 4096.00 MiB on device 0: cudaMalloc failed: out of memory\n"))
+	require.NoError(t, err)
+	assert.True(t, w.OutOfMemory)
+	assert.Equal(t, "cudaMalloc failed: out of memory", w.LastErrMsg)
+}
+
+func TestStatusWriterHostMemory(t *testing.T) {
+	out, err := os.Create(os.DevNull)
+	require.NoError(t, err)
+	defer out.Close()
+
+	w := NewStatusWriter(out)
+	_, err = w.Write([]byte("warning: failed to mlock 4294967296-byte buffer (after previously locking 0 bytes): Cannot allocate memory\n"))
+	require.NoError(t, err)
+	assert.True(t, w.MLockFailed)
+	assert.False(t, w.PinnedFailed)
+
+	_, err = w.Write([]byte("ggml_cuda_host_malloc: failed to allocate 4096.00 MiB of pinned memory: out of memory\n"))
+	require.NoError(t, err)
+	assert.True(t, w.PinnedFailed)
+	assert.False(t, w.OutOfMemory)
+}
+
+func TestStatusWriterTail(t *testing.T) {
+	out, err := os.Create(os.DevNull)
+	require.NoError(t, err)
+	defer out.Close()
+
+	w := NewStatusWriter(out)
+	assert.Empty(t, w.Tail(2))
+
+	_, err = w.Write([]byte("one\ntwo\n"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("three\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"two", "three"}, w.Tail(2))
+	assert.Equal(t, []string{"one", "two", "three"}, w.Tail(5))
+
+	for i := range 1000 {
+		_, err = w.Write([]byte(strings.Repeat("x", 20) + strconv.Itoa(i) + "\n"))
+		require.NoError(t, err)
+	}
+
+	tail := w.Tail(1000)
+	assert.Less(t, len(tail), 1000)
+	assert.Equal(t, strings.Repeat("x", 20)+"999", tail[len(tail)-1])
+	for _, line := range tail {
+		// lines cut off by the size limit aren't returned
+		assert.True(t, strings.HasPrefix(line, strings.Repeat("x", 20)), line)
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
-
-	"github.com/spf13/cobra"
+	"os"
 
 	"github.com/ollama/ollama/cmd"
 )
 
 func main() {
-	cobra.CheckErr(cmd.NewCLI().ExecuteContext(context.Background()))
+	if err := cmd.NewCLI().ExecuteContext(context.Background()); err != nil {
+		cmd.WriteError(os.Stderr, err)
+		os.Exit(cmd.ExitCode(err))
+	}
 }
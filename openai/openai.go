@@ -62,7 +62,13 @@ type Usage struct {
 }
 
 type ResponseFormat struct {
-	Type string `json:"type"`
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
 }
 
 type EmbedRequest struct {
@@ -476,8 +482,21 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 	}
 
 	var format string
-	if r.ResponseFormat != nil && r.ResponseFormat.Type == "json_object" {
-		format = "json"
+	if r.ResponseFormat != nil {
+		switch r.ResponseFormat.Type {
+		case "json_object":
+			format = "json"
+		case "json_schema":
+			if r.ResponseFormat.JSONSchema == nil || len(r.ResponseFormat.JSONSchema.Schema) == 0 {
+				return nil, errors.New("response_format json_schema requires a schema")
+			}
+
+			var b bytes.Buffer
+			if err := json.Compact(&b, r.ResponseFormat.JSONSchema.Schema); err != nil {
+				return nil, fmt.Errorf("invalid response_format schema: %w", err)
+			}
+			format = b.String()
+		}
 	}
 
 	return &api.ChatRequest{
@@ -70,6 +70,34 @@ func TestChatMiddleware(t *testing.T) {
 				Stream: &False,
 			},
 		},
+		{
+			name: "chat handler with json schema",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"response_format": {
+					"type": "json_schema",
+					"json_schema": {"name": "greeting", "schema": {"type": "object", "properties": {"greeting": {"type": "string"}}}}
+				}
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "Hello",
+					},
+				},
+				Format: `{"type":"object","properties":{"greeting":{"type":"string"}}}`,
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream: &False,
+			},
+		},
 		{
 			name: "chat handler with image content",
 			body: `{
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Document is a Modelfile as it's written. Unlike a File, it keeps the
+// comments, blank lines and formatting around its commands, so tools can
+// change it and write it back without losing them.
+type Document struct {
+	Nodes []Node
+}
+
+// Node is a command of a Document, or the text between two of them, such
+// as comments and blank lines.
+type Node struct {
+	// Command is the command of the node, or nil for the text between
+	// commands. A command which is changed is written formatted, like
+	// [Command.String]; otherwise it's written as Text.
+	Command *Command
+
+	// Text is the node as it's written in the Modelfile
+	Text string
+
+	// parsed is the command as it was parsed from Text
+	parsed Command
+}
+
+func (n Node) String() string {
+	if n.Command != nil && *n.Command != n.parsed {
+		return n.Command.String()
+	}
+
+	return n.Text
+}
+
+// ParseDocument parses a Modelfile into a Document. Writing the Document
+// back gives the same Modelfile, decoded to UTF-8 without a byte order mark.
+func ParseDocument(r io.Reader) (*Document, error) {
+	bts, err := io.ReadAll(transform.NewReader(r, unicode.BOMOverride(unicode.UTF8.NewDecoder())))
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(bts)
+
+	var pos position
+	f, err := parseFile(strings.NewReader(s), &pos)
+	if err != nil {
+		return nil, err
+	}
+
+	var d Document
+	var offset int
+	for i, cmd := range f.Commands {
+		loc := pos.locations[i]
+		if loc.begin > offset {
+			d.Nodes = append(d.Nodes, Node{Text: s[offset:loc.begin]})
+		}
+
+		d.Nodes = append(d.Nodes, Node{Command: &cmd, Text: s[loc.begin:loc.end], parsed: cmd})
+		offset = loc.end
+	}
+
+	if offset < len(s) {
+		d.Nodes = append(d.Nodes, Node{Text: s[offset:]})
+	}
+
+	return &d, nil
+}
+
+// File returns the commands of the Document
+func (d *Document) File() *File {
+	var f File
+	for _, n := range d.Nodes {
+		if n.Command != nil {
+			f.Commands = append(f.Commands, *n.Command)
+		}
+	}
+
+	return &f
+}
+
+// Append adds a command to the end of the Document, on a line of its own
+func (d *Document) Append(cmd Command) {
+	if len(d.Nodes) > 0 && !strings.HasSuffix(d.Nodes[len(d.Nodes)-1].String(), "\n") {
+		d.Nodes = append(d.Nodes, Node{Text: "\n"})
+	}
+
+	d.Nodes = append(d.Nodes, Node{Command: &cmd}, Node{Text: "\n"})
+}
+
+func (d *Document) String() string {
+	var sb strings.Builder
+	for _, n := range d.Nodes {
+		sb.WriteString(n.String())
+	}
+
+	return sb.String()
+}
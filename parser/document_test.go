@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDocument(t *testing.T) {
+	cases := []string{
+		"FROM llama3",
+		"FROM llama3\n",
+		"# a model\nFROM llama3\n\n# be creative\nPARAMETER temperature 1.2\n",
+		"FROM llama3\r\nPARAMETER  stop   <|end|>\r\n",
+		"FROM llama3\nSYSTEM \"\"\"\nYou are\n  a test.\n\"\"\"\n# done\n",
+		"\n\n  FROM llama3 \nMESSAGE user Hello!\n\t# indented comment\nLICENSE MIT",
+	}
+
+	for _, input := range cases {
+		d, err := ParseDocument(strings.NewReader(input))
+		require.NoError(t, err, input)
+		assert.Equal(t, input, d.String())
+
+		f, err := ParseFile(strings.NewReader(input))
+		require.NoError(t, err, input)
+		assert.Equal(t, f, d.File())
+	}
+}
+
+func TestDocumentEdit(t *testing.T) {
+	input := `# Mario
+FROM llama3
+
+# more creative
+PARAMETER temperature   1
+SYSTEM """
+You are Mario from Super Mario Bros.
+"""`
+
+	d, err := ParseDocument(strings.NewReader(input))
+	require.NoError(t, err)
+
+	for _, n := range d.Nodes {
+		if n.Command != nil && n.Command.Name == "temperature" {
+			n.Command.Args = "1.2"
+		}
+	}
+
+	d.Append(Command{Name: "num_ctx", Args: "4096"})
+
+	assert.Equal(t, `# Mario
+FROM llama3
+
+# more creative
+PARAMETER temperature 1.2
+SYSTEM """
+You are Mario from Super Mario Bros.
+"""
+PARAMETER num_ctx 4096
+`, d.String())
+}
@@ -0,0 +1,313 @@
+package parser
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/model"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem with a Modelfile. Line is 0 for problems with the
+// whole Modelfile, like a missing FROM.
+type Diagnostic struct {
+	Line     int      `json:"line"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+
+	return fmt.Sprintf("%d: %s: %s", d.Line, d.Severity, d.Message)
+}
+
+// Lint checks a Modelfile for problems creating a model from it would run
+// into, or which would make the model behave unexpectedly. Paths are
+// relative to dir. If chatTemplate isn't nil, it returns the chat template
+// of the model file FROM is, like tokenizer.chat_template of a GGUF, which
+// TEMPLATE is checked against. The error is only for reading r; problems
+// with the Modelfile itself, including syntax errors, are diagnostics.
+func Lint(r io.Reader, dir string, chatTemplate func(path string) (string, error)) ([]Diagnostic, error) {
+	bts, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var pos position
+	f, err := parseFile(bytes.NewReader(bts), &pos)
+	if err != nil {
+		return []Diagnostic{{Line: cmp.Or(pos.start, pos.line), Severity: SeverityError, Message: err.Error()}}, nil
+	}
+
+	var l linter
+	l.dir = dir
+	l.values = make(map[string]string)
+	for i, cmd := range f.Commands {
+		l.lint(pos.locations[i].line, cmd)
+	}
+
+	if l.from == 0 {
+		l.errorf(0, "%s", errMissingFrom)
+	}
+
+	if l.template != nil {
+		vars := l.template.Vars()
+		if !slices.Contains(vars, "prompt") && !slices.Contains(vars, "messages") {
+			l.warnf(l.templateLine, "TEMPLATE uses neither .Prompt nor .Messages, so prompts aren't given to the model")
+		}
+
+		if l.system != 0 && !slices.Contains(vars, "system") && !slices.Contains(vars, "messages") {
+			l.warnf(l.system, "SYSTEM isn't given to the model because TEMPLATE uses neither .System nor .Messages")
+		}
+
+		if l.tools != 0 && !slices.Contains(vars, "tools") {
+			l.errorf(l.tools, "TOOLS can't be given to the model because TEMPLATE doesn't use .Tools")
+		}
+
+		if l.fromPath != "" && chatTemplate != nil {
+			l.chatFormat(chatTemplate)
+		}
+	}
+
+	return l.diagnostics, nil
+}
+
+type linter struct {
+	dir         string
+	diagnostics []Diagnostic
+
+	// values are the ARGs declared with defaults, and unset the ones
+	// without, which can only be known when the model is created
+	values map[string]string
+	unset  []string
+
+	from         int
+	fromPath     string
+	system       int
+	tools        int
+	template     *template.Template
+	templateLine int
+	templateText string
+}
+
+func (l *linter) errorf(line int, format string, args ...any) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{Line: line, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) warnf(line int, format string, args ...any) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{Line: line, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// expand replaces the ARGs in s, or returns false if it has one which is
+// set only when the model is created
+func (l *linter) expand(s string) (string, bool) {
+	ok := true
+	s = argReferencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		if v, set := l.values[name]; set {
+			return v
+		}
+
+		if slices.Contains(l.unset, name) {
+			ok = false
+		}
+
+		return ref
+	})
+
+	return s, ok
+}
+
+func (l *linter) lint(line int, cmd Command) {
+	if cmd.Name == "arg" {
+		l.arg(line, cmd.Args)
+		return
+	}
+
+	if cmd.Name == "model" && l.from == 0 {
+		l.from = line
+	}
+
+	args, ok := l.expand(cmd.Args)
+	if !ok {
+		return
+	}
+
+	switch cmd.Name {
+	case "model":
+		l.path(line, "FROM", args)
+		if fi, err := os.Stat(l.abs(args)); err == nil && fi.Mode().IsRegular() && line == l.from {
+			l.fromPath = l.abs(args)
+		}
+	case "adapter":
+		adapter, _ := SplitAdapter(args)
+		l.path(line, "ADAPTER", adapter)
+	case "template":
+		if template.IsJinja(args) {
+			s, err := template.FromJinja(args, nil)
+			if err != nil {
+				l.errorf(line, "TEMPLATE: %v", err)
+				return
+			}
+
+			args = s
+		}
+
+		t, err := template.Parse(args)
+		if err != nil {
+			l.errorf(line, "TEMPLATE: %v", err)
+			return
+		}
+
+		l.template, l.templateLine, l.templateText = t, line, args
+	case "system":
+		l.system = line
+		l.file(line, "SYSTEM", args)
+	case "license":
+		l.file(line, "LICENSE", args)
+	case "metadata":
+		// any text is a metadata value
+	case "tools":
+		if p, ok := FileReference(args); ok {
+			// unlike a LICENSE or SYSTEM, the reference isn't valid as text
+			bts, err := os.ReadFile(l.abs(p))
+			if err != nil {
+				l.errorf(line, "TOOLS: %v", err)
+				return
+			}
+
+			args = string(bts)
+		}
+
+		if _, err := ParseTools(args); err != nil {
+			l.errorf(line, "%v", err)
+			return
+		}
+
+		l.tools = line
+	case "message":
+		for _, image := range MessageImages(args) {
+			if strings.HasPrefix(image, "@") {
+				continue
+			}
+
+			if _, err := os.Stat(l.abs(image)); errors.Is(err, os.ErrNotExist) {
+				l.warnf(line, "MESSAGE: %s doesn't exist, so it's kept as text", image)
+			}
+		}
+	default:
+		if err := checkParameter(cmd.Name, args); err != nil {
+			l.errorf(line, "PARAMETER %s: %v", cmd.Name, err)
+		}
+	}
+}
+
+func (l *linter) arg(line int, args string) {
+	name, value, ok := strings.Cut(args, "=")
+	name = strings.TrimSpace(name)
+	if !argNamePattern.MatchString(name) {
+		l.errorf(line, "%s: %q", errInvalidArg, args)
+		return
+	}
+
+	if !ok {
+		l.unset = append(l.unset, name)
+		return
+	}
+
+	value, ok = unquote(strings.TrimSpace(value))
+	if !ok {
+		l.errorf(line, "%s: %q", errInvalidArg, args)
+		return
+	}
+
+	if value, ok = l.expand(value); ok {
+		l.values[name] = value
+	} else {
+		l.unset = append(l.unset, name)
+	}
+}
+
+func (l *linter) abs(p string) string {
+	return resolvePath(l.dir, p)
+}
+
+// file checks a LICENSE or SYSTEM which references a file can read it
+func (l *linter) file(line int, command, args string) {
+	p, ok := FileReference(args)
+	if !ok {
+		return
+	}
+
+	if _, err := os.Stat(l.abs(p)); errors.Is(err, os.ErrNotExist) {
+		l.errorf(line, "%s: %s doesn't exist", command, p)
+	} else if err != nil {
+		l.errorf(line, "%s: %v", command, err)
+	}
+}
+
+// path checks FROM and ADAPTER are a file or directory, a blob, or for
+// FROM, the name of a model
+func (l *linter) path(line int, command, p string) {
+	if strings.HasPrefix(p, "@") {
+		return
+	}
+
+	if _, err := os.Stat(l.abs(p)); err == nil {
+		return
+	} else if !errors.Is(err, os.ErrNotExist) {
+		l.errorf(line, "%s: %v", command, err)
+		return
+	}
+
+	if command == "FROM" && model.ParseName(p).IsValid() {
+		return
+	}
+
+	l.errorf(line, "%s: %s doesn't exist", command, p)
+}
+
+// controlTokenPattern matches the special tokens chat formats mark turns
+// with, like <|im_start|>, <start_of_turn> and [INST]
+var controlTokenPattern = regexp.MustCompile(`<\|[^|<>\s]+\|>|<[a-z]+(?:_[a-z]+)+>|\[/?[A-Z]+(?:_[A-Z]+)*\]`)
+
+// chatFormat checks the special tokens TEMPLATE uses are in the chat
+// template of the model FROM is, since a TEMPLATE for another chat format
+// makes the model generate poorly
+func (l *linter) chatFormat(chatTemplate func(string) (string, error)) {
+	s, err := chatTemplate(l.fromPath)
+	if err != nil {
+		l.warnf(l.from, "FROM: couldn't read the model's chat template: %v", err)
+		return
+	} else if s == "" {
+		return
+	}
+
+	var missing []string
+	for _, token := range controlTokenPattern.FindAllString(l.templateText, -1) {
+		if !strings.Contains(s, token) && !slices.Contains(missing, token) {
+			missing = append(missing, token)
+		}
+	}
+
+	if len(missing) > 0 {
+		l.warnf(l.templateLine, "TEMPLATE uses %s, which the model's chat template doesn't, so it may be for another chat format", strings.Join(missing, ", "))
+	}
+}
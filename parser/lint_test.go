@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "model.gguf"), nil, 0o644))
+
+	cases := []struct {
+		name   string
+		input  string
+		expect []Diagnostic
+	}{
+		{
+			"valid",
+			"FROM ./model.gguf\nPARAMETER temperature 0.7\nPARAMETER stop <|end|>\nTEMPLATE \"{{ .System }} {{ .Prompt }}\"\nSYSTEM You are a test.",
+			nil,
+		},
+		{
+			"model name",
+			"FROM llama3:8b",
+			nil,
+		},
+		{
+			"missing from",
+			"PARAMETER temperature 0.7",
+			[]Diagnostic{{Line: 0, Severity: SeverityError, Message: "no FROM line"}},
+		},
+		{
+			"unknown command",
+			"FROM llama3\n\nFOO bar",
+			[]Diagnostic{{Line: 3, Severity: SeverityError, Message: errInvalidCommand.Error()}},
+		},
+		{
+			"unreachable paths",
+			"FROM ./missing.gguf\nADAPTER ./lora.gguf 0.5",
+			[]Diagnostic{
+				{Line: 1, Severity: SeverityError, Message: "FROM: ./missing.gguf doesn't exist"},
+				{Line: 2, Severity: SeverityError, Message: "ADAPTER: ./lora.gguf doesn't exist"},
+			},
+		},
+		{
+			"parameters",
+			"FROM llama3\nPARAMETER foo 1\nPARAMETER num_ctx big\nPARAMETER top_p 1.5\nPARAMETER num_ctx 0\nPARAMETER keep_alive soon",
+			[]Diagnostic{
+				{Line: 2, Severity: SeverityError, Message: "PARAMETER foo: unknown parameter 'foo'"},
+				{Line: 3, Severity: SeverityError, Message: "PARAMETER num_ctx: invalid int value [big]"},
+				{Line: 4, Severity: SeverityError, Message: "PARAMETER top_p: 1.5 isn't between 0 and 1"},
+				{Line: 5, Severity: SeverityError, Message: "PARAMETER num_ctx: 0 is less than 1"},
+				{Line: 6, Severity: SeverityError, Message: "PARAMETER keep_alive: invalid duration soon"},
+			},
+		},
+		{
+			"template syntax",
+			"FROM llama3\nTEMPLATE \"\"\"{{ .Prompt }\n\"\"\"",
+			[]Diagnostic{{Line: 2, Severity: SeverityError, Message: `TEMPLATE: template: :1: unexpected "}" in operand`}},
+		},
+		{
+			"jinja template",
+			"FROM llama3\nTEMPLATE \"{% for message in messages %}{{ message.content | upper }}{% endfor %}\"",
+			[]Diagnostic{{Line: 2, Severity: SeverityError, Message: "TEMPLATE: unsupported jinja: filter upper"}},
+		},
+		{
+			"template format",
+			"FROM llama3\nSYSTEM You are a test.\nTEMPLATE \"[INST] {{ .Response }}\"",
+			[]Diagnostic{
+				{Line: 3, Severity: SeverityWarning, Message: "TEMPLATE uses neither .Prompt nor .Messages, so prompts aren't given to the model"},
+				{Line: 2, Severity: SeverityWarning, Message: "SYSTEM isn't given to the model because TEMPLATE uses neither .System nor .Messages"},
+			},
+		},
+		{
+			"args",
+			"ARG MODEL=./model.gguf\nARG QUANT\nFROM ${MODEL}\nADAPTER ./${QUANT}.gguf\nARG 1BAD=foo",
+			[]Diagnostic{{Line: 5, Severity: SeverityError, Message: `ARG must be NAME or NAME=default: "1BAD=foo"`}},
+		},
+		{
+			"multiline",
+			"FROM llama3\nSYSTEM \"\"\"\nYou are\na test.\n\"\"\"\nPARAMETER temperature -1",
+			[]Diagnostic{{Line: 6, Severity: SeverityError, Message: "PARAMETER temperature: -1 is less than 0"}},
+		},
+		{
+			"message images",
+			"FROM llama3\nMESSAGE user \"What is this? ./cat.png\"",
+			[]Diagnostic{{Line: 2, Severity: SeverityWarning, Message: "MESSAGE: ./cat.png doesn't exist, so it's kept as text"}},
+		},
+		{
+			"file references",
+			"FROM llama3\nLICENSE @./model.gguf\nSYSTEM @./system.md",
+			[]Diagnostic{{Line: 3, Severity: SeverityError, Message: "SYSTEM: ./system.md doesn't exist"}},
+		},
+		{
+			"tools",
+			"FROM llama3\nTEMPLATE {{ .Prompt }}\nTOOLS [{\"function\": {\"name\": \"get_weather\"}}]\nTOOLS get_weather",
+			[]Diagnostic{
+				{Line: 4, Severity: SeverityError, Message: "TOOLS must be a JSON array of tools: invalid character 'g' looking for beginning of value"},
+				{Line: 3, Severity: SeverityError, Message: "TOOLS can't be given to the model because TEMPLATE doesn't use .Tools"},
+			},
+		},
+		{
+			"chat format",
+			"FROM ./model.gguf\nTEMPLATE \"<|im_start|>user\n{{ .Prompt }}<|im_end|>\n<|im_start|>assistant\n\"",
+			nil,
+		},
+		{
+			"other chat format",
+			"FROM ./model.gguf\nTEMPLATE \"[INST] {{ .Prompt }} [/INST]<|eot_id|>\"",
+			[]Diagnostic{{Line: 2, Severity: SeverityWarning, Message: "TEMPLATE uses [INST], [/INST], <|eot_id|>, which the model's chat template doesn't, so it may be for another chat format"}},
+		},
+	}
+
+	chatTemplate := func(path string) (string, error) {
+		if path != filepath.Join(dir, "model.gguf") {
+			t.Errorf("unexpected chat template of %s", path)
+		}
+
+		return "{% for message in messages %}{{ '<|im_start|>' + message['role'] + '\\n' + message['content'] + '<|im_end|>\\n' }}{% endfor %}", nil
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := Lint(strings.NewReader(tt.input), dir, chatTemplate)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, actual)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/ollama/ollama/api"
+)
+
+// parameterRanges are the values parameters can be set to
+var parameterRanges = map[string]struct{ min, max float64 }{
+	"adapter_scale":         {0, math.Inf(1)},
+	"best_of":               {1, 16},
+	"max_tokens_per_second": {0, math.Inf(1)},
+	"min_p":                 {0, 1},
+	"mirostat":              {0, 2},
+	"num_batch":             {1, math.Inf(1)},
+	"num_ctx":               {1, math.Inf(1)},
+	"num_gpu":               {-1, math.Inf(1)},
+	"num_parallel":          {0, math.Inf(1)},
+	"num_predict":           {-2, math.Inf(1)},
+	"num_thread":            {0, math.Inf(1)},
+	"repeat_last_n":         {-1, math.Inf(1)},
+	"repeat_penalty":        {0, math.Inf(1)},
+	"temperature":           {0, math.Inf(1)},
+	"top_k":                 {0, math.Inf(1)},
+	"top_p":                 {0, 1},
+	"typical_p":             {0, 1},
+}
+
+// ParameterError is a PARAMETER which doesn't exist, or whose value has the
+// wrong type or is out of range. Line and Column are where its value is.
+type ParameterError struct {
+	Line, Column int
+	Name         string
+	Err          error
+}
+
+func (e *ParameterError) Error() string {
+	return fmt.Sprintf("line %d, column %d: PARAMETER %s: %v", e.Line, e.Column, e.Name, e.Err)
+}
+
+func (e *ParameterError) Unwrap() error {
+	return e.Err
+}
+
+// CheckParameters checks the parameters of a Modelfile, once its ARGs are
+// expanded with args as by [File.Expand]. Creating a model only rejects
+// parameters which don't exist or have the wrong type, so this is stricter.
+// Every problem is returned, each a *ParameterError.
+func CheckParameters(r io.Reader, args map[string]string) error {
+	var pos position
+	f, err := parseFile(r, &pos)
+	if err != nil {
+		return err
+	}
+
+	// Expand removes the ARGs, so keep where the other commands are
+	var locations []location
+	for i, cmd := range f.Commands {
+		if cmd.Name != "arg" {
+			locations = append(locations, pos.locations[i])
+		}
+	}
+
+	if err := f.Expand(args); err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, cmd := range f.Commands {
+		switch cmd.Name {
+		case "model", "adapter", "license", "template", "system", "message", "metadata", "tools":
+			continue
+		}
+
+		if err := checkParameter(cmd.Name, cmd.Args); err != nil {
+			errs = append(errs, &ParameterError{Line: locations[i].line, Column: locations[i].column, Name: cmd.Name, Err: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkParameter checks a parameter exists and value has its type and is in
+// its range
+func checkParameter(name, value string) error {
+	if name == "keep_alive" || name == "load_timeout" {
+		b := []byte(value)
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			b, _ = json.Marshal(value)
+		}
+
+		var d api.Duration
+		if err := d.UnmarshalJSON(b); err != nil {
+			return fmt.Errorf("invalid duration %s", value)
+		}
+
+		return nil
+	}
+
+	params, err := api.FormatParams(map[string][]string{name: {value}})
+	if err != nil {
+		return err
+	}
+
+	r, ok := parameterRanges[name]
+	if !ok {
+		return nil
+	}
+
+	var f float64
+	switch v := params[name].(type) {
+	case int64:
+		f = float64(v)
+	case float32:
+		f = float64(v)
+	case []float32:
+		f = float64(v[0])
+	default:
+		return nil
+	}
+
+	if math.IsInf(r.max, 1) && f < r.min {
+		return fmt.Errorf("%s is less than %v", value, r.min)
+	} else if f < r.min || f > r.max {
+		return fmt.Errorf("%s isn't between %v and %v", value, r.min, r.max)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckParameters(t *testing.T) {
+	input := `ARG CTX=0
+FROM llama3
+PARAMETER temperature 0.7
+PARAMETER num_ctx ${CTX}
+PARAMETER foo bar
+SYSTEM You are a test.
+PARAMETER top_p 1.5
+PARAMETER top_k ten
+PARAMETER keep_alive 5m`
+
+	err := CheckParameters(strings.NewReader(input), nil)
+	require.Error(t, err)
+
+	var errs []*ParameterError
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var perr *ParameterError
+		require.True(t, errors.As(err, &perr))
+		errs = append(errs, perr)
+	}
+
+	assert.Equal(t, []*ParameterError{
+		{Line: 4, Column: 19, Name: "num_ctx", Err: errs[0].Err},
+		{Line: 5, Column: 15, Name: "foo", Err: errs[1].Err},
+		{Line: 7, Column: 17, Name: "top_p", Err: errs[2].Err},
+		{Line: 8, Column: 17, Name: "top_k", Err: errs[3].Err},
+	}, errs)
+
+	assert.EqualError(t, errs[0], "line 4, column 19: PARAMETER num_ctx: 0 is less than 1")
+	assert.EqualError(t, errs[1], "line 5, column 15: PARAMETER foo: unknown parameter 'foo'")
+	assert.EqualError(t, errs[2], "line 7, column 17: PARAMETER top_p: 1.5 isn't between 0 and 1")
+	assert.EqualError(t, errs[3], "line 8, column 17: PARAMETER top_k: invalid int value [ten]")
+
+	require.NoError(t, CheckParameters(strings.NewReader("ARG CTX=0\nFROM llama3\nPARAMETER num_ctx ${CTX}"), map[string]string{"CTX": "2048"}))
+}
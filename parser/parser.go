@@ -3,14 +3,20 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
+
+	"github.com/ollama/ollama/api"
 )
 
 type File struct {
@@ -36,11 +42,16 @@ func (c Command) String() string {
 	switch c.Name {
 	case "model":
 		fmt.Fprintf(&sb, "FROM %s", c.Args)
-	case "license", "template", "system", "adapter":
+	case "arg":
+		fmt.Fprintf(&sb, "ARG %s", c.Args)
+	case "license", "template", "system", "adapter", "tools":
 		fmt.Fprintf(&sb, "%s %s", strings.ToUpper(c.Name), quote(c.Args))
 	case "message":
 		role, message, _ := strings.Cut(c.Args, ": ")
 		fmt.Fprintf(&sb, "MESSAGE %s %s", role, quote(message))
+	case "metadata":
+		key, value, _ := strings.Cut(c.Args, ": ")
+		fmt.Fprintf(&sb, "METADATA %s %s", key, quote(value))
 	default:
 		fmt.Fprintf(&sb, "PARAMETER %s %s", c.Name, quote(c.Args))
 	}
@@ -62,10 +73,61 @@ const (
 var (
 	errMissingFrom        = errors.New("no FROM line")
 	errInvalidMessageRole = errors.New("message role must be one of \"system\", \"user\", or \"assistant\"")
-	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"parameter\", or \"message\"")
+	errInvalidMetadataKey = errors.New("metadata key must be one of \"description\", \"author\", or \"tags\"")
+	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"parameter\", \"message\", \"metadata\", \"tools\", or \"arg\"")
+	errInvalidArg         = errors.New("ARG must be NAME or NAME=default")
+	errInvalidTools       = errors.New("TOOLS must be a JSON array of tools")
+	errMissingArgValue    = errors.New("ARG has no value")
+	errUndeclaredArg      = errors.New("build arg isn't declared with ARG")
 )
 
 func ParseFile(r io.Reader) (*File, error) {
+	f, err := parseFile(r, &position{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range f.Commands {
+		if cmd.Name == "model" {
+			return f, nil
+		}
+	}
+
+	return nil, errMissingFrom
+}
+
+// position is where parseFile is in a Modelfile, so problems can be
+// reported with their lines
+type position struct {
+	// line is the line being read
+	line int
+
+	// column is the column of the rune being read
+	column int
+
+	// start is the first line of the command being read, or 0 between
+	// commands
+	start int
+
+	// args is the column the arguments of the command being read start at
+	args int
+
+	// offset is the number of bytes of UTF-8 which have been read, and
+	// begin the offset of the command being read
+	offset, begin int
+
+	// locations are where the commands which have been read are
+	locations []location
+}
+
+// location is the first line of a command, the column its arguments start
+// at, and the offsets of its first byte and the byte after its last
+type location struct {
+	line, column int
+	begin, end   int
+}
+
+func parseFile(r io.Reader, pos *position) (*File, error) {
 	var cmd Command
 	var curr state
 	var b bytes.Buffer
@@ -76,14 +138,31 @@ func ParseFile(r io.Reader) (*File, error) {
 	tr := unicode.BOMOverride(unicode.UTF8.NewDecoder())
 	br := bufio.NewReader(transform.NewReader(r, tr))
 
+	pos.line = 1
 	for {
-		r, _, err := br.ReadRune()
+		r, size, err := br.ReadRune()
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
 			return nil, err
 		}
 
+		at := pos.offset
+		pos.offset += size
+		pos.column++
+		if curr == stateNil && isAlpha(r) {
+			pos.start, pos.begin = pos.line, at
+		}
+
+		if curr == stateValue && pos.args == 0 && !isSpace(r) && !isNewline(r) {
+			pos.args = pos.column
+		}
+
+		if r == '\n' {
+			pos.line++
+			pos.column = 0
+		}
+
 		next, r, err := parseRuneForState(r, curr)
 		if errors.Is(err, io.ErrUnexpectedEOF) {
 			return nil, fmt.Errorf("%w: %s", err, b.String())
@@ -106,8 +185,8 @@ func ParseFile(r io.Reader) (*File, error) {
 				case "parameter":
 					// transition to stateParameter which sets command name
 					next = stateParameter
-				case "message":
-					// transition to stateMessage which validates the message role
+				case "message", "metadata":
+					// transition to stateMessage which validates the message role or metadata key
 					next = stateMessage
 					fallthrough
 				default:
@@ -116,7 +195,11 @@ func ParseFile(r io.Reader) (*File, error) {
 			case stateParameter:
 				cmd.Name = b.String()
 			case stateMessage:
-				if !isValidMessageRole(b.String()) {
+				if cmd.Name == "metadata" {
+					if !isValidMetadataKey(b.String()) {
+						return nil, errInvalidMetadataKey
+					}
+				} else if !isValidMessageRole(b.String()) {
 					return nil, errInvalidMessageRole
 				}
 
@@ -140,6 +223,8 @@ func ParseFile(r io.Reader) (*File, error) {
 
 				cmd.Args = s
 				f.Commands = append(f.Commands, cmd)
+				pos.locations = append(pos.locations, location{line: pos.start, column: pos.args, begin: pos.begin, end: at})
+				pos.start, pos.args = 0, 0
 			}
 
 			b.Reset()
@@ -169,17 +254,183 @@ func ParseFile(r io.Reader) (*File, error) {
 
 		cmd.Args = s
 		f.Commands = append(f.Commands, cmd)
+		pos.locations = append(pos.locations, location{line: pos.start, column: pos.args, begin: pos.begin, end: pos.offset})
+		pos.start, pos.args = 0, 0
 	default:
 		return nil, io.ErrUnexpectedEOF
 	}
 
+	return &f, nil
+}
+
+// SplitAdapter splits the arguments of an ADAPTER command into the adapter
+// and the scale it's applied with, if it has one, e.g. "./lora.gguf 0.5"
+func SplitAdapter(args string) (adapter, scale string) {
+	if i := strings.LastIndexAny(args, " \t"); i > 0 {
+		if _, err := strconv.ParseFloat(args[i+1:], 32); err == nil {
+			return strings.TrimSpace(args[:i]), args[i+1:]
+		}
+	}
+
+	return args, ""
+}
+
+// ParseTools parses the arguments of a TOOLS command, a JSON array of tools
+// like those of a chat request
+func ParseTools(args string) (api.Tools, error) {
+	var tools api.Tools
+	if err := json.Unmarshal([]byte(args), &tools); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidTools, err)
+	}
+
+	for i := range tools {
+		if tools[i].Type == "" {
+			tools[i].Type = "function"
+		}
+
+		if tools[i].Type != "function" {
+			return nil, fmt.Errorf("%w: unknown type %q", errInvalidTools, tools[i].Type)
+		}
+
+		if tools[i].Function.Name == "" {
+			return nil, fmt.Errorf("%w: function has no name", errInvalidTools)
+		}
+	}
+
+	return tools, nil
+}
+
+// messageImagePattern matches the images of a MESSAGE command, which are
+// paths like those of images in prompts to ollama run, or blobs uploaded in
+// their place
+var messageImagePattern = regexp.MustCompile(`@sha256[:-][0-9a-f]{64}\b|(?:[a-zA-Z]:)?(?:\./|/|\\)[\S\\ ]+?\.(?i:jpg|jpeg|png)\b`)
+
+// MessageImages returns the images in the content of a MESSAGE command,
+// e.g. "./cat.jpg" in "What is in this picture? ./cat.jpg"
+func MessageImages(content string) []string {
+	return messageImagePattern.FindAllString(content, -1)
+}
+
+var (
+	argNamePattern      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	argReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// Expand replaces ${NAME} in the commands after an ARG NAME declaration with
+// NAME's value in args, or else the declaration's default, and removes the
+// declarations. References to names which haven't been declared are kept,
+// so templates and prompts can still contain ${...}.
+func (f *File) Expand(args map[string]string) error {
+	values := make(map[string]string)
+	expand := func(s string) string {
+		return argReferencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+			if v, ok := values[ref[2:len(ref)-1]]; ok {
+				return v
+			}
+
+			return ref
+		})
+	}
+
+	var commands []Command
 	for _, cmd := range f.Commands {
-		if cmd.Name == "model" {
-			return &f, nil
+		if cmd.Name != "arg" {
+			cmd.Args = expand(cmd.Args)
+			commands = append(commands, cmd)
+			continue
+		}
+
+		name, value, ok := strings.Cut(cmd.Args, "=")
+		name = strings.TrimSpace(name)
+		if !argNamePattern.MatchString(name) {
+			return fmt.Errorf("%w: %q", errInvalidArg, cmd.Args)
+		}
+
+		if v, set := args[name]; set {
+			value, ok = v, true
+		} else if ok {
+			value, ok = unquote(strings.TrimSpace(value))
+			if !ok {
+				return fmt.Errorf("%w: %q", errInvalidArg, cmd.Args)
+			}
+
+			value = expand(value)
 		}
+
+		if !ok {
+			return fmt.Errorf("%w: %s", errMissingArgValue, name)
+		}
+
+		values[name] = value
 	}
 
-	return nil, errMissingFrom
+	for name := range args {
+		if _, ok := values[name]; !ok {
+			return fmt.Errorf("%w: %s", errUndeclaredArg, name)
+		}
+	}
+
+	f.Commands = commands
+	return nil
+}
+
+// FileReference returns the path of a LICENSE, SYSTEM or TOOLS which is read
+// from a file, e.g. "./LICENSE.txt" in "@./LICENSE.txt". Only paths which are
+// explicitly relative, absolute or in the home directory are references, so
+// text like "@bot" stays text.
+func FileReference(args string) (string, bool) {
+	if !strings.HasPrefix(args, "@") || strings.ContainsAny(args, " \t\r\n") {
+		return "", false
+	}
+
+	p := args[1:]
+	if slash := filepath.ToSlash(p); !strings.HasPrefix(slash, "./") && !strings.HasPrefix(slash, "../") &&
+		!strings.HasPrefix(slash, "~/") && !strings.HasPrefix(slash, "/") && !filepath.IsAbs(p) {
+		return "", false
+	}
+
+	return p, true
+}
+
+// ReadFiles replaces LICENSE, SYSTEM and TOOLS commands which reference a
+// file, absolute or relative to dir, with its contents. It's an error for a
+// referenced file not to exist, rather than using the reference as the text.
+func (f *File) ReadFiles(dir string) error {
+	for i, cmd := range f.Commands {
+		if cmd.Name != "license" && cmd.Name != "system" && cmd.Name != "tools" {
+			continue
+		}
+
+		p, ok := FileReference(cmd.Args)
+		if !ok {
+			continue
+		}
+
+		bts, err := os.ReadFile(resolvePath(dir, p))
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(cmd.Name), err)
+		}
+
+		f.Commands[i].Args = strings.TrimSpace(string(bts))
+	}
+
+	return nil
+}
+
+// resolvePath returns p relative to dir, or to the home directory if it
+// starts with ~
+func resolvePath(dir, p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[1:])
+		}
+	}
+
+	if !filepath.IsAbs(p) {
+		return filepath.Join(dir, p)
+	}
+
+	return p
 }
 
 func parseRuneForState(r rune, cs state) (state, rune, error) {
@@ -294,9 +545,13 @@ func isValidMessageRole(role string) bool {
 	return role == "system" || role == "user" || role == "assistant"
 }
 
+func isValidMetadataKey(key string) bool {
+	return key == "description" || key == "author" || key == "tags"
+}
+
 func isValidCommand(cmd string) bool {
 	switch strings.ToLower(cmd) {
-	case "from", "license", "template", "system", "adapter", "parameter", "message":
+	case "from", "license", "template", "system", "adapter", "parameter", "message", "metadata", "tools", "arg":
 		return true
 	default:
 		return false
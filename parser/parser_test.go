@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"unicode/utf16"
@@ -184,6 +186,53 @@ BADCOMMAND param1 value1
 	require.ErrorIs(t, err, errInvalidCommand)
 }
 
+func TestParseFileMetadata(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []Command
+		err      error
+	}{
+		{
+			`
+FROM foo
+METADATA description "A model which parses files"
+METADATA author Jane Doe
+METADATA tags parsing, files
+`,
+			[]Command{
+				{Name: "model", Args: "foo"},
+				{Name: "metadata", Args: "description: A model which parses files"},
+				{Name: "metadata", Args: "author: Jane Doe"},
+				{Name: "metadata", Args: "tags: parsing, files"},
+			},
+			nil,
+		},
+		{
+			`
+FROM foo
+METADATA version 1.0
+`,
+			nil,
+			errInvalidMetadataKey,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run("", func(t *testing.T) {
+			modelfile, err := ParseFile(strings.NewReader(c.input))
+			require.ErrorIs(t, err, c.err)
+			if modelfile != nil {
+				assert.Equal(t, c.expected, modelfile.Commands)
+
+				// commands are formatted back to the same commands
+				formatted, err := ParseFile(strings.NewReader(modelfile.String()))
+				require.NoError(t, err)
+				assert.Equal(t, c.expected, formatted.Commands)
+			}
+		})
+	}
+}
+
 func TestParseFileMessages(t *testing.T) {
 	cases := []struct {
 		input    string
@@ -445,6 +494,7 @@ func TestParseFileParameters(t *testing.T) {
 		"vocab_only true":              {"vocab_only", "true"},
 		"use_mmap true":                {"use_mmap", "true"},
 		"use_mlock true":               {"use_mlock", "true"},
+		"use_pinned false":             {"use_pinned", "false"},
 		"num_thread 1":                 {"num_thread", "1"},
 		"num_keep 1":                   {"num_keep", "1"},
 		"seed 1":                       {"seed", "1"},
@@ -641,3 +691,133 @@ func TestParseMultiByte(t *testing.T) {
 		})
 	}
 }
+
+func TestParseFileArgs(t *testing.T) {
+	input := `
+ARG BASE=llama3
+ARG QUANT=q4_0
+ARG PERSONA="You are a helpful assistant."
+FROM ${BASE}:8b-instruct-${QUANT}
+SYSTEM """${PERSONA} Answer in ${LANGUAGE}."""
+ARG LANGUAGE
+PARAMETER stop ${LANGUAGE}
+`
+
+	f, err := ParseFile(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Equal(t, Command{Name: "arg", Args: "BASE=llama3"}, f.Commands[0])
+	require.Equal(t, "ARG BASE=llama3", f.Commands[0].String())
+
+	require.NoError(t, f.Expand(map[string]string{"QUANT": "fp16", "LANGUAGE": "English"}))
+	assert.Equal(t, []Command{
+		{Name: "model", Args: "llama3:8b-instruct-fp16"},
+		{Name: "system", Args: "You are a helpful assistant. Answer in ${LANGUAGE}."},
+		{Name: "stop", Args: "English"},
+	}, f.Commands)
+}
+
+func TestParseFileArgsErrors(t *testing.T) {
+	cases := []struct {
+		input string
+		args  map[string]string
+		err   error
+	}{
+		{"ARG NAME\nFROM ${NAME}", nil, errMissingArgValue},
+		{"ARG 1NAME=foo\nFROM ${1NAME}", nil, errInvalidArg},
+		{"ARG NAME=\"foo\nFROM ${NAME}", nil, errInvalidArg},
+		{"ARG NAME=foo\nFROM ${NAME}", map[string]string{"OTHER": "bar"}, errUndeclaredArg},
+	}
+
+	for _, tt := range cases {
+		f, err := ParseFile(strings.NewReader(tt.input))
+		require.NoError(t, err)
+		require.ErrorIs(t, f.Expand(tt.args), tt.err, tt.input)
+	}
+}
+
+func TestSplitAdapter(t *testing.T) {
+	cases := []struct {
+		args, adapter, scale string
+	}{
+		{"./lora.gguf", "./lora.gguf", ""},
+		{"./lora.gguf 0.5", "./lora.gguf", "0.5"},
+		{"./my lora.gguf\t1", "./my lora.gguf", "1"},
+		{"./my lora.gguf", "./my lora.gguf", ""},
+		{"0.5", "0.5", ""},
+	}
+
+	for _, tt := range cases {
+		adapter, scale := SplitAdapter(tt.args)
+		assert.Equal(t, tt.adapter, adapter, tt.args)
+		assert.Equal(t, tt.scale, scale, tt.args)
+	}
+}
+
+func TestMessageImages(t *testing.T) {
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	cases := map[string][]string{
+		"What is this?":                          nil,
+		"What is this? ./cat.jpg":                {"./cat.jpg"},
+		"Compare /tmp/a.PNG and ./b.jpeg":        {"/tmp/a.PNG", "./b.jpeg"},
+		"What is this? " + digest:                {digest},
+		"What is in ./my pictures/cat.png today": {"./my pictures/cat.png"},
+	}
+
+	for content, expect := range cases {
+		assert.Equal(t, expect, MessageImages(content), content)
+	}
+}
+
+func TestParseFileReadFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "LICENSE.txt"), []byte("MIT License\n\nCopyright (c) Ollama\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "system.md"), []byte("You are Mario."), 0o644))
+
+	input := `
+FROM llama3
+LICENSE @./LICENSE.txt
+SYSTEM @../` + filepath.Base(dir) + `/system.md
+LICENSE "@Ollama all rights reserved"
+SYSTEM @bot
+`
+
+	f, err := ParseFile(strings.NewReader(input))
+	require.NoError(t, err)
+	require.NoError(t, f.ReadFiles(dir))
+	assert.Equal(t, []Command{
+		{Name: "model", Args: "llama3"},
+		{Name: "license", Args: "MIT License\n\nCopyright (c) Ollama"},
+		{Name: "system", Args: "You are Mario."},
+		{Name: "license", Args: "@Ollama all rights reserved"},
+		{Name: "system", Args: "@bot"},
+	}, f.Commands)
+
+	f, err = ParseFile(strings.NewReader("FROM llama3\nSYSTEM @./missing.md\n"))
+	require.NoError(t, err)
+	err = f.ReadFiles(dir)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), "SYSTEM")
+}
+
+func TestParseTools(t *testing.T) {
+	tools, err := ParseTools(`[{"function": {"name": "get_weather", "description": "Get the weather"}}]`)
+	require.NoError(t, err)
+	require.Len(t, tools, 1)
+	assert.Equal(t, "function", tools[0].Type)
+	assert.Equal(t, "get_weather", tools[0].Function.Name)
+
+	for _, args := range []string{
+		`get_weather`,
+		`{"function": {"name": "get_weather"}}`,
+		`[{"type": "retrieval", "function": {"name": "get_weather"}}]`,
+		`[{"function": {"description": "Get the weather"}}]`,
+	} {
+		_, err := ParseTools(args)
+		require.ErrorIs(t, err, errInvalidTools, args)
+	}
+
+	f, err := ParseFile(strings.NewReader("FROM foo\nTOOLS " + tools.String()))
+	require.NoError(t, err)
+	assert.Equal(t, Command{Name: "tools", Args: tools.String()}, f.Commands[1])
+	assert.Equal(t, "TOOLS "+tools.String(), f.Commands[1].String())
+}
@@ -3,6 +3,7 @@ package progress
 import (
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,6 +20,10 @@ type Progress struct {
 
 	ticker *time.Ticker
 	states []State
+
+	// quiet progress only renders its last state, once it's stopped
+	quiet   bool
+	stopped bool
 }
 
 func NewProgress(w io.Writer) *Progress {
@@ -27,6 +32,13 @@ func NewProgress(w io.Writer) *Progress {
 	return p
 }
 
+// NewQuietProgress returns a Progress which doesn't render its states as
+// they change, such as for logs which aren't terminals, but only its last
+// state once it's stopped
+func NewQuietProgress(w io.Writer) *Progress {
+	return &Progress{w: w, quiet: true}
+}
+
 func (p *Progress) stop() bool {
 	for _, state := range p.states {
 		if spinner, ok := state.(*Spinner); ok {
@@ -34,6 +46,19 @@ func (p *Progress) stop() bool {
 		}
 	}
 
+	if p.quiet {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.stopped || len(p.states) == 0 {
+			return false
+		}
+
+		p.stopped = true
+		fmt.Fprint(p.w, strings.TrimSpace(p.states[len(p.states)-1].String()))
+		return true
+	}
+
 	if p.ticker != nil {
 		p.ticker.Stop()
 		p.ticker = nil
@@ -53,6 +78,14 @@ func (p *Progress) Stop() bool {
 }
 
 func (p *Progress) StopAndClear() bool {
+	if p.quiet {
+		// nothing was rendered to clear
+		p.mu.Lock()
+		p.stopped = true
+		p.mu.Unlock()
+		return p.stop()
+	}
+
 	fmt.Fprint(p.w, "\033[?25l")
 	defer fmt.Fprint(p.w, "\033[?25h")
 
@@ -5,6 +5,8 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/ollama/ollama/theme"
 )
 
 type Spinner struct {
@@ -55,7 +57,7 @@ func (s *Spinner) String() string {
 
 	if s.stopped.IsZero() {
 		spinner := s.parts[s.value]
-		sb.WriteString(spinner)
+		sb.WriteString(theme.Current().Accent.Render(spinner))
 		sb.WriteString(" ")
 	}
 
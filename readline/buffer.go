@@ -468,8 +468,7 @@ func (b *Buffer) DeleteWord() {
 func (b *Buffer) ClearScreen() {
 	fmt.Print(ClearScreen + CursorReset + b.Prompt.prompt())
 	if b.IsEmpty() {
-		ph := b.Prompt.placeholder()
-		fmt.Print(ColorGrey + ph + CursorLeftN(len(ph)) + ColorDefault)
+		fmt.Print(b.Prompt.styledPlaceholder())
 	} else {
 		currPos := b.DisplayPos
 		currIndex := b.Pos
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/ollama/ollama/theme"
 )
 
 type Prompt struct {
@@ -29,6 +31,19 @@ func (p *Prompt) placeholder() string {
 	return p.Placeholder
 }
 
+// styledPlaceholder returns the placeholder styled as a hint with the cursor
+// moved back to its start, or nothing if output isn't styled, since then
+// it would look like typed text
+func (p *Prompt) styledPlaceholder() string {
+	ph := p.placeholder()
+	style := theme.Current().Muted
+	if ph == "" || !theme.Enabled() || style == "" {
+		return ""
+	}
+
+	return style.Render(ph) + CursorLeftN(len(ph))
+}
+
 type Terminal struct {
 	outchan chan rune
 	rawmode bool
@@ -40,6 +55,16 @@ type Instance struct {
 	Terminal *Terminal
 	History  *History
 	Pasting  bool
+
+	// EmptyPaste is called when a bracketed paste ends without any text,
+	// as terminals paste when the clipboard holds an image, and the text it
+	// returns is inserted at the cursor
+	EmptyPaste func() string
+
+	pasted bool
+
+	// linePasted is whether the line being read has pasted text in it
+	linePasted bool
 }
 
 func New(prompt Prompt) (*Instance, error) {
@@ -86,6 +111,7 @@ func (i *Instance) Readline() (string, error) {
 	}()
 
 	buf, _ := NewBuffer(i.Prompt)
+	i.linePasted = i.Pasting
 
 	var esc bool
 	var escex bool
@@ -97,8 +123,7 @@ func (i *Instance) Readline() (string, error) {
 		// don't show placeholder when pasting unless we're in multiline mode
 		showPlaceholder := !i.Pasting || i.Prompt.UseAlt
 		if buf.IsEmpty() && showPlaceholder {
-			ph := i.Prompt.placeholder()
-			fmt.Print(ColorGrey + ph + CursorLeftN(len(ph)) + ColorDefault)
+			fmt.Print(i.Prompt.styledPlaceholder())
 		}
 
 		r, err := i.Terminal.Read()
@@ -145,8 +170,14 @@ func (i *Instance) Readline() (string, error) {
 				}
 				if code == CharBracketedPasteStart {
 					i.Pasting = true
+					i.pasted = false
 				} else if code == CharBracketedPasteEnd {
 					i.Pasting = false
+					if !i.pasted && i.EmptyPaste != nil {
+						for _, r := range i.EmptyPaste() {
+							buf.Add(r)
+						}
+					}
 				}
 			case KeyDel:
 				if buf.DisplaySize() > 0 {
@@ -218,6 +249,7 @@ func (i *Instance) Readline() (string, error) {
 			fd := os.Stdin.Fd()
 			return handleCharCtrlZ(fd, i.Terminal.termios)
 		case CharEnter, CharCtrlJ:
+			i.pasted = i.pasted || i.Pasting
 			output := buf.String()
 			if output != "" {
 				i.History.Add([]rune(output))
@@ -233,11 +265,18 @@ func (i *Instance) Readline() (string, error) {
 			}
 			if r >= CharSpace || r == CharEnter || r == CharCtrlJ {
 				buf.Add(r)
+				i.pasted = i.pasted || i.Pasting
+				i.linePasted = i.linePasted || i.Pasting
 			}
 		}
 	}
 }
 
+// Pasted reports whether the line last read had pasted text in it
+func (i *Instance) Pasted() bool {
+	return i.linePasted
+}
+
 func (i *Instance) HistoryEnable() {
 	i.History.Enabled = true
 }
@@ -93,3 +93,29 @@ func getAuthorizationToken(ctx context.Context, challenge registryChallenge) (st
 
 	return token.Token, nil
 }
+
+// authorize sets the credentials of opts answering the challenge in the
+// www-authenticate header of the registry at requestURL, and reports whether
+// the registry only granted anonymous access. Registries other than
+// ollama.com use the Docker credentials of their host when it has any, and
+// the Ollama key otherwise.
+func authorize(ctx context.Context, requestURL *url.URL, header string, opts *registryOptions) (anonymous bool, err error) {
+	if !isOllamaRegistry(requestURL.Host) {
+		creds, err := getDockerCredentials(requestURL.Host)
+		if err != nil {
+			return false, err
+		}
+
+		if creds != nil {
+			return false, creds.authorize(ctx, header, opts)
+		}
+	}
+
+	token, err := getAuthorizationToken(ctx, parseRegistryChallenge(header))
+	if err != nil {
+		return false, err
+	}
+
+	opts.Token = token
+	return getTokenSubject(token) == "anonymous", nil
+}
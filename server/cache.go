@@ -0,0 +1,164 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// responseCache is a size limited LRU cache of responses to identical
+// requests. Entries expire after ttl.
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type responseCacheEntry struct {
+	key       string
+	value     any
+	expiresAt time.Time
+}
+
+func newResponseCache(ttl time.Duration, size int) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// responseCacheKey returns the cache key for a request against the model
+// with the given digest. Fields of req which don't change the response,
+// such as keep_alive, must be cleared by the caller.
+func responseCacheKey(digest string, req any) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(digest))
+	h.Write([]byte{0})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *responseCache) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*responseCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+	return entry.value, true
+}
+
+func (c *responseCache) put(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 {
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*responseCacheEntry)
+		entry.value, entry.expiresAt = value, time.Now().Add(c.ttl)
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	for c.lru.Len() >= c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*responseCacheEntry).key)
+	}
+
+	c.entries[key] = c.lru.PushFront(&responseCacheEntry{key: key, value: value, expiresAt: time.Now().Add(c.ttl)})
+}
+
+// deterministic reports whether a request with opts gets the same response
+// every time, as it doesn't sample or samples with a fixed seed
+func deterministic(opts api.Options) bool {
+	return opts.Temperature == 0 || opts.Seed >= 0
+}
+
+// restamp returns the metrics of a cached response for a request which
+// started at start, which didn't load the model or evaluate any tokens
+func restamp(m api.Metrics, start time.Time) api.Metrics {
+	m.TotalDuration = time.Since(start)
+	m.LoadDuration, m.PromptEvalDuration, m.EvalDuration = 0, 0, 0
+	return m
+}
+
+// cachedResponse looks up the response to a non-streaming request for the
+// model name with options in the response cache and sets the Cache-Status
+// header. It returns the key to store the response under on a miss. The key
+// is empty if the response cache is disabled or the response is sampled
+// without a seed, so it wouldn't be the same again.
+func (s *Server) cachedResponse(c *gin.Context, name string, options map[string]any, req any) (string, any, bool) {
+	if s.cache == nil {
+		return "", nil, false
+	}
+
+	start := time.Now()
+	m, err := GetModel(name)
+	if err != nil {
+		return "", nil, false
+	}
+
+	if opts, err := modelOptions(m, options); err != nil || !deterministic(opts) {
+		return "", nil, false
+	}
+
+	key, err := responseCacheKey(m.Digest, req)
+	if err != nil {
+		slog.Warn("failed to compute response cache key", "error", err)
+		return "", nil, false
+	}
+
+	if resp, ok := s.cache.get(key); ok {
+		c.Header("Cache-Status", "ollama; hit")
+
+		// the response is this request's now
+		switch r := resp.(type) {
+		case api.GenerateResponse:
+			r.RequestID = requestID(c)
+			r.CreatedAt = time.Now().UTC()
+			r.Metrics = restamp(r.Metrics, start)
+			resp = r
+		case api.ChatResponse:
+			r.RequestID = requestID(c)
+			r.CreatedAt = time.Now().UTC()
+			r.Metrics = restamp(r.Metrics, start)
+			resp = r
+		}
+
+		return key, resp, true
+	}
+
+	c.Header("Cache-Status", "ollama; fwd=miss")
+	return key, nil, false
+}
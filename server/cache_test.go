@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestResponseCache(t *testing.T) {
+	c := newResponseCache(time.Minute, 2)
+
+	c.put("a", 1)
+	c.put("b", 2)
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Fatalf("expected hit for a, got %v %v", v, ok)
+	}
+
+	// b is the least recently used entry and is evicted
+	c.put("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.get(k); !ok {
+			t.Fatalf("expected hit for %s", k)
+		}
+	}
+
+	c.ttl = -time.Second
+	c.put("d", 4)
+	if _, ok := c.get("d"); ok {
+		t.Fatal("expected d to expire")
+	}
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	req := api.GenerateRequest{Model: "test", Prompt: "hello", Options: map[string]any{"seed": 42}}
+
+	a, err := responseCacheKey("sha256:1", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := responseCacheKey("sha256:2", req); a == b {
+		t.Error("expected different keys for different digests")
+	}
+
+	req.Options["seed"] = 43
+	if b, _ := responseCacheKey("sha256:1", req); a == b {
+		t.Error("expected different keys for different options")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	opts := api.DefaultOptions()
+	if deterministic(opts) {
+		t.Error("expected sampling without a seed not to be deterministic")
+	}
+
+	opts.Seed = 42
+	if !deterministic(opts) {
+		t.Error("expected sampling with a seed to be deterministic")
+	}
+
+	opts.Seed, opts.Temperature = -1, 0
+	if !deterministic(opts) {
+		t.Error("expected greedy decoding to be deterministic")
+	}
+}
+
+func TestRestamp(t *testing.T) {
+	m := restamp(api.Metrics{
+		TotalDuration:      time.Minute,
+		LoadDuration:       time.Second,
+		PromptEvalCount:    10,
+		PromptEvalDuration: time.Second,
+		EvalCount:          20,
+		EvalDuration:       time.Second,
+	}, time.Now())
+
+	if m.TotalDuration >= time.Second || m.LoadDuration != 0 || m.PromptEvalDuration != 0 || m.EvalDuration != 0 {
+		t.Errorf("expected the durations of the cache lookup, got %+v", m)
+	}
+
+	if m.PromptEvalCount != 10 || m.EvalCount != 20 {
+		t.Errorf("expected the token counts to be kept, got %+v", m)
+	}
+}
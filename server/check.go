@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// checkText is ordinary English prose which any working language model
+// predicts well
+const checkText = `The river begins high in the mountains, where snow melts each spring and runs down through narrow valleys. By the time it reaches the town, it is wide and slow, and children wade along its banks in the summer. The town was built around an old stone bridge, which has stood for more than three hundred years. Farmers once brought their grain across it to the market in the square, and the market is still held there every Saturday morning.
+
+In the autumn the hills turn red and gold, and visitors come from the city to walk the trails and eat at the small restaurants near the water. Most of them leave before winter, when the days grow short and the roads can be closed by heavy snow. The people who live in the town all year say that winter is their favorite season. The streets are quiet, the air is clear, and in the evenings families gather to cook, read and tell stories by the fire.
+
+The school at the edge of the town has about two hundred students. They learn mathematics, science, history and languages, and many of them go on to study at the university in the capital. Some return after they graduate to work as teachers, doctors or engineers, while others travel to different countries and send letters home describing what they have seen.`
+
+// maxPerplexity is the perplexity on checkText above which a model is likely
+// broken. Working models of any architecture score well under it, while ones
+// with a wrong tokenizer or rope score closer to the size of their vocabulary.
+const maxPerplexity = 100
+
+// perplexity computes a model's perplexity, it's replaced in tests
+var perplexity = llm.Perplexity
+
+// checkModel evaluates the model in layer on checkText and warns through fn
+// if its perplexity is too high, which catches conversions with a broken
+// tokenizer or rope before the model is used.
+func checkModel(ctx context.Context, layer *layerGGML, fn func(api.ProgressResponse)) error {
+	kv := layer.GGML.KV()
+	arch := kv.Architecture()
+
+	// embedding models don't predict tokens
+	if _, ok := kv[arch+".pooling_type"]; ok || slices.Contains([]string{"bert", "nomic-bert", "jina-bert-v2", "t5encoder"}, arch) {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("skipping check of %s model, which doesn't generate text", arch)})
+		return nil
+	}
+
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return err
+	}
+
+	fn(api.ProgressResponse{Status: "checking model"})
+	ppl, err := perplexity(ctx, blob, checkText)
+	if err != nil {
+		return fmt.Errorf("checking model: %w", err)
+	}
+
+	if ppl > maxPerplexity {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("warning: perplexity is %.1f, above %d, the model may have been converted incorrectly", ppl, maxPerplexity)})
+	} else {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("perplexity is %.1f", ppl)})
+	}
+
+	return nil
+}
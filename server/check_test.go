@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestCheckModel(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	layer := func(kv map[string]any) *layerGGML {
+		f, err := os.Open(createBinFile(t, kv, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		l, err := NewLayer(f, "application/vnd.ollama.image.model")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		ggml, _, err := llm.DecodeGGML(f, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &layerGGML{l, ggml}
+	}
+
+	cases := []struct {
+		name       string
+		kv         map[string]any
+		perplexity float64
+		expect     string
+	}{
+		{"working", map[string]any{"general.architecture": "llama"}, 8, "perplexity is 8.0"},
+		{"broken", map[string]any{"general.architecture": "llama"}, 31250, "warning: perplexity is 31250.0, above 100, the model may have been converted incorrectly"},
+		{"embedding", map[string]any{"general.architecture": "bert", "bert.pooling_type": uint32(2)}, 0, "skipping check of bert model, which doesn't generate text"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			perplexity = func(_ context.Context, path, text string) (float64, error) {
+				if tt.perplexity == 0 {
+					t.Error("unexpected perplexity")
+				}
+
+				return tt.perplexity, nil
+			}
+			t.Cleanup(func() { perplexity = llm.Perplexity })
+
+			var statuses []string
+			if err := checkModel(context.TODO(), layer(tt.kv), func(resp api.ProgressResponse) {
+				statuses = append(statuses, resp.Status)
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(statuses) == 0 || statuses[len(statuses)-1] != tt.expect {
+				t.Errorf("expected status %q, got %v", tt.expect, statuses)
+			}
+		})
+	}
+}
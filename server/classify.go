@@ -0,0 +1,265 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// classifiers caches classifier heads by path. Heads are small and blobs are
+// content addressed so entries never go stale.
+var classifiers = struct {
+	mu sync.Mutex
+	m  map[string]*llm.Classifier
+}{m: make(map[string]*llm.Classifier)}
+
+func loadClassifier(path string) (*llm.Classifier, error) {
+	classifiers.mu.Lock()
+	defer classifiers.mu.Unlock()
+
+	if c, ok := classifiers.m[path]; ok {
+		return c, nil
+	}
+
+	c, err := llm.LoadClassifier(path)
+	if err != nil {
+		return nil, err
+	}
+
+	classifiers.m[path] = c
+	return c, nil
+}
+
+func (s *Server) ClassifyHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.ClassifyRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input, err := inputs(req.Input)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c, req.Model, []Capability{CapabilityClassify}, req.Options, req.KeepAlive, req.QueueTimeout)
+	if errors.Is(err, errCapabilityClassify) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support classification", req.Model)})
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	if len(input) == 0 {
+		c.JSON(http.StatusOK, api.ClassifyResponse{Model: req.Model, Results: []api.ClassifyResult{}})
+		return
+	}
+
+	classifier, err := loadClassifier(m.ClassifierPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, _, err := truncateInputs(c.Request.Context(), r, m, opts, input, req.Truncate == nil || *req.Truncate)
+	if errors.Is(err, errInputTooLong) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkInputTokens(tokens); err != nil {
+		c.JSON(limitStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	count := sumTokens(tokens)
+
+	var g errgroup.Group
+	results := make([]api.ClassifyResult, len(input))
+	for i, text := range input {
+		g.Go(func() error {
+			hidden, err := r.Embedding(c.Request.Context(), text)
+			if err != nil {
+				return err
+			}
+
+			scores, err := classifier.Classify(hidden)
+			if err != nil {
+				return err
+			}
+
+			labels := make([]api.ClassifyLabel, len(scores))
+			for j, score := range scores {
+				labels[j] = api.ClassifyLabel{Label: classifier.Labels[j], Score: score}
+			}
+
+			slices.SortStableFunc(labels, func(a, b api.ClassifyLabel) int {
+				return cmp.Compare(b.Score, a.Score)
+			})
+
+			results[i] = api.ClassifyResult{Labels: labels}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); errors.As(err, new(*llm.QueueTimeoutError)) {
+		handleScheduleError(c, req.Model, err)
+		return
+	} else if err != nil {
+		slog.Error("classification failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to classify input: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ClassifyResponse{
+		Model:           req.Model,
+		Results:         results,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+	})
+}
+
+func (s *Server) RerankHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.RerankRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Query == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	}
+
+	if req.TopN < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "top_n must not be negative"})
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c, req.Model, []Capability{CapabilityRerank}, req.Options, req.KeepAlive, req.QueueTimeout)
+	if errors.Is(err, errCapabilityRerank) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support reranking", req.Model)})
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	if len(req.Documents) == 0 {
+		c.JSON(http.StatusOK, api.RerankResponse{Model: req.Model, Results: []api.RerankResult{}})
+		return
+	}
+
+	classifier, err := loadClassifier(m.ClassifierPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// cross-encoders score the query and document together
+	separator := cmp.Or(classifier.Separator, "\n")
+	input := make([]string, len(req.Documents))
+	for i, document := range req.Documents {
+		input[i] = req.Query + separator + document
+	}
+
+	tokens, _, err := truncateInputs(c.Request.Context(), r, m, opts, input, req.Truncate == nil || *req.Truncate)
+	if errors.Is(err, errInputTooLong) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkInputTokens(tokens); err != nil {
+		c.JSON(limitStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	count := sumTokens(tokens)
+
+	var g errgroup.Group
+	results := make([]api.RerankResult, len(input))
+	for i, text := range input {
+		g.Go(func() error {
+			hidden, err := r.Embedding(c.Request.Context(), text)
+			if err != nil {
+				return err
+			}
+
+			scores, err := classifier.Classify(hidden)
+			if err != nil {
+				return err
+			}
+
+			score := scores[0]
+			if classifier.Regression {
+				score = float32(1 / (1 + math.Exp(-float64(score))))
+			}
+
+			results[i] = api.RerankResult{Index: i, Document: req.Documents[i], RelevanceScore: score}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); errors.As(err, new(*llm.QueueTimeoutError)) {
+		handleScheduleError(c, req.Model, err)
+		return
+	} else if err != nil {
+		slog.Error("rerank failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to rerank documents: %v", err)})
+		return
+	}
+
+	slices.SortStableFunc(results, func(a, b api.RerankResult) int {
+		return cmp.Compare(b.RelevanceScore, a.RelevanceScore)
+	})
+
+	if req.TopN > 0 && req.TopN < len(results) {
+		results = results[:req.TopN]
+	}
+
+	c.JSON(http.StatusOK, api.RerankResponse{
+		Model:           req.Model,
+		Results:         results,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+	})
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+type mockEmbedRunner struct {
+	mockRunner
+
+	embedding func(string) []float32
+}
+
+func (m *mockEmbedRunner) Embedding(_ context.Context, s string) ([]float32, error) {
+	return m.embedding(s), nil
+}
+
+func f32Tensor(name string, values ...float32) llm.Tensor {
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, values); err != nil {
+		panic(err)
+	}
+
+	return llm.Tensor{Name: name, Shape: []uint64{uint64(len(values))}, WriterTo: &b}
+}
+
+func newMockRunnerServer(mock llm.LlamaServer) *Server {
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn: func(gpu.GpuInfoList, string, *llm.GGML, []string, []string, string, api.Options, int, llm.RunnerLog) (llm.LlamaServer, error) {
+				return mock, nil
+			},
+			getGpuFn:     gpu.GetGPUInfo,
+			getCpuFn:     gpu.GetCPUInfo,
+			reschedDelay: 250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+	return &s
+}
+
+func createEmbedBinFile(t *testing.T) string {
+	t.Helper()
+
+	return createBinFile(t, llm.KV{
+		"general.architecture":  "bert",
+		"bert.context_length":   uint32(8),
+		"bert.embedding_length": uint32(2),
+		"bert.block_count":      uint32(1),
+	}, []llm.Tensor{f32Tensor("token_embd.weight", 0)})
+}
+
+func TestClassify(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mock := mockEmbedRunner{embedding: func(string) []float32 { return []float32{0, 2} }}
+
+	s := newMockRunnerServer(&mock)
+
+	base := createEmbedBinFile(t)
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "embed",
+		Modelfile: fmt.Sprintf("FROM %s", base),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model: "sentiment",
+		Modelfile: fmt.Sprintf("FROM %s\nFROM %s", base, createBinFile(t, llm.KV{
+			"general.architecture": "classifier",
+			"classifier.labels":    []string{"negative", "positive"},
+			"classifier.pooling":   "mean",
+		}, []llm.Tensor{
+			f32Tensor("cls.output.weight", 1, 0, 0, 1),
+		})),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	t.Run("model options", func(t *testing.T) {
+		m, err := GetModel("sentiment")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts, err := modelOptions(m, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.Pooling != "mean" {
+			t.Errorf("expected pooling mean, got %q", opts.Pooling)
+		}
+	})
+
+	t.Run("classify", func(t *testing.T) {
+		w := createRequest(t, s.ClassifyHandler, api.ClassifyRequest{
+			Model: "sentiment",
+			Input: []string{"great movie"},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ClassifyResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(resp.Results))
+		}
+
+		var labels []string
+		for _, l := range resp.Results[0].Labels {
+			labels = append(labels, l.Label)
+		}
+
+		if diff := cmp.Diff(labels, []string{"positive", "negative"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if resp.PromptEvalCount != 2 {
+			t.Errorf("expected prompt eval count 2, got %d", resp.PromptEvalCount)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		w := createRequest(t, s.ClassifyHandler, api.ClassifyRequest{
+			Model: "embed",
+			Input: "great movie",
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"\"embed\" does not support classification"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+}
+
+func TestRerank(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var inputs []string
+	var mu sync.Mutex
+	mock := mockEmbedRunner{embedding: func(s string) []float32 {
+		mu.Lock()
+		defer mu.Unlock()
+		inputs = append(inputs, s)
+
+		if strings.Contains(s, "Rayleigh") {
+			return []float32{2, 0}
+		}
+		return []float32{0, 2}
+	}}
+
+	s := newMockRunnerServer(&mock)
+
+	base := createEmbedBinFile(t)
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model: "reranker",
+		Modelfile: fmt.Sprintf("FROM %s\nFROM %s", base, createBinFile(t, llm.KV{
+			"general.architecture":    "classifier",
+			"classifier.labels":       []string{"LABEL_0"},
+			"classifier.problem_type": "regression",
+			"classifier.separator":    "[SEP]",
+		}, []llm.Tensor{
+			f32Tensor("cls.output.weight", 1, -1),
+		})),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model: "sentiment",
+		Modelfile: fmt.Sprintf("FROM %s\nFROM %s", base, createBinFile(t, llm.KV{
+			"general.architecture": "classifier",
+			"classifier.labels":    []string{"negative", "positive"},
+		}, []llm.Tensor{
+			f32Tensor("cls.output.weight", 1, 0, 0, 1),
+		})),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	t.Run("rerank", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{
+			Model:     "reranker",
+			Query:     "why is the sky blue?",
+			Documents: []string{"grass is green", "Rayleigh scattering", "the sea is blue"},
+			TopN:      2,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.RerankResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(resp.Results))
+		}
+
+		if resp.Results[0].Index != 1 || resp.Results[0].Document != "Rayleigh scattering" {
+			t.Errorf("expected Rayleigh scattering first, got %+v", resp.Results[0])
+		}
+
+		// sigmoid(2)
+		if score := resp.Results[0].RelevanceScore; score < 0.88 || score > 0.881 {
+			t.Errorf("unexpected relevance score %f", score)
+		}
+
+		if !slices.Contains(inputs, "why is the sky blue?[SEP]Rayleigh scattering") {
+			t.Errorf("expected query and document joined by the separator, got %q", inputs)
+		}
+	})
+
+	t.Run("missing query", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{
+			Model:     "reranker",
+			Documents: []string{"grass is green"},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{
+			Model:     "sentiment",
+			Query:     "why is the sky blue?",
+			Documents: []string{"grass is green"},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"\"sentiment\" does not support reranking"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	rpprof "runtime/pprof"
+	"slices"
+	"strings"
+	"time"
+)
+
+// debugHandler serves the profiles of net/http/pprof, the variables of
+// expvar and the goroutine stacks of the server. It isn't authenticated, so
+// it's only served on the address of OLLAMA_DEBUG_ADDR.
+func debugHandler(sched *Scheduler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", debugVarsHandler(sched))
+	mux.HandleFunc("GET /api/debug/stacks", StacksHandler)
+	return mux
+}
+
+// debugVarsHandler serves the variables of expvar along with the state of
+// sched. The state isn't published with expvar, which only allows a name to
+// be published once per process, so each instance reports its own scheduler.
+func debugVarsHandler(sched *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := map[string]json.RawMessage{}
+		expvar.Do(func(kv expvar.KeyValue) {
+			vars[kv.Key] = json.RawMessage(kv.Value.String())
+		})
+
+		state, err := json.Marshal(sched.debugState())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		vars["scheduler"] = state
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(vars); err != nil {
+			slog.ErrorContext(r.Context(), "failed to write debug vars", "error", err)
+		}
+	}
+}
+
+// StacksHandler dumps the stacks of every goroutine, along with how long
+// blocked goroutines have been waiting
+func StacksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := rpprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		slog.ErrorContext(r.Context(), "failed to dump stacks", "error", err)
+	}
+}
+
+type debugRunner struct {
+	Model          string    `json:"model"`
+	Refs           uint      `json:"refs"`
+	Loading        bool      `json:"loading"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	HealthFailures int       `json:"health_failures"`
+}
+
+type debugScheduler struct {
+	Pending  int           `json:"pending"`
+	Finished int           `json:"finished"`
+	Expired  int           `json:"expired"`
+	Runners  []debugRunner `json:"runners"`
+}
+
+// debugState is the state of the scheduler's queues and runners, so stalls
+// show up as requests piling up in a queue or runners which keep their refs
+func (s *Scheduler) debugState() any {
+	state := debugScheduler{
+		Pending:  len(s.pendingReqCh),
+		Finished: len(s.finishedReqCh),
+		Expired:  len(s.expiredCh),
+		Runners:  []debugRunner{},
+	}
+
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	for _, runner := range s.loaded {
+		runner.refMu.Lock()
+		state.Runners = append(state.Runners, debugRunner{
+			Model:          runner.name(),
+			Refs:           runner.refCount,
+			Loading:        runner.loading,
+			ExpiresAt:      runner.expiresAt,
+			HealthFailures: runner.healthFailures,
+		})
+		runner.refMu.Unlock()
+	}
+
+	slices.SortFunc(state.Runners, func(a, b debugRunner) int {
+		return strings.Compare(a.Model, b.Model)
+	})
+
+	return state
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugHandler(t *testing.T) {
+	sched := InitScheduler(context.Background())
+	sched.loaded["llama3"] = &runnerRef{model: &Model{ShortName: "llama3:latest"}, refCount: 2, expiresAt: time.Unix(0, 0).UTC()}
+	h := debugHandler(sched)
+
+	t.Run("stacks", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/debug/stacks", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "TestDebugHandler")
+	})
+
+	t.Run("pprof", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "goroutine")
+	})
+
+	t.Run("vars", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var vars struct {
+			Scheduler debugScheduler  `json:"scheduler"`
+			Memstats  json.RawMessage `json:"memstats"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&vars))
+		assert.Equal(t, []debugRunner{{Model: "llama3:latest", Refs: 2, ExpiresAt: time.Unix(0, 0).UTC()}}, vars.Scheduler.Runners)
+		assert.NotNil(t, vars.Memstats)
+	})
+
+	t.Run("vars of another scheduler", func(t *testing.T) {
+		other := InitScheduler(context.Background())
+		other.loaded["mistral"] = &runnerRef{model: &Model{ShortName: "mistral:latest"}, expiresAt: time.Unix(0, 0).UTC()}
+
+		w := httptest.NewRecorder()
+		debugHandler(other).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var vars struct {
+			Scheduler debugScheduler `json:"scheduler"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&vars))
+		assert.Equal(t, []debugRunner{{Model: "mistral:latest", ExpiresAt: time.Unix(0, 0).UTC()}}, vars.Scheduler.Runners)
+	})
+}
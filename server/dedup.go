@@ -0,0 +1,157 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// linkBlob replaces dst with a hard link to src so the blobs share their
+// storage, or a reflink on file systems which can clone files but not link
+// them. Blobs are never written once they're named after their digest, so
+// sharing them is safe.
+func linkBlob(src, dst string) error {
+	temp := dst + "-link"
+	os.Remove(temp)
+
+	if err := os.Link(src, temp); err != nil {
+		if err := reflink(src, temp); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(temp, dst); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return nil
+}
+
+// blobCopies returns the files of each blob in every models directory, in
+// the order of the directories
+func blobCopies() (map[string][]string, error) {
+	dirs, err := modelsSubdirs("blobs")
+	if err != nil {
+		return nil, err
+	}
+
+	copies := make(map[string][]string)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if name := entry.Name(); blobPattern.MatchString(name) && entry.Type().IsRegular() {
+				copies[name] = append(copies[name], filepath.Join(dir, name))
+			}
+		}
+	}
+
+	return copies, nil
+}
+
+// dedupBlobs links the copies of the blobs with the names in used which are
+// in several models directories to the copy in the first directory which
+// has it, and returns the number of copies linked and the bytes they freed.
+// Copies on other file systems are left as they are.
+func dedupBlobs(used map[string]bool, dryRun bool) (linked int, freed int64, err error) {
+	copies, err := blobCopies()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for name, paths := range copies {
+		if !used[name] || len(paths) < 2 {
+			continue
+		}
+
+		src, err := os.Stat(paths[0])
+		if err != nil {
+			return linked, freed, err
+		}
+
+		// copies linked to each other free their storage once
+		var relinked []os.FileInfo
+		for _, p := range paths[1:] {
+			fi, err := os.Stat(p)
+			if err != nil {
+				return linked, freed, err
+			}
+
+			if os.SameFile(src, fi) || fi.Size() != src.Size() {
+				continue
+			}
+
+			if !dryRun {
+				if err := linkBlob(paths[0], p); err != nil {
+					slog.Debug("couldn't link blob", "path", p, "error", err)
+					continue
+				}
+			}
+
+			linked++
+			relinked = append(relinked, fi)
+		}
+
+		freed += distinctSize(relinked)
+	}
+
+	return linked, freed, nil
+}
+
+// diskUsage returns the bytes the blobs with the names in used take up on
+// disk, counting blobs linked to each other once
+func diskUsage(used map[string]bool) (int64, error) {
+	copies, err := blobCopies()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for name, paths := range copies {
+		if !used[name] {
+			continue
+		}
+
+		var seen []os.FileInfo
+		for _, p := range paths {
+			fi, err := os.Stat(p)
+			if err != nil {
+				return 0, err
+			}
+
+			if !sameFileAny(fi, seen) {
+				seen = append(seen, fi)
+				size += fi.Size()
+			}
+		}
+	}
+
+	return size, nil
+}
+
+// distinctSize returns the size of the files fis, counting files linked to
+// each other once
+func distinctSize(fis []os.FileInfo) int64 {
+	var size int64
+	for i, fi := range fis {
+		if !sameFileAny(fi, fis[:i]) {
+			size += fi.Size()
+		}
+	}
+
+	return size
+}
+
+func sameFileAny(fi os.FileInfo, fis []os.FileInfo) bool {
+	for _, other := range fis {
+		if os.SameFile(fi, other) {
+			return true
+		}
+	}
+
+	return false
+}
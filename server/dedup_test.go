@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestDedupBlobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	first, second := t.TempDir(), t.TempDir()
+	t.Setenv("OLLAMA_MODELS", first)
+
+	var s Server
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	// the second directory has copies of the blobs, as if it had been
+	// copied from the first
+	blobs, err := filepath.Glob(filepath.Join(first, "blobs", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var size int64
+	for _, blob := range blobs {
+		b, err := os.ReadFile(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.MkdirAll(filepath.Join(second, "blobs"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(second, "blobs", filepath.Base(blob)), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		size += int64(len(b))
+	}
+
+	t.Setenv("OLLAMA_MODELS", first+","+second)
+
+	list := func() api.ListResponse {
+		t.Helper()
+		w := createRequest(t, s.ListModelsHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		var resp api.ListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	if resp := list(); resp.DiskUsage != 2*size {
+		t.Errorf("expected disk usage %d, got %d", 2*size, resp.DiskUsage)
+	}
+
+	resp, err := collectGarbage(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := (api.GCResponse{Linked: len(blobs), Reclaimed: size}); resp != expect {
+		t.Errorf("expected %+v, got %+v", expect, resp)
+	}
+
+	if resp, err = collectGarbage(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Linked != len(blobs) {
+		t.Errorf("expected %d linked blobs, got %d", len(blobs), resp.Linked)
+	}
+
+	for _, blob := range blobs {
+		fi1, err := os.Stat(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fi2, err := os.Stat(filepath.Join(second, "blobs", filepath.Base(blob)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !os.SameFile(fi1, fi2) {
+			t.Errorf("%s wasn't linked", filepath.Base(blob))
+		}
+	}
+
+	if resp := list(); len(resp.Models) != 1 || resp.DiskUsage != size {
+		t.Errorf("expected disk usage %d, got %d", size, resp.DiskUsage)
+	}
+
+	// linked blobs aren't linked again
+	if resp, err = collectGarbage(false); err != nil {
+		t.Fatal(err)
+	} else if resp.Linked != 0 {
+		t.Errorf("expected no linked blobs, got %d", resp.Linked)
+	}
+
+	// removing linked blobs reclaims their storage once
+	if err := os.RemoveAll(filepath.Join(first, "manifests")); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, blob := range blobs {
+		if err := os.Chtimes(blob, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := api.GCResponse{Blobs: 2 * len(blobs), Reclaimed: size}
+	if resp, err = collectGarbage(true); err != nil {
+		t.Fatal(err)
+	} else if resp != expect {
+		t.Errorf("expected %+v, got %+v", expect, resp)
+	}
+
+	if resp, err = collectGarbage(false); err != nil {
+		t.Fatal(err)
+	} else if resp != expect {
+		t.Errorf("expected %+v, got %+v", expect, resp)
+	}
+}
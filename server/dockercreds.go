@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerCredentials are the credentials of a registry in the Docker client
+// configuration
+type dockerCredentials struct {
+	Username string
+	Password string
+
+	// IdentityToken is an OAuth2 refresh token exchanged for registry tokens
+	IdentityToken string
+
+	// RegistryToken is sent to the registry as is
+	RegistryToken string
+}
+
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+		RegistryToken string `json:"registrytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// dockerServer returns the key of host in the Docker configuration
+func dockerServer(host string) string {
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubServer
+	}
+
+	return host
+}
+
+// dockerHost returns the host of a key of the Docker configuration, which
+// can be a URL
+func dockerHost(server string) string {
+	if server == dockerHubServer {
+		return server
+	}
+
+	if _, rest, ok := strings.Cut(server, "://"); ok {
+		server = rest
+	}
+
+	host, _, _ := strings.Cut(server, "/")
+	return dockerServer(host)
+}
+
+func dockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// getDockerCredentials returns the credentials of the registry at host from
+// the Docker configuration, its credential helpers or its credential store,
+// or nil if there are none
+func getDockerCredentials(host string) (*dockerCredentials, error) {
+	p, err := dockerConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(bts, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+
+	server := dockerServer(host)
+	for k, helper := range config.CredHelpers {
+		if dockerHost(k) == server {
+			return dockerCredentialHelper(helper, server)
+		}
+	}
+
+	if config.CredsStore != "" {
+		creds, err := dockerCredentialHelper(config.CredsStore, server)
+		if creds != nil || err != nil {
+			return creds, err
+		}
+	}
+
+	for k, auth := range config.Auths {
+		if dockerHost(k) != server {
+			continue
+		}
+
+		creds := dockerCredentials{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			IdentityToken: auth.IdentityToken,
+			RegistryToken: auth.RegistryToken,
+		}
+
+		if auth.Auth != "" {
+			bts, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("%s: auth of %s: %w", p, k, err)
+			}
+
+			creds.Username, creds.Password, _ = strings.Cut(string(bts), ":")
+		}
+
+		return &creds, nil
+	}
+
+	return nil, nil
+}
+
+// dockerCredentialHelper gets the credentials of server from the credential
+// helper docker-credential-<helper>, such as ecr-login or osxkeychain
+func dockerCredentialHelper(helper, server string) (*dockerCredentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// helpers exit with an error when they don't have the server
+		if out := stdout.String() + stderr.String(); strings.Contains(out, "credentials not found") {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("docker-credential-%s: %w: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp struct {
+		Username string
+		Secret   string
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("docker-credential-%s: %w", helper, err)
+	}
+
+	if resp.Username == "<token>" {
+		return &dockerCredentials{IdentityToken: resp.Secret}, nil
+	}
+
+	return &dockerCredentials{Username: resp.Username, Password: resp.Secret}, nil
+}
+
+// authorize sets the credentials of opts answering the challenge of the
+// www-authenticate header of a registry
+func (creds *dockerCredentials) authorize(ctx context.Context, header string, opts *registryOptions) error {
+	scheme, _, _ := strings.Cut(header, " ")
+	if strings.EqualFold(scheme, "Basic") {
+		opts.Username, opts.Password, opts.Token = creds.Username, creds.Password, ""
+		return nil
+	}
+
+	if creds.RegistryToken != "" {
+		opts.Token = creds.RegistryToken
+		return nil
+	}
+
+	challenge := parseRegistryChallenge(header)
+	realm, err := url.Parse(challenge.Realm)
+	if err != nil {
+		return err
+	}
+
+	values := realm.Query()
+	if challenge.Service != "" {
+		values.Set("service", challenge.Service)
+	}
+
+	for _, scope := range strings.Fields(challenge.Scope) {
+		values.Add("scope", scope)
+	}
+
+	var req *http.Request
+	if creds.IdentityToken != "" {
+		values.Set("grant_type", "refresh_token")
+		values.Set("refresh_token", creds.IdentityToken)
+		values.Set("client_id", "ollama")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(values.Encode()))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		realm.RawQuery = values.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		if creds.Username != "" || creds.Password != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+
+	resp, err := (&http.Client{Transport: &defaultRegistryTransport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%d: %s", resp.StatusCode, body)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.Unmarshal(body, &token); err != nil {
+		return err
+	}
+
+	opts.Token = token.Token
+	if opts.Token == "" {
+		opts.Token = token.AccessToken
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeDockerConfig(t *testing.T, config string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DOCKER_CONFIG", dir)
+}
+
+func TestGetDockerCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper scripts need a unix shell")
+	}
+
+	// the helper knows one server
+	bin := t.TempDir()
+	helper := `#!/bin/sh
+read server
+if [ "$server" = "helper.example.com" ]; then
+	echo '{"ServerURL":"helper.example.com","Username":"helper","Secret":"secret"}'
+elif [ "$server" = "token.example.com" ]; then
+	echo '{"ServerURL":"token.example.com","Username":"<token>","Secret":"refresh"}'
+else
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+`
+	if err := os.WriteFile(filepath.Join(bin, "docker-credential-fake"), []byte(helper), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	writeDockerConfig(t, fmt.Sprintf(`{
+	"auths": {
+		"https://registry.example.com/v1/": {"auth": %q},
+		"https://index.docker.io/v1/": {"auth": %q},
+		"identity.example.com": {"identitytoken": "refresh"}
+	},
+	"credHelpers": {
+		"helper.example.com": "fake",
+		"token.example.com": "fake",
+		"missing.example.com": "fake"
+	}
+}`, base64.StdEncoding.EncodeToString([]byte("user:pass")), base64.StdEncoding.EncodeToString([]byte("hub:pass"))))
+
+	cases := map[string]*dockerCredentials{
+		"registry.example.com": {Username: "user", Password: "pass"},
+		"registry-1.docker.io": {Username: "hub", Password: "pass"},
+		"identity.example.com": {IdentityToken: "refresh"},
+		"helper.example.com":   {Username: "helper", Password: "secret"},
+		"token.example.com":    {IdentityToken: "refresh"},
+		"missing.example.com":  nil,
+		"other.example.com":    nil,
+	}
+
+	for host, expect := range cases {
+		t.Run(host, func(t *testing.T) {
+			creds, err := getDockerCredentials(host)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (creds == nil) != (expect == nil) || creds != nil && *creds != *expect {
+				t.Errorf("expected %+v, got %+v", expect, creds)
+			}
+		})
+	}
+}
+
+func TestDockerCredentialsPull(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	manifest := Manifest{SchemaVersion: 2, MediaType: "application/vnd.docker.distribution.manifest.v2+json"}
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+
+			if r.URL.Query().Get("scope") != "repository:library/test:pull" || r.URL.Query().Get("service") != "registry" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]string{"token": "registry-token"})
+		case "/v2/library/test/manifests/latest":
+			if r.Header.Get("Authorization") != "Bearer registry-token" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:library/test:pull"`, ts.URL))
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			json.NewEncoder(w).Encode(manifest)
+		case "/v2/library/basic/manifests/latest":
+			if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			json.NewEncoder(w).Encode(manifest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeDockerConfig(t, fmt.Sprintf(`{"auths": {%q: {"username": "user", "password": "pass"}}}`, u.Host))
+
+	for _, name := range []string{"test", "basic"} {
+		t.Run(name, func(t *testing.T) {
+			mp := ParseModelPath(u.Host + "/library/" + name)
+			m, err := pullModelManifest(context.Background(), mp, &registryOptions{Insecure: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.SchemaVersion != 2 {
+				t.Errorf("unexpected manifest %+v", m)
+			}
+		})
+	}
+
+	// wrong credentials are rejected
+	writeDockerConfig(t, fmt.Sprintf(`{"auths": {%q: {"username": "user", "password": "wrong"}}}`, u.Host))
+	if _, err := pullModelManifest(context.Background(), ParseModelPath(u.Host+"/library/test"), &registryOptions{Insecure: true}); err == nil || !strings.Contains(err.Error(), "bad credentials") {
+		t.Errorf("expected bad credentials, got %v", err)
+	}
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
 	"math"
@@ -20,9 +23,11 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 )
 
@@ -49,6 +54,17 @@ type blobDownload struct {
 	done       chan struct{}
 	err        error
 	references atomic.Int32
+
+	// conns limits the parts downloaded at once. It's shared by the blobs
+	// of a pull so a pull uses at most cap(conns) connections.
+	conns chan struct{}
+
+	// size is the size of the blob in the manifest, and compressed is set
+	// when the registry sends the blob compressed with zstd. Compressed
+	// blobs are sent without their uncompressed length, and in a single
+	// stream that can't be split into parts.
+	size       int64
+	compressed bool
 }
 
 type blobDownloadPart struct {
@@ -57,6 +73,13 @@ type blobDownloadPart struct {
 	Size      int64
 	Completed atomic.Int64
 
+	// Checksum is the sha256 of the completed bytes of the part as of the
+	// last checkpoint, so they can be verified when the download resumes
+	Checksum string
+
+	hash         hash.Hash // sha256 of the completed bytes
+	checkpointed time.Time
+
 	lastUpdatedMu sync.Mutex
 	lastUpdated   time.Time
 
@@ -68,6 +91,7 @@ type jsonBlobDownloadPart struct {
 	Offset    int64
 	Size      int64
 	Completed int64
+	Checksum  string `json:",omitempty"`
 }
 
 func (p *blobDownloadPart) MarshalJSON() ([]byte, error) {
@@ -76,6 +100,7 @@ func (p *blobDownloadPart) MarshalJSON() ([]byte, error) {
 		Offset:    p.Offset,
 		Size:      p.Size,
 		Completed: p.Completed.Load(),
+		Checksum:  p.Checksum,
 	})
 }
 
@@ -85,20 +110,41 @@ func (p *blobDownloadPart) UnmarshalJSON(b []byte) error {
 		return err
 	}
 	*p = blobDownloadPart{
-		N:      j.N,
-		Offset: j.Offset,
-		Size:   j.Size,
+		N:        j.N,
+		Offset:   j.Offset,
+		Size:     j.Size,
+		Checksum: j.Checksum,
 	}
 	p.Completed.Store(j.Completed)
 	return nil
 }
 
+// downloadCheckpointInterval is how often the progress of a part is synced
+// to disk so an interrupted download resumes close to where it stopped
+var downloadCheckpointInterval = 5 * time.Second
+
 const (
-	numDownloadParts          = 16
-	minDownloadPartSize int64 = 100 * format.MegaByte
-	maxDownloadPartSize int64 = 1000 * format.MegaByte
+	defaultDownloadConcurrency       = 16
+	maxDownloadConcurrency           = 64
+	minDownloadPartSize        int64 = 100 * format.MegaByte
+	maxDownloadPartSize        int64 = 1000 * format.MegaByte
 )
 
+// downloadConcurrency returns the number of connections a pull downloads
+// with: n if it's set, otherwise OLLAMA_DOWNLOAD_CONCURRENCY, up to
+// maxDownloadConcurrency
+func downloadConcurrency(n int) int {
+	if n <= 0 {
+		n = int(envconfig.DownloadConcurrency())
+	}
+
+	if n <= 0 {
+		n = defaultDownloadConcurrency
+	}
+
+	return min(n, maxDownloadConcurrency)
+}
+
 func (p *blobDownloadPart) Name() string {
 	return strings.Join([]string{
 		p.blobDownload.Name, "partial", strconv.Itoa(p.N),
@@ -113,13 +159,63 @@ func (p *blobDownloadPart) StopsAt() int64 {
 	return p.Offset + p.Size
 }
 
-func (p *blobDownloadPart) Write(b []byte) (n int, err error) {
-	n = len(b)
-	p.blobDownload.Completed.Add(int64(n))
-	p.lastUpdatedMu.Lock()
-	p.lastUpdated = time.Now()
-	p.lastUpdatedMu.Unlock()
-	return n, nil
+// partWriter writes the bytes of a part after its completed bytes, keeping
+// its progress and checksum in step with what's written
+type partWriter struct {
+	*blobDownloadPart
+	file *os.File
+}
+
+func (w partWriter) Write(b []byte) (int, error) {
+	n, err := w.file.WriteAt(b, w.StartsAt())
+	w.hash.Write(b[:n])
+	w.Completed.Add(int64(n))
+	w.blobDownload.Completed.Add(int64(n))
+
+	w.lastUpdatedMu.Lock()
+	w.lastUpdated = time.Now()
+	w.lastUpdatedMu.Unlock()
+
+	if err == nil && time.Since(w.checkpointed) > downloadCheckpointInterval {
+		err = w.checkpoint(w.file)
+	}
+
+	return n, err
+}
+
+// checkpoint syncs the completed bytes of the part to disk and records them
+// with their checksum in the part file
+func (p *blobDownloadPart) checkpoint(file *os.File) error {
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	p.Checksum = hex.EncodeToString(p.hash.Sum(nil))
+	p.checkpointed = time.Now()
+	return p.blobDownload.writePart(p.Name(), p)
+}
+
+// verify checks the completed bytes of a part read from its part file
+// against its checksum, restarting the part if they don't match, e.g. if the
+// server stopped before they were written to disk. Parts written before
+// checksums were recorded are trusted.
+func (p *blobDownloadPart) verify(file *os.File) {
+	p.hash = sha256.New()
+	if p.Completed.Load() == 0 {
+		return
+	}
+
+	if file != nil {
+		_, err := io.Copy(p.hash, io.NewSectionReader(file, p.Offset, p.Completed.Load()))
+		if err == nil && (p.Checksum == "" || p.Checksum == hex.EncodeToString(p.hash.Sum(nil))) {
+			return
+		}
+	}
+
+	slog.Info(fmt.Sprintf("%s part %d failed verification, restarting it", p.Digest[7:19], p.N))
+	p.hash.Reset()
+	p.Completed.Store(0)
+	p.Checksum = ""
 }
 
 func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *registryOptions) error {
@@ -130,28 +226,57 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 
 	b.done = make(chan struct{})
 
+	// parts are verified against the data written before the download was
+	// interrupted
+	file, err := os.Open(b.Name + "-partial")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if file != nil {
+		defer file.Close()
+	}
+
 	for _, partFilePath := range partFilePaths {
+		if !partialPattern.MatchString(filepath.Base(partFilePath)) {
+			// an interrupted write of a part file
+			continue
+		}
+
 		part, err := b.readPart(partFilePath)
 		if err != nil {
 			return err
 		}
 
+		part.verify(file)
 		b.Total += part.Size
 		b.Completed.Add(part.Completed.Load())
 		b.Parts = append(b.Parts, part)
 	}
 
 	if len(b.Parts) == 0 {
-		resp, err := makeRequestWithRetry(ctx, http.MethodHead, requestURL, nil, nil, opts)
+		headers := make(http.Header)
+		if envconfig.ZstdTransfers() && b.size > 0 {
+			headers.Set("Accept-Encoding", "zstd")
+		}
+
+		resp, err := makeRequestWithRetry(ctx, http.MethodHead, requestURL, headers, nil, opts)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 
 		b.Total, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		if resp.Header.Get("Content-Encoding") == "zstd" {
+			b.compressed = true
+			b.Total = b.size
+		}
 
-		size := b.Total / numDownloadParts
+		// split the blob so every connection has a part, but keep parts
+		// large enough that requests aren't mostly overhead
+		size := b.Total / int64(cap(b.conns))
 		switch {
+		case b.compressed:
+			size = b.Total
 		case size < minDownloadPartSize:
 			size = minDownloadPartSize
 		case size > maxDownloadPartSize:
@@ -172,6 +297,11 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 		}
 	}
 
+	if b.compressed {
+		slog.Info(fmt.Sprintf("downloading %s compressed with zstd", b.Digest[7:19]))
+		return nil
+	}
+
 	slog.Info(fmt.Sprintf("downloading %s in %d %s part(s)", b.Digest[7:19], len(b.Parts), format.HumanBytes(b.Parts[0].Size)))
 	return nil
 }
@@ -220,6 +350,9 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 
 	_ = file.Truncate(b.Total)
 
+	// parts are downloaded from storage the registry redirects to without
+	// credentials, or from the registry itself with them
+	partOpts := &registryOptions{}
 	directURL, err := func() (*url.URL, error) {
 		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 		defer cancel()
@@ -256,10 +389,17 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 				continue
 			}
 			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusTemporaryRedirect {
+			switch resp.StatusCode {
+			case http.StatusTemporaryRedirect:
+				return resp.Location()
+			case http.StatusOK:
+				// registry caches and some OCI registries serve blobs
+				// themselves
+				partOpts = newOpts
+				return requestURL, nil
+			default:
 				return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
 			}
-			return resp.Location()
 		}
 	}()
 	if err != nil {
@@ -267,7 +407,7 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 	}
 
 	g, inner := errgroup.WithContext(ctx)
-	g.SetLimit(numDownloadParts)
+	g.SetLimit(cap(b.conns))
 	for i := range b.Parts {
 		part := b.Parts[i]
 		if part.Completed.Load() == part.Size {
@@ -275,10 +415,16 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 		}
 
 		g.Go(func() error {
+			select {
+			case b.conns <- struct{}{}:
+			case <-inner.Done():
+				return inner.Err()
+			}
+			defer func() { <-b.conns }()
+
 			var err error
 			for try := 0; try < maxRetries; try++ {
-				w := io.NewOffsetWriter(file, part.StartsAt())
-				err = b.downloadChunk(inner, directURL, w, part)
+				err = b.downloadChunk(inner, directURL, file, part, partOpts)
 				switch {
 				case errors.Is(err, context.Canceled), errors.Is(err, syscall.ENOSPC):
 					// return immediately if the context is canceled or the device is out of space
@@ -322,33 +468,46 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 	return nil
 }
 
-func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w io.Writer, part *blobDownloadPart) error {
+func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, file *os.File, part *blobDownloadPart, opts *registryOptions) error {
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
-		if err != nil {
-			return err
+		headers := make(http.Header)
+		if b.compressed && part.Completed.Load() == 0 {
+			headers.Set("Accept-Encoding", "zstd")
+		} else {
+			// a compressed stream can't be resumed part way, so an
+			// interrupted compressed download continues uncompressed
+			headers.Set("Range", fmt.Sprintf("bytes=%d-%d", part.StartsAt(), part.StopsAt()-1))
 		}
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", part.StartsAt(), part.StopsAt()-1))
-		resp, err := http.DefaultClient.Do(req)
+
+		resp, err := makeRequest(ctx, http.MethodGet, requestURL, headers, nil, opts)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 
-		n, err := io.CopyN(w, io.TeeReader(resp.Body, part), part.Size-part.Completed.Load())
-		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) {
-			// rollback progress
-			b.Completed.Add(-n)
-			return err
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("%d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
 		}
 
-		part.Completed.Add(n)
-		if err := b.writePart(part.Name(), part); err != nil {
-			return err
+		var body io.Reader = resp.Body
+		if resp.Header.Get("Content-Encoding") == "zstd" {
+			d, err := zstd.NewReader(resp.Body)
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			body = d
+		}
+
+		// bytes written before an error are kept, the next attempt
+		// continues after them
+		_, err = io.CopyN(partWriter{part, file}, body, part.Size-part.Completed.Load())
+		if cerr := part.checkpoint(file); err == nil {
+			err = cerr
 		}
 
-		// return nil or context.Canceled or UnexpectedEOF (resumable)
 		return err
 	})
 
@@ -384,7 +543,7 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 }
 
 func (b *blobDownload) newPart(offset, size int64) error {
-	part := blobDownloadPart{blobDownload: b, Offset: offset, Size: size, N: len(b.Parts)}
+	part := blobDownloadPart{blobDownload: b, Offset: offset, Size: size, N: len(b.Parts), hash: sha256.New()}
 	if err := b.writePart(part.Name(), &part); err != nil {
 		return err
 	}
@@ -409,14 +568,27 @@ func (b *blobDownload) readPart(partName string) (*blobDownloadPart, error) {
 	return &part, nil
 }
 
+// writePart replaces the part file so it's never left half written
 func (b *blobDownload) writePart(partName string, part *blobDownloadPart) error {
-	partFile, err := os.OpenFile(partName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	partFile, err := os.OpenFile(partName+".tmp", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
 	if err != nil {
 		return err
 	}
 	defer partFile.Close()
 
-	return json.NewEncoder(partFile).Encode(part)
+	if err := json.NewEncoder(partFile).Encode(part); err != nil {
+		return err
+	}
+
+	if err := partFile.Sync(); err != nil {
+		return err
+	}
+
+	if err := partFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(partName+".tmp", partName)
 }
 
 func (b *blobDownload) acquire() {
@@ -456,6 +628,12 @@ type downloadOpts struct {
 	digest  string
 	regOpts *registryOptions
 	fn      func(api.ProgressResponse)
+
+	// conns is shared by the downloads of a pull to limit its connections
+	conns chan struct{}
+
+	// size is the size of the blob in the manifest
+	size int64
 }
 
 // downloadBlob downloads a blob from the registry and stores it in the blobs directory
@@ -481,10 +659,14 @@ func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ erro
 		return true, nil
 	}
 
-	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest})
+	if opts.conns == nil {
+		opts.conns = make(chan struct{}, downloadConcurrency(opts.regOpts.Concurrency))
+	}
+
+	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest, conns: opts.conns, size: opts.size})
 	download := data.(*blobDownload)
 	if !ok {
-		requestURL := opts.mp.BaseURL()
+		requestURL := opts.mp.PullURL()
 		requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
 		if err := download.Prepare(ctx, requestURL, opts.regOpts); err != nil {
 			blobDownloadManager.Delete(opts.digest)
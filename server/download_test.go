@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDownloadConcurrency(t *testing.T) {
+	cases := []struct {
+		env    string
+		n      int
+		expect int
+	}{
+		{"", 0, defaultDownloadConcurrency},
+		{"", 4, 4},
+		{"2", 0, 2},
+		{"2", 8, 8},
+		{"0", 0, defaultDownloadConcurrency},
+		{"1000", 0, maxDownloadConcurrency},
+		{"", 1000, maxDownloadConcurrency},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprintf("%s/%d", tt.env, tt.n), func(t *testing.T) {
+			t.Setenv("OLLAMA_DOWNLOAD_CONCURRENCY", tt.env)
+			if actual := downloadConcurrency(tt.n); actual != tt.expect {
+				t.Errorf("expected %d, actual %d", tt.expect, actual)
+			}
+		})
+	}
+}
+
+func TestBlobDownloadConnections(t *testing.T) {
+	data := bytes.Repeat([]byte("ollama"), 1024)
+
+	var active, peak atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	b := &blobDownload{
+		Name:   filepath.Join(t.TempDir(), "blob"),
+		Digest: digest,
+		Total:  int64(len(data)),
+		conns:  make(chan struct{}, 2),
+	}
+	blobDownloadManager.Store(digest, b)
+
+	partSize := int64(len(data) / 8)
+	for offset := int64(0); offset < b.Total; offset += partSize {
+		if err := b.newPart(offset, partSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u, err := url.Parse(ts.URL + "/v2/library/test/blobs/" + digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.run(context.Background(), u, &registryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("expected at most 2 connections, actual %d", p)
+	}
+
+	bts, err := os.ReadFile(b.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bts, data) {
+		t.Error("downloaded blob doesn't match")
+	}
+}
+
+func TestBlobDownloadResume(t *testing.T) {
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	var served atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			served.Add(end - start + 1)
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	name := filepath.Join(t.TempDir(), "sha256-"+digest[7:])
+
+	// a download interrupted with the first part complete, half of the
+	// second, and the third part's checkpointed bytes lost
+	interrupted := &blobDownload{Name: name, Digest: digest, Total: int64(len(data))}
+	partSize := int64(len(data) / 4)
+	for offset := int64(0); offset < interrupted.Total; offset += partSize {
+		if err := interrupted.newPart(offset, partSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Create(name + "-partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, n := range []int64{partSize, partSize / 2, partSize / 2} {
+		part := interrupted.Parts[i]
+		if _, err := (partWriter{part, file}).Write(data[part.Offset : part.Offset+n]); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := part.checkpoint(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := file.WriteAt(make([]byte, partSize/2), 2*partSize); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// an interrupted write of a part file is ignored
+	if err := os.WriteFile(name+"-partial-0.tmp", []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(ts.URL + "/v2/library/test/blobs/" + digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &blobDownload{Name: name, Digest: digest, conns: make(chan struct{}, 4)}
+	blobDownloadManager.Store(digest, b)
+	if err := b.Prepare(context.Background(), u, &registryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.Parts) != 4 || b.Total != int64(len(data)) {
+		t.Fatalf("expected 4 parts of %d bytes, actual %d parts of %d bytes", len(data), len(b.Parts), b.Total)
+	}
+
+	if completed := b.Completed.Load(); completed != partSize+partSize/2 {
+		t.Errorf("expected %d verified bytes, actual %d", partSize+partSize/2, completed)
+	}
+
+	if err := b.run(context.Background(), u, &registryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := int64(len(data)) - partSize - partSize/2; served.Load() != expect {
+		t.Errorf("expected %d bytes to be downloaded, actual %d", expect, served.Load())
+	}
+
+	bts, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bts, data) {
+		t.Error("downloaded blob doesn't match")
+	}
+}
+
+func TestBlobDownloadZstd(t *testing.T) {
+	t.Setenv("OLLAMA_ZSTD_TRANSFERS", "1")
+
+	data := bytes.Repeat([]byte("ollama"), 64*1024)
+
+	var served atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" || r.Header.Get("Accept-Encoding") != "zstd" {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "zstd")
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		compressed := enc.EncodeAll(data, nil)
+		served.Add(int64(len(compressed)))
+		w.Write(compressed)
+	}))
+	defer ts.Close()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	b := &blobDownload{
+		Name:   filepath.Join(t.TempDir(), "sha256-"+digest[7:]),
+		Digest: digest,
+		conns:  make(chan struct{}, 4),
+		size:   int64(len(data)),
+	}
+	blobDownloadManager.Store(digest, b)
+
+	u, err := url.Parse(ts.URL + "/v2/library/test/blobs/" + digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Prepare(context.Background(), u, &registryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !b.compressed || len(b.Parts) != 1 || b.Total != int64(len(data)) {
+		t.Fatalf("expected one compressed part of %d bytes, actual %d parts of %d bytes", len(data), len(b.Parts), b.Total)
+	}
+
+	if err := b.run(context.Background(), u, &registryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := served.Load(); n == 0 || n >= int64(len(data)) {
+		t.Errorf("expected the blob to be sent compressed, actual %d bytes", n)
+	}
+
+	bts, err := os.ReadFile(b.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bts, data) {
+		t.Error("downloaded blob doesn't match")
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// server events
+const (
+	eventModelLoaded   = "model.loaded"
+	eventModelUnloaded = "model.unloaded"
+	eventModelEvicted  = "model.evicted"
+	eventRunnerCrashed = "runner.crashed"
+	eventPullStarted   = "pull.started"
+	eventPullCompleted = "pull.completed"
+	eventRequestFailed = "request.failed"
+	eventQueueChanged  = "queue.changed"
+)
+
+// eventsBufferSize is the number of events kept for a subscriber that isn't
+// reading them. Later events are dropped until it catches up.
+const eventsBufferSize = 64
+
+// eventsKeepAlive is how often a comment is sent to idle event streams so
+// proxies don't close them
+var eventsKeepAlive = 30 * time.Second
+
+// serverEvent is the JSON body of events sent to webhooks and /api/events
+type serverEvent struct {
+	Type  string         `json:"type"`
+	Time  time.Time      `json:"time"`
+	Model string         `json:"model,omitempty"`
+	Data  map[string]any `json:"data,omitempty"`
+}
+
+// eventBroker fans events out to the subscribers of /api/events
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan serverEvent]struct{}
+}
+
+var events eventBroker
+
+// subscribe returns a channel receiving events until unsubscribe is called
+func (b *eventBroker) subscribe() (ch chan serverEvent, unsubscribe func()) {
+	ch = make(chan serverEvent, eventsBufferSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan serverEvent]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, ch)
+	}
+}
+
+func (b *eventBroker) publish(e serverEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			slog.Debug("event subscriber is behind, dropping event", "event", e.Type)
+		}
+	}
+}
+
+// broadcast sends an event to /api/events subscribers only. Use notify for
+// events webhooks should also receive.
+func broadcast(event, model string, data map[string]any) serverEvent {
+	e := serverEvent{Type: event, Time: time.Now().UTC(), Model: model, Data: data}
+	events.publish(e)
+	return e
+}
+
+// EventsHandler streams server events to the client as server-sent events
+// until it disconnects. The types query parameter limits the stream to a
+// comma separated list of event types.
+func (s *Server) EventsHandler(c *gin.Context) {
+	var types []string
+	for _, t := range strings.Split(c.Query("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+
+	ch, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e := <-ch:
+			if len(types) > 0 && !slices.Contains(types, e.Type) {
+				continue
+			}
+
+			b, err := json.Marshal(e)
+			if err != nil {
+				slog.Warn("couldn't encode event", "event", e.Type, "error", err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readEvent reads the next event from a server-sent events stream,
+// skipping comments
+func readEvent(t *testing.T, r *bufio.Reader) (string, serverEvent) {
+	t.Helper()
+
+	var name string
+	var e serverEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if name != "" {
+				return name, e
+			}
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	keepAlive := eventsKeepAlive
+	eventsKeepAlive = 10 * time.Millisecond
+	t.Cleanup(func() { eventsKeepAlive = keepAlive })
+
+	var s Server
+	ts := httptest.NewServer(s.GenerateRoutes())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/events?types=model.loaded,queue.changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", resp.StatusCode)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	// filtered out
+	notify(eventModelUnloaded, "llama3:latest", nil)
+
+	notify(eventModelLoaded, "llama3:latest", map[string]any{"size_vram": 42})
+	broadcast(eventQueueChanged, "", map[string]any{"depth": 3})
+
+	r := bufio.NewReader(resp.Body)
+	name, e := readEvent(t, r)
+	if name != eventModelLoaded || e.Type != eventModelLoaded || e.Model != "llama3:latest" || e.Data["size_vram"] != float64(42) {
+		t.Errorf("unexpected event %s %+v", name, e)
+	}
+
+	name, e = readEvent(t, r)
+	if name != eventQueueChanged || e.Data["depth"] != float64(3) {
+		t.Errorf("unexpected event %s %+v", name, e)
+	}
+}
+
+func TestEventBrokerSlowSubscriber(t *testing.T) {
+	var b eventBroker
+	ch, unsubscribe := b.subscribe()
+
+	for range eventsBufferSize + 1 {
+		b.publish(serverEvent{Type: eventQueueChanged})
+	}
+
+	if len(ch) != eventsBufferSize {
+		t.Errorf("expected %d buffered events, actual %d", eventsBufferSize, len(ch))
+	}
+
+	unsubscribe()
+	b.publish(serverEvent{Type: eventQueueChanged})
+	if len(ch) != eventsBufferSize {
+		t.Errorf("unsubscribed channel was sent an event")
+	}
+}
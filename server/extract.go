@@ -0,0 +1,188 @@
+package server
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// extractFile is a file of an extracted model, either a blob at path or data
+type extractFile struct {
+	name string
+	path string
+	data []byte
+}
+
+// extractName names the i-th of n files, numbering them if there's more than
+// one, e.g. adapter.gguf or adapter-2.gguf
+func extractName(name, ext string, i, n int) string {
+	if n == 1 {
+		return name + ext
+	}
+
+	return fmt.Sprintf("%s-%d%s", name, i+1, ext)
+}
+
+// extractFiles returns the files of m with friendly names for use outside of
+// ollama, and a Modelfile which creates m from them
+func extractFiles(m *Model) ([]extractFile, error) {
+	var files []extractFile
+
+	// the Modelfile refers to the extracted files rather than blobs
+	mf := *m
+	blob := func(path, name string) string {
+		files = append(files, extractFile{name: name, path: path})
+		return "./" + name
+	}
+
+	if m.ModelPath != "" {
+		mf.ModelPath = blob(m.ModelPath, "model.gguf")
+	}
+
+	mf.AdapterPaths = make([]string, len(m.AdapterPaths))
+	for i, p := range m.AdapterPaths {
+		mf.AdapterPaths[i] = blob(p, extractName("adapter", ".gguf", i, len(m.AdapterPaths)))
+	}
+
+	mf.ProjectorPaths = make([]string, len(m.ProjectorPaths))
+	for i, p := range m.ProjectorPaths {
+		mf.ProjectorPaths[i] = blob(p, extractName("projector", ".gguf", i, len(m.ProjectorPaths)))
+	}
+
+	if m.ClassifierPath != "" {
+		mf.ClassifierPath = blob(m.ClassifierPath, "classifier.gguf")
+	}
+
+	if m.VocoderPath != "" {
+		mf.VocoderPath = blob(m.VocoderPath, "vocoder.gguf")
+	}
+
+	if m.Template != nil {
+		files = append(files, extractFile{name: "template.txt", data: []byte(m.Template.String())})
+	}
+
+	if m.System != "" {
+		files = append(files, extractFile{name: "system.txt", data: []byte(m.System)})
+	}
+
+	for i, license := range m.License {
+		files = append(files, extractFile{name: extractName("license", ".txt", i, len(m.License)), data: []byte(license)})
+	}
+
+	for _, f := range []struct {
+		name string
+		v    any
+		ok   bool
+	}{
+		{"params.json", m.Options, len(m.Options) > 0},
+		{"messages.json", m.Messages, len(m.Messages) > 0},
+		{"tools.json", m.Tools, len(m.Tools) > 0},
+	} {
+		if !f.ok {
+			continue
+		}
+
+		bts, err := json.MarshalIndent(f.v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, extractFile{name: f.name, data: bts})
+	}
+
+	files = append(files, extractFile{name: "Modelfile", data: []byte(mf.String())})
+	return files, nil
+}
+
+// writeExtract writes files to w as a tar archive
+func writeExtract(w io.Writer, files []extractFile) error {
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		if f.path == "" {
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: f.name, Mode: 0o644, Size: int64(len(f.data)), ModTime: time.Now()}); err != nil {
+				return err
+			}
+
+			if _, err := tw.Write(f.data); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := func() error {
+			blob, err := os.Open(f.path)
+			if err != nil {
+				return err
+			}
+			defer blob.Close()
+
+			fi, err := blob.Stat()
+			if err != nil {
+				return err
+			}
+
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: f.name, Mode: 0o644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+				return err
+			}
+
+			_, err = io.Copy(tw, blob)
+			return err
+		}(); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func (s *Server) ExtractHandler(c *gin.Context) {
+	var req api.ExtractRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !model.ParseName(req.Model).IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid model name"})
+		return
+	}
+
+	m, err := GetModel(req.Model)
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	files, err := extractFiles(m)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Status(http.StatusOK)
+	if err := writeExtract(c.Writer, files); err != nil {
+		// the response has started so the archive can only be cut short
+		slog.Error("extracting model failed", "model", req.Model, "error", err)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestExtractHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	var s Server
+
+	base := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name: "persona",
+		Modelfile: fmt.Sprintf("FROM %s\nADAPTER %s\nADAPTER %s\nTEMPLATE {{ .Prompt }}\nSYSTEM You are a pirate.\nPARAMETER temperature 0.5",
+			base,
+			createBinFile(t, map[string]any{"general.type": "adapter", "general.name": "a"}, nil),
+			createBinFile(t, map[string]any{"general.type": "adapter", "general.name": "b"}, nil),
+		),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.ExtractHandler, api.ExtractRequest{Model: "persona"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	files := make(map[string][]byte)
+	var names []string
+	tr := tar.NewReader(w.Body)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		bts, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, hdr.Name)
+		files[hdr.Name] = bts
+	}
+
+	expect := []string{"model.gguf", "adapter-1.gguf", "adapter-2.gguf", "template.txt", "system.txt", "params.json", "Modelfile"}
+	if !slices.Equal(names, expect) {
+		t.Fatalf("expected files %v, actual %v", expect, names)
+	}
+
+	bts, err := os.ReadFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(files["model.gguf"], bts) {
+		t.Error("model.gguf doesn't match the model")
+	}
+
+	if s := string(files["system.txt"]); s != "You are a pirate." {
+		t.Errorf("unexpected system.txt %q", s)
+	}
+
+	modelfile := string(files["Modelfile"])
+	for _, s := range []string{"FROM ./model.gguf", "ADAPTER ./adapter-1.gguf", "ADAPTER ./adapter-2.gguf", "PARAMETER temperature 0.5"} {
+		if !strings.Contains(modelfile, s) {
+			t.Errorf("expected Modelfile to contain %q:\n%s", s, modelfile)
+		}
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		w := createRequest(t, s.ExtractHandler, api.ExtractRequest{Model: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status code 404, actual %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/format"
+)
+
+const (
+	// gcGracePeriod keeps new blobs a pull or create hasn't written the
+	// manifest of yet
+	gcGracePeriod = time.Hour
+
+	// gcPartialAge is how long partial downloads are kept to be resumed
+	gcPartialAge = 24 * time.Hour
+)
+
+var (
+	blobPattern    = regexp.MustCompile(`^sha256-[0-9a-fA-F]{64}$`)
+	partialPattern = regexp.MustCompile(`^(sha256-[0-9a-fA-F]{64})-partial(-\d+)?$`)
+)
+
+// collectGarbage removes the blobs of the models directories which no
+// manifest references and the partial downloads and temporary files which
+// haven't been written to in gcPartialAge, and links the copies of blobs in
+// several models directories
+func collectGarbage(dryRun bool) (api.GCResponse, error) {
+	var resp api.GCResponse
+
+	ms, err := Manifests()
+	if err != nil {
+		return resp, err
+	}
+
+	used := make(map[string]bool)
+	for _, m := range ms {
+		for _, layer := range append(m.Layers, m.Config) {
+			used[strings.ReplaceAll(layer.Digest, ":", "-")] = true
+		}
+	}
+
+	dirs, err := modelsSubdirs("blobs")
+	if err != nil {
+		return resp, err
+	}
+
+	active := func(name string) bool {
+		digest := strings.Replace(name, "-", ":", 1)
+		_, downloading := blobDownloadManager.Load(digest)
+		_, uploading := blobUploadManager.Load(digest)
+		return downloading || uploading
+	}
+
+	// the files to remove, with blobs linked to each other reclaiming their
+	// storage once
+	var remove []string
+	var removeInfo []os.FileInfo
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return resp, err
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			fi, err := entry.Info()
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+
+			age := time.Since(fi.ModTime())
+			switch {
+			case blobPattern.MatchString(name):
+				if used[name] || active(name) || age < gcGracePeriod {
+					continue
+				}
+
+				resp.Blobs++
+			case partialPattern.MatchString(name):
+				if active(partialPattern.FindStringSubmatch(name)[1]) || age < gcPartialAge {
+					continue
+				}
+
+				resp.Partials++
+			case strings.HasPrefix(name, "sha256-"):
+				// temporary files of creates and downloads from the object store
+				if age < gcPartialAge {
+					continue
+				}
+
+				resp.Partials++
+			default:
+				continue
+			}
+
+			remove = append(remove, filepath.Join(dir, name))
+			removeInfo = append(removeInfo, fi)
+		}
+	}
+
+	resp.Reclaimed = distinctSize(removeInfo)
+	if !dryRun {
+		for _, p := range remove {
+			slog.Debug("removing unused blob", "path", p)
+			if err := os.Remove(p); err != nil {
+				return resp, err
+			}
+		}
+	}
+
+	linked, freed, err := dedupBlobs(used, dryRun)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Linked = linked
+	resp.Reclaimed += freed
+	return resp, nil
+}
+
+// runGC collects garbage every interval until ctx is done
+func runGC(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resp, err := collectGarbage(false)
+			if err != nil {
+				slog.Warn("garbage collection failed", "error", err)
+				continue
+			}
+
+			if resp.Reclaimed > 0 {
+				slog.Info("garbage collection", "blobs", resp.Blobs, "partials", resp.Partials, "linked", resp.Linked, "reclaimed", format.HumanBytes(resp.Reclaimed))
+			}
+		}
+	}
+}
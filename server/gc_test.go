@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	blobs := filepath.Join(p, "blobs")
+	used, err := filepath.Glob(filepath.Join(blobs, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	write := func(name string, size int, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(blobs, name), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(filepath.Join(blobs, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("sha256-"+strings.Repeat("a", 64), 10, old)
+	write("sha256-"+strings.Repeat("b", 64), 20, time.Now())
+	write("sha256-"+strings.Repeat("c", 64)+"-partial", 30, old)
+	write("sha256-"+strings.Repeat("c", 64)+"-partial-0", 40, old)
+	write("sha256-"+strings.Repeat("d", 64)+"-partial", 50, time.Now())
+	write("sha256-123456789", 60, old)
+
+	for _, blob := range used {
+		if err := os.Chtimes(blob, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := api.GCResponse{Blobs: 1, Partials: 3, Reclaimed: 140}
+
+	resp, err := collectGarbage(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp != expect {
+		t.Errorf("expected %+v, got %+v", expect, resp)
+	}
+
+	// dry runs don't remove anything
+	if entries, _ := os.ReadDir(blobs); len(entries) != len(used)+6 {
+		t.Errorf("expected %d blobs, got %d", len(used)+6, len(entries))
+	}
+
+	w = createRequest(t, s.GCHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	kept := append(used,
+		filepath.Join(blobs, "sha256-"+strings.Repeat("b", 64)),
+		filepath.Join(blobs, "sha256-"+strings.Repeat("d", 64)+"-partial"),
+	)
+	slices.Sort(kept)
+	checkFileExists(t, filepath.Join(blobs, "*"), kept)
+
+	if _, err := GetModel("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = collectGarbage(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp != (api.GCResponse{}) {
+		t.Errorf("expected nothing to collect, got %+v", resp)
+	}
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// defaultHistoryLimit is how many requests the history returns unless the
+// request sets a limit
+const defaultHistoryLimit = 100
+
+// historyDay is the layout of the days history files are named after
+const historyDay = "2006-01-02"
+
+// historyStore keeps a summary of every request served, so usage can be
+// reported across restarts. Summaries are appended as JSON lines to a file
+// for each day, in UTC, and the files of days older than the retention are
+// removed.
+type historyStore struct {
+	dir       string
+	retention time.Duration
+
+	mu  sync.Mutex
+	day string // day of f
+	f   *os.File
+}
+
+func openHistory(dir string, retention time.Duration) (*historyStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	h := &historyStore{dir: dir, retention: retention}
+	h.prune(time.Now())
+	return h, nil
+}
+
+func (h *historyStore) path(day string) string {
+	return filepath.Join(h.dir, day+".jsonl")
+}
+
+// days returns the days of the history files, the latest first
+func (h *historyStore) days() ([]time.Time, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var days []time.Time
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		if day, err := time.Parse(historyDay, name); err == nil {
+			days = append(days, day)
+		}
+	}
+
+	slices.SortFunc(days, func(a, b time.Time) int {
+		return b.Compare(a)
+	})
+
+	return days, nil
+}
+
+// prune removes the files of the days which ended before the retention
+func (h *historyStore) prune(now time.Time) {
+	days, err := h.days()
+	if err != nil {
+		slog.Warn("failed to prune usage history", "error", err)
+		return
+	}
+
+	for _, day := range days {
+		if day.AddDate(0, 0, 1).Before(now.Add(-h.retention)) {
+			if err := os.Remove(h.path(day.Format(historyDay))); err != nil {
+				slog.Warn("failed to prune usage history", "error", err)
+			}
+		}
+	}
+}
+
+// record appends e to the history file of its day
+func (h *historyStore) record(e api.HistoryEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if day := e.Time.UTC().Format(historyDay); day != h.day {
+		if h.f != nil {
+			h.f.Close()
+			h.f, h.day = nil, ""
+		}
+
+		f, err := os.OpenFile(h.path(day), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+
+		h.f, h.day = f, day
+		h.prune(e.Time)
+	}
+
+	_, err = h.f.Write(append(b, '\n'))
+	return err
+}
+
+// close closes the history file of the current day
+func (h *historyStore) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.f != nil {
+		h.f.Close()
+		h.f, h.day = nil, ""
+	}
+}
+
+// query returns the requests in the history which match req as of now, the
+// latest first
+func (h *historyStore) query(req api.HistoryRequest, now time.Time) ([]api.HistoryEntry, error) {
+	since := now.Add(-h.retention)
+	if req.Since.After(since) {
+		since = req.Since
+	}
+
+	var name model.Name
+	if req.Model != "" {
+		name = model.ParseName(req.Model)
+	}
+
+	limit := cmp.Or(req.Limit, defaultHistoryLimit)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	days, err := h.days()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []api.HistoryEntry{}
+	for _, day := range days {
+		if len(entries) >= limit || day.AddDate(0, 0, 1).Before(since) {
+			break
+		}
+
+		if !req.Until.IsZero() && day.After(req.Until) {
+			continue
+		}
+
+		dayEntries, err := h.read(day.Format(historyDay), func(e api.HistoryEntry) bool {
+			if e.Time.Before(since) || (!req.Until.IsZero() && e.Time.After(req.Until)) {
+				return false
+			}
+
+			return req.Model == "" || strings.EqualFold(model.ParseName(e.Model).String(), name.String())
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		slices.SortStableFunc(dayEntries, func(a, b api.HistoryEntry) int {
+			return b.Time.Compare(a.Time)
+		})
+		entries = append(entries, dayEntries...)
+	}
+
+	return entries[:min(len(entries), limit)], nil
+}
+
+// read returns the requests in the history file of day which match
+func (h *historyStore) read(day string, match func(api.HistoryEntry) bool) ([]api.HistoryEntry, error) {
+	f, err := os.Open(h.path(day))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []api.HistoryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e api.HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// the last line is cut off if the server was killed writing it
+			continue
+		}
+
+		if match(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// record adds a request which the model served to its stats, and to the
+// usage history if it's enabled
+func (s *Server) record(c *gin.Context, model string, sample requestSample) {
+	if s.stats != nil {
+		s.stats.record(model, sample)
+	}
+
+	if s.history == nil {
+		return
+	}
+
+	e := api.HistoryEntry{
+		Time:               sample.time.Add(-sample.latency).UTC(),
+		Model:              model,
+		Endpoint:           c.Request.URL.Path,
+		RequestID:          requestID(c),
+		Status:             "success",
+		PromptEvalCount:    sample.promptTokens,
+		EvalCount:          sample.evalTokens,
+		TotalDuration:      sample.latency,
+		PromptEvalDuration: sample.promptDuration,
+		EvalDuration:       sample.evalDuration,
+	}
+	if sample.failed {
+		e.Status = "error"
+	}
+
+	if err := s.history.record(e); err != nil {
+		slog.WarnContext(c.Request.Context(), "failed to record usage history", "error", err)
+	}
+}
+
+// HistoryHandler returns the requests which were served from the usage
+// history. The model, since, until and limit query parameters filter them
+// like [api.HistoryRequest].
+func (s *Server) HistoryHandler(c *gin.Context) {
+	if s.history == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage history is disabled"})
+		return
+	}
+
+	req := api.HistoryRequest{Model: c.Query("model")}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &req.Since}, {"until", &req.Until}} {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + p.name + ", expected an RFC 3339 time"})
+				return
+			}
+			*p.t = t
+		}
+	}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		req.Limit = n
+	}
+
+	entries, err := s.history.query(req, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.HistoryResponse{Requests: entries})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	dir := t.TempDir()
+	h, err := openHistory(dir, 48*time.Hour)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(24 * time.Hour).Add(12 * time.Hour)
+	for _, e := range []api.HistoryEntry{
+		{Time: now.Add(-72 * time.Hour), Model: "llama3:latest", Status: "success"},
+		{Time: now.Add(-25 * time.Hour), Model: "llama3:latest", Status: "success", EvalCount: 10},
+		{Time: now.Add(-time.Hour), Model: "all-minilm:latest", Status: "success", PromptEvalCount: 5},
+		{Time: now.Add(-time.Minute), Model: "llama3:latest", Status: "error"},
+	} {
+		require.NoError(t, h.record(e))
+	}
+
+	// the day before the retention is pruned once the store moves on to a
+	// new day
+	assert.NoFileExists(t, filepath.Join(dir, now.AddDate(0, 0, -3).Format(historyDay)+".jsonl"))
+
+	entries, err := h.query(api.HistoryRequest{}, now)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "error", entries[0].Status)
+	assert.Equal(t, "all-minilm:latest", entries[1].Model)
+	assert.Equal(t, 10, entries[2].EvalCount)
+
+	entries, err = h.query(api.HistoryRequest{Model: "llama3"}, now)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	entries, err = h.query(api.HistoryRequest{Since: now.Add(-2 * time.Hour), Until: now.Add(-30 * time.Minute)}, now)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "all-minilm:latest", entries[0].Model)
+
+	entries, err = h.query(api.HistoryRequest{Limit: 1}, now)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "error", entries[0].Status)
+
+	// the history survives reopening it, and lines cut off are skipped
+	f, err := os.OpenFile(filepath.Join(dir, now.Format(historyDay)+".jsonl"), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"time":"` + now.Format(time.RFC3339) + `","mod`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	h, err = openHistory(dir, 48*time.Hour)
+	require.NoError(t, err)
+	entries, err = h.query(api.HistoryRequest{}, now)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestHistoryHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	h, err := openHistory(t.TempDir(), time.Hour)
+	require.NoError(t, err)
+	s := Server{history: h}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+	c.Request = c.Request.WithContext(llm.WithRequestID(c.Request.Context(), "req-1"))
+	s.record(c, "llama3:latest", requestSample{time: time.Now(), latency: time.Second, evalTokens: 20, evalDuration: 500 * time.Millisecond})
+
+	t.Run("query", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/history?model=llama3&limit=10", nil)
+		s.HistoryHandler(c)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp api.HistoryResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Len(t, resp.Requests, 1)
+		e := resp.Requests[0]
+		assert.Equal(t, "llama3:latest", e.Model)
+		assert.Equal(t, "/api/chat", e.Endpoint)
+		assert.Equal(t, "req-1", e.RequestID)
+		assert.Equal(t, "success", e.Status)
+		assert.Equal(t, 20, e.EvalCount)
+		assert.Equal(t, time.Second, e.TotalDuration)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/history?since=yesterday", nil)
+		s.HistoryHandler(c)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var s Server
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/history", nil)
+		s.HistoryHandler(c)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
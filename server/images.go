@@ -13,6 +13,7 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -21,6 +22,10 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/auth"
@@ -39,6 +44,10 @@ var (
 	errCapabilityCompletion = errors.New("completion")
 	errCapabilityTools      = errors.New("tools")
 	errCapabilityInsert     = errors.New("insert")
+	errCapabilityClassify   = errors.New("classification")
+	errCapabilityRerank     = errors.New("rerank")
+	errCapabilitySpeech     = errors.New("speech")
+	errAdapter              = errors.New("invalid adapter")
 )
 
 type Capability string
@@ -47,6 +56,9 @@ const (
 	CapabilityCompletion = Capability("completion")
 	CapabilityTools      = Capability("tools")
 	CapabilityInsert     = Capability("insert")
+	CapabilityClassify   = Capability("classification")
+	CapabilityRerank     = Capability("rerank")
+	CapabilitySpeech     = Capability("speech")
 )
 
 type registryOptions struct {
@@ -55,6 +67,10 @@ type registryOptions struct {
 	Password string
 	Token    string
 
+	// Concurrency is the number of connections a pull downloads or a push
+	// uploads with, see downloadConcurrency and uploadConcurrency
+	Concurrency int
+
 	CheckRedirect func(req *http.Request, via []*http.Request) error
 }
 
@@ -66,15 +82,60 @@ type Model struct {
 	ParentModel    string
 	AdapterPaths   []string
 	ProjectorPaths []string
+	ClassifierPath string
+	VocoderPath    string
 	System         string
 	License        []string
 	Digest         string
 	Options        map[string]interface{}
 	Messages       []api.Message
+	Tools          api.Tools
 
 	Template *template.Template
 }
 
+// adapterPaths returns the LoRA adapters of the model name to apply to a
+// request against m and sets their scales in opts. The model must be created
+// from the same base model as m. Its adapter_scale parameter is used unless
+// the request sets one.
+func (m *Model) adapterPaths(name string, requestOpts map[string]any, opts *api.Options) ([]string, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	a, err := m.adapter(name)
+	if err != nil {
+		return nil, err
+	}
+
+	adapterOpts, err := modelOptions(a, requestOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.AdapterScale = adapterOpts.AdapterScale
+	return a.AdapterPaths, nil
+}
+
+// adapter returns the model name whose LoRA adapters can be applied to
+// requests against m
+func (m *Model) adapter(name string) (*Model, error) {
+	a, err := GetModel(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", errAdapter, name, err)
+	}
+
+	if a.ModelPath != m.ModelPath {
+		return nil, fmt.Errorf("%w %q: not created from the same base model as %q", errAdapter, name, m.ShortName)
+	}
+
+	if len(a.AdapterPaths) == 0 {
+		return nil, fmt.Errorf("%w %q: model has no adapters", errAdapter, name)
+	}
+
+	return a, nil
+}
+
 // CheckCapabilities checks if the model has the specified capabilities returning an error describing
 // any missing or unknown capabilities
 func (m *Model) CheckCapabilities(caps ...Capability) error {
@@ -96,7 +157,7 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 				continue
 			}
 
-			if _, ok := ggml.KV()[fmt.Sprintf("%s.pooling_type", ggml.KV().Architecture())]; ok {
+			if _, ok := ggml.KV()[fmt.Sprintf("%s.pooling_type", ggml.KV().Architecture())]; ok || m.ClassifierPath != "" || m.VocoderPath != "" {
 				errs = append(errs, errCapabilityCompletion)
 			}
 		case CapabilityTools:
@@ -108,6 +169,30 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 			if !slices.Contains(vars, "suffix") {
 				errs = append(errs, errCapabilityInsert)
 			}
+		case CapabilityClassify:
+			if m.ClassifierPath == "" {
+				errs = append(errs, errCapabilityClassify)
+			}
+		case CapabilityRerank:
+			if m.ClassifierPath == "" {
+				errs = append(errs, errCapabilityRerank)
+				continue
+			}
+
+			// rerankers score pairs with a single output
+			classifier, err := loadClassifier(m.ClassifierPath)
+			if err != nil {
+				slog.Error("couldn't load classifier", "error", err)
+				continue
+			}
+
+			if len(classifier.Labels) != 1 {
+				errs = append(errs, errCapabilityRerank)
+			}
+		case CapabilitySpeech:
+			if m.VocoderPath == "" {
+				errs = append(errs, errCapabilitySpeech)
+			}
 		default:
 			slog.Error("unknown capability", "capability", cap)
 			return fmt.Errorf("unknown capability: %s", cap)
@@ -143,6 +228,20 @@ func (m *Model) String() string {
 		})
 	}
 
+	if m.ClassifierPath != "" {
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "model",
+			Args: m.ClassifierPath,
+		})
+	}
+
+	if m.VocoderPath != "" {
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "model",
+			Args: m.VocoderPath,
+		})
+	}
+
 	if m.Template != nil {
 		modelfile.Commands = append(modelfile.Commands, parser.Command{
 			Name: "template",
@@ -157,6 +256,20 @@ func (m *Model) String() string {
 		})
 	}
 
+	if m.Config.KeepAlive != nil {
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "keep_alive",
+			Args: formatDuration(*m.Config.KeepAlive),
+		})
+	}
+
+	if m.Config.LoadTimeout != nil {
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "load_timeout",
+			Args: formatDuration(*m.Config.LoadTimeout),
+		})
+	}
+
 	for k, v := range m.Options {
 		switch v := v.(type) {
 		case []any:
@@ -188,6 +301,24 @@ func (m *Model) String() string {
 		})
 	}
 
+	if len(m.Tools) > 0 {
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "tools",
+			Args: m.Tools.String(),
+		})
+	}
+
+	if md := m.Config.Metadata; md != nil {
+		for _, kv := range [][2]string{{"description", md.Description}, {"author", md.Author}, {"tags", strings.Join(md.Tags, ", ")}} {
+			if kv[1] != "" {
+				modelfile.Commands = append(modelfile.Commands, parser.Command{
+					Name: "metadata",
+					Args: kv[0] + ": " + kv[1],
+				})
+			}
+		}
+	}
+
 	return modelfile.String()
 }
 
@@ -198,6 +329,18 @@ type ConfigV2 struct {
 	ModelType     string   `json:"model_type"`
 	FileType      string   `json:"file_type"`
 
+	// KeepAlive is how long the model stays loaded after a request which
+	// doesn't set keep_alive, overriding OLLAMA_KEEP_ALIVE.
+	KeepAlive *api.Duration `json:"keep_alive,omitempty"`
+
+	// LoadTimeout is how long loading the model may go without progress,
+	// overriding OLLAMA_LOAD_TIMEOUT.
+	LoadTimeout *api.Duration `json:"load_timeout,omitempty"`
+
+	// Metadata is the description, author and tags of the model set with
+	// METADATA. It isn't inherited from the model it's created from.
+	Metadata *api.ModelMetadata `json:"metadata,omitempty"`
+
 	// required by spec
 	Architecture string `json:"architecture"`
 	OS           string `json:"os"`
@@ -215,6 +358,10 @@ func GetManifest(mp ModelPath) (*Manifest, string, error) {
 		return nil, "", err
 	}
 
+	if err := fetchManifest(filepath.Join(mp.Registry, mp.Namespace, mp.Repository, mp.Tag)); err != nil {
+		return nil, "", err
+	}
+
 	if _, err = os.Stat(fp); err != nil {
 		return nil, "", err
 	}
@@ -243,6 +390,10 @@ func GetModel(name string) (*Model, error) {
 		return nil, err
 	}
 
+	if err := fetchBlobs(manifest); err != nil {
+		return nil, err
+	}
+
 	model := &Model{
 		Name:      mp.GetFullTagname(),
 		ShortName: mp.GetShortTagname(),
@@ -285,6 +436,10 @@ func GetModel(name string) (*Model, error) {
 			model.AdapterPaths = append(model.AdapterPaths, filename)
 		case "application/vnd.ollama.image.projector":
 			model.ProjectorPaths = append(model.ProjectorPaths, filename)
+		case "application/vnd.ollama.image.classifier":
+			model.ClassifierPath = filename
+		case "application/vnd.ollama.image.vocoder":
+			model.VocoderPath = filename
 		case "application/vnd.ollama.image.prompt",
 			"application/vnd.ollama.image.template":
 			bts, err := os.ReadFile(filename)
@@ -324,6 +479,16 @@ func GetModel(name string) (*Model, error) {
 			if err = json.NewDecoder(msgs).Decode(&model.Messages); err != nil {
 				return nil, err
 			}
+		case "application/vnd.ollama.image.tools":
+			tools, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer tools.Close()
+
+			if err = json.NewDecoder(tools).Decode(&model.Tools); err != nil {
+				return nil, err
+			}
 		case "application/vnd.ollama.image.license":
 			bts, err := os.ReadFile(filename)
 			if err != nil {
@@ -336,6 +501,45 @@ func GetModel(name string) (*Model, error) {
 	return model, nil
 }
 
+// checkToolsTemplate checks the template of a model with TOOLS, its own or
+// inherited, can give the tools to the model, so chat requests offering them
+// don't fail
+func checkToolsTemplate(layers []Layer) error {
+	if !slices.ContainsFunc(layers, func(layer Layer) bool {
+		return layer.MediaType == "application/vnd.ollama.image.tools"
+	}) {
+		return nil
+	}
+
+	for _, layer := range layers {
+		if layer.MediaType != "application/vnd.ollama.image.template" {
+			continue
+		}
+
+		r, err := layer.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		bts, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := template.Parse(string(bts))
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadTemplate, err)
+		}
+
+		if slices.Contains(tmpl.Vars(), "tools") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: TOOLS requires a template which uses .Tools", errBadTemplate)
+}
+
 func realpath(rel, from string) string {
 	abspath, err := filepath.Abs(from)
 	if err != nil {
@@ -361,7 +565,133 @@ func realpath(rel, from string) string {
 	return abspath
 }
 
-func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantization string, modelfile *parser.File, fn func(resp api.ProgressResponse)) (err error) {
+// watchSize reports the growing size of the file at path through fn until
+// stop is called, for steps like quantizing which don't report their own
+// progress. The size is capped at total, which can be an estimate.
+func watchSize(path, status string, total int64, fn func(api.ProgressResponse)) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if fi, err := os.Stat(path); err == nil {
+					fn(api.ProgressResponse{Status: status, Total: total, Completed: min(fi.Size(), total)})
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// quantizeLayer quantizes the GGUF model in layer, replacing it, if it isn't
+// already of type quantization
+func quantizeLayer(ctx context.Context, baseLayer *layerGGML, quantization, imatrix string, fn func(api.ProgressResponse)) error {
+	if baseLayer.MediaType != "application/vnd.ollama.image.model" ||
+		baseLayer.GGML == nil ||
+		baseLayer.GGML.Name() != "gguf" {
+		return nil
+	}
+
+	want, err := llm.ParseQuantizationType(quantization)
+	if err != nil {
+		return err
+	}
+
+	// Q8_0 models are close enough to F16 to be requantized to smaller types
+	ft := baseLayer.GGML.KV().FileType()
+	if !slices.Contains([]string{"F16", "BF16", "F32", "Q8_0"}, ft.String()) {
+		return errors.New("quantization is only supported for F16, BF16, F32 and Q8_0 models")
+	} else if ft.String() == "Q8_0" && want != ft && want.BitsPerWeight() >= ft.BitsPerWeight() {
+		return fmt.Errorf("Q8_0 models can't be quantized to %s, which isn't smaller", want)
+	} else if want != ft {
+		blob, err := GetBlobsPath(baseLayer.Digest)
+		if err != nil {
+			return err
+		}
+
+		var imatrixPath string
+		if imatrix != "" {
+			p, remove, err := importanceMatrix(ctx, blob, imatrix, fn)
+			if err != nil {
+				return err
+			}
+			defer remove()
+
+			imatrixPath = p
+		}
+
+		size := want.EstimateSize(baseLayer.GGML.KV().ParameterCount())
+		status := fmt.Sprintf("quantizing %s model to %s, about %s", ft, want, format.HumanBytes(int64(size)))
+		fn(api.ProgressResponse{Status: status})
+
+		temp, err := os.CreateTemp(filepath.Dir(blob), quantization)
+		if err != nil {
+			return err
+		}
+		defer temp.Close()
+		defer os.Remove(temp.Name())
+
+		stop := watchSize(temp.Name(), status, int64(size), fn)
+		err = llm.Quantize(blob, temp.Name(), want, imatrixPath)
+		stop()
+		if err != nil {
+			return err
+		}
+
+		layer, err := NewLayer(temp, baseLayer.MediaType)
+		if err != nil {
+			return err
+		}
+
+		if _, err := temp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		ggml, _, err := llm.DecodeGGML(temp, 0)
+		if err != nil {
+			return err
+		}
+
+		baseLayer.Layer = layer
+		baseLayer.GGML = ggml
+	}
+
+	return nil
+}
+
+// CreateOptions are the options of CreateModel, which change the model
+// created from a Modelfile, like those of [api.CreateRequest]
+type CreateOptions struct {
+	// Quantization is the type to quantize the model's tensors to, if any
+	Quantization string
+
+	// Imatrix is the digest of the importance matrix, or calibration text,
+	// weighting the quantization
+	Imatrix string
+
+	// Check evaluates the created model and warns if it doesn't work
+	Check bool
+
+	// MergeAdapters folds the model's adapters into its weights
+	MergeAdapters bool
+
+	// OverrideKV sets keys of the model's GGUF metadata
+	OverrideKV map[string]string
+}
+
+func CreateModel(ctx context.Context, name model.Name, modelFileDir string, opts CreateOptions, modelfile *parser.File, fn func(resp api.ProgressResponse)) (err error) {
 	config := ConfigV2{
 		OS:           "linux",
 		Architecture: "amd64",
@@ -371,22 +701,68 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 	}
 
 	var messages []*api.Message
+	var tools api.Tools
 	parameters := make(map[string]any)
 
+	// adapters are applied with the scales of their ADAPTER commands, or 1,
+	// and must be for the architecture of the model
+	var adapterScales []float32
+	var adapterScaled bool
+	var modelArch string
+	var adapterArchs []string
+
+	// adapters to merge into the model rather than keep as layers
+	var mergeLayers []*layerGGML
+
+	// models quantized to BF16 are converted to it directly, rather than to
+	// F16 first which loses the range of BF16 and FP8 checkpoints
+	bf16 := opts.Quantization == "BF16"
+
+	// the model's tokens are used by templates converted from jinja
+	var modelLayer *layerGGML
+
 	var layers []Layer
 	for _, c := range modelfile.Commands {
 		mediatype := fmt.Sprintf("application/vnd.ollama.image.%s", c.Name)
 
 		switch c.Name {
 		case "model", "adapter":
+			args, scale := c.Args, ""
+			if c.Name == "adapter" {
+				args, scale = parser.SplitAdapter(c.Args)
+			}
+
+			adapterScale := float32(1)
+			if scale != "" {
+				f, err := strconv.ParseFloat(scale, 32)
+				if err != nil {
+					return err
+				}
+
+				adapterScale, adapterScaled = float32(f), true
+			}
+
+			// safetensors adapters are converted for the model before them
+			var base *llm.GGML
+			if c.Name == "adapter" && modelLayer != nil {
+				base = modelLayer.GGML
+			}
+
 			var baseLayers []*layerGGML
-			if name := model.ParseName(c.Args); name.IsValid() {
+			if name := model.ParseName(args); name.IsValid() {
 				baseLayers, err = parseFromModel(ctx, name, fn)
 				if err != nil {
 					return err
 				}
-			} else if strings.HasPrefix(c.Args, "@") {
-				digest := strings.TrimPrefix(c.Args, "@")
+
+				if c.Name == "model" && (config.KeepAlive == nil || config.LoadTimeout == nil) {
+					if base, err := GetModel(name.String()); err == nil {
+						config.KeepAlive = cmp.Or(config.KeepAlive, base.Config.KeepAlive)
+						config.LoadTimeout = cmp.Or(config.LoadTimeout, base.Config.LoadTimeout)
+					}
+				}
+			} else if strings.HasPrefix(args, "@") {
+				digest := strings.TrimPrefix(args, "@")
 				if ib, ok := intermediateBlobs[digest]; ok {
 					p, err := GetBlobsPath(ib)
 					if err != nil {
@@ -397,6 +773,9 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 						// pass
 					} else if err != nil {
 						return err
+					} else if bf16 && blobExists(digest) {
+						// the cached layer is an F16 conversion, so the archive
+						// is converted again
 					} else {
 						fn(api.ProgressResponse{Status: fmt.Sprintf("using cached layer %s", ib)})
 						digest = ib
@@ -414,70 +793,27 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 				}
 				defer blob.Close()
 
-				baseLayers, err = parseFromFile(ctx, blob, digest, fn)
+				baseLayers, err = parseFromFile(ctx, blob, digest, base, bf16, fn)
 				if err != nil {
 					return err
 				}
-			} else if file, err := os.Open(realpath(modelFileDir, c.Args)); err == nil {
+			} else if file, err := os.Open(realpath(modelFileDir, args)); err == nil {
 				defer file.Close()
 
-				baseLayers, err = parseFromFile(ctx, file, "", fn)
+				baseLayers, err = parseFromFile(ctx, file, "", base, bf16, fn)
 				if err != nil {
 					return err
 				}
 			} else {
-				return fmt.Errorf("invalid model reference: %s", c.Args)
+				return fmt.Errorf("invalid model reference: %s", args)
 			}
 
 			for _, baseLayer := range baseLayers {
-				if quantization != "" &&
-					baseLayer.MediaType == "application/vnd.ollama.image.model" &&
-					baseLayer.GGML != nil &&
-					baseLayer.GGML.Name() == "gguf" {
-					want, err := llm.ParseFileType(quantization)
-					if err != nil {
+				// merged models are quantized once their adapters are merged
+				if opts.Quantization != "" && !opts.MergeAdapters {
+					if err := quantizeLayer(ctx, baseLayer, opts.Quantization, opts.Imatrix, fn); err != nil {
 						return err
 					}
-
-					ft := baseLayer.GGML.KV().FileType()
-					if !slices.Contains([]string{"F16", "F32"}, ft.String()) {
-						return errors.New("quantization is only supported for F16 and F32 models")
-					} else if want != ft {
-						fn(api.ProgressResponse{Status: fmt.Sprintf("quantizing %s model to %s", ft, quantization)})
-
-						blob, err := GetBlobsPath(baseLayer.Digest)
-						if err != nil {
-							return err
-						}
-
-						temp, err := os.CreateTemp(filepath.Dir(blob), quantization)
-						if err != nil {
-							return err
-						}
-						defer temp.Close()
-						defer os.Remove(temp.Name())
-
-						if err := llm.Quantize(blob, temp.Name(), want); err != nil {
-							return err
-						}
-
-						layer, err := NewLayer(temp, baseLayer.MediaType)
-						if err != nil {
-							return err
-						}
-
-						if _, err := temp.Seek(0, io.SeekStart); err != nil {
-							return err
-						}
-
-						ggml, _, err := llm.DecodeGGML(temp, 0)
-						if err != nil {
-							return err
-						}
-
-						baseLayer.Layer = layer
-						baseLayer.GGML = ggml
-					}
 				}
 
 				if baseLayer.GGML != nil {
@@ -488,10 +824,44 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 					config.ModelFamilies = append(config.ModelFamilies, baseLayer.GGML.KV().Architecture())
 				}
 
+				switch baseLayer.MediaType {
+				case "application/vnd.ollama.image.model":
+					if c.Name == "adapter" {
+						return fmt.Errorf("%w: %s isn't an adapter", errAdapter, args)
+					}
+
+					if baseLayer.GGML != nil {
+						modelArch = cmp.Or(modelArch, baseLayer.GGML.KV().Architecture())
+					}
+
+					if modelLayer == nil {
+						modelLayer = baseLayer
+					}
+				case "application/vnd.ollama.image.adapter":
+					if baseLayer.GGML != nil {
+						adapterArchs = append(adapterArchs, baseLayer.GGML.KV().Architecture())
+					}
+
+					adapterScales = append(adapterScales, adapterScale)
+					if opts.MergeAdapters {
+						mergeLayers = append(mergeLayers, baseLayer)
+						continue
+					}
+				}
+
 				layers = append(layers, baseLayer.Layer)
 			}
 		case "license", "template", "system":
 			if c.Name == "template" {
+				if template.IsJinja(c.Args) {
+					s, err := template.FromJinja(c.Args, jinjaVars(modelLayer))
+					if err != nil {
+						return fmt.Errorf("%w: %s", errBadTemplate, err)
+					}
+
+					c.Args = s
+				}
+
 				if _, err := template.Parse(c.Args); err != nil {
 					return fmt.Errorf("%w: %s", errBadTemplate, err)
 				}
@@ -504,7 +874,7 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 						return false
 					}
 
-					if err := layer.Remove(); err != nil {
+					if err := layer.Remove(ctx); err != nil {
 						return false
 					}
 
@@ -525,7 +895,70 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 				return fmt.Errorf("invalid message: %s", c.Args)
 			}
 
-			messages = append(messages, &api.Message{Role: role, Content: content})
+			msg := &api.Message{Role: role, Content: content}
+			for _, image := range parser.MessageImages(content) {
+				p := realpath(modelFileDir, image)
+				digest, blob := strings.CutPrefix(image, "@")
+				if blob {
+					if p, err = GetBlobsPath(digest); err != nil {
+						return err
+					}
+				}
+
+				bts, err := os.ReadFile(p)
+				if errors.Is(err, os.ErrNotExist) && !blob {
+					// like prompts to ollama run, paths which aren't files are kept
+					continue
+				} else if err != nil {
+					return err
+				}
+
+				msg.Images = append(msg.Images, bts)
+				msg.Content = strings.TrimSpace(strings.Replace(msg.Content, image, "", 1))
+			}
+
+			messages = append(messages, msg)
+		case "tools":
+			ts, err := parser.ParseTools(c.Args)
+			if err != nil {
+				return err
+			}
+
+			tools = append(tools, ts...)
+		case "metadata":
+			key, value, ok := strings.Cut(c.Args, ": ")
+			if !ok {
+				return fmt.Errorf("invalid metadata: %s", c.Args)
+			}
+
+			if config.Metadata == nil {
+				config.Metadata = &api.ModelMetadata{}
+			}
+
+			switch key {
+			case "description":
+				config.Metadata.Description = value
+			case "author":
+				config.Metadata.Author = value
+			case "tags":
+				for _, tag := range strings.Split(value, ",") {
+					if tag := strings.TrimSpace(tag); tag != "" && !slices.Contains(config.Metadata.Tags, tag) {
+						config.Metadata.Tags = append(config.Metadata.Tags, tag)
+					}
+				}
+			default:
+				return fmt.Errorf("invalid metadata key: %s", key)
+			}
+		case "keep_alive":
+			config.KeepAlive, err = parseDuration(c.Name, c.Args)
+			if err != nil {
+				return err
+			}
+		case "load_timeout":
+			config.LoadTimeout, err = parseDuration(c.Name, c.Args)
+			if err != nil {
+				return err
+			}
 		default:
 			ps, err := api.FormatParams(map[string][]string{c.Name: {c.Args}})
 			if err != nil {
@@ -535,6 +968,8 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 			for k, v := range ps {
 				if ks, ok := parameters[k].([]string); ok {
 					parameters[k] = append(ks, v.([]string)...)
+				} else if fs, ok := parameters[k].([]float32); ok {
+					parameters[k] = append(fs, v.([]float32)...)
 				} else if vs, ok := v.([]string); ok {
 					parameters[k] = vs
 				} else {
@@ -544,6 +979,86 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 		}
 	}
 
+	for _, arch := range adapterArchs {
+		if arch != "unknown" && modelArch != "unknown" && modelArch != "" && arch != modelArch {
+			return fmt.Errorf("%w: a %s adapter can't be applied to a %s model", errAdapter, arch, modelArch)
+		}
+	}
+
+	if opts.MergeAdapters && modelLayer != nil {
+		digest := modelLayer.Digest
+		if len(mergeLayers) > 0 {
+			// scales set by PARAMETER adapter_scale are merged too
+			if ps, ok := parameters["adapter_scale"].([]float32); ok {
+				if adapterScaled {
+					return fmt.Errorf("%w: scales are set by both ADAPTER and PARAMETER adapter_scale", errAdapter)
+				}
+
+				adapterScales = ps
+				delete(parameters, "adapter_scale")
+			}
+
+			adapterScaled = false
+			merged, err := mergeModel(modelLayer, mergeLayers, adapterScales, fn)
+			if err != nil {
+				return err
+			}
+
+			modelLayer = merged
+		}
+
+		if opts.Quantization != "" {
+			if err := quantizeLayer(ctx, modelLayer, opts.Quantization, opts.Imatrix, fn); err != nil {
+				return err
+			}
+		}
+
+		for i := range layers {
+			if layers[i].Digest == digest {
+				layers[i] = modelLayer.Layer
+			}
+		}
+
+		if modelLayer.GGML != nil {
+			config.FileType = modelLayer.GGML.KV().FileType().String()
+		}
+	} else if len(mergeLayers) > 0 {
+		return fmt.Errorf("%w: adapters can only be merged into a model given by FROM", errAdapter)
+	}
+
+	if len(opts.OverrideKV) > 0 {
+		if modelLayer == nil {
+			return errors.New("metadata can only be overridden for a model given by FROM")
+		}
+
+		layer, err := overrideLayer(modelLayer, opts.OverrideKV, fn)
+		if err != nil {
+			return err
+		}
+
+		for i := range layers {
+			if layers[i].Digest == modelLayer.Digest {
+				layers[i] = layer.Layer
+			}
+		}
+
+		modelLayer = layer
+	}
+
+	if opts.Check && modelLayer != nil && modelLayer.GGML != nil {
+		if err := checkModel(ctx, modelLayer, fn); err != nil {
+			return err
+		}
+	}
+
+	if adapterScaled {
+		if _, ok := parameters["adapter_scale"]; ok {
+			return fmt.Errorf("%w: scales are set by both ADAPTER and PARAMETER adapter_scale", errAdapter)
+		}
+
+		parameters["adapter_scale"] = adapterScales
+	}
+
 	var err2 error
 	layers = slices.DeleteFunc(layers, func(layer Layer) bool {
 		switch layer.MediaType {
@@ -554,6 +1069,9 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 			}
 
 			return false
+		case "application/vnd.ollama.image.tools":
+			// if there are new tools, replace the inherited ones
+			return len(tools) > 0
 		case "application/vnd.ollama.image.params":
 			// merge inherited parameters with new ones
 			r, err := layer.Open()
@@ -599,6 +1117,24 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 		layers = append(layers, layer)
 	}
 
+	if len(tools) > 0 {
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(tools); err != nil {
+			return err
+		}
+
+		layer, err := NewLayer(&b, "application/vnd.ollama.image.tools")
+		if err != nil {
+			return err
+		}
+
+		layers = append(layers, layer)
+	}
+
+	if err := checkToolsTemplate(layers); err != nil {
+		return err
+	}
+
 	if len(parameters) > 0 {
 		var b bytes.Buffer
 		if err := json.NewEncoder(&b).Encode(parameters); err != nil {
@@ -644,7 +1180,7 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 	}
 
 	if !envconfig.NoPrune() && old != nil {
-		if err := old.RemoveLayers(); err != nil {
+		if err := old.RemoveLayers(ctx); err != nil {
 			return err
 		}
 	}
@@ -653,6 +1189,33 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 	return nil
 }
 
+// parseDuration parses the duration parameter name of a Modelfile, such as
+// keep_alive, which is either a duration such as 30m or a number of seconds.
+// Negative keep_alive values keep the model loaded forever.
+func parseDuration(name, s string) (*api.Duration, error) {
+	b := []byte(s)
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		b, _ = json.Marshal(s)
+	}
+
+	var d api.Duration
+	if err := d.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("invalid %s value %s", name, s)
+	}
+
+	return &d, nil
+}
+
+// formatDuration formats a duration parameter of a Modelfile so
+// parseDuration parses it back
+func formatDuration(d api.Duration) string {
+	if d.Duration < 0 || d.Duration == math.MaxInt64 {
+		return "-1"
+	}
+
+	return d.Duration.String()
+}
+
 func CopyModel(src, dst model.Name) error {
 	if !dst.IsFullyQualified() {
 		return model.Unqualified(dst)
@@ -665,17 +1228,20 @@ func CopyModel(src, dst model.Name) error {
 		return nil
 	}
 
-	manifests, err := GetManifestPath()
+	dstpath, err := findModelsFile(filepath.Join("manifests", dst.Filepath()))
 	if err != nil {
 		return err
 	}
 
-	dstpath := filepath.Join(manifests, dst.Filepath())
 	if err := os.MkdirAll(filepath.Dir(dstpath), 0o755); err != nil {
 		return err
 	}
 
-	srcpath := filepath.Join(manifests, src.Filepath())
+	srcpath, err := findModelsFile(filepath.Join("manifests", src.Filepath()))
+	if err != nil {
+		return err
+	}
+
 	srcfile, err := os.Open(srcpath)
 	if err != nil {
 		return err
@@ -688,16 +1254,28 @@ func CopyModel(src, dst model.Name) error {
 	}
 	defer dstfile.Close()
 
-	_, err = io.Copy(dstfile, srcfile)
-	return err
+	if _, err := io.Copy(dstfile, srcfile); err != nil {
+		return err
+	}
+
+	if err := dstfile.Close(); err != nil {
+		return err
+	}
+
+	if err := placeModel(dst); err != nil {
+		return err
+	}
+
+	return storeManifest(dst.Filepath())
 }
 
 func deleteUnusedLayers(skipModelPath *ModelPath, deleteMap map[string]struct{}) error {
-	fp, err := GetManifestPath()
+	dirs, err := modelsSubdirs("manifests")
 	if err != nil {
 		return err
 	}
 
+	var fp string
 	walkFunc := func(path string, info os.FileInfo, _ error) error {
 		if info.IsDir() {
 			return nil
@@ -727,8 +1305,10 @@ func deleteUnusedLayers(skipModelPath *ModelPath, deleteMap map[string]struct{})
 		return nil
 	}
 
-	if err := filepath.Walk(fp, walkFunc); err != nil {
-		return err
+	for _, fp = range dirs {
+		if err := filepath.Walk(fp, walkFunc); err != nil {
+			return err
+		}
 	}
 
 	// only delete the files which are still in the deleteMap
@@ -749,34 +1329,36 @@ func deleteUnusedLayers(skipModelPath *ModelPath, deleteMap map[string]struct{})
 
 func PruneLayers() error {
 	deleteMap := make(map[string]struct{})
-	p, err := GetBlobsPath("")
+	dirs, err := modelsSubdirs("blobs")
 	if err != nil {
 		return err
 	}
 
-	blobs, err := os.ReadDir(p)
-	if err != nil {
-		slog.Info(fmt.Sprintf("couldn't read dir '%s': %v", p, err))
-		return err
-	}
+	for _, p := range dirs {
+		blobs, err := os.ReadDir(p)
+		if err != nil {
+			slog.Info(fmt.Sprintf("couldn't read dir '%s': %v", p, err))
+			return err
+		}
 
-	for _, blob := range blobs {
-		name := blob.Name()
-		name = strings.ReplaceAll(name, "-", ":")
+		for _, blob := range blobs {
+			name := blob.Name()
+			name = strings.ReplaceAll(name, "-", ":")
 
-		_, err := GetBlobsPath(name)
-		if err != nil {
-			if errors.Is(err, ErrInvalidDigestFormat) {
-				// remove invalid blobs (e.g. partial downloads)
-				if err := os.Remove(filepath.Join(p, blob.Name())); err != nil {
-					slog.Error("couldn't remove blob", "blob", blob.Name(), "error", err)
+			_, err := GetBlobsPath(name)
+			if err != nil {
+				if errors.Is(err, ErrInvalidDigestFormat) {
+					// remove invalid blobs (e.g. partial downloads)
+					if err := os.Remove(filepath.Join(p, blob.Name())); err != nil {
+						slog.Error("couldn't remove blob", "blob", blob.Name(), "error", err)
+					}
 				}
+
+				continue
 			}
 
-			continue
+			deleteMap[name] = struct{}{}
 		}
-
-		deleteMap[name] = struct{}{}
 	}
 
 	slog.Info(fmt.Sprintf("total blobs: %d", len(deleteMap)))
@@ -829,7 +1411,7 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	mp := ParseModelPath(name)
 	fn(api.ProgressResponse{Status: "retrieving manifest"})
 
-	if mp.ProtocolScheme == "http" && !regOpts.Insecure {
+	if mp.ProtocolScheme == "http" && !regOpts.Insecure && !defaultRegistryTransport.insecure(mp.Registry) {
 		return errors.New("insecure protocol http")
 	}
 
@@ -839,30 +1421,54 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return err
 	}
 
+	if err := fetchBlobs(manifest); err != nil {
+		return err
+	}
+
 	var layers []Layer
 	layers = append(layers, manifest.Layers...)
 	if manifest.Config.Digest != "" {
 		layers = append(layers, manifest.Config)
 	}
 
+	// layers are uploaded together, sharing the push's connections
+	concurrency := uploadConcurrency(regOpts.Concurrency)
+	conns := make(chan struct{}, concurrency)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
 	for _, layer := range layers {
-		if err := uploadBlob(ctx, mp, layer, regOpts, fn); err != nil {
-			slog.Info(fmt.Sprintf("error uploading blob: %v", err))
-			return err
-		}
+		g.Go(func() error {
+			if err := uploadBlob(gctx, mp, layer, conns, regOpts, fn); err != nil {
+				slog.Info(fmt.Sprintf("error uploading blob: %v", err))
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
 	}
 
 	fn(api.ProgressResponse{Status: "pushing manifest"})
 	requestURL := mp.BaseURL()
 	requestURL = requestURL.JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
 
+	if !isOllamaRegistry(mp.Registry) {
+		// other registries store models as OCI artifacts
+		manifest.MediaType = mediaTypeOCIManifest
+		manifest.ArtifactType = artifactTypeModel
+	}
+
 	manifestJSON, err := json.Marshal(manifest)
 	if err != nil {
 		return err
 	}
 
 	headers := make(http.Header)
-	headers.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	headers.Set("Content-Type", manifest.MediaType)
 	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(manifestJSON), regOpts)
 	if err != nil {
 		return err
@@ -874,6 +1480,24 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	return nil
 }
 
+// reusedLayers returns the number and total size of the layers which are
+// already in a models directory
+func reusedLayers(layers []Layer) (n int, size int64) {
+	for _, layer := range layers {
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			continue
+		}
+
+		if fi, err := os.Stat(p); err == nil && fi.Size() == layer.Size {
+			n++
+			size += layer.Size
+		}
+	}
+
+	return n, size
+}
+
 func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
 	mp := ParseModelPath(name)
 
@@ -900,10 +1524,11 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		}
 	}
 
-	if mp.ProtocolScheme == "http" && !regOpts.Insecure {
+	if mp.ProtocolScheme == "http" && !regOpts.Insecure && !defaultRegistryTransport.insecure(mp.Registry) {
 		return errors.New("insecure protocol http")
 	}
 
+	broadcast(eventPullStarted, mp.GetShortTagname(), nil)
 	fn(api.ProgressResponse{Status: "pulling manifest"})
 
 	manifest, err = pullModelManifest(ctx, mp, regOpts)
@@ -911,24 +1536,58 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return fmt.Errorf("pull model manifest: %s", err)
 	}
 
+	if err := verifySignatures(ctx, mp, manifest, regOpts, fn); err != nil {
+		return err
+	}
+
 	var layers []Layer
 	layers = append(layers, manifest.Layers...)
 	if manifest.Config.Digest != "" {
 		layers = append(layers, manifest.Config)
 	}
 
+	// only the layers which aren't in any models directory are downloaded,
+	// so updating a tag downloads the layers which changed
+	reused, reusedSize := reusedLayers(layers)
+	if reused > 0 {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("%d of %d layers reused (%s)", reused, len(layers), format.HumanBytes(reusedSize))})
+	}
+
+	// layers are downloaded together, sharing the pull's connections
+	concurrency := downloadConcurrency(regOpts.Concurrency)
+	conns := make(chan struct{}, concurrency)
+
+	var skipVerifyMu sync.Mutex
 	skipVerify := make(map[string]bool)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
 	for _, layer := range layers {
-		cacheHit, err := downloadBlob(ctx, downloadOpts{
-			mp:      mp,
-			digest:  layer.Digest,
-			regOpts: regOpts,
-			fn:      fn,
+		g.Go(func() error {
+			cacheHit, err := downloadBlob(gctx, downloadOpts{
+				mp:      mp,
+				digest:  layer.Digest,
+				regOpts: regOpts,
+				fn:      fn,
+				conns:   conns,
+				size:    layer.Size,
+			})
+			if err != nil {
+				return err
+			}
+
+			skipVerifyMu.Lock()
+			skipVerify[layer.Digest] = cacheHit
+			skipVerifyMu.Unlock()
+			return nil
 		})
-		if err != nil {
-			return err
-		}
-		skipVerify[layer.Digest] = cacheHit
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	for _, layer := range layers {
 		delete(deleteMap, layer.Digest)
 	}
 	delete(deleteMap, manifest.Config.Digest)
@@ -975,6 +1634,14 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return err
 	}
 
+	if err := placeModel(model.ParseName(mp.GetFullTagname())); err != nil {
+		return err
+	}
+
+	if err := storeManifest(filepath.Join(mp.Registry, mp.Namespace, mp.Repository, mp.Tag)); err != nil {
+		return err
+	}
+
 	if noprune == "" {
 		fn(api.ProgressResponse{Status: "removing any unused layers"})
 		err = deleteUnusedLayers(nil, deleteMap)
@@ -984,27 +1651,47 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		}
 	}
 
+	notify(eventPullCompleted, mp.GetShortTagname(), map[string]any{
+		"digest":        "sha256:" + manifest.digest,
+		"size":          manifest.Size(),
+		"reused_layers": reused,
+		"reused_size":   reusedSize,
+	})
+
 	fn(api.ProgressResponse{Status: "success"})
 
 	return nil
 }
 
 func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, error) {
-	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
+	requestURL := mp.PullURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
 
 	headers := make(http.Header)
-	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	headers.Set("Accept", strings.Join([]string{mediaTypeDockerManifest, mediaTypeOCIManifest}, ", "))
 	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	var m *Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+	if err := json.Unmarshal(bts, &m); err != nil {
 		return nil, err
 	}
 
+	// signatures are of the manifest as the registry serves it
+	sha256sum := sha256.Sum256(bts)
+	m.digest = hex.EncodeToString(sha256sum[:])
+
+	if m.MediaType == mediaTypeOCIIndex {
+		return nil, fmt.Errorf("%s is an image index rather than a model", mp.GetShortTagname())
+	}
+
 	return m, err
 }
 
@@ -1065,13 +1752,10 @@ func makeRequestWithRetry(ctx context.Context, method string, requestURL *url.UR
 		switch {
 		case resp.StatusCode == http.StatusUnauthorized:
 			// Handle authentication error with one retry
-			challenge := parseRegistryChallenge(resp.Header.Get("www-authenticate"))
-			token, err := getAuthorizationToken(ctx, challenge)
+			anonymous, err = authorize(ctx, requestURL, resp.Header.Get("www-authenticate"), regOpts)
 			if err != nil {
 				return nil, err
 			}
-			anonymous = getTokenSubject(token) == "anonymous"
-			regOpts.Token = token
 			if body != nil {
 				_, err = body.Seek(0, io.SeekStart)
 				if err != nil {
@@ -1105,7 +1789,7 @@ func makeRequestWithRetry(ctx context.Context, method string, requestURL *url.UR
 }
 
 func makeRequest(ctx context.Context, method string, requestURL *url.URL, headers http.Header, body io.Reader, regOpts *registryOptions) (*http.Response, error) {
-	if requestURL.Scheme != "http" && regOpts != nil && regOpts.Insecure {
+	if requestURL.Scheme != "http" && (regOpts != nil && regOpts.Insecure || defaultRegistryTransport.insecure(requestURL.Host)) {
 		requestURL.Scheme = "http"
 	}
 
@@ -1138,6 +1822,7 @@ func makeRequest(ctx context.Context, method string, requestURL *url.URL, header
 	}
 
 	resp, err := (&http.Client{
+		Transport:     &defaultRegistryTransport,
 		CheckRedirect: regOpts.CheckRedirect,
 	}).Do(req)
 	if err != nil {
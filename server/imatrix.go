@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// importanceMatrix returns the path of the importance matrix in the blob
+// digest to quantize the model in the blob at path with. If the blob is
+// calibration text, an importance matrix is computed from it with the model
+// and removed by the returned func.
+func importanceMatrix(ctx context.Context, path, digest string, fn func(api.ProgressResponse)) (string, func(), error) {
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		return "", nil, err
+	}
+
+	f, err := os.Open(blob)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	contentType, err := detectContentType(io.LimitReader(f, 512))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !strings.HasPrefix(contentType, "text/plain") {
+		return blob, func() {}, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", nil, err
+	}
+
+	text, err := io.ReadAll(f)
+	if err != nil {
+		return "", nil, err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(blob), "imatrix")
+	if err != nil {
+		return "", nil, err
+	}
+	temp.Close()
+
+	remove := func() { os.Remove(temp.Name()) }
+
+	// progress is reported in bytes of calibration text, like transfers
+	status := "computing importance matrix"
+	fn(api.ProgressResponse{Status: status})
+	if err := llm.Calibrate(ctx, path, string(text), temp.Name(), func(completed, total int) {
+		fn(api.ProgressResponse{Status: status, Total: int64(len(text)), Completed: int64(len(text) * completed / total)})
+	}); err != nil {
+		remove()
+		return "", nil, fmt.Errorf("importance matrix: %w", err)
+	}
+
+	return temp.Name(), remove, nil
+}
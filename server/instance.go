@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/version"
+)
+
+// Config configures an [Instance]. The rest of its configuration, such as
+// where models are stored, is read from the environment like the ollama
+// server's.
+type Config struct {
+	// Listeners are the addresses the instance serves the API on. An
+	// instance without listeners only serves the API through its Handler.
+	Listeners []net.Listener
+
+	// NoPrune skips removing unused layers and manifests from the model
+	// store when the instance is created, as if OLLAMA_NOPRUNE was set.
+	NoPrune bool
+
+	// AllowAnyHost skips checking the Host header of requests to Handler.
+	// By default it only allows the hosts a listener on localhost does, so
+	// web pages can't reach the API by rebinding DNS names to localhost.
+	// Set it if Handler is served on other addresses, or behind a proxy
+	// which checks hosts itself.
+	AllowAnyHost bool
+}
+
+// Instance is an ollama server, with its model store, scheduler and runners,
+// run by the process which created it. Go programs can embed ollama with an
+// Instance instead of running the ollama server, and call its API through
+// Handler without a listener. Each instance has its own scheduler and stats,
+// but the instances of a process share its server events.
+type Instance struct {
+	config Config
+
+	ctx       context.Context
+	done      context.CancelFunc
+	schedCtx  context.Context
+	schedDone context.CancelFunc
+
+	sched   *Scheduler
+	cache   *responseCache
+	stats   *statsRecorder
+	history *historyStore
+	handler http.Handler
+
+	srvrs []*http.Server
+	errs  chan error
+
+	// debugLn and debugSrvr serve the debug endpoints on OLLAMA_DEBUG_ADDR,
+	// once the instance is started
+	debugLn   net.Listener
+	debugSrvr *http.Server
+
+	stopOnce sync.Once
+}
+
+// New creates an instance with config, repairing and pruning its model store.
+// The instance doesn't load models or serve its listeners until it's started.
+func New(config Config) (*Instance, error) {
+	// the object store is opened once, and a bad OLLAMA_STORE fails here
+	// rather than on the first request
+	if _, err := objectStore(); err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	blobsDirs, err := modelsSubdirs("blobs")
+	if err != nil {
+		return nil, err
+	}
+	for _, blobsDir := range blobsDirs {
+		if err := fixBlobs(blobsDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if !config.NoPrune && !envconfig.NoPrune() {
+		// clean up unused layers and manifests
+		if err := PruneLayers(); err != nil {
+			return nil, err
+		}
+
+		manifestsPaths, err := modelsSubdirs("manifests")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, manifestsPath := range manifestsPaths {
+			if err := PruneDirectory(manifestsPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	i := &Instance{config: config, errs: make(chan error, len(config.Listeners))}
+
+	i.ctx, i.done = context.WithCancel(context.Background())
+	i.schedCtx, i.schedDone = context.WithCancel(i.ctx)
+	i.sched = InitScheduler(i.schedCtx)
+	if ttl := envconfig.ResponseCacheTTL(); ttl > 0 {
+		i.cache = newResponseCache(ttl, int(envconfig.ResponseCacheSize()))
+	}
+
+	if retention := envconfig.HistoryRetention(); retention > 0 {
+		if i.history, err = openHistory(envconfig.HistoryDir(), retention); err != nil {
+			slog.Warn("failed to open usage history, requests won't be recorded", "error", err)
+		}
+	}
+
+	i.stats = newStatsRecorder()
+
+	s := &Server{sched: i.sched, cache: i.cache, stats: i.stats, history: i.history}
+	if !config.AllowAnyHost {
+		// check hosts as if the handler were served on localhost
+		s.addr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	}
+	i.handler = s.GenerateRoutes()
+
+	i.srvrs = make([]*http.Server, len(config.Listeners))
+	for n, ln := range config.Listeners {
+		// every listener has its own routes, which check the hosts and API
+		// keys of its address
+		s := &Server{addr: ln.Addr(), sched: i.sched, cache: i.cache, stats: i.stats, history: i.history}
+		if l, ok := ln.(*listener); ok {
+			s.apiKeys = l.apiKeys
+		}
+
+		i.srvrs[n] = &http.Server{Handler: s.GenerateRoutes()}
+	}
+
+	return i, nil
+}
+
+// Handler returns the routes of the instance's API. Unlike its listeners,
+// the handler doesn't check the API keys of requests, and it checks their
+// hosts as a listener on localhost would unless Config.AllowAnyHost is set.
+func (i *Instance) Handler() http.Handler {
+	return i.handler
+}
+
+// Start starts the instance's scheduler and serves its listeners. It returns
+// once they're being served.
+func (i *Instance) Start() error {
+	if err := llm.Init(); err != nil {
+		return fmt.Errorf("unable to initialize llm library %w", err)
+	}
+
+	// the debug endpoints aren't authenticated, so they're only served on
+	// their own address when it's set
+	if addr := envconfig.DebugAddr(); addr != "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("listen on debug address: %w", err)
+		}
+
+		i.debugLn = ln
+		i.debugSrvr = &http.Server{Handler: debugHandler(i.sched)}
+	}
+
+	i.sched.Run(i.schedCtx)
+
+	if interval := envconfig.GCInterval(); interval > 0 {
+		go runGC(i.ctx, interval)
+	}
+
+	// At startup we retrieve GPU information so we can get log messages before loading a model
+	// This will log warnings to the log in case we have problems with detected GPUs
+	gpus := gpu.GetGPUInfo()
+	gpus.LogDetails()
+
+	for n, ln := range i.config.Listeners {
+		slog.Info(fmt.Sprintf("Listening on %s (version %s)", ln.Addr(), version.Version))
+		go func() {
+			i.errs <- i.srvrs[n].Serve(ln)
+		}()
+	}
+
+	if i.debugLn != nil {
+		slog.Info(fmt.Sprintf("Serving debug endpoints on %s", i.debugLn.Addr()))
+		go func() {
+			if err := i.debugSrvr.Serve(i.debugLn); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("debug server failed", "error", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Wait blocks until the instance is stopped, or stops the instance and
+// returns the error of a listener which failed to be served.
+func (i *Instance) Wait() error {
+	select {
+	case err := <-i.errs:
+		// If the listener was closed by Stop, wait for it to finish,
+		// otherwise stop the other listeners and error out quickly
+		if !errors.Is(err, http.ErrServerClosed) {
+			i.Stop()
+			return err
+		}
+	case <-i.ctx.Done():
+	}
+
+	<-i.ctx.Done()
+	return nil
+}
+
+// Stop closes the instance's listeners and unloads its models. An instance
+// can't be started again once it's stopped.
+func (i *Instance) Stop() {
+	i.stopOnce.Do(func() {
+		for _, srvr := range i.srvrs {
+			srvr.Close()
+		}
+
+		if i.debugSrvr != nil {
+			i.debugSrvr.Close()
+		}
+
+		i.schedDone()
+		i.sched.unloadAllRunners()
+		if i.history != nil {
+			i.history.close()
+		}
+		gpu.Cleanup()
+		i.done()
+	})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestInstanceHandler(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_HISTORY_DIR", t.TempDir())
+	t.Setenv("OLLAMA_DEBUG_ADDR", "")
+
+	i, err := New(Config{})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+	r.Host = "localhost"
+	i.Handler().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp api.ListResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Empty(t, resp.Models)
+
+	// the handler checks hosts like a listener on localhost, so pages which
+	// rebind their names to localhost can't reach it
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/api/version", nil)
+	r.Host = "example.com"
+	i.Handler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	i.Stop()
+	i.Stop()
+	require.NoError(t, i.Wait())
+
+	// unless the program opts out
+	i, err = New(Config{AllowAnyHost: true})
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	i.Handler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	i.Stop()
+	require.NoError(t, i.Wait())
+}
+
+func TestInstanceWait(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_HISTORY_DIR", t.TempDir())
+
+	// the debug address is only bound once the instance is started, so an
+	// address in use doesn't fail New
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	t.Setenv("OLLAMA_DEBUG_ADDR", ln.Addr().String())
+
+	i, err := New(Config{Listeners: []net.Listener{ln}})
+	require.NoError(t, err)
+
+	// a listener failing stops the instance
+	i.errs <- errors.New("accept failed")
+	require.EqualError(t, i.Wait(), "accept failed")
+
+	select {
+	case <-i.ctx.Done():
+	default:
+		t.Fatal("expected the instance to be stopped")
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -71,6 +72,10 @@ func NewLayerFromLayer(digest, mediatype, from string) (Layer, error) {
 		return Layer{}, err
 	}
 
+	if err := fetchBlob(digest); err != nil {
+		return Layer{}, err
+	}
+
 	fi, err := os.Stat(blob)
 	if err != nil {
 		return Layer{}, err
@@ -95,14 +100,25 @@ func (l *Layer) Open() (io.ReadSeekCloser, error) {
 		return nil, err
 	}
 
+	if err := fetchBlob(l.Digest); err != nil {
+		return nil, err
+	}
+
 	return os.Open(blob)
 }
 
-func (l *Layer) Remove() error {
+func (l *Layer) Remove(ctx context.Context) error {
 	if l.Digest == "" {
 		return nil
 	}
 
+	// Manifests only warns when it can't sync, but the blob mustn't be
+	// removed from the object store while another server's manifest may
+	// still use it
+	if err := syncManifests(ctx); err != nil {
+		return fmt.Errorf("couldn't sync manifests from store, not removing %s: %w", l.Digest, err)
+	}
+
 	ms, err := Manifests()
 	if err != nil {
 		return err
@@ -122,5 +138,9 @@ func (l *Layer) Remove() error {
 		return err
 	}
 
+	if err := unstoreBlob(ctx, l.Digest); err != nil {
+		return err
+	}
+
 	return os.Remove(blob)
 }
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+)
+
+// limitError is returned when a request exceeds one of the limits set by
+// OLLAMA_MAX_REQUEST_SIZE, OLLAMA_MAX_IMAGES, OLLAMA_MAX_IMAGE_SIZE or
+// OLLAMA_MAX_PROMPT_TOKENS, which are reported as 413 Request Entity Too
+// Large
+type limitError struct {
+	msg string
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+// requestSizeMiddleware rejects requests with bodies larger than
+// OLLAMA_MAX_REQUEST_SIZE, other than uploads of blobs, which are as large as
+// the models they're created from
+func requestSizeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := int64(envconfig.MaxRequestSize())
+		if limit <= 0 || c.Request.Body == nil || c.FullPath() == "/api/blobs/:digest" {
+			c.Next()
+			return
+		}
+
+		tooLarge := func() {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("request body exceeds the maximum size of %s", format.HumanBytes(limit)),
+			})
+		}
+
+		if c.Request.ContentLength > limit {
+			tooLarge()
+			return
+		}
+
+		// the body is read up front, as handlers decode it whole anyway, so
+		// a body without a Content-Length is rejected the same way
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if int64(len(body)) > limit {
+			tooLarge()
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+// checkImages returns a limitError if there are more than OLLAMA_MAX_IMAGES
+// images or any is larger than OLLAMA_MAX_IMAGE_SIZE
+func checkImages(images []api.ImageData) error {
+	if limit := envconfig.MaxImages(); limit > 0 && uint(len(images)) > limit {
+		return &limitError{fmt.Sprintf("request has %d images, more than the maximum of %d", len(images), limit)}
+	}
+
+	if limit := envconfig.MaxImageSize(); limit > 0 {
+		for i, image := range images {
+			if uint64(len(image)) > limit {
+				return &limitError{fmt.Sprintf("image %d exceeds the maximum size of %s", i, format.HumanBytes(int64(limit)))}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkMessageImages returns a limitError if the images of msgs exceed the
+// limits checked by checkImages
+func checkMessageImages(msgs []api.Message) error {
+	var images []api.ImageData
+	for _, msg := range msgs {
+		images = append(images, msg.Images...)
+	}
+
+	return checkImages(images)
+}
+
+// checkPromptTokens returns a limitError if prompt has more tokens than
+// OLLAMA_MAX_PROMPT_TOKENS
+func checkPromptTokens(ctx context.Context, tokenize tokenizeFunc, prompt string) error {
+	limit := envconfig.MaxPromptTokens()
+	if limit == 0 {
+		return nil
+	}
+
+	tokens, err := tokenize(ctx, prompt)
+	if err != nil {
+		return err
+	}
+
+	if uint(len(tokens)) > limit {
+		return &limitError{fmt.Sprintf("prompt has %d tokens, more than the maximum of %d", len(tokens), limit)}
+	}
+
+	return nil
+}
+
+// checkInputTokens returns a limitError if any input of an embed, classify or
+// rerank request, whose token counts are counts, has more tokens than
+// OLLAMA_MAX_PROMPT_TOKENS
+func checkInputTokens(counts []int) error {
+	limit := envconfig.MaxPromptTokens()
+	if limit == 0 {
+		return nil
+	}
+
+	for i, n := range counts {
+		if uint(n) > limit {
+			return &limitError{fmt.Sprintf("input %d has %d tokens, more than the maximum of %d", i, n, limit)}
+		}
+	}
+
+	return nil
+}
+
+// limitStatus returns the status a request failing with err is answered
+// with, 413 for exceeding a limit and 500 otherwise
+func limitStatus(err error) int {
+	var lerr *limitError
+	if errors.As(err, &lerr) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestRequestSizeMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestSizeMiddleware())
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	}
+	r.POST("/api/chat", echo)
+	r.POST("/api/blobs/:digest", echo)
+
+	cases := []struct {
+		name   string
+		limit  string
+		path   string
+		body   string
+		length bool // whether the request has a Content-Length
+		status int
+	}{
+		{"no limit", "", "/api/chat", strings.Repeat("a", 100), true, http.StatusOK},
+		{"under", "100", "/api/chat", strings.Repeat("a", 100), true, http.StatusOK},
+		{"over", "100", "/api/chat", strings.Repeat("a", 101), true, http.StatusRequestEntityTooLarge},
+		{"over without length", "100", "/api/chat", strings.Repeat("a", 101), false, http.StatusRequestEntityTooLarge},
+		{"under without length", "1KB", "/api/chat", strings.Repeat("a", 1000), false, http.StatusOK},
+		{"blob", "100", "/api/blobs/sha256:abc", strings.Repeat("a", 101), true, http.StatusOK},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_MAX_REQUEST_SIZE", tt.limit)
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if !tt.length {
+				req.ContentLength = -1
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, tt.body, w.Body.String())
+			} else {
+				assert.Contains(t, w.Body.String(), "request body exceeds the maximum size")
+			}
+		})
+	}
+}
+
+func TestCheckImages(t *testing.T) {
+	images := []api.ImageData{make([]byte, 10), make([]byte, 20)}
+
+	assert.NoError(t, checkImages(images))
+
+	t.Setenv("OLLAMA_MAX_IMAGES", "1")
+	err := checkImages(images)
+	assert.ErrorContains(t, err, "request has 2 images, more than the maximum of 1")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, limitStatus(err))
+
+	t.Setenv("OLLAMA_MAX_IMAGES", "2")
+	t.Setenv("OLLAMA_MAX_IMAGE_SIZE", "15")
+	assert.ErrorContains(t, checkImages(images), "image 1 exceeds the maximum size")
+	assert.ErrorContains(t, checkMessageImages([]api.Message{{Images: images[:1]}, {Images: images[1:]}}), "image 1 exceeds the maximum size")
+
+	t.Setenv("OLLAMA_MAX_IMAGE_SIZE", "20")
+	assert.NoError(t, checkImages(images))
+}
+
+func TestCheckPromptTokens(t *testing.T) {
+	tokenize := func(_ context.Context, s string) ([]int, error) {
+		return make([]int, len(strings.Fields(s))), nil
+	}
+
+	assert.NoError(t, checkPromptTokens(context.Background(), tokenize, "one two three"))
+
+	t.Setenv("OLLAMA_MAX_PROMPT_TOKENS", "3")
+	assert.NoError(t, checkPromptTokens(context.Background(), tokenize, "one two three"))
+
+	err := checkPromptTokens(context.Background(), tokenize, "one two three four")
+	assert.ErrorContains(t, err, "prompt has 4 tokens, more than the maximum of 3")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, limitStatus(err))
+
+	failing := func(context.Context, string) ([]int, error) {
+		return nil, errors.New("tokenize failed")
+	}
+
+	err = checkPromptTokens(context.Background(), failing, "one")
+	assert.Equal(t, http.StatusInternalServerError, limitStatus(err))
+}
+
+func TestCheckInputTokens(t *testing.T) {
+	assert.NoError(t, checkInputTokens([]int{3, 4}))
+
+	t.Setenv("OLLAMA_MAX_PROMPT_TOKENS", "3")
+	assert.NoError(t, checkInputTokens([]int{3, 1}))
+
+	err := checkInputTokens([]int{3, 4})
+	assert.ErrorContains(t, err, "input 1 has 4 tokens, more than the maximum of 3")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, limitStatus(err))
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// listener is an address of OLLAMA_HOST the server listens on. Its query
+// parameters set TLS with the tls_cert and tls_key files, and a file of
+// api_keys, one per line, of which requests must have one. Unix sockets
+// also have the mode, owner and group of their settings.
+type listener struct {
+	net.Listener
+
+	apiKeys []string
+}
+
+// Listen listens on the addresses of OLLAMA_HOST
+func Listen() ([]net.Listener, error) {
+	var lns []net.Listener
+	for _, u := range envconfig.Hosts() {
+		ln, err := listen(u)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+
+			return nil, fmt.Errorf("%s: %w", cmp.Or(u.Host, u.Path), err)
+		}
+
+		lns = append(lns, ln)
+	}
+
+	return lns, nil
+}
+
+func listen(u *url.URL) (*listener, error) {
+	q := u.Query()
+
+	var config *tls.Config
+	if cert, key := q.Get("tls_cert"), q.Get("tls_key"); cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+
+		config = &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+	} else if u.Scheme == "https" {
+		return nil, errors.New("https requires tls_cert and tls_key")
+	}
+
+	var apiKeys []string
+	if p := q.Get("api_keys"); p != "" {
+		var err error
+		if apiKeys, err = readAPIKeys(p); err != nil {
+			return nil, err
+		}
+	}
+
+	var ln net.Listener
+	var err error
+	if u.Scheme == "unix" {
+		ln, err = listenUnix(u.Path, q)
+	} else {
+		ln, err = net.Listen("tcp", u.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+
+	return &listener{Listener: ln, apiKeys: apiKeys}, nil
+}
+
+// listenUnix listens on a unix socket at p, replacing a socket no server is
+// listening on
+func listenUnix(p string, q url.Values) (net.Listener, error) {
+	if fi, err := os.Stat(p); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if conn, err := net.Dial("unix", p); err == nil {
+			conn.Close()
+			return nil, errors.New("address already in use")
+		}
+
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := chownSocket(p, q.Get("owner"), q.Get("group")); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	if s := q.Get("mode"); s != "" {
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid mode %q", s)
+		}
+
+		if err := os.Chmod(p, fs.FileMode(mode)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
+
+// chownSocket changes the owner and group of the socket at p to the user and
+// group names or IDs owner and group, if they're set
+func chownSocket(p, owner, group string) error {
+	uid, gid := -1, -1
+	if owner != "" {
+		id := owner
+		if u, err := user.Lookup(owner); err == nil {
+			id = u.Uid
+		}
+
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return fmt.Errorf("unknown owner %q", owner)
+		}
+
+		uid = n
+	}
+
+	if group != "" {
+		id := group
+		if g, err := user.LookupGroup(group); err == nil {
+			id = g.Gid
+		}
+
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return fmt.Errorf("unknown group %q", group)
+		}
+
+		gid = n
+	}
+
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+
+	return os.Chown(p, uid, gid)
+}
+
+// readAPIKeys reads a file of API keys, one per line, skipping blank lines
+// and comments
+func readAPIKeys(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" && !strings.HasPrefix(key, "#") {
+			keys = append(keys, key)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no API keys", p)
+	}
+
+	return keys, nil
+}
+
+// apiKeysMiddleware rejects requests without one of keys as a bearer token.
+// Every request is allowed if there are no keys.
+func apiKeysMiddleware(keys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(keys) == 0 {
+			c.Next()
+			return
+		}
+
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			for _, key := range keys {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and its key
+func writeCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	cert, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, keyPath
+}
+
+func TestListen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	cert, key := writeCertificate(t)
+	keys := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keys, []byte("# clients\nsecret\n\nother\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_HOST", "127.0.0.1:0,https://127.0.0.1:0?tls_cert="+cert+"&tls_key="+key+"&api_keys="+keys)
+	lns, err := Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lns) != 2 {
+		t.Fatalf("expected 2 listeners, actual %d", len(lns))
+	}
+
+	for i, ln := range lns {
+		s := Server{addr: ln.Addr(), apiKeys: ln.(*listener).apiKeys}
+		srvr := &http.Server{Handler: s.GenerateRoutes()}
+		defer srvr.Close()
+		go srvr.Serve(lns[i])
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}} //nolint:gosec
+	get := func(u, key string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, u+"/api/version", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	plain, secure := "http://"+lns[0].Addr().String(), "https://"+lns[1].Addr().String()
+	cases := []struct {
+		url    string
+		key    string
+		expect int
+	}{
+		{plain, "", http.StatusOK},
+		{secure, "", http.StatusUnauthorized},
+		{secure, "wrong", http.StatusUnauthorized},
+		{secure, "secret", http.StatusOK},
+		{secure, "other", http.StatusOK},
+	}
+
+	for _, tt := range cases {
+		if code := get(tt.url, tt.key); code != tt.expect {
+			t.Errorf("%s with key %q: expected status code %d, actual %d", tt.url, tt.key, tt.expect, code)
+		}
+	}
+}
+
+func TestListenErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(empty, []byte("# no keys\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range []string{
+		"https://127.0.0.1:0",
+		"127.0.0.1:0?tls_cert=missing.pem&tls_key=missing.pem",
+		"127.0.0.1:0?api_keys=" + empty,
+		"127.0.0.1:0,1.2.3.4.5:0",
+	} {
+		t.Setenv("OLLAMA_HOST", host)
+		if _, err := Listen(); err == nil {
+			t.Errorf("%s: expected an error", host)
+		}
+	}
+}
+
+func TestAPIKeysMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := Server{apiKeys: []string{"secret"}}
+	ts := httptest.NewServer(s.GenerateRoutes())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("expected status code 401, actual %d", resp.StatusCode)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions aren't supported on windows")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	p := filepath.Join(t.TempDir(), "ollama.sock")
+
+	// a socket left behind by a server that stopped is replaced
+	stale, err := net.Listen("unix", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	t.Setenv("OLLAMA_HOST", fmt.Sprintf("unix://%s?mode=0600&group=%d", p, os.Getgid()))
+	lns, err := Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := Server{addr: lns[0].Addr()}
+	srvr := &http.Server{Handler: s.GenerateRoutes()}
+	defer srvr.Close()
+	go srvr.Serve(lns[0])
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, actual %o", fi.Mode().Perm())
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Version(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a socket a server is listening on isn't replaced
+	if _, err := Listen(); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected the socket to be in use, actual %v", err)
+	}
+}
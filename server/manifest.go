@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -9,19 +10,34 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ollama/ollama/types/model"
 )
 
+const (
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+
+	// artifactTypeModel identifies models pushed to OCI registries as
+	// artifacts
+	artifactTypeModel = "application/vnd.ollama.model"
+)
+
 type Manifest struct {
 	SchemaVersion int     `json:"schemaVersion"`
 	MediaType     string  `json:"mediaType"`
+	ArtifactType  string  `json:"artifactType,omitempty"`
 	Config        Layer   `json:"config"`
 	Layers        []Layer `json:"layers"`
 
 	filepath string
 	fi       os.FileInfo
 	digest   string
+
+	// dir is the models directory of the manifest
+	dir string
 }
 
 func (m *Manifest) Size() (size int64) {
@@ -32,23 +48,28 @@ func (m *Manifest) Size() (size int64) {
 	return
 }
 
-func (m *Manifest) Remove() error {
+func (m *Manifest) Remove(ctx context.Context) error {
 	if err := os.Remove(m.filepath); err != nil {
 		return err
 	}
 
-	manifests, err := GetManifestPath()
+	manifests := filepath.Join(m.dir, "manifests")
+	rel, err := filepath.Rel(manifests, m.filepath)
 	if err != nil {
 		return err
 	}
 
+	if err := unstoreManifest(ctx, rel); err != nil {
+		return err
+	}
+
 	return PruneDirectory(manifests)
 }
 
-func (m *Manifest) RemoveLayers() error {
+func (m *Manifest) RemoveLayers(ctx context.Context) error {
 	for _, layer := range append(m.Layers, m.Config) {
 		if layer.Digest != "" {
-			if err := layer.Remove(); errors.Is(err, os.ErrNotExist) {
+			if err := layer.Remove(ctx); errors.Is(err, os.ErrNotExist) {
 				slog.Debug("layer does not exist", "digest", layer.Digest)
 			} else if err != nil {
 				return err
@@ -64,12 +85,14 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 		return nil, model.Unqualified(n)
 	}
 
-	manifests, err := GetManifestPath()
-	if err != nil {
+	if err := fetchManifest(n.Filepath()); err != nil {
 		return nil, err
 	}
 
-	p := filepath.Join(manifests, n.Filepath())
+	p, err := findModelsFile(filepath.Join("manifests", n.Filepath()))
+	if err != nil {
+		return nil, err
+	}
 
 	var m Manifest
 	f, err := os.Open(p)
@@ -91,17 +114,17 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 	m.filepath = p
 	m.fi = fi
 	m.digest = hex.EncodeToString(sha256sum.Sum(nil))
+	m.dir = filepath.Clean(strings.TrimSuffix(p, filepath.Join("manifests", n.Filepath())))
 
 	return &m, nil
 }
 
 func WriteManifest(name model.Name, config Layer, layers []Layer) error {
-	manifests, err := GetManifestPath()
+	p, err := findModelsFile(filepath.Join("manifests", name.Filepath()))
 	if err != nil {
 		return err
 	}
 
-	p := filepath.Join(manifests, name.Filepath())
 	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
 		return err
 	}
@@ -114,53 +137,76 @@ func WriteManifest(name model.Name, config Layer, layers []Layer) error {
 
 	m := Manifest{
 		SchemaVersion: 2,
-		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		MediaType:     mediaTypeDockerManifest,
 		Config:        config,
 		Layers:        layers,
 	}
 
-	return json.NewEncoder(f).Encode(m)
+	if err := json.NewEncoder(f).Encode(m); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := placeModel(name); err != nil {
+		return err
+	}
+
+	return storeManifest(name.Filepath())
 }
 
 func Manifests() (map[model.Name]*Manifest, error) {
-	manifests, err := GetManifestPath()
+	dirs, err := modelsSubdirs("manifests")
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO(mxyng): use something less brittle
-	matches, err := filepath.Glob(filepath.Join(manifests, "*", "*", "*", "*"))
-	if err != nil {
-		return nil, err
+	if err := syncManifests(context.Background()); err != nil {
+		slog.Warn("couldn't sync manifests from store", "error", err)
 	}
 
 	ms := make(map[model.Name]*Manifest)
-	for _, match := range matches {
-		fi, err := os.Stat(match)
+	for _, manifests := range dirs {
+		// TODO(mxyng): use something less brittle
+		matches, err := filepath.Glob(filepath.Join(manifests, "*", "*", "*", "*"))
 		if err != nil {
 			return nil, err
 		}
 
-		if !fi.IsDir() {
-			rel, err := filepath.Rel(manifests, match)
+		for _, match := range matches {
+			fi, err := os.Stat(match)
 			if err != nil {
-				slog.Warn("bad filepath", "path", match, "error", err)
-				continue
-			}
-
-			n := model.ParseNameFromFilepath(rel)
-			if !n.IsValid() {
-				slog.Warn("bad manifest name", "path", rel, "error", err)
-				continue
+				return nil, err
 			}
 
-			m, err := ParseNamedManifest(n)
-			if err != nil {
-				slog.Warn("bad manifest", "name", n, "error", err)
-				continue
+			if !fi.IsDir() {
+				rel, err := filepath.Rel(manifests, match)
+				if err != nil {
+					slog.Warn("bad filepath", "path", match, "error", err)
+					continue
+				}
+
+				n := model.ParseNameFromFilepath(rel)
+				if !n.IsValid() {
+					slog.Warn("bad manifest name", "path", rel, "error", err)
+					continue
+				}
+
+				if _, ok := ms[n]; ok {
+					// the first models directory with the manifest has precedence
+					continue
+				}
+
+				m, err := ParseNamedManifest(n)
+				if err != nil {
+					slog.Warn("bad manifest", "name", n, "error", err)
+					continue
+				}
+
+				ms[n] = m
 			}
-
-			ms[n] = m
 		}
 	}
 
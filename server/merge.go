@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// mergeModel folds the LoRA adapters into the model in layer, scaled like
+// they would be by the runner, and returns a layer of the merged model
+func mergeModel(layer *layerGGML, adapters []*layerGGML, scales []float32, fn func(api.ProgressResponse)) (*layerGGML, error) {
+	if layer.GGML == nil || layer.GGML.Name() != "gguf" {
+		return nil, fmt.Errorf("%w: adapters can only be merged into GGUF models", errAdapter)
+	}
+
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(adapters))
+	for i, adapter := range adapters {
+		if paths[i], err = GetBlobsPath(adapter.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	status := "merging adapter"
+	if len(adapters) > 1 {
+		status = fmt.Sprintf("merging %d adapters", len(adapters))
+	}
+
+	return convertLayer(layer.MediaType, status, fn, func(w io.Writer) error {
+		if err := llm.MergeAdapters(w, blob, paths, scales); err != nil {
+			return fmt.Errorf("%w: %w", errAdapter, err)
+		}
+
+		return nil
+	})
+}
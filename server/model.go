@@ -1,13 +1,13 @@
 package server
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
@@ -15,6 +15,7 @@ import (
 	"slices"
 	"strings"
 	"text/template/parse"
+	"time"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/convert"
@@ -55,6 +56,8 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 		switch layer.MediaType {
 		case "application/vnd.ollama.image.model",
 			"application/vnd.ollama.image.projector",
+			"application/vnd.ollama.image.classifier",
+			"application/vnd.ollama.image.vocoder",
 			"application/vnd.ollama.image.adapter":
 			blobpath, err := GetBlobsPath(layer.Digest)
 			if err != nil {
@@ -81,43 +84,72 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 	return layers, nil
 }
 
-func parseFromZipFile(_ context.Context, f *os.File, digest string, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
+// parseFromZipFile converts the model, or PEFT LoRA adapter for base, in f
+func parseFromZipFile(_ context.Context, f *os.File, digest string, base *llm.GGML, bf16 bool, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
 	fi, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := zip.NewReader(f, fi.Size())
+	p, err := os.MkdirTemp(filepath.Dir(f.Name()), "")
 	if err != nil {
 		return nil, err
 	}
+	defer os.RemoveAll(p)
 
-	p, err := os.MkdirTemp(filepath.Dir(f.Name()), "")
+	// the files of the archive are read in place, only compressed ones are
+	// extracted to p
+	fsys, err := convert.NewZipReader(f, fi.Size(), p, 32<<20)
 	if err != nil {
 		return nil, err
 	}
-	defer os.RemoveAll(p)
 
-	fn(api.ProgressResponse{Status: "converting model"})
-	// TODO(mxyng): this should write directly into a layer
-	// e.g. NewLayer(arch.Reader(), "application/vnd.ollama.image.model")
-	t, err := os.CreateTemp(p, "fp16")
+	if _, err := fs.Stat(fsys, "adapter_config.json"); err == nil {
+		return parseAdapterFromZipFile(fsys, base, fn)
+	}
+
+	layer, err := convertLayer("application/vnd.ollama.image.model", "converting model", fn, func(w io.Writer) error {
+		return convert.Convert(fsys, w, bf16)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer t.Close()
-	defer os.Remove(t.Name())
 
-	fn(api.ProgressResponse{Status: "converting model"})
-	if err := convert.Convert(convert.NewZipReader(r, p, 32<<20), t); err != nil {
+	layers = append(layers, layer)
+
+	head, err := parseHeadFromZipFile(fsys)
+	if err != nil {
 		return nil, err
+	} else if head != nil {
+		layers = append(layers, head)
 	}
 
-	if _, err := t.Seek(0, io.SeekStart); err != nil {
-		return nil, err
+	// only F16 conversions are cached, which is what most models are
+	// converted to
+	if !bf16 {
+		intermediateBlobs[digest] = layer.Digest
 	}
+	return detectChatTemplate(layers)
+}
+
+// convertLayer creates a layer of mediatype from the GGUF write writes, which
+// is streamed into the blob rather than written to a temporary file first.
+// Unless fn is nil, the progress of writing it is reported with status.
+func convertLayer(mediatype, status string, fn func(api.ProgressResponse), write func(io.Writer) error) (*layerGGML, error) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	var w io.Writer = pw
+	if fn != nil {
+		fn(api.ProgressResponse{Status: status})
+		w = &convertProgress{w: pw, status: status, fn: fn}
+	}
+
+	go func() {
+		pw.CloseWithError(write(w))
+	}()
 
-	layer, err := NewLayer(t, "application/vnd.ollama.image.model")
+	layer, err := NewLayer(pr, mediatype)
 	if err != nil {
 		return nil, err
 	}
@@ -133,13 +165,103 @@ func parseFromZipFile(_ context.Context, f *os.File, digest string, fn func(api.
 		return nil, err
 	}
 
-	layers = append(layers, &layerGGML{layer, ggml})
+	return &layerGGML{layer, ggml}, nil
+}
 
-	intermediateBlobs[digest] = layer.Digest
-	return detectChatTemplate(layers)
+// progressInterval is how often the progress of converting or quantizing a
+// model is reported
+const progressInterval = 250 * time.Millisecond
+
+// convertProgress reports the progress of a GGUF written to w through fn, in
+// bytes of the file and with the tensor being written, as an
+// [llm.TensorWriter]
+type convertProgress struct {
+	w      io.Writer
+	status string
+	fn     func(api.ProgressResponse)
+
+	tensor           string
+	total, completed int64
+	reported         time.Time
+}
+
+func (p *convertProgress) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.completed += int64(n)
+	if p.total > 0 && (p.completed >= p.total || time.Since(p.reported) >= progressInterval) {
+		p.report()
+	}
+
+	return n, err
+}
+
+func (p *convertProgress) StartTensor(name string, total int64) {
+	p.tensor, p.total = name, total
+	if time.Since(p.reported) >= progressInterval {
+		p.report()
+	}
+}
+
+func (p *convertProgress) report() {
+	p.reported = time.Now()
+	p.fn(api.ProgressResponse{Status: p.status, Tensor: p.tensor, Total: p.total, Completed: min(p.completed, p.total)})
+}
+
+// overrideLayer creates a layer of the GGUF model in layer with the metadata
+// in overrides set, see [llm.OverrideKV]
+func overrideLayer(layer *layerGGML, overrides map[string]string, fn func(api.ProgressResponse)) (*layerGGML, error) {
+	if layer.GGML == nil || layer.GGML.Name() != "gguf" {
+		return nil, errors.New("metadata can only be overridden for GGUF models")
+	}
+
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertLayer(layer.MediaType, "overriding metadata", fn, func(w io.Writer) error {
+		return llm.OverrideKV(w, blob, overrides)
+	})
+}
+
+// parseAdapterFromZipFile converts a PEFT LoRA adapter into an adapter layer
+// for base. Unlike models, converted adapters aren't cached as they depend on
+// base.
+func parseAdapterFromZipFile(fsys fs.FS, base *llm.GGML, fn func(api.ProgressResponse)) ([]*layerGGML, error) {
+	if base == nil {
+		return nil, fmt.Errorf("%w: safetensors adapters must be given by ADAPTER after FROM", errAdapter)
+	}
+
+	layer, err := convertLayer("application/vnd.ollama.image.adapter", "converting adapter", fn, func(w io.Writer) error {
+		if err := convert.ConvertAdapter(fsys, w, base); err != nil {
+			return fmt.Errorf("%w: %w", errAdapter, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []*layerGGML{layer}, nil
 }
 
-func parseFromFile(ctx context.Context, file *os.File, digest string, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
+// parseHeadFromZipFile converts the classification head of a model, if it has
+// one, into a classifier layer
+func parseHeadFromZipFile(fsys fs.FS) (*layerGGML, error) {
+	layer, err := convertLayer("application/vnd.ollama.image.classifier", "", nil, func(w io.Writer) error {
+		return convert.ConvertHead(fsys, w)
+	})
+	if errors.Is(err, convert.ErrNoHead) {
+		return nil, nil
+	}
+
+	return layer, err
+}
+
+// parseFromFile parses the models in file. base is the model a PEFT adapter in
+// file is converted for, if there is one.
+func parseFromFile(ctx context.Context, file *os.File, digest string, base *llm.GGML, bf16 bool, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
 	sr := io.NewSectionReader(file, 0, 512)
 	contentType, err := detectContentType(sr)
 	if err != nil {
@@ -150,7 +272,7 @@ func parseFromFile(ctx context.Context, file *os.File, digest string, fn func(ap
 	case "gguf", "ggla":
 		// noop
 	case "application/zip":
-		return parseFromZipFile(ctx, file, digest, fn)
+		return parseFromZipFile(ctx, file, digest, base, bf16, fn)
 	default:
 		return nil, fmt.Errorf("unsupported content type: %s", contentType)
 	}
@@ -170,10 +292,14 @@ func parseFromFile(ctx context.Context, file *os.File, digest string, fn func(ap
 		}
 
 		mediatype := "application/vnd.ollama.image.model"
-		if ggml.Name() == "ggla" {
+		if ggml.Name() == "ggla" || ggml.KV().Kind() == "adapter" {
 			mediatype = "application/vnd.ollama.image.adapter"
 		} else if ggml.KV().Architecture() == "clip" {
 			mediatype = "application/vnd.ollama.image.projector"
+		} else if ggml.KV().Architecture() == "classifier" {
+			mediatype = "application/vnd.ollama.image.classifier"
+		} else if ggml.KV().Architecture() == "wavtokenizer-dec" {
+			mediatype = "application/vnd.ollama.image.vocoder"
 		}
 
 		var layer Layer
@@ -204,6 +330,19 @@ func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 		if s := layer.GGML.KV().ChatTemplate(); s != "" {
 			if t, err := template.Named(s); err != nil {
 				slog.Debug("template detection", "error", err)
+
+				// templates which aren't known are converted instead
+				if s, err := template.FromJinja(s, jinjaVars(layer)); err != nil {
+					slog.Debug("template conversion", "error", err)
+				} else {
+					layer, err := NewLayer(strings.NewReader(s), "application/vnd.ollama.image.template")
+					if err != nil {
+						return nil, err
+					}
+
+					layer.status = "using template converted from jinja"
+					layers = append(layers, &layerGGML{layer, nil})
+				}
 			} else {
 				layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
 				if err != nil {
@@ -233,6 +372,46 @@ func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 	return layers, nil
 }
 
+// jinjaVars returns the bos_token and eos_token of the model of layer for
+// templates converted from jinja. The tokens are read again since they're
+// too many to be decoded with the rest of the model's metadata.
+func jinjaVars(layer *layerGGML) map[string]string {
+	if layer == nil || layer.GGML == nil {
+		return nil
+	}
+
+	p, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	ggml, _, err := llm.DecodeGGML(f, -1)
+	if err != nil {
+		return nil
+	}
+
+	kv := ggml.KV()
+	vars := map[string]string{"bos_token": ""}
+	if eos, ok := kv.Token("tokenizer.ggml.eos_token_id"); ok {
+		vars["eos_token"] = eos
+	}
+
+	// the runner adds the bos token to prompts, unless the model says not to
+	if add, ok := kv["tokenizer.ggml.add_bos_token"].(bool); ok && !add {
+		if bos, ok := kv.Token("tokenizer.ggml.bos_token_id"); ok {
+			vars["bos_token"] = bos
+		}
+	}
+
+	return vars
+}
+
 func detectContentType(r io.Reader) (string, error) {
 	var b bytes.Buffer
 	if _, err := io.Copy(&b, r); err != nil {
@@ -153,7 +153,7 @@ func TestParseFromFileFromLayer(t *testing.T) {
 		t.Fatalf("failed to seek to start: %v", err)
 	}
 
-	layers, err := parseFromFile(context.Background(), file, "", func(api.ProgressResponse) {})
+	layers, err := parseFromFile(context.Background(), file, "", nil, false, func(api.ProgressResponse) {})
 	if err != nil {
 		t.Fatalf("failed to parse from file: %v", err)
 	}
@@ -166,7 +166,7 @@ func TestParseFromFileFromLayer(t *testing.T) {
 		t.Fatalf("failed to seek to start: %v", err)
 	}
 
-	layers2, err := parseFromFile(context.Background(), file, layers[0].Digest, func(api.ProgressResponse) {})
+	layers2, err := parseFromFile(context.Background(), file, layers[0].Digest, nil, false, func(api.ProgressResponse) {})
 	if err != nil {
 		t.Fatalf("failed to parse from file: %v", err)
 	}
@@ -206,7 +206,7 @@ func TestParseLayerFromCopy(t *testing.T) {
 		t.Fatalf("failed to seek to start: %v", err)
 	}
 
-	layers, err := parseFromFile(context.Background(), file2, "", func(api.ProgressResponse) {})
+	layers, err := parseFromFile(context.Background(), file2, "", nil, false, func(api.ProgressResponse) {})
 	if err != nil {
 		t.Fatalf("failed to parse from file: %v", err)
 	}
@@ -215,3 +215,37 @@ func TestParseLayerFromCopy(t *testing.T) {
 		t.Fatalf("got %d != want 5", len(layers))
 	}
 }
+
+func TestConvertLayerProgress(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var resps []api.ProgressResponse
+	layer, err := convertLayer("application/vnd.ollama.image.model", "converting model", func(resp api.ProgressResponse) {
+		resps = append(resps, resp)
+	}, func(w io.Writer) error {
+		return llm.WriteGGUF(w, llm.KV{"general.architecture": "llama"}, []llm.Tensor{
+			f32Tensor("token_embd.weight", make([]float32, 1024)...),
+			f32Tensor("output.weight", make([]float32, 1024)...),
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resps) < 2 {
+		t.Fatalf("expected progress, got %v", resps)
+	}
+
+	if resps[0].Status != "converting model" || resps[0].Total != 0 {
+		t.Errorf("expected status without progress first, got %v", resps[0])
+	}
+
+	last := resps[len(resps)-1]
+	if last.Total != layer.Size || last.Completed != last.Total {
+		t.Errorf("expected completed progress of %d bytes, got %v", layer.Size, last)
+	}
+
+	if last.Tensor != "output.weight" {
+		t.Errorf("expected tensor output.weight, got %q", last.Tensor)
+	}
+}
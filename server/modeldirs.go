@@ -0,0 +1,358 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errNoModelsDir = errors.New("no models directory has room for the model")
+
+// modelsDir is one of the models directories set with OLLAMA_MODELS and
+// the policies deciding which models are placed in it
+type modelsDir struct {
+	path string
+
+	// maxSize is the total size of the blobs placing a model may grow the
+	// directory to, or 0 for no limit
+	maxSize int64
+
+	// models are the name patterns of the models placed in the directory,
+	// or empty for any model
+	models []string
+}
+
+func parseModelsDir(s string) (modelsDir, error) {
+	dir, query, _ := strings.Cut(s, "?")
+	d := modelsDir{path: dir}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return d, fmt.Errorf("models directory %s: %w", dir, err)
+	}
+
+	for k, v := range values {
+		switch k {
+		case "max":
+			n, err := parseSize(v[len(v)-1])
+			if err != nil {
+				return d, fmt.Errorf("models directory %s: %w", dir, err)
+			}
+
+			d.maxSize = n
+		case "models":
+			for _, pattern := range v {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return d, fmt.Errorf("models directory %s: bad pattern %q", dir, pattern)
+				}
+			}
+
+			d.models = v
+		default:
+			return d, fmt.Errorf("models directory %s: unknown policy %q", dir, k)
+		}
+	}
+
+	return d, nil
+}
+
+// parseSize parses a size such as 500GB or 1.5TiB
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"B", 1},
+	}
+
+	n, size := strings.ToUpper(strings.TrimSpace(s)), 1.
+	for _, unit := range units {
+		if strings.HasSuffix(n, unit.suffix) {
+			n, size = strings.TrimSpace(strings.TrimSuffix(n, unit.suffix)), unit.size
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+
+	return int64(f * size), nil
+}
+
+func modelsDirs() ([]modelsDir, error) {
+	var dirs []modelsDir
+	for _, s := range envconfig.ModelsDirs() {
+		d, err := parseModelsDir(s)
+		if err != nil {
+			return nil, err
+		}
+
+		dirs = append(dirs, d)
+	}
+
+	return dirs, nil
+}
+
+// modelsSubdirs returns the subdirectory sub, such as "blobs", of every
+// models directory
+func modelsSubdirs(sub string) ([]string, error) {
+	dirs, err := modelsDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(dirs))
+	for i, d := range dirs {
+		paths[i] = filepath.Join(d.path, sub)
+		if err := os.MkdirAll(paths[i], 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// findModelsFile returns the path of rel in the first models directory
+// which has it, or in the first models directory if none do
+func findModelsFile(rel string) (string, error) {
+	dirs, err := modelsDirs()
+	if err != nil {
+		return "", err
+	}
+
+	for _, d := range dirs {
+		p := filepath.Join(d.path, rel)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	return filepath.Join(dirs[0].path, rel), nil
+}
+
+// match reports whether the placement policies of d allow n
+func (d modelsDir) match(n model.Name) bool {
+	if len(d.models) == 0 {
+		return true
+	}
+
+	name := n.DisplayShortest()
+	for _, pattern := range d.models {
+		s := name
+		if !strings.Contains(pattern, ":") {
+			// patterns without a tag match every tag
+			if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+				s = s[:i]
+			}
+		}
+
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// used returns the total size of the blobs in d
+func (d modelsDir) used() (int64, error) {
+	entries, err := os.ReadDir(filepath.Join(d.path, "blobs"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+	}
+
+	return size, nil
+}
+
+// placeModel moves the manifest of n to the models directory selected by
+// the placement policies, along with the layers written for it to the first
+// models directory. Layers already in other directories are shared where
+// they are.
+func placeModel(n model.Name) error {
+	dirs, err := modelsDirs()
+	if err != nil || len(dirs) < 2 {
+		return err
+	}
+
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		return err
+	}
+
+	// directories which match n by name come before ones which take any model
+	var candidates []modelsDir
+	for _, d := range dirs {
+		if len(d.models) > 0 && d.match(n) {
+			candidates = append(candidates, d)
+		}
+	}
+
+	for _, d := range dirs {
+		if len(d.models) == 0 {
+			candidates = append(candidates, d)
+		}
+	}
+
+	var layers []Layer
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest != "" {
+			layers = append(layers, layer)
+		}
+	}
+
+	staged := func(layer Layer) string {
+		return filepath.Join(dirs[0].path, "blobs", strings.ReplaceAll(layer.Digest, ":", "-"))
+	}
+
+	var dst *modelsDir
+	for _, d := range candidates {
+		if d.maxSize > 0 {
+			used, err := d.used()
+			if err != nil {
+				return err
+			}
+
+			for _, layer := range layers {
+				if d.path == dirs[0].path {
+					break
+				}
+
+				if _, err := os.Stat(staged(layer)); err == nil {
+					used += layer.Size
+				}
+			}
+
+			if used > d.maxSize {
+				continue
+			}
+		}
+
+		dst = &d
+		break
+	}
+
+	if dst == nil {
+		return fmt.Errorf("%w: %s", errNoModelsDir, n.DisplayShortest())
+	}
+
+	if dst.path != dirs[0].path {
+		// blobs which other models outside dst also use are copied so they
+		// stay where those models find them
+		ms, err := Manifests()
+		if err != nil {
+			return err
+		}
+
+		shared := make(map[string]bool)
+		for other, om := range ms {
+			if other == n || om.dir == dst.path {
+				continue
+			}
+
+			for _, layer := range append(om.Layers, om.Config) {
+				shared[layer.Digest] = true
+			}
+		}
+
+		for _, layer := range layers {
+			src := staged(layer)
+			if _, err := os.Stat(src); err != nil {
+				continue
+			}
+
+			blobs := filepath.Join(dst.path, "blobs")
+			move := moveFile
+			if shared[layer.Digest] {
+				move = copyFile
+			}
+
+			if err := move(blobs, src, filepath.Join(blobs, filepath.Base(src))); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.dir != dst.path {
+		slog.Info("moving model", "name", n.DisplayShortest(), "from", m.dir, "to", dst.path)
+		manifests := filepath.Join(dst.path, "manifests")
+		if err := moveFile(manifests, m.filepath, filepath.Join(manifests, n.Filepath())); err != nil {
+			return err
+		}
+
+		return PruneDirectory(filepath.Join(m.dir, "manifests"))
+	}
+
+	return nil
+}
+
+// moveFile moves src to dst, copying it through a temporary file in dir if
+// they're on different file systems
+func moveFile(dir, src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeFileAtomic(dir, dst, f, ""); err != nil {
+		return err
+	}
+
+	f.Close()
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst, linking them if they're on the same file
+// system, and copying it through a temporary file in dir if not
+func copyFile(dir, src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.Link(src, dst); err == nil || errors.Is(err, os.ErrExist) {
+		return nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFileAtomic(dir, dst, f, "")
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestParseModelsDir(t *testing.T) {
+	cases := []struct {
+		value  string
+		expect modelsDir
+		err    bool
+	}{
+		{value: "/models", expect: modelsDir{path: "/models"}},
+		{value: "/nvme?max=200GB", expect: modelsDir{path: "/nvme", maxSize: 200_000_000_000}},
+		{value: "/nvme?max=1.5TiB", expect: modelsDir{path: "/nvme", maxSize: 3 << 39}},
+		{value: "/hdd?models=llama3:70b*&models=qwen*", expect: modelsDir{path: "/hdd", models: []string{"llama3:70b*", "qwen*"}}},
+		{value: "/nvme?max=lots", err: true},
+		{value: "/nvme?max=-1GB", err: true},
+		{value: "/hdd?models=[", err: true},
+		{value: "/hdd?min=1GB", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.value, func(t *testing.T) {
+			d, err := parseModelsDir(tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if d.path != tt.expect.path || d.maxSize != tt.expect.maxSize || !slices.Equal(d.models, tt.expect.models) {
+				t.Errorf("expected %+v, got %+v", tt.expect, d)
+			}
+		})
+	}
+}
+
+func TestModelsDirMatch(t *testing.T) {
+	d := modelsDir{models: []string{"llama3", "qwen2:*b", "hf.co/*/*"}}
+
+	cases := map[string]bool{
+		"llama3":                  true,
+		"llama3:70b":              true,
+		"llama3.1":                false,
+		"qwen2:72b":               true,
+		"qwen2:latest":            false,
+		"hf.co/bartowski/gemma-2": true,
+		"mistral":                 false,
+	}
+
+	for name, expect := range cases {
+		if got := d.match(model.ParseName(name)); got != expect {
+			t.Errorf("%s: expected %t, got %t", name, expect, got)
+		}
+	}
+}
+
+func TestModelsDirs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	fast, large, cold := t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("OLLAMA_MODELS", fmt.Sprintf("%s,%s?models=large*,%s", fast, large, cold))
+
+	var s Server
+	create := func(name string) {
+		t.Helper()
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      name,
+			Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, map[string]any{"general.name": name}, nil)),
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	create("small")
+	create("large")
+
+	checkFileExists(t, filepath.Join(fast, "manifests", "*", "*", "*", "*"), []string{
+		filepath.Join(fast, "manifests", "registry.ollama.ai", "library", "small", "latest"),
+	})
+
+	checkFileExists(t, filepath.Join(large, "manifests", "*", "*", "*", "*"), []string{
+		filepath.Join(large, "manifests", "registry.ollama.ai", "library", "large", "latest"),
+	})
+
+	m, err := ParseNamedManifest(model.ParseName("large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, layer := range append(m.Layers, m.Config) {
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if filepath.Dir(p) != filepath.Join(large, "blobs") {
+			t.Errorf("expected %s to be moved to %s", layer.Digest, large)
+		}
+	}
+
+	// the first directory is full so other models are placed in the last
+	used, err := modelsDir{path: fast}.used()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_MODELS", fmt.Sprintf("%s?max=%d,%s?models=large*,%s", fast, used, large, cold))
+	create("other")
+
+	checkFileExists(t, filepath.Join(cold, "manifests", "*", "*", "*", "*"), []string{
+		filepath.Join(cold, "manifests", "registry.ollama.ai", "library", "other", "latest"),
+	})
+
+	w := createRequest(t, s.ListModelsHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ListResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	locations := make(map[string]string)
+	for _, m := range resp.Models {
+		locations[m.Name] = m.Location
+	}
+
+	expect := map[string]string{"small:latest": fast, "large:latest": large, "other:latest": cold}
+	if len(locations) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, locations)
+	}
+
+	for name, location := range expect {
+		if locations[name] != location {
+			t.Errorf("expected %s in %s, got %s", name, location, locations[name])
+		}
+	}
+
+	// copies are placed by their own name
+	w = createRequest(t, s.CopyModelHandler, api.CopyRequest{Source: "small", Destination: "large-copy"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(large, "manifests", "*", "*", "*", "*"), []string{
+		filepath.Join(large, "manifests", "registry.ollama.ai", "library", "large", "latest"),
+		filepath.Join(large, "manifests", "registry.ollama.ai", "library", "large-copy", "latest"),
+	})
+
+	if _, err := GetModel("large-copy"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the blobs small shares with the copy stay with it
+	small, err := ParseNamedManifest(model.ParseName("small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, layer := range append(small.Layers, small.Config) {
+		name := strings.ReplaceAll(layer.Digest, ":", "-")
+		for _, dir := range []string{fast, large} {
+			if _, err := os.Stat(filepath.Join(dir, "blobs", name)); err != nil {
+				t.Errorf("expected %s in %s: %v", layer.Digest, dir, err)
+			}
+		}
+	}
+
+	w = createRequest(t, s.DeleteModelHandler, api.DeleteRequest{Name: "large"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(large, "manifests", "*", "*", "*", "*"), []string{
+		filepath.Join(large, "manifests", "registry.ollama.ai", "library", "large-copy", "latest"),
+	})
+
+	for _, layer := range append(m.Layers, m.Config) {
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if filepath.Dir(p) == filepath.Join(large, "blobs") {
+			t.Errorf("expected %s to be removed", layer.Digest)
+		}
+	}
+}
+
+func TestModelsDirsFull(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", fmt.Sprintf("%s?max=1B,%s?max=1B", t.TempDir(), t.TempDir()))
+
+	var s Server
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+	})
+
+	// errors are streamed after the status code
+	if !strings.Contains(w.Body.String(), errNoModelsDir.Error()) {
+		t.Errorf("expected %q, got %s", errNoModelsDir, w.Body.String())
+	}
+}
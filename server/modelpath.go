@@ -3,6 +3,7 @@ package server
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -104,8 +105,9 @@ func (mp ModelPath) GetShortTagname() string {
 }
 
 // GetManifestPath returns the path to the manifest file for the given model path, it is up to the caller to create the directory if it does not exist.
+// The manifest is in the first models directory unless another one already has it.
 func (mp ModelPath) GetManifestPath() (string, error) {
-	return filepath.Join(envconfig.Models(), "manifests", mp.Registry, mp.Namespace, mp.Repository, mp.Tag), nil
+	return findModelsFile(filepath.Join("manifests", mp.Registry, mp.Namespace, mp.Repository, mp.Tag))
 }
 
 func (mp ModelPath) BaseURL() *url.URL {
@@ -115,6 +117,29 @@ func (mp ModelPath) BaseURL() *url.URL {
 	}
 }
 
+// isOllamaRegistry reports whether host is ollama.com, which models are
+// pushed to as Docker manifests signed with the Ollama key, rather than a
+// standard OCI registry
+func isOllamaRegistry(host string) bool {
+	return host == DefaultRegistry || host == "ollama.com"
+}
+
+// PullURL returns the base URL models are pulled from, which is the registry
+// cache set with OLLAMA_REGISTRY_MIRROR for models of the default registry
+func (mp ModelPath) PullURL() *url.URL {
+	if mp.Registry == DefaultRegistry {
+		if mirror := envconfig.RegistryMirror(); mirror != "" {
+			if u, err := url.Parse(mirror); err == nil && u.Host != "" {
+				return &url.URL{Scheme: u.Scheme, Host: u.Host}
+			}
+
+			slog.Warn("invalid registry mirror, pulling from the registry", "mirror", mirror)
+		}
+	}
+
+	return mp.BaseURL()
+}
+
 func GetManifestPath() (string, error) {
 	path := filepath.Join(envconfig.Models(), "manifests")
 	if err := os.MkdirAll(path, 0o755); err != nil {
@@ -135,6 +160,16 @@ func GetBlobsPath(digest string) (string, error) {
 
 	digest = strings.ReplaceAll(digest, ":", "-")
 	path := filepath.Join(envconfig.Models(), "blobs", digest)
+	if digest != "" {
+		// blobs are shared between models directories
+		p, err := findModelsFile(filepath.Join("blobs", digest))
+		if err != nil {
+			return "", err
+		}
+
+		path = p
+	}
+
 	dirPath := filepath.Dir(path)
 	if digest == "" {
 		dirPath = path
@@ -146,3 +181,14 @@ func GetBlobsPath(digest string) (string, error) {
 
 	return path, nil
 }
+
+// blobExists reports whether the blob with digest is on disk
+func blobExists(digest string) bool {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(p)
+	return err == nil
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// fakeOCIRegistry is an in-memory registry implementing the parts of the OCI
+// distribution spec used to push and pull models, with relative upload
+// locations and blobs served without redirects
+type fakeOCIRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	uploads   map[string]*bytes.Buffer
+	manifests map[string][]byte
+	types     map[string]string
+}
+
+func (f *fakeOCIRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	repo, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/blobs/")
+	if !ok {
+		var tag string
+		repo, tag, ok = strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/manifests/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		key := repo + ":" + tag
+		switch r.Method {
+		case http.MethodPut:
+			if ct := r.Header.Get("Content-Type"); ct != mediaTypeOCIManifest {
+				http.Error(w, "unsupported manifest type "+ct, http.StatusBadRequest)
+				return
+			}
+
+			b, _ := io.ReadAll(r.Body)
+			f.manifests[key], f.types[key] = b, r.Header.Get("Content-Type")
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			b, ok := f.manifests[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.Header().Set("Content-Type", f.types[key])
+			w.Write(b)
+		}
+
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && rest == "uploads/":
+		id := fmt.Sprint(len(f.uploads))
+		f.uploads[id] = new(bytes.Buffer)
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPatch && strings.HasPrefix(rest, "uploads/"):
+		id := strings.TrimPrefix(rest, "uploads/")
+		var start int
+		fmt.Sscanf(r.Header.Get("Content-Range"), "%d-", &start)
+		if start != f.uploads[id].Len() {
+			http.Error(w, "out of order chunk", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+
+		io.Copy(f.uploads[id], r.Body)
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && strings.HasPrefix(rest, "uploads/"):
+		id := strings.TrimPrefix(rest, "uploads/")
+		b := f.uploads[id].Bytes()
+		digest := r.URL.Query().Get("digest")
+		if fmt.Sprintf("sha256:%x", sha256.Sum256(b)) != digest {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+
+		f.blobs[digest] = b
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		b, ok := f.blobs[rest]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestOCIRegistry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	f := fakeOCIRegistry{
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string]*bytes.Buffer),
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+	}
+
+	ts := httptest.NewServer(&f)
+	defer ts.Close()
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	name := strings.TrimPrefix(ts.URL, "http://") + "/team/model:v1"
+
+	var s Server
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      name,
+		Modelfile: fmt.Sprintf("FROM %s\nTEMPLATE {{ .Prompt }}", createBinFile(t, nil, nil)),
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	if err := PushModel(context.Background(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(f.manifests["team/model:v1"], &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.MediaType != mediaTypeOCIManifest || m.ArtifactType != artifactTypeModel {
+		t.Errorf("expected an OCI artifact, got %s %s", m.MediaType, m.ArtifactType)
+	}
+
+	if len(f.blobs) != len(m.Layers)+1 {
+		t.Errorf("expected %d blobs, got %d", len(m.Layers)+1, len(f.blobs))
+	}
+
+	// pull the model back from the registry
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	if err := PullModel(context.Background(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := GetModel(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model.Template.String() != "{{ .Prompt }}" {
+		t.Errorf("unexpected template %q", model.Template.String())
+	}
+
+	// image indexes aren't models
+	f.manifests["team/model:index"] = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+	f.types["team/model:index"] = mediaTypeOCIIndex
+	if _, err := pullModelManifest(context.Background(), ParseModelPath(strings.TrimSuffix(name, ":v1")+":index"), &registryOptions{Insecure: true}); err == nil {
+		t.Error("expected an error pulling an image index")
+	}
+}
+
+func TestPullReusesLayers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	f := fakeOCIRegistry{
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string]*bytes.Buffer),
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+	}
+
+	ts := httptest.NewServer(&f)
+	defer ts.Close()
+
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	name := strings.TrimPrefix(ts.URL, "http://") + "/team/model:latest"
+	src, dst := t.TempDir(), t.TempDir()
+	bin := createBinFile(t, nil, nil)
+
+	push := func(template string) {
+		t.Helper()
+		t.Setenv("OLLAMA_MODELS", src)
+
+		var s Server
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      name,
+			Modelfile: fmt.Sprintf("FROM %s\nTEMPLATE %s", bin, template),
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		if err := PushModel(context.Background(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pull := func() (statuses []string) {
+		t.Helper()
+		t.Setenv("OLLAMA_MODELS", dst)
+
+		if err := PullModel(context.Background(), name, &registryOptions{Insecure: true}, func(resp api.ProgressResponse) {
+			if resp.Digest == "" {
+				statuses = append(statuses, resp.Status)
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		return statuses
+	}
+
+	push("{{ .Prompt }}")
+	for _, status := range pull() {
+		if strings.Contains(status, "reused") {
+			t.Errorf("unexpected status %q pulling a new model", status)
+		}
+	}
+
+	// the weights are reused when only the template changes
+	push("[INST] {{ .Prompt }} [/INST]")
+	if statuses := pull(); !slices.ContainsFunc(statuses, func(s string) bool {
+		return strings.HasPrefix(s, "1 of 3 layers reused")
+	}) {
+		t.Errorf("expected the weights to be reused, got %v", statuses)
+	}
+
+	model, err := GetModel(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model.Template.String() != "[INST] {{ .Prompt }} [/INST]" {
+		t.Errorf("unexpected template %q", model.Template.String())
+	}
+}
@@ -3,7 +3,11 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/llm"
@@ -12,39 +16,100 @@ import (
 
 type tokenizeFunc func(context.Context, string) ([]int, error)
 
+var errContextOverflow = errors.New("input exceeds the context length")
+
+// imageTokens caches the estimated number of tokens an image takes by
+// projector path
+var imageTokens = struct {
+	mu sync.Mutex
+	m  map[string]int
+}{m: make(map[string]int)}
+
+// modelImageTokens returns the estimated number of tokens an image takes in
+// the prompt of m, or 0 if m doesn't accept images
+func modelImageTokens(m *Model) int {
+	if len(m.ProjectorPaths) == 0 {
+		return 0
+	}
+
+	imageTokens.mu.Lock()
+	defer imageTokens.mu.Unlock()
+
+	path := m.ProjectorPaths[0]
+	if n, ok := imageTokens.m[path]; ok {
+		return n
+	}
+
+	n, err := llm.ProjectorImageTokens(path)
+	if err != nil {
+		slog.Warn("failed to read projector, assuming the default image size", "path", path, "error", err)
+		n = llm.DefaultImageTokens
+	}
+
+	imageTokens.m[path] = n
+	return n
+}
+
+// preprocessImages resizes images in place according to opts
+func preprocessImages(images []llm.ImageData, opts *api.Options) error {
+	for i := range images {
+		data, err := llm.PreprocessImage(images[i].Data, opts.ImageResize, opts.ImageMaxResolution)
+		if err != nil {
+			return fmt.Errorf("image %d: %w", images[i].ID, err)
+		}
+
+		images[i].Data = data
+	}
+
+	return nil
+}
+
 // chatPrompt accepts a list of messages and returns the prompt and images that should be used for the next chat turn.
 // chatPrompt truncates any messages that exceed the context window of the model, making sure to always include 1) the
-// latest message and 2) system messages
-func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool) (prompt string, images []llm.ImageData, _ error) {
-	var system []api.Message
-	// always include the last message
-	n := len(msgs) - 1
-	// in reverse, find all messages that fit into context window
-	for i := n - 1; i >= 0; i-- {
-		system = make([]api.Message, 0)
+// latest message and 2) system messages. The middle context overflow policy also keeps the first message, and the
+// error policy returns errContextOverflow instead of truncating. It returns the number of tokens which were dropped.
+func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool) (prompt string, images []llm.ImageData, discarded int, _ error) {
+	first := slices.IndexFunc(msgs, func(m api.Message) bool { return m.Role != "system" })
+	if opts.ContextOverflow != llm.ContextOverflowMiddle {
+		first = -1
+	}
+
+	// prefix returns the messages which are kept ahead of msgs[i:]
+	prefix := func(i int) []api.Message {
+		system := make([]api.Message, 0)
 		for j := range i {
-			if msgs[j].Role == "system" {
+			if msgs[j].Role == "system" || j == first {
 				system = append(system, msgs[j])
 			}
 		}
+		return system
+	}
 
+	count := func(msgs []api.Message) (int, error) {
 		var b bytes.Buffer
-		if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[i:]...), Tools: tools}); err != nil {
-			return "", nil, err
+		if err := m.Template.Execute(&b, template.Values{Messages: msgs, Tools: tools}); err != nil {
+			return 0, err
 		}
 
 		s, err := tokenize(ctx, b.String())
 		if err != nil {
-			return "", nil, err
+			return 0, err
 		}
 
 		c := len(s)
-		if m.ProjectorPaths != nil {
-			for _, m := range msgs[i:] {
-				// images are represented as 768 sized embeddings
-				// TODO: get embedding length from project metadata
-				c += 768 * len(m.Images)
-			}
+		for _, msg := range msgs {
+			c += modelImageTokens(m) * len(msg.Images)
+		}
+		return c, nil
+	}
+
+	// always include the last message
+	n := len(msgs) - 1
+	// in reverse, find all messages that fit into context window
+	for i := n - 1; i >= 0; i-- {
+		c, err := count(append(prefix(i), msgs[i:]...))
+		if err != nil {
+			return "", nil, 0, err
 		}
 
 		if c > opts.NumCtx {
@@ -55,13 +120,34 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 		}
 	}
 
+	kept := append(prefix(n), msgs[n:]...)
+	if len(kept) < len(msgs) || opts.ContextOverflow == llm.ContextOverflowError {
+		total, err := count(msgs)
+		if err != nil {
+			return "", nil, 0, err
+		}
+
+		if opts.ContextOverflow == llm.ContextOverflowError && total > opts.NumCtx {
+			return "", nil, 0, errContextOverflow
+		}
+
+		if len(kept) < len(msgs) {
+			c, err := count(kept)
+			if err != nil {
+				return "", nil, 0, err
+			}
+
+			discarded = max(total-c, 0)
+		}
+	}
+
 	// truncate any messages that do not fit into the context window
 	var b bytes.Buffer
-	if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[n:]...), Tools: tools}); err != nil {
-		return "", nil, err
+	if err := m.Template.Execute(&b, template.Values{Messages: kept, Tools: tools}); err != nil {
+		return "", nil, 0, err
 	}
 
-	for _, m := range msgs[n:] {
+	for _, m := range kept {
 		for _, i := range m.Images {
 			images = append(images, llm.ImageData{
 				ID:   len(images),
@@ -70,5 +156,5 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 		}
 	}
 
-	return b.String(), images, nil
+	return b.String(), images, discarded, nil
 }
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -13,14 +14,17 @@ import (
 
 func TestChatPrompt(t *testing.T) {
 	type expect struct {
-		prompt string
-		images [][]byte
+		prompt    string
+		images    [][]byte
+		discarded bool
+		error     error
 	}
 
 	cases := []struct {
-		name  string
-		limit int
-		msgs  []api.Message
+		name     string
+		limit    int
+		overflow string
+		msgs     []api.Message
 		expect
 	}{
 		{
@@ -44,7 +48,35 @@ func TestChatPrompt(t *testing.T) {
 				{Role: "user", Content: "A test. And a thumping good one at that, I'd wager."},
 			},
 			expect: expect{
-				prompt: "A test. And a thumping good one at that, I'd wager. ",
+				prompt:    "A test. And a thumping good one at that, I'd wager. ",
+				discarded: true,
+			},
+		},
+		{
+			name:     "truncate middle messages",
+			limit:    1,
+			overflow: "middle",
+			msgs: []api.Message{
+				{Role: "user", Content: "You're a test, Harry!"},
+				{Role: "assistant", Content: "I-I'm a what?"},
+				{Role: "user", Content: "A test. And a thumping good one at that, I'd wager."},
+			},
+			expect: expect{
+				prompt:    "You're a test, Harry!\n\nA test. And a thumping good one at that, I'd wager. ",
+				discarded: true,
+			},
+		},
+		{
+			name:     "messages exceed context",
+			limit:    1,
+			overflow: "error",
+			msgs: []api.Message{
+				{Role: "user", Content: "You're a test, Harry!"},
+				{Role: "assistant", Content: "I-I'm a what?"},
+				{Role: "user", Content: "A test. And a thumping good one at that, I'd wager."},
+			},
+			expect: expect{
+				error: errContextOverflow,
 			},
 		},
 		{
@@ -56,7 +88,8 @@ func TestChatPrompt(t *testing.T) {
 				{Role: "user", Content: "A test. And a thumping good one at that, I'd wager.", Images: []api.ImageData{[]byte("something")}},
 			},
 			expect: expect{
-				prompt: "[img-0] A test. And a thumping good one at that, I'd wager. ",
+				prompt:    "[img-0] A test. And a thumping good one at that, I'd wager. ",
+				discarded: true,
 				images: [][]byte{
 					[]byte("something"),
 				},
@@ -71,7 +104,8 @@ func TestChatPrompt(t *testing.T) {
 				{Role: "user", Content: "A test. And a thumping good one at that, I'd wager.", Images: []api.ImageData{[]byte("somethingelse")}},
 			},
 			expect: expect{
-				prompt: "[img-0] A test. And a thumping good one at that, I'd wager. ",
+				prompt:    "[img-0] A test. And a thumping good one at that, I'd wager. ",
+				discarded: true,
 				images: [][]byte{
 					[]byte("somethingelse"),
 				},
@@ -138,7 +172,8 @@ func TestChatPrompt(t *testing.T) {
 				{Role: "user", Content: "A test. And a thumping good one at that, I'd wager."},
 			},
 			expect: expect{
-				prompt: "[img-0] I-I'm a what? A test. And a thumping good one at that, I'd wager. ",
+				prompt:    "[img-0] I-I'm a what? A test. And a thumping good one at that, I'd wager. ",
+				discarded: true,
 				images: [][]byte{
 					[]byte("somethingelse"),
 				},
@@ -183,10 +218,14 @@ func TestChatPrompt(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			model := Model{Template: tmpl, ProjectorPaths: []string{"vision"}}
-			opts := api.Options{Runner: api.Runner{NumCtx: tt.limit}}
-			prompt, images, err := chatPrompt(context.TODO(), &model, mockRunner{}.Tokenize, &opts, tt.msgs, nil)
-			if err != nil {
-				t.Fatal(err)
+			opts := api.Options{Runner: api.Runner{NumCtx: tt.limit}, ContextOverflow: tt.overflow}
+			prompt, images, discarded, err := chatPrompt(context.TODO(), &model, mockRunner{}.Tokenize, &opts, tt.msgs, nil)
+			if !errors.Is(err, tt.error) {
+				t.Fatalf("expected error %v, got %v", tt.error, err)
+			}
+
+			if discarded > 0 != tt.discarded {
+				t.Errorf("expected discarded %t, got %d", tt.discarded, discarded)
 			}
 
 			if diff := cmp.Diff(prompt, tt.prompt); diff != "" {
@@ -0,0 +1,8 @@
+package server
+
+import "golang.org/x/sys/unix"
+
+// reflink creates dst as a copy on write clone of src on APFS
+func reflink(src, dst string) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
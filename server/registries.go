@@ -0,0 +1,205 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// registriesConfig is the configuration set with OLLAMA_REGISTRIES of how
+// registries are connected to. Registries are keyed by their host, with
+// its port if it has one, or * for every other host. Storage a registry
+// redirects downloads and uploads to is connected to with the settings of
+// its own host.
+type registriesConfig struct {
+	Registries map[string]registrySettings `json:"registries"`
+
+	dir string
+}
+
+type registrySettings struct {
+	// Proxy is the URL of the HTTP, HTTPS or SOCKS5 proxy requests are
+	// sent through, or "direct" to ignore HTTPS_PROXY and HTTP_PROXY
+	Proxy string `json:"proxy"`
+
+	// CA is the path of a PEM bundle, relative to the configuration, of
+	// certificate authorities trusted in addition to the system's
+	CA string `json:"ca"`
+
+	// Insecure connects to the registry over plain HTTP, like pulling and
+	// pushing with --insecure
+	Insecure bool `json:"insecure"`
+
+	// SkipVerify doesn't verify the registry's TLS certificate
+	SkipVerify bool `json:"skip_verify"`
+}
+
+// loadRegistriesConfig reads the configuration set with OLLAMA_REGISTRIES,
+// or returns nil if none is set
+func loadRegistriesConfig(p string) (*registriesConfig, error) {
+	if p == "" {
+		return nil, nil
+	}
+
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("registries: %w", err)
+	}
+
+	var config registriesConfig
+	if err := json.Unmarshal(bts, &config); err != nil {
+		return nil, fmt.Errorf("registries %s: %w", p, err)
+	}
+
+	config.dir = filepath.Dir(p)
+	return &config, nil
+}
+
+// settings returns the settings of host and the key they're configured
+// under, or false if none apply to it
+func (rc *registriesConfig) settings(host string) (string, registrySettings, bool) {
+	if rc == nil {
+		return "", registrySettings{}, false
+	}
+
+	hostname := host
+	if u, err := url.Parse("//" + host); err == nil {
+		hostname = u.Hostname()
+	}
+
+	for _, key := range []string{host, hostname, "*"} {
+		if s, ok := rc.Registries[key]; ok {
+			return key, s, true
+		}
+	}
+
+	return "", registrySettings{}, false
+}
+
+func (rc *registriesConfig) transport(s registrySettings) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	switch s.Proxy {
+	case "":
+	case "direct":
+		t.Proxy = nil
+	default:
+		u, err := url.Parse(s.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	if s.CA != "" || s.SkipVerify {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: s.SkipVerify} //nolint:gosec
+	}
+
+	if s.CA != "" {
+		p := s.CA
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(rc.dir, p)
+		}
+
+		bts, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(bts) {
+			return nil, fmt.Errorf("%s: no PEM encoded certificates", p)
+		}
+
+		t.TLSClientConfig.RootCAs = pool
+	}
+
+	return t, nil
+}
+
+// registryTransport sends requests to registries with the settings of
+// their hosts, including requests redirected to other hosts. The
+// configuration is read again when OLLAMA_REGISTRIES changes.
+type registryTransport struct {
+	mu         sync.Mutex
+	path       string
+	config     *registriesConfig
+	err        error
+	transports map[string]http.RoundTripper
+}
+
+var defaultRegistryTransport registryTransport
+
+func (rt *registryTransport) load() (*registriesConfig, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if p := envconfig.Registries(); rt.transports == nil || p != rt.path {
+		rt.path = p
+		rt.config, rt.err = loadRegistriesConfig(p)
+		rt.transports = make(map[string]http.RoundTripper)
+	}
+
+	return rt.config, rt.err
+}
+
+func (rt *registryTransport) transport(host string) (http.RoundTripper, error) {
+	config, err := rt.load()
+	if err != nil {
+		return nil, err
+	}
+
+	key, s, ok := config.settings(host)
+	if !ok {
+		return http.DefaultTransport, nil
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if t, ok := rt.transports[key]; ok {
+		return t, nil
+	}
+
+	t, err := config.transport(s)
+	if err != nil {
+		return nil, fmt.Errorf("registries: %s: %w", key, err)
+	}
+
+	rt.transports[key] = t
+	return t, nil
+}
+
+func (rt *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t, err := rt.transport(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.RoundTrip(req)
+}
+
+// insecure reports whether host is configured to be connected to over
+// plain HTTP
+func (rt *registryTransport) insecure(host string) bool {
+	config, err := rt.load()
+	if err != nil {
+		return false
+	}
+
+	_, s, _ := config.settings(host)
+	return s.Insecure
+}
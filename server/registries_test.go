@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRegistriesConfig(t *testing.T, registries map[string]registrySettings) {
+	t.Helper()
+
+	bts, err := json.Marshal(registriesConfig{Registries: registries})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(t.TempDir(), "registries.json")
+	if err := os.WriteFile(p, bts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_REGISTRIES", p)
+}
+
+func TestRegistriesConfigSettings(t *testing.T) {
+	config := registriesConfig{Registries: map[string]registrySettings{
+		"mirror.local:5000": {Insecure: true},
+		"mirror.local":      {Proxy: "direct"},
+		"*":                 {Proxy: "http://proxy.local:3128"},
+	}}
+
+	cases := map[string]string{
+		"mirror.local:5000":  "mirror.local:5000",
+		"mirror.local:8080":  "mirror.local",
+		"mirror.local":       "mirror.local",
+		"registry.ollama.ai": "*",
+	}
+
+	for host, expect := range cases {
+		if key, _, ok := config.settings(host); !ok || key != expect {
+			t.Errorf("%s: expected %q, actual %q", host, expect, key)
+		}
+	}
+
+	var empty *registriesConfig
+	if _, _, ok := empty.settings("registry.ollama.ai"); ok {
+		t.Error("expected no settings without a configuration")
+	}
+}
+
+func TestRegistryTransportProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer proxy.Close()
+
+	writeRegistriesConfig(t, map[string]registrySettings{
+		"registry.example.com": {Proxy: proxy.URL},
+	})
+
+	u, err := url.Parse("http://registry.example.com/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := makeRequest(context.Background(), http.MethodGet, u, nil, nil, &registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(proxied) != 1 || proxied[0] != "http://registry.example.com/v2/" {
+		t.Errorf("unexpected proxied requests %v", proxied)
+	}
+}
+
+func TestRegistryTransportTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL + "/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("untrusted", func(t *testing.T) {
+		t.Setenv("OLLAMA_REGISTRIES", "")
+		if _, err := makeRequest(context.Background(), http.MethodGet, u, nil, nil, &registryOptions{}); err == nil {
+			t.Error("expected an unknown certificate authority error")
+		}
+	})
+
+	t.Run("ca", func(t *testing.T) {
+		ca := filepath.Join(t.TempDir(), "ca.pem")
+		if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		writeRegistriesConfig(t, map[string]registrySettings{u.Host: {CA: ca}})
+		resp, err := makeRequest(context.Background(), http.MethodGet, u, nil, nil, &registryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("skip verify", func(t *testing.T) {
+		writeRegistriesConfig(t, map[string]registrySettings{"*": {SkipVerify: true}})
+		resp, err := makeRequest(context.Background(), http.MethodGet, u, nil, nil, &registryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("missing ca", func(t *testing.T) {
+		writeRegistriesConfig(t, map[string]registrySettings{u.Host: {CA: "missing.pem"}})
+		if _, err := makeRequest(context.Background(), http.MethodGet, u, nil, nil, &registryOptions{}); err == nil || !strings.Contains(err.Error(), "missing.pem") {
+			t.Errorf("expected an error reading the CA, actual %v", err)
+		}
+	})
+}
+
+func TestRegistryTransportInsecure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	writeRegistriesConfig(t, map[string]registrySettings{host: {Insecure: true}})
+
+	u, err := url.Parse("https://" + host + "/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := makeRequest(context.Background(), http.MethodGet, u, nil, nil, &registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !defaultRegistryTransport.insecure(host) || defaultRegistryTransport.insecure("registry.ollama.ai") {
+		t.Error("unexpected insecure registries")
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ollama/ollama/api"
+)
+
+// registryCache serves the registry API for models of the default registry
+// so other servers can pull through this one with OLLAMA_REGISTRY_MIRROR.
+// Manifests are fetched from the registry, or served from disk when it
+// can't be reached, and every requested model is pulled in the background.
+// Blobs are served from disk once they're pulled and proxied from the
+// registry until then.
+type registryCache struct {
+	// pulls are the names of the models being pulled
+	pulls sync.Map
+}
+
+func registryError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"errors": []gin.H{{"code": code, "message": message}}})
+}
+
+func (rc *registryCache) modelPath(c *gin.Context) (ModelPath, bool) {
+	mp := ParseModelPath(fmt.Sprintf("%s/%s:%s", c.Param("namespace"), c.Param("model"), cmp.Or(c.Param("tag"), DefaultTag)))
+	if mp.Registry != DefaultRegistry || mp.Validate() != nil {
+		registryError(c, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return mp, false
+	}
+
+	return mp, true
+}
+
+func (rc *registryCache) PingHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (rc *registryCache) ManifestHandler(c *gin.Context) {
+	mp, ok := rc.modelPath(c)
+	if !ok {
+		return
+	}
+
+	requestURL := mp.PullURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
+	headers := make(http.Header)
+	headers.Set("Accept", cmp.Or(c.GetHeader("Accept"), mediaTypeDockerManifest))
+
+	var bts []byte
+	contentType := mediaTypeDockerManifest
+	resp, err := makeRequestWithRetry(c.Request.Context(), http.MethodGet, requestURL, headers, nil, &registryOptions{})
+	if err == nil {
+		defer resp.Body.Close()
+		bts, err = io.ReadAll(resp.Body)
+		contentType = cmp.Or(resp.Header.Get("Content-Type"), contentType)
+	}
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		registryError(c, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	case err != nil:
+		slog.Warn("couldn't fetch manifest from registry, serving cached manifest", "name", mp.GetShortTagname(), "error", err)
+
+		fp, ferr := mp.GetManifestPath()
+		if ferr == nil {
+			bts, ferr = os.ReadFile(fp)
+		}
+
+		var m Manifest
+		if ferr == nil {
+			ferr = json.Unmarshal(bts, &m)
+			contentType = cmp.Or(m.MediaType, contentType)
+		}
+
+		if ferr != nil {
+			registryError(c, http.StatusBadGateway, "UNAVAILABLE", err.Error())
+			return
+		}
+	case strings.HasSuffix(mp.Tag, ".sig"):
+		// cosign signatures are only proxied, they aren't models to cache
+	default:
+		rc.pull(mp)
+	}
+
+	c.Data(http.StatusOK, contentType, bts)
+}
+
+// pull pulls mp in the background unless it's already being pulled
+func (rc *registryCache) pull(mp ModelPath) {
+	name := mp.GetFullTagname()
+	if _, loaded := rc.pulls.LoadOrStore(name, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer rc.pulls.Delete(name)
+
+		//nolint:contextcheck
+		if err := PullModel(context.Background(), name, &registryOptions{}, func(api.ProgressResponse) {}); err != nil {
+			slog.Warn("couldn't cache model", "name", mp.GetShortTagname(), "error", err)
+			return
+		}
+
+		slog.Info("cached model", "name", mp.GetShortTagname())
+	}()
+}
+
+func (rc *registryCache) BlobHandler(c *gin.Context) {
+	mp, ok := rc.modelPath(c)
+	if !ok {
+		return
+	}
+
+	digest := c.Param("digest")
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		registryError(c, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+		return
+	}
+
+	// blobs are only in the blobs directory once they're complete
+	if f, err := os.Open(p); err == nil {
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Docker-Content-Digest", digest)
+		c.Header("Vary", "Accept-Encoding")
+		if c.GetHeader("Range") == "" && acceptsEncoding(c.Request, "zstd") {
+			serveZstd(c, f)
+			return
+		}
+
+		http.ServeContent(c.Writer, c.Request, "", fi.ModTime(), f)
+		return
+	}
+
+	headers := make(http.Header)
+	if r := c.GetHeader("Range"); r != "" {
+		headers.Set("Range", r)
+	}
+
+	requestURL := mp.PullURL().JoinPath("v2", mp.GetNamespaceRepository(), "blobs", digest)
+	resp, err := makeRequestWithRetry(c.Request.Context(), c.Request.Method, requestURL, headers, nil, &registryOptions{})
+	if errors.Is(err, os.ErrNotExist) {
+		registryError(c, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	} else if err != nil {
+		registryError(c, http.StatusBadGateway, "UNAVAILABLE", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, k := range []string{"Content-Length", "Content-Range", "Content-Type", "Accept-Ranges"} {
+		if v := resp.Header.Get(k); v != "" {
+			c.Header(k, v)
+		}
+	}
+
+	c.Header("Docker-Content-Digest", digest)
+	c.Status(resp.StatusCode)
+	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+		// clients close the connection after the headers of some requests
+		slog.Debug("couldn't proxy blob", "digest", digest, "error", err)
+	}
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of r allows
+// the content coding encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(v, ";")
+		if strings.EqualFold(strings.TrimSpace(name), encoding) {
+			q, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(params), "q="), 64)
+			return err != nil || q > 0
+		}
+	}
+
+	return false
+}
+
+// serveZstd sends a blob compressed with zstd as it's read. The digest of
+// the blob is still that of its uncompressed content.
+func serveZstd(c *gin.Context, r io.Reader) {
+	c.Header("Content-Encoding", "zstd")
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	w, err := zstd.NewWriter(c.Writer, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	if err != nil {
+		slog.Warn("couldn't compress blob", "error", err)
+		return
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		slog.Debug("couldn't send compressed blob", "error", err)
+	}
+
+	if err := w.Close(); err != nil {
+		slog.Debug("couldn't send compressed blob", "error", err)
+	}
+}
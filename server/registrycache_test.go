@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestPullURL(t *testing.T) {
+	mp := ParseModelPath("llama3")
+	if u := mp.PullURL(); u.String() != "https://registry.ollama.ai" {
+		t.Errorf("unexpected url %s", u)
+	}
+
+	t.Setenv("OLLAMA_REGISTRY_MIRROR", "http://cache:11434/")
+	if u := mp.PullURL(); u.String() != "http://cache:11434" {
+		t.Errorf("unexpected url %s", u)
+	}
+
+	// models of other registries are pulled from them
+	mp = ParseModelPath("example.com/library/llama3")
+	if u := mp.PullURL(); u.String() != "https://example.com" {
+		t.Errorf("unexpected url %s", u)
+	}
+}
+
+func TestRegistryCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	blobs := make(map[string][]byte)
+	digestOf := func(b []byte) string {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+		blobs[digest] = b
+		return digest
+	}
+
+	config, layer := []byte(`{"model_format":"gguf"}`), bytes.Repeat([]byte("weights "), 1024)
+	manifest, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		Config:        Layer{MediaType: "application/vnd.docker.container.image.v1+json", Digest: digestOf(config), Size: int64(len(config))},
+		Layers:        []Layer{{MediaType: "application/vnd.ollama.image.model", Digest: digestOf(layer), Size: int64(len(layer))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/library/test/manifests/latest":
+			w.Write(manifest)
+		case strings.HasPrefix(r.URL.Path, "/v2/library/test/blobs/"):
+			b, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/library/test/blobs/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer registry.Close()
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRY_CACHE", "1")
+	t.Setenv("OLLAMA_REGISTRY_MIRROR", registry.URL)
+
+	var s Server
+	cache := httptest.NewServer(s.GenerateRoutes())
+	defer cache.Close()
+
+	get := func(path, byteRange string) (int, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, cache.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode, b
+	}
+
+	if code, b := get("/v2/library/test/manifests/latest", ""); code != http.StatusOK || !bytes.Equal(b, manifest) {
+		t.Fatalf("unexpected manifest %d %s", code, b)
+	}
+
+	// blobs are served while the model is pulled
+	layerPath := "/v2/library/test/blobs/" + fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+	if code, b := get(layerPath, "bytes=8-15"); code != http.StatusPartialContent || string(b) != "weights " {
+		t.Fatalf("unexpected blob %d %q", code, b)
+	}
+
+	if code, _ := get("/v2/library/missing/manifests/latest", ""); code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", code)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := ParseNamedManifest(model.ParseName("test")); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("model wasn't cached")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// cached models are served without the registry
+	registry.Close()
+
+	if code, b := get("/v2/library/test/manifests/latest", ""); code != http.StatusOK || !bytes.Equal(b, manifest) {
+		t.Fatalf("unexpected manifest %d %s", code, b)
+	}
+
+	if code, b := get(layerPath, ""); code != http.StatusOK || !bytes.Equal(b, layer) {
+		t.Fatalf("unexpected blob %d", code)
+	}
+
+	if code, b := get(layerPath, "bytes=0-6"); code != http.StatusPartialContent || string(b) != "weights" {
+		t.Fatalf("unexpected blob %d %q", code, b)
+	}
+
+	// blobs are compressed for clients that accept zstd
+	req, err := http.NewRequest(http.MethodGet, cache.URL+layerPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ce := resp.Header.Get("Content-Encoding"); ce != "zstd" {
+		t.Fatalf("expected zstd content encoding, actual %q", ce)
+	}
+
+	d, err := zstd.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if b, err := io.ReadAll(d); err != nil || !bytes.Equal(b, layer) {
+		t.Fatalf("unexpected compressed blob %v", err)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	cases := map[string]bool{
+		"":                   false,
+		"gzip":               false,
+		"zstd":               true,
+		"gzip, ZSTD":         true,
+		"zstd;q=0.5, gzip":   true,
+		"gzip, zstd;q=0":     false,
+		"gzip, zstd ; q=0.0": false,
+	}
+
+	for header, expect := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if actual := acceptsEncoding(r, "zstd"); actual != expect {
+			t.Errorf("%q: expected %t, actual %t", header, expect, actual)
+		}
+	}
+}
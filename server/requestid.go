@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// requestIDHeader is the header with the ID of an API request. Clients may
+// set it to trace their requests, and every response has it.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of request IDs set by clients
+const maxRequestIDLength = 128
+
+// requestIDMiddleware gives every request an ID, the client's if it set a
+// valid one, which logs written with the request's context include
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(llm.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// validRequestID reports whether id is short and only has printable ASCII
+// characters other than spaces, so it can't break up log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestID returns the ID of the request c
+func requestID(c *gin.Context) string {
+	return llm.RequestIDFromContext(c.Request.Context())
+}
+
+// withRequestID adds the ID of the request of ctx, if any, to the data of an
+// event it caused
+func withRequestID(ctx context.Context, data map[string]any) map[string]any {
+	if id := llm.RequestIDFromContext(ctx); id != "" {
+		data["request_id"] = id
+	}
+
+	return data
+}
+
+// requestIDHandler adds the request ID of the context of records to them
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := llm.RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
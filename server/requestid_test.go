@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/llm"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestIDMiddleware())
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, requestID(c))
+	})
+
+	cases := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"none", "", false},
+		{"client", "trace-1234", true},
+		{"spaces", "trace 1234", false},
+		{"newline", "trace\n1234", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(requestIDHeader)
+			require.NotEmpty(t, id)
+			assert.Equal(t, id, w.Body.String())
+			if tt.keep {
+				assert.Equal(t, tt.header, id)
+			} else {
+				assert.NotEqual(t, tt.header, id)
+				assert.True(t, validRequestID(id))
+			}
+		})
+	}
+}
+
+func TestRequestIDHandler(t *testing.T) {
+	var b bytes.Buffer
+	logger := slog.New(requestIDHandler{slog.NewTextHandler(&b, nil)}).With("model", "llama3")
+
+	logger.InfoContext(llm.WithRequestID(context.Background(), "trace-1234"), "loading")
+	assert.Contains(t, b.String(), "model=llama3 request_id=trace-1234")
+
+	b.Reset()
+	logger.Info("loading")
+	assert.NotContains(t, b.String(), "request_id")
+}
+
+func TestWithRequestID(t *testing.T) {
+	ctx := llm.WithRequestID(context.Background(), "trace-1234")
+	assert.Equal(t, map[string]any{"size": 1, "request_id": "trace-1234"}, withRequestID(ctx, map[string]any{"size": 1}))
+	assert.Equal(t, map[string]any{"size": 1}, withRequestID(context.Background(), map[string]any{"size": 1}))
+}
@@ -17,7 +17,9 @@ import (
 	"os/signal"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -42,6 +44,14 @@ var mode string = gin.DebugMode
 type Server struct {
 	addr  net.Addr
 	sched *Scheduler
+	cache *responseCache
+	stats *statsRecorder
+
+	// history is the usage history, if it's enabled
+	history *historyStore
+
+	// apiKeys are the keys requests to addr must have one of, if any
+	apiKeys []string
 }
 
 func init() {
@@ -61,22 +71,72 @@ var (
 	errBadTemplate = errors.New("template error")
 )
 
+// maxBestOf bounds the number of candidates of the best_of option, each of
+// which takes as long to generate as a response
+const maxBestOf = 16
+
 func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options, error) {
 	opts := api.DefaultOptions()
+	if model.ClassifierPath != "" {
+		// the head was trained on a specific pooling of the hidden states
+		classifier, err := loadClassifier(model.ClassifierPath)
+		if err != nil {
+			return api.Options{}, err
+		}
+		opts.Pooling = classifier.Pooling
+	}
+
 	if err := opts.FromMap(model.Options); err != nil {
 		return api.Options{}, err
 	}
 
+	// a server wide context length takes the place of the model's, but not
+	// of one requested
+	if n := envconfig.ContextLength(); n > 0 {
+		opts.NumCtx = int(n)
+	}
+
 	if err := opts.FromMap(requestOpts); err != nil {
 		return api.Options{}, err
 	}
 
+	switch opts.ContextOverflow {
+	case "", llm.ContextOverflowError, llm.ContextOverflowTruncate, llm.ContextOverflowShift, llm.ContextOverflowMiddle:
+	default:
+		return api.Options{}, fmt.Errorf("invalid context_overflow %q", opts.ContextOverflow)
+	}
+
+	switch opts.Pooling {
+	case "", llm.PoolingMean, llm.PoolingCLS, llm.PoolingLast:
+	default:
+		return api.Options{}, fmt.Errorf("invalid pooling %q", opts.Pooling)
+	}
+
+	switch opts.ImageResize {
+	case "", llm.ImageResizeFit, llm.ImageResizeCrop, llm.ImageResizePad, llm.ImageResizeNone:
+	default:
+		return api.Options{}, fmt.Errorf("invalid image_resize %q", opts.ImageResize)
+	}
+
+	if opts.ImageMaxResolution < 0 {
+		return api.Options{}, fmt.Errorf("invalid image_max_resolution %d", opts.ImageMaxResolution)
+	}
+
+	if opts.NumParallel < 0 {
+		return api.Options{}, fmt.Errorf("invalid num_parallel %d", opts.NumParallel)
+	}
+
+	if opts.BestOf < 0 || opts.BestOf > maxBestOf {
+		return api.Options{}, fmt.Errorf("invalid best_of %d, expected at most %d", opts.BestOf, maxBestOf)
+	}
+
 	return opts, nil
 }
 
 // scheduleRunner schedules a runner after validating inputs such as capabilities and model options.
 // It returns the allocated runner, model instance, and consolidated options if successful and error otherwise.
-func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
+func (s *Server) scheduleRunner(c *gin.Context, name string, caps []Capability, requestOpts map[string]any, keepAlive, queueTimeout *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
+	start := time.Now()
 	if name == "" {
 		return nil, nil, nil, fmt.Errorf("model %w", errRequired)
 	}
@@ -95,21 +155,105 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 		return nil, nil, nil, err
 	}
 
-	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, keepAlive)
+	// the model's keep_alive applies to requests without one
+	if keepAlive == nil {
+		keepAlive = model.Config.KeepAlive
+	}
+
+	// the queue timeout also bounds waiting for a slot on the runner once
+	// it's scheduled
+	if queueTimeout != nil && queueTimeout.Duration > 0 {
+		c.Request = c.Request.WithContext(llm.WithQueueDeadline(c.Request.Context(), queueTimeout.Duration, start.Add(queueTimeout.Duration)))
+	}
+
+	runnerCh, errCh := s.sched.GetRunner(c.Request.Context(), model, opts, keepAlive, queueTimeout)
 	var runner *runnerRef
 	select {
 	case runner = <-runnerCh:
 	case err = <-errCh:
+		// the model failed to load or the server was too busy to load it,
+		// which are failures of the model's requests like those of its
+		// completions
+		if sample, ok := failedSample(start, err); ok {
+			s.record(c, model.ShortName, sample)
+		}
 		return nil, nil, nil, err
 	}
 
 	return runner.llama, model, &opts, nil
 }
 
+// checkAdapter checks the adapter a request names can be applied to its
+// model before the model is loaded for it. Errors getting the model itself
+// are left to scheduleRunner.
+func checkAdapter(name, adapter string) error {
+	if adapter == "" {
+		return nil
+	}
+
+	m, err := GetModel(name)
+	if err != nil {
+		return nil
+	}
+
+	_, err = m.adapter(adapter)
+	return err
+}
+
+// completionError handles err ending the completion of request c by the
+// runner r and returns the error sent to the client
+func (s *Server) completionError(c *gin.Context, r llm.LlamaServer, err error) gin.H {
+	h := gin.H{"error": err.Error(), "request_id": requestID(c)}
+
+	var queueErr *llm.QueueTimeoutError
+	if errors.As(err, &queueErr) {
+		h["status"] = http.StatusServiceUnavailable
+		h["retry_after"] = retryAfter(queueErr)
+		h["queue_position"] = queueErr.Position
+	}
+
+	var crash *llm.CrashError
+	if errors.As(err, &crash) {
+		h["exit_status"] = crash.ExitStatus
+		h["crashes"] = s.sched.crashed(r, crash)
+	}
+
+	if errors.Is(err, llm.ErrOutOfMemory) {
+		s.sched.outOfMemory(r)
+	}
+
+	return h
+}
+
+// requestFormat is the format of a generate or chat request, which clients
+// send as a string or, for a JSON Schema, as an object. Schema objects are
+// kept as their JSON text.
+type requestFormat string
+
+func (f *requestFormat) UnmarshalJSON(b []byte) error {
+	if b := bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		*f = requestFormat(b)
+		return nil
+	}
+
+	var s *string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("format must be a string or a JSON Schema object")
+	}
+
+	if s != nil {
+		*f = requestFormat(*s)
+	}
+	return nil
+}
+
 func (s *Server) GenerateHandler(c *gin.Context) {
 	checkpointStart := time.Now()
-	var req api.GenerateRequest
-	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+	var body struct {
+		api.GenerateRequest
+		Format requestFormat `json:"format"`
+	}
+	if err := c.ShouldBindJSON(&body); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
 		return
 	} else if err != nil {
@@ -117,20 +261,46 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
-	if req.Format != "" && req.Format != "json" {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "format must be empty or \"json\""})
+	req := body.GenerateRequest
+	req.Format = string(body.Format)
+
+	if _, err := llm.FormatGrammar(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	} else if req.Raw && (req.Template != "" || req.System != "" || len(req.Context) > 0) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "raw mode does not support template, system, or context"})
 		return
 	}
 
+	if err := checkImages(req.Images); err != nil {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cacheKey string
+	if req.Stream != nil && !*req.Stream && req.Prompt != "" {
+		cacheReq := req
+		cacheReq.KeepAlive, cacheReq.QueueTimeout = nil, nil
+		key, resp, ok := s.cachedResponse(c, req.Model, req.Options, cacheReq)
+		if ok {
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+
+		cacheKey = key
+	}
+
 	caps := []Capability{CapabilityCompletion}
 	if req.Suffix != "" {
 		caps = append(caps, CapabilityInsert)
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), req.Model, caps, req.Options, req.KeepAlive)
+	if err := checkAdapter(req.Model, req.Adapter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c, req.Model, caps, req.Options, req.KeepAlive, req.QueueTimeout)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support generate", req.Model)})
 		return
@@ -139,6 +309,12 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	adapters, err := m.adapterPaths(req.Adapter, req.Options, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	checkpointLoaded := time.Now()
 
 	if req.Prompt == "" {
@@ -156,6 +332,11 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		images[i] = llm.ImageData{ID: i, Data: req.Images[i]}
 	}
 
+	if err := preprocessImages(images, opts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	prompt := req.Prompt
 	if !req.Raw {
 		tmpl := m.Template
@@ -208,19 +389,42 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		prompt = b.String()
 	}
 
-	slog.Debug("generate request", "prompt", prompt, "images", images)
+	if err := checkPromptTokens(c.Request.Context(), r.Tokenize, prompt); err != nil {
+		c.JSON(limitStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if opts.ContextOverflow == llm.ContextOverflowError {
+		tokens, err := r.Tokenize(c.Request.Context(), prompt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		n := len(tokens) + modelImageTokens(m)*len(images)
+
+		if n > opts.NumCtx {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errContextOverflow.Error()})
+			return
+		}
+	}
+
+	slog.DebugContext(c.Request.Context(), "generate request", "prompt", prompt, "images", images)
 
 	ch := make(chan any)
+	timing := requestTiming{start: checkpointStart, scheduled: checkpointLoaded}
 	go func() {
 		// TODO (jmorganca): avoid building the response twice both here and below
 		var sb strings.Builder
 		defer close(ch)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:   prompt,
+			Images:   images,
+			Format:   req.Format,
+			Options:  opts,
+			Adapters: adapters,
 		}, func(cr llm.CompletionResponse) {
+			timing.token(cr)
 			res := api.GenerateResponse{
 				Model:      req.Model,
 				CreatedAt:  time.Now().UTC(),
@@ -230,8 +434,11 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 				Metrics: api.Metrics{
 					PromptEvalCount:    cr.PromptEvalCount,
 					PromptEvalDuration: cr.PromptEvalDuration,
+					PromptCacheCount:   cr.PromptCacheCount,
+					DiscardedCount:     cr.DiscardedCount,
 					EvalCount:          cr.EvalCount,
 					EvalDuration:       cr.EvalDuration,
+					ImageTokens:        cr.ImageTokens,
 				},
 			}
 
@@ -242,6 +449,9 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			if cr.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				res.RequestID = requestID(c)
+				s.record(c, m.ShortName, completionSample(checkpointStart, cr))
+				timing.logIfSlow(c, m.ShortName, req.Options, cr)
 
 				if !req.Raw {
 					tokens, err := r.Tokenize(c.Request.Context(), prompt+sb.String())
@@ -255,7 +465,10 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 			ch <- res
 		}); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			if sample, ok := failedSample(checkpointStart, err); ok {
+				s.record(c, m.ShortName, sample)
+			}
+			ch <- s.completionError(c, r, err)
 		}
 	}()
 
@@ -268,12 +481,11 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 				sb.WriteString(t.Response)
 				r = t
 			case gin.H:
-				msg, ok := t["error"].(string)
-				if !ok {
-					msg = "unexpected error format in response"
+				if _, ok := t["error"].(string); !ok {
+					t = gin.H{"error": "unexpected error format in response"}
 				}
 
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				c.JSON(errorStatus(c, t, http.StatusInternalServerError), t)
 				return
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
@@ -282,6 +494,10 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		}
 
 		r.Response = sb.String()
+		if cacheKey != "" {
+			s.cache.put(cacheKey, r)
+		}
+
 		c.JSON(http.StatusOK, r)
 		return
 	}
@@ -302,35 +518,13 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
-	truncate := true
-
-	if req.Truncate != nil && !*req.Truncate {
-		truncate = false
-	}
-
-	var input []string
-
-	switch i := req.Input.(type) {
-	case string:
-		if len(i) > 0 {
-			input = append(input, i)
-		}
-	case []any:
-		for _, v := range i {
-			if _, ok := v.(string); !ok {
-				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
-				return
-			}
-			input = append(input, v.(string))
-		}
-	default:
-		if req.Input != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
-			return
-		}
+	input, err := inputs(req.Input)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	r, m, opts, err := s.scheduleRunner(c, req.Model, []Capability{}, req.Options, req.KeepAlive, req.QueueTimeout)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -343,38 +537,23 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
-	kvData, err := getKVData(m.ModelPath, false)
-	if err != nil {
+	tokens, truncated, err := truncateInputs(c.Request.Context(), r, m, opts, input, req.Truncate == nil || *req.Truncate)
+	if errors.Is(err, errInputTooLong) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var count int
-	for i, s := range input {
-		tokens, err := r.Tokenize(c.Request.Context(), s)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
-		if len(tokens) > ctxLen {
-			if !truncate {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "input length exceeds maximum context length"})
-				return
-			}
-
-			tokens = tokens[:ctxLen]
-			s, err = r.Detokenize(c.Request.Context(), tokens)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-		}
-
-		count += len(tokens)
+	if err := checkInputTokens(tokens); err != nil {
+		c.JSON(limitStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
-		input[i] = s
+	count := sumTokens(tokens)
+	if len(truncated) > 0 {
+		slog.DebugContext(c.Request.Context(), "truncated embedding inputs", "inputs", truncated)
 	}
 
 	var g errgroup.Group
@@ -391,7 +570,16 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 	}
 
 	if err := g.Wait(); err != nil {
-		slog.Error("embedding generation failed", "error", err)
+		if sample, ok := failedSample(checkpointStart, err); ok {
+			s.record(c, m.ShortName, sample)
+		}
+
+		if errors.As(err, new(*llm.QueueTimeoutError)) {
+			handleScheduleError(c, req.Model, err)
+			return
+		}
+
+		slog.ErrorContext(c.Request.Context(), "embedding generation failed", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("failed to generate embeddings: %v", err)})
 		return
 	}
@@ -402,10 +590,95 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		TotalDuration:   time.Since(checkpointStart),
 		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
 		PromptEvalCount: count,
+		InputTokens:     tokens,
+		Truncated:       truncated,
+		RequestID:       requestID(c),
 	}
+	s.record(c, m.ShortName, requestSample{time: time.Now(), latency: resp.TotalDuration, promptTokens: count})
 	c.JSON(http.StatusOK, resp)
 }
 
+var errInputTooLong = errors.New("input length exceeds maximum context length")
+
+// inputs returns the texts of an input which is a string or a list of strings
+func inputs(input any) ([]string, error) {
+	switch i := input.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if len(i) > 0 {
+			return []string{i}, nil
+		}
+		return nil, nil
+	case []any:
+		texts := make([]string, len(i))
+		for j, v := range i {
+			s, ok := v.(string)
+			if !ok {
+				return nil, errors.New("invalid input type")
+			}
+			texts[j] = s
+		}
+		return texts, nil
+	default:
+		return nil, errors.New("invalid input type")
+	}
+}
+
+// truncateInputs truncates each input to the context length in place and
+// returns the number of tokens of each input, after truncation, and the
+// indexes of the inputs it truncated. It returns errInputTooLong instead if
+// truncate is false.
+func truncateInputs(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, input []string, truncate bool) (tokens []int, truncated []int, _ error) {
+	kvData, err := getKVData(m.ModelPath, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return truncateTokens(ctx, r, min(opts.NumCtx, int(kvData.ContextLength())), input, truncate)
+}
+
+// truncateTokens truncates each input to ctxLen tokens in place, as
+// truncateInputs does
+func truncateTokens(ctx context.Context, r llm.LlamaServer, ctxLen int, input []string, truncate bool) (counts []int, truncated []int, _ error) {
+	counts = make([]int, len(input))
+	for i, s := range input {
+		tokens, err := r.Tokenize(ctx, s)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(tokens) > ctxLen {
+			if !truncate {
+				return nil, nil, fmt.Errorf("%w: input %d has %d tokens, more than the context length of %d", errInputTooLong, i, len(tokens), ctxLen)
+			}
+
+			tokens = tokens[:ctxLen]
+			s, err = r.Detokenize(ctx, tokens)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			truncated = append(truncated, i)
+		}
+
+		counts[i] = len(tokens)
+		input[i] = s
+	}
+
+	return counts, truncated, nil
+}
+
+// sumTokens returns the total of the token counts of inputs
+func sumTokens(counts []int) int {
+	var total int
+	for _, n := range counts {
+		total += n
+	}
+
+	return total
+}
+
 func normalize(vec []float32) []float32 {
 	var sum float32
 	for _, v := range vec {
@@ -433,7 +706,7 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	r, _, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	r, _, _, err := s.scheduleRunner(c, req.Model, []Capability{}, req.Options, req.KeepAlive, req.QueueTimeout)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -445,8 +718,16 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
+	if err := checkPromptTokens(c.Request.Context(), r.Tokenize, req.Prompt); err != nil {
+		c.JSON(limitStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
 	embedding, err := r.Embedding(c.Request.Context(), req.Prompt)
-	if err != nil {
+	if errors.As(err, new(*llm.QueueTimeoutError)) {
+		handleScheduleError(c, req.Model, err)
+		return
+	} else if err != nil {
 		slog.Info(fmt.Sprintf("embedding generation failed: %v", err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate embedding"})
 		return
@@ -494,7 +775,8 @@ func (s *Server) PullModelHandler(c *gin.Context) {
 		}
 
 		regOpts := &registryOptions{
-			Insecure: req.Insecure,
+			Insecure:    req.Insecure,
+			Concurrency: req.Concurrency,
 		}
 
 		ctx, cancel := context.WithCancel(c.Request.Context())
@@ -543,7 +825,8 @@ func (s *Server) PushModelHandler(c *gin.Context) {
 		}
 
 		regOpts := &registryOptions{
-			Insecure: req.Insecure,
+			Insecure:    req.Insecure,
+			Concurrency: req.Concurrency,
 		}
 
 		ctx, cancel := context.WithCancel(c.Request.Context())
@@ -615,12 +898,51 @@ func (s *Server) CreateModelHandler(c *gin.Context) {
 		sr = f
 	}
 
-	f, err := parser.ParseFile(sr)
+	// the Modelfile is kept to check its parameters where they are in it
+	var b bytes.Buffer
+	f, err := parser.ParseFile(io.TeeReader(sr, &b))
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := f.Expand(r.BuildArgs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if r.Strict {
+		if err := parser.CheckParameters(&b, r.BuildArgs); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// only a Modelfile read from the server's disk can reference its files
+	if r.Path != "" {
+		if err := f.ReadFiles(filepath.Dir(r.Path)); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	quantization := cmp.Or(r.Quantize, r.Quantization)
+	if quantization != "" {
+		ft, err := llm.ParseQuantizationType(quantization)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if ft.RequiresImatrix() && r.Imatrix == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("quantizing to %s requires an imatrix", ft)})
+			return
+		}
+	} else if r.Imatrix != "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "imatrix requires quantize"})
+		return
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -631,8 +953,13 @@ func (s *Server) CreateModelHandler(c *gin.Context) {
 		ctx, cancel := context.WithCancel(c.Request.Context())
 		defer cancel()
 
-		quantization := cmp.Or(r.Quantize, r.Quantization)
-		if err := CreateModel(ctx, name, filepath.Dir(r.Path), strings.ToUpper(quantization), f, fn); errors.Is(err, errBadTemplate) {
+		if err := CreateModel(ctx, name, filepath.Dir(r.Path), CreateOptions{
+			Quantization:  strings.ToUpper(quantization),
+			Imatrix:       r.Imatrix,
+			Check:         r.Check,
+			MergeAdapters: r.MergeAdapters,
+			OverrideKV:    r.OverrideKV,
+		}, f, fn); errors.Is(err, errBadTemplate) {
 			ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 		} else if err != nil {
 			ch <- gin.H{"error": err.Error()}
@@ -669,12 +996,12 @@ func (s *Server) DeleteModelHandler(c *gin.Context) {
 		return
 	}
 
-	if err := m.Remove(); err != nil {
+	if err := m.Remove(c.Request.Context()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := m.RemoveLayers(); err != nil {
+	if err := m.RemoveLayers(c.Request.Context()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -714,9 +1041,19 @@ func (s *Server) ShowModelHandler(c *gin.Context) {
 		return
 	}
 
+	if m, err := GetModel(req.Model); err == nil && s.sched != nil {
+		resp.Attention = s.sched.attention(m.ModelPath)
+	}
+
 	c.JSON(http.StatusOK, resp)
 }
 
+// blobReference returns the Modelfile reference to the blob at p, e.g.
+// "@sha256:..." for ".../blobs/sha256-..."
+func blobReference(p string) string {
+	return "@" + strings.Replace(filepath.Base(p), "-", ":", 1)
+}
+
 func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 	m, err := GetModel(req.Model)
 	if err != nil {
@@ -757,11 +1094,21 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		Template:   m.Template.String(),
 		Details:    modelDetails,
 		Messages:   msgs,
+		Tools:      m.Tools,
 		ModifiedAt: manifest.fi.ModTime(),
+		Metadata:   m.Config.Metadata,
 	}
 
 	var params []string
 	cs := 30
+	if m.Config.KeepAlive != nil {
+		params = append(params, fmt.Sprintf("%-*s %#v", cs, "keep_alive", formatDuration(*m.Config.KeepAlive)))
+	}
+
+	if m.Config.LoadTimeout != nil {
+		params = append(params, fmt.Sprintf("%-*s %#v", cs, "load_timeout", formatDuration(*m.Config.LoadTimeout)))
+	}
+
 	for k, v := range m.Options {
 		switch val := v.(type) {
 		case []interface{}:
@@ -781,10 +1128,38 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 	}
 
 	var sb strings.Builder
-	fmt.Fprintln(&sb, "# Modelfile generated by \"ollama show\"")
-	fmt.Fprintln(&sb, "# To build a new Modelfile based on this, replace FROM with:")
-	fmt.Fprintf(&sb, "# FROM %s\n\n", m.ShortName)
-	fmt.Fprint(&sb, m.String())
+	if req.Resolve {
+		// the blobs are referenced by their digests, so the Modelfile creates
+		// the same model on any server which has them
+		resolved := *m
+		resolved.ModelPath = blobReference(m.ModelPath)
+		resolved.AdapterPaths = make([]string, len(m.AdapterPaths))
+		for i, p := range m.AdapterPaths {
+			resolved.AdapterPaths[i] = blobReference(p)
+		}
+
+		resolved.ProjectorPaths = make([]string, len(m.ProjectorPaths))
+		for i, p := range m.ProjectorPaths {
+			resolved.ProjectorPaths[i] = blobReference(p)
+		}
+
+		if m.ClassifierPath != "" {
+			resolved.ClassifierPath = blobReference(m.ClassifierPath)
+		}
+
+		if m.VocoderPath != "" {
+			resolved.VocoderPath = blobReference(m.VocoderPath)
+		}
+
+		fmt.Fprintln(&sb, "# Modelfile generated by \"ollama show --resolve\"")
+		fmt.Fprintf(&sb, "# %s with its blobs referenced by digest\n\n", m.ShortName)
+		fmt.Fprint(&sb, resolved.String())
+	} else {
+		fmt.Fprintln(&sb, "# Modelfile generated by \"ollama show\"")
+		fmt.Fprintln(&sb, "# To build a new Modelfile based on this, replace FROM with:")
+		fmt.Fprintf(&sb, "# FROM %s\n\n", m.ShortName)
+		fmt.Fprint(&sb, m.String())
+	}
 	resp.Modelfile = sb.String()
 
 	kvData, err := getKVData(m.ModelPath, req.Verbose)
@@ -836,8 +1211,19 @@ func (s *Server) ListModelsHandler(c *gin.Context) {
 		return
 	}
 
+	dirs, err := modelsDirs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	models := []api.ListModelResponse{}
+	used := make(map[string]bool)
 	for n, m := range ms {
+		for _, layer := range append(m.Layers, m.Config) {
+			used[strings.ReplaceAll(layer.Digest, ":", "-")] = true
+		}
+
 		var cf ConfigV2
 
 		if m.Config.Digest != "" {
@@ -854,6 +1240,11 @@ func (s *Server) ListModelsHandler(c *gin.Context) {
 			}
 		}
 
+		var location string
+		if len(dirs) > 1 {
+			location = m.dir
+		}
+
 		// tag should never be masked
 		models = append(models, api.ListModelResponse{
 			Model:      n.DisplayShortest(),
@@ -868,6 +1259,8 @@ func (s *Server) ListModelsHandler(c *gin.Context) {
 				ParameterSize:     cf.ModelType,
 				QuantizationLevel: cf.FileType,
 			},
+			Location: location,
+			Metadata: cf.Metadata,
 		})
 	}
 
@@ -876,7 +1269,29 @@ func (s *Server) ListModelsHandler(c *gin.Context) {
 		return cmp.Compare(j.ModifiedAt.Unix(), i.ModifiedAt.Unix())
 	})
 
-	c.JSON(http.StatusOK, api.ListResponse{Models: models})
+	usage, err := diskUsage(used)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ListResponse{Models: models, DiskUsage: usage})
+}
+
+func (s *Server) GCHandler(c *gin.Context) {
+	var r api.GCRequest
+	if err := c.ShouldBindJSON(&r); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := collectGarbage(r.DryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 func (s *Server) CopyModelHandler(c *gin.Context) {
@@ -1028,7 +1443,9 @@ func allowedHost(host string) bool {
 
 func allowedHostsMiddleware(addr net.Addr) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if addr == nil {
+		// unix sockets can't be reached from browsers, so aren't open to
+		// DNS rebinding
+		if addr == nil || addr.Network() == "unix" {
 			c.Next()
 			return
 		}
@@ -1064,11 +1481,28 @@ func allowedHostsMiddleware(addr net.Addr) gin.HandlerFunc {
 	}
 }
 
+// clientMiddleware tags the request context with the client making the request
+// so runners can share their slots fairly between clients. Clients are
+// identified by their API key if one was provided, otherwise by the address
+// they connect from, since forwarding headers are set by clients themselves.
+func clientMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := c.RemoteIP()
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && token != "" {
+			client = "key:" + token
+		}
+
+		c.Request = c.Request.WithContext(llm.WithClient(c.Request.Context(), client))
+		c.Next()
+	}
+}
+
 func (s *Server) GenerateRoutes() http.Handler {
 	config := cors.DefaultConfig()
 	config.AllowWildcard = true
 	config.AllowBrowserExtensions = true
-	config.AllowHeaders = []string{"Authorization", "Content-Type", "User-Agent", "Accept", "X-Requested-With"}
+	config.AllowHeaders = []string{"Authorization", "Content-Type", "User-Agent", "Accept", "X-Requested-With", requestIDHeader}
+	config.ExposeHeaders = []string{requestIDHeader}
 	openAIProperties := []string{"lang", "package-version", "os", "arch", "runtime", "runtime-version", "async"}
 	for _, prop := range openAIProperties {
 		config.AllowHeaders = append(config.AllowHeaders, "x-stainless-"+prop)
@@ -1076,24 +1510,42 @@ func (s *Server) GenerateRoutes() http.Handler {
 	config.AllowOrigins = envconfig.Origins()
 
 	r := gin.Default()
+	// clients can set X-Forwarded-For themselves, so c.ClientIP is the address
+	// requests come from
+	r.ForwardedByClientIP = false
+
 	r.Use(
+		requestIDMiddleware(),
 		cors.New(config),
 		allowedHostsMiddleware(s.addr),
+		apiKeysMiddleware(s.apiKeys),
+		requestSizeMiddleware(),
+		clientMiddleware(),
+		webhookMiddleware(),
 	)
 
 	r.POST("/api/pull", s.PullModelHandler)
 	r.POST("/api/generate", s.GenerateHandler)
 	r.POST("/api/chat", s.ChatHandler)
 	r.POST("/api/embed", s.EmbedHandler)
+	r.POST("/api/tokenize", s.TokenizeHandler)
+	r.POST("/api/classify", s.ClassifyHandler)
+	r.POST("/api/rerank", s.RerankHandler)
+	r.POST("/api/speech", s.SpeechHandler)
 	r.POST("/api/embeddings", s.EmbeddingsHandler)
 	r.POST("/api/create", s.CreateModelHandler)
 	r.POST("/api/push", s.PushModelHandler)
 	r.POST("/api/copy", s.CopyModelHandler)
 	r.DELETE("/api/delete", s.DeleteModelHandler)
 	r.POST("/api/show", s.ShowModelHandler)
+	r.POST("/api/extract", s.ExtractHandler)
 	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
 	r.GET("/api/ps", s.ProcessHandler)
+	r.GET("/api/stats", s.StatsHandler)
+	r.GET("/api/history", s.HistoryHandler)
+	r.POST("/api/gc", s.GCHandler)
+	r.GET("/api/events", s.EventsHandler)
 
 	// Compatibility endpoints
 	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
@@ -1113,10 +1565,24 @@ func (s *Server) GenerateRoutes() http.Handler {
 		})
 	}
 
+	if envconfig.RegistryCache() {
+		var rc registryCache
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			r.Handle(method, "/v2/", rc.PingHandler)
+			r.Handle(method, "/v2/:namespace/:model/manifests/:tag", rc.ManifestHandler)
+			r.Handle(method, "/v2/:namespace/:model/blobs/:digest", rc.BlobHandler)
+		}
+	}
+
 	return r
 }
 
-func Serve(ln net.Listener) error {
+// Serve serves the API on every listener of lns, such as those of Listen
+func Serve(lns ...net.Listener) error {
+	if len(lns) == 0 {
+		return errors.New("no listeners")
+	}
+
 	level := slog.LevelInfo
 	if envconfig.Debug() {
 		level = slog.LevelDebug
@@ -1136,83 +1602,26 @@ func Serve(ln net.Listener) error {
 		},
 	})
 
-	slog.SetDefault(slog.New(handler))
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
 
-	blobsDir, err := GetBlobsPath("")
+	i, err := New(Config{Listeners: lns})
 	if err != nil {
 		return err
 	}
-	if err := fixBlobs(blobsDir); err != nil {
-		return err
-	}
-
-	if !envconfig.NoPrune() {
-		// clean up unused layers and manifests
-		if err := PruneLayers(); err != nil {
-			return err
-		}
-
-		manifestsPath, err := GetManifestPath()
-		if err != nil {
-			return err
-		}
-
-		if err := PruneDirectory(manifestsPath); err != nil {
-			return err
-		}
-	}
-
-	ctx, done := context.WithCancel(context.Background())
-	schedCtx, schedDone := context.WithCancel(ctx)
-	sched := InitScheduler(schedCtx)
-	s := &Server{addr: ln.Addr(), sched: sched}
-
-	http.Handle("/", s.GenerateRoutes())
-
-	slog.Info(fmt.Sprintf("Listening on %s (version %s)", ln.Addr(), version.Version))
-	srvr := &http.Server{
-		// Use http.DefaultServeMux so we get net/http/pprof for
-		// free.
-		//
-		// TODO(bmizerany): Decide if we want to make this
-		// configurable so it is not exposed by default, or allow
-		// users to bind it to a different port. This was a quick
-		// and easy way to get pprof, but it may not be the best
-		// way.
-		Handler: nil,
-	}
 
 	// listen for a ctrl+c and stop any loaded llm
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-signals
-		srvr.Close()
-		schedDone()
-		sched.unloadAllRunners()
-		gpu.Cleanup()
-		done()
+		i.Stop()
 	}()
 
-	if err := llm.Init(); err != nil {
-		return fmt.Errorf("unable to initialize llm library %w", err)
-	}
-
-	s.sched.Run(schedCtx)
-
-	// At startup we retrieve GPU information so we can get log messages before loading a model
-	// This will log warnings to the log in case we have problems with detected GPUs
-	gpus := gpu.GetGPUInfo()
-	gpus.LogDetails()
-
-	err = srvr.Serve(ln)
-	// If server is closed from the signal handler, wait for the ctx to be done
-	// otherwise error out quickly
-	if !errors.Is(err, http.ErrServerClosed) {
+	if err := i.Start(); err != nil {
 		return err
 	}
-	<-ctx.Done()
-	return nil
+
+	return i.Wait()
 }
 
 func waitForStream(c *gin.Context, ch chan interface{}) {
@@ -1246,12 +1655,22 @@ func waitForStream(c *gin.Context, ch chan interface{}) {
 
 func streamResponse(c *gin.Context, ch chan any) {
 	c.Header("Content-Type", "application/x-ndjson")
+	first := true
 	c.Stream(func(w io.Writer) bool {
 		val, ok := <-ch
 		if !ok {
 			return false
 		}
 
+		// an error before anything is streamed, like a queue timeout, sets
+		// the status of the response
+		if h, ok := val.(gin.H); ok && first {
+			if status := errorStatus(c, h, 0); status != 0 {
+				c.Status(status)
+			}
+		}
+		first = false
+
 		bts, err := json.Marshal(val)
 		if err != nil {
 			slog.Info(fmt.Sprintf("streamResponse: json.Marshal failed with %s", err))
@@ -1271,8 +1690,21 @@ func streamResponse(c *gin.Context, ch chan any) {
 
 func (s *Server) ProcessHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
+	runners := s.sched.loadedRunners()
+
+	// the current memory and load of the GPUs models are loaded on
+	var gpus gpu.GpuInfoList
+	var stats []gpu.GpuStats
+	for _, v := range runners {
+		if len(v.gpus) > 0 && v.gpus[0].Library != "cpu" {
+			gpus = s.sched.getGpuFn()
+			stats = s.sched.getGpuStatsFn(gpus)
+			break
+		}
+	}
 
-	for _, v := range s.sched.loaded {
+	slots := processSlots(c.Request.Context(), runners)
+	for i, v := range runners {
 		model := v.model
 		modelDetails := api.ModelDetails{
 			Format:            model.Config.ModelFormat,
@@ -1290,6 +1722,24 @@ func (s *Server) ProcessHandler(c *gin.Context) {
 			Digest:    model.Digest,
 			Details:   modelDetails,
 			ExpiresAt: v.expiresAt,
+
+			KVCacheType: v.kvCacheType,
+			SizeKVCache: int64(v.estimatedKVCache),
+
+			NumBatch:   v.numBatch,
+			NumUBatch:  v.numUBatch,
+			CUDAGraphs: v.cudaGraphs,
+
+			UseMMap:   v.mmap,
+			UseMLock:  v.mlock,
+			UsePinned: v.pinned,
+
+			Crashes: s.sched.crashCount(v.modelPath),
+
+			GPUs: processGPUs(v.gpus, gpus, stats),
+
+			Slots:   slots[i],
+			Waiting: v.llama.Waiting(),
 		}
 		// The scheduler waits to set expiresAt, so if a model is loading it's
 		// possible that it will be set to the unix epoch. For those cases, just
@@ -1310,11 +1760,102 @@ func (s *Server) ProcessHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, api.ProcessResponse{Models: models})
 }
 
+// slotsTimeout is how long ps waits for runners to report their slots, which
+// they only do between batches
+const slotsTimeout = time.Second
+
+// processSlots describes what the parallel slots of each of runners are
+// doing. The runners are asked concurrently, and those which are loading or
+// don't respond within slotsTimeout have no slots.
+func processSlots(ctx context.Context, runners []*runnerRef) [][]api.ProcessSlotResponse {
+	ctx, cancel := context.WithTimeout(ctx, slotsTimeout)
+	defer cancel()
+
+	resp := make([][]api.ProcessSlotResponse, len(runners))
+
+	var wg sync.WaitGroup
+	for i, runner := range runners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp[i] = runnerSlots(ctx, runner)
+		}()
+	}
+
+	wg.Wait()
+	return resp
+}
+
+// runnerSlots describes what the parallel slots of runner are doing, or
+// nothing if it's loading or doesn't respond before ctx is done
+func runnerSlots(ctx context.Context, runner *runnerRef) []api.ProcessSlotResponse {
+	if runner.loading {
+		return nil
+	}
+
+	slots, err := runner.llama.Slots(ctx)
+	if err != nil {
+		slog.DebugContext(ctx, "failed to get runner slots", "model", runner.modelPath, "error", err)
+		return nil
+	}
+
+	resp := make([]api.ProcessSlotResponse, len(slots))
+	for i, slot := range slots {
+		resp[i] = api.ProcessSlotResponse{
+			ID:              slot.ID,
+			State:           slot.State,
+			RequestID:       slot.RequestID,
+			PromptTokens:    slot.PromptTokens,
+			ContextTokens:   slot.ContextTokens,
+			GeneratedTokens: slot.GeneratedTokens,
+			Age:             slot.Age,
+		}
+	}
+
+	return resp
+}
+
+// processGPUs describes the GPUs in loaded, which a model was loaded on, with
+// their memory and stats from current, the GPUs as they are now
+func processGPUs(loaded, current gpu.GpuInfoList, stats []gpu.GpuStats) []api.ProcessGPUResponse {
+	var resp []api.ProcessGPUResponse
+	for _, g := range loaded {
+		if g.Library == "cpu" {
+			continue
+		}
+
+		r := api.ProcessGPUResponse{
+			ID:          g.ID,
+			Library:     g.Library,
+			Name:        g.Name,
+			FreeMemory:  int64(g.FreeMemory),
+			TotalMemory: int64(g.TotalMemory),
+		}
+
+		if i := slices.IndexFunc(current, func(c gpu.GpuInfo) bool { return c.Library == g.Library && c.ID == g.ID }); i >= 0 {
+			r.FreeMemory = int64(current[i].FreeMemory)
+			r.TotalMemory = int64(current[i].TotalMemory)
+			if i < len(stats) {
+				r.Utilization = stats[i].Utilization
+				r.Temperature = stats[i].Temperature
+				r.Power = stats[i].Power
+			}
+		}
+
+		resp = append(resp, r)
+	}
+
+	return resp
+}
+
 func (s *Server) ChatHandler(c *gin.Context) {
 	checkpointStart := time.Now()
 
-	var req api.ChatRequest
-	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+	var body struct {
+		api.ChatRequest
+		Format requestFormat `json:"format"`
+	}
+	if err := c.ShouldBindJSON(&body); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
 		return
 	} else if err != nil {
@@ -1322,12 +1863,43 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	req := body.ChatRequest
+	req.Format = string(body.Format)
+
+	if _, err := llm.FormatGrammar(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkMessageImages(req.Messages); err != nil {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cacheKey string
+	if req.Stream != nil && !*req.Stream && len(req.Messages) > 0 {
+		cacheReq := req
+		cacheReq.KeepAlive, cacheReq.QueueTimeout = nil, nil
+		key, resp, ok := s.cachedResponse(c, req.Model, req.Options, cacheReq)
+		if ok {
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+
+		cacheKey = key
+	}
+
 	caps := []Capability{CapabilityCompletion}
 	if len(req.Tools) > 0 {
 		caps = append(caps, CapabilityTools)
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), req.Model, caps, req.Options, req.KeepAlive)
+	if err := checkAdapter(req.Model, req.Adapter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c, req.Model, caps, req.Options, req.KeepAlive, req.QueueTimeout)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support chat", req.Model)})
 		return
@@ -1336,6 +1908,18 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	// the model's tools are offered unless the request has its own
+	tools := req.Tools
+	if len(tools) == 0 {
+		tools = m.Tools
+	}
+
+	adapters, err := m.adapterPaths(req.Adapter, req.Options, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	checkpointLoaded := time.Now()
 
 	if len(req.Messages) == 0 {
@@ -1354,23 +1938,39 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
 	}
 
-	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
-	if err != nil {
+	prompt, images, discarded, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, tools)
+	if errors.Is(err, errContextOverflow) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	slog.Debug("chat request", "images", len(images), "prompt", prompt)
+	if err := checkPromptTokens(c.Request.Context(), r.Tokenize, prompt); err != nil {
+		c.JSON(limitStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := preprocessImages(images, opts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.DebugContext(c.Request.Context(), "chat request", "images", len(images), "prompt", prompt)
 
 	ch := make(chan any)
+	timing := requestTiming{start: checkpointStart, scheduled: checkpointLoaded}
 	go func() {
 		defer close(ch)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:   prompt,
+			Images:   images,
+			Format:   req.Format,
+			Options:  opts,
+			Adapters: adapters,
 		}, func(r llm.CompletionResponse) {
+			timing.token(r)
 			res := api.ChatResponse{
 				Model:      req.Model,
 				CreatedAt:  time.Now().UTC(),
@@ -1380,19 +1980,29 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				Metrics: api.Metrics{
 					PromptEvalCount:    r.PromptEvalCount,
 					PromptEvalDuration: r.PromptEvalDuration,
+					PromptCacheCount:   r.PromptCacheCount,
+					DiscardedCount:     r.DiscardedCount,
 					EvalCount:          r.EvalCount,
 					EvalDuration:       r.EvalDuration,
+					ImageTokens:        r.ImageTokens,
 				},
 			}
 
 			if r.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				res.DiscardedCount += discarded
+				res.RequestID = requestID(c)
+				s.record(c, m.ShortName, completionSample(checkpointStart, r))
+				timing.logIfSlow(c, m.ShortName, req.Options, r)
 			}
 
 			ch <- res
 		}); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			if sample, ok := failedSample(checkpointStart, err); ok {
+				s.record(c, m.ShortName, sample)
+			}
+			ch <- s.completionError(c, r, err)
 		}
 	}()
 
@@ -1405,12 +2015,11 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				sb.WriteString(t.Message.Content)
 				resp = t
 			case gin.H:
-				msg, ok := t["error"].(string)
-				if !ok {
-					msg = "unexpected error format in response"
+				if _, ok := t["error"].(string); !ok {
+					t = gin.H{"error": "unexpected error format in response"}
 				}
 
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				c.JSON(errorStatus(c, t, http.StatusInternalServerError), t)
 				return
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
@@ -1420,13 +2029,17 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 		resp.Message.Content = sb.String()
 
-		if len(req.Tools) > 0 {
+		if len(tools) > 0 {
 			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
 				resp.Message.ToolCalls = toolCalls
 				resp.Message.Content = ""
 			}
 		}
 
+		if cacheKey != "" {
+			s.cache.put(cacheKey, resp)
+		}
+
 		c.JSON(http.StatusOK, resp)
 		return
 	}
@@ -1434,7 +2047,32 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	streamResponse(c, ch)
 }
 
+// retryAfter is the seconds a client timed out in the queue should wait to
+// retry
+func retryAfter(err *llm.QueueTimeoutError) int {
+	return int(math.Ceil(err.Timeout.Seconds()))
+}
+
+// errorStatus returns the status of the error h sent by a completion, or
+// fallback if it has none, and sets the headers it asks for. They're removed
+// from h, which is the body of the response.
+func errorStatus(c *gin.Context, h gin.H, fallback int) int {
+	status, ok := h["status"].(int)
+	if !ok {
+		return fallback
+	}
+	delete(h, "status")
+
+	if seconds, ok := h["retry_after"].(int); ok {
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		delete(h, "retry_after")
+	}
+
+	return status
+}
+
 func handleScheduleError(c *gin.Context, name string, err error) {
+	var queueErr *llm.QueueTimeoutError
 	switch {
 	case errors.Is(err, errCapabilities), errors.Is(err, errRequired):
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -1442,6 +2080,9 @@ func handleScheduleError(c *gin.Context, name string, err error) {
 		c.JSON(499, gin.H{"error": "request canceled"})
 	case errors.Is(err, ErrMaxQueue):
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.As(err, &queueErr):
+		c.Header("Retry-After", strconv.Itoa(retryAfter(queueErr)))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "queue_position": queueErr.Position})
 	case errors.Is(err, os.ErrNotExist):
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found, try pulling it first", name)})
 	default:
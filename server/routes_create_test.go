@@ -3,20 +3,27 @@ package server
 import (
 	"bytes"
 	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
 )
 
 var stream bool = false
@@ -615,4 +622,927 @@ func TestCreateDetectTemplate(t *testing.T) {
 			filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
 		})
 	})
+
+	t.Run("converted", func(t *testing.T) {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name: "converted",
+			Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+				"tokenizer.chat_template":      "{%- for message in messages %}\n    {%- if message['role'] == 'system' %}\n        {{- '### Instructions for the assistant, to be followed strictly:\\n' + message['content'] + '\\n\\n' }}\n    {%- elif message['role'] == 'user' %}\n        {{- '### Question from the user:\\n' + message['content'] + '\\n\\n' }}\n    {%- else %}\n        {{- '### Answer from the assistant:\\n' + message['content'] + eos_token + '\\n\\n' }}\n    {%- endif %}\n{%- endfor %}\n{{- '### Answer from the assistant:\\n' }}",
+				"tokenizer.ggml.tokens":        []string{"<unk>", "<s>", "</s>"},
+				"tokenizer.ggml.eos_token_id":  uint32(2),
+				"tokenizer.ggml.bos_token_id":  uint32(1),
+				"tokenizer.ggml.add_bos_token": true,
+			}, nil)),
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("converted")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: []api.Message{{Role: "user", Content: "Hello"}, {Role: "assistant", Content: "Hi"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := "### Question from the user:\nHello\n\n### Answer from the assistant:\nHi</s>\n\n### Answer from the assistant:\n"; b.String() != expect {
+			t.Errorf("expected %q, actual %q", expect, b.String())
+		}
+	})
+
+	t.Run("jinja template", func(t *testing.T) {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      "jinja",
+			Modelfile: fmt.Sprintf("FROM %s\nTEMPLATE \"\"\"{%% for message in messages %%}{{ message.role }}: {{ message.content }}\n{%% endfor %%}assistant:\"\"\"", createBinFile(t, nil, nil)),
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("jinja")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := "{{ range $i, $message := $.Messages }}{{ $message.Role }}: {{ $message.Content }}\n{{ end }}assistant:"; m.Template.String() != expect {
+			t.Errorf("expected %q, actual %q", expect, m.Template.String())
+		}
+	})
+}
+
+func TestCreateStackedAdapters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	base := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "base",
+		Modelfile: fmt.Sprintf("FROM %s", base),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name: "persona",
+		Modelfile: fmt.Sprintf("FROM %s\nADAPTER %s\nADAPTER %s\nPARAMETER adapter_scale 1\nPARAMETER adapter_scale 0.5",
+			base,
+			createBinFile(t, map[string]any{"general.type": "adapter", "general.name": "a"}, nil),
+			createBinFile(t, map[string]any{"general.type": "adapter", "general.name": "b"}, nil),
+		),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err := GetModel("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := api.DefaultOptions()
+	adapters, err := m.adapterPaths("persona", nil, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(adapters) != 2 {
+		t.Fatalf("expected 2 adapters, actual %d", len(adapters))
+	}
+
+	if !slices.Equal(opts.AdapterScale, []float32{1, 0.5}) {
+		t.Errorf("expected adapter scales [1 0.5], actual %v", opts.AdapterScale)
+	}
+
+	// scales in the request override the ones of the adapter model
+	if _, err := m.adapterPaths("persona", map[string]any{"adapter_scale": []any{0.2}}, &opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(opts.AdapterScale, []float32{0.2}) {
+		t.Errorf("expected adapter scales [0.2], actual %v", opts.AdapterScale)
+	}
+
+	if _, err := m.adapterPaths("base", nil, &opts); !errors.Is(err, errAdapter) {
+		t.Errorf("expected errAdapter, actual %v", err)
+	}
+}
+
+func TestCreateKeepAlive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nPARAMETER keep_alive 30m\nPARAMETER temperature 1", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.KeepAlive == nil || m.Config.KeepAlive.Duration != 30*time.Minute {
+		t.Errorf("expected keep_alive 30m, actual %v", m.Config.KeepAlive)
+	}
+
+	// keep_alive is kept in the config, not the parameters
+	if _, ok := m.Options["keep_alive"]; ok {
+		t.Errorf("unexpected keep_alive parameter %v", m.Options)
+	}
+
+	if !strings.Contains(m.String(), "PARAMETER keep_alive 30m0s") {
+		t.Errorf("expected keep_alive in modelfile %s", m.String())
+	}
+
+	// models created from it inherit keep_alive unless they set it
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test2",
+		Modelfile: "FROM test",
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err = GetModel("test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.KeepAlive == nil || m.Config.KeepAlive.Duration != 30*time.Minute {
+		t.Errorf("expected keep_alive 30m, actual %v", m.Config.KeepAlive)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test3",
+		Modelfile: "FROM test\nPARAMETER keep_alive -1",
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err = GetModel("test3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.KeepAlive == nil || formatDuration(*m.Config.KeepAlive) != "-1" {
+		t.Errorf("expected keep_alive -1, actual %v", m.Config.KeepAlive)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test4",
+		Modelfile: "FROM test\nPARAMETER keep_alive forever",
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "invalid keep_alive value forever") {
+		t.Fatalf("expected invalid keep_alive error, actual %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateLoadTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nPARAMETER load_timeout 15m", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.LoadTimeout == nil || m.Config.LoadTimeout.Duration != 15*time.Minute {
+		t.Errorf("expected load_timeout 15m, actual %v", m.Config.LoadTimeout)
+	}
+
+	if _, ok := m.Options["load_timeout"]; ok {
+		t.Errorf("unexpected load_timeout parameter %v", m.Options)
+	}
+
+	if !strings.Contains(m.String(), "PARAMETER load_timeout 15m0s") {
+		t.Errorf("expected load_timeout in modelfile %s", m.String())
+	}
+
+	// models created from it inherit load_timeout along with keep_alive
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test2",
+		Modelfile: "FROM test\nPARAMETER keep_alive 1h",
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err = GetModel("test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.LoadTimeout == nil || m.Config.LoadTimeout.Duration != 15*time.Minute {
+		t.Errorf("expected load_timeout 15m, actual %v", m.Config.LoadTimeout)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test3",
+		Modelfile: "FROM test\nPARAMETER load_timeout soon",
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "invalid load_timeout value soon") {
+		t.Fatalf("expected invalid load_timeout error, actual %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateBuildArgs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	modelfile := fmt.Sprintf("ARG PERSONA=\"a helpful assistant\"\nFROM %s\nSYSTEM You are ${PERSONA}.", createBinFile(t, nil, nil))
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: modelfile,
+		BuildArgs: map[string]string{"PERSONA": "a pirate"},
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.System != "You are a pirate." {
+		t.Errorf("unexpected system %q", m.System)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: modelfile,
+		BuildArgs: map[string]string{"LANGUAGE": "English"},
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+}
+
+func TestCreateAdapterScales(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	base := createBinFile(t, map[string]any{"general.architecture": "llama"}, nil)
+	var n int
+	adapter := func(arch string) string {
+		n++
+		return createBinFile(t, map[string]any{"general.type": "adapter", "general.architecture": arch, "general.name": fmt.Sprintf("adapter%d", n)}, nil)
+	}
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nADAPTER %s 0.5\nADAPTER %s", base, adapter("llama"), adapter("llama")),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.AdapterPaths) != 2 {
+		t.Fatalf("expected 2 adapters, actual %d", len(m.AdapterPaths))
+	}
+
+	if scales := fmt.Sprint(m.Options["adapter_scale"]); scales != "[0.5 1]" {
+		t.Errorf("expected adapter scales [0.5 1], actual %s", scales)
+	}
+
+	cases := map[string]string{
+		"architecture": fmt.Sprintf("FROM %s\nADAPTER %s", base, adapter("gemma")),
+		"not adapter":  fmt.Sprintf("FROM %s\nADAPTER %s", base, createBinFile(t, map[string]any{"general.architecture": "llama"}, nil)),
+		"both scales":  fmt.Sprintf("FROM %s\nADAPTER %s 0.5\nPARAMETER adapter_scale 1", base, adapter("llama")),
+	}
+
+	for name, modelfile := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+				Name:      "invalid",
+				Modelfile: modelfile,
+				Stream:    &stream,
+			})
+
+			if !strings.Contains(w.Body.String(), errAdapter.Error()) {
+				t.Errorf("expected %q, actual %s", errAdapter, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateMessageImages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	image := filepath.Join(t.TempDir(), "cat.png")
+	if err := os.WriteFile(image, []byte("cat"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("dog")))
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(blob, []byte("dog"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nMESSAGE user \"What is this? %s\"\nMESSAGE assistant \"A cat.\"\nMESSAGE user \"And this? @%s\"\nMESSAGE user \"Is /missing.png there?\"", createBinFile(t, nil, nil), image, digest),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []api.Message{
+		{Role: "user", Content: "What is this?", Images: []api.ImageData{[]byte("cat")}},
+		{Role: "assistant", Content: "A cat."},
+		{Role: "user", Content: "And this?", Images: []api.ImageData{[]byte("dog")}},
+		{Role: "user", Content: "Is /missing.png there?"},
+	}
+
+	if !reflect.DeepEqual(m.Messages, expect) {
+		t.Errorf("expected %v, actual %v", expect, m.Messages)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "missing",
+		Modelfile: fmt.Sprintf("FROM test\nMESSAGE user \"What is this? @sha256:%x\"", sha256.Sum256([]byte("bird"))),
+		Stream:    &stream,
+	})
+
+	if w.Code == http.StatusOK {
+		t.Error("expected an error for a missing blob")
+	}
+}
+
+func TestCreateMetadata(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nMETADATA description \"A test model.\"\nMETADATA author Ollama\nMETADATA tags test, small\nMETADATA tags small, example", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := &api.ModelMetadata{Description: "A test model.", Author: "Ollama", Tags: []string{"test", "small", "example"}}
+	if !reflect.DeepEqual(m.Config.Metadata, expect) {
+		t.Errorf("expected %v, actual %v", expect, m.Config.Metadata)
+	}
+
+	if !strings.Contains(m.String(), "METADATA tags test, small, example") {
+		t.Errorf("expected modelfile to have tags, actual %s", m.String())
+	}
+
+	// metadata isn't inherited from the model it's created from
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "child",
+		Modelfile: "FROM test",
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err = GetModel("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.Metadata != nil {
+		t.Errorf("expected no metadata, actual %v", m.Config.Metadata)
+	}
+}
+
+func TestCreateFileReferences(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "LICENSE.txt"), []byte("MIT License\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "system.md"), []byte("You are Mario."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	modelfile := filepath.Join(dir, "Modelfile")
+	if err := os.WriteFile(modelfile, []byte(fmt.Sprintf("FROM %s\nLICENSE @./LICENSE.txt\nSYSTEM @./system.md", createBinFile(t, nil, nil))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:   "test",
+		Path:   modelfile,
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(m.License, []string{"MIT License"}) {
+		t.Errorf("expected license %q, actual %q", "MIT License", m.License)
+	}
+
+	if m.System != "You are Mario." {
+		t.Errorf("expected system %q, actual %q", "You are Mario.", m.System)
+	}
+
+	// a Modelfile sent in the request can't reference the server's files
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "inline",
+		Modelfile: fmt.Sprintf("FROM %s\nSYSTEM @%s", createBinFile(t, nil, nil), filepath.Join(dir, "system.md")),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err = GetModel("inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "@" + filepath.Join(dir, "system.md"); m.System != expect {
+		t.Errorf("expected system %q, actual %q", expect, m.System)
+	}
+}
+
+func TestCreateTools(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	tools := `[{"type": "function", "function": {"name": "get_weather", "description": "Get the weather"}}]`
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nTEMPLATE \"{{ .Tools }} {{ .Prompt }}\"\nTOOLS %s", createBinFile(t, nil, nil), tools),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Tools) != 1 || m.Tools[0].Function.Name != "get_weather" {
+		t.Errorf("expected get_weather tool, actual %v", m.Tools)
+	}
+
+	if !strings.Contains(m.String(), "TOOLS "+m.Tools.String()) {
+		t.Errorf("expected modelfile to have tools, actual %s", m.String())
+	}
+
+	t.Run("inherited", func(t *testing.T) {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      "child",
+			Modelfile: "FROM test\nSYSTEM You are a weatherman.",
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("child")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(m.Tools) != 1 || m.Tools[0].Function.Name != "get_weather" {
+			t.Errorf("expected get_weather tool, actual %v", m.Tools)
+		}
+	})
+
+	t.Run("template without tools", func(t *testing.T) {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      "child",
+			Modelfile: "FROM test\nTEMPLATE {{ .Prompt }}",
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      "child",
+			Modelfile: "FROM test\nTOOLS get_weather",
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected status code 500, actual %d", w.Code)
+		}
+	})
+}
+
+func TestCreateStrict(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	modelfile := fmt.Sprintf("FROM %s\nPARAMETER num_ctx -1", createBinFile(t, nil, nil))
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: modelfile,
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "strict",
+		Modelfile: modelfile,
+		Stream:    &stream,
+		Strict:    true,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "line 2, column 19: PARAMETER num_ctx: -1 is less than 1"; resp["error"] != expect {
+		t.Errorf("expected error %q, actual %q", expect, resp["error"])
+	}
+}
+
+func TestCreateImatrix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Imatrix:   "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "imatrix requires quantize"; resp["error"] != expect {
+		t.Errorf("expected error %q, actual %q", expect, resp["error"])
+	}
+}
+
+func TestCreateQuantizeTypes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	modelfile := fmt.Sprintf("FROM %s", createBinFile(t, nil, nil))
+
+	cases := map[string]string{
+		"q4_k_x": "unsupported quantization type q4_k_x",
+		"iq1_s":  "quantizing to IQ1_S requires an imatrix",
+	}
+
+	for quantize, expect := range cases {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      "test",
+			Modelfile: modelfile,
+			Quantize:  quantize,
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status code 400, actual %d", quantize, w.Code)
+		}
+
+		var resp map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(resp["error"], expect) {
+			t.Errorf("%s: expected error %q, actual %q", quantize, expect, resp["error"])
+		}
+	}
+}
+
+func TestCreateRequantize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	for name, fileType := range map[string]uint32{"q8_0": 7, "q4_0": 2} {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      name,
+			Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, map[string]any{"general.architecture": "llama", "general.file_type": fileType}, nil)),
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	cases := []struct {
+		from, quantize, expect string
+	}{
+		{"q8_0", "f16", "Q8_0 models can't be quantized to F16, which isn't smaller"},
+		{"q4_0", "q2_k", "quantization is only supported for F16, BF16, F32 and Q8_0 models"},
+	}
+
+	for _, tt := range cases {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name:      "test",
+			Modelfile: "FROM " + tt.from,
+			Quantize:  tt.quantize,
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: expected status code 500, actual %d", tt.from, w.Code)
+		}
+
+		var resp map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp["error"] != tt.expect {
+			t.Errorf("%s: expected error %q, actual %q", tt.from, tt.expect, resp["error"])
+		}
+	}
+}
+
+func TestCreateMergeAdapter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	tensor := func(name string, shape []uint64, values ...float32) llm.Tensor {
+		t := f32Tensor(name, values...)
+		t.Shape = shape
+		return t
+	}
+
+	base := createBinFile(t, map[string]any{"general.architecture": "llama", "general.file_type": uint32(0)}, []llm.Tensor{
+		tensor("blk.0.attn_q.weight", []uint64{2, 3}, 1, 2, 3, 4, 5, 6),
+	})
+
+	adapter := createBinFile(t, map[string]any{"general.architecture": "llama", "general.type": "adapter", "adapter.lora.alpha": float32(1)}, []llm.Tensor{
+		tensor("blk.0.attn_q.weight.lora_a", []uint64{1, 3}, 1, 0, 1),
+		tensor("blk.0.attn_q.weight.lora_b", []uint64{2, 1}, 1, 2),
+	})
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:          "merged",
+		Modelfile:     fmt.Sprintf("FROM %s\nADAPTER %s 2", base, adapter),
+		MergeAdapters: true,
+		Stream:        &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("merged")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.AdapterPaths) != 0 {
+		t.Errorf("expected no adapters, actual %v", m.AdapterPaths)
+	}
+
+	if _, ok := m.Options["adapter_scale"]; ok {
+		t.Errorf("expected no adapter_scale, actual %v", m.Options["adapter_scale"])
+	}
+
+	f, err := os.Open(m.ModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ggml, _, err := llm.DecodeGGML(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tensors := ggml.Tensors()
+	values := make([]float32, 6)
+	if err := binary.Read(io.NewSectionReader(f, int64(tensors.Offset+tensors.Items[0].Offset), 24), binary.LittleEndian, values); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := []float32{3, 2, 5, 8, 5, 10}; !slices.Equal(values, expect) {
+		t.Errorf("expected merged weights %v, actual %v", expect, values)
+	}
+
+	t.Run("quantized", func(t *testing.T) {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name: "test",
+			Modelfile: fmt.Sprintf("FROM %s\nADAPTER %s", createBinFile(t, map[string]any{"general.architecture": "llama", "general.file_type": uint32(7)}, []llm.Tensor{
+				{Name: "blk.0.attn_q.weight", Kind: 8, Shape: []uint64{2, 32}, WriterTo: bytes.NewReader(make([]byte, 68))},
+			}), adapter),
+			MergeAdapters: true,
+			Stream:        &stream,
+		})
+
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected status code 500, actual %d", w.Code)
+		}
+
+		var resp map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := "invalid adapter: adapters can only be merged into F32, F16 and BF16 weights, blk.0.attn_q.weight has type 8"; resp["error"] != expect {
+			t.Errorf("expected error %q, actual %q", expect, resp["error"])
+		}
+	})
+}
+
+func TestCreateOverrideKV(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "base",
+		Modelfile: fmt.Sprintf("FROM %s\nTEMPLATE {{ .Prompt }}", createBinFile(t, map[string]any{"general.architecture": "llama", "llama.rope.freq_base": float32(10000)}, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:       "fixed",
+		Modelfile:  "FROM base",
+		OverrideKV: map[string]string{"llama.rope.freq_base": "500000"},
+		Stream:     &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Template.String() != "{{ .Prompt }}" {
+		t.Errorf("expected template to be kept, actual %q", m.Template.String())
+	}
+
+	ggml, err := llm.LoadModel(m.ModelPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := ggml.KV()["llama.rope.freq_base"]; v != float32(500000) {
+		t.Errorf("expected llama.rope.freq_base 500000, actual %v", v)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:       "broken",
+		Modelfile:  "FROM base",
+		OverrideKV: map[string]string{"llama.rope.freq_base": "fast"},
+		Stream:     &stream,
+	})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code 500, actual %d", w.Code)
+	}
 }
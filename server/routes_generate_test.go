@@ -5,9 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -41,8 +44,8 @@ func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error
 	return
 }
 
-func newMockServer(mock *mockRunner) func(gpu.GpuInfoList, string, *llm.GGML, []string, []string, api.Options, int) (llm.LlamaServer, error) {
-	return func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, projectors, system []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+func newMockServer(mock *mockRunner) func(gpu.GpuInfoList, string, *llm.GGML, []string, []string, string, api.Options, int, llm.RunnerLog) (llm.LlamaServer, error) {
+	return func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, projectors, system []string, vocoder string, opts api.Options, numParallel int, runnerLog llm.RunnerLog) (llm.LlamaServer, error) {
 		return mock, nil
 	}
 }
@@ -343,6 +346,112 @@ func TestGenerateChat(t *testing.T) {
 
 		checkChatResponse(t, w.Body, "test-system", "Abra kadabra!")
 	})
+
+	t.Run("messages with multiple images", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 64, 32))); err != nil {
+			t.Fatal(err)
+		}
+
+		mock.CompletionResponse.ImageTokens = []int{576, 576}
+		t.Cleanup(func() { mock.CompletionResponse.ImageTokens = nil })
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model: "test",
+			Messages: []api.Message{
+				{Role: "user", Content: "Compare [img] and [img]", Images: []api.ImageData{b.Bytes(), b.Bytes()}},
+			},
+			Stream:  &stream,
+			Options: map[string]any{"image_resize": "crop", "image_max_resolution": 16},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, "User: Compare [img-0] and [img-1] "); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if len(mock.CompletionRequest.Images) != 2 {
+			t.Fatalf("expected 2 images, got %d", len(mock.CompletionRequest.Images))
+		}
+
+		for i, data := range mock.CompletionRequest.Images {
+			img, _, err := image.Decode(bytes.NewReader(data.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data.ID != i || img.Bounds().Dx() != 16 || img.Bounds().Dy() != 16 {
+				t.Errorf("expected image %d to be 16x16, got %d %v", i, data.ID, img.Bounds())
+			}
+		}
+
+		var actual api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&actual); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(actual.ImageTokens, []int{576, 576}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model: "test-tools",
+		Modelfile: `FROM test
+TEMPLATE """
+{{- if .Tools }}Tools: {{ range .Tools }}{{ .Function.Name }} {{ end }}{{ end }}
+{{- range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}"""
+TOOLS [{"type": "function", "function": {"name": "get_weather", "description": "Get the weather"}}]`,
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("messages with model tools", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model: "test-tools",
+			Messages: []api.Message{
+				{Role: "user", Content: "What's the weather?"},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, "Tools: get_weather user: What's the weather? "); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("messages with tools", func(t *testing.T) {
+		var tool api.Tool
+		tool.Type = "function"
+		tool.Function.Name = "get_time"
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model: "test-tools",
+			Messages: []api.Message{
+				{Role: "user", Content: "What time is it?"},
+			},
+			Tools:  api.Tools{tool},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, "Tools: get_time user: What time is it? "); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
 }
 
 func TestGenerate(t *testing.T) {
@@ -712,3 +821,203 @@ TEMPLATE """{{- if .Suffix }}<PRE> {{ .Prompt }} <SUF>{{ .Suffix }} <MID>
 		}
 	})
 }
+
+// mockBusyRunner times out waiting for a slot, as if every one were held
+type mockBusyRunner struct {
+	mockRunner
+}
+
+func (m *mockBusyRunner) Completion(ctx context.Context, _ llm.CompletionRequest, _ func(llm.CompletionResponse)) error {
+	return &llm.QueueTimeoutError{Timeout: 1500 * time.Millisecond, Position: 2}
+}
+
+func TestGenerateQueueTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := newMockRunnerServer(&mockBusyRunner{})
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	queueTimeout := &api.Duration{Duration: 1500 * time.Millisecond}
+	for _, streamed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream %t", streamed), func(t *testing.T) {
+			w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+				Model:        "test",
+				Prompt:       "Hello!",
+				Stream:       &streamed,
+				QueueTimeout: queueTimeout,
+			})
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("expected status 503, got %d", w.Code)
+			}
+
+			if retry := w.Header().Get("Retry-After"); retry != "2" {
+				t.Errorf("expected Retry-After 2, got %q", retry)
+			}
+
+			var resp map[string]any
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp["queue_position"] != float64(2) {
+				t.Errorf("expected queue position 2, got %v", resp["queue_position"])
+			}
+
+			if _, ok := resp["status"]; ok {
+				t.Errorf("expected no status in the body, got %v", resp)
+			}
+		})
+	}
+
+	w = createRequest(t, s.ChatHandler, api.ChatRequest{
+		Model:        "test",
+		Messages:     []api.Message{{Role: "user", Content: "Hello!"}},
+		Stream:       &stream,
+		QueueTimeout: queueTimeout,
+	})
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", w.Code)
+	}
+}
+
+func TestGenerateBadAdapter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var loads atomic.Int32
+	mock := mockRunner{}
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      gpu.GetGPUInfo,
+			getCpuFn:      gpu.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int) {
+				loads.Add(1)
+				req.successCh <- &runnerRef{llama: &mock}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	// the adapter is checked before the model is loaded for it
+	w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+		Model:   "test",
+		Prompt:  "Hello!",
+		Adapter: "missing",
+		Stream:  &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+
+	w = createRequest(t, s.ChatHandler, api.ChatRequest{
+		Model:    "test",
+		Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		Adapter:  "test",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+
+	if n := loads.Load(); n != 0 {
+		t.Errorf("expected no loads, got %d", n)
+	}
+}
+
+func TestGenerateFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mock := mockRunner{CompletionResponse: llm.CompletionResponse{Done: true, DoneReason: "stop"}}
+	s := newMockRunnerServer(&mock)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	cases := []struct {
+		name   string
+		format any
+		status int
+		expect string
+	}{
+		{"name", "json", http.StatusOK, "json"},
+		{"schema object", map[string]any{"type": "object"}, http.StatusOK, `{"type":"object"}`},
+		{"schema text", `{"type": "object"}`, http.StatusOK, `{"type": "object"}`},
+		{"null", nil, http.StatusOK, ""},
+		{"array", []any{"json"}, http.StatusBadRequest, ""},
+		{"pattern", map[string]any{"type": "string", "pattern": "^a+$"}, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.CompletionRequest = llm.CompletionRequest{}
+			w := createRequest(t, s.GenerateHandler, map[string]any{
+				"model":  "test",
+				"prompt": "Hello!",
+				"format": tt.format,
+				"stream": false,
+			})
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+
+			if mock.CompletionRequest.Format != tt.expect {
+				t.Errorf("expected format %q, got %q", tt.expect, mock.CompletionRequest.Format)
+			}
+
+			w = createRequest(t, s.ChatHandler, map[string]any{
+				"model":    "test",
+				"messages": []api.Message{{Role: "user", Content: "Hello!"}},
+				"format":   tt.format,
+				"stream":   false,
+			})
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -11,14 +11,19 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/openai"
 	"github.com/ollama/ollama/parser"
@@ -68,7 +73,7 @@ func Test_Routes(t *testing.T) {
 		fn := func(resp api.ProgressResponse) {
 			t.Logf("Status: %s", resp.Status)
 		}
-		err = CreateModel(context.TODO(), model.ParseName(name), "", "", modelfile, fn)
+		err = CreateModel(context.TODO(), model.ParseName(name), "", CreateOptions{}, modelfile, fn)
 		require.NoError(t, err)
 	}
 
@@ -418,6 +423,82 @@ func TestShow(t *testing.T) {
 	}
 }
 
+func TestShowResolve(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name: "base",
+		Modelfile: fmt.Sprintf(
+			"FROM %s\nFROM %s\nPARAMETER temperature 0.5\nSYSTEM You are a test.",
+			createBinFile(t, llm.KV{"general.architecture": "test"}, nil),
+			createBinFile(t, llm.KV{"general.architecture": "clip"}, nil),
+		),
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "child",
+		Modelfile: "FROM base\nPARAMETER top_k 10",
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.ShowModelHandler, api.ShowRequest{
+		Name:    "child",
+		Resolve: true,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ShowResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := GetModel("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range append([]string{expected.ModelPath}, expected.ProjectorPaths...) {
+		if from := "FROM " + blobReference(p); !strings.Contains(resp.Modelfile, from) {
+			t.Errorf("expected modelfile to have %q, actual %s", from, resp.Modelfile)
+		}
+	}
+
+	// the resolved Modelfile creates the same model
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "rebuilt",
+		Modelfile: resp.Modelfile,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	actual, err := GetModel("rebuilt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual.ModelPath != expected.ModelPath || !slices.Equal(actual.ProjectorPaths, expected.ProjectorPaths) {
+		t.Errorf("expected blobs %s %v, actual %s %v", expected.ModelPath, expected.ProjectorPaths, actual.ModelPath, actual.ProjectorPaths)
+	}
+
+	if actual.System != expected.System || !reflect.DeepEqual(actual.Options, expected.Options) {
+		t.Errorf("expected %q %v, actual %q %v", expected.System, expected.Options, actual.System, actual.Options)
+	}
+}
+
 func TestNormalize(t *testing.T) {
 	type testCase struct {
 		input []float32
@@ -452,3 +533,153 @@ func TestNormalize(t *testing.T) {
 		})
 	}
 }
+
+// wordsRunner detokenizes the tokens of mockRunner into as many words
+type wordsRunner struct {
+	mockRunner
+}
+
+func (wordsRunner) Detokenize(_ context.Context, tokens []int) (string, error) {
+	return strings.TrimSpace(strings.Repeat("word ", len(tokens))), nil
+}
+
+func TestTruncateTokens(t *testing.T) {
+	input := []string{"one two", "one two three four five", "one two three"}
+	counts, truncated, err := truncateTokens(context.Background(), &wordsRunner{}, 3, input, true)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3, 3}, counts)
+	assert.Equal(t, []int{1}, truncated)
+	assert.Equal(t, []string{"one two", "word word word", "one two three"}, input)
+	assert.Equal(t, 8, sumTokens(counts))
+
+	input = []string{"one two", "one two three four five"}
+	_, _, err = truncateTokens(context.Background(), &wordsRunner{}, 3, input, false)
+	require.ErrorIs(t, err, errInputTooLong)
+	assert.ErrorContains(t, err, "input 1 has 5 tokens, more than the context length of 3")
+	assert.Equal(t, "one two three four five", input[1])
+}
+
+func TestModelOptionsContextLength(t *testing.T) {
+	m := &Model{Options: map[string]interface{}{"num_ctx": float64(4096)}}
+
+	opts, err := modelOptions(m, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4096, opts.NumCtx)
+
+	t.Setenv("OLLAMA_CONTEXT_LENGTH", "32768")
+	opts, err = modelOptions(m, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 32768, opts.NumCtx)
+
+	opts, err = modelOptions(&Model{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 32768, opts.NumCtx)
+
+	opts, err = modelOptions(m, map[string]interface{}{"num_ctx": float64(8192)})
+	require.NoError(t, err)
+	assert.Equal(t, 8192, opts.NumCtx)
+}
+
+func TestProcessGPUs(t *testing.T) {
+	loaded := gpu.GpuInfoList{
+		{Library: "cuda", ID: "GPU-a", Name: "a"},
+		{Library: "cuda", ID: "GPU-b", Name: "b"},
+	}
+	loaded[0].FreeMemory, loaded[0].TotalMemory = 20, 24
+	loaded[1].FreeMemory, loaded[1].TotalMemory = 6, 8
+
+	current := gpu.GpuInfoList{{Library: "cuda", ID: "GPU-a"}}
+	current[0].FreeMemory, current[0].TotalMemory = 4, 24
+
+	utilization, temperature, power := 87, 65, 250.5
+	stats := []gpu.GpuStats{{Utilization: &utilization, Temperature: &temperature, Power: &power}}
+
+	gpus := processGPUs(loaded, current, stats)
+	require.Len(t, gpus, 2)
+
+	assert.Equal(t, api.ProcessGPUResponse{
+		ID:          "GPU-a",
+		Library:     "cuda",
+		Name:        "a",
+		FreeMemory:  4,
+		TotalMemory: 24,
+		Utilization: &utilization,
+		Temperature: &temperature,
+		Power:       &power,
+	}, gpus[0])
+
+	// a GPU which is no longer reported keeps its memory from when the
+	// model was loaded
+	assert.Equal(t, int64(6), gpus[1].FreeMemory)
+	assert.Nil(t, gpus[1].Utilization)
+
+	assert.Empty(t, processGPUs(gpu.GpuInfoList{{Library: "cpu", ID: "0"}}, nil, nil))
+}
+
+func TestProcessSlots(t *testing.T) {
+	llama := &mockLlm{slotsResp: []llm.SlotStatus{
+		{ID: 0, State: llm.SlotGenerating, RequestID: "req-1", PromptTokens: 512, ContextTokens: 700, GeneratedTokens: 188, Age: 12 * time.Second},
+		{ID: 1, State: llm.SlotIdle},
+	}}
+	runner := &runnerRef{modelPath: "foo", llama: llama}
+
+	assert.Equal(t, []api.ProcessSlotResponse{
+		{ID: 0, State: "generating", RequestID: "req-1", PromptTokens: 512, ContextTokens: 700, GeneratedTokens: 188, Age: 12 * time.Second},
+		{ID: 1, State: "idle"},
+	}, processSlots(context.Background(), []*runnerRef{runner})[0])
+
+	// runners which are loading or don't respond have no slots
+	runner.loading = true
+	assert.Nil(t, processSlots(context.Background(), []*runnerRef{runner})[0])
+
+	runner.loading = false
+	llama.slotsRespErr = context.DeadlineExceeded
+	assert.Nil(t, processSlots(context.Background(), []*runnerRef{runner})[0])
+
+	// runners which are busy share one deadline rather than each waiting for
+	// their own
+	llama.slotsRespErr = nil
+	runners := []*runnerRef{runner}
+	for range 3 {
+		runners = append(runners, &runnerRef{modelPath: "busy", llama: &mockLlm{slotsBlock: true}})
+	}
+
+	start := time.Now()
+	slots := processSlots(context.Background(), runners)
+	assert.Less(t, time.Since(start), 2*slotsTimeout)
+	require.Len(t, slots, 4)
+	assert.Len(t, slots[0], 2)
+	assert.Nil(t, slots[1])
+	assert.Nil(t, slots[3])
+}
+
+func TestClientMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var client string
+	r := gin.New()
+	r.Use(clientMiddleware())
+	r.GET("/", func(c *gin.Context) {
+		client = llm.ClientFromContext(c.Request.Context())
+	})
+
+	cases := []struct {
+		name   string
+		header http.Header
+		expect string
+	}{
+		{"address", http.Header{}, "192.0.2.1"},
+		{"forwarded", http.Header{"X-Forwarded-For": {"198.51.100.7"}, "X-Real-Ip": {"198.51.100.7"}}, "192.0.2.1"},
+		{"key", http.Header{"Authorization": {"Bearer secret"}}, "key:secret"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header = tt.header
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.expect, client)
+		})
+	}
+}
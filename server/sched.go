@@ -5,13 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ollama/ollama/api"
@@ -19,6 +23,7 @@ import (
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/gpu"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
 )
 
 type LlmRequest struct {
@@ -30,8 +35,18 @@ type LlmRequest struct {
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
+	seq             uint64       // order in which the request entered the pending queue
+	state           atomic.Int32 // one of requestQueued, requestScheduling or requestTimedOut
+	queueTimeout    time.Duration
+	queueDeadline   time.Time // when the request times out if it's still queued
 }
 
+const (
+	requestQueued int32 = iota
+	requestScheduling
+	requestTimedOut
+)
+
 type Scheduler struct {
 	pendingReqCh  chan *LlmRequest
 	finishedReqCh chan *LlmRequest
@@ -41,11 +56,26 @@ type Scheduler struct {
 	loaded   map[string]*runnerRef
 	loadedMu sync.Mutex
 
-	loadFn       func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int)
-	newServerFn  func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error)
-	getGpuFn     func() gpu.GpuInfoList
-	getCpuFn     func() gpu.GpuInfoList
-	reschedDelay time.Duration
+	// fits are the GPU layer counts which fit after models with automatic
+	// offloading ran out of GPU memory, alongside the runners loaded then
+	fits   map[fitKey]int
+	fitsMu sync.Mutex
+
+	// crashes are the runner crashes of each model path
+	crashes   map[string]*crashRecord
+	crashesMu sync.Mutex
+
+	queueMu      sync.Mutex
+	queuedSeq    uint64        // sequence number of the most recently queued request
+	scheduledSeq atomic.Uint64 // sequence number of the most recently dequeued request
+
+	loadFn        func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int)
+	newServerFn   func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, vocoder string, opts api.Options, numParallel int, runnerLog llm.RunnerLog) (llm.LlamaServer, error)
+	getGpuFn      func() gpu.GpuInfoList
+	getCpuFn      func() gpu.GpuInfoList
+	getGpuStatsFn func(gpu.GpuInfoList) []gpu.GpuStats
+	reschedDelay  time.Duration
+	restartDelay  time.Duration // backoff before restarting the first crash, doubling with each recent one
 }
 
 // Default automatic value for number of models we allow per GPU
@@ -68,17 +98,23 @@ func InitScheduler(ctx context.Context) *Scheduler {
 		expiredCh:     make(chan *runnerRef, maxQueue),
 		unloadedCh:    make(chan interface{}, maxQueue),
 		loaded:        make(map[string]*runnerRef),
+		fits:          make(map[fitKey]int),
+		crashes:       make(map[string]*crashRecord),
 		newServerFn:   llm.NewLlamaServer,
 		getGpuFn:      gpu.GetGPUInfo,
 		getCpuFn:      gpu.GetCPUInfo,
+		getGpuStatsFn: gpu.GetGPUStats,
 		reschedDelay:  250 * time.Millisecond,
+		restartDelay:  time.Second,
 	}
 	sched.loadFn = sched.load
 	return sched
 }
 
 // context must be canceled to decrement ref count and release the runner
-func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options, sessionDuration *api.Duration) (chan *runnerRef, chan error) {
+// If queueTimeout is set and the request is still waiting in the pending queue
+// when it expires, a *llm.QueueTimeoutError is sent on the error channel.
+func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options, sessionDuration, queueTimeout *api.Duration) (chan *runnerRef, chan error) {
 	if opts.NumCtx < 4 {
 		opts.NumCtx = 4
 	}
@@ -92,14 +128,75 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 		errCh:           make(chan error, 1),
 	}
 
+	s.queueMu.Lock()
+	req.seq = s.queuedSeq + 1
 	select {
 	case s.pendingReqCh <- req:
+		s.queuedSeq = req.seq
+		broadcast(eventQueueChanged, "", map[string]any{"depth": len(s.pendingReqCh)})
 	default:
 		req.errCh <- ErrMaxQueue
+		s.queueMu.Unlock()
+		return req.successCh, req.errCh
 	}
+	s.queueMu.Unlock()
+
+	if queueTimeout != nil && queueTimeout.Duration > 0 {
+		req.queueTimeout = queueTimeout.Duration
+		req.queueDeadline = time.Now().Add(queueTimeout.Duration)
+		time.AfterFunc(queueTimeout.Duration, func() { s.timeoutQueued(req) })
+	}
+
 	return req.successCh, req.errCh
 }
 
+// timeoutQueued sends req a *llm.QueueTimeoutError if it's queued and its queue
+// timeout has passed, and reports whether the timeout has passed
+func (s *Scheduler) timeoutQueued(req *LlmRequest) bool {
+	if req.queueTimeout <= 0 || time.Now().Before(req.queueDeadline) {
+		return false
+	}
+
+	if req.state.CompareAndSwap(requestQueued, requestTimedOut) {
+		req.errCh <- &llm.QueueTimeoutError{
+			Timeout:  req.queueTimeout,
+			Position: s.queuePosition(req),
+		}
+	}
+
+	return true
+}
+
+// requeue puts pending back on the pending queue after reschedDelay. Its
+// queue timeout may have fired while it was being scheduled, so it isn't
+// requeued if that has passed or it was cancelled in the meantime.
+func (s *Scheduler) requeue(pending *LlmRequest) {
+	time.Sleep(s.reschedDelay)
+	pending.state.Store(requestQueued)
+	if pending.ctx.Err() != nil {
+		slog.DebugContext(pending.ctx, "pending request cancelled while delayed, not requeueing")
+		return
+	}
+
+	if s.timeoutQueued(pending) {
+		slog.DebugContext(pending.ctx, "pending request timed out while delayed, not requeueing")
+		return
+	}
+
+	s.pendingReqCh <- pending
+}
+
+// queuePosition returns how many requests are ahead of req in the pending
+// queue, including req itself
+func (s *Scheduler) queuePosition(req *LlmRequest) int {
+	if scheduled := s.scheduledSeq.Load(); req.seq > scheduled {
+		return int(req.seq - scheduled)
+	}
+
+	// requests delayed by the scheduler are requeued behind newer ones
+	return 1
+}
+
 // Returns immediately, spawns go routines for the scheduler which will shutdown when ctx is done
 func (s *Scheduler) Run(ctx context.Context) {
 	slog.Debug("starting llm scheduler")
@@ -110,6 +207,10 @@ func (s *Scheduler) Run(ctx context.Context) {
 	go func() {
 		s.processCompleted(ctx)
 	}()
+
+	go func() {
+		s.checkHealth(ctx)
+	}()
 }
 
 func (s *Scheduler) processPending(ctx context.Context) {
@@ -119,6 +220,16 @@ func (s *Scheduler) processPending(ctx context.Context) {
 			slog.Debug("shutting down scheduler pending loop")
 			return
 		case pending := <-s.pendingReqCh:
+			if pending.seq > s.scheduledSeq.Load() {
+				s.scheduledSeq.Store(pending.seq)
+			}
+			broadcast(eventQueueChanged, "", map[string]any{"depth": len(s.pendingReqCh)})
+
+			if !pending.state.CompareAndSwap(requestQueued, requestScheduling) {
+				slog.DebugContext(pending.ctx, "pending request timed out in queue, skipping scheduling")
+				continue
+			}
+
 			// Block other requests until we get this pending request running
 			pending.schedAttempts++
 			if pending.origNumCtx == 0 {
@@ -126,21 +237,24 @@ func (s *Scheduler) processPending(ctx context.Context) {
 			}
 
 			if pending.ctx.Err() != nil {
-				slog.Debug("pending request cancelled or timed out, skipping scheduling")
+				slog.DebugContext(pending.ctx, "pending request cancelled or timed out, skipping scheduling")
 				continue
 			}
-			numParallel := int(envconfig.NumParallel())
+			numParallel := pending.numParallel()
 			// TODO (jmorganca): multimodal models don't support parallel yet
 			// see https://github.com/ollama/ollama/issues/4165
 			if len(pending.model.ProjectorPaths) > 0 && numParallel != 1 {
 				numParallel = 1
-				slog.Warn("multimodal models don't support parallel requests yet")
+				slog.WarnContext(pending.ctx, "multimodal models don't support parallel requests yet")
 			}
 
 			for {
 				var runnerToExpire *runnerRef
+				// evictReason is why runnerToExpire is unloaded for another
+				// model, or empty if it's reloaded for this one
+				var evictReason string
 				s.loadedMu.Lock()
-				runner := s.loaded[pending.model.ModelPath]
+				runner := s.loaded[pending.runnerKey()]
 				loadedCount := len(s.loaded)
 				s.loadedMu.Unlock()
 				if runner != nil {
@@ -152,8 +266,14 @@ func (s *Scheduler) processPending(ctx context.Context) {
 						break
 					}
 				} else if envconfig.MaxRunners() > 0 && loadedCount >= int(envconfig.MaxRunners()) {
-					slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
-					runnerToExpire = s.findRunnerToUnload()
+					slog.DebugContext(pending.ctx, "max runners achieved, unloading one to make room", "runner_count", loadedCount)
+					evictReason = "max_loaded_models"
+					if pending.opts.NumGPU == 0 {
+						runnerToExpire = s.findCPURunnerToUnload()
+					}
+					if runnerToExpire == nil {
+						runnerToExpire = s.findRunnerToUnload()
+					}
 				} else {
 					// Either no models are loaded or below envconfig.MaxRunners
 					// Get a refreshed GPU list
@@ -162,6 +282,18 @@ func (s *Scheduler) processPending(ctx context.Context) {
 						gpus = s.getCpuFn()
 					} else {
 						gpus = s.getGpuFn()
+						if placement := pending.gpuPlacement(); placement != "" && gpus[0].Library != "cpu" {
+							gpus = gpus.Select(placement)
+							if len(gpus) == 0 {
+								pending.errCh <- fmt.Errorf("no GPUs match placement %q for model %s", placement, pending.model.ShortName)
+								break
+							}
+						} else if pending.explicitLayout() {
+							// tensor_split and main_gpu refer to GPUs by
+							// their position, so none are left out
+						} else if gpus = excludeSmallGPUs(gpus); len(gpus) == 0 {
+							gpus = s.getCpuFn()
+						}
 					}
 
 					if envconfig.MaxRunners() <= 0 {
@@ -178,11 +310,11 @@ func (s *Scheduler) processPending(ctx context.Context) {
 						if allReliable {
 							// HACK
 							os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(defaultModelsPerGPU*len(gpus)))
-							slog.Debug("updating default concurrency", "OLLAMA_MAX_LOADED_MODELS", envconfig.MaxRunners, "gpu_count", len(gpus))
+							slog.DebugContext(pending.ctx, "updating default concurrency", "OLLAMA_MAX_LOADED_MODELS", envconfig.MaxRunners, "gpu_count", len(gpus))
 						} else {
 							// HACK
 							os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(len(gpus)))
-							slog.Info("one or more GPUs detected that are unable to accurately report free memory - disabling default concurrency")
+							slog.InfoContext(pending.ctx, "one or more GPUs detected that are unable to accurately report free memory - disabling default concurrency")
 						}
 					}
 
@@ -203,20 +335,21 @@ func (s *Scheduler) processPending(ctx context.Context) {
 						pending.opts.NumCtx = pending.origNumCtx * numParallel
 
 						if loadedCount == 0 {
-							slog.Debug("cpu mode with first model, loading")
+							slog.DebugContext(pending.ctx, "cpu mode with first model, loading")
 							s.loadFn(pending, ggml, gpus, numParallel)
 							break
 						}
 						runnerToExpire = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
+						evictReason = "memory"
 						if runnerToExpire == nil {
-							slog.Debug("cpu mode with available system memory or first model, loading")
+							slog.DebugContext(pending.ctx, "cpu mode with available system memory or first model, loading")
 							s.loadFn(pending, ggml, gpus, numParallel)
 							break
 						}
 						// else we need to expire a runner
 					} else if loadedCount == 0 {
 						// No models loaded. Load the model but prefer the best fit.
-						slog.Debug("loading first model", "model", pending.model.ModelPath)
+						slog.DebugContext(pending.ctx, "loading first model", "model", pending.model.ModelPath)
 						g := pickBestFullFitByLibrary(pending, ggml, gpus, &numParallel)
 						if g != nil {
 							gpus = g
@@ -241,7 +374,7 @@ func (s *Scheduler) processPending(ctx context.Context) {
 						s.updateFreeSpace(availGpus)
 						fitGpus := pickBestFullFitByLibrary(pending, ggml, availGpus, &numParallel)
 						if fitGpus != nil {
-							slog.Debug("new model fits with existing models, loading")
+							slog.DebugContext(pending.ctx, "new model fits with existing models, loading")
 							s.loadFn(pending, ggml, fitGpus, numParallel)
 							break
 						}
@@ -255,27 +388,41 @@ func (s *Scheduler) processPending(ctx context.Context) {
 							// needs more time, so put it on the back of the
 							// queue so that we might satisfy other pending
 							// requests that aren't blocked
-							go func() {
-								// Process in a go routine to avoid deadlocking
-								// the scheduler if our queue is full
-								slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
-								time.Sleep(s.reschedDelay)
-								s.pendingReqCh <- pending
-							}()
+							// Process in a go routine to avoid deadlocking
+							// the scheduler if our queue is full
+							slog.DebugContext(pending.ctx, "delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
+							go s.requeue(pending)
 							break
 						}
-						runnerToExpire = s.findRunnerToUnload()
+						if placement := pending.gpuPlacement(); placement != "" {
+							// unloading models on other GPUs wouldn't
+							// make room on the ones it's pinned to
+							if runnerToExpire = s.findRunnerToUnloadOn(gpus); runnerToExpire == nil {
+								pending.errCh <- fmt.Errorf("model %s doesn't fit on GPUs %q and no models loaded on them can be unloaded", pending.model.ShortName, placement)
+								break
+							}
+						} else {
+							runnerToExpire = s.findRunnerToUnload()
+						}
+						evictReason = "memory"
 					}
 				}
 
 				if runnerToExpire == nil {
 					// Shouildn't happen
-					slog.Error("runner to expire was nil!")
+					slog.ErrorContext(pending.ctx, "runner to expire was nil!")
 					continue
 				}
+				if evictReason != "" {
+					notify(eventModelEvicted, runnerToExpire.name(), withRequestID(pending.ctx, map[string]any{
+						"reason": evictReason,
+						"for":    pending.model.ShortName,
+					}))
+				}
+
 				// Trigger an expiration to unload once it's done
 				runnerToExpire.refMu.Lock()
-				slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
+				slog.DebugContext(pending.ctx, "resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
 				if runnerToExpire.expireTimer != nil {
 					runnerToExpire.expireTimer.Stop()
 					runnerToExpire.expireTimer = nil
@@ -288,7 +435,7 @@ func (s *Scheduler) processPending(ctx context.Context) {
 				// Wait for the unload to happen
 				// Note: at this point we're queueing up all incoming requests, even if they were for
 				// a different model that's loaded and not scheduled to be removed.
-				slog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
+				slog.DebugContext(pending.ctx, "waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
 				select {
 				case <-ctx.Done():
 					slog.Debug("shutting down scheduler pending loop")
@@ -314,7 +461,7 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			return
 		case finished := <-s.finishedReqCh:
 			s.loadedMu.Lock()
-			runner := s.loaded[finished.model.ModelPath]
+			runner := s.loaded[finished.runnerKey()]
 			s.loadedMu.Unlock()
 			if runner == nil {
 				slog.Error("finished request signal received after model unloaded", "modelPath", finished.model.ModelPath)
@@ -371,10 +518,12 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			slog.Debug("got lock to unload", "modelPath", runner.modelPath)
 			finished := runner.waitForVRAMRecovery()
 			runner.unload()
-			delete(s.loaded, runner.modelPath)
+			delete(s.loaded, runner.key)
 			s.loadedMu.Unlock()
+			s.forgetFits(runner.modelPath)
 			slog.Debug("runner released", "modelPath", runner.modelPath)
 			runner.refMu.Unlock()
+			notify(eventModelUnloaded, runner.name(), nil)
 
 			<-finished
 			slog.Debug("sending an unloaded event", "modelPath", runner.modelPath)
@@ -383,6 +532,128 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 	}
 }
 
+// runnerKey identifies the runner which serves the request. Requests forced
+// onto the CPU with num_gpu 0 get a runner of their own so they don't evict a
+// GPU resident instance of the same model.
+func (pending *LlmRequest) runnerKey() string {
+	if pending.opts.NumGPU == 0 {
+		return pending.model.ModelPath + "@cpu"
+	}
+
+	return pending.model.ModelPath
+}
+
+// gpuPlacement returns the GPUs the requested model is pinned to, if any. The
+// model's gpus option takes precedence over OLLAMA_GPU_PLACEMENT.
+func (pending *LlmRequest) gpuPlacement() string {
+	if pending.opts.GPUs != "" {
+		return pending.opts.GPUs
+	}
+
+	name := model.ParseName(pending.model.Name)
+	for k, v := range envconfig.GPUPlacement() {
+		if strings.EqualFold(model.ParseName(k).String(), name.String()) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// explicitLayout reports whether the request lays the model out across GPUs
+// itself with tensor_split or main_gpu, which refer to GPUs by their position
+func (pending *LlmRequest) explicitLayout() bool {
+	return pending.opts.TensorSplit != "" || pending.opts.MainGPU > 0
+}
+
+// numParallel returns how many requests the requested model serves at once,
+// or 0 to fit as many as memory allows. The model's num_parallel option takes
+// precedence over OLLAMA_NUM_PARALLEL.
+func (pending *LlmRequest) numParallel() int {
+	if pending.opts.NumParallel > 0 {
+		return pending.opts.NumParallel
+	}
+
+	return int(envconfig.NumParallel())
+}
+
+// maxTokenRate returns the most tokens per second the requested model may
+// generate from OLLAMA_MAX_TOKEN_RATE, or 0 if it isn't listed
+func (pending *LlmRequest) maxTokenRate() float32 {
+	name := model.ParseName(pending.model.Name)
+	for k, v := range envconfig.MaxTokenRates() {
+		if strings.EqualFold(model.ParseName(k).String(), name.String()) {
+			return v
+		}
+	}
+
+	return 0
+}
+
+// runnerLog returns how verbosely the requested model's runner logs, from
+// OLLAMA_RUNNER_LOG_LEVEL, and its file in OLLAMA_RUNNER_LOG_DIR if that's set
+func (pending *LlmRequest) runnerLog() llm.RunnerLog {
+	levels := envconfig.RunnerLogLevels()
+	runnerLog := llm.RunnerLog{Level: levels[""]}
+
+	name := model.ParseName(pending.model.Name)
+	for k, v := range levels {
+		if k != "" && strings.EqualFold(model.ParseName(k).String(), name.String()) {
+			runnerLog.Level = v
+		}
+	}
+
+	if dir := envconfig.RunnerLogDir(); dir != "" {
+		file := strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(pending.model.ShortName)
+		runnerLog.File = filepath.Join(dir, file+".log")
+	}
+
+	return runnerLog
+}
+
+// sticky reports whether the requested model should be kept loaded when
+// another model needs its memory, either because its Modelfile sets the
+// sticky parameter or because it's listed in OLLAMA_STICKY_MODELS. The sticky
+// option of a request is ignored so clients can't pin models in memory.
+func (pending *LlmRequest) sticky() bool {
+	if pending.model == nil {
+		return false
+	}
+
+	if sticky, ok := pending.model.Options["sticky"].(bool); ok && sticky {
+		return true
+	}
+
+	return pending.listed(envconfig.StickyModels())
+}
+
+// warmup reports whether the requested model should generate a token as
+// soon as it's loaded, either because of the model's warmup option or
+// because it's listed in OLLAMA_WARMUP_MODELS.
+func (pending *LlmRequest) warmup() bool {
+	if pending.opts.Warmup {
+		return true
+	}
+
+	if pending.model == nil {
+		return false
+	}
+
+	return pending.listed(envconfig.WarmupModels())
+}
+
+// listed reports whether the requested model is one of names
+func (pending *LlmRequest) listed(names []string) bool {
+	name := model.ParseName(pending.model.Name)
+	for _, k := range names {
+		if strings.EqualFold(model.ParseName(k).String(), name.String()) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Complete the pending request and send the runner back to the requester
 // Wires up a finished event after the request context is completed
 // Updates session duration, and resets expiration timer
@@ -397,6 +668,8 @@ func (pending *LlmRequest) useLoadedRunner(runner *runnerRef, finished chan *Llm
 	if pending.sessionDuration != nil {
 		runner.sessionDuration = pending.sessionDuration.Duration
 	}
+	runner.sticky = pending.sticky()
+	runner.used(time.Now())
 	pending.successCh <- runner
 	go func() {
 		<-pending.ctx.Done()
@@ -413,7 +686,26 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList,
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
-	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
+
+	opts := req.opts
+	key := fitKey{req.model.ModelPath, req.opts.NumCtx}
+	if layers, ok := s.fit(key); ok && opts.NumGPU < 0 {
+		slog.InfoContext(req.ctx, "offloading the layers which fit after running out of GPU memory", "model", req.model.ModelPath, "num_ctx", opts.NumCtx, "layers", layers)
+		opts.NumGPU = layers
+	}
+
+	// the model's own option takes precedence over OLLAMA_MAX_TOKEN_RATE
+	if opts.MaxTokensPerSecond == 0 {
+		opts.MaxTokensPerSecond = req.maxTokenRate()
+	}
+
+	loadTimeout := envconfig.LoadTimeout()
+	if req.model.Config.LoadTimeout != nil {
+		loadTimeout = max(req.model.Config.LoadTimeout.Duration, 0)
+	}
+
+	runnerLog := req.runnerLog()
+	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.VocoderPath, opts, numParallel, runnerLog)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
 		// show a generalized compatibility error until there is a better way to
@@ -421,51 +713,419 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList,
 		if errors.Is(err, llm.ErrUnsupportedFormat) || strings.Contains(err.Error(), "failed to load model") {
 			err = fmt.Errorf("%v: this model may be incompatible with your version of Ollama. If you previously pulled this model, try updating it by running `ollama pull %s`", err, req.model.ShortName)
 		}
-		slog.Info("NewLlamaServer failed", "model", req.model.ModelPath, "error", err)
+		slog.InfoContext(req.ctx, "NewLlamaServer failed", "model", req.model.ModelPath, "error", err)
 		req.errCh <- err
 		return
 	}
 	runner := &runnerRef{
-		model:           req.model,
-		modelPath:       req.model.ModelPath,
-		llama:           llama,
-		Options:         &req.opts,
-		sessionDuration: sessionDuration,
-		gpus:            gpus,
-		estimatedVRAM:   llama.EstimatedVRAM(),
-		estimatedTotal:  llama.EstimatedTotal(),
-		loading:         true,
-		refCount:        1,
+		model:            req.model,
+		modelPath:        req.model.ModelPath,
+		key:              req.runnerKey(),
+		llama:            llama,
+		Options:          &req.opts,
+		sessionDuration:  sessionDuration,
+		gpus:             gpus,
+		estimatedVRAM:    llama.EstimatedVRAM(),
+		estimatedTotal:   llama.EstimatedTotal(),
+		kvCacheType:      llama.KVCacheType(),
+		estimatedKVCache: llama.EstimatedKVCache(),
+		cudaGraphs:       llama.CUDAGraphs(),
+		loading:          true,
+		refCount:         1,
+		sticky:           req.sticky(),
 	}
 	runner.numParallel = numParallel
+	runner.numBatch, runner.numUBatch = llama.BatchSize()
+	runner.used(time.Now())
 	runner.refMu.Lock()
 
 	s.loadedMu.Lock()
-	s.loaded[req.model.ModelPath] = runner
-	slog.Info("loaded runners", "count", len(s.loaded))
+	s.loaded[runner.key] = runner
+	slog.InfoContext(req.ctx, "loaded runners", "count", len(s.loaded))
 	s.loadedMu.Unlock()
 
 	go func() {
 		defer runner.refMu.Unlock()
-		if err = llama.WaitUntilRunning(req.ctx); err != nil {
-			slog.Error("error loading llama server", "error", err)
+		err = llama.WaitUntilRunning(req.ctx, loadTimeout)
+
+		// retry automatic offloading with fewer layers until the model fits
+		for errors.Is(err, llm.ErrOutOfMemory) && req.opts.NumGPU < 0 && runner.llama.GPULayers() > 0 && req.ctx.Err() == nil {
+			layers := fewerLayers(runner.llama.GPULayers())
+			slog.WarnContext(req.ctx, "runner ran out of GPU memory, retrying with fewer layers", "model", req.model.ModelPath, "layers", runner.llama.GPULayers(), "retry_layers", layers)
+			runner.llama.Close()
+
+			opts.NumGPU = layers
+			var llama llm.LlamaServer
+			if llama, err = s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.VocoderPath, opts, numParallel, runnerLog); err != nil {
+				break
+			}
+
+			runner.llama = llama
+			runner.estimatedVRAM = llama.EstimatedVRAM()
+			runner.estimatedTotal = llama.EstimatedTotal()
+			runner.estimatedKVCache = llama.EstimatedKVCache()
+			if err = llama.WaitUntilRunning(req.ctx, loadTimeout); err == nil {
+				s.setFit(key, layers)
+			}
+		}
+
+		if err != nil {
+			slog.ErrorContext(req.ctx, "error loading llama server", "error", err)
 			runner.refCount--
 			req.errCh <- err
-			slog.Debug("triggering expiration for failed load", "model", runner.modelPath)
+			slog.DebugContext(req.ctx, "triggering expiration for failed load", "model", runner.modelPath)
 			s.expiredCh <- runner
 			return
 		}
-		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
+		slog.DebugContext(req.ctx, "finished setting up runner", "model", req.model.ModelPath)
+		if req.warmup() {
+			warmup(req.ctx, runner.llama, req.model, req.opts)
+		}
+		runner.mmap, runner.mlock, runner.pinned = runner.llama.HostMemory()
 		runner.loading = false
+		notify(eventModelLoaded, runner.name(), withRequestID(req.ctx, map[string]any{
+			"size":      runner.estimatedTotal,
+			"size_vram": runner.estimatedVRAM,
+		}))
 		go func() {
 			<-req.ctx.Done()
-			slog.Debug("context for request finished")
+			slog.DebugContext(req.ctx, "context for request finished")
 			s.finishedReqCh <- req
 		}()
 		req.successCh <- runner
 	}()
 }
 
+// warmupTimeout is how long warming up a model may take before it's
+// abandoned and the model serves requests anyway
+var warmupTimeout = time.Minute
+
+// warmupPrompt is the prompt of the generation warming up a model
+const warmupPrompt = "Hello"
+
+// warmup runs a hidden generation of a single token, or an embedding for
+// models which can't generate, on a model which was just loaded, so its
+// kernels are compiled and its caches filled before its first request.
+// Failing to warm up is logged, but doesn't fail loading the model.
+func warmup(ctx context.Context, llama llm.LlamaServer, m *Model, opts api.Options) {
+	ctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	if m.CheckCapabilities(CapabilityCompletion) == nil {
+		opts.NumPredict = 1
+		err = llama.Completion(ctx, llm.CompletionRequest{Prompt: warmupPrompt, Options: &opts}, func(llm.CompletionResponse) {})
+	} else if m.ClassifierPath == "" && m.VocoderPath == "" {
+		_, err = llama.Embedding(ctx, warmupPrompt)
+	} else {
+		return
+	}
+
+	if err != nil {
+		slog.WarnContext(ctx, "failed to warm up model", "model", m.ModelPath, "error", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "warmed up model", "model", m.ModelPath, "duration", time.Since(start))
+}
+
+// fitKey identifies a model loaded with a context size
+type fitKey struct {
+	modelPath string
+	numCtx    int
+}
+
+func (s *Scheduler) fit(key fitKey) (int, bool) {
+	s.fitsMu.Lock()
+	defer s.fitsMu.Unlock()
+	layers, ok := s.fits[key]
+	return layers, ok
+}
+
+func (s *Scheduler) setFit(key fitKey, layers int) {
+	s.fitsMu.Lock()
+	defer s.fitsMu.Unlock()
+	s.fits[key] = layers
+}
+
+// forgetFits drops the layer counts which fit alongside the runner of
+// modelPath, since more layers may fit now that it's unloaded. A model's own
+// count is kept as it's unloaded to be reloaded with fewer layers.
+func (s *Scheduler) forgetFits(modelPath string) {
+	s.fitsMu.Lock()
+	defer s.fitsMu.Unlock()
+	for key := range s.fits {
+		if key.modelPath != modelPath {
+			delete(s.fits, key)
+		}
+	}
+}
+
+// fewerLayers is the number of layers to offload after n ran out of memory,
+// a quarter fewer so a model fits in a few retries
+func fewerLayers(n int) int {
+	return min(n*3/4, n-1)
+}
+
+// outOfMemory handles the runner llama running out of GPU memory while
+// generating. If its model was offloaded automatically, it's reloaded with
+// fewer layers once its requests finish.
+func (s *Scheduler) outOfMemory(llama llm.LlamaServer) {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	for _, runner := range s.loaded {
+		if runner.llama != llama {
+			continue
+		}
+
+		runner.refMu.Lock()
+		defer runner.refMu.Unlock()
+		if runner.Options == nil || runner.Options.NumGPU >= 0 || llama.GPULayers() <= 0 {
+			return
+		}
+
+		layers := fewerLayers(llama.GPULayers())
+		slog.Warn("runner ran out of GPU memory, reloading with fewer layers", "model", runner.modelPath, "layers", llama.GPULayers(), "retry_layers", layers)
+		s.setFit(fitKey{runner.modelPath, runner.Options.NumCtx}, layers)
+
+		// expire as soon as its requests finish
+		if runner.expireTimer != nil {
+			runner.expireTimer.Stop()
+			runner.expireTimer = nil
+		}
+		runner.sessionDuration = 0
+		return
+	}
+}
+
+// crashRecord counts the crashes of a model's runners
+type crashRecord struct {
+	count  int       // crashes since the server started
+	recent int       // crashes less than crashRestartWindow apart
+	last   time.Time // when it last crashed
+}
+
+const (
+	// crashRestartWindow is how long after a crash another one counts
+	// towards maxCrashRestarts
+	crashRestartWindow = 10 * time.Minute
+
+	// maxCrashRestarts is the number of recent crashes after which runners
+	// aren't restarted until a request loads them
+	maxCrashRestarts = 5
+
+	// maxRestartDelay caps the backoff before restarting crashed runners
+	maxRestartDelay = time.Minute
+)
+
+// crashCount is the number of times runners of the model at modelPath crashed
+func (s *Scheduler) crashCount(modelPath string) int {
+	s.crashesMu.Lock()
+	defer s.crashesMu.Unlock()
+	if c, ok := s.crashes[modelPath]; ok {
+		return c.count
+	}
+	return 0
+}
+
+// recordCrash counts a crash of modelPath and returns the record
+func (s *Scheduler) recordCrash(modelPath string) crashRecord {
+	s.crashesMu.Lock()
+	defer s.crashesMu.Unlock()
+	c, ok := s.crashes[modelPath]
+	if !ok {
+		c = &crashRecord{}
+		s.crashes[modelPath] = c
+	}
+
+	now := time.Now()
+	if now.Sub(c.last) > crashRestartWindow {
+		c.recent = 0
+	}
+	c.count++
+	c.recent++
+	c.last = now
+	return *c
+}
+
+// restartBackoff is how long to wait before restarting a runner after its
+// recent-th crash
+func (s *Scheduler) restartBackoff(recent int) time.Duration {
+	delay := s.restartDelay
+	for range recent - 1 {
+		delay *= 2
+		if delay >= maxRestartDelay {
+			return maxRestartDelay
+		}
+	}
+	return delay
+}
+
+// crashed handles the process of the runner llama crashing. The runner is
+// unloaded once its requests finish and, unless it keeps crashing, loaded
+// again after a backoff so it's ready for the next request. It returns the
+// number of times runners of the model crashed.
+func (s *Scheduler) crashed(llama llm.LlamaServer, crash *llm.CrashError) int {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	for _, runner := range s.loaded {
+		if runner.llama != llama {
+			continue
+		}
+
+		runner.refMu.Lock()
+		defer runner.refMu.Unlock()
+		if runner.crashed {
+			// already handled for another of its requests
+			return s.crashCount(runner.modelPath)
+		}
+		runner.crashed = true
+
+		record := s.recordCrash(runner.modelPath)
+		keepAlive := runner.sessionDuration
+
+		// expire as soon as its requests finish
+		if runner.expireTimer != nil {
+			runner.expireTimer.Stop()
+			runner.expireTimer = nil
+		}
+		runner.sessionDuration = 0
+
+		data := map[string]any{"exit_status": crash.ExitStatus, "crashes": record.count}
+		switch {
+		case runner.model == nil || runner.Options == nil || keepAlive <= 0:
+			slog.Error("llama runner crashed", "model", runner.modelPath, "status", crash.ExitStatus, "crashes", record.count)
+		case record.recent > maxCrashRestarts:
+			slog.Error("llama runner crashed too often, not restarting", "model", runner.modelPath, "status", crash.ExitStatus, "crashes", record.count)
+		default:
+			delay := s.restartBackoff(record.recent)
+			slog.Error("llama runner crashed, restarting", "model", runner.modelPath, "status", crash.ExitStatus, "crashes", record.count, "restart_in", delay)
+			data["restart_in"] = delay.String()
+
+			opts := *runner.Options
+			opts.NumCtx /= max(runner.numParallel, 1)
+			go s.restart(runner.model, opts, keepAlive, delay)
+		}
+
+		notify(eventRunnerCrashed, runner.name(), data)
+		return record.count
+	}
+
+	return 0
+}
+
+// restart loads model again after delay, as a crashed runner had it loaded
+func (s *Scheduler) restart(model *Model, opts api.Options, keepAlive, delay time.Duration) {
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	successCh, errCh := s.GetRunner(ctx, model, opts, &api.Duration{Duration: keepAlive}, nil)
+	select {
+	case <-successCh:
+		slog.Info("restarted llama runner", "model", model.ModelPath)
+	case err := <-errCh:
+		slog.Error("failed to restart llama runner", "model", model.ModelPath, "error", err)
+	}
+}
+
+// checkHealth pings loaded runners every OLLAMA_HEALTH_CHECK_INTERVAL and
+// replaces the ones which fail OLLAMA_HEALTH_CHECK_FAILURES checks in a row
+func (s *Scheduler) checkHealth(ctx context.Context) {
+	interval := envconfig.HealthCheckInterval()
+	if interval <= 0 {
+		slog.Debug("runner health checks disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Debug("shutting down scheduler health check loop")
+			return
+		case <-ticker.C:
+			s.pingRunners(ctx, envconfig.HealthCheckTimeout(), max(int(envconfig.HealthCheckFailures()), 1))
+		}
+	}
+}
+
+// pingRunners checks every loaded runner is responsive, waiting up to timeout
+// for each, and replaces runners which failed maxFailures checks in a row
+func (s *Scheduler) pingRunners(ctx context.Context, timeout time.Duration, maxFailures int) {
+	type check struct {
+		runner *runnerRef
+		llama  llm.LlamaServer
+	}
+
+	var checks []check
+	s.loadedMu.Lock()
+	for _, runner := range s.loaded {
+		runner.refMu.Lock()
+		if !runner.loading && !runner.crashed && runner.llama != nil {
+			checks = append(checks, check{runner, runner.llama})
+		}
+		runner.refMu.Unlock()
+	}
+	s.loadedMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pingCtx, cancel := ctx, context.CancelFunc(func() {})
+			if timeout > 0 {
+				pingCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			defer cancel()
+
+			err := c.llama.Ping(pingCtx)
+			c.runner.refMu.Lock()
+			if err == nil || c.runner.llama != c.llama {
+				c.runner.healthFailures = 0
+				c.runner.refMu.Unlock()
+				return
+			}
+
+			c.runner.healthFailures++
+			failures := c.runner.healthFailures
+			c.runner.refMu.Unlock()
+
+			slog.Warn("llama runner failed health check", "model", c.runner.modelPath, "failures", failures, "error", err)
+			if failures >= maxFailures && ctx.Err() == nil {
+				s.replace(c.runner, c.llama, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// replace handles the runner llama failing its health checks with err. Its
+// process is stopped and handled as a crash, so requests it was serving fail
+// and the model is loaded again.
+func (s *Scheduler) replace(runner *runnerRef, llama llm.LlamaServer, err error) {
+	var crash *llm.CrashError
+	if !errors.As(err, &crash) {
+		crash = &llm.CrashError{ExitStatus: "unresponsive", Message: err.Error()}
+	}
+
+	slog.Error("llama runner is unresponsive, replacing it", "model", runner.modelPath, "error", err)
+	s.crashed(llama, crash)
+	if err := llama.Close(); err != nil {
+		slog.Debug("error stopping unresponsive llama runner", "error", err)
+	}
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	if runner.refCount == 0 && runner.llama == llama {
+		// no request will finish to unload it
+		s.expiredCh <- runner
+	}
+}
+
 func (s *Scheduler) updateFreeSpace(allGpus gpu.GpuInfoList) {
 	type predKey struct {
 		Library string
@@ -542,16 +1202,47 @@ type runnerRef struct {
 	estimatedVRAM  uint64
 	estimatedTotal uint64
 
+	kvCacheType      string
+	estimatedKVCache uint64
+
+	// effective tuning of the runner, reported by ps
+	numBatch   int
+	numUBatch  int
+	cudaGraphs bool
+
+	// mmap, mlock and pinned are how the model is held in system memory once
+	// it's loaded
+	mmap   bool
+	mlock  bool
+	pinned bool
+
 	sessionDuration time.Duration
 	expireTimer     *time.Timer
 	expiresAt       time.Time
+	crashed         bool // the runner's process crashed, see Scheduler.crashed
+	healthFailures  int  // health checks failed in a row, see Scheduler.checkHealth
+
+	// eviction policy, see pickRunnerToUnload
+	sticky   bool      // only unloaded when every candidate is sticky
+	lastUsed time.Time // when the runner was last handed to a request
+	rate     float64   // requests served, decayed with evictionRateHalfLife as of lastUsed
 
 	model       *Model
 	modelPath   string
+	key         string // key in Scheduler.loaded, see LlmRequest.runnerKey
 	numParallel int
 	*api.Options
 }
 
+// name is the name of the model of the runner
+func (runner *runnerRef) name() string {
+	if runner.model != nil {
+		return runner.model.ShortName
+	}
+
+	return runner.modelPath
+}
+
 // The refMu must already be held when calling unload
 func (runner *runnerRef) unload() {
 	if runner.expireTimer != nil {
@@ -596,6 +1287,7 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 	defer cancel()
 	if !reflect.DeepEqual(runner.model.AdapterPaths, req.model.AdapterPaths) || // have the adapters changed?
 		!reflect.DeepEqual(runner.model.ProjectorPaths, req.model.ProjectorPaths) || // have the projectors changed?
+		runner.model.VocoderPath != req.model.VocoderPath || // has the vocoder changed?
 		!reflect.DeepEqual(optsExisting, optsNew) || // have the runner options changed?
 		runner.llama.Ping(ctx) != nil {
 		return true
@@ -661,20 +1353,52 @@ func (runner *runnerRef) waitForVRAMRecovery() chan interface{} {
 	return finished
 }
 
-type ByDuration []*runnerRef
+// evictionRateHalfLife is how quickly requests stop counting towards the
+// request rate of a runner when choosing one to unload
+var evictionRateHalfLife = 10 * time.Minute
+
+// The refMu must already be held when calling used
+func (runner *runnerRef) used(now time.Time) {
+	runner.rate = runner.rateAt(now) + 1
+	runner.lastUsed = now
+}
+
+// rateAt returns the decayed request count of the runner at now. The refMu
+// must already be held.
+func (runner *runnerRef) rateAt(now time.Time) float64 {
+	if runner.lastUsed.IsZero() || runner.rate == 0 {
+		return 0
+	}
 
-func (a ByDuration) Len() int      { return len(a) }
-func (a ByDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a ByDuration) Less(i, j int) bool {
-	// uint64 to turn negative time (never unload) to largest
-	return uint64(a[i].sessionDuration) < uint64(a[j].sessionDuration)
+	return runner.rate * math.Exp2(-float64(now.Sub(runner.lastUsed))/float64(evictionRateHalfLife))
 }
 
-// TODO - future consideration to pick runners based on size
-// type BySize []*runnerRef
-// func (a BySize) Len() int           { return len(a) }
-// func (a BySize) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-// func (a BySize) Less(i, j int) bool { return a[i].estimatedVRAM < a[j].estimatedVRAM }
+// evictionCandidate is a snapshot of a runner's eviction policy state
+type evictionCandidate struct {
+	runner          *runnerRef
+	idle            bool
+	sticky          bool
+	value           float64 // recent requests per byte of memory
+	lastUsed        time.Time
+	sessionDuration time.Duration
+}
+
+// less reports whether c should be unloaded before o
+func (c evictionCandidate) less(o evictionCandidate) bool {
+	switch {
+	case c.sticky != o.sticky:
+		return o.sticky
+	case c.idle != o.idle:
+		return c.idle
+	case c.value != o.value:
+		return c.value < o.value
+	case !c.lastUsed.Equal(o.lastUsed):
+		return c.lastUsed.Before(o.lastUsed)
+	default:
+		// uint64 to turn negative time (never unload) to largest
+		return uint64(c.sessionDuration) < uint64(o.sessionDuration)
+	}
+}
 
 // pickBestFullFitByLibrary will try to find the optimal placement of the model in the available GPUs where the model fully fits
 // The list of GPUs returned will always be the same brand (library)
@@ -696,15 +1420,25 @@ func pickBestFullFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoL
 		var ok bool
 		sgl := append(make(gpu.GpuInfoList, 0, len(gl)), gl...)
 
-		// TODO - potentially sort by performance capability, existing models loaded, etc.
+		// An explicit layout refers to GPUs by their position so keep them
+		// in order and always use all of them
+		explicitLayout := req.explicitLayout()
+
 		// TODO - Eliminate any GPUs that already have envconfig.MaxRunners loaded on them
-		// Note: at present, this will favor more VRAM over faster GPU speed in mixed setups
-		sort.Sort(sort.Reverse(gpu.ByFreeMemory(sgl)))
+		// Note: by default, this will favor more VRAM over faster GPU speed in mixed setups
+		fastest := envconfig.GPUPolicy() == envconfig.GPUPolicyFastest
+		if !explicitLayout {
+			if fastest {
+				sort.Stable(sort.Reverse(gpu.ByPerformance(sgl)))
+			} else {
+				sort.Sort(sort.Reverse(gpu.ByFreeMemory(sgl)))
+			}
+		}
 
 		// First attempt to fit the model into a single GPU
 		for _, p := range numParallelToTry {
 			req.opts.NumCtx = req.origNumCtx * p
-			if !envconfig.SchedSpread() {
+			if !envconfig.SchedSpread() && !explicitLayout {
 				for _, g := range sgl {
 					if ok, estimatedVRAM = llm.PredictServerFit([]gpu.GpuInfo{g}, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
 						slog.Info("new model will fit in available VRAM in single GPU, loading", "model", req.model.ModelPath, "gpu", g.ID, "parallel", p, "available", g.FreeMemory, "required", format.HumanBytes2(estimatedVRAM))
@@ -715,9 +1449,22 @@ func pickBestFullFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoL
 			}
 		}
 
+		// Then the fewest of the fastest GPUs
+		if fastest && !envconfig.SchedSpread() && !explicitLayout {
+			for n := 2; n < len(sgl); n++ {
+				for _, p := range numParallelToTry {
+					req.opts.NumCtx = req.origNumCtx * p
+					if ok, estimatedVRAM = llm.PredictServerFit(sgl[:n], ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+						slog.Info("new model will fit in available VRAM of the fastest GPUs, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "gpus", n, "parallel", p, "required", format.HumanBytes2(estimatedVRAM))
+						*numParallel = p
+						return sgl[:n]
+					}
+				}
+			}
+		}
+
 		// TODO future refinements
 		// - if multiple Libraries, see if any single GPU in any Library will fit
-		// - try subsets of GPUs instead of just falling back to 1 or all in a family
 
 		// Now try all the GPUs
 		for _, p := range numParallelToTry {
@@ -736,51 +1483,154 @@ func pickBestFullFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoL
 func pickBestPartialFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel *int) gpu.GpuInfoList {
 	*numParallel = 1
 	byLibrary := gpus.ByLibrary()
-	if len(byLibrary) <= 1 {
+	bestFit := 0
+	if len(byLibrary) > 1 {
+		var bestEstimate uint64
+		for i, gl := range byLibrary {
+			_, estimatedVRAM := llm.PredictServerFit(gl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts)
+			if estimatedVRAM > bestEstimate {
+				bestEstimate = estimatedVRAM
+				bestFit = i
+			}
+		}
+	} else if len(byLibrary) == 0 {
 		return gpus
 	}
-	var bestEstimate uint64
-	var bestFit int
-	for i, gl := range byLibrary {
-		_, estimatedVRAM := llm.PredictServerFit(gl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts)
-		if estimatedVRAM > bestEstimate {
-			bestEstimate = estimatedVRAM
-			bestFit = i
+
+	// the first GPU holds the scratch buffers, so it should be the fastest
+	sgl := byLibrary[bestFit]
+	if envconfig.GPUPolicy() == envconfig.GPUPolicyFastest && !req.explicitLayout() {
+		sort.Stable(sort.Reverse(gpu.ByPerformance(sgl)))
+	}
+	return sgl
+}
+
+// excludeSmallGPUs removes the GPUs with less total memory than
+// OLLAMA_GPU_MIN_MEMORY, so a small, slow GPU doesn't hold back models split
+// across it and larger ones.
+func excludeSmallGPUs(gpus gpu.GpuInfoList) gpu.GpuInfoList {
+	if len(gpus) == 0 || gpus[0].Library == "cpu" {
+		return gpus
+	}
+
+	var largest uint64
+	for _, g := range gpus {
+		largest = max(largest, g.TotalMemory)
+	}
+
+	minimum := envconfig.GPUMinMemory(largest)
+	return slices.DeleteFunc(slices.Clone(gpus), func(g gpu.GpuInfo) bool {
+		if g.TotalMemory < minimum {
+			slog.Debug("excluding gpu with less memory than OLLAMA_GPU_MIN_MEMORY", "id", g.ID, "library", g.Library, "total", format.HumanBytes2(g.TotalMemory), "minimum", format.HumanBytes2(minimum))
+			return true
 		}
+		return false
+	})
+}
+
+// loadedRunners returns the runners currently loaded, so callers can look at
+// them without holding loadedMu
+func (s *Scheduler) loadedRunners() []*runnerRef {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	runnerList := make([]*runnerRef, 0, len(s.loaded))
+	for _, r := range s.loaded {
+		runnerList = append(runnerList, r)
 	}
-	return byLibrary[bestFit]
+	return runnerList
 }
 
 // findRunnerToUnload finds a runner to unload to make room for a new model
 func (s *Scheduler) findRunnerToUnload() *runnerRef {
+	return pickRunnerToUnload(s.loadedRunners())
+}
+
+// findCPURunnerToUnload is like findRunnerToUnload but only considers runners
+// running entirely on the CPU so CPU loads don't evict GPU resident models.
+// If no CPU runners are loaded nil is returned.
+func (s *Scheduler) findCPURunnerToUnload() *runnerRef {
 	s.loadedMu.Lock()
 	runnerList := make([]*runnerRef, 0, len(s.loaded))
 	for _, r := range s.loaded {
-		runnerList = append(runnerList, r)
+		if len(r.gpus) == 0 || r.gpus[0].Library == "cpu" {
+			runnerList = append(runnerList, r)
+		}
+	}
+	s.loadedMu.Unlock()
+	return pickRunnerToUnload(runnerList)
+}
+
+// findRunnerToUnloadOn is like findRunnerToUnload but only considers runners
+// loaded on any of gpus, so a model pinned to some GPUs doesn't evict models
+// on the others. It returns nil if no runners are loaded on them, as
+// unloading others wouldn't free any of their memory.
+func (s *Scheduler) findRunnerToUnloadOn(gpus gpu.GpuInfoList) *runnerRef {
+	s.loadedMu.Lock()
+	var runnerList []*runnerRef
+	for _, r := range s.loaded {
+		if slices.ContainsFunc(r.gpus, func(g gpu.GpuInfo) bool {
+			return slices.ContainsFunc(gpus, func(o gpu.GpuInfo) bool {
+				return g.Library == o.Library && g.ID == o.ID
+			})
+		}) {
+			runnerList = append(runnerList, r)
+		}
 	}
 	s.loadedMu.Unlock()
+
+	return pickRunnerToUnload(runnerList)
+}
+
+// pickRunnerToUnload picks the runner which costs the least to unload.
+// Sticky runners are only picked if every runner is sticky, and idle runners
+// are picked before busy ones. Amongst those, the runner serving the fewest
+// recent requests for the memory it holds is picked, so a large model that's
+// rarely used goes before a small one that's used often. Ties go to the
+// least recently used runner, then the one with the shortest keep alive.
+func pickRunnerToUnload(runnerList []*runnerRef) *runnerRef {
 	if len(runnerList) == 0 {
 		slog.Debug("no loaded runner to unload")
 		return nil
 	}
 
-	// In the future we can enhance the algorithm to be smarter about picking the optimal runner to unload
-	// e.g., if we have multiple options, will one make room for the request?
-	sort.Sort(ByDuration(runnerList))
-
-	// First try to find a runner that's already idle
+	now := time.Now()
+	var best *evictionCandidate
 	for _, runner := range runnerList {
 		runner.refMu.Lock()
-		rc := runner.refCount
+		size := runner.estimatedVRAM
+		if size == 0 {
+			size = runner.estimatedTotal
+		}
+
+		c := evictionCandidate{
+			runner:          runner,
+			idle:            runner.refCount == 0,
+			sticky:          runner.sticky,
+			value:           runner.rateAt(now) / float64(max(size, 1)),
+			lastUsed:        runner.lastUsed,
+			sessionDuration: runner.sessionDuration,
+		}
 		runner.refMu.Unlock()
-		if rc == 0 {
-			slog.Debug("found an idle runner to unload")
-			return runner
+
+		if best == nil || c.less(*best) {
+			best = &c
 		}
 	}
-	// None appear idle, just wait for the one with the shortest duration
-	slog.Debug("no idle runners, picking the shortest duration", "count", len(runnerList))
-	return runnerList[0]
+
+	slog.Debug("picked runner to unload", "model", best.runner.modelPath, "idle", best.idle, "sticky", best.sticky, "last_used", best.lastUsed, "count", len(runnerList))
+	return best.runner
+}
+
+// attention returns the attention implementation used by the runner loaded
+// for modelPath, or an empty string if the model is not loaded
+func (s *Scheduler) attention(modelPath string) string {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	if runner, ok := s.loaded[modelPath]; ok && runner.llama != nil {
+		return runner.llama.Attention()
+	}
+
+	return ""
 }
 
 func (s *Scheduler) unloadAllRunners() {
@@ -804,7 +1654,17 @@ func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, ggml *llm.GGML,
 		return nil
 	}
 
-	// TODO - optimization: try to find CPU only runners first, or partial offloads with enough in system memory to make room
+	// TODO - optimization: try partial offloads with enough in system memory to make room
+	if runner := s.findCPURunnerToUnload(); runner != nil {
+		return runner
+	}
+
+	// Requests forced onto the CPU shouldn't evict GPU resident models. If
+	// the model really doesn't fit the load will fail instead.
+	if req.opts.NumGPU == 0 {
+		slog.Debug("no cpu runners to unload, attempting load without evicting gpu runners")
+		return nil
+	}
 
 	return s.findRunnerToUnload()
 }
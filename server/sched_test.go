@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -47,7 +50,7 @@ func TestLoad(t *testing.T) {
 		sessionDuration: &api.Duration{Duration: 2 * time.Second},
 	}
 	// Fail to load model first
-	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, vocoder string, opts api.Options, numParallel int, runnerLog llm.RunnerLog) (llm.LlamaServer, error) {
 		return nil, errors.New("something failed to load model blah")
 	}
 	gpus := gpu.GpuInfoList{}
@@ -61,7 +64,7 @@ func TestLoad(t *testing.T) {
 	require.Contains(t, err.Error(), "this model may be incompatible")
 
 	server := &mockLlm{estimatedVRAM: 10, estimatedVRAMByGPU: map[string]uint64{}}
-	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, vocoder string, opts api.Options, numParallel int, runnerLog llm.RunnerLog) (llm.LlamaServer, error) {
 		return server, nil
 	}
 	s.load(req, ggml, gpus, 0)
@@ -94,6 +97,258 @@ func TestLoad(t *testing.T) {
 	require.Len(t, s.expiredCh, 1)
 }
 
+func TestLoadTimeout(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	var ggml *llm.GGML // value not used in tests
+	server := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}
+	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, vocoder string, opts api.Options, numParallel int, runnerLog llm.RunnerLog) (llm.LlamaServer, error) {
+		return server, nil
+	}
+
+	cases := []struct {
+		name    string
+		env     string
+		model   *api.Duration
+		timeout time.Duration
+	}{
+		{"default", "", nil, 5 * time.Minute},
+		{"env", "10m", nil, 10 * time.Minute},
+		{"model", "10m", &api.Duration{Duration: time.Minute}, time.Minute},
+		{"model disabled", "10m", &api.Duration{Duration: time.Duration(math.MaxInt64)}, time.Duration(math.MaxInt64)},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_LOAD_TIMEOUT", tt.env)
+			model := &Model{ModelPath: tt.name}
+			model.Config.LoadTimeout = tt.model
+			req := &LlmRequest{
+				ctx:       ctx,
+				model:     model,
+				opts:      api.DefaultOptions(),
+				successCh: make(chan *runnerRef, 1),
+				errCh:     make(chan error, 1),
+			}
+
+			s.load(req, ggml, gpu.GpuInfoList{}, 0)
+			select {
+			case err := <-req.errCh:
+				t.Fatalf("unexpected error %v", err)
+			case <-req.successCh:
+				require.Equal(t, tt.timeout, server.loadTimeout)
+			}
+		})
+	}
+}
+
+func TestPingRunners(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+
+	healthy := &mockLlm{}
+	s.loaded["healthy"] = &runnerRef{modelPath: "healthy", llama: healthy, healthFailures: 1}
+	hung := &mockLlm{pingResp: errors.New("server not responding")}
+	s.loaded["hung"] = &runnerRef{modelPath: "hung", llama: hung}
+	loading := &mockLlm{pingResp: errors.New("server not responding")}
+	s.loaded["loading"] = &runnerRef{modelPath: "loading", llama: loading, loading: true}
+
+	s.pingRunners(ctx, time.Second, 2)
+	require.Zero(t, s.loaded["healthy"].healthFailures)
+	require.Equal(t, 1, s.loaded["hung"].healthFailures)
+	require.Zero(t, s.loaded["loading"].healthFailures)
+	require.False(t, hung.closeCalled)
+
+	// replaced after failing twice in a row
+	s.pingRunners(ctx, time.Second, 2)
+	require.True(t, hung.closeCalled)
+	require.True(t, s.loaded["hung"].crashed)
+	require.Equal(t, 1, s.crashCount("hung"))
+	require.False(t, healthy.closeCalled)
+	require.False(t, loading.closeCalled)
+
+	select {
+	case runner := <-s.expiredCh:
+		require.Equal(t, "hung", runner.modelPath)
+	default:
+		t.Fatal("idle unresponsive runner wasn't expired")
+	}
+}
+
+func TestLoadOutOfMemory(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	var ggml *llm.GGML // value not used in tests
+	req := &LlmRequest{
+		ctx:             ctx,
+		model:           &Model{ModelPath: "foo"},
+		opts:            api.DefaultOptions(),
+		successCh:       make(chan *runnerRef, 1),
+		errCh:           make(chan error, 1),
+		sessionDuration: &api.Duration{Duration: 2 * time.Second},
+	}
+
+	// runners with more than 20 layers run out of memory
+	var layers []int
+	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, vocoder string, opts api.Options, numParallel int, runnerLog llm.RunnerLog) (llm.LlamaServer, error) {
+		n := 32
+		if opts.NumGPU >= 0 {
+			n = opts.NumGPU
+		}
+		layers = append(layers, n)
+
+		server := &mockLlm{estimatedVRAM: uint64(n), estimatedVRAMByGPU: map[string]uint64{}, gpuLayers: n}
+		if n > 20 {
+			server.waitResp = fmt.Errorf("%w: cudaMalloc failed", llm.ErrOutOfMemory)
+		}
+		return server, nil
+	}
+
+	s.load(req, ggml, gpu.GpuInfoList{}, 0)
+	select {
+	case err := <-req.errCh:
+		t.Fatalf("unexpected error %v", err)
+	case resp := <-req.successCh:
+		require.Equal(t, uint64(18), resp.estimatedVRAM)
+		require.Equal(t, 18, resp.llama.GPULayers())
+	}
+	require.Equal(t, []int{32, 24, 18}, layers)
+
+	// later loads start with the layers which fit
+	req.successCh = make(chan *runnerRef, 1)
+	s.load(req, ggml, gpu.GpuInfoList{}, 0)
+	resp := <-req.successCh
+	require.Equal(t, 18, resp.llama.GPULayers())
+	require.Equal(t, []int{32, 24, 18, 18}, layers)
+
+	t.Run("num_gpu", func(t *testing.T) {
+		layers = nil
+		req.opts.NumGPU = 30
+		req.successCh = make(chan *runnerRef, 1)
+		s.load(req, ggml, gpu.GpuInfoList{}, 0)
+		err := <-req.errCh
+		require.ErrorIs(t, err, llm.ErrOutOfMemory)
+		require.Equal(t, []int{30}, layers)
+	})
+}
+
+func TestOutOfMemory(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+
+	opts := api.DefaultOptions()
+	llama := &mockLlm{gpuLayers: 10}
+	runner := &runnerRef{modelPath: "foo", llama: llama, Options: &opts, sessionDuration: 5 * time.Minute, expireTimer: time.NewTimer(5 * time.Minute)}
+	s.loaded["foo"] = runner
+
+	s.outOfMemory(llama)
+	layers, ok := s.fit(fitKey{"foo", opts.NumCtx})
+	require.True(t, ok)
+	require.Equal(t, 7, layers)
+	require.Zero(t, runner.sessionDuration)
+	require.Nil(t, runner.expireTimer)
+
+	t.Run("num_gpu", func(t *testing.T) {
+		opts := api.DefaultOptions()
+		opts.NumGPU = 10
+		llama := &mockLlm{gpuLayers: 10}
+		s.loaded["bar"] = &runnerRef{modelPath: "bar", llama: llama, Options: &opts, sessionDuration: 5 * time.Minute}
+
+		s.outOfMemory(llama)
+		_, ok := s.fit(fitKey{"bar", opts.NumCtx})
+		require.False(t, ok)
+		require.Equal(t, 5*time.Minute, s.loaded["bar"].sessionDuration)
+	})
+
+	t.Run("unload", func(t *testing.T) {
+		s.forgetFits("foo")
+		_, ok := s.fit(fitKey{"foo", opts.NumCtx})
+		require.True(t, ok)
+
+		s.forgetFits("bar")
+		_, ok = s.fit(fitKey{"foo", opts.NumCtx})
+		require.False(t, ok)
+	})
+}
+
+func TestCrashed(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.restartDelay = time.Millisecond
+
+	opts := api.DefaultOptions()
+	opts.NumCtx = 8192
+	llama := &mockLlm{}
+	model := &Model{ModelPath: "foo", ShortName: "foo:latest"}
+	runner := &runnerRef{model: model, modelPath: "foo", llama: llama, Options: &opts, numParallel: 4, sessionDuration: 5 * time.Minute, expireTimer: time.NewTimer(5 * time.Minute)}
+	s.loaded["foo"] = runner
+
+	crash := &llm.CrashError{ExitStatus: "signal: segmentation fault"}
+	require.Equal(t, 1, s.crashed(llama, crash))
+	require.Zero(t, runner.sessionDuration)
+	require.Nil(t, runner.expireTimer)
+
+	// other requests failing with the same crash don't count again
+	require.Equal(t, 1, s.crashed(llama, crash))
+	require.Equal(t, 1, s.crashCount("foo"))
+
+	select {
+	case req := <-s.pendingReqCh:
+		require.Equal(t, model, req.model)
+		require.Equal(t, 2048, req.opts.NumCtx)
+		require.Equal(t, 5*time.Minute, req.sessionDuration.Duration)
+		req.errCh <- errors.New("done")
+	case <-ctx.Done():
+		t.Fatal("runner wasn't restarted")
+	}
+
+	t.Run("keep_alive 0", func(t *testing.T) {
+		llama := &mockLlm{}
+		s.loaded["bar"] = &runnerRef{model: &Model{ModelPath: "bar"}, modelPath: "bar", llama: llama, Options: &opts}
+
+		require.Equal(t, 1, s.crashed(llama, crash))
+		select {
+		case <-s.pendingReqCh:
+			t.Fatal("runner was restarted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+
+	t.Run("too many crashes", func(t *testing.T) {
+		for range maxCrashRestarts {
+			s.recordCrash("baz")
+		}
+
+		llama := &mockLlm{}
+		s.loaded["baz"] = &runnerRef{model: &Model{ModelPath: "baz"}, modelPath: "baz", llama: llama, Options: &opts, sessionDuration: 5 * time.Minute}
+
+		require.Equal(t, maxCrashRestarts+1, s.crashed(llama, crash))
+		select {
+		case <-s.pendingReqCh:
+			t.Fatal("runner was restarted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+}
+
+func TestRestartBackoff(t *testing.T) {
+	s := InitScheduler(context.Background())
+	for recent, expect := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 6: 32 * time.Second, 7: time.Minute, 20: time.Minute} {
+		require.Equal(t, expect, s.restartBackoff(recent), recent)
+	}
+}
+
+func TestFewerLayers(t *testing.T) {
+	for n, expect := range map[int]int{1: 0, 2: 1, 3: 2, 4: 3, 10: 7, 32: 24} {
+		require.Equal(t, expect, fewerLayers(n), n)
+	}
+}
+
 type reqBundle struct {
 	ctx     context.Context //nolint:containedctx
 	ctxDone func()
@@ -102,7 +357,7 @@ type reqBundle struct {
 	ggml    *llm.GGML
 }
 
-func (scenario *reqBundle) newServer(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+func (scenario *reqBundle) newServer(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, vocoder string, opts api.Options, numParallel int, runnerLog llm.RunnerLog) (llm.LlamaServer, error) {
 	return scenario.srv, nil
 }
 
@@ -209,6 +464,49 @@ func TestRequestsSameModelSameRequest(t *testing.T) {
 	}
 }
 
+func TestRequestsSameModelCPU(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, &api.Duration{Duration: 5 * time.Millisecond})
+	b := newScenarioRequest(t, ctx, "ollama-model-1", 10, &api.Duration{Duration: 5 * time.Millisecond})
+	b.req.model = a.req.model
+	b.ggml = a.ggml
+	b.req.opts.NumGPU = 0
+
+	s.newServerFn = a.newServer
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+	select {
+	case resp := <-a.req.successCh:
+		require.Equal(t, resp.llama, a.srv)
+		require.Empty(t, a.req.errCh)
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// CPU only request gets its own runner without evicting the first
+	s.newServerFn = b.newServer
+	s.pendingReqCh <- b.req
+	select {
+	case resp := <-b.req.successCh:
+		require.Equal(t, resp.llama, b.srv)
+		require.Empty(t, b.req.errCh)
+	case err := <-b.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.loadedMu.Lock()
+	require.Len(t, s.loaded, 2)
+	s.loadedMu.Unlock()
+}
+
 func TestRequestsSimpleReloadSameModel(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer done()
@@ -367,10 +665,10 @@ func TestGetRunner(t *testing.T) {
 	s.getCpuFn = getCpuFn
 	s.newServerFn = a.newServer
 	slog.Info("a")
-	successCh1a, errCh1a := s.GetRunner(a.ctx, a.req.model, a.req.opts, a.req.sessionDuration)
+	successCh1a, errCh1a := s.GetRunner(a.ctx, a.req.model, a.req.opts, a.req.sessionDuration, nil)
 	require.Len(t, s.pendingReqCh, 1)
 	slog.Info("b")
-	successCh1b, errCh1b := s.GetRunner(b.ctx, b.req.model, b.req.opts, b.req.sessionDuration)
+	successCh1b, errCh1b := s.GetRunner(b.ctx, b.req.model, b.req.opts, b.req.sessionDuration, nil)
 	require.Len(t, s.pendingReqCh, 1)
 	require.Empty(t, successCh1b)
 	require.Len(t, errCh1b, 1)
@@ -394,7 +692,7 @@ func TestGetRunner(t *testing.T) {
 
 	c.req.model.ModelPath = "bad path"
 	slog.Info("c")
-	successCh1c, errCh1c := s.GetRunner(c.ctx, c.req.model, c.req.opts, c.req.sessionDuration)
+	successCh1c, errCh1c := s.GetRunner(c.ctx, c.req.model, c.req.opts, c.req.sessionDuration, nil)
 	// Starts in pending channel, then should be quickly processsed to return an error
 	time.Sleep(20 * time.Millisecond) // Long enough for the "a" model to expire and unload
 	require.Empty(t, successCh1c)
@@ -407,6 +705,91 @@ func TestGetRunner(t *testing.T) {
 	b.ctxDone()
 }
 
+func TestGetRunnerQueueTimeout(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1a", 10, &api.Duration{Duration: 2 * time.Millisecond})
+	b := newScenarioRequest(t, ctx, "ollama-model-1b", 10, &api.Duration{Duration: 2 * time.Millisecond})
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	s.newServerFn = a.newServer
+
+	// The scheduler isn't running yet so both requests stay queued
+	successCh1a, errCh1a := s.GetRunner(a.ctx, a.req.model, a.req.opts, a.req.sessionDuration, nil)
+	_, errCh1b := s.GetRunner(b.ctx, b.req.model, b.req.opts, b.req.sessionDuration, &api.Duration{Duration: 5 * time.Millisecond})
+	require.Len(t, s.pendingReqCh, 2)
+
+	var queueErr *llm.QueueTimeoutError
+	select {
+	case err := <-errCh1b:
+		require.ErrorAs(t, err, &queueErr)
+		require.Equal(t, 2, queueErr.Position)
+		require.Equal(t, 5*time.Millisecond, queueErr.Timeout)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.Run(ctx)
+	select {
+	case resp := <-successCh1a:
+		require.Equal(t, resp.llama, a.srv)
+	case err := <-errCh1a:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// The timed out request is dropped rather than scheduled
+	require.Eventually(t, func() bool { return len(s.pendingReqCh) == 0 }, 50*time.Millisecond, time.Millisecond)
+	s.loadedMu.Lock()
+	require.Len(t, s.loaded, 1)
+	s.loadedMu.Unlock()
+	a.ctxDone()
+	b.ctxDone()
+}
+
+func TestRequeueQueueTimeout(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1a", 10, &api.Duration{Duration: 2 * time.Millisecond})
+	s := InitScheduler(ctx)
+	s.reschedDelay = time.Millisecond
+
+	_, errCh := s.GetRunner(a.ctx, a.req.model, a.req.opts, a.req.sessionDuration, &api.Duration{Duration: 5 * time.Millisecond})
+	pending := <-s.pendingReqCh
+
+	// the queue timeout fires while the scheduler holds the request
+	require.True(t, pending.state.CompareAndSwap(requestQueued, requestScheduling))
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, errCh)
+
+	s.requeue(pending)
+
+	var queueErr *llm.QueueTimeoutError
+	select {
+	case err := <-errCh:
+		require.ErrorAs(t, err, &queueErr)
+		require.Equal(t, 5*time.Millisecond, queueErr.Timeout)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+	require.Empty(t, s.pendingReqCh)
+
+	// cancelled requests aren't requeued either
+	b := newScenarioRequest(t, ctx, "ollama-model-1b", 10, &api.Duration{Duration: 2 * time.Millisecond})
+	s.GetRunner(b.ctx, b.req.model, b.req.opts, b.req.sessionDuration, nil)
+	pending = <-s.pendingReqCh
+	require.True(t, pending.state.CompareAndSwap(requestQueued, requestScheduling))
+	b.ctxDone()
+
+	s.requeue(pending)
+	require.Empty(t, s.pendingReqCh)
+	a.ctxDone()
+}
+
 // TODO - add one scenario that triggers the bogus finished event with positive ref count
 func TestPrematureExpired(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
@@ -422,7 +805,7 @@ func TestPrematureExpired(t *testing.T) {
 		return []gpu.GpuInfo{g}
 	}
 	s.newServerFn = scenario1a.newServer
-	successCh1a, errCh1a := s.GetRunner(scenario1a.ctx, scenario1a.req.model, scenario1a.req.opts, scenario1a.req.sessionDuration)
+	successCh1a, errCh1a := s.GetRunner(scenario1a.ctx, scenario1a.req.model, scenario1a.req.opts, scenario1a.req.sessionDuration, nil)
 	require.Len(t, s.pendingReqCh, 1)
 	s.Run(ctx)
 	select {
@@ -569,6 +952,153 @@ func TestFindRunnerToUnload(t *testing.T) {
 	require.Equal(t, r1, resp)
 }
 
+func TestFindRunnerToUnloadOn(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	gpus := gpu.GpuInfoList{{Library: "cuda", ID: "0"}, {Library: "cuda", ID: "1"}}
+	r1 := &runnerRef{gpus: gpus[:1], sessionDuration: 1, numParallel: 1}
+	r2 := &runnerRef{gpus: gpus[1:], refCount: 1, sessionDuration: 2, numParallel: 1}
+
+	s := InitScheduler(ctx)
+	s.loadedMu.Lock()
+	s.loaded["a"] = r1
+	s.loaded["b"] = r2
+	s.loadedMu.Unlock()
+
+	// the idle runner on the other GPU is left alone
+	require.Equal(t, r2, s.findRunnerToUnloadOn(gpus[1:]))
+	require.Equal(t, r1, s.findRunnerToUnloadOn(gpus[:1]))
+
+	// without a runner on the GPU none is unloaded
+	require.Nil(t, s.findRunnerToUnloadOn(gpu.GpuInfoList{{Library: "rocm", ID: "0"}}))
+}
+
+func TestPickRunnerToUnloadPolicy(t *testing.T) {
+	now := time.Now()
+
+	// a large model used once an hour ago and a small one used often
+	large := &runnerRef{modelPath: "large", estimatedVRAM: 40 * format.GibiByte, rate: 1, lastUsed: now.Add(-time.Hour)}
+	small := &runnerRef{modelPath: "small", estimatedVRAM: 2 * format.GibiByte, rate: 20, lastUsed: now.Add(-time.Minute)}
+	require.Equal(t, large, pickRunnerToUnload([]*runnerRef{small, large}))
+
+	// a small model which is rarely used goes before a large busy one
+	small.rate, small.lastUsed = 1, now.Add(-2*time.Hour)
+	large.rate, large.lastUsed = 50, now
+	require.Equal(t, small, pickRunnerToUnload([]*runnerRef{small, large}))
+
+	// sticky models are kept unless every model is sticky
+	small.sticky = true
+	require.Equal(t, large, pickRunnerToUnload([]*runnerRef{small, large}))
+	large.sticky = true
+	require.Equal(t, small, pickRunnerToUnload([]*runnerRef{small, large}))
+
+	// idle models go before busy ones
+	small.sticky, large.sticky = false, false
+	small.refCount = 1
+	require.Equal(t, large, pickRunnerToUnload([]*runnerRef{small, large}))
+
+	// least recently used breaks ties
+	a := &runnerRef{modelPath: "a", lastUsed: now.Add(-time.Minute)}
+	b := &runnerRef{modelPath: "b", lastUsed: now.Add(-time.Hour)}
+	require.Equal(t, b, pickRunnerToUnload([]*runnerRef{a, b}))
+}
+
+func TestRunnerRate(t *testing.T) {
+	now := time.Now()
+	var r runnerRef
+	r.used(now)
+	r.used(now)
+	require.InDelta(t, 2, r.rateAt(now), 1e-9)
+	require.InDelta(t, 1, r.rateAt(now.Add(evictionRateHalfLife)), 1e-9)
+
+	r.used(now.Add(2 * evictionRateHalfLife))
+	require.InDelta(t, 1.5, r.rate, 1e-9)
+}
+
+func TestRequestSticky(t *testing.T) {
+	req := &LlmRequest{model: &Model{Name: "registry.ollama.ai/library/llama3:latest"}, opts: api.DefaultOptions()}
+	require.False(t, req.sticky())
+
+	t.Setenv("OLLAMA_STICKY_MODELS", "mistral, llama3")
+	require.True(t, req.sticky())
+
+	t.Setenv("OLLAMA_STICKY_MODELS", "")
+	req.model.Options = map[string]any{"sticky": true}
+	require.True(t, req.sticky())
+
+	// requests can't make a model sticky
+	req.model.Options = nil
+	req.opts.Sticky = true
+	require.False(t, req.sticky())
+}
+
+func TestRequestWarmup(t *testing.T) {
+	req := &LlmRequest{model: &Model{Name: "registry.ollama.ai/library/llama3:latest"}, opts: api.DefaultOptions()}
+	require.False(t, req.warmup())
+
+	t.Setenv("OLLAMA_WARMUP_MODELS", "mistral, llama3")
+	require.True(t, req.warmup())
+
+	t.Setenv("OLLAMA_WARMUP_MODELS", "")
+	req.opts.Warmup = true
+	require.True(t, req.warmup())
+}
+
+func TestRequestNumParallel(t *testing.T) {
+	req := &LlmRequest{model: &Model{Name: "registry.ollama.ai/library/llama3:latest"}, opts: api.DefaultOptions()}
+	require.Zero(t, req.numParallel())
+
+	t.Setenv("OLLAMA_NUM_PARALLEL", "4")
+	require.Equal(t, 4, req.numParallel())
+
+	req.opts.NumParallel = 16
+	require.Equal(t, 16, req.numParallel())
+}
+
+func TestRequestMaxTokenRate(t *testing.T) {
+	req := &LlmRequest{model: &Model{Name: "registry.ollama.ai/library/summarizer:latest"}, opts: api.DefaultOptions()}
+	require.Zero(t, req.maxTokenRate())
+
+	t.Setenv("OLLAMA_MAX_TOKEN_RATE", "llama3=40;summarizer=10")
+	require.InDelta(t, 10, req.maxTokenRate(), 1e-6)
+
+	t.Setenv("OLLAMA_MAX_TOKEN_RATE", "llama3=40")
+	require.Zero(t, req.maxTokenRate())
+}
+
+func TestWarmup(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	llama := &mockLlm{}
+	opts := api.DefaultOptions()
+	warmup(ctx, llama, &Model{ModelPath: filepath.Join(t.TempDir(), "missing")}, opts)
+	require.NotNil(t, llama.completionReq)
+	require.Equal(t, warmupPrompt, llama.completionReq.Prompt)
+	require.Equal(t, 1, llama.completionReq.Options.NumPredict)
+	require.Equal(t, -1, opts.NumPredict)
+
+	// failing to warm up doesn't fail loading
+	llama = &mockLlm{completionResp: errors.New("warmup failed")}
+	warmup(ctx, llama, &Model{ModelPath: filepath.Join(t.TempDir(), "missing")}, opts)
+	require.NotNil(t, llama.completionReq)
+}
+
+func TestRequestRunnerLog(t *testing.T) {
+	t.Setenv("OLLAMA_DEBUG", "")
+	req := &LlmRequest{model: &Model{Name: "registry.ollama.ai/library/llama3:latest", ShortName: "llama3:latest"}}
+	require.Equal(t, llm.RunnerLog{Level: "info"}, req.runnerLog())
+
+	t.Setenv("OLLAMA_RUNNER_LOG_LEVEL", "warn;mistral=error")
+	require.Equal(t, llm.RunnerLog{Level: "warn"}, req.runnerLog())
+
+	t.Setenv("OLLAMA_RUNNER_LOG_LEVEL", "warn;llama3=debug")
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_RUNNER_LOG_DIR", dir)
+	require.Equal(t, llm.RunnerLog{Level: "debug", File: filepath.Join(dir, "llama3-latest.log")}, req.runnerLog())
+}
+
 func TestNeedsReload(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer done()
@@ -684,9 +1214,9 @@ func TestHomogeneousGPUs(t *testing.T) {
 	}
 	s.getCpuFn = getCpuFn
 	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, &api.Duration{Duration: 5 * time.Millisecond})
-	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, vocoder string, opts api.Options, numParallel int, runnerLog llm.RunnerLog) (llm.LlamaServer, error) {
 		require.Len(t, gpus, 1)
-		return a.newServer(gpus, model, ggml, adapters, projectors, opts, numParallel)
+		return a.newServer(gpus, model, ggml, adapters, projectors, vocoder, opts, numParallel, runnerLog)
 	}
 	slog.Info("a")
 	s.pendingReqCh <- a.req
@@ -704,10 +1234,88 @@ func TestHomogeneousGPUs(t *testing.T) {
 	}
 }
 
+func TestGPUPolicy(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	// an old GPU with more free memory than a newer one
+	gpus := gpu.GpuInfoList{
+		{Library: "cuda", ID: "GPU-1070", Compute: "6.1"},
+		{Library: "cuda", ID: "GPU-4090", Compute: "8.9"},
+	}
+	gpus[0].TotalMemory, gpus[0].FreeMemory = 8*format.GibiByte, 8*format.GibiByte
+	gpus[1].TotalMemory, gpus[1].FreeMemory = 24*format.GibiByte, 2*format.GibiByte
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	a.req.origNumCtx = a.req.opts.NumCtx
+
+	for policy, expect := range map[string]string{"": "GPU-1070", "fastest": "GPU-4090"} {
+		t.Run(policy, func(t *testing.T) {
+			t.Setenv("OLLAMA_GPU_POLICY", policy)
+			numParallel := 1
+			fit := pickBestFullFitByLibrary(a.req, a.ggml, gpus, &numParallel)
+			require.Len(t, fit, 1)
+			require.Equal(t, expect, fit[0].ID)
+		})
+	}
+
+	t.Run("min memory", func(t *testing.T) {
+		for minimum, expect := range map[string][]string{
+			"":      {"GPU-1070", "GPU-4090"},
+			"10GB":  {"GPU-4090"},
+			"25%":   {"GPU-1070", "GPU-4090"},
+			"50%":   {"GPU-4090"},
+			"100GB": nil,
+		} {
+			t.Setenv("OLLAMA_GPU_MIN_MEMORY", minimum)
+			var ids []string
+			for _, g := range excludeSmallGPUs(gpus) {
+				ids = append(ids, g.ID)
+			}
+			require.Equal(t, expect, ids, minimum)
+		}
+	})
+}
+
+func TestExplicitLayoutKeepsSmallGPUs(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+
+	t.Setenv("OLLAMA_GPU_MIN_MEMORY", "10GB")
+
+	s := InitScheduler(ctx)
+	s.getGpuFn = func() gpu.GpuInfoList {
+		gpus := gpu.GpuInfoList{{Library: "cuda", ID: "GPU-1070"}, {Library: "cuda", ID: "GPU-4090"}}
+		gpus[0].TotalMemory, gpus[0].FreeMemory = 8*format.GibiByte, 8*format.GibiByte
+		gpus[1].TotalMemory, gpus[1].FreeMemory = 24*format.GibiByte, 24*format.GibiByte
+		return gpus
+	}
+	s.getCpuFn = getCpuFn
+
+	loaded := make(chan gpu.GpuInfoList, 1)
+	s.loadFn = func(req *LlmRequest, _ *llm.GGML, gpus gpu.GpuInfoList, _ int) {
+		loaded <- gpus
+		req.errCh <- errors.New("not loaded")
+	}
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	a.req.opts.TensorSplit = "1,1"
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+
+	select {
+	case gpus := <-loaded:
+		require.Len(t, gpus, 2)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+}
+
 type mockLlm struct {
 	pingResp           error
 	waitResp           error
 	completionResp     error
+	completionReq      *llm.CompletionRequest
 	embeddingResp      []float32
 	embeddingRespErr   error
 	tokenizeResp       []int
@@ -719,11 +1327,29 @@ type mockLlm struct {
 	estimatedVRAM      uint64
 	estimatedTotal     uint64
 	estimatedVRAMByGPU map[string]uint64
+	gpuLayers          int
+	loadTimeout        time.Duration
+	slotsResp          []llm.SlotStatus
+	slotsRespErr       error
+	slotsBlock         bool
+	waiting            int
 }
 
-func (s *mockLlm) Ping(ctx context.Context) error             { return s.pingResp }
-func (s *mockLlm) WaitUntilRunning(ctx context.Context) error { return s.waitResp }
+func (s *mockLlm) Ping(ctx context.Context) error { return s.pingResp }
+func (s *mockLlm) Slots(ctx context.Context) ([]llm.SlotStatus, error) {
+	if s.slotsBlock {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.slotsResp, s.slotsRespErr
+}
+func (s *mockLlm) Waiting() int { return s.waiting }
+func (s *mockLlm) WaitUntilRunning(ctx context.Context, timeout time.Duration) error {
+	s.loadTimeout = timeout
+	return s.waitResp
+}
 func (s *mockLlm) Completion(ctx context.Context, req llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+	s.completionReq = &req
 	return s.completionResp
 }
 
@@ -731,6 +1357,10 @@ func (s *mockLlm) Embedding(ctx context.Context, input string) ([]float32, error
 	return s.embeddingResp, s.embeddingRespErr
 }
 
+func (s *mockLlm) Vocode(ctx context.Context, codes []int) ([]float32, error) {
+	return nil, nil
+}
+
 func (s *mockLlm) Tokenize(ctx context.Context, content string) ([]int, error) {
 	return s.tokenizeResp, s.tokenizeRespErr
 }
@@ -746,3 +1376,10 @@ func (s *mockLlm) Close() error {
 func (s *mockLlm) EstimatedVRAM() uint64                  { return s.estimatedVRAM }
 func (s *mockLlm) EstimatedTotal() uint64                 { return s.estimatedTotal }
 func (s *mockLlm) EstimatedVRAMByGPU(gpuid string) uint64 { return s.estimatedVRAMByGPU[gpuid] }
+func (s *mockLlm) EstimatedKVCache() uint64               { return 0 }
+func (s *mockLlm) KVCacheType() string                    { return "f16" }
+func (s *mockLlm) Attention() string                      { return llm.AttentionStandard }
+func (s *mockLlm) BatchSize() (int, int)                  { return 512, 512 }
+func (s *mockLlm) CUDAGraphs() bool                       { return false }
+func (s *mockLlm) HostMemory() (bool, bool, bool)         { return true, false, false }
+func (s *mockLlm) GPULayers() int                         { return s.gpuLayers }
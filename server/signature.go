@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+var errSignature = errors.New("signature verification failed")
+
+const (
+	mediaTypeCosignPayload          = "application/vnd.dev.cosign.simplesigning.v1+json"
+	annotationCosignSignature       = "dev.cosignproject.cosign/signature"
+	cosignSignatureType             = "cosign container image signature"
+	maxCosignPayloadSize      int64 = 1 << 20
+)
+
+// trustPolicy is the policy set with OLLAMA_TRUST_POLICY of the signers
+// whose signatures models must have. Scopes are a registry, a registry and
+// namespace, or a registry, namespace and repository, such as
+// registry.example.com/team, or * for every model. The most specific scope
+// of a model applies to it.
+type trustPolicy struct {
+	Policies map[string]struct {
+		// Signers are the paths of the PEM encoded public keys, relative to
+		// the policy, which must all have signed models in the scope
+		Signers []string `json:"signers"`
+	} `json:"policies"`
+
+	dir string
+}
+
+// loadTrustPolicy reads the policy set with OLLAMA_TRUST_POLICY, or returns
+// nil if none is set
+func loadTrustPolicy() (*trustPolicy, error) {
+	p := envconfig.TrustPolicy()
+	if p == "" {
+		return nil, nil
+	}
+
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("trust policy: %w", err)
+	}
+
+	var policy trustPolicy
+	if err := json.Unmarshal(bts, &policy); err != nil {
+		return nil, fmt.Errorf("trust policy %s: %w", p, err)
+	}
+
+	policy.dir = filepath.Dir(p)
+	return &policy, nil
+}
+
+// signers returns the paths of the signers which must have signed the model
+// at mp
+func (tp *trustPolicy) signers(mp ModelPath) []string {
+	for _, scope := range []string{
+		mp.Registry + "/" + mp.Namespace + "/" + mp.Repository,
+		mp.Registry + "/" + mp.Namespace,
+		mp.Registry,
+		"*",
+	} {
+		if policy, ok := tp.Policies[scope]; ok {
+			signers := make([]string, len(policy.Signers))
+			for i, signer := range policy.Signers {
+				signers[i] = signer
+				if !filepath.IsAbs(signer) {
+					signers[i] = filepath.Join(tp.dir, signer)
+				}
+			}
+
+			return signers
+		}
+	}
+
+	return nil
+}
+
+func loadPublicKey(p string) (crypto.PublicKey, error) {
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(bts)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM encoded public key", p)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+
+	return key, nil
+}
+
+// cosignSignature is a signature cosign attached to a manifest
+type cosignSignature struct {
+	payload   []byte
+	signature []byte
+}
+
+// verify reports whether s is a signature by key of the manifest with
+// digest of the model at mp
+func (s cosignSignature) verify(key crypto.PublicKey, mp ModelPath, digest string) bool {
+	sha256sum := sha256.Sum256(s.payload)
+
+	var ok bool
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, sha256sum[:], s.signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, s.payload, s.signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, sha256sum[:], s.signature) == nil
+	}
+
+	if !ok {
+		return false
+	}
+
+	// the payload is only trusted once its signature is
+	var payload struct {
+		Critical struct {
+			Identity struct {
+				DockerReference string `json:"docker-reference"`
+			} `json:"identity"`
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+	}
+
+	if err := json.Unmarshal(s.payload, &payload); err != nil {
+		return false
+	}
+
+	return payload.Critical.Type == cosignSignatureType &&
+		payload.Critical.Image.DockerManifestDigest == digest &&
+		payload.Critical.Identity.DockerReference == mp.Registry+"/"+mp.GetNamespaceRepository()
+}
+
+// pullSignatures pulls the cosign signatures of the manifest with digest,
+// which cosign attaches to the repository with the tag sha256-<hex>.sig
+func pullSignatures(ctx context.Context, mp ModelPath, digest string, regOpts *registryOptions) ([]cosignSignature, error) {
+	repository := mp.GetNamespaceRepository()
+	requestURL := mp.PullURL().JoinPath("v2", repository, "manifests", strings.Replace(digest, ":", "-", 1)+".sig")
+
+	headers := make(http.Header)
+	headers.Set("Accept", strings.Join([]string{mediaTypeDockerManifest, mediaTypeOCIManifest}, ", "))
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var m struct {
+		Layers []struct {
+			MediaType   string            `json:"mediaType"`
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"layers"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, err
+	}
+
+	var signatures []cosignSignature
+	for _, layer := range m.Layers {
+		if layer.MediaType != mediaTypeCosignPayload {
+			continue
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[annotationCosignSignature])
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %w", layer.Digest, err)
+		}
+
+		resp, err := makeRequestWithRetry(ctx, http.MethodGet, mp.PullURL().JoinPath("v2", repository, "blobs", layer.Digest), nil, nil, regOpts)
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %w", layer.Digest, err)
+		}
+
+		payload, err := io.ReadAll(io.LimitReader(resp.Body, maxCosignPayloadSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %w", layer.Digest, err)
+		}
+
+		if fmt.Sprintf("sha256:%x", sha256.Sum256(payload)) != layer.Digest {
+			return nil, fmt.Errorf("signature %s: %w", layer.Digest, errDigestMismatch)
+		}
+
+		signatures = append(signatures, cosignSignature{payload: payload, signature: signature})
+	}
+
+	return signatures, nil
+}
+
+// verifySignatures checks that the manifest m of the model at mp has been
+// signed by every signer the trust policy requires for it
+func verifySignatures(ctx context.Context, mp ModelPath, m *Manifest, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
+	policy, err := loadTrustPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+
+	signers := policy.signers(mp)
+	if len(signers) == 0 {
+		return nil
+	}
+
+	fn(api.ProgressResponse{Status: "verifying signatures"})
+
+	digest := "sha256:" + m.digest
+	signatures, err := pullSignatures(ctx, mp, digest, regOpts)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s isn't signed", errSignature, mp.GetShortTagname())
+	} else if err != nil {
+		return fmt.Errorf("%w: %s: %w", errSignature, mp.GetShortTagname(), err)
+	}
+
+	for _, signer := range signers {
+		key, err := loadPublicKey(signer)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errSignature, err)
+		}
+
+		var signed bool
+		for _, s := range signatures {
+			if s.verify(key, mp, digest) {
+				signed = true
+				break
+			}
+		}
+
+		if !signed {
+			return fmt.Errorf("%w: %s isn't signed by %s", errSignature, mp.GetShortTagname(), filepath.Base(signer))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestVerifySignatures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	f := fakeOCIRegistry{
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string]*bytes.Buffer),
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+	}
+
+	ts := httptest.NewServer(&f)
+	defer ts.Close()
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	name := host + "/team/model:v1"
+
+	var s Server
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      name,
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	if err := PushModel(context.Background(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(f.manifests["team/model:v1"]))
+
+	// keys are written next to the policy, which refers to them by name
+	dir := t.TempDir()
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, signer := range []string{"release", "security", "other"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, signer+".pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		keys[signer] = key
+	}
+
+	// sign attaches signatures of the pushed manifest to it the way cosign
+	// does, as the layers of the manifest tagged sha256-<hex>.sig
+	sign := func(reference string, signers ...string) {
+		t.Helper()
+
+		var layers []map[string]any
+		for _, signer := range signers {
+			payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":{"signer":%q}}`, reference, digest, signer))
+			sha256sum := sha256.Sum256(payload)
+			signature, err := ecdsa.SignASN1(rand.Reader, keys[signer], sha256sum[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f.blobs[fmt.Sprintf("sha256:%x", sha256sum)] = payload
+			layers = append(layers, map[string]any{
+				"mediaType":   mediaTypeCosignPayload,
+				"digest":      fmt.Sprintf("sha256:%x", sha256sum),
+				"size":        len(payload),
+				"annotations": map[string]string{annotationCosignSignature: base64.StdEncoding.EncodeToString(signature)},
+			})
+		}
+
+		b, err := json.Marshal(map[string]any{"schemaVersion": 2, "mediaType": mediaTypeOCIManifest, "layers": layers})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tag := "team/model:" + strings.Replace(digest, ":", "-", 1) + ".sig"
+		f.manifests[tag], f.types[tag] = b, mediaTypeOCIManifest
+	}
+
+	policy := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "policy.json"), []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("OLLAMA_TRUST_POLICY", filepath.Join(dir, "policy.json"))
+
+	pull := func() error {
+		t.Helper()
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+		return PullModel(context.Background(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+	}
+
+	cases := []struct {
+		name    string
+		policy  string
+		sign    func()
+		invalid bool
+	}{
+		{
+			name:   "other scope",
+			policy: `{"policies": {"registry.example.com": {"signers": ["release.pub"]}}}`,
+		},
+		{
+			name:    "unsigned",
+			policy:  fmt.Sprintf(`{"policies": {"%s/team": {"signers": ["release.pub"]}}}`, host),
+			invalid: true,
+		},
+		{
+			name:   "signed",
+			policy: fmt.Sprintf(`{"policies": {"%s/team": {"signers": ["release.pub"]}}}`, host),
+			sign:   func() { sign(host+"/team/model", "release") },
+		},
+		{
+			name:    "wrong signer",
+			policy:  fmt.Sprintf(`{"policies": {"%s": {"signers": ["release.pub"]}}}`, host),
+			sign:    func() { sign(host+"/team/model", "other") },
+			invalid: true,
+		},
+		{
+			name:    "signature of another model",
+			policy:  `{"policies": {"*": {"signers": ["release.pub"]}}}`,
+			sign:    func() { sign(host+"/team/other", "release") },
+			invalid: true,
+		},
+		{
+			name:    "missing required signer",
+			policy:  fmt.Sprintf(`{"policies": {"%s/team/model": {"signers": ["release.pub", "security.pub"]}}}`, host),
+			sign:    func() { sign(host+"/team/model", "release", "other") },
+			invalid: true,
+		},
+		{
+			name:   "all required signers",
+			policy: fmt.Sprintf(`{"policies": {"%s/team/model": {"signers": ["release.pub", "security.pub"]}}}`, host),
+			sign:   func() { sign(host+"/team/model", "security", "release") },
+		},
+		{
+			name:   "more specific scope",
+			policy: fmt.Sprintf(`{"policies": {"*": {"signers": ["release.pub"]}, "%s/team": {"signers": []}}}`, host),
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for k := range f.manifests {
+				if strings.HasSuffix(k, ".sig") {
+					delete(f.manifests, k)
+				}
+			}
+
+			policy(tt.policy)
+			if tt.sign != nil {
+				tt.sign()
+			}
+
+			err := pull()
+			if tt.invalid {
+				if !errors.Is(err, errSignature) {
+					t.Fatalf("expected a signature error, got %v", err)
+				}
+
+				if _, err := ParseNamedManifest(model.ParseName(name)); err == nil {
+					t.Error("expected the model not to be pulled")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// policies which can't be read fail pulls
+	policy(`{"policies": `)
+	if err := pull(); err == nil || !strings.Contains(err.Error(), "trust policy") {
+		t.Errorf("expected a trust policy error, got %v", err)
+	}
+}
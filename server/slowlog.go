@@ -0,0 +1,66 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+)
+
+// requestTiming is when a completion reached each stage, to log it if it was
+// slow
+type requestTiming struct {
+	start      time.Time // the request was received
+	scheduled  time.Time // it got a runner, once the model was loaded
+	firstToken time.Time // the runner responded with the first token
+}
+
+// token records a response from the runner
+func (t *requestTiming) token(cr llm.CompletionResponse) {
+	if t.firstToken.IsZero() && (cr.Content != "" || cr.Done) {
+		t.firstToken = time.Now()
+	}
+}
+
+// slow reports whether a request which took total and firstToken to respond
+// with its first token exceeded OLLAMA_SLOW_REQUEST_THRESHOLD or
+// OLLAMA_SLOW_FIRST_TOKEN_THRESHOLD
+func slow(total, firstToken time.Duration) bool {
+	if threshold := envconfig.SlowRequestThreshold(); threshold > 0 && total > threshold {
+		return true
+	}
+
+	if threshold := envconfig.SlowFirstTokenThreshold(); threshold > 0 && firstToken > threshold {
+		return true
+	}
+
+	return false
+}
+
+// logIfSlow logs a warning about the completion of request c by model, with
+// the options the client set, if it was slow. cr is the final response.
+func (t *requestTiming) logIfSlow(c *gin.Context, model string, options map[string]any, cr llm.CompletionResponse) {
+	total := time.Since(t.start)
+	firstToken := t.firstToken.Sub(t.start)
+	if !slow(total, firstToken) {
+		return
+	}
+
+	slog.WarnContext(c.Request.Context(), "slow request",
+		"model", model,
+		"path", c.Request.URL.Path,
+		"duration", total,
+		"first_token", firstToken,
+		"queue", t.scheduled.Sub(t.start),
+		"prompt_tokens", cr.PromptEvalCount,
+		"prompt_cached", cr.PromptCacheCount,
+		"prompt_duration", cr.PromptEvalDuration,
+		"eval_tokens", cr.EvalCount,
+		"eval_duration", cr.EvalDuration,
+		"options", options,
+		"client", c.ClientIP(),
+		"user_agent", c.Request.UserAgent())
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ollama/ollama/llm"
+)
+
+func TestSlow(t *testing.T) {
+	assert.False(t, slow(time.Hour, time.Hour), "disabled by default")
+
+	t.Setenv("OLLAMA_SLOW_REQUEST_THRESHOLD", "30s")
+	assert.False(t, slow(10*time.Second, 5*time.Second))
+	assert.True(t, slow(time.Minute, 5*time.Second))
+
+	t.Setenv("OLLAMA_SLOW_FIRST_TOKEN_THRESHOLD", "2s")
+	assert.True(t, slow(10*time.Second, 5*time.Second))
+	assert.False(t, slow(10*time.Second, time.Second))
+}
+
+func TestRequestTiming(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	timing := requestTiming{start: start, scheduled: start.Add(10 * time.Second)}
+
+	timing.token(llm.CompletionResponse{})
+	assert.True(t, timing.firstToken.IsZero(), "empty responses aren't tokens")
+
+	timing.token(llm.CompletionResponse{Content: "Hello"})
+	first := timing.firstToken
+	assert.False(t, first.IsZero())
+
+	timing.token(llm.CompletionResponse{Content: " world"})
+	assert.Equal(t, first, timing.firstToken)
+
+	var b bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&b, nil)))
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+	cr := llm.CompletionResponse{Done: true, PromptEvalCount: 4096, EvalCount: 12}
+
+	timing.logIfSlow(c, "llama3:latest", map[string]any{"num_ctx": 8192}, cr)
+	assert.Empty(t, b.String())
+
+	t.Setenv("OLLAMA_SLOW_REQUEST_THRESHOLD", "30s")
+	timing.logIfSlow(c, "llama3:latest", map[string]any{"num_ctx": 8192}, cr)
+	assert.Contains(t, b.String(), `msg="slow request" model=llama3:latest path=/api/generate`)
+	assert.Contains(t, b.String(), "queue=10s prompt_tokens=4096")
+	assert.Contains(t, b.String(), "options=map[num_ctx:8192]")
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+// maxSpeechTokens limits the audio generated for a sentence when the model
+// doesn't set num_predict
+const maxSpeechTokens = 4096
+
+var sentenceEnd = regexp.MustCompile(`[.!?;:]+\s+|\n+`)
+
+// speechSentences splits text into sentences which are synthesized and
+// streamed one at a time. Sentences without words are dropped.
+func speechSentences(text string) []string {
+	var sentences []string
+	for _, s := range sentenceEnd.Split(text, -1) {
+		if s := llm.SpeechText(s); s != "" {
+			sentences = append(sentences, s)
+		}
+	}
+
+	return sentences
+}
+
+// wavHeader returns the header of a 16-bit mono WAV stream. The stream's
+// length isn't known up front so the chunk sizes are set to their maximum,
+// which players treat as "until the end of the stream".
+func wavHeader(sampleRate int) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(math.MaxUint32))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))           // fmt chunk size
+	binary.Write(&b, binary.LittleEndian, uint16(1))            // PCM
+	binary.Write(&b, binary.LittleEndian, uint16(1))            // channels
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate))   // sample rate
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate*2)) // byte rate
+	binary.Write(&b, binary.LittleEndian, uint16(2))            // block align
+	binary.Write(&b, binary.LittleEndian, uint16(16))           // bits per sample
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(math.MaxUint32))
+	return b.Bytes()
+}
+
+// writePCM16 writes samples as 16-bit little-endian PCM, clipping samples
+// outside of [-1, 1]
+func writePCM16(w io.Writer, samples []float32) error {
+	pcm := make([]int16, len(samples))
+	for i, s := range samples {
+		pcm[i] = int16(max(-1, min(1, s)) * math.MaxInt16)
+	}
+
+	return binary.Write(w, binary.LittleEndian, pcm)
+}
+
+// speak generates the audio codes of one sentence and decodes them into
+// audio samples
+func speak(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, text string) ([]float32, error) {
+	var b bytes.Buffer
+	if err := m.Template.Execute(&b, template.Values{Messages: []api.Message{{Role: "user", Content: text}}}); err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	if err := r.Completion(ctx, llm.CompletionRequest{
+		Prompt:  b.String(),
+		Options: opts,
+	}, func(cr llm.CompletionResponse) {
+		sb.WriteString(cr.Content)
+	}); err != nil {
+		return nil, err
+	}
+
+	codes := llm.AudioCodes(sb.String())
+	if len(codes) == 0 {
+		return nil, errors.New("model generated no audio")
+	}
+
+	return r.Vocode(ctx, codes)
+}
+
+func (s *Server) SpeechHandler(c *gin.Context) {
+	var req api.SpeechRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sentences := speechSentences(req.Input)
+	if len(sentences) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "input is required"})
+		return
+	}
+
+	var contentType string
+	switch req.ResponseFormat {
+	case "", "wav":
+		contentType = "audio/wav"
+	case "pcm":
+		contentType = "audio/pcm"
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid response_format %q", req.ResponseFormat)})
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c, req.Model, []Capability{CapabilitySpeech}, req.Options, req.KeepAlive, req.QueueTimeout)
+	if errors.Is(err, errCapabilitySpeech) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support speech", req.Model)})
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	if opts.NumPredict < 0 {
+		opts.NumPredict = maxSpeechTokens
+	}
+
+	for i, sentence := range sentences {
+		samples, err := speak(c.Request.Context(), r, m, opts, sentence)
+		if err != nil && i == 0 {
+			if errors.As(err, new(*llm.QueueTimeoutError)) {
+				handleScheduleError(c, req.Model, err)
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			// the response has started so the stream can only be cut short
+			slog.Error("speech synthesis failed", "error", err)
+			return
+		}
+
+		if i == 0 {
+			c.Header("Content-Type", contentType)
+			c.Status(http.StatusOK)
+			if contentType == "audio/wav" {
+				if _, err := c.Writer.Write(wavHeader(llm.SpeechSampleRate)); err != nil {
+					return
+				}
+			}
+		}
+
+		if err := writePCM16(c.Writer, samples); err != nil {
+			return
+		}
+
+		c.Writer.Flush()
+	}
+}
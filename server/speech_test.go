@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+type mockSpeechRunner struct {
+	mockRunner
+
+	codes [][]int
+}
+
+func (m *mockSpeechRunner) Vocode(_ context.Context, codes []int) ([]float32, error) {
+	m.codes = append(m.codes, codes)
+	return []float32{0, 0.5, -2}, nil
+}
+
+func TestSpeech(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mock := mockSpeechRunner{mockRunner: mockRunner{
+		CompletionResponse: llm.CompletionResponse{
+			Content:    "hello<|t_0.2|><|code_start|><|12|><|34|><|code_end|>",
+			Done:       true,
+			DoneReason: "stop",
+		},
+	}}
+
+	s := newMockRunnerServer(&mock)
+
+	base := createBinFile(t, llm.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+	}, nil)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model: "tts",
+		Modelfile: fmt.Sprintf("FROM %s\nFROM %s\nTEMPLATE \"<|text_start|>{{ .Prompt }}<|text_end|>\"", base, createBinFile(t, llm.KV{
+			"general.architecture": "wavtokenizer-dec",
+		}, nil)),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "llm",
+		Modelfile: fmt.Sprintf("FROM %s", base),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	t.Run("wav", func(t *testing.T) {
+		mock.codes = nil
+		w := createRequest(t, s.SpeechHandler, api.SpeechRequest{
+			Model: "tts",
+			Input: "Hello, world! How are you?",
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if ct := w.Header().Get("Content-Type"); ct != "audio/wav" {
+			t.Errorf("expected content type audio/wav, got %s", ct)
+		}
+
+		if !strings.Contains(mock.CompletionRequest.Prompt, "<|text_start|>how<|text_sep|>are<|text_sep|>you<|text_end|>") {
+			t.Errorf("unexpected prompt %q", mock.CompletionRequest.Prompt)
+		}
+
+		// one vocoder call per sentence
+		if len(mock.codes) != 2 || len(mock.codes[0]) != 2 || mock.codes[0][0] != 12 {
+			t.Errorf("unexpected audio codes %v", mock.codes)
+		}
+
+		body := w.Body.Bytes()
+		if len(body) != 44+2*2*3 {
+			t.Fatalf("expected a header and 6 samples, got %d bytes", len(body))
+		}
+
+		if !bytes.HasPrefix(body, []byte("RIFF")) || string(body[8:16]) != "WAVEfmt " {
+			t.Errorf("unexpected header %q", body[:16])
+		}
+
+		if rate := binary.LittleEndian.Uint32(body[24:28]); rate != llm.SpeechSampleRate {
+			t.Errorf("expected sample rate %d, got %d", llm.SpeechSampleRate, rate)
+		}
+
+		samples := make([]int16, 6)
+		if err := binary.Read(bytes.NewReader(body[44:]), binary.LittleEndian, samples); err != nil {
+			t.Fatal(err)
+		}
+
+		// samples are clipped to [-1, 1]
+		if samples[1] != 16383 || samples[2] != -32767 {
+			t.Errorf("unexpected samples %v", samples)
+		}
+	})
+
+	t.Run("pcm", func(t *testing.T) {
+		w := createRequest(t, s.SpeechHandler, api.SpeechRequest{
+			Model:          "tts",
+			Input:          "hello",
+			ResponseFormat: "pcm",
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if w.Body.Len() != 6 {
+			t.Errorf("expected 3 samples, got %d bytes", w.Body.Len())
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		w := createRequest(t, s.SpeechHandler, api.SpeechRequest{
+			Model:          "tts",
+			Input:          "hello",
+			ResponseFormat: "mp3",
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing input", func(t *testing.T) {
+		w := createRequest(t, s.SpeechHandler, api.SpeechRequest{Model: "tts", Input: "?!"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		w := createRequest(t, s.SpeechHandler, api.SpeechRequest{Model: "llm", Input: "hello"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if w.Body.String() != `{"error":"\"llm\" does not support speech"}` {
+			t.Errorf("unexpected body %s", w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// statsWindow is how far back the rolling stats of a model go
+const statsWindow = 15 * time.Minute
+
+// maxStatsSamples is the number of requests kept for the rolling stats of a
+// model, so busy models don't keep an unbounded number of them
+const maxStatsSamples = 10000
+
+// requestSample is a request served by a model
+type requestSample struct {
+	time    time.Time
+	latency time.Duration
+	failed  bool
+
+	promptTokens   int
+	promptDuration time.Duration
+	evalTokens     int
+	evalDuration   time.Duration
+}
+
+// completionSample is the sample of a completion which started at start and
+// ended with cr
+func completionSample(start time.Time, cr llm.CompletionResponse) requestSample {
+	return requestSample{
+		time:           time.Now(),
+		latency:        time.Since(start),
+		promptTokens:   cr.PromptEvalCount,
+		promptDuration: cr.PromptEvalDuration,
+		evalTokens:     cr.EvalCount,
+		evalDuration:   cr.EvalDuration,
+	}
+}
+
+// failedSample is the sample of a request which started at start and failed
+// with err, if the failure is the server's rather than the client going away
+func failedSample(start time.Time, err error) (requestSample, bool) {
+	if errors.Is(err, context.Canceled) {
+		return requestSample{}, false
+	}
+
+	return requestSample{time: time.Now(), latency: time.Since(start), failed: true}, true
+}
+
+type modelStats struct {
+	samples []requestSample // within statsWindow, oldest first
+
+	requests int64 // since the server started
+	errors   int64
+}
+
+// statsRecorder keeps the stats of the requests served by each model
+type statsRecorder struct {
+	mu     sync.Mutex
+	models map[string]*modelStats
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{models: make(map[string]*modelStats)}
+}
+
+func (r *statsRecorder) record(model string, sample requestSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.models[model]
+	if !ok {
+		m = &modelStats{}
+		r.models[model] = m
+	}
+
+	m.requests++
+	if sample.failed {
+		m.errors++
+	}
+
+	m.samples = append(m.expire(sample.time), sample)
+	if len(m.samples) > maxStatsSamples {
+		m.samples = slices.Delete(m.samples, 0, len(m.samples)-maxStatsSamples)
+	}
+}
+
+// expire drops the samples older than statsWindow as of now
+func (m *modelStats) expire(now time.Time) []requestSample {
+	i, _ := slices.BinarySearchFunc(m.samples, now.Add(-statsWindow), func(s requestSample, t time.Time) int {
+		return s.time.Compare(t)
+	})
+	m.samples = slices.Delete(m.samples, 0, i)
+	return m.samples
+}
+
+// snapshot returns the stats of every model as of now, sorted by name
+func (r *statsRecorder) snapshot(now time.Time) []api.ModelStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	models := []api.ModelStats{}
+	for name, m := range r.models {
+		ms := api.ModelStats{
+			Model:         name,
+			TotalRequests: m.requests,
+			TotalErrors:   m.errors,
+		}
+
+		var latencies []time.Duration
+		var promptTokens, evalTokens int
+		var promptDuration, evalDuration time.Duration
+		for _, s := range m.expire(now) {
+			ms.Requests++
+			if s.failed {
+				ms.Errors++
+				continue
+			}
+
+			latencies = append(latencies, s.latency)
+			promptTokens += s.promptTokens
+			promptDuration += s.promptDuration
+			evalTokens += s.evalTokens
+			evalDuration += s.evalDuration
+		}
+
+		if ms.Requests > 0 {
+			ms.ErrorRate = float64(ms.Errors) / float64(ms.Requests)
+		}
+
+		if promptDuration > 0 {
+			ms.PromptTokensPerSecond = float64(promptTokens) / promptDuration.Seconds()
+		}
+
+		if evalDuration > 0 {
+			ms.TokensPerSecond = float64(evalTokens) / evalDuration.Seconds()
+		}
+
+		slices.Sort(latencies)
+		ms.LatencyP50 = percentile(latencies, 50)
+		ms.LatencyP95 = percentile(latencies, 95)
+		models = append(models, ms)
+	}
+
+	slices.SortFunc(models, func(a, b api.ModelStats) int {
+		return cmp.Compare(a.Model, b.Model)
+	})
+
+	return models
+}
+
+// percentile returns the nearest rank p percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// StatsHandler returns the throughput, latency and error rate of the requests
+// each model served recently
+func (s *Server) StatsHandler(c *gin.Context) {
+	models := []api.ModelStats{}
+	if s.stats != nil {
+		models = s.stats.snapshot(time.Now())
+	}
+
+	c.JSON(http.StatusOK, api.StatsResponse{
+		Window: statsWindow,
+		Models: models,
+	})
+}
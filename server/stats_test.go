@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestStatsSnapshot(t *testing.T) {
+	r := newStatsRecorder()
+	now := time.Now()
+
+	// expired by the time of the snapshot
+	r.record("llama3:latest", requestSample{time: now.Add(-2 * statsWindow), latency: time.Hour, failed: true})
+
+	for i := range 20 {
+		r.record("llama3:latest", requestSample{
+			time:           now.Add(-time.Minute),
+			latency:        time.Duration(i+1) * time.Second,
+			promptTokens:   100,
+			promptDuration: 100 * time.Millisecond,
+			evalTokens:     50,
+			evalDuration:   time.Second,
+		})
+	}
+	r.record("llama3:latest", requestSample{time: now, latency: time.Minute, failed: true})
+	r.record("all-minilm:latest", requestSample{time: now, latency: time.Second, promptTokens: 10})
+
+	models := r.snapshot(now)
+	require.Len(t, models, 2)
+	assert.Equal(t, api.ModelStats{
+		Model:         "all-minilm:latest",
+		Requests:      1,
+		LatencyP50:    time.Second,
+		LatencyP95:    time.Second,
+		TotalRequests: 1,
+	}, models[0])
+
+	m := models[1]
+	assert.Equal(t, "llama3:latest", m.Model)
+	assert.Equal(t, 21, m.Requests)
+	assert.Equal(t, 1, m.Errors)
+	assert.InDelta(t, 1.0/21, m.ErrorRate, 1e-9)
+	assert.InDelta(t, 1000, m.PromptTokensPerSecond, 1e-9)
+	assert.InDelta(t, 50, m.TokensPerSecond, 1e-9)
+	assert.Equal(t, 10*time.Second, m.LatencyP50)
+	assert.Equal(t, 19*time.Second, m.LatencyP95)
+	assert.Equal(t, int64(22), m.TotalRequests)
+	assert.Equal(t, int64(2), m.TotalErrors)
+
+	// later snapshots only have the requests in the window
+	models = r.snapshot(now.Add(statsWindow))
+	assert.Equal(t, 1, models[1].Requests)
+	assert.Equal(t, int64(22), models[1].TotalRequests)
+}
+
+func TestStatsMaxSamples(t *testing.T) {
+	r := newStatsRecorder()
+	now := time.Now()
+	for range maxStatsSamples + 10 {
+		r.record("llama3:latest", requestSample{time: now})
+	}
+
+	models := r.snapshot(now)
+	assert.Equal(t, maxStatsSamples, models[0].Requests)
+	assert.Equal(t, int64(maxStatsSamples+10), models[0].TotalRequests)
+}
+
+func TestPercentile(t *testing.T) {
+	assert.Zero(t, percentile(nil, 50))
+
+	sorted := []time.Duration{1, 2, 3, 4}
+	assert.Equal(t, time.Duration(2), percentile(sorted, 50))
+	assert.Equal(t, time.Duration(4), percentile(sorted, 95))
+	assert.Equal(t, time.Duration(1), percentile(sorted, 0))
+}
+
+func TestFailedSample(t *testing.T) {
+	_, ok := failedSample(time.Now(), context.Canceled)
+	assert.False(t, ok, "clients going away aren't errors")
+
+	sample, ok := failedSample(time.Now(), errors.New("llama runner process has terminated"))
+	assert.True(t, ok)
+	assert.True(t, sample.failed)
+}
+
+func TestStatsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := Server{stats: newStatsRecorder()}
+	s.stats.record("llama3:latest", requestSample{time: time.Now(), latency: time.Second})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+	s.StatsHandler(c)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp api.StatsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, statsWindow, resp.Window)
+	require.Len(t, resp.Models, 1)
+	assert.Equal(t, "llama3:latest", resp.Models[0].Model)
+	assert.Equal(t, 1, resp.Models[0].Requests)
+}
+
+func TestStatsLoadFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			getGpuFn:      gpu.GetGPUInfo,
+			getCpuFn:      gpu.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int) {
+				req.errCh <- errors.New("llama runner process has terminated")
+			},
+		},
+		stats: newStatsRecorder(),
+	}
+	go s.sched.Run(context.TODO())
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "embed",
+		Modelfile: fmt.Sprintf("FROM %s", createEmbedBinFile(t)),
+		Stream:    &stream,
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "embed", Input: "hello"})
+	require.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+
+	// models which fail to load count against their error rate
+	models := s.stats.snapshot(time.Now())
+	require.Len(t, models, 1)
+	assert.Equal(t, "embed:latest", models[0].Model)
+	assert.Equal(t, 1, models[0].Errors)
+	assert.InDelta(t, 1.0, models[0].ErrorRate, 1e-9)
+}
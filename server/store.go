@@ -0,0 +1,284 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/store"
+)
+
+// openStores are the object stores opened by objectStore, by URL, so each is
+// only opened once
+var openStores struct {
+	mu sync.Mutex
+	m  map[string]store.Store
+}
+
+// objectStore returns the object store set with OLLAMA_STORE, or nil if
+// models are only stored on local disk. With an object store the models
+// directory is a cache: manifests and blobs are uploaded to the store when
+// they're written and downloaded from it when they're missing locally.
+func objectStore() (store.Store, error) {
+	rawurl := envconfig.Store()
+	if rawurl == "" {
+		return nil, nil
+	}
+
+	openStores.mu.Lock()
+	defer openStores.mu.Unlock()
+	if s, ok := openStores.m[rawurl]; ok {
+		return s, nil
+	}
+
+	s, err := store.Open(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if openStores.m == nil {
+		openStores.m = make(map[string]store.Store)
+	}
+
+	openStores.m[rawurl] = s
+	return s, nil
+}
+
+func manifestKey(rel string) string {
+	return "manifests/" + filepath.ToSlash(rel)
+}
+
+func blobKey(digest string) string {
+	return "blobs/" + strings.ReplaceAll(digest, ":", "-")
+}
+
+// storeManifest uploads the manifest at rel, relative to the manifests
+// directory, after the layers the object store doesn't have yet
+func storeManifest(rel string) error {
+	s, err := objectStore()
+	if err != nil || s == nil {
+		return err
+	}
+
+	p, err := findModelsFile(filepath.Join("manifests", rel))
+	if err != nil {
+		return err
+	}
+
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(bts, &m); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		if _, err := s.Stat(ctx, blobKey(layer.Digest)); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if err := storeBlob(ctx, s, layer.Digest); err != nil {
+			return err
+		}
+	}
+
+	return s.Put(ctx, manifestKey(rel), bytes.NewReader(bts), int64(len(bts)))
+}
+
+func storeBlob(ctx context.Context, s store.Store, digest string) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("uploading blob to store", "digest", digest, "size", fi.Size())
+	return s.Put(ctx, blobKey(digest), f, fi.Size())
+}
+
+// fetchManifest downloads the manifest at rel, relative to the manifests
+// directory, from the object store unless it's already cached
+func fetchManifest(rel string) error {
+	p, err := findModelsFile(filepath.Join("manifests", rel))
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+
+	s, err := objectStore()
+	if err != nil || s == nil {
+		return err
+	}
+
+	r, err := s.Get(context.Background(), manifestKey(rel))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	// the temporary file is kept out of the manifest tree so it's never
+	// mistaken for a tag
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(manifests, p, r, "")
+}
+
+// fetchBlob downloads a blob from the object store unless it's already
+// cached
+func fetchBlob(digest string) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+
+	s, err := objectStore()
+	if err != nil || s == nil {
+		return err
+	}
+
+	r, err := s.Get(context.Background(), blobKey(digest))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	slog.Info("downloading blob from store", "digest", digest)
+	return writeFileAtomic(filepath.Dir(p), p, r, digest)
+}
+
+// fetchBlobs downloads the layers of m which aren't cached
+func fetchBlobs(m *Manifest) error {
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		if err := fetchBlob(layer.Digest); err != nil {
+			return fmt.Errorf("fetching %s: %w", layer.Digest, err)
+		}
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes r to p through a temporary file in dir, verifying
+// its sha256 digest if it's set, so readers never see partial files
+func writeFileAtomic(dir, p string, r io.Reader, digest string) error {
+	// temporary files have the prefix of partial downloads so blobs are
+	// pruned if the server exits before they're complete
+	temp, err := os.CreateTemp(dir, "sha256-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	sha256sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(temp, sha256sum), r); err != nil {
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); digest != "" && got != digest {
+		return fmt.Errorf("%w: want %s, got %s", errDigestMismatch, digest, got)
+	}
+
+	return os.Rename(temp.Name(), p)
+}
+
+// syncManifests downloads the manifests of the object store which aren't
+// cached, such as models pulled by other servers
+func syncManifests(ctx context.Context) error {
+	s, err := objectStore()
+	if err != nil || s == nil {
+		return err
+	}
+
+	keys, err := s.List(ctx, "manifests/")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		rel := strings.TrimPrefix(key, "manifests/")
+		if !fs.ValidPath(rel) || strings.Count(rel, "/") != 3 {
+			slog.Warn("bad manifest in store", "key", key)
+			continue
+		}
+
+		if err := fetchManifest(filepath.FromSlash(rel)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unstoreManifest removes the manifest at rel, relative to the manifests
+// directory, from the object store
+func unstoreManifest(ctx context.Context, rel string) error {
+	s, err := objectStore()
+	if err != nil || s == nil {
+		return err
+	}
+
+	return s.Delete(ctx, manifestKey(rel))
+}
+
+// unstoreBlob removes a blob from the object store
+func unstoreBlob(ctx context.Context, digest string) error {
+	s, err := objectStore()
+	if err != nil || s == nil {
+		return err
+	}
+
+	return s.Delete(ctx, blobKey(digest))
+}
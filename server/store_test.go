@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestObjectStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := t.TempDir()
+	t.Setenv("OLLAMA_STORE", "file://"+store)
+
+	// one server creates a model
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s\nTEMPLATE {{ .Prompt }}", createBinFile(t, nil, nil)),
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(store, "manifests", "*", "*", "*", "*"), []string{
+		filepath.Join(store, "manifests", "registry.ollama.ai", "library", "test", "latest"),
+	})
+
+	blobs, err := filepath.Glob(filepath.Join(store, "blobs", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blobs) != 3 {
+		t.Fatalf("expected 3 blobs in the store, got %v", blobs)
+	}
+
+	// another server with an empty models directory lists and loads it
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	w = createRequest(t, s.ListModelsHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ListResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Models) != 1 || resp.Models[0].Name != "test:latest" {
+		t.Fatalf("expected the model test:latest, got %v", resp.Models)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Template.String() != "{{ .Prompt }}" {
+		t.Errorf("unexpected template %q", m.Template.String())
+	}
+
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+		filepath.Join(p, "blobs", filepath.Base(blobs[0])),
+		filepath.Join(p, "blobs", filepath.Base(blobs[1])),
+		filepath.Join(p, "blobs", filepath.Base(blobs[2])),
+	})
+
+	// deleting it removes it from the store too
+	w = createRequest(t, s.DeleteModelHandler, api.DeleteRequest{Name: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(store, "manifests", "*", "*", "*", "*"), []string{})
+	checkFileExists(t, filepath.Join(store, "blobs", "*"), []string{})
+}
+
+func TestRemoveLayerStaleManifests(t *testing.T) {
+	store := t.TempDir()
+	t.Setenv("OLLAMA_STORE", "file://"+store)
+
+	models := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", models)
+
+	digest := "sha256:" + strings.Repeat("a", 64)
+	blob := filepath.Join(store, "blobs", "sha256-"+strings.Repeat("a", 64))
+	for _, p := range []string{blob, filepath.Join(store, "manifests", "example.com", "library", "other", "latest")} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the other server's manifest can't be synced, as a file is in the way
+	if err := os.MkdirAll(filepath.Join(models, "manifests"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(models, "manifests", "example.com"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := Layer{Digest: digest}
+	if err := l.Remove(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if _, err := os.Stat(blob); err != nil {
+		t.Fatalf("expected the blob to be kept in the store, got %v", err)
+	}
+}
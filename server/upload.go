@@ -20,6 +20,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 )
 
@@ -42,14 +43,34 @@ type blobUpload struct {
 	done       bool
 	err        error
 	references atomic.Int32
+
+	// conns limits the parts uploaded at once. It's shared by the blobs
+	// of a push so a push uses at most cap(conns) connections.
+	conns chan struct{}
 }
 
 const (
-	numUploadParts          = 64
-	minUploadPartSize int64 = 100 * format.MegaByte
-	maxUploadPartSize int64 = 1000 * format.MegaByte
+	defaultUploadConcurrency       = 64
+	maxUploadConcurrency           = 64
+	minUploadPartSize        int64 = 100 * format.MegaByte
+	maxUploadPartSize        int64 = 1000 * format.MegaByte
 )
 
+// uploadConcurrency returns the number of connections a push uploads with:
+// n if it's set, otherwise OLLAMA_UPLOAD_CONCURRENCY, up to
+// maxUploadConcurrency
+func uploadConcurrency(n int) int {
+	if n <= 0 {
+		n = int(envconfig.UploadConcurrency())
+	}
+
+	if n <= 0 {
+		n = defaultUploadConcurrency
+	}
+
+	return min(n, maxUploadConcurrency)
+}
+
 func (b *blobUpload) Prepare(ctx context.Context, requestURL *url.URL, opts *registryOptions) error {
 	p, err := GetBlobsPath(b.Digest)
 	if err != nil {
@@ -89,7 +110,9 @@ func (b *blobUpload) Prepare(ctx context.Context, requestURL *url.URL, opts *reg
 		return nil
 	}
 
-	size := b.Total / numUploadParts
+	// split the blob so every connection has a part, but keep parts
+	// large enough that requests aren't mostly overhead
+	size := b.Total / int64(cap(b.conns))
 	switch {
 	case size < minUploadPartSize:
 		size = minUploadPartSize
@@ -110,7 +133,8 @@ func (b *blobUpload) Prepare(ctx context.Context, requestURL *url.URL, opts *reg
 
 	slog.Info(fmt.Sprintf("uploading %s in %d %s part(s)", b.Digest[7:19], len(b.Parts), format.HumanBytes(b.Parts[0].Size)))
 
-	requestURL, err = url.Parse(location)
+	// registries may return a location relative to the request
+	requestURL, err = requestURL.Parse(location)
 	if err != nil {
 		return err
 	}
@@ -121,7 +145,7 @@ func (b *blobUpload) Prepare(ctx context.Context, requestURL *url.URL, opts *reg
 }
 
 // Run uploads blob parts to the upstream. If the upstream supports redirection, parts will be uploaded
-// in parallel as defined by Prepare. Otherwise, parts will be uploaded serially. Run sets b.err on error.
+// in parallel, up to cap(b.conns) at once. Otherwise, parts will be uploaded serially. Run sets b.err on error.
 func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 	defer blobUploadManager.Delete(b.Digest)
 	ctx, b.CancelFunc = context.WithCancel(ctx)
@@ -140,13 +164,20 @@ func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 	defer b.file.Close()
 
 	g, inner := errgroup.WithContext(ctx)
-	g.SetLimit(numUploadParts)
+	g.SetLimit(cap(b.conns))
 	for i := range b.Parts {
 		part := &b.Parts[i]
 		select {
 		case <-inner.Done():
 		case requestURL := <-b.nextURL:
 			g.Go(func() error {
+				select {
+				case b.conns <- struct{}{}:
+				case <-inner.Done():
+					return inner.Err()
+				}
+				defer func() { <-b.conns }()
+
 				var err error
 				for try := range maxRetries {
 					err = b.uploadPart(inner, http.MethodPatch, requestURL, part, opts)
@@ -238,7 +269,7 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 		location = resp.Header.Get("Location")
 	}
 
-	nextURL, err := url.Parse(location)
+	nextURL, err := requestURL.Parse(location)
 	if err != nil {
 		w.Rollback()
 		return err
@@ -276,13 +307,10 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 
 	case resp.StatusCode == http.StatusUnauthorized:
 		w.Rollback()
-		challenge := parseRegistryChallenge(resp.Header.Get("www-authenticate"))
-		token, err := getAuthorizationToken(ctx, challenge)
-		if err != nil {
+		if _, err := authorize(ctx, requestURL, resp.Header.Get("www-authenticate"), opts); err != nil {
 			return err
 		}
 
-		opts.Token = token
 		fallthrough
 	case resp.StatusCode >= http.StatusBadRequest:
 		w.Rollback()
@@ -362,7 +390,9 @@ func (p *progressWriter) Rollback() {
 	p.written = 0
 }
 
-func uploadBlob(ctx context.Context, mp ModelPath, layer Layer, opts *registryOptions, fn func(api.ProgressResponse)) error {
+// uploadBlob uploads a layer to the registry unless it's already there. conns
+// is shared by the uploads of a push to limit its connections.
+func uploadBlob(ctx context.Context, mp ModelPath, layer Layer, conns chan struct{}, opts *registryOptions, fn func(api.ProgressResponse)) error {
 	requestURL := mp.BaseURL()
 	requestURL = requestURL.JoinPath("v2", mp.GetNamespaceRepository(), "blobs", layer.Digest)
 
@@ -383,7 +413,11 @@ func uploadBlob(ctx context.Context, mp ModelPath, layer Layer, opts *registryOp
 		return nil
 	}
 
-	data, ok := blobUploadManager.LoadOrStore(layer.Digest, &blobUpload{Layer: layer})
+	if conns == nil {
+		conns = make(chan struct{}, uploadConcurrency(opts.Concurrency))
+	}
+
+	data, ok := blobUploadManager.LoadOrStore(layer.Digest, &blobUpload{Layer: layer, conns: conns})
 	upload := data.(*blobUpload)
 	if !ok {
 		requestURL := mp.BaseURL()
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestUploadConcurrency(t *testing.T) {
+	cases := []struct {
+		env    string
+		n      int
+		expect int
+	}{
+		{"", 0, 64},
+		{"", 4, 4},
+		{"2", 0, 2},
+		{"2", 8, 8},
+		{"1000", 0, maxUploadConcurrency},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprintf("%s/%d", tt.env, tt.n), func(t *testing.T) {
+			t.Setenv("OLLAMA_UPLOAD_CONCURRENCY", tt.env)
+			if actual := uploadConcurrency(tt.n); actual != tt.expect {
+				t.Errorf("expected %d, actual %d", tt.expect, actual)
+			}
+		})
+	}
+}
+
+func TestUploadBlobConnections(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var mu sync.Mutex
+	stored := make(map[string][]byte)
+
+	var uploads, active, peak atomic.Int32
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			http.NotFound(w, r)
+		case r.Method == http.MethodPost:
+			w.Header().Set("Location", "/v2/library/test/blobs/uploads/"+fmt.Sprint(uploads.Add(1)))
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPatch:
+			// parts are sent to storage the registry redirects to
+			w.Header().Set("Docker-Upload-Location", r.URL.Path)
+			w.Header().Set("Location", ts.URL+"/storage"+r.URL.Path)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/storage/"):
+			n := active.Add(1)
+			defer active.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+
+			b, _ := io.ReadAll(r.Body)
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			stored[strings.TrimPrefix(r.URL.Path, "/storage")] = b
+			mu.Unlock()
+		case r.Method == http.MethodPut:
+			mu.Lock()
+			defer mu.Unlock()
+			if fmt.Sprintf("sha256:%x", sha256.Sum256(stored[r.URL.Path])) != r.URL.Query().Get("digest") {
+				http.Error(w, "digest mismatch", http.StatusBadRequest)
+				return
+			}
+
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer ts.Close()
+
+	var layers []Layer
+	for i := range 4 {
+		b := bytes.Repeat([]byte{byte(i)}, 1024)
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+		p, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		layers = append(layers, Layer{Digest: digest, Size: int64(len(b))})
+	}
+
+	mp := ParseModelPath(strings.TrimPrefix(ts.URL, "http://") + "/library/test")
+	mp.ProtocolScheme = "http"
+
+	conns := make(chan struct{}, 2)
+	var g errgroup.Group
+	for _, layer := range layers {
+		g.Go(func() error {
+			return uploadBlob(context.Background(), mp, layer, conns, &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("expected at most 2 connections, actual %d", p)
+	}
+
+	if len(stored) != len(layers) {
+		t.Errorf("expected %d blobs to be uploaded, actual %d", len(layers), len(stored))
+	}
+}
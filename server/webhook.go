@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/version"
+)
+
+const (
+	webhookMaxAttempts  = 4
+	webhookTimeout      = 10 * time.Second
+	webhookMaxErrorSize = 4 << 10
+)
+
+// webhookRetryDelay is the delay before the first retry of a delivery,
+// which doubles with every attempt
+var webhookRetryDelay = time.Second
+
+// notify sends an event to /api/events subscribers and posts it to the
+// webhooks set with OLLAMA_WEBHOOKS in the background. Events are signed
+// with OLLAMA_WEBHOOK_SECRET if it's set.
+func notify(event, model string, data map[string]any) {
+	e := broadcast(event, model, data)
+
+	urls := envconfig.Webhooks()
+	if len(urls) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		slog.Warn("couldn't encode webhook event", "event", event, "error", err)
+		return
+	}
+
+	var signature string
+	if secret := envconfig.WebhookSecret(); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	for _, u := range urls {
+		go deliverWebhook(u, event, signature, body)
+	}
+}
+
+// deliverWebhook posts body to u, retrying failed deliveries with backoff
+func deliverWebhook(u, event, signature string, body []byte) {
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := postWebhook(u, event, signature, body)
+		if err == nil {
+			return
+		}
+
+		if attempt == webhookMaxAttempts {
+			slog.Warn("couldn't deliver webhook", "url", u, "event", event, "attempts", attempt, "error", err)
+			return
+		}
+
+		slog.Debug("retrying webhook", "url", u, "event", event, "attempt", attempt, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func postWebhook(u, event, signature string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ollama/"+version.Version)
+	req.Header.Set("X-Ollama-Event", event)
+	if signature != "" {
+		req.Header.Set("X-Ollama-Signature", signature)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	return nil
+}
+
+// failureWriter keeps the error of responses which fail
+type failureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *failureWriter) Write(b []byte) (int, error) {
+	if w.Status() >= http.StatusBadRequest && w.body.Len() < webhookMaxErrorSize {
+		w.body.Write(b[:min(len(b), webhookMaxErrorSize-w.body.Len())])
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *failureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// webhookMiddleware sends a request.failed event for API requests which
+// fail
+func webhookMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if len(envconfig.Webhooks()) == 0 || !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/v1/") {
+			c.Next()
+			return
+		}
+
+		w := &failureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		if status := w.Status(); status >= http.StatusBadRequest {
+			var resp struct {
+				Error any `json:"error"`
+			}
+
+			// OpenAI compatible endpoints have error objects
+			errMessage := strings.TrimSpace(w.body.String())
+			if err := json.Unmarshal(w.body.Bytes(), &resp); err == nil {
+				switch e := resp.Error.(type) {
+				case string:
+					errMessage = e
+				case map[string]any:
+					errMessage = fmt.Sprint(e["message"])
+				}
+			}
+
+			notify(eventRequestFailed, "", map[string]any{
+				"method":     c.Request.Method,
+				"path":       path,
+				"status":     status,
+				"error":      errMessage,
+				"request_id": requestID(c),
+			})
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookReceiver records the events posted to it, failing the first
+// deliveries it's sent
+func webhookReceiver(t *testing.T, failures int) (*httptest.Server, chan serverEvent) {
+	t.Helper()
+
+	events := make(chan serverEvent, 8)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if signature := r.Header.Get("X-Ollama-Signature"); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("unexpected signature %q", signature)
+		}
+
+		var event serverEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if r.Header.Get("X-Ollama-Event") != event.Type {
+			t.Errorf("unexpected event header %q", r.Header.Get("X-Ollama-Event"))
+		}
+
+		events <- event
+	}))
+	t.Cleanup(ts.Close)
+
+	t.Setenv("OLLAMA_WEBHOOKS", ts.URL)
+	t.Setenv("OLLAMA_WEBHOOK_SECRET", "secret")
+	return ts, events
+}
+
+func receiveEvent(t *testing.T, events chan serverEvent) serverEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was delivered")
+		return serverEvent{}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	delay := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = delay })
+
+	_, events := webhookReceiver(t, 2)
+
+	notify(eventPullCompleted, "llama3:latest", map[string]any{"size": 42})
+
+	event := receiveEvent(t, events)
+	if event.Type != eventPullCompleted || event.Model != "llama3:latest" || event.Data["size"] != float64(42) {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if time.Since(event.Time) > time.Minute {
+		t.Errorf("unexpected event time %s", event.Time)
+	}
+}
+
+func TestWebhookMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	_, events := webhookReceiver(t, 0)
+
+	var s Server
+	ts := httptest.NewServer(s.GenerateRoutes())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/show", "application/json", strings.NewReader(`{"model": "missing"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status code 404, actual %d", resp.StatusCode)
+	}
+
+	event := receiveEvent(t, events)
+	if event.Type != eventRequestFailed {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	if event.Data["path"] != "/api/show" || event.Data["status"] != float64(http.StatusNotFound) || !strings.Contains(event.Data["error"].(string), "not found") {
+		t.Errorf("unexpected event data %v", event.Data)
+	}
+
+	// successful requests aren't sent
+	resp, err = http.Get(ts.URL + "/api/tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
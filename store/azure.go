@@ -0,0 +1,307 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+const azureVersion = "2021-08-06"
+
+// azure stores keys in an Azure Blob Storage container of the account
+// AZURE_STORAGE_ACCOUNT. Requests are authorized with the account key
+// AZURE_STORAGE_KEY or the shared access signature AZURE_STORAGE_SAS_TOKEN.
+// AZURE_STORAGE_ENDPOINT overrides the account's endpoint, such as for
+// Azurite.
+type azure struct {
+	client *http.Client
+
+	endpoint  *url.URL
+	account   string
+	container string
+
+	key []byte
+	sas url.Values
+}
+
+func newAzure(container string) (*azure, error) {
+	a := azure{
+		client:    http.DefaultClient,
+		account:   envconfig.Var("AZURE_STORAGE_ACCOUNT"),
+		container: container,
+	}
+
+	if a.account == "" {
+		return nil, errors.New("AZURE_STORAGE_ACCOUNT is required for Azure Blob Storage")
+	}
+
+	endpoint := envconfig.Var("AZURE_STORAGE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", a.account)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	a.endpoint = u
+
+	if s := envconfig.Var("AZURE_STORAGE_KEY"); s != "" {
+		if a.key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY: %w", err)
+		}
+	}
+
+	if s := envconfig.Var("AZURE_STORAGE_SAS_TOKEN"); s != "" {
+		if a.sas, err = url.ParseQuery(strings.TrimPrefix(s, "?")); err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN: %w", err)
+		}
+	}
+
+	return &a, nil
+}
+
+func (a *azure) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := a.do(ctx, http.MethodGet, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (a *azure) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size <= partSize {
+		resp, err := a.do(ctx, http.MethodPut, key, nil, http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}, r, size)
+		if err != nil {
+			return err
+		}
+
+		return resp.Body.Close()
+	}
+
+	// large blobs are uploaded as blocks which are committed together
+	var blocks []string
+	for offset := int64(0); offset < size; offset += partSize {
+		n := min(partSize, size-offset)
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(blocks))))
+		resp, err := a.do(ctx, http.MethodPut, key, url.Values{"comp": {"block"}, "blockid": {id}}, nil, io.LimitReader(r, n), n)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		blocks = append(blocks, id)
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: blocks})
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.do(ctx, http.MethodPut, key, url.Values{"comp": {"blocklist"}}, nil, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (a *azure) Stat(ctx context.Context, key string) (int64, error) {
+	resp, err := a.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.ContentLength, nil
+}
+
+func (a *azure) Delete(ctx context.Context, key string) error {
+	resp, err := a.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (a *azure) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	var marker string
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := a.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Blobs struct {
+				Blob []struct {
+					Name string
+				}
+			}
+			NextMarker string
+		}
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range result.Blobs.Blob {
+			keys = append(keys, b.Name)
+		}
+
+		if result.NextMarker == "" {
+			return keys, nil
+		}
+
+		marker = result.NextMarker
+	}
+}
+
+// do sends an authorized request for key, or the container if key is empty,
+// and returns the response if it's successful
+func (a *azure) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := *a.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + a.container
+	if key != "" {
+		u.Path += "/" + key
+	}
+
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+
+	if a.key == nil {
+		for k, v := range a.sas {
+			q[k] = v
+		}
+	}
+
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureVersion)
+	if a.key != nil {
+		a.sign(req)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	case resp.StatusCode >= http.StatusBadRequest:
+		defer resp.Body.Close()
+		return nil, responseError(resp, key)
+	}
+
+	return resp, nil
+}
+
+// sign authorizes r with the account's shared key
+func (a *azure) sign(r *http.Request) {
+	var contentLength string
+	if r.ContentLength > 0 {
+		contentLength = strconv.FormatInt(r.ContentLength, 10)
+	}
+
+	var headers []string
+	for k := range r.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			headers = append(headers, k)
+		}
+	}
+	slices.Sort(headers)
+
+	var b strings.Builder
+	for _, v := range []string{
+		r.Method,
+		r.Header.Get("Content-Encoding"),
+		r.Header.Get("Content-Language"),
+		contentLength,
+		r.Header.Get("Content-MD5"),
+		r.Header.Get("Content-Type"),
+		"", // Date, superseded by x-ms-date
+		r.Header.Get("If-Modified-Since"),
+		r.Header.Get("If-Match"),
+		r.Header.Get("If-None-Match"),
+		r.Header.Get("If-Unmodified-Since"),
+		r.Header.Get("Range"),
+	} {
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+
+	for _, k := range headers {
+		fmt.Fprintf(&b, "%s:%s\n", k, strings.TrimSpace(r.Header.Get(k)))
+	}
+
+	fmt.Fprintf(&b, "/%s%s", a.account, r.URL.EscapedPath())
+
+	query := r.URL.Query()
+	params := make([]string, 0, len(query))
+	for k := range query {
+		params = append(params, k)
+	}
+	slices.Sort(params)
+
+	for _, k := range params {
+		values := slices.Clone(query[k])
+		slices.Sort(values)
+		fmt.Fprintf(&b, "\n%s:%s", strings.ToLower(k), strings.Join(values, ","))
+	}
+
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(b.String()))
+	r.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.account, base64.StdEncoding.EncodeToString(h.Sum(nil))))
+}
@@ -0,0 +1,363 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// s3 stores keys in an S3 bucket. Google Cloud Storage is accessed through
+// its S3 compatible API. Requests are signed with AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, or sent anonymously if they aren't set.
+type s3 struct {
+	client *http.Client
+
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	pathStyle bool
+
+	accessKey, secretKey, sessionToken string
+}
+
+func newS3(bucket, endpoint, region string) (*s3, error) {
+	s := s3{
+		client:       http.DefaultClient,
+		bucket:       bucket,
+		region:       region,
+		accessKey:    envconfig.Var("AWS_ACCESS_KEY_ID"),
+		secretKey:    envconfig.Var("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: envconfig.Var("AWS_SESSION_TOKEN"),
+	}
+
+	if endpoint == "" {
+		// virtual hosted-style requests are the only kind new buckets support
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", region)}
+		return &s, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	s.endpoint = u
+	s.pathStyle = true
+	return &s, nil
+}
+
+func (s *s3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size <= partSize {
+		resp, err := s.do(ctx, http.MethodPut, key, nil, r, size)
+		if err != nil {
+			return err
+		}
+
+		return resp.Body.Close()
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return err
+	}
+
+	if err := s.putParts(ctx, key, upload.UploadID, r, size); err != nil {
+		// abort the upload so its parts aren't stored, and billed, forever
+		if resp, err := s.do(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {upload.UploadID}}, nil, 0); err == nil {
+			resp.Body.Close()
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *s3) putParts(ctx context.Context, key, uploadID string, r io.Reader, size int64) error {
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+
+	var parts []part
+	for offset := int64(0); offset < size; offset += partSize {
+		n := min(partSize, size-offset)
+		number := len(parts) + 1
+		resp, err := s.do(ctx, http.MethodPut, key, url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}, io.LimitReader(r, n), n)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		parts = append(parts, part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// failures to complete an upload can be reported after a 200 OK
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(b, []byte("<Error>")) {
+		return fmt.Errorf("PUT %s: %s", key, b)
+	}
+
+	return nil
+}
+
+func (s *s3) Stat(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.ContentLength, nil
+}
+
+func (s *s3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (s *s3) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for key, or the bucket if key is empty, and
+// returns the response if it's successful
+func (s *s3) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.endpoint
+	p := "/" + key
+	if s.pathStyle {
+		p = strings.TrimRight(u.Path, "/") + "/" + s.bucket + p
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+
+	u.Path, u.RawPath = p, s3Escape(p, false)
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			// otherwise the body is sent chunked, which S3 rejects
+			req.Body = http.NoBody
+		}
+	}
+
+	s.sign(req, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	case resp.StatusCode >= http.StatusBadRequest:
+		defer resp.Body.Close()
+		return nil, responseError(resp, key)
+	}
+
+	return resp, nil
+}
+
+// sign signs r with AWS Signature Version 4. Payloads aren't hashed so they
+// can be streamed.
+func (s *s3) sign(r *http.Request, t time.Time) {
+	if s.accessKey == "" {
+		return
+	}
+
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if r.Body == nil {
+		// the hash of an empty payload
+		payloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	}
+
+	date := t.UTC().Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", date)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": r.URL.Host}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date[:8], s.region)
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, v := range []string{date[:8], s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		s.accessKey, scope, signedHeaders, hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes every byte of s except unreserved characters and,
+// unless escapeSlash is set, slashes
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// s3Query returns the canonical form of query, which is also sent
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
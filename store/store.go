@@ -0,0 +1,129 @@
+// Package store implements object storage backends for the model store so
+// several servers can share one copy of their models.
+package store
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// Store is a flat key-value store of blobs and manifests. Keys are slash
+// separated paths such as "blobs/sha256-<hex>". Missing keys return errors
+// matching [fs.ErrNotExist].
+type Store interface {
+	// Get returns the contents of key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Put writes size bytes read from r to key, replacing any existing value
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Stat returns the size of key
+	Stat(ctx context.Context, key string) (int64, error)
+
+	// Delete removes key. Deleting a missing key isn't an error.
+	Delete(ctx context.Context, key string) error
+
+	// List returns the keys starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// partSize is the size of the parts of large uploads
+var partSize int64 = 64 << 20
+
+// Open returns the store at rawurl, one of:
+//
+//   - s3://bucket/prefix: Amazon S3 or, with AWS_ENDPOINT_URL, an S3
+//     compatible service such as MinIO
+//   - gs://bucket/prefix: Google Cloud Storage with HMAC keys
+//   - az://container/prefix: Azure Blob Storage
+//   - file:///path: a directory, such as a network share
+func Open(rawurl string) (Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "file" {
+		return newDisk(u.Path)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("store %q is missing a bucket", rawurl)
+	}
+
+	var s Store
+	switch u.Scheme {
+	case "s3":
+		s, err = newS3(u.Host, envconfig.Var("AWS_ENDPOINT_URL"), cmp.Or(envconfig.Var("AWS_REGION"), envconfig.Var("AWS_DEFAULT_REGION"), "us-east-1"))
+	case "gs":
+		s, err = newS3(u.Host, "https://storage.googleapis.com", "auto")
+	case "az", "azure":
+		s, err = newAzure(u.Host)
+	default:
+		return nil, fmt.Errorf("unsupported store %q", rawurl)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix := strings.Trim(u.Path, "/"); prefix != "" {
+		return &prefixed{Store: s, prefix: prefix + "/"}, nil
+	}
+
+	return s, nil
+}
+
+// prefixed stores keys under a common prefix
+type prefixed struct {
+	Store
+	prefix string
+}
+
+func (p *prefixed) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return p.Store.Get(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return p.Store.Put(ctx, p.prefix+key, r, size)
+}
+
+func (p *prefixed) Stat(ctx context.Context, key string) (int64, error) {
+	return p.Store.Stat(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Delete(ctx context.Context, key string) error {
+	return p.Store.Delete(ctx, p.prefix+key)
+}
+
+func (p *prefixed) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := p.Store.List(ctx, p.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], p.prefix)
+	}
+
+	return keys, nil
+}
+
+// responseError returns the error of an unsuccessful response
+func responseError(resp *http.Response, key string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = resp.Status
+	}
+
+	return fmt.Errorf("%s %s: %s", resp.Request.Method, path.Clean("/"+key), message)
+}
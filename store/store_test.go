@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testStore puts, reads, lists and deletes keys of s
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+
+	ctx := context.Background()
+	values := map[string]string{
+		"blobs/sha256-a": "hello, world",
+		"blobs/sha256-b": strings.Repeat("0123456789", 10),
+		"manifests/registry.ollama.ai/library/x/xl": "{}",
+		"empty": "",
+	}
+
+	for k, v := range values {
+		if err := s.Put(ctx, k, strings.NewReader(v), int64(len(v))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for k, v := range values {
+		n, err := s.Stat(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != int64(len(v)) {
+			t.Errorf("expected %s to be %d bytes, got %d", k, len(v), n)
+		}
+
+		r, err := s.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, b)
+		}
+	}
+
+	keys, err := s.List(ctx, "blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"blobs/sha256-a", "blobs/sha256-b"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	if err := s.Delete(ctx, "blobs/sha256-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, "blobs/sha256-a"); err != nil {
+		t.Errorf("expected deleting a missing key to succeed, got %v", err)
+	}
+
+	if _, err := s.Stat(ctx, "blobs/sha256-a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	if _, err := s.Get(ctx, "blobs/sha256-a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestDisk(t *testing.T) {
+	s, err := Open("file://" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, s)
+
+	if err := s.Put(context.Background(), "../escape", strings.NewReader(""), 0); err == nil {
+		t.Error("expected an error for a key outside of the store")
+	}
+}
+
+func TestPrefixed(t *testing.T) {
+	d, err := newDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, &prefixed{Store: d, prefix: "cluster/"})
+
+	keys, err := d.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range keys {
+		if !strings.HasPrefix(k, "cluster/") {
+			t.Errorf("expected key %s to have the prefix", k)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_ACCOUNT", "")
+
+	cases := map[string]bool{
+		"s3://bucket":      true,
+		"s3://bucket/a/b/": true,
+		"gs://bucket":      true,
+		"s3:///prefix":     false,
+		"az://container":   false,
+		"ftp://host/path":  false,
+	}
+
+	for rawurl, ok := range cases {
+		t.Run(rawurl, func(t *testing.T) {
+			_, err := Open(rawurl)
+			if ok && err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if !ok && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,1170 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var errJinjaUnsupported = errors.New("unsupported jinja")
+
+var (
+	jinjaStatementPattern = regexp.MustCompile(`{%[-+]?\s*(if|for|set)\b`)
+	jinjaNamePattern      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	jinjaKeyPattern       = regexp.MustCompile(`^[a-z_]+$`)
+)
+
+// IsJinja reports whether s is a Jinja template rather than a template
+func IsJinja(s string) bool {
+	return jinjaStatementPattern.MatchString(s)
+}
+
+// FromJinja converts a Jinja chat template, like the chat_template of a
+// model's tokenizer_config.json, to a template. It supports the subset of
+// Jinja chat templates are written in: output, if, for and set statements,
+// and expressions of messages and their fields, tools, literals,
+// comparisons, concatenation, loop variables, namespaces and the trim,
+// length and tojson filters. Names in vars, like bos_token and eos_token,
+// are replaced with their values, and add_generation_prompt is always true.
+// Whitespace is controlled like transformers does, with trim_blocks and
+// lstrip_blocks.
+func FromJinja(s string, vars map[string]string) (string, error) {
+	tags, err := lexJinja(s)
+	if err != nil {
+		return "", err
+	}
+
+	c := jinjaCompiler{vars: vars, namespaces: make(map[string]bool), kinds: make(map[string]string)}
+	for _, tag := range tags {
+		if err := c.tag(tag); err != nil {
+			return "", err
+		}
+	}
+
+	if len(c.blocks) > 0 {
+		return "", fmt.Errorf("jinja: unclosed %s", c.blocks[len(c.blocks)-1])
+	}
+
+	// variables are declared first so they're in scope everywhere, like
+	// the variables of jinja's set outside of loops
+	var sb strings.Builder
+	for _, v := range c.declared {
+		fmt.Fprintf(&sb, "{{ $%s := %s }}", v.name, v.value)
+	}
+
+	sb.WriteString(c.b.String())
+
+	if _, err := Parse(sb.String()); err != nil {
+		return "", fmt.Errorf("jinja: %w", err)
+	}
+
+	return sb.String(), nil
+}
+
+type jinjaTag struct {
+	// kind is 0 for text, or the second character of the tag's delimiter:
+	// { for output, % for statements and # for comments
+	kind byte
+	text string
+
+	trimLeft, trimRight bool
+	keepLeft            bool
+}
+
+// lexJinja splits s into text and tags, and removes the whitespace the
+// tags control
+func lexJinja(s string) ([]jinjaTag, error) {
+	var tags []jinjaTag
+	for len(s) > 0 {
+		i := -1
+		for j := 0; j+1 < len(s); j++ {
+			if s[j] == '{' && strings.IndexByte("{%#", s[j+1]) >= 0 {
+				i = j
+				break
+			}
+		}
+
+		if i < 0 {
+			tags = append(tags, jinjaTag{text: s})
+			break
+		}
+
+		if i > 0 {
+			tags = append(tags, jinjaTag{text: s[:i]})
+		}
+
+		tag := jinjaTag{kind: s[i+1]}
+		s = s[i+2:]
+
+		if strings.HasPrefix(s, "-") {
+			tag.trimLeft, s = true, s[1:]
+		} else if strings.HasPrefix(s, "+") {
+			tag.keepLeft, s = true, s[1:]
+		}
+
+		end := map[byte]string{'{': "}}", '%': "%}", '#': "#}"}[tag.kind]
+		n, err := jinjaTagEnd(s, end, tag.kind == '#')
+		if err != nil {
+			return nil, err
+		}
+
+		tag.text = s[:n]
+		s = s[n+len(end):]
+
+		if strings.HasSuffix(tag.text, "-") {
+			tag.trimRight, tag.text = true, strings.TrimSuffix(tag.text, "-")
+		} else if strings.HasSuffix(tag.text, "+") && tag.kind != '{' {
+			tag.text = strings.TrimSuffix(tag.text, "+")
+		}
+
+		tag.text = strings.TrimSpace(tag.text)
+		tags = append(tags, tag)
+	}
+
+	for i, tag := range tags {
+		if tag.kind == 0 {
+			continue
+		}
+
+		var prev, next *jinjaTag
+		if i > 0 && tags[i-1].kind == 0 {
+			prev = &tags[i-1]
+		}
+
+		if i+1 < len(tags) && tags[i+1].kind == 0 {
+			next = &tags[i+1]
+		}
+
+		if tag.kind != '{' {
+			// lstrip_blocks removes the whitespace before blocks at the
+			// start of lines, and trim_blocks the newline after them
+			if prev != nil && !tag.keepLeft {
+				n := strings.LastIndexByte(prev.text, '\n')
+				if line := prev.text[n+1:]; strings.Trim(line, " \t") == "" && (n >= 0 || i == 1) {
+					prev.text = prev.text[:n+1]
+				}
+			}
+
+			if next != nil {
+				if t, ok := strings.CutPrefix(next.text, "\n"); ok {
+					next.text = t
+				} else if t, ok := strings.CutPrefix(next.text, "\r\n"); ok {
+					next.text = t
+				}
+			}
+		}
+
+		if prev != nil && tag.trimLeft {
+			prev.text = strings.TrimRight(prev.text, " \t\r\n")
+		}
+
+		if next != nil && tag.trimRight {
+			next.text = strings.TrimLeft(next.text, " \t\r\n")
+		}
+	}
+
+	return tags, nil
+}
+
+// jinjaTagEnd returns the index of end in s, outside of strings
+func jinjaTagEnd(s, end string, comment bool) (int, error) {
+	if comment {
+		if n := strings.Index(s, end); n >= 0 {
+			return n, nil
+		}
+
+		return 0, errors.New("jinja: unclosed comment")
+	}
+
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote != 0:
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case strings.HasPrefix(s[i:], end):
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("jinja: unclosed tag, expected %s", end)
+}
+
+const (
+	jinjaName = iota
+	jinjaString
+	jinjaNumber
+	jinjaOperator
+	jinjaEOF
+)
+
+type jinjaToken struct {
+	kind int
+	s    string
+}
+
+func lexJinjaExpression(s string) ([]jinjaToken, error) {
+	var tokens []jinjaToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+
+			tokens = append(tokens, jinjaToken{jinjaName, s[i:j]})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+
+			tokens = append(tokens, jinjaToken{jinjaNumber, s[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+					switch s[j] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					case 'r':
+						sb.WriteByte('\r')
+					default:
+						sb.WriteByte(s[j])
+					}
+
+					continue
+				}
+
+				sb.WriteByte(s[j])
+			}
+
+			if j >= len(s) {
+				return nil, fmt.Errorf("jinja: unterminated string in %q", s)
+			}
+
+			tokens = append(tokens, jinjaToken{jinjaString, sb.String()})
+			i = j + 1
+		default:
+			if i+1 < len(s) && slices.Contains([]string{"==", "!=", "<=", ">=", "//", "**"}, s[i:i+2]) {
+				tokens = append(tokens, jinjaToken{jinjaOperator, s[i : i+2]})
+				i += 2
+			} else if strings.IndexByte("+-*/%~<>()[]{}.,:|=", c) >= 0 {
+				tokens = append(tokens, jinjaToken{jinjaOperator, string(c)})
+				i++
+			} else {
+				return nil, fmt.Errorf("jinja: unexpected %q in %q", c, s)
+			}
+		}
+	}
+
+	return append(tokens, jinjaToken{kind: jinjaEOF}), nil
+}
+
+// jinjaNode is a node of a parsed Jinja expression
+type jinjaNode struct {
+	// op is the kind of node: literal, name, attr, index, slice, call,
+	// filter, test, not, neg, cond, or a binary operator
+	op    string
+	value jinjaToken
+	name  string
+	args  []*jinjaNode
+
+	// kwargs are the keyword arguments of calls
+	kwargs map[string]*jinjaNode
+	keys   []string
+}
+
+type jinjaParser struct {
+	tokens []jinjaToken
+	pos    int
+}
+
+func (p *jinjaParser) peek() jinjaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *jinjaParser) next() jinjaToken {
+	t := p.tokens[p.pos]
+	if t.kind != jinjaEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+// is reports whether the next token is one of the operators or keywords
+// in ss
+func (p *jinjaParser) is(ss ...string) bool {
+	t := p.peek()
+	return (t.kind == jinjaOperator || t.kind == jinjaName) && slices.Contains(ss, t.s)
+}
+
+func (p *jinjaParser) expect(s string) error {
+	if t := p.next(); t.s != s || t.kind == jinjaString {
+		return fmt.Errorf("jinja: expected %q, found %q", s, t.s)
+	}
+
+	return nil
+}
+
+func (p *jinjaParser) expression() (*jinjaNode, error) {
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.is("if") {
+		p.next()
+		cond, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		els := &jinjaNode{op: "literal", value: jinjaToken{jinjaString, ""}}
+		if p.is("else") {
+			p.next()
+			if els, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+
+		return &jinjaNode{op: "cond", args: []*jinjaNode{cond, n, els}}, nil
+	}
+
+	return n, nil
+}
+
+func (p *jinjaParser) binary(ops []string, operand func() (*jinjaNode, error)) (*jinjaNode, error) {
+	n, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.is(ops...) {
+		op := p.next().s
+		m, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		n = &jinjaNode{op: op, args: []*jinjaNode{n, m}}
+	}
+
+	return n, nil
+}
+
+func (p *jinjaParser) or() (*jinjaNode, error) {
+	return p.binary([]string{"or"}, p.and)
+}
+
+func (p *jinjaParser) and() (*jinjaNode, error) {
+	return p.binary([]string{"and"}, p.not)
+}
+
+func (p *jinjaParser) not() (*jinjaNode, error) {
+	if p.is("not") {
+		p.next()
+		n, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+
+		return &jinjaNode{op: "not", args: []*jinjaNode{n}}, nil
+	}
+
+	return p.compare()
+}
+
+func (p *jinjaParser) compare() (*jinjaNode, error) {
+	n, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.is("==", "!=", "<", ">", "<=", ">="):
+			op := p.next().s
+			m, err := p.concat()
+			if err != nil {
+				return nil, err
+			}
+
+			n = &jinjaNode{op: op, args: []*jinjaNode{n, m}}
+		case p.is("in"), p.is("not") && p.tokens[p.pos+1].s == "in":
+			negate := p.next().s == "not"
+			if negate {
+				p.next()
+			}
+
+			m, err := p.concat()
+			if err != nil {
+				return nil, err
+			}
+
+			n = &jinjaNode{op: "in", args: []*jinjaNode{n, m}}
+			if negate {
+				n = &jinjaNode{op: "not", args: []*jinjaNode{n}}
+			}
+		case p.is("is"):
+			p.next()
+			negate := p.is("not")
+			if negate {
+				p.next()
+			}
+
+			t := p.next()
+			if t.kind != jinjaName {
+				return nil, fmt.Errorf("jinja: expected a test, found %q", t.s)
+			}
+
+			n = &jinjaNode{op: "test", name: strings.ToLower(t.s), args: []*jinjaNode{n}}
+			if negate {
+				n = &jinjaNode{op: "not", args: []*jinjaNode{n}}
+			}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *jinjaParser) concat() (*jinjaNode, error) {
+	return p.binary([]string{"~"}, p.sum)
+}
+
+func (p *jinjaParser) sum() (*jinjaNode, error) {
+	return p.binary([]string{"+", "-"}, p.product)
+}
+
+func (p *jinjaParser) product() (*jinjaNode, error) {
+	return p.binary([]string{"*", "/", "//", "%"}, p.unary)
+}
+
+func (p *jinjaParser) unary() (*jinjaNode, error) {
+	if p.is("-") {
+		p.next()
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &jinjaNode{op: "neg", args: []*jinjaNode{n}}, nil
+	}
+
+	return p.postfix()
+}
+
+func (p *jinjaParser) postfix() (*jinjaNode, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.is("."):
+			p.next()
+			t := p.next()
+			if t.kind != jinjaName {
+				return nil, fmt.Errorf("jinja: expected a name after ., found %q", t.s)
+			}
+
+			n = &jinjaNode{op: "attr", name: t.s, args: []*jinjaNode{n}}
+		case p.is("["):
+			p.next()
+			var lo, hi *jinjaNode
+			if !p.is(":") {
+				if lo, err = p.expression(); err != nil {
+					return nil, err
+				}
+			}
+
+			if p.is(":") {
+				p.next()
+				if !p.is("]") {
+					if hi, err = p.expression(); err != nil {
+						return nil, err
+					}
+				}
+
+				n = &jinjaNode{op: "slice", args: []*jinjaNode{n, lo, hi}}
+			} else {
+				n = &jinjaNode{op: "index", args: []*jinjaNode{n, lo}}
+			}
+
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		case p.is("("):
+			call, err := p.call()
+			if err != nil {
+				return nil, err
+			}
+
+			call.args = append([]*jinjaNode{n}, call.args...)
+			n = call
+		case p.is("|"):
+			p.next()
+			t := p.next()
+			if t.kind != jinjaName {
+				return nil, fmt.Errorf("jinja: expected a filter, found %q", t.s)
+			}
+
+			filter := &jinjaNode{op: "filter", name: t.s}
+			if p.is("(") {
+				if filter, err = p.call(); err != nil {
+					return nil, err
+				}
+
+				filter.op, filter.name = "filter", t.s
+			}
+
+			filter.args = append([]*jinjaNode{n}, filter.args...)
+			n = filter
+		default:
+			return n, nil
+		}
+	}
+}
+
+// call parses the arguments of a call
+func (p *jinjaParser) call() (*jinjaNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	n := &jinjaNode{op: "call", kwargs: make(map[string]*jinjaNode)}
+	for !p.is(")") {
+		if t := p.peek(); t.kind == jinjaName && p.tokens[p.pos+1].s == "=" {
+			p.next()
+			p.next()
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+
+			n.kwargs[t.s] = arg
+			n.keys = append(n.keys, t.s)
+		} else {
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+
+			n.args = append(n.args, arg)
+		}
+
+		if !p.is(",") {
+			break
+		}
+
+		p.next()
+	}
+
+	return n, p.expect(")")
+}
+
+func (p *jinjaParser) primary() (*jinjaNode, error) {
+	t := p.next()
+	switch t.kind {
+	case jinjaString, jinjaNumber:
+		return &jinjaNode{op: "literal", value: t}, nil
+	case jinjaName:
+		switch t.s {
+		case "true", "True", "false", "False", "none", "None":
+			return &jinjaNode{op: "literal", value: jinjaToken{jinjaName, strings.ToLower(t.s)}}, nil
+		}
+
+		return &jinjaNode{op: "name", name: t.s}, nil
+	case jinjaOperator:
+		if t.s == "(" {
+			n, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+
+			return n, p.expect(")")
+		}
+	}
+
+	return nil, fmt.Errorf("jinja: unexpected %q", t.s)
+}
+
+func parseJinjaExpression(s string) (*jinjaNode, error) {
+	tokens, err := lexJinjaExpression(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := jinjaParser{tokens: tokens}
+	n, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != jinjaEOF {
+		return nil, fmt.Errorf("jinja: unexpected %q in %q", t.s, s)
+	}
+
+	return n, nil
+}
+
+// goExpr is a template expression compiled from a Jinja expression
+type goExpr struct {
+	s string
+
+	// compound expressions are function calls, which need parentheses to
+	// be arguments
+	compound bool
+
+	// kind is string, number or bool when the expression is known to be
+	// one, or undefined for Jinja's undefined
+	kind string
+}
+
+func (e goExpr) arg() string {
+	if e.compound {
+		return "(" + e.s + ")"
+	}
+
+	return e.s
+}
+
+var undefined = goExpr{s: `""`, kind: "undefined"}
+
+type jinjaLoop struct {
+	name, index string
+	items       goExpr
+}
+
+type jinjaVariable struct {
+	name, value string
+}
+
+type jinjaCompiler struct {
+	vars map[string]string
+	b    strings.Builder
+
+	blocks     []string
+	loops      []jinjaLoop
+	declared   []jinjaVariable
+	namespaces map[string]bool
+
+	// kinds are the kinds of values variables are set to, or "" for
+	// variables set to values of different kinds
+	kinds map[string]string
+}
+
+func (c *jinjaCompiler) tag(tag jinjaTag) error {
+	switch tag.kind {
+	case 0:
+		c.b.WriteString(strings.ReplaceAll(tag.text, "{{", `{{ "{{" }}`))
+	case '{':
+		n, err := parseJinjaExpression(tag.text)
+		if err != nil {
+			return err
+		}
+
+		return c.output(n)
+	case '%':
+		return c.statement(tag.text)
+	}
+
+	return nil
+}
+
+func (c *jinjaCompiler) output(n *jinjaNode) error {
+	if n.op == "cond" {
+		cond, err := c.expr(n.args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&c.b, "{{ if %s }}", cond.s)
+		if err := c.output(n.args[1]); err != nil {
+			return err
+		}
+
+		c.b.WriteString("{{ else }}")
+		if err := c.output(n.args[2]); err != nil {
+			return err
+		}
+
+		c.b.WriteString("{{ end }}")
+		return nil
+	}
+
+	e, err := c.expr(n)
+	if err != nil {
+		return err
+	}
+
+	if e.kind != "undefined" {
+		fmt.Fprintf(&c.b, "{{ %s }}", e.s)
+	}
+
+	return nil
+}
+
+func (c *jinjaCompiler) statement(s string) error {
+	n := strings.IndexFunc(s, func(r rune) bool {
+		return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if n < 0 {
+		n = len(s)
+	}
+
+	keyword, rest := s[:n], strings.TrimSpace(s[n:])
+
+	switch keyword {
+	case "if", "elif":
+		n, err := parseJinjaExpression(rest)
+		if err != nil {
+			return err
+		}
+
+		cond, err := c.expr(n)
+		if err != nil {
+			return err
+		}
+
+		if keyword == "if" {
+			c.blocks = append(c.blocks, "if")
+			fmt.Fprintf(&c.b, "{{ if %s }}", cond.s)
+		} else if len(c.blocks) == 0 || c.blocks[len(c.blocks)-1] != "if" {
+			return errors.New("jinja: elif outside of if")
+		} else {
+			fmt.Fprintf(&c.b, "{{ else if %s }}", cond.s)
+		}
+	case "else":
+		if len(c.blocks) == 0 {
+			return errors.New("jinja: else outside of if or for")
+		}
+
+		c.b.WriteString("{{ else }}")
+	case "endif", "endfor":
+		block := strings.TrimPrefix(keyword, "end")
+		if len(c.blocks) == 0 || c.blocks[len(c.blocks)-1] != block {
+			return fmt.Errorf("jinja: %s without %s", keyword, block)
+		}
+
+		c.blocks = c.blocks[:len(c.blocks)-1]
+		if block == "for" {
+			c.loops = c.loops[:len(c.loops)-1]
+		}
+
+		c.b.WriteString("{{ end }}")
+	case "for":
+		name, items, ok := strings.Cut(rest, " in ")
+		name = strings.TrimSpace(name)
+		if !ok || !jinjaNamePattern.MatchString(name) {
+			return fmt.Errorf("%w: for %s", errJinjaUnsupported, rest)
+		}
+
+		n, err := parseJinjaExpression(items)
+		if err != nil {
+			return err
+		}
+
+		e, err := c.expr(n)
+		if err != nil {
+			return err
+		}
+
+		index := "$i"
+		if len(c.loops) > 0 {
+			index = fmt.Sprintf("$i%d", len(c.loops))
+		}
+
+		c.blocks = append(c.blocks, "for")
+		c.loops = append(c.loops, jinjaLoop{name: name, index: index, items: e})
+		fmt.Fprintf(&c.b, "{{ range %s, $%s := %s }}", index, name, e.s)
+	case "set":
+		return c.set(rest)
+	case "generation", "endgeneration":
+		// these mark the assistant's messages for training
+	default:
+		return fmt.Errorf("%w: %s", errJinjaUnsupported, keyword)
+	}
+
+	return nil
+}
+
+func (c *jinjaCompiler) set(s string) error {
+	target, value, ok := strings.Cut(s, "=")
+	target = strings.TrimSpace(target)
+	if !ok {
+		return fmt.Errorf("%w: set %s", errJinjaUnsupported, s)
+	}
+
+	n, err := parseJinjaExpression(value)
+	if err != nil {
+		return err
+	}
+
+	if n.op == "call" && n.args[0].op == "name" && n.args[0].name == "namespace" {
+		c.namespaces[target] = true
+		for _, key := range n.keys {
+			if err := c.assign(target+"_"+key, n.kwargs[key]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if ns, attr, ok := strings.Cut(target, "."); ok {
+		if !c.namespaces[ns] {
+			return fmt.Errorf("jinja: %s isn't a namespace", ns)
+		}
+
+		return c.assign(ns+"_"+attr, n)
+	}
+
+	return c.assign(target, n)
+}
+
+func (c *jinjaCompiler) assign(name string, n *jinjaNode) error {
+	if n.op == "cond" {
+		cond, err := c.expr(n.args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&c.b, "{{ if %s }}", cond.s)
+		if err := c.assign(name, n.args[1]); err != nil {
+			return err
+		}
+
+		c.b.WriteString("{{ else }}")
+		if err := c.assign(name, n.args[2]); err != nil {
+			return err
+		}
+
+		c.b.WriteString("{{ end }}")
+		return nil
+	}
+
+	e, err := c.expr(n)
+	if err != nil {
+		return err
+	}
+
+	if !slices.ContainsFunc(c.declared, func(v jinjaVariable) bool { return v.name == name }) {
+		// a variable shadowing a name like messages starts as its value
+		initial, err := c.expr(&jinjaNode{op: "name", name: name})
+		if err != nil {
+			return err
+		}
+
+		c.declared = append(c.declared, jinjaVariable{name: name, value: initial.s})
+		c.kinds[name] = e.kind
+	} else if c.kinds[name] != e.kind {
+		c.kinds[name] = ""
+	}
+
+	fmt.Fprintf(&c.b, "{{ $%s = %s }}", name, e.s)
+	return nil
+}
+
+func (c *jinjaCompiler) loop(name string) *jinjaLoop {
+	for i := len(c.loops) - 1; i >= 0; i-- {
+		if c.loops[i].name == name {
+			return &c.loops[i]
+		}
+	}
+
+	return nil
+}
+
+func (c *jinjaCompiler) expr(n *jinjaNode) (goExpr, error) {
+	switch n.op {
+	case "literal":
+		switch n.value.kind {
+		case jinjaString:
+			return goExpr{s: strconv.Quote(n.value.s), kind: "string"}, nil
+		case jinjaNumber:
+			return goExpr{s: n.value.s, kind: "number"}, nil
+		}
+
+		switch n.value.s {
+		case "true", "false":
+			return goExpr{s: n.value.s, kind: "bool"}, nil
+		}
+
+		return undefined, nil
+	case "name":
+		return c.name(n.name), nil
+	case "attr":
+		return c.attr(n.args[0], n.name)
+	case "index":
+		if key := n.args[1]; key.op == "literal" && key.value.kind == jinjaString {
+			return c.attr(n.args[0], key.value.s)
+		}
+
+		x, err := c.expr(n.args[0])
+		if err != nil {
+			return goExpr{}, err
+		}
+
+		i, err := c.offset(x, n.args[1])
+		if err != nil {
+			return goExpr{}, err
+		}
+
+		return goExpr{s: fmt.Sprintf("index %s %s", x.arg(), i.arg()), compound: true}, nil
+	case "slice":
+		x, err := c.expr(n.args[0])
+		if err != nil {
+			return goExpr{}, err
+		}
+
+		lo := goExpr{s: "0"}
+		if n.args[1] != nil {
+			if lo, err = c.offset(x, n.args[1]); err != nil {
+				return goExpr{}, err
+			}
+		}
+
+		if n.args[2] == nil {
+			return goExpr{s: fmt.Sprintf("slice %s %s", x.arg(), lo.arg()), compound: true}, nil
+		}
+
+		hi, err := c.offset(x, n.args[2])
+		if err != nil {
+			return goExpr{}, err
+		}
+
+		return goExpr{s: fmt.Sprintf("slice %s %s %s", x.arg(), lo.arg(), hi.arg()), compound: true}, nil
+	case "call":
+		return c.call(n)
+	case "filter":
+		return c.filter(n)
+	case "test":
+		return c.test(n)
+	case "not":
+		x, err := c.expr(n.args[0])
+		if err != nil {
+			return goExpr{}, err
+		}
+
+		return goExpr{s: "not " + x.arg(), compound: true, kind: "bool"}, nil
+	case "neg":
+		x, err := c.expr(n.args[0])
+		if err != nil {
+			return goExpr{}, err
+		}
+
+		if x.kind == "number" && !x.compound {
+			return goExpr{s: "-" + x.s, kind: "number"}, nil
+		}
+
+		return goExpr{s: "sub 0 " + x.arg(), compound: true, kind: "number"}, nil
+	case "in":
+		// only checks for keys, like 'tool_calls' in message, are supported
+		key := n.args[0]
+		if key.op != "literal" || key.value.kind != jinjaString || !jinjaKeyPattern.MatchString(key.value.s) {
+			return goExpr{}, fmt.Errorf("%w: in", errJinjaUnsupported)
+		}
+
+		return c.attr(n.args[1], key.value.s)
+	case "cond":
+		return goExpr{}, fmt.Errorf("%w: conditional expressions outside of output and set", errJinjaUnsupported)
+	}
+
+	x, err := c.expr(n.args[0])
+	if err != nil {
+		return goExpr{}, err
+	}
+
+	y, err := c.expr(n.args[1])
+	if err != nil {
+		return goExpr{}, err
+	}
+
+	fn, kind := map[string]string{
+		"and": "and", "or": "or",
+		"==": "eq", "!=": "ne", "<": "lt", "<=": "le", ">": "gt", ">=": "ge",
+		"~": "print", "-": "sub", "%": "mod",
+	}[n.op], "bool"
+
+	switch n.op {
+	case "+":
+		fn, kind = "print", "string"
+		if x.kind == "number" && y.kind == "number" {
+			fn, kind = "add", "number"
+		}
+	case "~":
+		kind = "string"
+	case "-", "%":
+		kind = "number"
+	case "and", "or":
+		kind = ""
+	case "*", "/", "//":
+		return goExpr{}, fmt.Errorf("%w: %s", errJinjaUnsupported, n.op)
+	}
+
+	return goExpr{s: fmt.Sprintf("%s %s %s", fn, x.arg(), y.arg()), compound: true, kind: kind}, nil
+}
+
+// offset compiles an index of x, counting negative indexes from the end
+func (c *jinjaCompiler) offset(x goExpr, n *jinjaNode) (goExpr, error) {
+	if n.op == "neg" {
+		i, err := c.expr(n.args[0])
+		if err != nil {
+			return goExpr{}, err
+		}
+
+		return goExpr{s: fmt.Sprintf("sub (len %s) %s", x.arg(), i.arg()), compound: true, kind: "number"}, nil
+	}
+
+	return c.expr(n)
+}
+
+func (c *jinjaCompiler) name(name string) goExpr {
+	if c.loop(name) != nil {
+		return goExpr{s: "$" + name}
+	}
+
+	if slices.ContainsFunc(c.declared, func(v jinjaVariable) bool { return v.name == name }) {
+		return goExpr{s: "$" + name, kind: c.kinds[name]}
+	}
+
+	switch name {
+	case "messages":
+		return goExpr{s: "$.Messages"}
+	case "tools":
+		return goExpr{s: "$.Tools"}
+	case "add_generation_prompt":
+		return goExpr{s: "true", kind: "bool"}
+	}
+
+	if v, ok := c.vars[name]; ok {
+		return goExpr{s: strconv.Quote(v), kind: "string"}
+	}
+
+	return undefined
+}
+
+func (c *jinjaCompiler) attr(n *jinjaNode, attr string) (goExpr, error) {
+	if n.op == "name" && n.name == "loop" && len(c.loops) > 0 {
+		l := c.loops[len(c.loops)-1]
+		switch attr {
+		case "index0":
+			return goExpr{s: l.index, kind: "number"}, nil
+		case "index":
+			return goExpr{s: fmt.Sprintf("add %s 1", l.index), compound: true, kind: "number"}, nil
+		case "first":
+			return goExpr{s: fmt.Sprintf("eq %s 0", l.index), compound: true, kind: "bool"}, nil
+		case "last":
+			return goExpr{s: fmt.Sprintf("eq (add %s 1) (len %s)", l.index, l.items.arg()), compound: true, kind: "bool"}, nil
+		case "length":
+			return goExpr{s: "len " + l.items.arg(), compound: true, kind: "number"}, nil
+		}
+
+		return goExpr{}, fmt.Errorf("%w: loop.%s", errJinjaUnsupported, attr)
+	}
+
+	if n.op == "name" && c.namespaces[n.name] {
+		return c.name(n.name + "_" + attr), nil
+	}
+
+	x, err := c.expr(n)
+	if err != nil {
+		return goExpr{}, err
+	}
+
+	if x.kind == "undefined" {
+		return undefined, nil
+	}
+
+	// message['tool_calls'] is the ToolCalls field of a message
+	var field strings.Builder
+	for _, part := range strings.Split(attr, "_") {
+		if part != "" {
+			field.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+
+	return goExpr{s: x.arg() + "." + field.String()}, nil
+}
+
+func (c *jinjaCompiler) call(n *jinjaNode) (goExpr, error) {
+	fn := n.args[0]
+	switch {
+	case fn.op == "name" && fn.name == "raise_exception":
+		// templates check messages with raise_exception, which are
+		// rendered as well as they can be instead
+		return undefined, nil
+	case fn.op == "attr" && fn.name == "strip" && len(n.args) == 1:
+		x, err := c.expr(fn.args[0])
+		if err != nil {
+			return goExpr{}, err
+		}
+
+		return goExpr{s: "trim " + x.arg(), compound: true, kind: "string"}, nil
+	}
+
+	return goExpr{}, fmt.Errorf("%w: calls", errJinjaUnsupported)
+}
+
+func (c *jinjaCompiler) filter(n *jinjaNode) (goExpr, error) {
+	x, err := c.expr(n.args[0])
+	if err != nil {
+		return goExpr{}, err
+	}
+
+	switch n.name {
+	case "trim":
+		return goExpr{s: "trim " + x.arg(), compound: true, kind: "string"}, nil
+	case "length", "count":
+		return goExpr{s: "len " + x.arg(), compound: true, kind: "number"}, nil
+	case "tojson":
+		return goExpr{s: "json " + x.arg(), compound: true, kind: "string"}, nil
+	case "string":
+		return goExpr{s: "print " + x.arg(), compound: true, kind: "string"}, nil
+	}
+
+	return goExpr{}, fmt.Errorf("%w: filter %s", errJinjaUnsupported, n.name)
+}
+
+func (c *jinjaCompiler) test(n *jinjaNode) (goExpr, error) {
+	x, err := c.expr(n.args[0])
+	if err != nil {
+		return goExpr{}, err
+	}
+
+	switch n.name {
+	case "defined":
+		return goExpr{s: strconv.FormatBool(x.kind != "undefined"), kind: "bool"}, nil
+	case "undefined":
+		return goExpr{s: strconv.FormatBool(x.kind == "undefined"), kind: "bool"}, nil
+	case "none":
+		return goExpr{s: "not " + x.arg(), compound: true, kind: "bool"}, nil
+	case "string":
+		// message content is always a string, never a list of parts
+		return goExpr{s: "true", kind: "bool"}, nil
+	case "mapping", "sequence", "iterable", "number", "boolean":
+		return goExpr{s: "false", kind: "bool"}, nil
+	}
+
+	return goExpr{}, fmt.Errorf("%w: test %s", errJinjaUnsupported, n.name)
+}
@@ -0,0 +1,200 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestFromJinja(t *testing.T) {
+	tokens := map[string]string{"bos_token": "<s>", "eos_token": "</s>"}
+
+	cases := []struct {
+		name     string
+		jinja    string
+		messages []api.Message
+		expect   string
+	}{
+		{
+			name: "zephyr",
+			jinja: `{% for message in messages %}
+{% if message['role'] == 'user' %}
+{{ '<|user|>\n' + message['content'] + eos_token }}
+{% elif message['role'] == 'system' %}
+{{ '<|system|>\n' + message['content'] + eos_token }}
+{% elif message['role'] == 'assistant' %}
+{{ '<|assistant|>\n'  + message['content'] + eos_token }}
+{% endif %}
+{% if loop.last and add_generation_prompt %}
+{{ '<|assistant|>' }}
+{% endif %}
+{% endfor %}`,
+			messages: []api.Message{
+				{Role: "system", Content: "You are a test."},
+				{Role: "user", Content: "Hello"},
+			},
+			expect: "<|system|>\nYou are a test.</s>\n<|user|>\nHello</s>\n<|assistant|>\n",
+		},
+		{
+			name:  "llama3",
+			jinja: `{% set loop_messages = messages %}{% for message in loop_messages %}{% set content = '<|start_header_id|>' + message['role'] + '<|end_header_id|>\n\n'+ message['content'] | trim + '<|eot_id|>' %}{% if loop.index0 == 0 %}{% set content = bos_token + content %}{% endif %}{{ content }}{% endfor %}{% if add_generation_prompt %}{{ '<|start_header_id|>assistant<|end_header_id|>\n\n' }}{% endif %}`,
+			messages: []api.Message{
+				{Role: "user", Content: "Hello "},
+				{Role: "assistant", Content: "Hi"},
+				{Role: "user", Content: "Bye"},
+			},
+			expect: "<s><|start_header_id|>user<|end_header_id|>\n\nHello<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\nHi<|eot_id|><|start_header_id|>user<|end_header_id|>\n\nBye<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n",
+		},
+		{
+			name:  "mistral",
+			jinja: `{{ bos_token }}{% for message in messages %}{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}{% if message['role'] == 'user' %}{{ '[INST] ' + message['content'] + ' [/INST]' }}{% elif message['role'] == 'assistant' %}{{ message['content'] + eos_token}}{% else %}{{ raise_exception('Only user and assistant roles are supported!') }}{% endif %}{% endfor %}`,
+			messages: []api.Message{
+				{Role: "user", Content: "Hi"},
+				{Role: "assistant", Content: "Hello"},
+				{Role: "user", Content: "Bye"},
+			},
+			expect: "<s>[INST] Hi [/INST]Hello</s>[INST] Bye [/INST]",
+		},
+		{
+			name: "system and slices",
+			jinja: `{%- if messages[0]['role'] == 'system' %}
+    {%- set system = messages[0]['content'] %}
+    {%- set loop_messages = messages[1:] %}
+{%- else %}
+    {%- set system = 'Be brief.' %}
+    {%- set loop_messages = messages %}
+{%- endif %}
+System: {{ system }}
+{% for message in loop_messages %}
+{{- message.role }}: {{ message.content }}{{ '\n' }}
+{%- endfor %}
+{%- if messages[-1].role == 'user' %}assistant:{% endif %}`,
+			messages: []api.Message{
+				{Role: "system", Content: "You are a test."},
+				{Role: "user", Content: "Hello"},
+			},
+			expect: "System: You are a test.\nuser: Hello\nassistant:",
+		},
+		{
+			name: "namespace",
+			jinja: `{%- set ns = namespace(found=false, count=0) -%}
+{%- for message in messages -%}
+    {%- if message['role'] == 'system' -%}
+        {%- set ns.found = true -%}
+    {%- endif -%}
+    {%- set ns.count = ns.count + 1 -%}
+{%- endfor -%}
+{%- if not ns.found -%}
+{{- 'System: Be brief.\n' -}}
+{%- endif -%}
+{{ ns.count }} messages`,
+			messages: []api.Message{
+				{Role: "user", Content: "Hello"},
+				{Role: "assistant", Content: "Hi"},
+			},
+			expect: "System: Be brief.\n2 messages",
+		},
+		{
+			name: "tools",
+			jinja: `{%- if tools %}<|im_start|>system
+{{ tools | tojson }}<|im_end|>
+{% endif %}
+{%- for message in messages %}
+{%- if message.role == 'assistant' and 'tool_calls' in message %}
+<|im_start|>assistant
+{%- for tool_call in message.tool_calls %}
+{{ tool_call.function | tojson }}
+{%- endfor %}<|im_end|>
+{%- else %}
+<|im_start|>{{ message.role }}
+{{ message.content }}<|im_end|>
+{%- endif %}
+{%- endfor %}
+<|im_start|>assistant
+`,
+			messages: []api.Message{
+				{Role: "user", Content: "What's the weather?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}},
+			},
+			expect: "<|im_start|>user\nWhat's the weather?<|im_end|><|im_start|>assistant{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}}<|im_end|><|im_start|>assistant\n",
+		},
+		{
+			name:     "braces",
+			jinja:    `{% for message in messages %}{{ '{{' }}{{ message.content }}}}{% endfor %}`,
+			messages: []api.Message{{Role: "user", Content: "Hello"}},
+			expect:   "{{Hello}}",
+		},
+		{
+			name:     "conditional",
+			jinja:    `{% for message in messages %}{{ 'Q: ' if message.role == 'user' else 'A: ' }}{{ message.content }} {% endfor %}`,
+			messages: []api.Message{{Role: "user", Content: "Hello"}, {Role: "assistant", Content: "Hi"}},
+			expect:   "Q: Hello A: Hi ",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := FromJinja(tt.jinja, tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tmpl, err := Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, Values{Messages: tt.messages}); err != nil {
+				t.Fatalf("%s: %v", s, err)
+			}
+
+			if b.String() != tt.expect {
+				t.Errorf("%s\nexpected %q\nactual   %q", s, tt.expect, b.String())
+			}
+		})
+	}
+}
+
+func TestFromJinjaUnsupported(t *testing.T) {
+	cases := []string{
+		`{% macro greet(name) %}Hello {{ name }}{% endmacro %}`,
+		`{% for message in messages %}{{ message.content | upper }}{% endfor %}`,
+		`{% for key, value in messages %}{% endfor %}`,
+		`{% for message in messages %}{{ strftime_now('%Y') }}{% endfor %}`,
+	}
+
+	for _, tt := range cases {
+		if _, err := FromJinja(tt, nil); !errors.Is(err, errJinjaUnsupported) {
+			t.Errorf("%s: expected an unsupported error, actual %v", tt, err)
+		}
+	}
+
+	for _, tt := range []string{
+		`{% for message in messages %}`,
+		`{% if true %}{% endfor %}`,
+		`{{ 'unterminated }}`,
+	} {
+		if _, err := FromJinja(tt, nil); err == nil {
+			t.Errorf("%s: expected an error", tt)
+		}
+	}
+}
+
+func TestIsJinja(t *testing.T) {
+	cases := map[string]bool{
+		"{{ .Prompt }}": false,
+		"{% for message in messages %}{{ message.content }}{% endfor %}": true,
+		"{%- if messages %}":        true,
+		"100{% discount":            false,
+		"{{ if .System }}{{ end }}": false,
+	}
+
+	for s, expect := range cases {
+		if actual := IsJinja(s); actual != expect {
+			t.Errorf("%s: expected %t, actual %t", s, expect, actual)
+		}
+	}
+}
@@ -122,6 +122,11 @@ var funcs = template.FuncMap{
 		b, _ := json.Marshal(v)
 		return string(b)
 	},
+	// these are for templates converted from jinja
+	"trim": strings.TrimSpace,
+	"add":  func(a, b int) int { return a + b },
+	"sub":  func(a, b int) int { return a - b },
+	"mod":  func(a, b int) int { return a % b },
 }
 
 func Parse(s string) (*Template, error) {
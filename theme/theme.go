@@ -0,0 +1,115 @@
+// Package theme styles the output of the CLI with ANSI colors. Output is
+// only styled when it's enabled by [Setup], so it isn't when NO_COLOR is
+// set or it's written to something other than a terminal.
+package theme
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Color modes accepted by Setup
+const (
+	ColorAuto   = "auto"   // color terminals, unless NO_COLOR is set
+	ColorAlways = "always" // color whatever the output is written to
+	ColorNever  = "never"
+)
+
+// Style is the SGR parameters of a style, such as "1;31" for bold red. The
+// empty style leaves text unchanged.
+type Style string
+
+// Render returns text in the style, if styling is enabled
+func (s Style) Render(text string) string {
+	if !enabled || s == "" || text == "" {
+		return text
+	}
+
+	return "\x1b[" + string(s) + "m" + text + "\x1b[0m"
+}
+
+// Theme is the styles of the kinds of output of the CLI
+type Theme struct {
+	Name string
+
+	Error   Style
+	Warning Style
+	Accent  Style // spinners and other highlights
+	Header  Style // table headers
+	Muted   Style // placeholders and other hints
+}
+
+var (
+	// Default is readable on dark and light terminals with 256 colors
+	Default = Theme{
+		Name:    "default",
+		Error:   "31",
+		Warning: "33",
+		Accent:  "36",
+		Header:  "1",
+		Muted:   "38;5;245",
+	}
+
+	// HighContrast relies on bold and italic text rather than hues, and
+	// never dims text, so it's readable on any background
+	HighContrast = Theme{
+		Name:    "high-contrast",
+		Error:   "1;31",
+		Warning: "1",
+		Accent:  "1",
+		Header:  "1;4",
+		Muted:   "3",
+	}
+
+	themes = []Theme{Default, HighContrast}
+)
+
+var (
+	enabled bool
+	current = Default
+)
+
+// Setup enables styling for mode, one of the Color constants, and uses the
+// theme called name, or the default theme if name is empty. In the auto
+// mode, output is styled if stdout and stderr are terminals and neither
+// NO_COLOR is set nor TERM is dumb.
+func Setup(mode, name string) error {
+	switch mode {
+	case ColorAuto, "":
+		enabled = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" &&
+			term.IsTerminal(int(os.Stdout.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
+	case ColorAlways:
+		enabled = true
+	case ColorNever:
+		enabled = false
+	default:
+		return fmt.Errorf("invalid color mode %q, expected auto, always or never", mode)
+	}
+
+	if name == "" {
+		current = Default
+		return nil
+	}
+
+	for _, t := range themes {
+		if strings.EqualFold(t.Name, name) {
+			current = t
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown theme %q, expected default or high-contrast", name)
+}
+
+// Enabled reports whether output is styled
+func Enabled() bool {
+	return enabled
+}
+
+// Current returns the theme output is styled with
+func Current() Theme {
+	return current
+}
@@ -0,0 +1,30 @@
+package theme
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	t.Cleanup(func() { Setup(ColorNever, "") }) //nolint:errcheck
+
+	require.NoError(t, Setup(ColorAlways, ""))
+	assert.True(t, Enabled())
+	assert.Equal(t, "\x1b[31mError:\x1b[0m", Current().Error.Render("Error:"))
+
+	require.NoError(t, Setup(ColorAlways, "High-Contrast"))
+	assert.Equal(t, HighContrast.Name, Current().Name)
+	assert.Equal(t, "\x1b[3mhint\x1b[0m", Current().Muted.Render("hint"))
+
+	// tests don't write to terminals
+	require.NoError(t, Setup(ColorAuto, ""))
+	assert.False(t, Enabled())
+
+	require.NoError(t, Setup(ColorNever, ""))
+	assert.Equal(t, "Error:", Current().Error.Render("Error:"))
+
+	require.Error(t, Setup("sometimes", ""))
+	require.Error(t, Setup(ColorAuto, "solarized"))
+}